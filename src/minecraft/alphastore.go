@@ -0,0 +1,149 @@
+package world
+
+import "minecraft/error"
+
+import "fmt"
+import "io/ioutil"
+import "os"
+import "path"
+import "strings"
+
+// alphaStore is the ChunkStore of FormatAlpha: a file for each chunk, in
+// <dir>/<x mod 64>/<z mod 64>/c.<x>.<z>.dat, named in base 36, holding the
+// gzipped compound.
+type alphaStore struct {
+	dir string
+}
+
+func (s *alphaStore) compression() Compression { return CompressGzip }
+
+// posmod64 returns i modulo 64 in [0, 64), as the game names the directories
+// of chunks with negative coordinates.
+func posmod64(i int32) int32 {
+	return (i%64 + 64) % 64
+}
+
+// chunkPath returns the file of the chunk at (x, z) in the Alpha world in dir.
+func chunkPath(dir string, x int32, z int32) string {
+	var px, pz = posmod64(x), posmod64(z)
+	return path.Join(
+		dir,
+		int32ToBase36String(px),
+		int32ToBase36String(pz),
+		fmt.Sprint(
+			"c.",
+			int32ToBase36String(x),
+			".",
+			int32ToBase36String(z),
+			".dat"))
+}
+
+// parseChunkName extracts the chunk coordinates from a file name of the form
+// c.<x>.<z>.dat.
+func parseChunkName(name string) (x, z int32, ok bool) {
+	parts := strings.Split(name, ".", -1)
+	if len(parts) != 4 || parts[0] != "c" || parts[3] != "dat" {
+		return
+	}
+	if x, ok = base36StringToInt32(parts[1]); !ok {
+		return
+	}
+	z, ok = base36StringToInt32(parts[2])
+	return
+}
+
+// notFound wraps err, from a file of the chunk at (x, z), in a
+// ChunkNotFoundError if it is for a file that does not exist.
+func notFound(err os.Error, x, z int32) os.Error {
+	if isNotExist(err) {
+		return error.Wrap(ChunkNotFoundError{x, z}, err)
+	}
+	return err
+}
+
+func (s *alphaStore) Has(x, z int32) bool {
+	fi, err := os.Stat(chunkPath(s.dir, x, z))
+	return err == nil && fi.IsRegular()
+}
+
+func (s *alphaStore) Read(x, z int32) ([]byte, os.Error) {
+	raw, err := ioutil.ReadFile(chunkPath(s.dir, x, z))
+	if err != nil {
+		return nil, error.NewError("could not read file", notFound(err, x, z))
+	}
+	return append([]byte{byte(CompressGzip)}, raw...), nil
+}
+
+// Write writes the chunk's file under a temporary name and renames it into
+// place, as nbt.Save does, so a failed write never leaves a truncated chunk.
+// A payload compressed with zlib is recompressed.
+func (s *alphaStore) Write(x, z int32, payload []byte) (err os.Error) {
+	if payload, err = recompress(payload, CompressGzip); err != nil {
+		return err
+	}
+	file := chunkPath(s.dir, x, z)
+	if err = os.MkdirAll(path.Dir(file), 0755); err != nil {
+		return error.NewError("could not create directory for chunk", err)
+	}
+	tmp := file + ".tmp"
+	if err = ioutil.WriteFile(tmp, payload[1:], 0644); err == nil {
+		err = os.Rename(tmp, file)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return error.InFile(file).Error("could not save", err)
+	}
+	return nil
+}
+
+func (s *alphaStore) Delete(x, z int32) os.Error {
+	if err := os.Remove(chunkPath(s.dir, x, z)); err != nil {
+		return error.NewError("could not remove file", notFound(err, x, z))
+	}
+	return nil
+}
+
+func (s *alphaStore) List() (keys []XZ, err os.Error) {
+	outer, err := ioutil.ReadDir(s.dir)
+	if err != nil {
+		return nil, error.NewError("could not list world directory", err)
+	}
+	for _, xdir := range outer {
+		if !xdir.IsDirectory() {
+			continue
+		}
+		inner, err := ioutil.ReadDir(path.Join(s.dir, xdir.Name))
+		if err != nil {
+			return nil, error.NewError(fmt.Sprint("could not list chunk directory ", xdir.Name), err)
+		}
+		for _, zdir := range inner {
+			if !zdir.IsDirectory() {
+				continue
+			}
+			files, err := ioutil.ReadDir(path.Join(s.dir, xdir.Name, zdir.Name))
+			if err != nil {
+				return nil, error.NewError(fmt.Sprint("could not list chunk directory ", xdir.Name, "/", zdir.Name), err)
+			}
+			for _, fi := range files {
+				if x, z, ok := parseChunkName(fi.Name); ok && fi.IsRegular() {
+					keys = append(keys, MakeXZ(x, z))
+				}
+			}
+		}
+	}
+	return keys, nil
+}
+
+func (s *alphaStore) ModTime(x, z int32) (int64, os.Error) {
+	fi, err := os.Stat(chunkPath(s.dir, x, z))
+	if err != nil {
+		return 0, error.NewError("could not stat file", notFound(err, x, z))
+	}
+	return fi.Mtime_ns, nil
+}
+
+// readKey orders chunks by the paths of their files, and so by directory, for
+// LoadChunksParallel.
+func (s *alphaStore) readKey(x, z int32) string {
+	return chunkPath(s.dir, x, z)
+}
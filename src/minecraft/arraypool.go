@@ -0,0 +1,88 @@
+package world
+
+import "os"
+import "sync"
+
+// arrayPool keeps the allocations that decodeLevel reads chunks' arrays into,
+// once their chunks are released, for decoding other chunks into.
+type arrayPool struct {
+	mu   sync.Mutex
+	free [][]byte
+}
+
+// get returns an allocation of arraysSize bytes, from the pool if it holds one.
+// Its contents are whatever the last chunk to use it left.
+func (p *arrayPool) get() []byte {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if n := len(p.free); n > 0 {
+		arrays := p.free[n-1]
+		p.free = p.free[:n-1]
+		return arrays
+	}
+	return make([]byte, arraysSize)
+}
+
+// put returns arrays to the pool unless that would make it hold more than limit
+// bytes.
+func (p *arrayPool) put(arrays []byte, limit int64) {
+	if len(arrays) != arraysSize {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if int64(len(p.free)+1)*arraysSize <= limit {
+		p.free = append(p.free, arrays)
+	}
+}
+
+// size returns how many bytes the pool holds.
+func (p *arrayPool) size() int64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return int64(len(p.free)) * arraysSize
+}
+
+// release takes c's arrays from it, and its tile entities' references to it,
+// and returns them to the pool if World.ArrayPoolSize is set.  It is only for
+// the chunks streamChunk reads and is done with, which no one else has seen;
+// a chunk that was resident may still be held, and its arrays read, after it
+// is unloaded.
+func (world *World) release(c *Chunk) {
+	if world.ArrayPoolSize <= 0 {
+		return
+	}
+	arrays := c.alloc
+	l := &c.Level
+	l.Blocks, l.Data, l.SkyLight, l.HeightMap, l.BlockLight = nil, nil, nil, nil, nil
+	c.alloc = nil
+	// a furnace kept past the chunk would otherwise set blocks in its arrays
+	for _, te := range l.TileEntities {
+		te.tileEntityBase().chunk = nil
+	}
+	world.pool.put(arrays, world.ArrayPoolSize)
+}
+
+// UnloadChunk writes the resident chunk at (x, z) if it is dirty and then forgets
+// it; it does nothing if the chunk is not resident.  The chunk is left as it
+// is, for any goroutine still holding it, but changes to it are no longer
+// written.
+func (world *World) UnloadChunk(x, z int32) os.Error {
+	xz := MakeXZ(x, z)
+	c, ok := world.resident(xz)
+	if !ok {
+		return nil
+	}
+	c.Lock()
+	defer c.Unlock()
+	if c.dirty {
+		if err := world.VerifyLockNow(); err != nil {
+			return err
+		}
+		if err := world.saveChunk(c); err != nil {
+			return err
+		}
+	}
+	world.drop(xz)
+	return nil
+}
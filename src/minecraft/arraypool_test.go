@@ -0,0 +1,136 @@
+package world
+
+import "os"
+import "runtime"
+import "testing"
+
+func TestUnloadChunk(t *testing.T) {
+	dir := makeTestWorld(t, testChunkPayload(1, 0, nil, nil), testChunkPayload(2, 0, nil, nil))
+	defer os.RemoveAll(dir)
+	w, err := Open(dir, CacheSize(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	w.ArrayPoolSize = 2 * arraysSize
+
+	a, err := w.GetChunk(1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	blocks := &a.Level.Blocks[0]
+	a.SetBlock(3, 64, 3, BlockStone, 0)
+	if err = w.UnloadChunk(1, 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := w.Chunks[MakeXZ(1, 0)]; ok {
+		t.Error("expected the chunk unloaded")
+	}
+	// a goroutine still holding the chunk may read its arrays
+	if a.Level.Blocks == nil || &a.Level.Blocks[0] != blocks || w.pool.size() != 0 {
+		t.Error("expected the unloaded chunk to keep its arrays, and nothing pooled")
+	}
+	if id, _, err := a.BlockAt(3, 64, 3); err != nil || id != BlockStone {
+		t.Errorf("expected stone in the unloaded chunk, got %d (%v)", id, err)
+	}
+	if err = w.UnloadChunk(5, 5); err != nil {
+		t.Error("expected nothing to unload, got ", err)
+	}
+
+	// nor are the arrays of the chunks UnloadExcessChunks unloads pooled
+	for _, x := range []int32{1, 2} {
+		if _, err = w.GetChunk(x, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	b := w.Chunks[MakeXZ(1, 0)]
+	if id, _, _ := b.BlockAt(3, 64, 3); id != BlockStone {
+		t.Errorf("expected stone written before unloading, got %d", id)
+	}
+	if n, err := w.UnloadExcessChunks(); err != nil || n != 1 {
+		t.Fatalf("expected 1 chunk unloaded, got %d (%v)", n, err)
+	}
+	if b.Level.Blocks == nil || w.pool.size() != 0 {
+		t.Error("expected the unloaded chunk to keep its arrays, and nothing pooled")
+	}
+}
+
+func TestStreamChunksPool(t *testing.T) {
+	dir := makeTestWorld(t, testChunkPayload(0, 0, nil, nil), testChunkPayload(1, 0, nil, nil), testChunkPayload(2, 0, nil, nil))
+	defer os.RemoveAll(dir)
+	w, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	resident, err := w.GetChunk(0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.ArrayPoolSize = 3 * arraysSize
+
+	if _, err = w.RemoveEntities(func(e *Entity) bool { return true }, nil); err != nil {
+		t.Fatal(err)
+	}
+	// the second chunk streamed is decoded into the arrays of the first
+	if n := w.pool.size(); n != arraysSize {
+		t.Errorf("expected one chunk's arrays pooled, got %d bytes", n)
+	}
+	if resident.Level.Blocks == nil {
+		t.Error("expected the resident chunk's arrays kept")
+	}
+}
+
+// benchmarkStreamChunks scans the blocks of a world of 10,000 chunks, streaming
+// them through memory with the given pool size, and logs the collections and
+// allocation of a scan.
+func benchmarkStreamChunks(b *testing.B, poolSize int64) {
+	b.StopTimer()
+	dir, err := writeTestWorld()
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for x := int32(0); x < 100; x++ {
+		for z := int32(0); z < 100; z++ {
+			if err = writeTestChunk(dir, testChunkPayload(x, z, nil, nil)); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+	w, err := Open(dir)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer w.Close()
+	w.ArrayPoolSize = poolSize
+	runtime.UpdateMemStats()
+	bytes, collections := runtime.MemStats.TotalAlloc, runtime.MemStats.NumGC
+	b.StartTimer()
+
+	for i := 0; i < b.N; i++ {
+		n := 0
+		err = w.streamChunks("benchmark", nil, func(c *Chunk) (bool, os.Error) {
+			for _, id := range c.Level.Blocks {
+				if id != BlockAir {
+					n++
+				}
+			}
+			return false, nil
+		})
+		if err != nil {
+			b.Fatal(err)
+		}
+		if n != 0 {
+			b.Fatal("expected only air, got ", n)
+		}
+	}
+	b.StopTimer()
+	runtime.UpdateMemStats()
+	bytes = runtime.MemStats.TotalAlloc - bytes
+	collections = runtime.MemStats.NumGC - collections
+	b.Logf("%d KiB and %d collections a scan", bytes/1024/uint64(b.N), collections/uint32(b.N))
+}
+
+func BenchmarkStreamChunks(b *testing.B)       { benchmarkStreamChunks(b, 0) }
+func BenchmarkStreamChunksPooled(b *testing.B) { benchmarkStreamChunks(b, 16*arraysSize) }
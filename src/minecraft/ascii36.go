@@ -32,3 +32,30 @@ func int32ToBase36String(i int32) string {
 	}
 	return string(str[ix:])
 }
+
+func base36StringToInt32(s string) (i int32, ok bool) {
+	var neg bool
+	if len(s) > 0 && s[0] == '-' {
+		neg = true
+		s = s[1:]
+	}
+	if len(s) == 0 || len(s) > 6 {
+		return 0, false
+	}
+	for k := 0; k < len(s); k++ {
+		var digit byte
+		switch c := s[k]; {
+		case c >= '0' && c <= '9':
+			digit = c - '0'
+		case c >= 'a' && c <= 'z':
+			digit = c - 'a' + 10
+		default:
+			return 0, false
+		}
+		i = i*36 + int32(digit)
+	}
+	if neg {
+		i = -i
+	}
+	return i, true
+}
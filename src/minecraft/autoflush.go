@@ -0,0 +1,121 @@
+package world
+
+import "minecraft/error"
+
+import "os"
+import "time"
+
+// autoFlushPoll is how often, in nanoseconds, the goroutine StartAutoFlush
+// starts counts the dirty chunks when it has a limit on them.
+const autoFlushPoll = 1e8
+
+// autoFlush is the goroutine StartAutoFlush started.
+type autoFlush struct {
+	stop chan bool
+	done chan os.Error // the goroutine's last word, once it has stopped
+}
+
+// StartAutoFlush starts a goroutine that writes the dirty chunks and then
+// level.dat, as Flush does, every interval nanoseconds and, if maxDirty is
+// positive, whenever more than maxDirty resident chunks are dirty.  Either may
+// be zero, but not both.  StopAutoFlush, or Close, stops it.  Like Flush, it
+// unloads no chunks, whatever MaxResident.
+//
+// Errors from these flushes are passed to FlushError if it is set; otherwise
+// StopAutoFlush returns the first of them.  While the goroutine runs the
+// chunks are shared with it, so changes to them must hold their Lock, as
+// ForEachChunk and the World's methods that change chunks do, and changes to
+// Data the World's LockData.
+func (world *World) StartAutoFlush(interval int64, maxDirty int) os.Error {
+	if interval <= 0 && maxDirty <= 0 {
+		return error.NewError("auto-flush needs an interval or a limit on dirty chunks", nil)
+	}
+	if world.config.ReadOnly {
+		return errReadOnly
+	}
+	world.autoMu.Lock()
+	defer world.autoMu.Unlock()
+	if world.auto != nil {
+		return error.NewError("auto-flush is already running", nil)
+	}
+	world.auto = &autoFlush{make(chan bool), make(chan os.Error)}
+	go world.runAutoFlush(world.auto, interval, maxDirty)
+	return nil
+}
+
+// StopAutoFlush stops the goroutine StartAutoFlush started, once it has
+// flushed one last time, and returns the error of that flush or, if FlushError
+// is not set, of an earlier one.  It does nothing if auto-flush is not running.
+func (world *World) StopAutoFlush() os.Error {
+	world.autoMu.Lock()
+	defer world.autoMu.Unlock()
+	a := world.auto
+	if a == nil {
+		return nil
+	}
+	world.auto = nil
+	a.stop <- true
+	return <-a.done
+}
+
+// runAutoFlush is the goroutine of StartAutoFlush.
+func (world *World) runAutoFlush(a *autoFlush, interval int64, maxDirty int) {
+	poll := interval
+	if maxDirty > 0 && (poll <= 0 || poll > autoFlushPoll) {
+		poll = autoFlushPoll
+	}
+	ticker := time.NewTicker(poll)
+	defer ticker.Stop()
+	last := time.Nanoseconds()
+	var first os.Error // the first error not passed to FlushError
+	for {
+		select {
+		case <-a.stop:
+			err := world.flushAll()
+			if first == nil {
+				first = err
+			}
+			a.done <- first
+			return
+		case now := <-ticker.C:
+			if (interval <= 0 || now-last < interval) && (maxDirty <= 0 || world.dirtyChunks() <= maxDirty) {
+				continue
+			}
+			last = now
+			if err := world.flushAll(); err == nil {
+				continue
+			} else if world.FlushError != nil {
+				world.FlushError(err)
+			} else if first == nil {
+				first = err
+			}
+		}
+	}
+}
+
+// flushAll writes the dirty chunks and then level.dat, for Flush and for
+// auto-flush, which must not unload chunks other goroutines may be using.
+func (world *World) flushAll() os.Error {
+	if err := world.writeDirty(); err != nil {
+		return err
+	}
+	return world.SaveLevel()
+}
+
+// dirtyChunks counts the resident chunks that are dirty.
+func (world *World) dirtyChunks() (n int) {
+	for _, c := range world.residentChunks() {
+		c.RLock()
+		if c.dirty {
+			n++
+		}
+		c.RUnlock()
+	}
+	return
+}
+
+// LockData and UnlockData guard changes to Data while auto-flush, which writes
+// it to level.dat, is running.  SaveLevel holds the lock while it encodes Data,
+// so it must not be called by a goroutine holding it.
+func (world *World) LockData()   { world.dataMu.Lock() }
+func (world *World) UnlockData() { world.dataMu.Unlock() }
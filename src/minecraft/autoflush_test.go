@@ -0,0 +1,267 @@
+package world
+
+import "minecraft/nbt"
+
+import "os"
+import "path"
+import "testing"
+import "time"
+
+// blockOnDisk returns the block at (x, y, z) of chunk (0, 0) as written to disk.
+func blockOnDisk(w *World, x, y, z int32) byte {
+	_, chunkmap, err := nbt.Load(chunkPath(w.dir, 0, 0))
+	if err != nil {
+		return 0
+	}
+	return chunkmap["Level"].(map[string]interface{})["Blocks"].([]byte)[blockIndex(x, y, z)]
+}
+
+// eventually reports whether cond becomes true within five seconds.
+func eventually(cond func() bool) bool {
+	for deadline := time.Nanoseconds() + 5e9; time.Nanoseconds() < deadline; time.Sleep(1e7) {
+		if cond() {
+			return true
+		}
+	}
+	return cond()
+}
+
+func TestAutoFlush(t *testing.T) {
+	dir := makeTestWorld(t, testChunkPayload(0, 0, nil, nil), testChunkPayload(1, 0, nil, nil))
+	defer os.RemoveAll(dir)
+	w, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	if err = w.StartAutoFlush(0, 0); err == nil {
+		t.Error("expected an error with neither an interval nor a limit")
+	}
+	if err = w.StartAutoFlush(1e7, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err = w.StartAutoFlush(1e7, 0); err == nil {
+		t.Error("expected an error starting auto-flush twice")
+	}
+
+	c, err := w.GetChunk(0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Lock()
+	c.SetBlock(1, 64, 1, BlockStone, 0)
+	c.Unlock()
+	w.LockData()
+	w.Data.Time = 99999
+	w.UnlockData()
+	if !eventually(func() bool { return blockOnDisk(w, 1, 64, 1) == BlockStone }) {
+		t.Error("expected the chunk written while the world is open")
+	}
+	if !eventually(func() bool {
+		_, level, err := nbt.Load(path.Join(dir, leveldat))
+		return err == nil && level["Data"].(map[string]interface{})["Time"].(int64) == 99999
+	}) {
+		t.Error("expected level.dat written")
+	}
+	c.RLock()
+	if c.Dirty() {
+		t.Error("expected the chunk clean once written")
+	}
+	c.RUnlock()
+
+	// the last change is written on stopping, however long the interval
+	if err = w.StopAutoFlush(); err != nil {
+		t.Fatal(err)
+	}
+	if err = w.StartAutoFlush(1e12, 0); err != nil {
+		t.Fatal(err)
+	}
+	c.Lock()
+	c.SetBlock(1, 64, 1, BlockGlass, 0)
+	c.Unlock()
+	if err = w.StopAutoFlush(); err != nil {
+		t.Fatal(err)
+	}
+	if id := blockOnDisk(w, 1, 64, 1); id != BlockGlass {
+		t.Errorf("expected glass written by the last flush, got %d", id)
+	}
+	if err = w.StopAutoFlush(); err != nil {
+		t.Error("expected stopping twice to do nothing, got ", err)
+	}
+}
+
+func TestAutoFlushMaxDirty(t *testing.T) {
+	dir := makeTestWorld(t, testChunkPayload(0, 0, nil, nil), testChunkPayload(1, 0, nil, nil))
+	defer os.RemoveAll(dir)
+	w, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	if _, _, err = w.LoadChunksParallel([]XZ{MakeXZ(0, 0), MakeXZ(1, 0)}, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err = w.StartAutoFlush(0, 1); err != nil {
+		t.Fatal(err)
+	}
+	set := func(c *Chunk) os.Error {
+		return c.SetBlock(1, 64, 1, BlockStone, 0)
+	}
+
+	c := w.Chunks[MakeXZ(0, 0)]
+	c.Lock()
+	set(c)
+	c.Unlock()
+	time.Sleep(3 * autoFlushPoll)
+	if id := blockOnDisk(w, 1, 64, 1); id != BlockAir {
+		t.Errorf("expected one dirty chunk left unwritten, got %d", id)
+	}
+	if err = w.ForEachChunk(set); err != nil {
+		t.Fatal(err)
+	}
+	if !eventually(func() bool { return blockOnDisk(w, 1, 64, 1) == BlockStone }) {
+		t.Error("expected the chunks written once two were dirty")
+	}
+}
+
+func TestAutoFlushErrors(t *testing.T) {
+	short := testChunkPayload(0, 0, nil, nil)
+	short["Level"].(map[string]interface{})["Blocks"] = make([]byte, 10)
+	dir := makeTestWorld(t, short)
+	defer os.RemoveAll(dir)
+	w, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.LazyArrays = true
+	c, err := w.GetChunk(0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.dirty = true
+
+	errs := make(chan os.Error, 1)
+	w.FlushError = func(err os.Error) {
+		select {
+		case errs <- err:
+		default:
+		}
+	}
+	if err = w.StartAutoFlush(1e7, 0); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-errs:
+	case <-time.After(5e9):
+		t.Error("expected FlushError called")
+	}
+	if err = w.StopAutoFlush(); err == nil {
+		t.Error("expected the error of the last flush")
+	}
+
+	// without FlushError, the first error waits for StopAutoFlush, or Close
+	w.FlushError = nil
+	if err = w.StartAutoFlush(1e7, 0); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(5e7)
+	if err = w.Close(); err == nil {
+		t.Error("expected Close to return the error of auto-flush")
+	}
+}
+
+func TestAutoFlushUnloadsNothing(t *testing.T) {
+	dir := makeTestWorld(t, testChunkPayload(0, 0, nil, nil), testChunkPayload(1, 0, nil, nil))
+	defer os.RemoveAll(dir)
+	w, err := Open(dir, CacheSize(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	w.ArrayPoolSize = 2 * arraysSize
+	if _, _, err = w.LoadChunksParallel([]XZ{MakeXZ(0, 0), MakeXZ(1, 0)}, 0); err != nil {
+		t.Fatal(err)
+	}
+	c := w.Chunks[MakeXZ(0, 0)]
+	blocks := c.Level.Blocks
+	if err = w.StartAutoFlush(1e7, 0); err != nil {
+		t.Fatal(err)
+	}
+	c.Lock()
+	c.SetBlock(1, 64, 1, BlockStone, 0)
+	c.Unlock()
+	if err = w.StopAutoFlush(); err != nil {
+		t.Fatal(err)
+	}
+	// a renderer holding blocks must still find the chunk's own blocks there
+	if len(w.Chunks) != 2 || c.Level.Blocks == nil || &c.Level.Blocks[0] != &blocks[0] || blockOnDisk(w, 1, 64, 1) != BlockStone {
+		t.Errorf("expected both chunks left resident and written, got %d", len(w.Chunks))
+	}
+	if err = w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if len(w.Chunks) != 2 {
+		t.Errorf("expected Flush to unload nothing either, got %d chunks", len(w.Chunks))
+	}
+	if n, err := w.UnloadExcessChunks(); err != nil || n != 1 || len(w.Chunks) != 1 {
+		t.Errorf("expected UnloadExcessChunks to unload down to 1 chunk, got %d (%v)", len(w.Chunks), err)
+	}
+}
+
+// TestAutoFlushDuringEdits runs the World's edits while auto-flush writes the
+// chunks they change, for the race detector, and checks that the chunks on disk
+// end up as they are in memory.
+func TestAutoFlushDuringEdits(t *testing.T) {
+	var chunks []map[string]interface{}
+	for x := int32(0); x < 4; x++ {
+		for z := int32(0); z < 4; z++ {
+			chunks = append(chunks, testChunkPayload(x, z, nil, nil))
+		}
+	}
+	dir := makeTestWorld(t, chunks...)
+	defer os.RemoveAll(dir)
+	w, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	if err = w.StartAutoFlush(1e6, 1); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 50; i++ {
+		for x := 0; x < 64; x += 8 {
+			if err = w.AddEntity(NewItemDrop(float64(x)+0.5, 64, float64(i)+0.5, Item{Id: BlockDirt, Count: 1})); err != nil {
+				t.Fatal(err)
+			}
+		}
+		err = w.ForEachEntity(nil, func(cx, cz int32, e *Entity) EntityAction {
+			e.Item.Count++
+			return Modified
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, _, err = w.ConsolidateItems(NewRegion(0, 0, 3, 3), 2, 0); err != nil {
+			t.Fatal(err)
+		}
+		if _, err = w.RemoveEntities(func(e *Entity) bool { return e.Item.Count > 20 }, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = w.StopAutoFlush(); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range w.residentChunks() {
+		if c.Dirty() {
+			t.Errorf("expected chunk (%d, %d) clean after the last flush", c.Level.XPos, c.Level.ZPos)
+		}
+		level, err := w.readChunkLevel(c.Level.XPos, c.Level.ZPos)
+		if err != nil {
+			t.Fatal(err)
+		}
+		onDisk, _ := getList(level, "Entities")
+		if len(onDisk) != len(c.Level.Entities) {
+			t.Errorf("chunk (%d, %d): expected %d entities on disk, got %d", c.Level.XPos, c.Level.ZPos, len(c.Level.Entities), len(onDisk))
+		}
+	}
+}
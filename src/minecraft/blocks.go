@@ -0,0 +1,67 @@
+package world
+
+// see: http://www.minecraftwiki.net/wiki/Data_values
+const (
+	BlockAir         = 0
+	BlockStone       = 1
+	BlockGrass       = 2
+	BlockDirt        = 3
+	BlockCobblestone = 4
+	BlockBedrock     = 7
+	BlockWater       = 8
+	BlockStillWater  = 9
+	BlockLava        = 10
+	BlockStillLava   = 11
+	BlockSand        = 12
+	BlockGravel      = 13
+	BlockWood        = 17
+	BlockLeaves      = 18
+	BlockGlass       = 20
+	BlockWool        = 35
+	BlockTorch       = 50
+	BlockMobSpawner  = 52
+	BlockChest       = 54
+	BlockFurnace     = 61
+	BlockLitFurnace  = 62
+	BlockSignPost    = 63
+	BlockWallSign    = 68
+	BlockIce         = 79
+)
+
+// skyTransparent holds the blocks that let skylight through undiminished.  The
+// heightmap records, for each column, the level just above the highest block
+// that is not in this set.
+var skyTransparent [256]bool
+
+func init() {
+	for _, id := range []byte{
+		0,  // air
+		6,  // sapling
+		20, // glass
+		37, // yellow flower
+		38, // red rose
+		39, // brown mushroom
+		40, // red mushroom
+		50, // torch
+		51, // fire
+		55, // redstone wire
+		59, // crops
+		63, // sign post
+		64, // wooden door
+		65, // ladder
+		66, // rails
+		68, // wall sign
+		69, // lever
+		70, // stone pressure plate
+		71, // iron door
+		72, // wooden pressure plate
+		75, // redstone torch (off)
+		76, // redstone torch (on)
+		77, // stone button
+		78, // snow
+		83, // reeds
+		90, // portal
+	} {
+		skyTransparent[id] = true
+	}
+}
@@ -0,0 +1,95 @@
+package world
+
+import "image"
+import "io"
+import "os"
+
+var (
+	// rockColor is the color of columns with no caves.
+	rockColor = image.NRGBAColor{0x00, 0x00, 0x00, 0xff}
+	// caveLavaColor and caveWaterColor mark the columns with lava or water
+	// underground.
+	caveLavaColor  = image.NRGBAColor{0xff, 0x50, 0x00, 0xff}
+	caveWaterColor = image.NRGBAColor{0x30, 0x60, 0xff, 0xff}
+)
+
+// caveFullAir is how many blocks of air a column needs for the brightest color.
+const caveFullAir = 16
+
+// RenderCaveMap writes a PNG map of the caves under region (nil meaning every
+// chunk in the world), laid out as by RenderMap.  Each column is colored by the
+// underground air between opts.MinY and opts.MaxY, that is the air below its
+// ground, which is its highest block that is not air, water, leaves or a block
+// that lets skylight through, so that the open air under trees and over lakes
+// does not count.  Columns with no underground air are black; the more air they
+// have, the brighter they are, tinted from cyan when the air lies at the bottom
+// of the range to yellow when it lies at the top.  Columns with lava underground
+// are orange and, failing that, those with water are blue.  Columns of missing
+// chunks, or with no ground, are transparent.  Chunks are read a row at a time as
+// the PNG is written and are not kept.
+func (world *World) RenderCaveMap(w io.Writer, region *Region, opts RenderOptions) os.Error {
+	minY, maxY, err := opts.yRange()
+	if err != nil {
+		return err
+	}
+	return world.renderColumns("RenderCaveMap", w, region, opts.Scale, image.NRGBAColorModel, func(c *Chunk, x, z int32) image.Color {
+		if c == nil {
+			return image.NRGBAColor{}
+		}
+		ground := groundLevel(c, x, z)
+		if ground < 0 {
+			return image.NRGBAColor{}
+		}
+		air, ySum := 0, 0
+		lava, water := false, false
+		base := blockIndex(x, 0, z)
+		for y := min32(ground-1, maxY); y >= minY; y-- {
+			switch c.Level.Blocks[base+int(y)] {
+			case BlockAir:
+				air++
+				ySum += int(y - minY)
+			case BlockLava, BlockStillLava:
+				lava = true
+			case BlockWater, BlockStillWater:
+				water = true
+			}
+		}
+		switch {
+		case lava:
+			return caveLavaColor
+		case water:
+			return caveWaterColor
+		case air == 0:
+			return rockColor
+		}
+		return caveColor(air, ySum, int(maxY-minY))
+	})
+}
+
+// caveColor returns the color of a column with air blocks of underground air,
+// whose heights above the bottom of the range, which spans span blocks, add up
+// to ySum.
+func caveColor(air, ySum, span int) image.NRGBAColor {
+	bright := 0x40 + 0xbf*min(air, caveFullAir)/caveFullAir
+	high := 0 // how high the air lies, from 0 to 0xff
+	if span > 0 {
+		high = 0xff * ySum / (air * span)
+	}
+	return image.NRGBAColor{uint8(bright * high / 0xff), uint8(bright), uint8(bright * (0xff - high) / 0xff), 0xff}
+}
+
+// groundLevel returns the height of the ground of column (x, z) of c, or -1 if
+// it has none.
+func groundLevel(c *Chunk, x, z int32) int32 {
+	base := blockIndex(x, 0, z)
+	for y := int32(ChunkHeight - 1); y >= 0; y-- {
+		switch id := c.Level.Blocks[base+int(y)]; id {
+		case BlockWater, BlockStillWater, BlockLeaves:
+		default:
+			if !skyTransparent[id] {
+				return y
+			}
+		}
+	}
+	return -1
+}
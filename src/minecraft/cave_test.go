@@ -0,0 +1,76 @@
+package world
+
+import "bytes"
+import "image"
+import "testing"
+
+// caveWorld returns chunk (0, 0) filled with stone up to y=63, through which run
+// a tunnel three blocks high at y=20 to 22 along z=4 and a single block of air at
+// (3, 60, 8), with lava at (5, 10, 12) and water at (6, 30, 12).  Leaves hang
+// over columns (0, 0) and (1, 0) at y=70, and column (15, 15) is empty.
+func caveWorld() *World {
+	w := &World{Chunks: make(map[XZ]*Chunk)}
+	c := newChunk(0, 0)
+	for x := int32(0); x < 16; x++ {
+		for z := int32(0); z < 16; z++ {
+			for y := int32(0); y < 64; y++ {
+				c.SetBlock(x, y, z, BlockStone, 0)
+			}
+		}
+		for y := int32(20); y < 23; y++ {
+			c.SetBlock(x, y, 4, BlockAir, 0)
+		}
+	}
+	c.SetBlock(3, 60, 8, BlockAir, 0)
+	c.SetBlock(5, 10, 12, BlockStillLava, 0)
+	c.SetBlock(6, 30, 12, BlockStillWater, 0)
+	c.SetBlock(0, 70, 0, BlockLeaves, 0)
+	c.SetBlock(1, 70, 0, BlockLeaves, 0)
+	for y := int32(0); y < 64; y++ {
+		c.SetBlock(15, y, 15, BlockAir, 0)
+	}
+	w.Chunks[MakeXZ(0, 0)] = c
+	return w
+}
+
+func TestRenderCaveMap(t *testing.T) {
+	w := caveWorld()
+	buf := new(bytes.Buffer)
+	if err := w.RenderCaveMap(buf, NewRegion(0, 0, 0, 0), RenderOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	m := decodePNG(t, buf)
+	tunnel, pocket := caveColor(3, 20+21+22, 127), caveColor(1, 60, 127)
+	for _, p := range []struct {
+		px, py int
+		want   image.Color
+	}{
+		{7, 4, tunnel},
+		{3, 8, pocket},
+		{5, 12, caveLavaColor},
+		{6, 12, caveWaterColor},
+		{7, 7, rockColor},
+		{0, 0, rockColor}, // the air under the leaves is not underground
+		{15, 15, image.NRGBAColor{}},
+	} {
+		if got := m.At(p.px, p.py); !sameColor(got, p.want) {
+			t.Errorf("pixel (%d, %d): expected %v, got %v", p.px, p.py, p.want, got)
+		}
+	}
+	if tunnel.G <= pocket.G || tunnel.B <= pocket.B || tunnel.R >= pocket.R {
+		t.Errorf("expected the tunnel brighter and deeper than the pocket, got %v and %v", tunnel, pocket)
+	}
+
+	// diamond depth
+	buf.Reset()
+	if err := w.RenderCaveMap(buf, NewRegion(0, 0, 0, 0), RenderOptions{MinY: 5, MaxY: 16}); err != nil {
+		t.Fatal(err)
+	}
+	m = decodePNG(t, buf)
+	if got := m.At(7, 4); !sameColor(got, rockColor) {
+		t.Error("expected the tunnel out of range, got ", got)
+	}
+	if got := m.At(5, 12); !sameColor(got, caveLavaColor) {
+		t.Error("expected the lava in range, got ", got)
+	}
+}
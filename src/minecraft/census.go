@@ -0,0 +1,163 @@
+package world
+
+import "os"
+import "sort"
+
+// CensusOptions control what EntityCensus and BusiestChunks count.
+type CensusOptions struct {
+	// IgnoreVehicles counts only the entities chunks hold, and not the vehicles
+	// they ride.
+	IgnoreVehicles bool
+}
+
+// EntityCensus counts the entities in region (nil meaning the whole world) by
+// id.  Vehicles being ridden count too, unless opts.IgnoreVehicles is set.
+// Chunks that are not resident are streamed from disk.
+func (world *World) EntityCensus(region *Region, opts CensusOptions) (counts map[string]int, err os.Error) {
+	counts = make(map[string]int)
+	err = world.scanEntities(region, func(xz ChunkCoord, entities []*Entity) bool {
+		for _, e := range entities {
+			counts[e.Id]++
+			for v := e.Riding; v != nil && !opts.IgnoreVehicles; v = v.Riding {
+				counts[v.Id]++
+			}
+		}
+		return true
+	})
+	if err != nil {
+		counts = nil
+	}
+	return
+}
+
+// ChunkCount is the number of entities in one chunk.
+type ChunkCount struct {
+	ChunkCoord
+	Count int
+}
+
+type chunkCountSlice []ChunkCount
+
+func (p chunkCountSlice) Len() int      { return len(p) }
+func (p chunkCountSlice) Swap(i, j int) { p[i], p[j] = p[j], p[i] }
+func (p chunkCountSlice) Less(i, j int) bool {
+	if p[i].Count != p[j].Count {
+		return p[i].Count > p[j].Count
+	}
+	return chunkCoordSlice{p[i].ChunkCoord, p[j].ChunkCoord}.Less(0, 1)
+}
+
+// BusiestChunks returns the n chunks in region holding the most entities, busiest
+// first, counting vehicles as EntityCensus does.  Chunks with no entities are
+// never listed.
+func (world *World) BusiestChunks(region *Region, n int, opts CensusOptions) (busiest []ChunkCount, err os.Error) {
+	busiest = make([]ChunkCount, 0)
+	err = world.scanEntities(region, func(xz ChunkCoord, entities []*Entity) bool {
+		count := len(entities)
+		for _, e := range entities {
+			for v := e.Riding; v != nil && !opts.IgnoreVehicles; v = v.Riding {
+				count++
+			}
+		}
+		if count > 0 {
+			busiest = append(busiest, ChunkCount{xz, count})
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Sort(chunkCountSlice(busiest))
+	if len(busiest) > n {
+		busiest = busiest[:n]
+	}
+	return
+}
+
+type refsByDistance struct {
+	refs []EntityRef
+	dist []float64
+}
+
+func (p refsByDistance) Len() int { return len(p.refs) }
+func (p refsByDistance) Swap(i, j int) {
+	p.refs[i], p.refs[j] = p.refs[j], p.refs[i]
+	p.dist[i], p.dist[j] = p.dist[j], p.dist[i]
+}
+func (p refsByDistance) Less(i, j int) bool {
+	if p.dist[i] != p.dist[j] {
+		return p.dist[i] < p.dist[j]
+	}
+	a, b := p.refs[i], p.refs[j]
+	if a.ChunkX != b.ChunkX || a.ChunkZ != b.ChunkZ {
+		return chunkCoordSlice{{a.ChunkX, a.ChunkZ}, {b.ChunkX, b.ChunkZ}}.Less(0, 1)
+	}
+	return a.Index < b.Index
+}
+
+// PlanCull returns the entities with the given id in region that a cull keeping
+// the keep closest to near would remove, without removing anything.
+func (world *World) PlanCull(id string, keep int, near Position, region *Region) ([]EntityRef, os.Error) {
+	refs, err := world.FindEntities(id, region)
+	if err != nil {
+		return nil, err
+	}
+	if keep < 0 {
+		keep = 0
+	}
+	if len(refs) <= keep {
+		return make([]EntityRef, 0), nil
+	}
+	byDistance := refsByDistance{refs, make([]float64, len(refs))}
+	for i, ref := range refs {
+		pos := ref.Entity.Physics.Position
+		dx, dy, dz := pos.X-near.X, pos.Y-near.Y, pos.Z-near.Z
+		byDistance.dist[i] = dx*dx + dy*dy + dz*dz
+	}
+	sort.Sort(byDistance)
+	return refs[keep:], nil
+}
+
+// Cull removes all but the keep entities with the given id in region closest to
+// the world's spawn point.
+func (world *World) Cull(id string, keep int, region *Region) (removed int, err os.Error) {
+	spawn := Position{float64(world.Data.SpawnX), float64(world.Data.SpawnY), float64(world.Data.SpawnZ)}
+	return world.CullNear(id, keep, spawn, region)
+}
+
+// CullNear removes all but the keep entities with the given id in region closest
+// to near.  Use PlanCull to see what it would remove.
+func (world *World) CullNear(id string, keep int, near Position, region *Region) (removed int, err os.Error) {
+	doomed, err := world.PlanCull(id, keep, near, region)
+	if err != nil || len(doomed) == 0 {
+		return
+	}
+	// index the doomed entities by chunk, then by their index in it
+	byChunk := make(map[XZ]map[int]bool)
+	culled := NewRegion(doomed[0].ChunkX, doomed[0].ChunkZ, doomed[0].ChunkX, doomed[0].ChunkZ)
+	for _, ref := range doomed {
+		xz := MakeXZ(ref.ChunkX, ref.ChunkZ)
+		if byChunk[xz] == nil {
+			byChunk[xz] = make(map[int]bool)
+		}
+		byChunk[xz][ref.Index] = true
+		culled = culled.Union(NewRegion(ref.ChunkX, ref.ChunkZ, ref.ChunkX, ref.ChunkZ))
+	}
+	err = world.streamChunks("CullNear", culled, func(c *Chunk) (bool, os.Error) {
+		indices := byChunk[MakeXZ(c.Level.XPos, c.Level.ZPos)]
+		if indices == nil {
+			return false, nil
+		}
+		kept := make([]*Entity, 0, len(c.Level.Entities))
+		for i, e := range c.Level.Entities {
+			if !indices[i] || e.Id != id {
+				kept = append(kept, e)
+			}
+		}
+		n := len(c.Level.Entities) - len(kept)
+		c.Level.Entities = kept
+		removed += n
+		return n > 0, nil
+	})
+	return
+}
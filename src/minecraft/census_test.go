@@ -0,0 +1,107 @@
+package world
+
+import "os"
+import "reflect"
+import "testing"
+
+// itemAt returns a copy of itemFixture lying at (x, y, z).
+func itemAt(x, y, z float64) map[string]interface{} {
+	item := make(map[string]interface{})
+	for k, v := range itemFixture {
+		item[k] = v
+	}
+	item["Pos"] = []interface{}{x, y, z}
+	return item
+}
+
+func makeCensusWorld(t *testing.T) string {
+	return makeTestWorld(t,
+		testChunkPayload(0, 0, []interface{}{itemAt(8, 64, 9), pigFixture, itemAt(0, 64, 0), itemAt(15, 64, 15)}, nil),
+		testChunkPayload(2, -1, []interface{}{
+			itemAt(40, 64, -1), itemAt(41, 64, -2), itemAt(42, 64, -3), itemAt(43, 64, -4), itemAt(44, 64, -5),
+		}, nil),
+		testChunkPayload(-1, -1, []interface{}{pigFixture}, nil))
+}
+
+func TestEntityCensus(t *testing.T) {
+	dir := makeCensusWorld(t)
+	defer os.RemoveAll(dir)
+	w, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	counts, err := w.EntityCensus(nil, CensusOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(counts) != 2 || counts["Item"] != 8 || counts["Pig"] != 2 {
+		t.Error("expected 8 items and 2 pigs, got ", counts)
+	}
+	if len(w.Chunks) != 0 {
+		t.Error("census left chunks resident")
+	}
+	if counts, err = w.EntityCensus(NewRegion(-1, -1, 0, 0), CensusOptions{}); err != nil || counts["Item"] != 3 {
+		t.Error("expected 3 items in region, got ", counts, err)
+	}
+
+	busiest, err := w.BusiestChunks(nil, 2, CensusOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []ChunkCount{{ChunkCoord{2, -1}, 5}, {ChunkCoord{0, 0}, 4}}
+	if !reflect.DeepEqual(busiest, want) {
+		t.Error("expected ", want, ", got ", busiest)
+	}
+}
+
+func TestCull(t *testing.T) {
+	dir := makeCensusWorld(t)
+	defer os.RemoveAll(dir)
+	w, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	// spawn is at (8, 64, 8)
+	resident, err := w.GetChunk(0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	doomed, err := w.PlanCull("Item", 2, Position{8, 64, 8}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(doomed) != 6 {
+		t.Fatal("expected 6 items planned for removal, got ", len(doomed))
+	}
+	if counts, _ := w.EntityCensus(nil, CensusOptions{}); counts["Item"] != 8 {
+		t.Error("PlanCull removed something")
+	}
+
+	removed, err := w.Cull("Item", 2, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if removed != 6 {
+		t.Error("expected 6 items removed, got ", removed)
+	}
+	counts, err := w.EntityCensus(nil, CensusOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if counts["Item"] != 2 || counts["Pig"] != 2 {
+		t.Error("expected 2 items and 2 pigs left, got ", counts)
+	}
+	// the two closest to spawn are both in (0, 0)
+	if len(resident.Level.Entities) != 3 || !resident.Dirty() {
+		t.Error("expected 2 items and the pig left in a dirty (0, 0), got ", resident.Level.Entities)
+	}
+	for _, e := range resident.Level.Entities {
+		if e.Id == "Item" && e.Physics.Position.X == 0 {
+			t.Error("kept the item farthest from spawn")
+		}
+	}
+}
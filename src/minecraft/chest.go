@@ -0,0 +1,91 @@
+package world
+
+// Items returns the chest's contents by slot number.  Empty slots hold an Item
+// with a Count of zero.
+func (chest *Chest) Items() (items [ChestSlots]Item) {
+	for _, s := range chest.Slots {
+		if s.Slot >= 0 && s.Slot < ChestSlots {
+			items[s.Slot] = s.Item
+		}
+	}
+	return
+}
+
+// Add puts up to count of item into the chest, topping up existing stacks of the
+// same item before filling empty slots in order.  It returns how many fit; the
+// rest are left over.  item.Count is ignored.
+func (chest *Chest) Add(item Item, count int) (added int) {
+	max := MaxStackSize(item.Id)
+	for i := range chest.Slots {
+		if added == count {
+			break
+		}
+		s := &chest.Slots[i].Item
+		if !stackable(*s, item) || int(s.Count) >= max {
+			continue
+		}
+		n := min(max-int(s.Count), count-added)
+		s.Count += int8(n)
+		added += n
+	}
+	for slot := int8(0); slot < ChestSlots && added < count; slot++ {
+		if findSlot(chest.Slots, slot) >= 0 {
+			continue
+		}
+		stack := item
+		n := min(max, count-added)
+		stack.Count = int8(n)
+		chest.Slots = putSlot(chest.Slots, slot, stack)
+		added += n
+	}
+	if added > 0 {
+		chest.touch()
+	}
+	return
+}
+
+// Remove takes up to count items with the given id out of the chest, emptying
+// stacks from the last slot back.  It returns how many were taken.
+func (chest *Chest) Remove(id int16, count int) (removed int) {
+	for i := len(chest.Slots) - 1; i >= 0 && removed < count; i-- {
+		s := &chest.Slots[i]
+		if s.Item.Id != id {
+			continue
+		}
+		n := min(int(s.Item.Count), count-removed)
+		s.Item.Count -= int8(n)
+		removed += n
+		if s.Item.Count <= 0 {
+			chest.Slots = takeSlot(chest.Slots, s.Slot)
+		}
+	}
+	if removed > 0 {
+		chest.touch()
+	}
+	return
+}
+
+// Clear empties the chest.
+func (chest *Chest) Clear() {
+	if len(chest.Slots) > 0 {
+		chest.Slots = chest.Slots[:0]
+		chest.touch()
+	}
+}
+
+// IsFull reports whether every slot of the chest is occupied.
+func (chest *Chest) IsFull() bool {
+	for slot := int8(0); slot < ChestSlots; slot++ {
+		if findSlot(chest.Slots, slot) < 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
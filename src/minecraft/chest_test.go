@@ -0,0 +1,90 @@
+package world
+
+import "minecraft/nbt"
+
+import "reflect"
+import "testing"
+
+func TestChestAddOverflow(t *testing.T) {
+	c := newChunk(0, 0)
+	chest := &Chest{TileEntityBase: TileEntityBase{id: "Chest", chunk: c}}
+	chest.Slots = []InventorySlot{
+		{3, Item{Id: BlockCobblestone, Count: 60}},
+		{5, Item{Id: 276, Count: 1}}, // a diamond sword
+	}
+	// 4 tops up slot 3, then 25 empty slots of 64
+	added := chest.Add(Item{Id: BlockCobblestone}, 2000)
+	if want := 4 + 25*64; added != want {
+		t.Errorf("expected %d added, got %d", want, added)
+	}
+	if !chest.IsFull() {
+		t.Error("expected the chest to be full")
+	}
+	if !c.Dirty() {
+		t.Error("chunk not marked dirty")
+	}
+	items := chest.Items()
+	if items[3].Count != 64 || items[5].Id != 276 || items[0].Count != 64 || items[26].Count != 64 {
+		t.Error("bad contents ", items)
+	}
+	if n := chest.Add(Item{Id: BlockCobblestone}, 1); n != 0 {
+		t.Error("added ", n, " to a full chest")
+	}
+	for i, s := range chest.Slots {
+		if s.Slot != int8(i) {
+			t.Fatal("slots out of order: ", chest.Slots)
+		}
+	}
+}
+
+func TestChestAddUnstackable(t *testing.T) {
+	chest := &Chest{}
+	if n := chest.Add(Item{Id: 278}, 3); n != 3 || len(chest.Slots) != 3 {
+		t.Error("expected 3 pickaxes in 3 slots, got ", chest.Slots)
+	}
+	if n := chest.Add(Item{Id: 332}, 20); n != 20 || chest.Items()[3].Count != 16 || chest.Items()[4].Count != 4 {
+		t.Error("expected snowballs in stacks of 16, got ", chest.Slots)
+	}
+}
+
+func TestChestRemove(t *testing.T) {
+	chest := &Chest{Slots: []InventorySlot{
+		{0, Item{Id: 264, Count: 10}},
+		{4, Item{Id: BlockDirt, Count: 64}},
+		{9, Item{Id: 264, Count: 5}},
+		{20, Item{Id: 264, Count: 7}},
+	}}
+	if n := chest.Remove(264, 15); n != 15 {
+		t.Error("expected 15 removed, got ", n)
+	}
+	// slots 20 and 9 are emptied and 3 come from slot 0
+	want := []InventorySlot{{0, Item{Id: 264, Count: 7}}, {4, Item{Id: BlockDirt, Count: 64}}}
+	if !reflect.DeepEqual(chest.Slots, want) {
+		t.Error("expected ", want, ", got ", chest.Slots)
+	}
+	if n := chest.Remove(264, 100); n != 7 {
+		t.Error("expected the last 7 removed, got ", n)
+	}
+	chest.Clear()
+	if len(chest.Slots) != 0 || chest.IsFull() {
+		t.Error("expected an empty chest, got ", chest.Slots)
+	}
+}
+
+func TestChestHelpersRoundTrip(t *testing.T) {
+	chest := mustChunk(testChunkPayload(0, 0, nil, []interface{}{chestFixture})).Level.TileEntities[0].(*Chest)
+	chest.Remove(264, 3)
+	chest.Add(Item{Id: BlockSand}, 100)
+	reread, err := toTileEntity(chest.toCompound())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !nbt.Equal(reread.toCompound(), chest.toCompound()) {
+		t.Error("expected ", chest.toCompound(), ", got ", reread.toCompound())
+	}
+	items := reread.(*Chest).Items()
+	// the fixture holds slots 0, 13 and 26; slot 13 emptied and sand fills 1 and 2
+	if items[13].Count != 0 || items[1].Id != BlockSand || items[1].Count != 64 || items[2].Count != 36 {
+		t.Error("bad contents after round trip ", items)
+	}
+}
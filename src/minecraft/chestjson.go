@@ -0,0 +1,383 @@
+package world
+
+import "minecraft/error"
+
+import "bytes"
+import "fmt"
+import "io"
+import "io/ioutil"
+import "json"
+import "math"
+import "os"
+import "sort"
+
+// JSON encoding of the contents of storage, for carrying it between worlds.  The
+// whole is an array with a container on each line, each an object with these
+// members, in this order:
+//
+//	"x", "y", "z"  the absolute coordinates of a chest's block, or of the block
+//	               holding a storage minecart
+//	"minecart"     true, for a storage minecart; left out for a chest
+//	"items"        the occupied slots, encoded as items are by Entity.MarshalJSON
+//
+// Containers come in the order of ListChunks, chests before minecarts within a
+// chunk.
+
+// MissingChestPolicy chooses what ImportChests does with a container whose chest,
+// or storage minecart, is not in the world.
+type MissingChestPolicy int
+
+const (
+	SkipMissingChests   MissingChestPolicy = iota // leave its items out
+	CreateMissingChests                           // place a chest or spawn a cart
+	FailMissingChests                             // stop with an error
+)
+
+// ChestImportOptions control how ImportChests fills containers.
+type ChestImportOptions struct {
+	Missing MissingChestPolicy
+	// Merge adds the items to what a container already holds: each stack goes
+	// in its own slot if that is free, and otherwise as Chest.Add would put it.
+	// A container too full to take them all is an error, and is left as it
+	// was.  Otherwise the items replace the container's contents.
+	Merge bool
+	// OffsetX, OffsetY and OffsetZ are added to every container's coordinates,
+	// for a world shifted from the one exported.
+	OffsetX, OffsetY, OffsetZ int32
+}
+
+// chestContents is a container as ExportChests writes it.
+type chestContents struct {
+	x, y, z  int32
+	minecart bool
+	slots    []InventorySlot
+}
+
+// storageMembers are the members of a container decoded with fromJSONObject;
+// "minecart" is handled on its own.
+var storageMembers = []jsonMember{
+	{"x", "x", jsonInt},
+	{"y", "y", jsonInt},
+	{"z", "z", jsonInt},
+	{"items", "Items", jsonItems},
+}
+
+// ExportChests writes the contents of every chest and storage minecart in region,
+// or in the whole world if region is nil, to w as described at the top of this
+// file.  Chunks are read one at a time and none are loaded.
+func (world *World) ExportChests(w io.Writer, region *Region) os.Error {
+	coords, err := world.ListChunks(region)
+	if err != nil {
+		return err
+	}
+	first := true
+	for _, xz := range coords {
+		var tes []TileEntity
+		var entities []*Entity
+		if c, ok := world.resident(MakeXZ(xz.X, xz.Z)); ok {
+			tes, entities = c.Level.TileEntities, c.Level.Entities
+		} else {
+			level, err := world.readChunkLevel(xz.X, xz.Z)
+			if err != nil {
+				return err
+			}
+			teList, err := getList(level, "TileEntities")
+			if err == nil {
+				var entityList []interface{}
+				if entityList, err = getList(level, "Entities"); err == nil {
+					tes, _ = toTileEntityList(teList)
+					entities, _, err = toEntityList(entityList)
+				}
+			}
+			if err != nil {
+				return error.InChunk(xz.X, xz.Z).Error("malformed chunk", err)
+			}
+		}
+		var found []chestContents
+		for _, te := range tes {
+			if chest, ok := te.(*Chest); ok {
+				found = append(found, chestContents{chest.X(), chest.Y(), chest.Z(), false, chest.Slots})
+			}
+		}
+		for _, e := range entities {
+			if isStorageCart(e) {
+				x, y, z := blockOf(e.Physics.Position)
+				found = append(found, chestContents{x, y, z, true, e.Minecart.Items})
+			}
+		}
+		for _, contents := range found {
+			b, err := contents.marshalJSON()
+			if err != nil {
+				return error.InChunk(xz.X, xz.Z).Error("chunk", err)
+			}
+			sep := ",\n"
+			if first {
+				sep, first = "[\n", false
+			}
+			if _, err = w.Write(append([]byte(sep), b...)); err != nil {
+				return err
+			}
+		}
+	}
+	end := "\n]\n"
+	if first {
+		end = "[]\n"
+	}
+	_, err = w.Write([]byte(end))
+	return err
+}
+
+// isStorageCart reports whether e is a storage minecart.
+func isStorageCart(e *Entity) bool {
+	return e.Id == "Minecart" && e.Minecart != nil && e.Minecart.Type == MinecartChest
+}
+
+// blockOf returns the absolute coordinates of the block containing p.
+func blockOf(p Position) (x, y, z int32) {
+	return int32(math.Floor(p.X)), int32(math.Floor(p.Y)), int32(math.Floor(p.Z))
+}
+
+// marshalJSON encodes the container as described at the top of this file.
+func (contents *chestContents) marshalJSON() ([]byte, os.Error) {
+	obj := jsonObject{{"x", contents.x}, {"y", contents.y}, {"z", contents.z}}
+	if contents.minecart {
+		obj = append(obj, jsonField{"minecart", true})
+	}
+	items, _, err := storageMembers[3].encode(fromInventory(contents.slots))
+	if err != nil {
+		return nil, err
+	}
+	obj = append(obj, jsonField{"items", items})
+	buf := new(bytes.Buffer)
+	if err = writeJSON(buf, obj); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// unmarshalChestContents decodes a container from its parsed JSON.
+func unmarshalChestContents(v interface{}) (contents chestContents, err os.Error) {
+	obj, ok := v.(map[string]interface{})
+	if !ok {
+		return contents, error.NewError(fmt.Sprintf("expected an object, got %v", v), nil)
+	}
+	if _, ok = obj["extra"]; ok {
+		return contents, error.NewError(`unknown member "extra"`, nil)
+	}
+	members := make(map[string]interface{}, len(obj))
+	for name, value := range obj {
+		if name != "minecart" {
+			members[name] = value
+		} else if contents.minecart, ok = value.(bool); !ok {
+			return contents, error.NewError(fmt.Sprintf(`member "minecart": expected a boolean, got %v`, value), nil)
+		}
+	}
+	c, err := fromJSONObject(members, storageMembers)
+	if err != nil {
+		return
+	}
+	for _, m := range storageMembers {
+		if _, ok = c[m.tag]; !ok {
+			return contents, error.NewError(fmt.Sprintf("missing member %q", m.name), nil)
+		}
+	}
+	contents.x, contents.y, contents.z = c["x"].(int32), c["y"].(int32), c["z"].(int32)
+	if contents.slots, err = toSlots(c["Items"].([]interface{})); err != nil {
+		return
+	}
+	for _, s := range contents.slots {
+		if s.Slot < 0 || s.Slot >= ChestSlots {
+			return contents, error.NewError(fmt.Sprint("no slot ", s.Slot, " in a chest"), nil)
+		}
+	}
+	return
+}
+
+// ImportChests reads containers written by ExportChests from r and puts their
+// items into the chests and storage minecarts at their coordinates, shifted by
+// the offsets of opts; a minecart is matched by the block holding it.  Every
+// container is decoded before any is filled.  A chest block without a tile
+// entity is given one.  Under CreateMissingChests a missing chest is placed,
+// replacing the block there and any tile entity it had, and a missing minecart is
+// spawned in the middle of its block; either is an error if its chunk does not
+// exist.  It returns how many containers were filled and how many were skipped
+// as missing.  The chunks filled are left resident and dirty; containers filled
+// before an error stay filled.  The Locks of the chunks filled are held until
+// every container is filled.
+func (world *World) ImportChests(r io.Reader, opts ChestImportOptions) (filled, skipped int, err os.Error) {
+	if err = world.verifyLock(); err != nil {
+		return
+	}
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return 0, 0, error.NewError("could not read chests", err)
+	}
+	var v interface{}
+	if err = json.Unmarshal(data, &v); err != nil {
+		return 0, 0, error.NewError("could not parse chests", err)
+	}
+	list, ok := v.([]interface{})
+	if !ok {
+		return 0, 0, error.NewError(fmt.Sprintf("expected an array of chests, got %v", v), nil)
+	}
+	all := make([]chestContents, len(list))
+	for i, item := range list {
+		if all[i], err = unmarshalChestContents(item); err != nil {
+			return 0, 0, error.NewError(fmt.Sprintf("chest %d", i), err)
+		}
+		all[i].x += opts.OffsetX
+		all[i].y += opts.OffsetY
+		all[i].z += opts.OffsetZ
+		if all[i].y < 0 || all[i].y >= ChunkHeight {
+			return 0, 0, error.NewError(fmt.Sprintf("chest %d: y %d is outside the world", i, all[i].y), ErrOutOfRange)
+		}
+	}
+
+	// the chunks filled are locked in the order ListChunks sorts them, as
+	// lockPair locks them
+	chunks := make(map[XZ]*Chunk)
+	var coords []ChunkCoord
+	for _, contents := range all {
+		x, z := contents.x>>4, contents.z>>4
+		if _, ok := chunks[MakeXZ(x, z)]; !ok && world.ChunkExists(x, z) {
+			chunks[MakeXZ(x, z)] = nil
+			coords = append(coords, ChunkCoord{x, z})
+		}
+	}
+	sort.Sort(chunkCoordSlice(coords))
+	defer func() {
+		for _, c := range chunks {
+			if c != nil {
+				c.Unlock()
+			}
+		}
+	}()
+	for _, xz := range coords {
+		c, err := world.GetChunk(xz.X, xz.Z)
+		if err != nil {
+			return 0, 0, err
+		}
+		c.Lock()
+		chunks[MakeXZ(xz.X, xz.Z)] = c
+	}
+
+	for _, contents := range all {
+		var ok bool
+		if ok, err = importChest(chunks[MakeXZ(contents.x>>4, contents.z>>4)], contents, opts); err != nil {
+			return
+		}
+		if ok {
+			filled++
+		} else {
+			skipped++
+		}
+	}
+	return
+}
+
+// importChest fills the container matching contents in c, which is nil if its
+// chunk is missing and otherwise locked by the caller, and reports whether it
+// was filled rather than skipped as missing.
+func importChest(c *Chunk, contents chestContents, opts ChestImportOptions) (bool, os.Error) {
+	slots, err := storageIn(c, contents, opts.Missing)
+	if err != nil || slots == nil {
+		return false, err
+	}
+	merged := append([]InventorySlot(nil), contents.slots...)
+	if opts.Merge {
+		if merged, err = mergeSlots(*slots, contents.slots); err != nil {
+			return false, error.NewError(fmt.Sprintf("could not fill %s", contents.describe()), err)
+		}
+	}
+	*slots = merged
+	c.dirty = true
+	return true, nil
+}
+
+// describe names the container for errors.
+func (contents *chestContents) describe() string {
+	kind := "chest"
+	if contents.minecart {
+		kind = "storage minecart"
+	}
+	return fmt.Sprintf("%s at (%d, %d, %d)", kind, contents.x, contents.y, contents.z)
+}
+
+// storageIn returns the slots of the container matching contents in c, which
+// is nil if its chunk is missing, or nil slots if the container is missing and
+// should be skipped.
+func storageIn(c *Chunk, contents chestContents, missing MissingChestPolicy) (*[]InventorySlot, os.Error) {
+	x, y, z := contents.x, contents.y, contents.z
+	if c != nil && contents.minecart {
+		for _, e := range c.Level.Entities {
+			if ex, ey, ez := blockOf(e.Physics.Position); isStorageCart(e) && ex == x && ey == y && ez == z {
+				return &e.Minecart.Items, nil
+			}
+		}
+	} else if c != nil {
+		if id, _, _ := c.BlockAt(x&15, y, z&15); id == BlockChest {
+			chest, err := c.chestAt(x, y, z)
+			if err != nil {
+				return nil, err
+			}
+			return &chest.Slots, nil
+		}
+	}
+
+	switch {
+	case missing == SkipMissingChests:
+		return nil, nil
+	case missing != CreateMissingChests:
+		return nil, error.NewError(fmt.Sprintf("no %s", contents.describe()), nil)
+	case c == nil:
+		return nil, error.InChunk(x>>4, z>>4).Error("could not create "+contents.describe()+" in missing chunk", nil)
+	}
+	if contents.minecart {
+		cart := &Entity{
+			Id:       "Minecart",
+			Air:      300,
+			Minecart: &MinecartData{Type: MinecartChest},
+			Physics:  Physics{Position: Position{float64(x) + 0.5, float64(y), float64(z) + 0.5}},
+		}
+		if err := c.AddEntity(cart); err != nil {
+			return nil, err
+		}
+		return &cart.Minecart.Items, nil
+	}
+	kept := c.Level.TileEntities[:0]
+	for _, te := range c.Level.TileEntities {
+		if te.X() != x || te.Y() != y || te.Z() != z {
+			kept = append(kept, te)
+		}
+	}
+	c.Level.TileEntities = kept
+	if err := c.SetBlock(x&15, y, z&15, BlockChest, 0); err != nil {
+		return nil, err
+	}
+	chest, err := c.chestAt(x, y, z)
+	if err != nil {
+		return nil, err
+	}
+	return &chest.Slots, nil
+}
+
+// mergeSlots returns slots with items added, each in its own slot if that is
+// free and otherwise topping up stacks and filling empty slots in order, or an
+// error if they do not all fit.  slots itself is not changed.
+func mergeSlots(slots, items []InventorySlot) ([]InventorySlot, os.Error) {
+	chest := &Chest{Slots: append([]InventorySlot(nil), slots...)}
+	var rest []InventorySlot
+	for _, s := range items {
+		if findSlot(chest.Slots, s.Slot) < 0 {
+			chest.Slots = putSlot(chest.Slots, s.Slot, s.Item)
+		} else {
+			rest = append(rest, s)
+		}
+	}
+	for _, s := range rest {
+		if added := chest.Add(s.Item, int(s.Item.Count)); added < int(s.Item.Count) {
+			return nil, error.NewError(fmt.Sprintf("%d of item %d do not fit", int(s.Item.Count)-added, s.Item.Id), nil)
+		}
+	}
+	return chest.Slots, nil
+}
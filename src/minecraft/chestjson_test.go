@@ -0,0 +1,188 @@
+package world
+
+import "bytes"
+import "os"
+import "strings"
+import "testing"
+
+// storageChunks returns chunks (0, 0) and (-1, -1) holding a chest each and a
+// storage minecart at (4, 64, 12), empty unless filled is set.
+func storageChunks(filled bool) []map[string]interface{} {
+	items := func(slots ...interface{}) []interface{} {
+		if !filled {
+			return []interface{}{}
+		}
+		return slots
+	}
+	enchanted := itemCompound(2, 276, 1, 5)
+	enchanted["tag"] = map[string]interface{}{"Name": "Edge"}
+	cart := minecartFixture("Minecart", map[string]interface{}{
+		"Type":  int32(MinecartChest),
+		"Items": items(itemCompound(26, 264, 3, 0)),
+	})
+	home := testChunkPayload(0, 0, []interface{}{cart}, []interface{}{
+		tileEntityCompound("Chest", 5, 64, 5, map[string]interface{}{
+			"Items": items(itemCompound(0, BlockCobblestone, 64, 0), itemCompound(13, 264, 3, 0)),
+		}),
+	})
+	far := testChunkPayload(-1, -1, nil, []interface{}{
+		tileEntityCompound("Chest", -3, 70, -2, map[string]interface{}{"Items": items(enchanted)}),
+	})
+	home["Level"].(map[string]interface{})["Blocks"].([]byte)[blockIndex(5, 64, 5)] = BlockChest
+	far["Level"].(map[string]interface{})["Blocks"].([]byte)[blockIndex(13, 70, 14)] = BlockChest
+	return []map[string]interface{}{home, far}
+}
+
+// openStorageWorld opens a new world of storageChunks.
+func openStorageWorld(t *testing.T, filled bool) (*World, string) {
+	dir := makeTestWorld(t, storageChunks(filled)...)
+	w, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return w, dir
+}
+
+func exportChests(t *testing.T, w *World) string {
+	buf := new(bytes.Buffer)
+	if err := w.ExportChests(buf, nil); err != nil {
+		t.Fatal(err)
+	}
+	return buf.String()
+}
+
+func TestExportChests(t *testing.T) {
+	w, dir := openStorageWorld(t, true)
+	defer os.RemoveAll(dir)
+	defer w.Close()
+	if got, want := exportChests(t, w), readGolden(t, "chests.json")+"\n"; got != want {
+		t.Errorf("expected\n%s\ngot\n%s", want, got)
+	}
+	if len(w.Chunks) != 0 {
+		t.Error("expected no chunks to be made resident, got ", len(w.Chunks))
+	}
+}
+
+func TestImportChestsRoundTrip(t *testing.T) {
+	src, srcDir := openStorageWorld(t, true)
+	defer os.RemoveAll(srcDir)
+	defer src.Close()
+	exported := exportChests(t, src)
+
+	w, dir := openStorageWorld(t, false)
+	defer os.RemoveAll(dir)
+	defer w.Close()
+	filled, skipped, err := w.ImportChests(strings.NewReader(exported), ChestImportOptions{Missing: FailMissingChests})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if filled != 3 || skipped != 0 {
+		t.Errorf("expected 3 filled and none skipped, got %d and %d", filled, skipped)
+	}
+	if got := exportChests(t, w); got != exported {
+		t.Errorf("expected\n%s\ngot\n%s", exported, got)
+	}
+	if err = w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	// importing again, merged, doubles every stack
+	if _, _, err = w.ImportChests(strings.NewReader(exported), ChestImportOptions{Merge: true}); err != nil {
+		t.Fatal(err)
+	}
+	c, err := w.GetChunk(0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chest, err := c.chestAt(5, 64, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	items := chest.Items()
+	if items[0].Count != 64 || items[1].Count != 64 || items[13].Count != 6 {
+		t.Error("expected stacks to be merged, got ", items)
+	}
+}
+
+func TestImportChestsMissing(t *testing.T) {
+	src, srcDir := openStorageWorld(t, true)
+	defer os.RemoveAll(srcDir)
+	defer src.Close()
+	exported := exportChests(t, src)
+
+	dir := makeTestWorld(t, testChunkPayload(0, -1, nil, nil), testChunkPayload(1, 0, nil, nil))
+	defer os.RemoveAll(dir)
+	w, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	if _, _, err = w.ImportChests(strings.NewReader(exported), ChestImportOptions{Missing: FailMissingChests}); err == nil {
+		t.Error("expected an error for missing chests")
+	}
+	filled, skipped, err := w.ImportChests(strings.NewReader(exported), ChestImportOptions{})
+	if err != nil || filled != 0 || skipped != 3 {
+		t.Errorf("expected every chest skipped, got %d filled and %d skipped (%v)", filled, skipped, err)
+	}
+
+	if _, _, err = w.ImportChests(strings.NewReader(exported), ChestImportOptions{Missing: CreateMissingChests}); err == nil {
+		t.Error("expected an error creating a chest in a missing chunk")
+	}
+	if _, _, err = w.ImportChests(strings.NewReader(`[{"x": 5, "y": 64, "z": -11, "items": []}]`), ChestImportOptions{Missing: CreateMissingChests}); err != nil {
+		t.Fatal(err)
+	}
+	if id, _, _ := w.Chunks[MakeXZ(0, -1)].BlockAt(5, 64, 5); id != BlockChest {
+		t.Error("expected a chest block to be placed, got ", id)
+	}
+
+	opts := ChestImportOptions{Missing: CreateMissingChests, OffsetX: 16}
+	exported = `[{"x": 5, "y": 64, "z": 5, "items": [{"slot": 0, "id": 4, "count": 64, "damage": 0}]},
+{"x": 4, "y": 64, "z": 12, "minecart": true, "items": [{"slot": 26, "id": 264, "count": 3, "damage": 0}]}]`
+	if filled, _, err = w.ImportChests(strings.NewReader(exported), opts); err != nil || filled != 2 {
+		t.Fatalf("expected 2 filled, got %d (%v)", filled, err)
+	}
+	want := "[\n" +
+		`{"x": 5, "y": 64, "z": -11, "items": []},` + "\n" +
+		`{"x": 21, "y": 64, "z": 5, "items": [{"slot": 0, "id": 4, "count": 64, "damage": 0}]},` + "\n" +
+		`{"x": 20, "y": 64, "z": 12, "minecart": true, "items": [{"slot": 26, "id": 264, "count": 3, "damage": 0}]}` +
+		"\n]\n"
+	if got := exportChests(t, w); got != want {
+		t.Errorf("expected\n%s\ngot\n%s", want, got)
+	}
+}
+
+func TestImportChestsFull(t *testing.T) {
+	w, dir := openStorageWorld(t, false)
+	defer os.RemoveAll(dir)
+	defer w.Close()
+	c, err := w.GetChunk(0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chest, err := c.chestAt(5, 64, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chest.Add(Item{Id: BlockCobblestone}, ChestSlots*64-1)
+	before := len(chest.Slots)
+	_, _, err = w.ImportChests(strings.NewReader(`[{"x": 5, "y": 64, "z": 5, "items": [{"slot": 3, "id": 4, "count": 2, "damage": 0}]}]`), ChestImportOptions{Merge: true})
+	if err == nil {
+		t.Error("expected an error for items that do not fit")
+	}
+	if len(chest.Slots) != before || chest.Items()[ChestSlots-1].Count != 63 {
+		t.Error("expected the full chest to be left as it was, got ", chest.Items())
+	}
+
+	for _, bad := range []string{
+		`{"x": 5, "y": 64, "z": 5, "items": []}`,
+		`[{"x": 5, "y": 64, "z": 5}]`,
+		`[{"x": 5, "y": 64, "z": 5, "items": [], "extra": {}}]`,
+		`[{"x": 5, "y": 64, "z": 5, "minecart": 1, "items": []}]`,
+		`[{"x": 5, "y": 64, "z": 5, "items": [{"slot": 27, "id": 4, "count": 1, "damage": 0}]}]`,
+		`[{"x": 5, "y": 128, "z": 5, "items": []}]`,
+	} {
+		if _, _, err = w.ImportChests(strings.NewReader(bad), ChestImportOptions{}); err == nil {
+			t.Errorf("expected an error for %s", bad)
+		}
+	}
+}
@@ -0,0 +1,423 @@
+package world
+
+import "minecraft/error"
+
+import "fmt"
+import "os"
+
+// Alpha chunks are 16x128x16 columns.  Blocks are stored with y varying fastest,
+// then z, then x, so index = y + z*ChunkHeight + x*ChunkHeight*ChunkDepth.
+// Data, SkyLight and BlockLight hold one nibble per block using the same index;
+// even indices live in the low nibble.
+const (
+	ChunkWidth  = 16  // x
+	ChunkHeight = 128 // y
+	ChunkDepth  = 16  // z
+
+	chunkColumns = ChunkWidth * ChunkDepth
+	chunkBlocks  = ChunkWidth * ChunkHeight * ChunkDepth
+	chunkNibbles = chunkBlocks / 2
+)
+
+// newChunk makes an empty (all air, unlit) chunk at chunk coordinates (x, z).
+func newChunk(x, z int32) *Chunk {
+	return &Chunk{
+		Level: Level{
+			Blocks:       make([]byte, chunkBlocks),
+			Data:         make([]byte, chunkNibbles),
+			SkyLight:     make([]byte, chunkNibbles),
+			HeightMap:    make([]byte, chunkColumns),
+			BlockLight:   make([]byte, chunkNibbles),
+			Entities:     make([]*Entity, 0),
+			TileEntities: make([]TileEntity, 0),
+			XPos:         x,
+			ZPos:         z,
+		},
+	}
+}
+
+func blockIndex(x, y, z int32) int {
+	return int(y + z*ChunkHeight + x*ChunkHeight*ChunkDepth)
+}
+
+func inChunk(x, y, z int32) bool {
+	return x >= 0 && x < ChunkWidth && y >= 0 && y < ChunkHeight && z >= 0 && z < ChunkDepth
+}
+
+func getNibble(arr []byte, i int) byte {
+	if i&1 == 0 {
+		return arr[i>>1] & 0x0f
+	}
+	return arr[i>>1] >> 4
+}
+
+func setNibble(arr []byte, i int, v byte) {
+	if i&1 == 0 {
+		arr[i>>1] = arr[i>>1]&0xf0 | v&0x0f
+	} else {
+		arr[i>>1] = arr[i>>1]&0x0f | v<<4
+	}
+}
+
+// Lock and Unlock guard changes to a chunk that other goroutines may be using;
+// see World.
+func (c *Chunk) Lock()   { c.mu.Lock() }
+func (c *Chunk) Unlock() { c.mu.Unlock() }
+
+// RLock and RUnlock guard reading a chunk that other goroutines may change.
+func (c *Chunk) RLock()   { c.mu.RLock() }
+func (c *Chunk) RUnlock() { c.mu.RUnlock() }
+
+// Dirty reports whether the chunk has in-memory changes that Flush has yet to write.
+func (c *Chunk) Dirty() bool {
+	return c.dirty
+}
+
+// MarkDirty flags the chunk for writing on the next Flush.  Callers that modify
+// Level directly are responsible for calling it.  It fails, leaving the chunk
+// clean, if the chunk's arrays cannot be decoded, as Flush could not write it.
+func (c *Chunk) MarkDirty() os.Error {
+	if err := c.LoadArrays(); err != nil {
+		return err
+	}
+	c.dirty = true
+	return nil
+}
+
+// HeightMapValid reports whether Level.HeightMap still describes the blocks.
+// Block writes through the Chunk API invalidate it.
+func (c *Chunk) HeightMapValid() bool {
+	return !c.heightMapStale
+}
+
+// BlockAt returns the block id and data value at local coordinates (x, y, z).
+func (c *Chunk) BlockAt(x, y, z int32) (id byte, data byte, err os.Error) {
+	if !inChunk(x, y, z) {
+		err = error.NewError(fmt.Sprintf("block (%d, %d, %d) is outside the chunk", x, y, z), ErrOutOfRange)
+		return
+	}
+	if err = c.LoadArrays(); err != nil {
+		return
+	}
+	i := blockIndex(x, y, z)
+	return c.Level.Blocks[i], getNibble(c.Level.Data, i), nil
+}
+
+// HighestBlockAt returns the height and id of the highest block in column (x, z)
+// that is not air, scanning the blocks rather than trusting Level.HeightMap.  An
+// empty column gives a height of -1.
+func (c *Chunk) HighestBlockAt(x, z int32) (y int32, id byte, err os.Error) {
+	if !inChunk(x, 0, z) {
+		err = error.NewError(fmt.Sprintf("column (%d, %d) is outside the chunk", x, z), ErrOutOfRange)
+		return
+	}
+	return c.highestBlockBelow(x, ChunkHeight-1, z)
+}
+
+// highestBlockBelow returns the height and id of the highest block that is not
+// air in column (x, z) at or below top, or -1 if there is none.
+func (c *Chunk) highestBlockBelow(x, top, z int32) (y int32, id byte, err os.Error) {
+	if err = c.LoadArrays(); err != nil {
+		return
+	}
+	base := blockIndex(x, 0, z)
+	for y = top; y >= 0; y-- {
+		if id = c.Level.Blocks[base+int(y)]; id != BlockAir {
+			return
+		}
+	}
+	return -1, BlockAir, nil
+}
+
+// SetBlock sets the block id and data value at local coordinates (x, y, z).
+func (c *Chunk) SetBlock(x, y, z int32, id byte, data byte) os.Error {
+	if !inChunk(x, y, z) {
+		return error.NewError(fmt.Sprintf("block (%d, %d, %d) is outside the chunk", x, y, z), ErrOutOfRange)
+	}
+	if err := c.LoadArrays(); err != nil {
+		return err
+	}
+	i := blockIndex(x, y, z)
+	c.Level.Blocks[i] = id
+	setNibble(c.Level.Data, i, data)
+	c.dirty = true
+	c.heightMapStale = true
+	return nil
+}
+
+// SetBlocks replaces every block id in the chunk with src, which must be laid out
+// like Level.Blocks.  Data values are left alone.
+func (c *Chunk) SetBlocks(src []byte) os.Error {
+	if len(src) != chunkBlocks {
+		return error.NewError(fmt.Sprintf("expected %d blocks, got %d", chunkBlocks, len(src)), nil)
+	}
+	if err := c.LoadArrays(); err != nil {
+		return err
+	}
+	copy(c.Level.Blocks, src)
+	c.dirty = true
+	c.heightMapStale = true
+	return nil
+}
+
+// SetRegionBlocks copies a box of dims[0] x dims[1] x dims[2] (x, y, z) blocks into
+// the chunk with its minimum corner at local coordinates (lx, ly, lz).  src is laid
+// out like Level.Blocks, y fastest: src[y + z*dims[1] + x*dims[1]*dims[2]].
+// srcData holds one data value per block (low nibble) in the same order, or is nil
+// to leave data values untouched.
+func (c *Chunk) SetRegionBlocks(lx, ly, lz int32, dims [3]int32, src []byte, srcData []byte) os.Error {
+	if dims[0] < 0 || dims[1] < 0 || dims[2] < 0 {
+		return error.NewError(fmt.Sprint("negative box dimensions ", dims), nil)
+	}
+	n := int(dims[0] * dims[1] * dims[2])
+	if len(src) != n {
+		return error.NewError(fmt.Sprintf("expected %d blocks, got %d", n, len(src)), nil)
+	}
+	if srcData != nil && len(srcData) != n {
+		return error.NewError(fmt.Sprintf("expected %d data values, got %d", n, len(srcData)), nil)
+	}
+	return c.blit(lx, ly, lz, dims, blitSource{src, srcData, dims, [3]int32{}})
+}
+
+// blitSource describes the box inside a larger source array that a blit reads from.
+type blitSource struct {
+	blocks, data []byte
+	dims         [3]int32 // of the whole source array
+	offset       [3]int32 // of the box within it
+}
+
+// blit copies a box of the given dims from src into the chunk at (lx, ly, lz).
+// Bounds are checked once up front; the copy itself works a column at a time.
+func (c *Chunk) blit(lx, ly, lz int32, dims [3]int32, src blitSource) os.Error {
+	if dims[0] == 0 || dims[1] == 0 || dims[2] == 0 {
+		return nil
+	}
+	if !inChunk(lx, ly, lz) || !inChunk(lx+dims[0]-1, ly+dims[1]-1, lz+dims[2]-1) {
+		return error.NewError(fmt.Sprintf("box at (%d, %d, %d) with dims %v does not fit in the chunk", lx, ly, lz, dims), ErrOutOfRange)
+	}
+	if err := c.LoadArrays(); err != nil {
+		return err
+	}
+	sh, sd := src.dims[1], src.dims[2]
+	h := int(dims[1])
+	for x := int32(0); x < dims[0]; x++ {
+		for z := int32(0); z < dims[2]; z++ {
+			si := int(src.offset[1] + (src.offset[2]+z)*sh + (src.offset[0]+x)*sh*sd)
+			di := blockIndex(lx+x, ly, lz+z)
+			copy(c.Level.Blocks[di:di+h], src.blocks[si:si+h])
+			if src.data != nil {
+				for y := 0; y < h; y++ {
+					setNibble(c.Level.Data, di+y, src.data[si+y])
+				}
+			}
+		}
+	}
+	c.dirty = true
+	c.heightMapStale = true
+	return nil
+}
+
+// GetChunk returns the chunk at chunk coordinates (x, z), loading it from the
+// store if it is not already resident, as LoadChunk does.  It is the way to
+// reach a chunk about to be used, leaving whether it must be read to the
+// World; code that makes chunks resident ahead of use calls LoadChunk, or
+// LoadChunksParallel, instead.
+func (world *World) GetChunk(x, z int32) (c *Chunk, err os.Error) {
+	return world.LoadChunk(x, z)
+}
+
+// BlockAt returns the id and data value of the block at absolute coordinates
+// (x, y, z), loading its chunk if it is not resident, while holding the chunk's
+// RLock.
+func (world *World) BlockAt(x, y, z int32) (id byte, data byte, err os.Error) {
+	c, err := world.GetChunk(x>>4, z>>4)
+	if err != nil {
+		return
+	}
+	c.RLock()
+	defer c.RUnlock()
+	return c.BlockAt(x&(ChunkWidth-1), y, z&(ChunkDepth-1))
+}
+
+// CreateChunk makes an empty chunk, all air and unlit, resident at chunk
+// coordinates (x, z), to be written on the next Flush.  It fails if there is
+// already a chunk there, resident or on disk.
+func (world *World) CreateChunk(x, z int32) (c *Chunk, err os.Error) {
+	if err = world.verifyLock(); err != nil {
+		return
+	}
+	world.mu.Lock()
+	defer world.mu.Unlock()
+	if _, ok := world.Chunks[MakeXZ(x, z)]; ok || world.chunkOnDisk(x, z) {
+		return nil, error.InChunk(x, z).Error("cannot create existing chunk", nil)
+	}
+	c = newChunk(x, z)
+	c.dirty = true
+	world.Chunks[MakeXZ(x, z)] = c
+	return
+}
+
+// BlitBlocks copies a box of blocks laid out as for Chunk.SetRegionBlocks into the
+// world with its minimum corner at absolute block coordinates (absX, absY, absZ),
+// splitting it across every chunk it spans.  All of those chunks are loaded, and
+// their Locks taken, before any of them is modified.
+func (world *World) BlitBlocks(absX, absY, absZ int32, dims [3]int32, src []byte, srcData []byte) os.Error {
+	if dims[0] < 0 || dims[1] < 0 || dims[2] < 0 {
+		return error.NewError(fmt.Sprint("negative box dimensions ", dims), nil)
+	}
+	n := int(dims[0] * dims[1] * dims[2])
+	if len(src) != n {
+		return error.NewError(fmt.Sprintf("expected %d blocks, got %d", n, len(src)), nil)
+	}
+	if srcData != nil && len(srcData) != n {
+		return error.NewError(fmt.Sprintf("expected %d data values, got %d", n, len(srcData)), nil)
+	}
+	if n == 0 {
+		return nil
+	}
+	if absY < 0 || absY+dims[1] > ChunkHeight {
+		return error.NewError(fmt.Sprintf("box from y=%d of height %d leaves the world", absY, dims[1]), ErrOutOfRange)
+	}
+
+	// chunk coordinates floor towards negative infinity
+	cx0, cx1 := absX>>4, (absX+dims[0]-1)>>4
+	cz0, cz1 := absZ>>4, (absZ+dims[2]-1)>>4
+	// locked in the order ListChunks sorts them, as lockPair locks them
+	chunks := make(map[XZ]*Chunk)
+	defer func() {
+		for _, c := range chunks {
+			c.Unlock()
+		}
+	}()
+	for cx := cx0; cx <= cx1; cx++ {
+		for cz := cz0; cz <= cz1; cz++ {
+			c, err := world.GetChunk(cx, cz)
+			if err != nil {
+				return error.InChunk(cx, cz).Error("could not get chunk", err)
+			}
+			c.Lock()
+			chunks[MakeXZ(cx, cz)] = c
+		}
+	}
+
+	for cx := cx0; cx <= cx1; cx++ {
+		x0, x1 := max32(absX, cx*ChunkWidth), min32(absX+dims[0], (cx+1)*ChunkWidth)
+		for cz := cz0; cz <= cz1; cz++ {
+			z0, z1 := max32(absZ, cz*ChunkDepth), min32(absZ+dims[2], (cz+1)*ChunkDepth)
+			part := [3]int32{x1 - x0, dims[1], z1 - z0}
+			from := blitSource{src, srcData, dims, [3]int32{x0 - absX, 0, z0 - absZ}}
+			err := chunks[MakeXZ(cx, cz)].blit(x0-cx*ChunkWidth, absY, z0-cz*ChunkDepth, part, from)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func min32(a, b int32) int32 {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func max32(a, b int32) int32 {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// Slice returns the 16x16 layer of blocks at height y.  Both arrays are indexed
+// x + z*ChunkWidth, the same order as Level.HeightMap.
+func (c *Chunk) Slice(y int32) (ids [chunkColumns]byte, data [chunkColumns]byte, err os.Error) {
+	if y < 0 || y >= ChunkHeight {
+		err = error.NewError(fmt.Sprintf("y=%d is outside the chunk", y), ErrOutOfRange)
+		return
+	}
+	if err = c.LoadArrays(); err != nil {
+		return
+	}
+	for x := int32(0); x < ChunkWidth; x++ {
+		for z := int32(0); z < ChunkDepth; z++ {
+			i := blockIndex(x, y, z)
+			ids[x+z*ChunkWidth] = c.Level.Blocks[i]
+			data[x+z*ChunkWidth] = getNibble(c.Level.Data, i)
+		}
+	}
+	return
+}
+
+// Slice assembles the layer of blocks at height y across every chunk in region.
+// The arrays are indexed x + z*width, where width is region.Width()*ChunkWidth and x
+// and z count blocks from the region's minimum corner.  Each chunk's layer is read
+// under its RLock.  Columns in chunks that do not exist get the block id fill and
+// data 0.
+func (world *World) Slice(region *Region, y int32, fill byte) (ids []byte, data []byte, err os.Error) {
+	if region == nil {
+		err = error.NewError("a region is required", nil)
+		return
+	}
+	if y < 0 || y >= ChunkHeight {
+		err = error.NewError(fmt.Sprintf("y=%d is outside the world", y), ErrOutOfRange)
+		return
+	}
+	width := int(region.Width()) * ChunkWidth
+	n := width * int(region.Depth()) * ChunkDepth
+	ids = make([]byte, n)
+	data = make([]byte, n)
+	for cx := region.MinX; cx <= region.MaxX; cx++ {
+		for cz := region.MinZ; cz <= region.MaxZ; cz++ {
+			ox := int(cx-region.MinX) * ChunkWidth
+			oz := int(cz-region.MinZ) * ChunkDepth
+			if !world.ChunkExists(cx, cz) {
+				for z := 0; z < ChunkDepth; z++ {
+					for x := 0; x < ChunkWidth; x++ {
+						ids[ox+x+(oz+z)*width] = fill
+					}
+				}
+				continue
+			}
+			var c *Chunk
+			if c, err = world.GetChunk(cx, cz); err != nil {
+				err = error.InChunk(cx, cz).Error("could not get chunk", err)
+				return
+			}
+			c.RLock()
+			cids, cdata, err := c.Slice(y)
+			c.RUnlock()
+			if err != nil {
+				return nil, nil, err
+			}
+			for z := 0; z < ChunkDepth; z++ {
+				row := (oz + z) * width
+				copy(ids[row+ox:row+ox+ChunkWidth], cids[z*ChunkWidth:(z+1)*ChunkWidth])
+				copy(data[row+ox:row+ox+ChunkWidth], cdata[z*ChunkWidth:(z+1)*ChunkWidth])
+			}
+		}
+	}
+	return
+}
+
+// updateHeightMap recomputes Level.HeightMap if block writes have invalidated it.
+func (c *Chunk) updateHeightMap() os.Error {
+	if !c.heightMapStale {
+		return nil
+	}
+	if err := c.LoadArrays(); err != nil {
+		return err
+	}
+	for x := int32(0); x < ChunkWidth; x++ {
+		for z := int32(0); z < ChunkDepth; z++ {
+			base := blockIndex(x, 0, z)
+			y := ChunkHeight
+			for y > 0 && skyTransparent[c.Level.Blocks[base+y-1]] {
+				y--
+			}
+			c.Level.HeightMap[x+z*ChunkWidth] = byte(y)
+		}
+	}
+	c.heightMapStale = false
+	return nil
+}
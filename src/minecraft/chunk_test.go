@@ -0,0 +1,273 @@
+package world
+
+import "os"
+import "testing"
+
+// pattern returns a deterministic, non-trivial block id for absolute coordinates.
+func pattern(x, y, z int32) byte {
+	return byte((x*7 + y*13 + z*31) & 0x7f)
+}
+
+func makeBox(dims [3]int32, x0, y0, z0 int32) (blocks []byte, data []byte) {
+	n := dims[0] * dims[1] * dims[2]
+	blocks = make([]byte, n)
+	data = make([]byte, n)
+	for x := int32(0); x < dims[0]; x++ {
+		for z := int32(0); z < dims[2]; z++ {
+			for y := int32(0); y < dims[1]; y++ {
+				i := y + z*dims[1] + x*dims[1]*dims[2]
+				blocks[i] = pattern(x0+x, y0+y, z0+z)
+				data[i] = blocks[i] & 0x0f
+			}
+		}
+	}
+	return
+}
+
+func TestSetRegionBlocks(t *testing.T) {
+	c := newChunk(0, 0)
+	dims := [3]int32{3, 4, 5}
+	blocks, data := makeBox(dims, 2, 10, 7)
+	if err := c.SetRegionBlocks(2, 10, 7, dims, blocks, data); err != nil {
+		t.Fatal(err)
+	}
+	for x := int32(0); x < ChunkWidth; x++ {
+		for z := int32(0); z < ChunkDepth; z++ {
+			for y := int32(0); y < ChunkHeight; y++ {
+				id, d, err := c.BlockAt(x, y, z)
+				if err != nil {
+					t.Fatal(err)
+				}
+				var want byte
+				if x >= 2 && x < 5 && y >= 10 && y < 14 && z >= 7 && z < 12 {
+					want = pattern(x, y, z)
+				}
+				if id != want || d != want&0x0f {
+					t.Fatalf("(%d, %d, %d): expected %d:%d, got %d:%d", x, y, z, want, want&0x0f, id, d)
+				}
+			}
+		}
+	}
+	if !c.Dirty() {
+		t.Error("chunk not marked dirty")
+	}
+	if c.HeightMapValid() {
+		t.Error("heightmap not invalidated")
+	}
+}
+
+func TestSetRegionBlocksOutOfBounds(t *testing.T) {
+	c := newChunk(0, 0)
+	dims := [3]int32{4, 1, 1}
+	blocks, _ := makeBox(dims, 0, 0, 0)
+	if err := c.SetRegionBlocks(13, 0, 0, dims, blocks, nil); err == nil {
+		t.Error("expected an error for a box overhanging the chunk")
+	}
+	if c.Dirty() {
+		t.Error("rejected write marked the chunk dirty")
+	}
+}
+
+func TestBlitBlocksSeams(t *testing.T) {
+	w := &World{Chunks: make(map[XZ]*Chunk)}
+	for cx := int32(-2); cx <= 1; cx++ {
+		for cz := int32(-2); cz <= 1; cz++ {
+			w.Chunks[MakeXZ(cx, cz)] = newChunk(cx, cz)
+		}
+	}
+	// straddles the seams at x=0 and z=0 and ends exactly on the x=-16 seam
+	x0, y0, z0 := int32(-16), int32(60), int32(-5)
+	dims := [3]int32{20, 3, 9}
+	blocks, data := makeBox(dims, x0, y0, z0)
+	if err := w.BlitBlocks(x0, y0, z0, dims, blocks, data); err != nil {
+		t.Fatal(err)
+	}
+	for x := int32(-32); x < 32; x++ {
+		for z := int32(-32); z < 32; z++ {
+			c := w.Chunks[MakeXZ(x>>4, z>>4)]
+			for y := y0 - 1; y <= y0+dims[1]; y++ {
+				id, d, _ := c.BlockAt(x&15, y, z&15)
+				var want byte
+				if x >= x0 && x < x0+dims[0] && y >= y0 && y < y0+dims[1] && z >= z0 && z < z0+dims[2] {
+					want = pattern(x, y, z)
+				}
+				if id != want || d != want&0x0f {
+					t.Fatalf("(%d, %d, %d): expected %d:%d, got %d:%d", x, y, z, want, want&0x0f, id, d)
+				}
+			}
+		}
+	}
+	for xz, c := range w.Chunks {
+		spanned := c.Level.XPos >= -1 && c.Level.XPos <= 0 && c.Level.ZPos >= -1 && c.Level.ZPos <= 0
+		if c.Dirty() != spanned {
+			t.Errorf("chunk %v: dirty=%v, expected %v", xz, c.Dirty(), spanned)
+		}
+	}
+}
+
+func BenchmarkSetBlockFullChunk(b *testing.B) {
+	c := newChunk(0, 0)
+	blocks, _ := makeBox([3]int32{ChunkWidth, ChunkHeight, ChunkDepth}, 0, 0, 0)
+	b.SetBytes(chunkBlocks)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for x := int32(0); x < ChunkWidth; x++ {
+			for z := int32(0); z < ChunkDepth; z++ {
+				for y := int32(0); y < ChunkHeight; y++ {
+					c.SetBlock(x, y, z, blocks[blockIndex(x, y, z)], 0)
+				}
+			}
+		}
+	}
+}
+
+func BenchmarkSetBlocksFullChunk(b *testing.B) {
+	c := newChunk(0, 0)
+	blocks, _ := makeBox([3]int32{ChunkWidth, ChunkHeight, ChunkDepth}, 0, 0, 0)
+	b.SetBytes(chunkBlocks)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		c.SetBlocks(blocks)
+	}
+}
+
+func BenchmarkSetRegionBlocksFullChunk(b *testing.B) {
+	c := newChunk(0, 0)
+	dims := [3]int32{ChunkWidth, ChunkHeight, ChunkDepth}
+	blocks, _ := makeBox(dims, 0, 0, 0)
+	b.SetBytes(chunkBlocks)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		c.SetRegionBlocks(0, 0, 0, dims, blocks, nil)
+	}
+}
+
+func patternChunk(cx, cz int32) *Chunk {
+	c := newChunk(cx, cz)
+	dims := [3]int32{ChunkWidth, ChunkHeight, ChunkDepth}
+	blocks, data := makeBox(dims, cx*ChunkWidth, 0, cz*ChunkDepth)
+	c.SetRegionBlocks(0, 0, 0, dims, blocks, data)
+	return c
+}
+
+func TestChunkSlice(t *testing.T) {
+	c := patternChunk(0, 0)
+	for _, y := range []int32{0, 40, 127} {
+		ids, data, err := c.Slice(y)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for x := int32(0); x < ChunkWidth; x++ {
+			for z := int32(0); z < ChunkDepth; z++ {
+				id, d, _ := c.BlockAt(x, y, z)
+				if ids[x+z*ChunkWidth] != id || data[x+z*ChunkWidth] != d {
+					t.Fatalf("y=%d (%d, %d): slice has %d:%d, BlockAt has %d:%d",
+						y, x, z, ids[x+z*ChunkWidth], data[x+z*ChunkWidth], id, d)
+				}
+			}
+		}
+	}
+	if _, _, err := c.Slice(128); err == nil {
+		t.Error("expected an error for y=128")
+	}
+}
+
+func TestChunkSliceOrder(t *testing.T) {
+	c := newChunk(0, 0)
+	c.SetBlock(3, 40, 0, 1, 0)
+	c.SetBlock(0, 40, 3, 2, 0)
+	ids, _, _ := c.Slice(40)
+	if ids[3] != 1 {
+		t.Errorf("expected x to vary fastest; ids[3] = %d", ids[3])
+	}
+	if ids[3*ChunkWidth] != 2 {
+		t.Errorf("expected z to step by ChunkWidth; ids[48] = %d", ids[3*ChunkWidth])
+	}
+}
+
+func TestWorldSlice(t *testing.T) {
+	w := &World{Chunks: make(map[XZ]*Chunk)}
+	w.Chunks[MakeXZ(-1, 0)] = patternChunk(-1, 0)
+	w.Chunks[MakeXZ(0, 0)] = patternChunk(0, 0)
+	w.Chunks[MakeXZ(-1, 1)] = patternChunk(-1, 1)
+	// (0, 1) is missing
+	region := NewRegion(0, 1, -1, 0)
+	const fill = 0xff
+	ids, data, err := w.Slice(region, 40, fill)
+	if err != nil {
+		t.Fatal(err)
+	}
+	width := int32(2 * ChunkWidth)
+	for bx := int32(-16); bx < 16; bx++ {
+		for bz := int32(0); bz < 32; bz++ {
+			i := (bx + 16) + bz*width
+			if bx >= 0 && bz >= 16 {
+				if ids[i] != fill || data[i] != 0 {
+					t.Fatalf("(%d, %d): expected fill, got %d:%d", bx, bz, ids[i], data[i])
+				}
+				continue
+			}
+			want := pattern(bx, 40, bz)
+			if ids[i] != want || data[i] != want&0x0f {
+				t.Fatalf("(%d, %d): expected %d:%d, got %d:%d", bx, bz, want, want&0x0f, ids[i], data[i])
+			}
+		}
+	}
+}
+
+func TestHighestBlockAt(t *testing.T) {
+	c := newChunk(0, 0)
+	c.SetBlock(3, 20, 4, BlockStone, 0)
+	c.SetBlock(3, 64, 4, BlockTorch, 0)
+	c.SetBlock(0, 127, 0, BlockGlass, 0)
+	for _, col := range []struct {
+		x, z int32
+		y    int32
+		id   byte
+	}{
+		{3, 4, 64, BlockTorch},
+		{0, 0, 127, BlockGlass},
+		{15, 15, -1, BlockAir},
+	} {
+		y, id, err := c.HighestBlockAt(col.x, col.z)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if y != col.y || id != col.id {
+			t.Errorf("column (%d, %d): expected %d at %d, got %d at %d", col.x, col.z, col.id, col.y, id, y)
+		}
+	}
+	if _, _, err := c.HighestBlockAt(16, 0); err == nil {
+		t.Error("expected an error for column (16, 0)")
+	}
+}
+
+func TestCreateChunk(t *testing.T) {
+	dir := makeTestWorld(t, testChunkPayload(0, 0, nil, nil))
+	defer os.RemoveAll(dir)
+	w, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	c, err := w.CreateChunk(-3, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Level.XPos != -3 || c.Level.ZPos != 2 || !c.Dirty() || w.Chunks[MakeXZ(-3, 2)] != c {
+		t.Error("expected a resident chunk to be written, got ", c.Level.XPos, c.Level.ZPos, c.Dirty())
+	}
+	if _, err = w.CreateChunk(-3, 2); err == nil {
+		t.Error("expected an error for a resident chunk")
+	}
+	if _, err = w.CreateChunk(0, 0); err == nil {
+		t.Error("expected an error for a chunk on disk")
+	}
+	if err = w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = os.Stat(chunkPath(w.dir, -3, 2)); err != nil {
+		t.Error("expected the chunk to be written, got ", err)
+	}
+}
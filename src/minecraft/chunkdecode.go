@@ -0,0 +1,194 @@
+package world
+
+import "minecraft/error"
+import "minecraft/nbt"
+
+import "fmt"
+import "os"
+
+// levelTags are the tags of a chunk's Level that decodeLevel requires, with
+// their types.
+var levelTags = []struct {
+	name  string
+	ttype nbt.TagType
+}{
+	{"Blocks", nbt.ByteArray},
+	{"Data", nbt.ByteArray},
+	{"SkyLight", nbt.ByteArray},
+	{"HeightMap", nbt.ByteArray},
+	{"BlockLight", nbt.ByteArray},
+	{"Entities", nbt.List},
+	{"TileEntities", nbt.List},
+	{"LastUpdate", nbt.Long},
+	{"xPos", nbt.Int},
+	{"zPos", nbt.Int},
+	{"TerrainPopulated", nbt.Byte},
+}
+
+// readChunk decodes the stored chunk at (x, z) without making it resident.
+func (world *World) readChunk(x int32, z int32) (c *Chunk, err os.Error) {
+	defer func() {
+		if err != nil {
+			err = error.InChunk(x, z).Error("could not load chunk", err)
+		}
+	}()
+	raw, giveBack, err := world.readPayload(x, z)
+	if err != nil {
+		return nil, err
+	}
+	defer giveBack()
+	return decodeChunkBytes(raw, &world.pool)
+}
+
+// decodeChunkBytes decodes a chunk from raw, its payload as a ChunkStore keeps
+// it, into arrays from pool.
+func decodeChunkBytes(raw []byte, pool *arrayPool) (c *Chunk, err os.Error) {
+	r, err := payloadReader(raw)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	if c, err = decodeChunk(nbt.NewDecoder(r), pool); err != nil {
+		err = error.Wrap(ErrCorruptChunk, err)
+	}
+	return
+}
+
+// decodeChunk decodes a chunk as toChunk would, but straight from its tags,
+// without building the maps of the chunk's compounds: the tags of Level are
+// read into the chunk as they come, and its five arrays into one allocation.
+// Only the entities and tile entities, which toEntity and toTileEntity decode,
+// are read as compounds.  A chunk missing tags or holding tags of the wrong
+// types is an error, as is one whose arrays are not the sizes the game writes,
+// which fails with ErrCorruptChunk.  The arrays are read into an allocation
+// from pool.
+func decodeChunk(d *nbt.Decoder, pool *arrayPool) (c *Chunk, err os.Error) {
+	tag, err := d.ReadNamedTag()
+	if err != nil {
+		return nil, error.NewError("could not read named tag", err)
+	}
+	if tag.Type != nbt.Compound {
+		return nil, error.NewError(fmt.Sprint("expected compound type, got ", tag.Type), nil)
+	}
+	for {
+		if tag, err = d.ReadNamedTag(); err != nil {
+			return nil, error.NewError("could not read named tag", err)
+		}
+		switch {
+		case tag.Type == nbt.End:
+			if c == nil {
+				return nil, tagError("Level", "compound", nil)
+			}
+			return c, nil
+		case tag.Name == "Level" && tag.Type == nbt.Compound && c == nil:
+			if c, err = decodeLevel(d, pool); err != nil {
+				return nil, error.NewError("could not read Level", err)
+			}
+		default:
+			if err = d.SkipPayload(tag.Type); err != nil {
+				return nil, error.NewError(fmt.Sprint("could not read payload of ", tag.Name), err)
+			}
+		}
+	}
+	panic("shouldn't get here")
+}
+
+// decodeLevel decodes the tags of a chunk's Level compound for decodeChunk.
+func decodeLevel(d *nbt.Decoder, pool *arrayPool) (*Chunk, os.Error) {
+	// the arrays share one allocation; none of them is ever appended to
+	arrays := pool.get()
+	blocks := arrays[:chunkBlocks]
+	data := arrays[chunkBlocks : chunkBlocks+chunkNibbles]
+	skyLight := arrays[chunkBlocks+chunkNibbles : chunkBlocks+2*chunkNibbles]
+	blockLight := arrays[chunkBlocks+2*chunkNibbles : chunkBlocks+3*chunkNibbles]
+	heightMap := arrays[chunkBlocks+3*chunkNibbles:]
+
+	var l Level
+	var entityList, tileEntityList []interface{}
+	var seen uint // a bit for each of levelTags
+	for {
+		tag, err := d.ReadNamedTag()
+		if err != nil {
+			return nil, error.NewError("could not read named tag", err)
+		}
+		if tag.Type == nbt.End {
+			break
+		}
+		i := levelTag(tag.Name)
+		if i < 0 {
+			if err = d.SkipPayload(tag.Type); err != nil {
+				return nil, error.NewError(fmt.Sprint("could not read payload of ", tag.Name), err)
+			}
+			continue
+		}
+		if want := levelTags[i].ttype; tag.Type != want {
+			return nil, error.NewError(fmt.Sprintf("tag %q: expected type %d, got %d", tag.Name, want, tag.Type), nil)
+		}
+		seen |= 1 << uint(i)
+		switch tag.Name {
+		case "Blocks":
+			l.Blocks, err = d.ReadByteArrayInto(blocks)
+		case "Data":
+			l.Data, err = d.ReadByteArrayInto(data)
+		case "SkyLight":
+			l.SkyLight, err = d.ReadByteArrayInto(skyLight)
+		case "HeightMap":
+			l.HeightMap, err = d.ReadByteArrayInto(heightMap)
+		case "BlockLight":
+			l.BlockLight, err = d.ReadByteArrayInto(blockLight)
+		case "Entities":
+			entityList, err = d.ReadList()
+		case "TileEntities":
+			tileEntityList, err = d.ReadList()
+		case "LastUpdate":
+			l.LastUpdate, err = d.ReadInt64()
+		case "xPos":
+			l.XPos, err = d.ReadInt32()
+		case "zPos":
+			l.ZPos, err = d.ReadInt32()
+		case "TerrainPopulated":
+			l.TerrainPopulated, err = d.ReadInt8()
+		}
+		if err != nil {
+			return nil, error.NewError(fmt.Sprint("could not read payload of ", tag.Name), err)
+		}
+	}
+	for i, t := range levelTags {
+		if seen&(1<<uint(i)) == 0 {
+			return nil, tagError(t.name, "", nil)
+		}
+	}
+	for _, array := range []struct {
+		name string
+		b    []byte
+		size int
+	}{
+		{"Blocks", l.Blocks, chunkBlocks},
+		{"Data", l.Data, chunkNibbles},
+		{"SkyLight", l.SkyLight, chunkNibbles},
+		{"HeightMap", l.HeightMap, chunkColumns},
+		{"BlockLight", l.BlockLight, chunkNibbles},
+	} {
+		if len(array.b) != array.size {
+			return nil, error.NewError(fmt.Sprint(len(array.b), " bytes of ", array.name, " in chunk"), ErrCorruptChunk)
+		}
+	}
+	c, err := makeChunk(l, entityList, tileEntityList)
+	if err != nil {
+		return nil, err
+	}
+	c.alloc = arrays
+	c.Warnings = append(c.Warnings, checkSpawnerBlocks(c)...)
+	return c, nil
+}
+
+// levelTag returns the index in levelTags of the tag of the given name, or -1
+// for the tags decodeLevel skips.
+func levelTag(name string) int {
+	for i, t := range levelTags {
+		if t.name == name {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,145 @@
+package world
+
+import "minecraft/nbt"
+
+import "bytes"
+import "os"
+import "runtime"
+import "strings"
+import "testing"
+
+// decodeBothWays encodes payload and decodes it with decodeChunk and with
+// toChunk, as readChunk did before it.
+func decodeBothWays(t *testing.T, payload map[string]interface{}) (fast, slow *Chunk, err os.Error) {
+	buf := new(bytes.Buffer)
+	if err = nbt.WriteTagCompound(buf, "", payload); err != nil {
+		t.Fatal(err)
+	}
+	encoded := buf.Bytes()
+	if fast, err = decodeChunk(nbt.NewDecoder(bytes.NewBuffer(encoded)), new(arrayPool)); err != nil {
+		return
+	}
+	_, reread, err := nbt.ReadTagCompound(bytes.NewBuffer(encoded))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if slow, err = toChunk(reread); err != nil {
+		t.Fatal(err)
+	}
+	return
+}
+
+func TestDecodeChunk(t *testing.T) {
+	busy := testChunkPayload(-1, 0, []interface{}{pigFixture, itemAt(-8, 70, 3)}, []interface{}{chestFixture, spawnerFixture})
+	busy["Level"].(map[string]interface{})["Blocks"].([]byte)[blockIndex(3, 20, 7)] = BlockMobSpawner
+	busy["Level"].(map[string]interface{})["SkyLight"].([]byte)[100] = 0xf3
+	odd := testChunkPayload(4, -9, nil, []interface{}{spawnerFixture})
+	odd["Level"].(map[string]interface{})["Unknown"] = "dropped"
+	odd["Other"] = map[string]interface{}{"Blocks": []byte{9}}
+
+	for i, payload := range []map[string]interface{}{testChunkPayload(0, 0, nil, nil), busy, odd} {
+		fast, slow, err := decodeBothWays(t, payload)
+		if err != nil {
+			t.Errorf("payload %d: %v", i, err)
+			continue
+		}
+		if !nbt.Equal(mustFromChunk(fast), mustFromChunk(slow)) {
+			t.Errorf("payload %d: expected the chunk toChunk decodes", i)
+		}
+		if len(fast.Warnings) != len(slow.Warnings) {
+			t.Errorf("payload %d: expected %d warnings, got %d", i, len(slow.Warnings), len(fast.Warnings))
+		}
+		for j := range fast.Warnings {
+			if j < len(slow.Warnings) && fast.Warnings[j].String() != slow.Warnings[j].String() {
+				t.Errorf("payload %d: expected %q, got %q", i, slow.Warnings[j], fast.Warnings[j])
+			}
+		}
+		for _, te := range fast.Level.TileEntities {
+			if te.tileEntityBase().chunk != fast {
+				t.Errorf("payload %d: expected tile entities to know their chunk", i)
+			}
+		}
+	}
+
+	for _, c := range []struct {
+		change  func(level map[string]interface{})
+		want    string
+		corrupt bool // whether the error must be ErrCorruptChunk
+	}{
+		{func(level map[string]interface{}) {
+			level["Blocks"] = nil, false
+		}, `missing tag "Blocks"`, false},
+		{func(level map[string]interface{}) { level["xPos"] = int16(3) }, `tag "xPos"`, false},
+		{func(level map[string]interface{}) { level["Entities"] = []interface{}{int8(1)} }, "entity 0", false},
+		{func(level map[string]interface{}) { level["Blocks"] = make([]byte, chunkBlocks-1) }, "32767 bytes of Blocks", true},
+		{func(level map[string]interface{}) { level["Data"] = make([]byte, chunkNibbles+1) }, "16385 bytes of Data", true},
+		{func(level map[string]interface{}) { level["SkyLight"] = []byte{} }, "0 bytes of SkyLight", true},
+		{func(level map[string]interface{}) { level["BlockLight"] = make([]byte, chunkBlocks) }, "32768 bytes of BlockLight", true},
+		{func(level map[string]interface{}) { level["HeightMap"] = []byte{1, 2, 3} }, "3 bytes of HeightMap", true},
+	} {
+		payload := testChunkPayload(0, 0, nil, nil)
+		c.change(payload["Level"].(map[string]interface{}))
+		buf := new(bytes.Buffer)
+		if err := nbt.WriteTagCompound(buf, "", payload); err != nil {
+			t.Fatal(err)
+		}
+		_, err := decodeChunk(nbt.NewDecoder(buf), new(arrayPool))
+		if err == nil || !strings.Contains(err.String(), c.want) {
+			t.Errorf("expected an error mentioning %s, got %v", c.want, err)
+		} else if c.corrupt && !IsError(err, ErrCorruptChunk) {
+			t.Errorf("expected ErrCorruptChunk for %s, got %v", c.want, err)
+		}
+	}
+}
+
+// benchmarkLoadChunk reads a chunk holding a few entities and tile entities with
+// read, logging the allocations per chunk.
+func benchmarkLoadChunk(b *testing.B, read func(w *World) os.Error) {
+	b.StopTimer()
+	var entities []interface{}
+	for i := 0; i < 8; i++ {
+		entities = append(entities, itemAt(float64(i), 64, 3))
+	}
+	entities = append(entities, pigFixture)
+	dir, err := writeTestWorld(testChunkPayload(0, 0, entities, []interface{}{chestFixture, spawnerFixture}))
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	w, err := Open(dir)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer w.Close()
+	runtime.UpdateMemStats()
+	mallocs := runtime.MemStats.Mallocs
+	b.StartTimer()
+
+	for i := 0; i < b.N; i++ {
+		if err = read(w); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.StopTimer()
+	runtime.UpdateMemStats()
+	b.Logf("%d allocations a chunk", (runtime.MemStats.Mallocs-mallocs)/uint64(b.N))
+}
+
+func BenchmarkLoadChunk(b *testing.B) {
+	benchmarkLoadChunk(b, func(w *World) os.Error {
+		_, err := w.readChunk(0, 0)
+		return err
+	})
+}
+
+// BenchmarkLoadChunkMaps decodes the chunk through maps, as readChunk once did,
+// for comparison.
+func BenchmarkLoadChunkMaps(b *testing.B) {
+	benchmarkLoadChunk(b, func(w *World) os.Error {
+		_, chunkmap, err := nbt.Load(chunkPath(w.dir, 0, 0))
+		if err == nil {
+			_, err = toChunk(chunkmap)
+		}
+		return err
+	})
+}
@@ -0,0 +1,358 @@
+package world
+
+import "minecraft/error"
+
+import "bytes"
+import "encoding/base64"
+import "fmt"
+import "io"
+import "io/ioutil"
+import "json"
+import "math"
+import "os"
+import "strconv"
+
+// JSON encoding of whole chunks, for debugging, outside analysis and test
+// fixtures.  A chunk is an object with these members, in this order, each on a
+// line of its own:
+//
+//	"xPos", "zPos"    its chunk coordinates
+//	"lastUpdate"      the tick it was last saved, as a string like other longs
+//	"terrainPopulated"
+//	"blocks", "data", "skyLight", "blockLight", "heightMap"
+//	"entities"        its entities, one per line, encoded as by Entity.MarshalJSON
+//	"tileEntities"    its tile entities, one per line, encoded likewise
+//
+// The bulk arrays are written as ChunkJSONOptions.Arrays asks: as the base64 of
+// the bytes the game stores, with data and light two values to a byte; as arrays
+// of integers nested x outermost, then z, then y, one integer per value, and only
+// x then z for the height map; or not at all.
+
+// ArrayEncoding chooses how ExportChunkJSON writes a chunk's bulk arrays.
+type ArrayEncoding int
+
+const (
+	ArraysBase64  ArrayEncoding = iota // strings of base64
+	ArraysNested                       // [x][z][y] arrays of integers
+	ArraysOmitted                      // left out
+)
+
+// ChunkJSONOptions control how ExportChunkJSON writes a chunk.
+type ChunkJSONOptions struct {
+	Arrays ArrayEncoding
+}
+
+// chunkArray is a bulk array of a chunk and its member in the JSON encoding.
+type chunkArray struct {
+	name    string
+	array   func(l *Level) *[]byte
+	nibbles bool // two values to a byte
+	columns bool // one value per column rather than per block
+}
+
+var chunkArrays = []chunkArray{
+	{"blocks", func(l *Level) *[]byte { return &l.Blocks }, false, false},
+	{"data", func(l *Level) *[]byte { return &l.Data }, true, false},
+	{"skyLight", func(l *Level) *[]byte { return &l.SkyLight }, true, false},
+	{"blockLight", func(l *Level) *[]byte { return &l.BlockLight }, true, false},
+	{"heightMap", func(l *Level) *[]byte { return &l.HeightMap }, false, true},
+}
+
+// size returns the length of the array as the game stores it.
+func (a chunkArray) size() int {
+	switch {
+	case a.columns:
+		return chunkColumns
+	case a.nibbles:
+		return chunkNibbles
+	}
+	return chunkBlocks
+}
+
+// nested returns the array as nested arrays of integers.
+func (a chunkArray) nested(b []byte) []interface{} {
+	xs := make([]interface{}, ChunkWidth)
+	for x := int32(0); x < ChunkWidth; x++ {
+		zs := make([]interface{}, ChunkDepth)
+		for z := int32(0); z < ChunkDepth; z++ {
+			if a.columns {
+				zs[z] = int(b[x+z*ChunkWidth])
+				continue
+			}
+			ys := make([]interface{}, ChunkHeight)
+			for y := int32(0); y < ChunkHeight; y++ {
+				if i := blockIndex(x, y, z); a.nibbles {
+					ys[y] = int(getNibble(b, i))
+				} else {
+					ys[y] = int(b[i])
+				}
+			}
+			zs[z] = ys
+		}
+		xs[x] = zs
+	}
+	return xs
+}
+
+// fromNested decodes nested arrays of integers into the array.
+func (a chunkArray) fromNested(v interface{}) (b []byte, err os.Error) {
+	b = make([]byte, a.size())
+	max := int64(math.MaxUint8)
+	if a.nibbles {
+		max = 15
+	}
+	xs, err := jsonArray(v, ChunkWidth)
+	for x := int32(0); x < ChunkWidth && err == nil; x++ {
+		var zs []interface{}
+		if zs, err = jsonArray(xs[x], ChunkDepth); err != nil {
+			break
+		}
+		for z := int32(0); z < ChunkDepth && err == nil; z++ {
+			var n int64
+			if a.columns {
+				n, err = jsonInteger(zs[z], 0, max)
+				b[x+z*ChunkWidth] = byte(n)
+				continue
+			}
+			var ys []interface{}
+			if ys, err = jsonArray(zs[z], ChunkHeight); err != nil {
+				break
+			}
+			for y := int32(0); y < ChunkHeight && err == nil; y++ {
+				n, err = jsonInteger(ys[y], 0, max)
+				if i := blockIndex(x, y, z); a.nibbles {
+					setNibble(b, i, byte(n))
+				} else {
+					b[i] = byte(n)
+				}
+			}
+		}
+	}
+	return
+}
+
+// jsonArray returns v, a parsed JSON value, as an array of n elements.
+func jsonArray(v interface{}, n int) ([]interface{}, os.Error) {
+	a, ok := v.([]interface{})
+	if !ok || len(a) != n {
+		return nil, error.NewError(fmt.Sprintf("expected an array of %d elements", n), nil)
+	}
+	return a, nil
+}
+
+// ExportChunkJSON writes the chunk at (x, z) as JSON, in the format described at
+// the top of this file.  The chunk is read from disk if it is not resident, and is
+// not kept.
+func (world *World) ExportChunkJSON(w io.Writer, x, z int32, opts ChunkJSONOptions) os.Error {
+	c, err := world.peekChunk(x, z)
+	if err != nil {
+		return err
+	}
+	if c == nil {
+		return error.InChunk(x, z).Error("missing chunk", nil)
+	}
+	if err = c.updateHeightMap(); err != nil {
+		return err
+	}
+	buf := new(bytes.Buffer)
+	if err = c.writeJSON(buf, opts); err != nil {
+		return error.InChunk(x, z).Error("could not encode chunk", err)
+	}
+	_, err = w.Write(buf.Bytes())
+	return err
+}
+
+func (c *Chunk) writeJSON(buf *bytes.Buffer, opts ChunkJSONOptions) os.Error {
+	l := &c.Level
+	obj := jsonObject{
+		{"xPos", l.XPos},
+		{"zPos", l.ZPos},
+		{"lastUpdate", strconv.Itoa64(l.LastUpdate)},
+		{"terrainPopulated", l.TerrainPopulated},
+	}
+	for _, a := range chunkArrays {
+		b := *a.array(l)
+		switch opts.Arrays {
+		case ArraysBase64:
+			s := make([]byte, base64.StdEncoding.EncodedLen(len(b)))
+			base64.StdEncoding.Encode(s, b)
+			obj = append(obj, jsonField{a.name, string(s)})
+		case ArraysNested:
+			obj = append(obj, jsonField{a.name, a.nested(b)})
+		case ArraysOmitted:
+		default:
+			return error.NewError(fmt.Sprintf("unknown array encoding %d", opts.Arrays), nil)
+		}
+	}
+	buf.WriteString("{\n")
+	for _, f := range obj {
+		writeJSON(buf, f.name)
+		buf.WriteString(": ")
+		if err := writeJSON(buf, f.value); err != nil {
+			return error.NewError(f.name, err)
+		}
+		buf.WriteString(",\n")
+	}
+	var entities, tileEntities [][]byte
+	for _, e := range l.Entities {
+		b, err := e.MarshalJSON()
+		if err != nil {
+			return err
+		}
+		entities = append(entities, b)
+	}
+	for _, te := range l.TileEntities {
+		b, err := marshalTileEntity(te)
+		if err != nil {
+			return error.NewError(fmt.Sprintf("%s at (%d, %d, %d)", te.Id(), te.X(), te.Y(), te.Z()), err)
+		}
+		tileEntities = append(tileEntities, b)
+	}
+	writeJSONLines(buf, "entities", entities)
+	buf.WriteString(",\n")
+	writeJSONLines(buf, "tileEntities", tileEntities)
+	buf.WriteString("\n}\n")
+	return nil
+}
+
+// writeJSONLines writes a member holding an array of encoded values, one per line.
+func writeJSONLines(buf *bytes.Buffer, name string, values [][]byte) {
+	writeJSON(buf, name)
+	if len(values) == 0 {
+		buf.WriteString(": []")
+		return
+	}
+	buf.WriteString(": [\n")
+	for i, v := range values {
+		if i > 0 {
+			buf.WriteString(",\n")
+		}
+		buf.Write(v)
+	}
+	buf.WriteString("\n]")
+}
+
+// ImportChunkJSON reads a chunk written by ExportChunkJSON.  Bulk arrays that were
+// left out are zero, and the height map is recomputed from the blocks when it is
+// missing.  The chunk is not added to any world.
+func ImportChunkJSON(r io.Reader) (*Chunk, os.Error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, error.NewError("could not read chunk", err)
+	}
+	var v interface{}
+	if err = json.Unmarshal(data, &v); err != nil {
+		return nil, error.NewError("could not parse chunk", err)
+	}
+	c, err := chunkFromJSON(v)
+	if err != nil {
+		return nil, error.NewError("could not decode chunk", err)
+	}
+	return c, nil
+}
+
+func chunkFromJSON(v interface{}) (c *Chunk, err os.Error) {
+	obj, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, error.NewError(fmt.Sprintf("expected an object, got %v", v), nil)
+	}
+	known := map[string]bool{"xPos": true, "zPos": true, "lastUpdate": true, "terrainPopulated": true, "entities": true, "tileEntities": true}
+	for _, a := range chunkArrays {
+		known[a.name] = true
+	}
+	for name := range obj {
+		if !known[name] {
+			return nil, error.NewError(fmt.Sprintf("unknown member %q", name), nil)
+		}
+	}
+
+	var x, z, populated int64
+	if x, err = jsonInteger(obj["xPos"], math.MinInt32, math.MaxInt32); err != nil {
+		return nil, error.NewError("member \"xPos\"", err)
+	}
+	if z, err = jsonInteger(obj["zPos"], math.MinInt32, math.MaxInt32); err != nil {
+		return nil, error.NewError("member \"zPos\"", err)
+	}
+	if populated, err = jsonInteger(obj["terrainPopulated"], math.MinInt8, math.MaxInt8); err != nil {
+		return nil, error.NewError("member \"terrainPopulated\"", err)
+	}
+	c = newChunk(int32(x), int32(z))
+	c.Level.TerrainPopulated = int8(populated)
+	if s, ok := obj["lastUpdate"].(string); !ok {
+		return nil, error.NewError(fmt.Sprintf("member \"lastUpdate\": expected a long as a string, got %v", obj["lastUpdate"]), nil)
+	} else if c.Level.LastUpdate, err = strconv.Atoi64(s); err != nil {
+		return nil, error.NewError("member \"lastUpdate\"", err)
+	}
+
+	_, hasHeightMap := obj["heightMap"]
+	for _, a := range chunkArrays {
+		var b []byte
+		switch value := obj[a.name].(type) {
+		case nil:
+			continue
+		case string:
+			b = make([]byte, base64.StdEncoding.DecodedLen(len(value)))
+			var n int
+			if n, err = base64.StdEncoding.Decode(b, []byte(value)); err == nil && n != a.size() {
+				err = error.NewError(fmt.Sprintf("expected %d bytes, got %d", a.size(), n), nil)
+			}
+			b = b[:n]
+		default:
+			b, err = a.fromNested(value)
+		}
+		if err != nil {
+			return nil, error.NewError(fmt.Sprintf("member %q", a.name), err)
+		}
+		*a.array(&c.Level) = b
+	}
+	c.heightMapStale = !hasHeightMap
+
+	entities, ok := obj["entities"].([]interface{})
+	if !ok {
+		return nil, error.NewError("member \"entities\": expected an array", nil)
+	}
+	for i, ev := range entities {
+		var payload map[string]interface{}
+		var e *Entity
+		if payload, err = fromJSONObject(ev, entityMembers); err == nil {
+			e, err = toEntity(payload)
+		}
+		if err == nil {
+			err = e.validate()
+		}
+		if err != nil {
+			return nil, error.NewError(fmt.Sprintf("entity %d", i), err)
+		}
+		c.Level.Entities = append(c.Level.Entities, e)
+	}
+	tileEntities, ok := obj["tileEntities"].([]interface{})
+	if !ok {
+		return nil, error.NewError("member \"tileEntities\": expected an array", nil)
+	}
+	for i, tv := range tileEntities {
+		var te TileEntity
+		if te, err = tileEntityFromJSON(tv); err != nil {
+			return nil, error.NewError(fmt.Sprintf("tile entity %d", i), err)
+		}
+		te.tileEntityBase().chunk = c
+		c.Level.TileEntities = append(c.Level.TileEntities, te)
+	}
+	return c, nil
+}
+
+// tileEntityFromJSON decodes a parsed tile entity of any kind.
+func tileEntityFromJSON(v interface{}) (TileEntity, os.Error) {
+	obj, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, error.NewError(fmt.Sprintf("expected an object, got %v", v), nil)
+	}
+	id, ok := obj["id"].(string)
+	if !ok {
+		return nil, error.NewError("member \"id\": expected a string", nil)
+	}
+	payload, err := fromJSONObject(obj, tileEntityJSONMembers(id))
+	if err != nil {
+		return nil, err
+	}
+	return toTileEntity(payload)
+}
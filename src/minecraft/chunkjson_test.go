@@ -0,0 +1,125 @@
+package world
+
+import "minecraft/nbt"
+
+import "bytes"
+import "json"
+import "strings"
+import "testing"
+
+// jsonChunkWorld returns chunk (-1, 2) holding a pig, a dropped item and a chest,
+// with a glowing pillar of wool at local (3, 60..62, 5) and sunlight above it.
+func jsonChunkWorld() *World {
+	w := &World{Chunks: make(map[XZ]*Chunk)}
+	c := mustChunk(testChunkPayload(-1, 2, []interface{}{pigFixture, itemFixture}, []interface{}{chestFixture}))
+	for y := int32(60); y < 63; y++ {
+		c.SetBlock(3, y, 5, 35, byte(y-60))
+		setNibble(c.Level.BlockLight, blockIndex(3, y, 5), 14)
+	}
+	setNibble(c.Level.SkyLight, blockIndex(3, 63, 5), 15)
+	w.Chunks[MakeXZ(-1, 2)] = c
+	return w
+}
+
+func TestChunkJSONGolden(t *testing.T) {
+	buf := new(bytes.Buffer)
+	if err := jsonChunkWorld().ExportChunkJSON(buf, -1, 2, ChunkJSONOptions{Arrays: ArraysOmitted}); err != nil {
+		t.Fatal(err)
+	}
+	if golden := readGolden(t, "chunk.json"); strings.TrimRight(buf.String(), "\n") != golden {
+		t.Errorf("expected\n%s\ngot\n%s", golden, buf.String())
+	}
+}
+
+func TestChunkJSONRoundTrip(t *testing.T) {
+	w := jsonChunkWorld()
+	want := w.Chunks[MakeXZ(-1, 2)]
+	for _, arrays := range []ArrayEncoding{ArraysBase64, ArraysNested} {
+		buf := new(bytes.Buffer)
+		if err := w.ExportChunkJSON(buf, -1, 2, ChunkJSONOptions{Arrays: arrays}); err != nil {
+			t.Fatal(err)
+		}
+		c, err := ImportChunkJSON(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !nbt.Equal(mustFromChunk(c), mustFromChunk(want)) {
+			t.Errorf("encoding %d: chunk changed in a round trip", arrays)
+		}
+		if len(c.Level.TileEntities) != 1 || c.Level.TileEntities[0].tileEntityBase().chunk != c {
+			t.Errorf("encoding %d: expected the chest to belong to the chunk", arrays)
+		}
+	}
+}
+
+func TestChunkJSONNested(t *testing.T) {
+	buf := new(bytes.Buffer)
+	if err := jsonChunkWorld().ExportChunkJSON(buf, -1, 2, ChunkJSONOptions{Arrays: ArraysNested}); err != nil {
+		t.Fatal(err)
+	}
+	var v map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &v); err != nil {
+		t.Fatal(err)
+	}
+	at := func(name string, x, z, y int) interface{} {
+		zs := v[name].([]interface{})[x].([]interface{})
+		if y < 0 {
+			return zs[z]
+		}
+		return zs[z].([]interface{})[y]
+	}
+	for _, c := range []struct {
+		name    string
+		x, z, y int
+		want    float64
+	}{
+		{"blocks", 3, 5, 61, 35},
+		{"blocks", 5, 3, 61, 0},
+		{"data", 3, 5, 62, 2},
+		{"blockLight", 3, 5, 60, 14},
+		{"skyLight", 3, 5, 63, 15},
+		{"heightMap", 3, 5, -1, 63},
+	} {
+		if got := at(c.name, c.x, c.z, c.y); got != c.want {
+			t.Errorf("%s[%d][%d][%d]: expected %v, got %v", c.name, c.x, c.z, c.y, c.want, got)
+		}
+	}
+}
+
+func TestImportChunkJSONOmitted(t *testing.T) {
+	buf := new(bytes.Buffer)
+	if err := jsonChunkWorld().ExportChunkJSON(buf, -1, 2, ChunkJSONOptions{Arrays: ArraysOmitted}); err != nil {
+		t.Fatal(err)
+	}
+	c, err := ImportChunkJSON(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Level.XPos != -1 || c.Level.ZPos != 2 || c.Level.LastUpdate != 1234 || c.Level.TerrainPopulated != 1 {
+		t.Errorf("expected chunk (-1, 2) updated at 1234, got %+v", c.Level)
+	}
+	if len(c.Level.Blocks) != chunkBlocks || len(c.Level.SkyLight) != chunkNibbles || len(c.Level.Entities) != 2 {
+		t.Error("expected empty arrays and 2 entities")
+	}
+}
+
+func TestImportChunkJSONMalformed(t *testing.T) {
+	for _, doc := range []string{
+		`[]`,
+		`{"xPos": 0, "zPos": 0, "lastUpdate": "0", "terrainPopulated": 0, "entities": [], "tileEntities": [], "biomes": []}`,
+		`{"xPos": 0.5, "zPos": 0, "lastUpdate": "0", "terrainPopulated": 0, "entities": [], "tileEntities": []}`,
+		`{"xPos": 0, "zPos": 0, "lastUpdate": 0, "terrainPopulated": 0, "entities": [], "tileEntities": []}`,
+		`{"xPos": 0, "zPos": 0, "lastUpdate": "0", "terrainPopulated": 0, "entities": [], "tileEntities": [], "heightMap": "AAAA"}`,
+		`{"xPos": 0, "zPos": 0, "lastUpdate": "0", "terrainPopulated": 0, "entities": [], "tileEntities": [], "heightMap": [1, 2]}`,
+		`{"xPos": 0, "zPos": 0, "lastUpdate": "0", "terrainPopulated": 0, "entities": [{"id": "Pig"}], "tileEntities": []}`,
+		`{"xPos": 0, "zPos": 0, "lastUpdate": "0", "terrainPopulated": 0, "entities": [], "tileEntities": [{"x": 1}]}`,
+		`{"xPos": 0, "zPos": 0, "lastUpdate": "0", "terrainPopulated": 0, "tileEntities": []}`,
+	} {
+		if _, err := ImportChunkJSON(strings.NewReader(doc)); err == nil {
+			t.Error("expected an error for ", doc)
+		}
+	}
+	if err := jsonChunkWorld().ExportChunkJSON(new(bytes.Buffer), 0, 0, ChunkJSONOptions{}); err == nil {
+		t.Error("expected an error for a missing chunk")
+	}
+}
@@ -0,0 +1,362 @@
+package world
+
+import "minecraft/error"
+import "minecraft/nbt"
+
+import "bytes"
+import "compress/gzip"
+import "compress/zlib"
+import "fmt"
+import "io"
+import "os"
+import "sync"
+import "time"
+
+// A ChunkStore keeps a world's chunks, each as its payload: a byte giving its
+// Compression, then the chunk's NBT compound compressed that way, as a region
+// file holds it.  World reads and writes chunks only through its store, which
+// Open chooses by the world's Format unless given one with the Store option.
+// A store keeps payloads of either compression, but may have to recompress
+// those not compressed as its files are.
+//
+// Read, Delete and ModTime fail with ErrChunkNotFound for a chunk the store
+// does not have.  Write replaces a chunk whole, so a write cut short leaves the
+// chunk as it was.  A store may be used by several goroutines at once.
+type ChunkStore interface {
+	// Has reports whether the store has the chunk at (x, z).
+	Has(x, z int32) bool
+	// Read returns the payload of the chunk at (x, z).
+	Read(x, z int32) ([]byte, os.Error)
+	// Write stores payload as the chunk at (x, z), replacing any it had.
+	Write(x, z int32, payload []byte) os.Error
+	// Delete removes the chunk at (x, z).
+	Delete(x, z int32) os.Error
+	// List returns the keys of every chunk the store has, in no particular
+	// order.
+	List() ([]XZ, os.Error)
+	// ModTime returns when the chunk at (x, z) was last written, in
+	// nanoseconds since the epoch.
+	ModTime(x, z int32) (int64, os.Error)
+}
+
+// A Compression is how the compound of a chunk's payload is compressed, given
+// by the payload's first byte with the numbers region files use.
+type Compression byte
+
+const (
+	CompressGzip Compression = 1 // as Alpha chunk files are compressed
+	CompressZlib Compression = 2 // as the game compresses chunks in region files
+)
+
+func (c Compression) String() string {
+	switch c {
+	case CompressGzip:
+		return "gzip"
+	case CompressZlib:
+		return "zlib"
+	}
+	return fmt.Sprintf("Compression(%d)", byte(c))
+}
+
+// A compressor is a ChunkStore whose files compress chunks one way, so that
+// World writes payloads that way and the store need not recompress them.
+type compressor interface {
+	compression() Compression
+}
+
+// A lender is a ChunkStore that can lend a chunk's payload rather than copy
+// it.  A lent payload must not be changed, and is the lender's again once
+// giveBack is called, which must be soon: the store may wait for it to write.
+type lender interface {
+	lend(x, z int32) (payload []byte, giveBack func(), err os.Error)
+}
+
+// readPayload returns the payload of the stored chunk at (x, z) for the World
+// to decode, lent if its store lends payloads.  The World must call giveBack
+// once it is decoded, and copy what it keeps.
+func (world *World) readPayload(x, z int32) (payload []byte, giveBack func(), err os.Error) {
+	if s, ok := world.Store().(lender); ok {
+		return s.lend(x, z)
+	}
+	payload, err = world.Store().Read(x, z)
+	return payload, noLoan, err
+}
+
+// noLoan gives back a payload that was not lent.
+func noLoan() {}
+
+// NewStore returns the store of the chunks of the world in dir, laid out in
+// format.
+func NewStore(dir string, format Format) ChunkStore {
+	switch format {
+	case FormatMcRegion:
+		return newRegionStore(dir, mcRegionExt)
+	case FormatAnvil:
+		return newRegionStore(dir, anvilExt)
+	}
+	return &alphaStore{dir}
+}
+
+// Store returns the store the World keeps its chunks in.  A World not made by
+// Open keeps them as an Alpha world in its directory.
+func (world *World) Store() ChunkStore {
+	if world.store == nil {
+		return &alphaStore{world.dir}
+	}
+	return world.store
+}
+
+// DeleteChunk removes the chunk at (x, z) from the world, so that the game
+// generates it afresh: it is forgotten if it is resident, changes not yet
+// flushed and all, and deleted from the store.  It fails with ErrChunkNotFound
+// if there is no such chunk.
+func (world *World) DeleteChunk(x, z int32) os.Error {
+	if err := world.VerifyLockNow(); err != nil {
+		return err
+	}
+	xz := MakeXZ(x, z)
+	c, resident := world.resident(xz)
+	if resident {
+		// a Flush already under way must not write it back
+		c.Lock()
+		c.dirty = false
+		world.drop(xz)
+		c.Unlock()
+	}
+	if err := world.Store().Delete(x, z); err != nil && !(resident && IsError(err, ErrChunkNotFound)) {
+		return error.InChunk(x, z).Error("could not delete chunk", err)
+	}
+	return nil
+}
+
+// CopyChunks writes every chunk of src to dst, as when a world built in a
+// MemoryStore is saved to disk, and returns how many it copied.  It stops at
+// the first error.
+func CopyChunks(dst, src ChunkStore) (n int, err os.Error) {
+	keys, err := src.List()
+	if err != nil {
+		return 0, error.NewError("could not list chunks", err)
+	}
+	SortXZ(keys)
+	for _, xz := range keys {
+		x, z := SplitXZ(xz)
+		payload, err := src.Read(x, z)
+		if err != nil {
+			return n, error.InChunk(x, z).Error("could not read chunk", err)
+		}
+		if err = dst.Write(x, z, payload); err != nil {
+			return n, error.InChunk(x, z).Error("could not write chunk", err)
+		}
+		n++
+	}
+	return n, nil
+}
+
+// keepAll decodes every tag of a compound.
+func keepAll(name string, ttype nbt.TagType) bool {
+	return true
+}
+
+// loadChunkMap decodes the tags keep accepts of the stored chunk at (x, z).
+func (world *World) loadChunkMap(x, z int32, keep nbt.Filter) (name string, chunkmap map[string]interface{}, err os.Error) {
+	raw, giveBack, err := world.readPayload(x, z)
+	if err == nil {
+		name, chunkmap, err = decodePayload(raw, keep)
+		giveBack()
+	}
+	if err != nil {
+		err = error.InChunk(x, z).Error("could not load chunk", err)
+	}
+	return
+}
+
+// saveChunkMap stores chunkmap as the chunk at (x, z).
+func (world *World) saveChunkMap(x, z int32, name string, chunkmap map[string]interface{}) os.Error {
+	payload, err := encodeChunk(name, chunkmap, world.compression())
+	if err == nil {
+		err = world.Store().Write(x, z, payload)
+	}
+	if err != nil {
+		return error.InChunk(x, z).Error("could not save chunk", err)
+	}
+	return nil
+}
+
+// compression returns how World compresses the chunks it writes: as the store's
+// files do, or with gzip.
+func (world *World) compression() Compression {
+	if c, ok := world.Store().(compressor); ok {
+		return c.compression()
+	}
+	return CompressGzip
+}
+
+// encodeChunk returns the payload of a chunk, compressed as c.
+func encodeChunk(name string, chunkmap map[string]interface{}, c Compression) ([]byte, os.Error) {
+	buf := bytes.NewBuffer([]byte{byte(c)})
+	w, err := compressWriter(buf, c)
+	if err != nil {
+		return nil, err
+	}
+	if err = nbt.WriteTagCompound(w, name, chunkmap); err != nil {
+		return nil, error.NewError("could not write compound tag", err)
+	}
+	if err = w.Close(); err != nil {
+		return nil, error.NewError(fmt.Sprint("could not finish ", c, " stream"), err)
+	}
+	return buf.Bytes(), nil
+}
+
+// compressWriter returns a writer compressing to w as c.
+func compressWriter(w io.Writer, c Compression) (io.WriteCloser, os.Error) {
+	var cw io.WriteCloser
+	var err os.Error
+	switch c {
+	case CompressGzip:
+		cw, err = gzip.NewWriter(w)
+	case CompressZlib:
+		cw, err = zlib.NewWriter(w)
+	default:
+		return nil, error.NewError(fmt.Sprint("unknown compression ", c), nil)
+	}
+	if err != nil {
+		return nil, error.NewError(fmt.Sprint("could not start ", c, " stream"), err)
+	}
+	return cw, nil
+}
+
+// payloadReader returns a reader of the compound in payload.  A payload that
+// is empty or of an unknown compression is corrupt.
+func payloadReader(payload []byte) (io.ReadCloser, os.Error) {
+	if len(payload) == 0 {
+		return nil, error.NewError("empty payload", ErrCorruptChunk)
+	}
+	var r io.ReadCloser
+	var err os.Error
+	switch c := Compression(payload[0]); c {
+	case CompressGzip:
+		r, err = gzip.NewReader(bytes.NewBuffer(payload[1:]))
+	case CompressZlib:
+		r, err = zlib.NewReader(bytes.NewBuffer(payload[1:]))
+	default:
+		return nil, error.NewError(fmt.Sprint("unknown compression ", c), ErrCorruptChunk)
+	}
+	if err != nil {
+		return nil, error.NewError(fmt.Sprint("could not read ", Compression(payload[0]), " stream"), error.Wrap(ErrCorruptChunk, err))
+	}
+	return r, nil
+}
+
+// decodePayload decodes the tags keep accepts of the compound in payload.
+func decodePayload(payload []byte, keep nbt.Filter) (name string, chunkmap map[string]interface{}, err os.Error) {
+	r, err := payloadReader(payload)
+	if err != nil {
+		return
+	}
+	defer r.Close()
+	if name, chunkmap, err = nbt.ReadTagCompoundFiltered(r, keep); err != nil {
+		err = error.Wrap(ErrCorruptChunk, err)
+	}
+	return
+}
+
+// recompress returns payload compressed as c, which it is already unless it
+// came from a store of another format.
+func recompress(payload []byte, c Compression) ([]byte, os.Error) {
+	if len(payload) > 0 && Compression(payload[0]) == c {
+		return payload, nil
+	}
+	r, err := payloadReader(payload)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	buf := bytes.NewBuffer([]byte{byte(c)})
+	w, err := compressWriter(buf, c)
+	if err == nil {
+		if _, err = io.Copy(w, r); err == nil {
+			err = w.Close()
+		}
+	}
+	if err != nil {
+		return nil, error.NewError(fmt.Sprint("could not recompress chunk as ", c), err)
+	}
+	return buf.Bytes(), nil
+}
+
+// A MemoryStore is a ChunkStore that keeps its chunks in memory, for tests and
+// for tools that build a world before writing it out with CopyChunks.  The zero
+// MemoryStore is empty and ready to use.
+type MemoryStore struct {
+	mu     sync.RWMutex
+	chunks map[XZ]memoryChunk
+}
+
+type memoryChunk struct {
+	payload []byte
+	mtime   int64
+}
+
+// NewMemoryStore returns an empty MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{chunks: make(map[XZ]memoryChunk)}
+}
+
+func (s *MemoryStore) Has(x, z int32) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	_, ok := s.chunks[MakeXZ(x, z)]
+	return ok
+}
+
+// Read returns a copy of the chunk's payload.
+func (s *MemoryStore) Read(x, z int32) ([]byte, os.Error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	c, ok := s.chunks[MakeXZ(x, z)]
+	if !ok {
+		return nil, ChunkNotFoundError{x, z}
+	}
+	return append([]byte(nil), c.payload...), nil
+}
+
+// Write keeps a copy of payload.
+func (s *MemoryStore) Write(x, z int32, payload []byte) os.Error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.chunks == nil {
+		s.chunks = make(map[XZ]memoryChunk)
+	}
+	s.chunks[MakeXZ(x, z)] = memoryChunk{append([]byte(nil), payload...), time.Nanoseconds()}
+	return nil
+}
+
+func (s *MemoryStore) Delete(x, z int32) os.Error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.chunks[MakeXZ(x, z)]; !ok {
+		return ChunkNotFoundError{x, z}
+	}
+	s.chunks[MakeXZ(x, z)] = memoryChunk{}, false
+	return nil
+}
+
+func (s *MemoryStore) List() ([]XZ, os.Error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	keys := make([]XZ, 0, len(s.chunks))
+	for xz := range s.chunks {
+		keys = append(keys, xz)
+	}
+	return keys, nil
+}
+
+func (s *MemoryStore) ModTime(x, z int32) (int64, os.Error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	c, ok := s.chunks[MakeXZ(x, z)]
+	if !ok {
+		return 0, ChunkNotFoundError{x, z}
+	}
+	return c.mtime, nil
+}
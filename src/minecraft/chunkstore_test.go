@@ -0,0 +1,326 @@
+package world
+
+import "minecraft/nbt"
+
+import "io/ioutil"
+import "os"
+import "path"
+import "rand"
+import "testing"
+import "time"
+
+// storeKeys are chunks for the conformance tests, on both sides of the origin
+// and of the borders of regions.
+var storeKeys = []XZ{
+	MakeXZ(0, 0), MakeXZ(1, 0), MakeXZ(31, 31), MakeXZ(32, 0),
+	MakeXZ(-1, -1), MakeXZ(-33, 5), MakeXZ(1000, -1000),
+}
+
+// storePayload returns the payload of chunk (x, z), with n bytes of noise that
+// does not compress added to make it bigger.
+func storePayload(t *testing.T, x, z int32, n int) []byte {
+	c := testChunkPayload(x, z, nil, nil)
+	noise := make([]byte, n)
+	r := rand.New(rand.NewSource(int64(x)<<32 | int64(z)))
+	for i := range noise {
+		noise[i] = byte(r.Int63())
+	}
+	c["Noise"] = noise
+	payload, err := encodeChunk("", c, CompressGzip)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return payload
+}
+
+// expectPayload checks that s has the chunk at xz, holding the compound of
+// want.  Stores need only keep the compound: the Alpha store recompresses it.
+func expectPayload(t *testing.T, name string, s ChunkStore, xz XZ, want []byte) {
+	x, z := SplitXZ(xz)
+	got, err := s.Read(x, z)
+	if err != nil {
+		t.Errorf("%s: reading %v: %v", name, xz, err)
+		return
+	}
+	_, a, err := decodePayload(want, keepAll)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, b, err := decodePayload(got, keepAll)
+	if err != nil || !nbt.Equal(a, b) {
+		t.Errorf("%s: %v read back changed (%v)", name, xz, err)
+	}
+	if !s.Has(x, z) {
+		t.Errorf("%s: expected to have %v", name, xz)
+	}
+}
+
+// expectMissing checks that s does not have the chunk at xz.
+func expectMissing(t *testing.T, name string, s ChunkStore, xz XZ) {
+	x, z := SplitXZ(xz)
+	_, readErr := s.Read(x, z)
+	_, modErr := s.ModTime(x, z)
+	if s.Has(x, z) || !IsError(readErr, ErrChunkNotFound) || !IsError(modErr, ErrChunkNotFound) {
+		t.Errorf("%s: expected %v missing, got %v and %v", name, xz, readErr, modErr)
+	}
+	if err := s.Delete(x, z); !IsError(err, ErrChunkNotFound) {
+		t.Errorf("%s: expected deleting missing %v to fail, got %v", name, xz, err)
+	}
+}
+
+// expectKeys checks that s lists exactly keys.
+func expectKeys(t *testing.T, name string, s ChunkStore, keys []XZ) {
+	got, err := s.List()
+	if err != nil {
+		t.Errorf("%s: %v", name, err)
+		return
+	}
+	want := append([]XZ(nil), keys...)
+	SortXZ(got)
+	SortXZ(want)
+	if len(got) != len(want) {
+		t.Errorf("%s: expected %v listed, got %v", name, want, got)
+		return
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("%s: expected %v listed, got %v", name, want, got)
+			return
+		}
+	}
+}
+
+// testChunkStore is the conformance test of a ChunkStore, which must start
+// empty.
+func testChunkStore(t *testing.T, name string, s ChunkStore) {
+	for _, xz := range storeKeys {
+		expectMissing(t, name, s, xz)
+	}
+	expectKeys(t, name, s, nil)
+
+	payloads := make(map[XZ][]byte)
+	before := time.Nanoseconds()
+	for _, xz := range storeKeys {
+		x, z := SplitXZ(xz)
+		payloads[xz] = storePayload(t, x, z, 100)
+		if err := s.Write(x, z, payloads[xz]); err != nil {
+			t.Fatalf("%s: writing %v: %v", name, xz, err)
+		}
+	}
+	after := time.Nanoseconds()
+	for _, xz := range storeKeys {
+		expectPayload(t, name, s, xz, payloads[xz])
+		// the region stores keep whole seconds
+		if mtime, err := s.ModTime(SplitXZ(xz)); err != nil || mtime < before-1e9 || mtime > after {
+			t.Errorf("%s: expected %v written between %d and %d, got %d (%v)", name, xz, before, after, mtime, err)
+		}
+	}
+	expectKeys(t, name, s, storeKeys)
+
+	// what Read returns is the caller's
+	got, err := s.Read(0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range got {
+		got[i] = 0
+	}
+	expectPayload(t, name, s, MakeXZ(0, 0), payloads[MakeXZ(0, 0)])
+
+	// growing a chunk past its room and shrinking it again leaves the rest be
+	for _, n := range []int{20000, 0, 50000} {
+		payloads[MakeXZ(1, 0)] = storePayload(t, 1, 0, n)
+		if err = s.Write(1, 0, payloads[MakeXZ(1, 0)]); err != nil {
+			t.Fatalf("%s: rewriting with %d bytes: %v", name, n, err)
+		}
+		for _, xz := range storeKeys {
+			expectPayload(t, name, s, xz, payloads[xz])
+		}
+	}
+
+	if err = s.Delete(31, 31); err != nil {
+		t.Fatalf("%s: %v", name, err)
+	}
+	expectMissing(t, name, s, MakeXZ(31, 31))
+	kept := []XZ{}
+	for _, xz := range storeKeys {
+		if xz != MakeXZ(31, 31) {
+			kept = append(kept, xz)
+			expectPayload(t, name, s, xz, payloads[xz])
+		}
+	}
+	expectKeys(t, name, s, kept)
+
+	// chunks written at once from several goroutines all arrive
+	done := make(chan os.Error)
+	for i := int32(0); i < 8; i++ {
+		go func(x int32) {
+			done <- s.Write(x, 7, payloads[MakeXZ(0, 0)])
+		}(i)
+	}
+	for i := 0; i < 8; i++ {
+		if err = <-done; err != nil {
+			t.Errorf("%s: writing concurrently: %v", name, err)
+		}
+	}
+	for i := int32(0); i < 8; i++ {
+		expectPayload(t, name, s, MakeXZ(i, 7), payloads[MakeXZ(0, 0)])
+	}
+	for _, xz := range kept {
+		expectPayload(t, name, s, xz, payloads[xz])
+	}
+}
+
+func TestChunkStores(t *testing.T) {
+	testChunkStore(t, "memory", NewMemoryStore())
+	testChunkStore(t, "zero memory", new(MemoryStore))
+	for _, format := range []Format{FormatAlpha, FormatMcRegion, FormatAnvil} {
+		dir, err := ioutil.TempDir("", "store")
+		if err != nil {
+			t.Fatal(err)
+		}
+		testChunkStore(t, format.String(), NewStore(dir, format))
+		os.RemoveAll(dir)
+	}
+	dir, err := ioutil.TempDir("", "store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	s := newMappedStore(dir, mcRegionExt)
+	testChunkStore(t, "mapped", s)
+	if err = s.Close(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestMemoryStoreWorld(t *testing.T) {
+	dir := makeTestWorld(t, testChunkPayload(0, 0, nil, nil))
+	defer os.RemoveAll(dir)
+	disk := NewStore(dir, FormatAlpha)
+	mem := NewMemoryStore()
+	if n, err := CopyChunks(mem, disk); err != nil || n != 1 {
+		t.Fatalf("expected a chunk copied, got %d (%v)", n, err)
+	}
+	w, err := Open(dir, Store(mem))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	if w.Store() != ChunkStore(mem) || w.Config().Store != ChunkStore(mem) {
+		t.Error("expected the world to keep its chunks in the store given")
+	}
+
+	c, err := w.CreateChunk(5, -3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.SetBlock(1, 64, 1, BlockStone, 0)
+	if err = w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if !mem.Has(5, -3) || disk.Has(5, -3) {
+		t.Error("expected the chunk flushed to memory and not to disk")
+	}
+	if err = w.UnloadChunk(5, -3); err != nil {
+		t.Fatal(err)
+	}
+	if id, _, err := w.BlockAt(5*16+1, 64, -3*16+1); err != nil || id != BlockStone {
+		t.Errorf("expected stone read back from memory, got %d (%v)", id, err)
+	}
+	coords, err := w.ListChunks(nil)
+	if err != nil || len(coords) != 2 {
+		t.Errorf("expected 2 chunks listed, got %v (%v)", coords, err)
+	}
+
+	if _, err = CopyChunks(disk, mem); err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+	if w, err = Open(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	if id, _, err := w.BlockAt(5*16+1, 64, -3*16+1); err != nil || id != BlockStone {
+		t.Errorf("expected stone once copied to disk, got %d (%v)", id, err)
+	}
+}
+
+func TestDeleteChunk(t *testing.T) {
+	dir := makeTestWorld(t, testChunkPayload(0, 0, nil, nil), testChunkPayload(1, 0, nil, nil))
+	defer os.RemoveAll(dir)
+	w, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	c, err := w.GetChunk(0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.SetBlock(0, 64, 0, BlockStone, 0)
+	if _, err = w.CreateChunk(2, 0); err != nil {
+		t.Fatal(err)
+	}
+	for _, x := range []int32{0, 1, 2} {
+		if err = w.DeleteChunk(x, 0); err != nil {
+			t.Errorf("deleting (%d, 0): %v", x, err)
+		}
+		if w.ChunkExists(x, 0) {
+			t.Errorf("expected (%d, 0) gone", x)
+		}
+	}
+	if err = w.DeleteChunk(3, 0); !IsError(err, ErrChunkNotFound) {
+		t.Error("expected ErrChunkNotFound deleting a missing chunk, got ", err)
+	}
+	if err = w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if coords, err := w.ListChunks(nil); err != nil || len(coords) != 0 {
+		t.Errorf("expected nothing left to flush, got %v (%v)", coords, err)
+	}
+
+	w.Close()
+	if w, err = Open(dir, ReadOnly()); err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	if err = w.DeleteChunk(0, 0); !IsError(err, ErrReadOnly) {
+		t.Error("expected ErrReadOnly, got ", err)
+	}
+}
+
+// TestAlphaChunkPath reads chunks with negative coordinates from where the game
+// keeps them, not from where chunkPath puts them.
+func TestAlphaChunkPath(t *testing.T) {
+	dir := makeTestWorld(t, testChunkPayload(0, 0, nil, nil))
+	defer os.RemoveAll(dir)
+	for _, c := range []struct {
+		x, z int32
+		file string
+	}{{-1, 0, "1r/0/c.-1.0.dat"}, {5, -64, "5/0/c.5.-1s.dat"}, {-65, -2, "1r/1q/c.-1t.-2.dat"}} {
+		file := path.Join(dir, c.file)
+		if err := os.MkdirAll(path.Dir(file), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := nbt.Save(file, "", testChunkPayload(c.x, c.z, nil, nil)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	w, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	coords, err := w.ListChunks(nil)
+	if err != nil || len(coords) != 4 {
+		t.Fatalf("expected 4 chunks, got %v (%v)", coords, err)
+	}
+	for _, xz := range coords {
+		if c, err := w.readChunk(xz.X, xz.Z); err != nil || c.Level.XPos != xz.X || c.Level.ZPos != xz.Z {
+			t.Errorf("expected chunk %v read back, got %v", xz, err)
+		}
+	}
+}
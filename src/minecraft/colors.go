@@ -0,0 +1,292 @@
+package world
+
+import "minecraft/error"
+
+import "bufio"
+import "fmt"
+import "image"
+import "io"
+import "os"
+import "strconv"
+import "strings"
+
+// see: http://www.minecraftwiki.net/wiki/Data_values#Block_IDs
+
+// MissingColor is the loud magenta of the blocks a color table has no color for,
+// so that the gaps show.
+var MissingColor = image.NRGBAColor{0xff, 0x00, 0xff, 0xff}
+
+// blockColors gives each block the color it shows from above on a map, whatever
+// its data value.  Ids not listed are MissingColor.  Water is translucent and is
+// drawn over what lies beneath it.
+var blockColors [256]image.NRGBAColor
+
+// defaultColors is the palette of DefaultColorTable: blockColors, with the colors
+// of wool and wood told apart by data value.
+var defaultColors = &ColorTable{byData: make(map[byte]*[16]image.NRGBAColor)}
+
+func init() {
+	for id := range blockColors {
+		blockColors[id] = MissingColor
+	}
+	for id, rgb := range map[byte]uint32{
+		1:  0x7d7d7d, // stone
+		2:  0x5d9a3a, // grass
+		3:  0x866043, // dirt
+		4:  0x7a7a7a, // cobblestone
+		5:  0x9c7f4e, // wooden planks
+		6:  0x48772a, // sapling
+		7:  0x545454, // bedrock
+		10: 0xd4590f, // lava
+		11: 0xd4590f, // stationary lava
+		12: 0xdbd3a0, // sand
+		13: 0x857f7e, // gravel
+		14: 0x8f8c7d, // gold ore
+		15: 0x88827f, // iron ore
+		16: 0x737373, // coal ore
+		17: 0x675231, // wood
+		18: 0x3c7a1e, // leaves
+		19: 0xc3c34a, // sponge
+		20: 0xc0f5fe, // glass
+		21: 0x667086, // lapis lazuli ore
+		22: 0x1d47a6, // lapis lazuli block
+		23: 0x6b6b6b, // dispenser
+		24: 0xd9d19c, // sandstone
+		25: 0x654433, // note block
+		26: 0x8e1616, // bed
+		27: 0x9a7f4a, // powered rail
+		28: 0x7a6b5a, // detector rail
+		30: 0xdcdcdc, // cobweb
+		31: 0x4f8a2a, // tall grass
+		32: 0x7b5e34, // dead bush
+		35: 0xdddddd, // wool
+		37: 0xf1f902, // dandelion
+		38: 0xc71b1b, // rose
+		39: 0x9a7658, // brown mushroom
+		40: 0xc33637, // red mushroom
+		41: 0xf9ec4f, // gold block
+		42: 0xdbdbdb, // iron block
+		43: 0xa8a8a8, // double slab
+		44: 0xa8a8a8, // slab
+		45: 0x966454, // brick
+		46: 0xdb441a, // TNT
+		47: 0x6b5839, // bookshelf
+		48: 0x667f5e, // moss stone
+		49: 0x14121d, // obsidian
+		50: 0xfcd65a, // torch
+		51: 0xe3a012, // fire
+		52: 0x1b2a35, // monster spawner
+		53: 0x9c7f4e, // wooden stairs
+		54: 0x8f6a2a, // chest
+		55: 0xa00000, // redstone wire
+		56: 0x81a8ac, // diamond ore
+		57: 0x61dbd5, // diamond block
+		58: 0x6b4e2e, // crafting table
+		59: 0x8fb45a, // crops
+		60: 0x5b3a1e, // farmland
+		61: 0x6b6b6b, // furnace
+		62: 0x6b6b6b, // burning furnace
+		63: 0x9c7f4e, // sign post
+		64: 0x7a5b32, // wooden door
+		65: 0x8c7148, // ladder
+		66: 0x7f6d54, // rails
+		67: 0x7a7a7a, // cobblestone stairs
+		68: 0x9c7f4e, // wall sign
+		69: 0x6b5839, // lever
+		70: 0x7d7d7d, // stone pressure plate
+		71: 0xb4b4b4, // iron door
+		72: 0x9c7f4e, // wooden pressure plate
+		73: 0x845b5b, // redstone ore
+		74: 0x845b5b, // glowing redstone ore
+		75: 0xb51c1c, // redstone torch (off)
+		76: 0xb51c1c, // redstone torch (on)
+		77: 0x7d7d7d, // stone button
+		78: 0xf0fbfb, // snow
+		79: 0x7dadff, // ice
+		80: 0xf0fbfb, // snow block
+		81: 0x0d6418, // cactus
+		82: 0x9fa4b1, // clay
+		83: 0x94c065, // sugar cane
+		84: 0x6b4a37, // jukebox
+		85: 0x9c7f4e, // fence
+		86: 0xc07615, // pumpkin
+		87: 0x6f3634, // netherrack
+		88: 0x554134, // soul sand
+		89: 0xf9d49c, // glowstone
+		90: 0x5a1ea8, // portal
+		91: 0xe3a012, // jack-o-lantern
+		92: 0xe4cdce, // cake
+		93: 0x9b9b9b, // redstone repeater (off)
+		94: 0x9b9b9b, // redstone repeater (on)
+		95: 0x8f6a2a, // locked chest
+		96: 0x7e5d2d, // trapdoor
+	} {
+		blockColors[id] = image.NRGBAColor{uint8(rgb >> 16), uint8(rgb >> 8), uint8(rgb), 0xff}
+	}
+	blockColors[BlockAir] = image.NRGBAColor{}
+	blockColors[BlockWater] = waterColor
+	blockColors[BlockStillWater] = waterColor
+
+	defaultColors.colors = blockColors
+	for data, rgb := range []uint32{
+		0xdddddd, // white
+		0xdb7d3e, // orange
+		0xb350bc, // magenta
+		0x6b8ac9, // light blue
+		0xb1a627, // yellow
+		0x41ae38, // lime
+		0xd08499, // pink
+		0x404040, // gray
+		0x9aa1a1, // light gray
+		0x2e6e89, // cyan
+		0x7e3db5, // purple
+		0x2e388d, // blue
+		0x4f321f, // brown
+		0x35461b, // green
+		0x963430, // red
+		0x191616, // black
+	} {
+		defaultColors.SetData(BlockWool, byte(data), image.NRGBAColor{uint8(rgb >> 16), uint8(rgb >> 8), uint8(rgb), 0xff})
+	}
+	for data, rgb := range []uint32{
+		0x675231, // oak
+		0x3b2912, // spruce
+		0xd5d3c9, // birch
+	} {
+		defaultColors.SetData(BlockWood, byte(data), image.NRGBAColor{uint8(rgb >> 16), uint8(rgb >> 8), uint8(rgb), 0xff})
+	}
+}
+
+var waterColor = image.NRGBAColor{0x2f, 0x4f, 0xd6, 0x90}
+
+// over returns the color of top, which may be translucent, drawn over the opaque
+// color bottom.
+func over(top, bottom image.NRGBAColor) image.NRGBAColor {
+	a := int(top.A)
+	blend := func(t, b uint8) uint8 {
+		return uint8((int(t)*a + int(b)*(0xff-a) + 0x7f) / 0xff)
+	}
+	return image.NRGBAColor{blend(top.R, bottom.R), blend(top.G, bottom.G), blend(top.B, bottom.B), 0xff}
+}
+
+// A ColorTable gives each block the color it shows on a map, by id and, for
+// blocks such as wool, data value.
+type ColorTable struct {
+	colors [256]image.NRGBAColor
+	byData map[byte]*[16]image.NRGBAColor // the ids whose color depends on data
+}
+
+// NewColorTable returns a table in which every block but air, which is
+// transparent, is MissingColor.
+func NewColorTable() *ColorTable {
+	t := &ColorTable{byData: make(map[byte]*[16]image.NRGBAColor)}
+	for id := range t.colors {
+		t.colors[id] = MissingColor
+	}
+	t.colors[BlockAir] = image.NRGBAColor{}
+	return t
+}
+
+// DefaultColorTable returns a copy of the palette the renderers use when they are
+// not given one, which is close to the colors the game's own map shows.
+func DefaultColorTable() *ColorTable {
+	t := &ColorTable{colors: defaultColors.colors, byData: make(map[byte]*[16]image.NRGBAColor)}
+	for id, colors := range defaultColors.byData {
+		c := *colors
+		t.byData[id] = &c
+	}
+	return t
+}
+
+// Color returns the color of a block with the given id and data value.
+func (t *ColorTable) Color(id, data byte) image.NRGBAColor {
+	if colors, ok := t.byData[id]; ok {
+		return colors[data&0x0f]
+	}
+	return t.colors[id]
+}
+
+// Set makes c the color of blocks with the given id, whatever their data value.
+func (t *ColorTable) Set(id byte, c image.NRGBAColor) {
+	t.colors[id] = c
+	t.byData[id] = nil, false
+}
+
+// SetData makes c the color of blocks with the given id and data value.  Their
+// other data values keep the colors they had.
+func (t *ColorTable) SetData(id, data byte, c image.NRGBAColor) {
+	colors, ok := t.byData[id]
+	if !ok {
+		colors = new([16]image.NRGBAColor)
+		for i := range colors {
+			colors[i] = t.colors[id]
+		}
+		t.byData[id] = colors
+	}
+	colors[data&0x0f] = c
+}
+
+// LoadColorTable reads a color table from lines of comma-separated values, each
+// either id,color or id:data,color, where color is six or eight hex digits,
+// rrggbb or rrggbbaa, optionally after a #, as in
+//
+//	# grass, and red wool
+//	2,#5d9a3a
+//	35:14,963430
+//
+// Lines are applied in order, starting from NewColorTable, so that ids not listed
+// are MissingColor, and an id,color line resets the data values given for the id
+// before it.  Blank lines and lines starting with # are skipped.
+func LoadColorTable(r io.Reader) (*ColorTable, os.Error) {
+	t := NewColorTable()
+	br := bufio.NewReader(r)
+	var err os.Error
+	for n := 1; err != os.EOF; n++ {
+		var line string
+		line, err = br.ReadString('\n')
+		if err != nil && err != os.EOF {
+			return nil, error.NewError("could not read color table", err)
+		}
+		if line = strings.TrimSpace(line); line != "" && line[0] != '#' {
+			if e := t.parseLine(line); e != nil {
+				return nil, error.NewError(fmt.Sprintf("line %d of color table", n), e)
+			}
+		}
+	}
+	return t, nil
+}
+
+// parseLine applies a line of a color table file to t.
+func (t *ColorTable) parseLine(line string) os.Error {
+	fields := strings.Split(line, ",", -1)
+	if len(fields) != 2 {
+		return error.NewError(fmt.Sprintf("expected id and color, got %q", line), nil)
+	}
+	key := strings.Split(strings.TrimSpace(fields[0]), ":", 2)
+	id, err := strconv.Atoi(key[0])
+	if err != nil || id < 0 || id > 255 {
+		return error.NewError(fmt.Sprintf("bad block id %q", key[0]), err)
+	}
+	hex := strings.TrimSpace(fields[1])
+	if strings.HasPrefix(hex, "#") {
+		hex = hex[1:]
+	}
+	v, err := strconv.Btoui64(hex, 16)
+	if err != nil || len(hex) != 6 && len(hex) != 8 {
+		return error.NewError(fmt.Sprintf("bad color %q", fields[1]), err)
+	}
+	if len(hex) == 6 {
+		v = v<<8 | 0xff
+	}
+	c := image.NRGBAColor{uint8(v >> 24), uint8(v >> 16), uint8(v >> 8), uint8(v)}
+	if len(key) == 1 {
+		t.Set(byte(id), c)
+		return nil
+	}
+	data, err := strconv.Atoi(key[1])
+	if err != nil || data < 0 || data > 15 {
+		return error.NewError(fmt.Sprintf("bad data value %q", key[1]), err)
+	}
+	t.SetData(byte(id), byte(data), c)
+	return nil
+}
@@ -0,0 +1,93 @@
+package world
+
+import "bytes"
+import "image"
+import "strings"
+import "testing"
+
+// woolWorld returns chunk (0, 0) with a rainbow of wool, data values 0 to 15, in
+// columns (0, 0) to (15, 0), the three kinds of wood in columns (0, 1) to (2, 1),
+// and a block with the unknown id 200 in column (0, 2).
+func woolWorld() *World {
+	w := &World{Chunks: make(map[XZ]*Chunk)}
+	c := newChunk(0, 0)
+	for x := int32(0); x < 16; x++ {
+		c.SetBlock(x, 64, 0, BlockWool, byte(x))
+	}
+	for x := int32(0); x < 3; x++ {
+		c.SetBlock(x, 64, 1, BlockWood, byte(x))
+	}
+	c.SetBlock(0, 64, 2, 200, 0)
+	w.Chunks[MakeXZ(0, 0)] = c
+	return w
+}
+
+func TestRenderWool(t *testing.T) {
+	w := woolWorld()
+	buf := new(bytes.Buffer)
+	if err := w.RenderMap(buf, NewRegion(0, 0, 0, 0), RenderOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	m := decodePNG(t, buf)
+	seen := make(map[uint32]int)
+	colors := DefaultColorTable()
+	for x := 0; x < 16; x++ {
+		got := m.At(x, 0)
+		if want := colors.Color(BlockWool, byte(x)); !sameColor(got, want) {
+			t.Errorf("wool %d: expected %v, got %v", x, want, got)
+		}
+		r, g, b, _ := got.RGBA()
+		if n, ok := seen[r>>8<<16|g>>8<<8|b>>8]; ok {
+			t.Errorf("wool %d: same color as wool %d", x, n)
+		}
+		seen[r>>8<<16|g>>8<<8|b>>8] = x
+	}
+	if got := m.At(2, 1); !sameColor(got, colors.Color(BlockWood, 2)) || sameColor(got, m.At(0, 1)) {
+		t.Error("expected birch to differ from oak, got ", got)
+	}
+	if got := m.At(0, 2); !sameColor(got, MissingColor) {
+		t.Error("expected an unknown block to be magenta, got ", got)
+	}
+}
+
+func TestLoadColorTable(t *testing.T) {
+	colors, err := LoadColorTable(strings.NewReader("# wool is white, but red is red\n\n35,#ffffff\n 35:14 , ff000080 \n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := woolWorld()
+	buf := new(bytes.Buffer)
+	if err = w.RenderMap(buf, NewRegion(0, 0, 0, 0), RenderOptions{Colors: colors}); err != nil {
+		t.Fatal(err)
+	}
+	m := decodePNG(t, buf)
+	for _, p := range []struct {
+		px, py int
+		want   image.Color
+	}{
+		{0, 0, image.NRGBAColor{0xff, 0xff, 0xff, 0xff}},
+		{13, 0, image.NRGBAColor{0xff, 0xff, 0xff, 0xff}},
+		{14, 0, image.NRGBAColor{0xff, 0x00, 0x00, 0x80}},
+		{0, 1, MissingColor},
+		{0, 3, image.NRGBAColor{}},
+	} {
+		if got := m.At(p.px, p.py); !sameColor(got, p.want) {
+			t.Errorf("(%d, %d): expected %v, got %v", p.px, p.py, p.want, got)
+		}
+	}
+
+	// setting a color for every data value forgets those given before
+	colors.Set(BlockWool, blockColors[BlockStone])
+	if got := colors.Color(BlockWool, 14); !sameColor(got, blockColors[BlockStone]) {
+		t.Error("expected stone, got ", got)
+	}
+	if sameColor(DefaultColorTable().Color(BlockWool, 14), defaultColors.Color(BlockWool, 0)) {
+		t.Error("expected the default table to tell wool apart")
+	}
+
+	for _, bad := range []string{"35", "256,ffffff", "35:16,ffffff", "x,ffffff", "35,fffff", "35,#gggggg"} {
+		if _, err := LoadColorTable(strings.NewReader(bad)); err == nil {
+			t.Errorf("expected an error for %q", bad)
+		}
+	}
+}
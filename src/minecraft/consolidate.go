@@ -0,0 +1,162 @@
+package world
+
+import "minecraft/error"
+
+import "math"
+import "os"
+import "sort"
+
+// drop is a dropped item together with the chunk holding it.
+type drop struct {
+	*Entity
+	c *Chunk
+}
+
+// dropSlice sorts drops by x.
+type dropSlice []drop
+
+func (p dropSlice) Len() int           { return len(p) }
+func (p dropSlice) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
+func (p dropSlice) Less(i, j int) bool { return p[i].Physics.Position.X < p[j].Physics.Position.X }
+
+// ConsolidateItems merges the dropped items in region (nil meaning the whole
+// world) the way the game merges nearby stacks.  Drops of the same id and damage
+// within mergeRadius of the first of them are combined into as few stacks as will
+// hold them, at the group's centre, and the rest are removed.  Drops older than
+// maxAge ticks, if it is positive, are removed first; the game despawns them at
+// 6000.  It returns how many drops there were before and after.  The chunks in
+// region are made resident so that groups can span chunk borders; those changed
+// are marked dirty, and their Locks are held throughout.  If a group's centre
+// lies in a chunk that is not in region, as it can where region has holes, that
+// group is left alone and the error returned, with the groups before it merged.
+func (world *World) ConsolidateItems(region *Region, mergeRadius float64, maxAge int16) (before, after int, err os.Error) {
+	coords, err := world.ListChunks(region)
+	if err != nil {
+		return
+	}
+	// locked in the order ListChunks sorts them, as lockPair locks them
+	chunks := make([]*Chunk, 0, len(coords))
+	locked := make(map[XZ]*Chunk)
+	defer func() {
+		for _, c := range chunks {
+			c.Unlock()
+		}
+	}()
+	var drops dropSlice
+	for _, xz := range coords {
+		c, err := world.GetChunk(xz.X, xz.Z)
+		if err != nil {
+			return 0, 0, err
+		}
+		c.Lock()
+		chunks = append(chunks, c)
+		locked[MakeXZ(xz.X, xz.Z)] = c
+		kept := c.Level.Entities[:0]
+		for _, e := range c.Level.Entities {
+			if e.Id != "Item" || e.Item == nil {
+				kept = append(kept, e)
+				continue
+			}
+			before++
+			if maxAge > 0 && e.Age != nil && *e.Age > maxAge {
+				c.dirty = true
+				continue
+			}
+			kept = append(kept, e)
+			if e.Item.Count <= 0 || len(e.Item.Extra) > 0 {
+				after++
+			} else {
+				drops = append(drops, drop{e, c})
+			}
+		}
+		c.Level.Entities = kept
+	}
+
+	sort.Sort(drops)
+	grouped := make([]bool, len(drops))
+	removed := make(map[*Entity]bool)
+	for i, seed := range drops {
+		if grouped[i] {
+			continue
+		}
+		group := dropSlice{seed}
+		at := seed.Physics.Position
+		for j := i + 1; j < len(drops) && drops[j].Physics.Position.X-at.X <= mergeRadius; j++ {
+			d := drops[j]
+			if grouped[j] || d.Item.Id != seed.Item.Id || d.Item.Damage != seed.Item.Damage || len(d.Item.Extra) > 0 {
+				continue
+			}
+			if distance(at, d.Physics.Position) <= mergeRadius {
+				group = append(group, d)
+				grouped[j] = true
+			}
+		}
+		var n int
+		if n, err = mergeDrops(group, removed, locked); err != nil {
+			break
+		}
+		after += n
+	}
+
+	if len(removed) > 0 {
+		for _, c := range chunks {
+			kept := c.Level.Entities[:0]
+			for _, e := range c.Level.Entities {
+				if !removed[e] {
+					kept = append(kept, e)
+				}
+			}
+			if len(kept) < len(c.Level.Entities) {
+				c.dirty = true
+			}
+			c.Level.Entities = kept
+		}
+	}
+	return
+}
+
+// mergeDrops combines a group of drops of the same item into as few stacks as
+// will hold them at the group's centre, adding the drops no longer needed to
+// removed.  It returns how many drops remain.  The group is left as it was if its
+// centre lies in none of chunks, which ConsolidateItems holds locked.
+func mergeDrops(group dropSlice, removed map[*Entity]bool, chunks map[XZ]*Chunk) (int, os.Error) {
+	total := 0
+	var centre Position
+	for _, d := range group {
+		total += int(d.Item.Count)
+		centre.X += d.Physics.Position.X
+		centre.Y += d.Physics.Position.Y
+		centre.Z += d.Physics.Position.Z
+	}
+	stackSize := MaxStackSize(group[0].Item.Id)
+	stacks := (total + stackSize - 1) / stackSize
+	if stacks >= len(group) {
+		return len(group), nil
+	}
+	n := float64(len(group))
+	centre = Position{centre.X / n, centre.Y / n, centre.Z / n}
+	cx, cz := centre.ChunkXZ()
+	dest, ok := chunks[MakeXZ(cx, cz)]
+	if !ok {
+		return 0, error.InChunk(cx, cz).Error("cannot move "+group[0].Id+" into unloaded chunk", nil)
+	}
+	for _, d := range group[:stacks] {
+		placeEntity(d.Entity, d.c, dest, centre)
+	}
+	for i, d := range group {
+		if i >= stacks {
+			removed[d.Entity] = true
+			continue
+		}
+		d.Item.Count = int8(min(total, stackSize))
+		total -= int(d.Item.Count)
+		d.Physics.Velocity = Velocity{}
+		d.c.dirty = true
+	}
+	return stacks, nil
+}
+
+func distance(a, b Position) float64 {
+	dx, dy, dz := a.X-b.X, a.Y-b.Y, a.Z-b.Z
+	return math.Sqrt(dx*dx + dy*dy + dz*dz)
+}
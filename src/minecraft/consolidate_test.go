@@ -0,0 +1,116 @@
+package world
+
+import "testing"
+
+// itemFarmWorld returns a world with 500 drops of cobblestone heaped on the border
+// between chunks (0, 0) and (1, 0), and the number of items they hold.
+func itemFarmWorld() (w *World, total int) {
+	w = &World{Chunks: make(map[XZ]*Chunk)}
+	w.Chunks[MakeXZ(0, 0)] = newChunk(0, 0)
+	w.Chunks[MakeXZ(1, 0)] = newChunk(1, 0)
+	for i := 0; i < 500; i++ {
+		count := int8(i%7 + 1)
+		x := 15 + float64(i%20)/10
+		z := 8 + float64(i%13)/10
+		w.AddEntity(NewItemDrop(x, 64, z, Item{Id: BlockCobblestone, Count: count}))
+		total += int(count)
+	}
+	for _, c := range w.Chunks {
+		c.dirty = false
+	}
+	return
+}
+
+func countItems(w *World, id int16) (drops, items int) {
+	for _, c := range w.Chunks {
+		for _, e := range c.Level.Entities {
+			if e.Item != nil && e.Item.Id == id {
+				drops++
+				items += int(e.Item.Count)
+			}
+		}
+	}
+	return
+}
+
+func TestConsolidateItems(t *testing.T) {
+	w, total := itemFarmWorld()
+	before, after, err := w.ConsolidateItems(NewRegion(0, 0, 1, 0), 3, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stacks := (total + 63) / 64
+	if before != 500 || after != stacks {
+		t.Errorf("expected 500 drops to become %d, got %d to %d", stacks, before, after)
+	}
+	if drops, items := countItems(w, BlockCobblestone); drops != stacks || items != total {
+		t.Errorf("expected %d items in %d drops, got %d in %d", total, stacks, items, drops)
+	}
+	for _, c := range w.Chunks {
+		if !c.Dirty() {
+			t.Errorf("chunk (%d, %d) not marked dirty", c.Level.XPos, c.Level.ZPos)
+		}
+		for _, e := range c.Level.Entities {
+			if cx, _ := e.Physics.Position.ChunkXZ(); cx != c.Level.XPos {
+				t.Error("merged stack in the wrong chunk: ", e.Physics.Position)
+			}
+		}
+	}
+}
+
+func TestConsolidateItemsKeepsApart(t *testing.T) {
+	w := &World{Chunks: make(map[XZ]*Chunk)}
+	w.Chunks[MakeXZ(0, 0)] = newChunk(0, 0)
+	for _, item := range []Item{
+		{Id: BlockDirt, Count: 10},
+		{Id: BlockDirt, Count: 10},
+		{Id: 35, Damage: 14, Count: 1}, // red wool
+		{Id: 35, Damage: 11, Count: 1}, // blue wool
+		{Id: 276, Count: 1},            // diamond swords do not stack
+		{Id: 276, Count: 1},
+	} {
+		w.AddEntity(NewItemDrop(4, 64, 4, item))
+	}
+	w.AddEntity(NewItemDrop(12, 64, 12, Item{Id: BlockDirt, Count: 10}))
+	before, after, err := w.ConsolidateItems(NewRegion(0, 0, 0, 0), 2, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if before != 7 || after != 6 {
+		t.Errorf("expected 7 drops to become 6, got %d to %d", before, after)
+	}
+	if drops, items := countItems(w, BlockDirt); drops != 2 || items != 30 {
+		t.Errorf("expected 30 dirt in 2 drops, got %d in %d", items, drops)
+	}
+}
+
+func TestConsolidateItemsExpires(t *testing.T) {
+	w := &World{Chunks: make(map[XZ]*Chunk)}
+	w.Chunks[MakeXZ(0, 0)] = newChunk(0, 0)
+	for i, age := range []int16{0, 5999, 6000, 6001, 32000} {
+		e := NewItemDrop(float64(i), 64, 4, Item{Id: BlockSand, Count: 1})
+		*e.Age = age
+		w.AddEntity(e)
+	}
+	before, after, err := w.ConsolidateItems(NewRegion(0, 0, 0, 0), 0, 6000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if before != 5 || after != 3 {
+		t.Errorf("expected 5 drops to become 3, got %d to %d", before, after)
+	}
+}
+
+func TestConsolidateItemsAcrossHole(t *testing.T) {
+	w := &World{Chunks: make(map[XZ]*Chunk)}
+	w.Chunks[MakeXZ(0, 0)] = newChunk(0, 0)
+	w.Chunks[MakeXZ(2, 0)] = newChunk(2, 0)
+	w.AddEntity(NewItemDrop(15.5, 64, 4, Item{Id: BlockDirt, Count: 10}))
+	w.AddEntity(NewItemDrop(32.5, 64, 4, Item{Id: BlockDirt, Count: 10}))
+	if _, _, err := w.ConsolidateItems(NewRegion(0, 0, 2, 0), 20, 0); err == nil {
+		t.Error("expected an error merging drops into a missing chunk")
+	}
+	if drops, items := countItems(w, BlockDirt); drops != 2 || items != 20 {
+		t.Errorf("expected the drops left alone, got %d dirt in %d drops", items, drops)
+	}
+}
@@ -0,0 +1,146 @@
+package world
+
+import "minecraft/error"
+
+import "bufio"
+import "fmt"
+import "io"
+import "os"
+import "strconv"
+import "strings"
+
+// see: http://tools.ietf.org/html/rfc4180
+
+// csvWriter writes rows of comma-separated fields, keeping the first error.
+type csvWriter struct {
+	w   *bufio.Writer
+	err os.Error
+}
+
+func newCSVWriter(w io.Writer) *csvWriter {
+	return &csvWriter{w: bufio.NewWriter(w)}
+}
+
+// row writes one record, quoting the fields that need it, and reports whether
+// it was written.
+func (cw *csvWriter) row(fields ...string) bool {
+	if cw.err != nil {
+		return false
+	}
+	for i, field := range fields {
+		if i > 0 {
+			cw.w.WriteByte(',')
+		}
+		cw.w.WriteString(csvField(field))
+	}
+	_, cw.err = cw.w.WriteString("\r\n")
+	return cw.err == nil
+}
+
+// flush finishes writing and returns the first error met.
+func (cw *csvWriter) flush() os.Error {
+	if cw.err == nil {
+		cw.err = cw.w.Flush()
+	}
+	if cw.err != nil {
+		return error.NewError("could not write CSV", cw.err)
+	}
+	return nil
+}
+
+// csvField returns s as a CSV field: unchanged unless it holds a comma, a quote
+// or a line break, in which case it is quoted with its quotes doubled.
+func csvField(s string) string {
+	if strings.IndexAny(s, ",\"\r\n") < 0 {
+		return s
+	}
+	return "\"" + strings.Replace(s, "\"", "\"\"", -1) + "\""
+}
+
+// csvFloat formats v with as many digits as it takes to read it back exactly.
+func csvFloat(v float64) string {
+	return strconv.Ftoa64(v, 'g', -1)
+}
+
+func csvFloat32(v float32) string {
+	return strconv.Ftoa32(v, 'g', -1)
+}
+
+// ExportEntitiesCSV writes a CSV table of the entities in region (nil meaning the
+// whole world), one row per entity under a header row, with the columns
+// id, x, y, z, yaw, pitch, health, chunkX, chunkZ, itemId, itemCount and
+// itemDamage.  health is empty for entities without it, and the item columns are
+// empty but for item drops.  A vehicle, which the game stores within its rider,
+// gets a row of its own after the rider's.  Rows follow the order of ListChunks,
+// and within a chunk the order the game stored them in.  Chunks are decoded
+// without their blocks and are not kept, as by EntityCensus.
+func (world *World) ExportEntitiesCSV(w io.Writer, region *Region) os.Error {
+	cw := newCSVWriter(w)
+	cw.row("id", "x", "y", "z", "yaw", "pitch", "health", "chunkX", "chunkZ", "itemId", "itemCount", "itemDamage")
+	err := world.scanEntities(region, func(xz ChunkCoord, entities []*Entity) bool {
+		cx, cz := fmt.Sprint(xz.X), fmt.Sprint(xz.Z)
+		for _, e := range entities {
+			for depth := 0; e != nil && depth <= maxRidingDepth; depth++ {
+				p, r := e.Physics.Position, e.Physics.Euler
+				health := ""
+				if e.Health != nil {
+					health = fmt.Sprint(*e.Health)
+				}
+				var itemId, itemCount, itemDamage string
+				if e.Item != nil {
+					itemId, itemCount, itemDamage = fmt.Sprint(e.Item.Id), fmt.Sprint(e.Item.Count), fmt.Sprint(e.Item.Damage)
+				}
+				if !cw.row(e.Id, csvFloat(p.X), csvFloat(p.Y), csvFloat(p.Z), csvFloat32(r.Yaw), csvFloat32(r.Pitch),
+					health, cx, cz, itemId, itemCount, itemDamage) {
+					return false
+				}
+				e = e.Riding
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	return cw.flush()
+}
+
+// ExportTileEntitiesCSV writes a CSV table of the tile entities in region (nil
+// meaning the whole world), as ExportEntitiesCSV does for entities, with the
+// columns id, x, y, z, chunkX, chunkZ and summary.  The summary depends on the
+// kind: a sign's four lines joined by line breaks, how many of a chest's slots
+// are used, a furnace's burn and cook times, the mob a spawner spawns, and
+// nothing for other kinds.
+func (world *World) ExportTileEntitiesCSV(w io.Writer, region *Region) os.Error {
+	cw := newCSVWriter(w)
+	cw.row("id", "x", "y", "z", "chunkX", "chunkZ", "summary")
+	err := world.scanTileEntities(region, func(xz ChunkCoord, tes []TileEntity) bool {
+		cx, cz := fmt.Sprint(xz.X), fmt.Sprint(xz.Z)
+		for _, te := range tes {
+			if !cw.row(te.Id(), fmt.Sprint(te.X()), fmt.Sprint(te.Y()), fmt.Sprint(te.Z()), cx, cz, tileEntitySummary(te)) {
+				return false
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	return cw.flush()
+}
+
+// tileEntitySummary describes what a tile entity holds in a few words.
+func tileEntitySummary(te TileEntity) string {
+	switch te := te.(type) {
+	case *Sign:
+		lines := te.Lines()
+		return strings.Join(lines[:], "\n")
+	case *Chest:
+		return fmt.Sprintf("%d of %d slots used", len(te.Slots), ChestSlots)
+	case *Furnace:
+		return fmt.Sprintf("burn time %d, cook time %d", te.BurnTime, te.CookTime)
+	case *MobSpawner:
+		return "spawns " + te.EntityId
+	}
+	return ""
+}
@@ -0,0 +1,76 @@
+package world
+
+import "bytes"
+import "io/ioutil"
+import "os"
+import "testing"
+
+var csvSignFixture = tileEntityCompound("Sign", 12, 66, -20, map[string]interface{}{
+	"Text1": "Say \"hi\",",
+	"Text2": "Bob",
+	"Text3": "",
+	"Text4": "",
+})
+
+func makeCSVWorld(t *testing.T) string {
+	skeleton := mobFixture("Skeleton", map[string]interface{}{"Riding": mobFixture("Spider", nil)})
+	return makeTestWorld(t,
+		testChunkPayload(0, 0, []interface{}{pigFixture, skeleton, itemAt(8.1, 64.7, 1.0/3)}, nil),
+		testChunkPayload(0, -2, nil, []interface{}{chestFixture, furnaceFixture, signFixture, csvSignFixture}),
+		testChunkPayload(-1, 0, []interface{}{itemFixture}, []interface{}{spawnerFixture}))
+}
+
+func TestCSVField(t *testing.T) {
+	for in, want := range map[string]string{
+		"":           "",
+		"plain":      "plain",
+		"a,b":        "\"a,b\"",
+		"say \"x\"":  "\"say \"\"x\"\"\"",
+		"two\nlines": "\"two\nlines\"",
+	} {
+		if got := csvField(in); got != want {
+			t.Errorf("%q: expected %q, got %q", in, want, got)
+		}
+	}
+}
+
+func TestExportCSV(t *testing.T) {
+	dir := makeCSVWorld(t)
+	defer os.RemoveAll(dir)
+	w, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	for _, test := range []struct {
+		golden string
+		export func(buf *bytes.Buffer) os.Error
+	}{
+		{"testdata/entities.csv", func(buf *bytes.Buffer) os.Error { return w.ExportEntitiesCSV(buf, nil) }},
+		{"testdata/tileentities.csv", func(buf *bytes.Buffer) os.Error { return w.ExportTileEntitiesCSV(buf, nil) }},
+	} {
+		buf := new(bytes.Buffer)
+		if err := test.export(buf); err != nil {
+			t.Fatal(err)
+		}
+		want, err := ioutil.ReadFile(test.golden)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(buf.Bytes(), want) {
+			t.Errorf("%s: expected\n%s\ngot\n%s", test.golden, want, buf)
+		}
+	}
+	if len(w.Chunks) != 0 {
+		t.Error("expected no chunks to be made resident, got ", len(w.Chunks))
+	}
+
+	buf := new(bytes.Buffer)
+	if err := w.ExportEntitiesCSV(buf, NewRegion(5, 5, 6, 6)); err != nil {
+		t.Fatal(err)
+	}
+	if got := buf.String(); got != "id,x,y,z,yaw,pitch,health,chunkX,chunkZ,itemId,itemCount,itemDamage\r\n" {
+		t.Error("expected only the header for an empty region, got ", got)
+	}
+}
@@ -0,0 +1,321 @@
+package nbt
+
+import "minecraft/error"
+
+import "fmt"
+import "io"
+import "math"
+import "os"
+
+// A Decoder reads tags from a stream.  It reads the fixed-size parts of each
+// tag, and the bytes of strings, into buffers of its own, so that decoding
+// allocates only for the values it returns.  The functions ReadCompound,
+// ReadList and so on each decode with a Decoder of their own; decoding many
+// tags from one stream is cheaper with one Decoder.
+type Decoder struct {
+	r       io.Reader
+	offset  int64 // the bytes read so far
+	scratch [8]byte
+	text    []byte // the bytes of the last string read
+}
+
+// NewDecoder returns a Decoder reading from r.
+func NewDecoder(r io.Reader) *Decoder {
+	return &Decoder{r: r}
+}
+
+// Offset returns the number of bytes read from the stream so far, which is
+// where a failed read stopped.
+func (d *Decoder) Offset() int64 {
+	return d.offset
+}
+
+// readFull fills b from the stream, counting the bytes read.
+func (d *Decoder) readFull(b []byte) os.Error {
+	n, err := io.ReadFull(d.r, b)
+	d.offset += int64(n)
+	return err
+}
+
+// read reads the next n bytes, at most 8, into d.scratch.
+func (d *Decoder) read(n int) (b []byte, err os.Error) {
+	b = d.scratch[:n]
+	err = d.readFull(b)
+	return
+}
+
+func (d *Decoder) ReadInt8() (i int8, err os.Error) {
+	b, err := d.read(1)
+	return int8(b[0]), err
+}
+
+func (d *Decoder) ReadInt16() (i int16, err os.Error) {
+	b, err := d.read(2)
+	return int16(uint16(b[1]) | uint16(b[0])<<8), err
+}
+
+func (d *Decoder) ReadInt32() (i int32, err os.Error) {
+	b, err := d.read(4)
+	return int32(uint32(b[3]) | uint32(b[2])<<8 | uint32(b[1])<<16 | uint32(b[0])<<24), err
+}
+
+func (d *Decoder) ReadInt64() (i int64, err os.Error) {
+	b, err := d.read(8)
+	hi := uint64(uint32(b[3]) | uint32(b[2])<<8 | uint32(b[1])<<16 | uint32(b[0])<<24)
+	lo := uint64(uint32(b[7]) | uint32(b[6])<<8 | uint32(b[5])<<16 | uint32(b[4])<<24)
+	return int64(hi<<32 | lo), err
+}
+
+func (d *Decoder) ReadFloat32() (f float32, err os.Error) {
+	i, err := d.ReadInt32()
+	return math.Float32frombits(uint32(i)), err
+}
+
+func (d *Decoder) ReadFloat64() (f float64, err os.Error) {
+	i, err := d.ReadInt64()
+	return math.Float64frombits(uint64(i)), err
+}
+
+// ReadString reads a string stored in modified UTF-8.
+func (d *Decoder) ReadString() (s string, err os.Error) {
+	var strlen int16
+	if strlen, err = d.ReadInt16(); err != nil {
+		return
+	}
+	if strlen < 0 {
+		err = error.NewError("string length cannot be < 0", nil)
+		return
+	}
+	if cap(d.text) < int(strlen) {
+		d.text = make([]byte, strlen, 64+int(strlen))
+	}
+	d.text = d.text[:strlen]
+	if err = d.readFull(d.text); err != nil {
+		return
+	}
+	return DecodeModifiedUTF8(d.text)
+}
+
+func (d *Decoder) ReadNamedTag() (t NamedTag, err os.Error) {
+	var tag int8
+	if tag, err = d.ReadInt8(); err != nil {
+		err = error.NewError("could not read tag type", err)
+		return
+	}
+	t.Type = TagType(tag)
+	if t.Type == End {
+		// end tags have no name; not even a bytelen of 0 for name
+		return
+	}
+	if t.Name, err = d.ReadString(); err != nil {
+		return
+	}
+	return
+}
+
+// ReadPayload reads a payload of type ttype, returning it as Load would.
+func (d *Decoder) ReadPayload(ttype TagType) (payload interface{}, err os.Error) {
+	switch ttype {
+	case End:
+		err = (os.ErrorString)("nbt.readPayload: tag type End has no payload")
+	case Byte:
+		payload, err = d.ReadInt8()
+		if err != nil {
+			err = error.NewError("could not read payload byte", err)
+		}
+	case Short:
+		payload, err = d.ReadInt16()
+		if err != nil {
+			err = error.NewError("could not read payload short", err)
+		}
+	case Int:
+		payload, err = d.ReadInt32()
+		if err != nil {
+			err = error.NewError("could not read payload int", err)
+		}
+	case Long:
+		payload, err = d.ReadInt64()
+		if err != nil {
+			err = error.NewError("could not read payload long", err)
+		}
+	case Float:
+		payload, err = d.ReadFloat32()
+		if err != nil {
+			err = error.NewError("could not read payload float", err)
+		}
+	case Double:
+		payload, err = d.ReadFloat64()
+		if err != nil {
+			err = error.NewError("could not read payload double", err)
+		}
+	case ByteArray:
+		payload, err = d.ReadByteArray()
+		if err != nil {
+			err = error.NewError("could not read payload byte array", err)
+		}
+	case String:
+		payload, err = d.ReadString()
+		if err != nil {
+			err = error.NewError("could not read payload string", err)
+		}
+	case List:
+		payload, err = d.ReadList()
+		if err != nil {
+			err = error.NewError("could not read payload list", err)
+		}
+	case Compound:
+		payload, err = d.ReadCompound()
+		if err != nil {
+			err = error.NewError("could not read payload compound", err)
+		}
+	default:
+		err = (os.ErrorString)(fmt.Sprint("nbt.readPayload: unknown payload type ", ttype))
+	}
+	return
+}
+
+func (d *Decoder) ReadByteArray() (b []byte, err os.Error) {
+	return d.ReadByteArrayInto(nil)
+}
+
+// ReadByteArrayInto reads a byte array into buf if the array is buf's length,
+// and into a new slice otherwise, returning the slice read into.  It lets the
+// caller decode arrays of known lengths into memory it has set aside for them.
+func (d *Decoder) ReadByteArrayInto(buf []byte) (b []byte, err os.Error) {
+	var length int32
+	if length, err = d.ReadInt32(); err != nil {
+		err = error.NewError("could not read byte array's length", err)
+		return
+	}
+	if length < 0 {
+		err = error.NewError("byte array's length cannot be < 0", nil)
+		return
+	}
+	b = buf
+	if b == nil || len(b) != int(length) {
+		b = make([]byte, length)
+	}
+	if err = d.readFull(b); err != nil {
+		err = error.NewError("could not read byte array", err)
+	}
+	return
+}
+
+func (d *Decoder) ReadList() (l []interface{}, err os.Error) {
+	var ttypei8 int8
+	var llen int32
+
+	if ttypei8, err = d.ReadInt8(); err != nil {
+		err = error.NewError("could not read list type", err)
+		return
+	}
+	if llen, err = d.ReadInt32(); err != nil {
+		err = error.NewError("could not read list length", err)
+		return
+	}
+	if llen < 0 {
+		err = error.NewError("list length cannot be < 0", nil)
+		return
+	}
+	ttype := TagType(ttypei8)
+	l = make([]interface{}, int(llen))
+	for i := int32(0); i < llen; i++ {
+		var payload interface{}
+		if payload, err = d.ReadPayload(ttype); err != nil {
+			err = error.NewError(fmt.Sprint("could not read list payload at index", i), nil)
+			return
+		}
+		l[i] = payload
+	}
+	return
+}
+
+func (d *Decoder) ReadCompound() (c map[string]interface{}, err os.Error) {
+	c = make(map[string]interface{})
+	var tag NamedTag
+	for {
+		if tag, err = d.ReadNamedTag(); err != nil {
+			err = error.NewError("could not read named tag", err)
+			return
+		}
+		if tag.Type == End {
+			return
+		}
+		if c[tag.Name], err = d.ReadPayload(tag.Type); err != nil {
+			err = error.NewError("could not read payload", err)
+			return
+		}
+	}
+	panic("shouldn't get here")
+}
+
+// ReadCompoundFiltered reads a compound, decoding only the tags keep accepts.
+// Nested compounds are filtered the same way; lists are decoded whole.
+func (d *Decoder) ReadCompoundFiltered(keep Filter) (c map[string]interface{}, err os.Error) {
+	c = make(map[string]interface{})
+	var tag NamedTag
+	for {
+		if tag, err = d.ReadNamedTag(); err != nil {
+			err = error.NewError("could not read named tag", err)
+			return
+		}
+		switch {
+		case tag.Type == End:
+			return
+		case !keep(tag.Name, tag.Type):
+			err = d.SkipPayload(tag.Type)
+		case tag.Type == Compound:
+			c[tag.Name], err = d.ReadCompoundFiltered(keep)
+		default:
+			c[tag.Name], err = d.ReadPayload(tag.Type)
+		}
+		if err != nil {
+			err = error.NewError(fmt.Sprint("could not read payload of ", tag.Name), err)
+			return
+		}
+	}
+	panic("shouldn't get here")
+}
+
+// skipBuffers is a free list of the buffers SkipPayload reads skipped byte
+// arrays into, so that skipping the arrays of many chunks does not allocate a
+// buffer for each.
+var skipBuffers = make(chan []byte, 16)
+
+// SkipPayload reads past a payload of type ttype.  Byte arrays are skipped
+// without being buffered.
+func (d *Decoder) SkipPayload(ttype TagType) (err os.Error) {
+	if ttype != ByteArray {
+		_, err = d.ReadPayload(ttype)
+		return
+	}
+	var length int32
+	if length, err = d.ReadInt32(); err != nil {
+		err = error.NewError("could not read byte array's length", err)
+		return
+	}
+	if length < 0 {
+		return error.NewError("byte array's length cannot be < 0", nil)
+	}
+	var buf []byte
+	select {
+	case buf = <-skipBuffers:
+	default:
+		buf = make([]byte, 4096)
+	}
+	for n := int(length); n > 0 && err == nil; n -= len(buf) {
+		if n < len(buf) {
+			err = d.readFull(buf[:n])
+		} else {
+			err = d.readFull(buf)
+		}
+	}
+	select {
+	case skipBuffers <- buf:
+	default:
+	}
+	if err != nil {
+		err = error.NewError("could not skip byte array", err)
+	}
+	return
+}
@@ -0,0 +1,47 @@
+package nbt
+
+import "bytes"
+import "testing"
+
+func TestDecoder(t *testing.T) {
+	buf := new(bytes.Buffer)
+	for _, b := range [][]byte{{1, 2, 3}, {4, 5}, {}} {
+		if err := WriteByteArray(buf, b); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := WriteTagCompound(buf, "all", allTypesPayload); err != nil {
+		t.Fatal(err)
+	}
+	WriteInt64(buf, -2)
+	d := NewDecoder(buf)
+
+	into := make([]byte, 3)
+	if b, err := d.ReadByteArrayInto(into); err != nil || &b[0] != &into[0] || !bytes.Equal(b, []byte{1, 2, 3}) {
+		t.Errorf("expected the array read into the buffer given, got %v (%v)", b, err)
+	}
+	if b, err := d.ReadByteArrayInto(into); err != nil || &b[0] == &into[0] || !bytes.Equal(b, []byte{4, 5}) {
+		t.Errorf("expected the array read into a new slice, got %v (%v)", b, err)
+	}
+	if b, err := d.ReadByteArrayInto(nil); err != nil || b == nil || len(b) != 0 {
+		t.Errorf("expected an empty array, got %v (%v)", b, err)
+	}
+
+	tag, err := d.ReadNamedTag()
+	if err != nil || tag.Type != Compound || tag.Name != "all" {
+		t.Fatalf("expected compound \"all\", got %v (%v)", tag, err)
+	}
+	payload, err := d.ReadCompound()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !Equal(payload, allTypesPayload) {
+		t.Errorf("expected %v, got %v", allTypesPayload, payload)
+	}
+	if i, err := d.ReadInt64(); err != nil || i != -2 {
+		t.Errorf("expected -2, got %d (%v)", i, err)
+	}
+	if _, err := d.ReadInt8(); err == nil {
+		t.Error("expected an error at the end of the stream")
+	}
+}
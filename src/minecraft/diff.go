@@ -0,0 +1,423 @@
+package world
+
+import "minecraft/error"
+import "minecraft/nbt"
+
+import "bytes"
+import "fmt"
+import "io"
+import "os"
+
+// DiffOptions control what Diff compares.
+type DiffOptions struct {
+	// MaxPositions is how many changed blocks to list for each chunk; zero lists
+	// none, and they are only counted.
+	MaxPositions int
+	// SkipBlocks leaves the blocks out, so that chunks are read without them.
+	SkipBlocks bool
+	// SkipEntities leaves the entities and tile entities out, so that chunks are
+	// read without them.
+	SkipEntities bool
+}
+
+// A ChunkStatus says how a chunk differs between two worlds.
+type ChunkStatus int
+
+const (
+	ChunkIdentical ChunkStatus = iota // the same in both worlds
+	ChunkChanged                      // in both worlds, but different
+	ChunkOnlyInA                      // in the first world alone
+	ChunkOnlyInB                      // in the second world alone
+)
+
+var chunkStatusNames = []string{"identical", "changed", "only in a", "only in b"}
+
+func (s ChunkStatus) String() string {
+	return chunkStatusNames[s]
+}
+
+// BlockPos names a block by its absolute coordinates.
+type BlockPos struct {
+	X, Y, Z int32
+}
+
+// An EntityMove is an entity that moved within its chunk: From is as it was in
+// the first world and To as it is in the second.
+type EntityMove struct {
+	From, To *Entity
+}
+
+// A ChunkDiff is how a chunk differs between two worlds, as Diff finds it.  Only
+// the status of chunks in one world alone is set.
+type ChunkDiff struct {
+	X, Z   int32
+	Status ChunkStatus
+
+	// Blocks counts the blocks whose id or data value differ, and Positions
+	// lists the first opts.MaxPositions of them in the order they are stored,
+	// x slowest.
+	Blocks    int
+	Positions []BlockPos
+
+	// Added and Removed are the entities in only the second and only the first
+	// world.  An entity counts as moved if all that changed is its position,
+	// motion and rotation; one that moved to another chunk is removed from the
+	// one and added to the other.
+	Added, Removed []*Entity
+	Moved          []EntityMove
+
+	// The tile entities are matched by position, and a tile entity counts as
+	// changed if any of its tags did.
+	TileEntitiesAdded, TileEntitiesRemoved, TileEntitiesChanged []TileEntity
+}
+
+// String summarizes d on one line, as in
+// "(12,-7) changed: 3 blocks, entities +1 -0 ~2, tile entities +0 -0 ~1".
+func (d ChunkDiff) String() string {
+	s := fmt.Sprint(formatXZ(d.X, d.Z), " ", d.Status)
+	if d.Status != ChunkChanged {
+		return s
+	}
+	return s + fmt.Sprintf(": %d blocks, entities +%d -%d ~%d, tile entities +%d -%d ~%d", d.Blocks,
+		len(d.Added), len(d.Removed), len(d.Moved),
+		len(d.TileEntitiesAdded), len(d.TileEntitiesRemoved), len(d.TileEntitiesChanged))
+}
+
+// A WorldDiff is the result of Diff.
+type WorldDiff struct {
+	// Chunks holds every chunk in either world, ordered as by ListChunks.
+	Chunks []ChunkDiff
+}
+
+// Diff compares region (nil meaning every chunk in either world) of world a, as
+// before, with world b, as after, chunk by chunk.  Both worlds are streamed in
+// step, in the order of ListChunks; chunks that are not resident are decoded
+// without what opts skips, and are not kept.  Neither world is changed.
+func Diff(a, b *World, region *Region, opts DiffOptions) (*WorldDiff, os.Error) {
+	coordsA, err := a.ListChunks(region)
+	if err != nil {
+		return nil, err
+	}
+	coordsB, err := b.ListChunks(region)
+	if err != nil {
+		return nil, err
+	}
+	d := new(WorldDiff)
+	for i, j := 0, 0; i < len(coordsA) || j < len(coordsB); {
+		var xz ChunkCoord
+		switch {
+		case j == len(coordsB) || i < len(coordsA) && coordBefore(coordsA[i], coordsB[j]):
+			xz = coordsA[i]
+			d.Chunks = append(d.Chunks, ChunkDiff{X: xz.X, Z: xz.Z, Status: ChunkOnlyInA})
+			i++
+			continue
+		case i == len(coordsA) || coordBefore(coordsB[j], coordsA[i]):
+			xz = coordsB[j]
+			d.Chunks = append(d.Chunks, ChunkDiff{X: xz.X, Z: xz.Z, Status: ChunkOnlyInB})
+			j++
+			continue
+		}
+		xz = coordsA[i]
+		i, j = i+1, j+1
+		ca, err := a.diffChunk(xz.X, xz.Z, opts)
+		if err != nil {
+			return nil, err
+		}
+		cb, err := b.diffChunk(xz.X, xz.Z, opts)
+		if err != nil {
+			return nil, err
+		}
+		d.Chunks = append(d.Chunks, compareChunks(ca, cb, xz, opts))
+	}
+	return d, nil
+}
+
+// coordBefore reports whether chunk p comes before chunk q in the order of
+// ListChunks.
+func coordBefore(p, q ChunkCoord) bool {
+	return p.X < q.X || p.X == q.X && p.Z < q.Z
+}
+
+// diffChunk returns the chunk at (x, z), resident or read from disk with only
+// what opts compares.
+func (world *World) diffChunk(x, z int32, opts DiffOptions) (*Chunk, os.Error) {
+	if c, ok := world.resident(MakeXZ(x, z)); ok {
+		return c, c.LoadArrays()
+	}
+	if !opts.SkipEntities {
+		return world.reportChunk(x, z, !opts.SkipBlocks)
+	}
+	c := &Chunk{Level: Level{XPos: x, ZPos: z}}
+	if opts.SkipBlocks {
+		return c, nil
+	}
+	_, chunkmap, err := world.loadChunkMap(x, z, keepBlockArrays)
+	if err != nil {
+		return nil, err
+	}
+	level, err := getCompound(chunkmap, "Level")
+	if err != nil {
+		return nil, error.InChunk(x, z).Error("malformed chunk", err)
+	}
+	for _, array := range []struct {
+		name string
+		dst  *[]byte
+		size int
+	}{
+		{"Blocks", &c.Level.Blocks, chunkBlocks},
+		{"Data", &c.Level.Data, chunkNibbles},
+	} {
+		v, ok := level[array.name].([]byte)
+		if !ok {
+			return nil, error.InChunk(x, z).Error("malformed chunk", tagError(array.name, "byte array", level[array.name]))
+		}
+		if len(v) != array.size {
+			return nil, error.InChunk(x, z).Error(fmt.Sprint(len(v), " bytes of ", array.name, " in chunk"), nil)
+		}
+		*array.dst = v
+	}
+	return c, nil
+}
+
+func keepBlockArrays(name string, ttype nbt.TagType) bool {
+	return keepBlocks(name, ttype) || name == "Data" && ttype == nbt.ByteArray
+}
+
+// compareChunks returns how chunk b differs from chunk a, both at xz.
+func compareChunks(a, b *Chunk, xz ChunkCoord, opts DiffOptions) ChunkDiff {
+	d := ChunkDiff{X: xz.X, Z: xz.Z}
+	if !opts.SkipBlocks {
+		for i := range a.Level.Blocks {
+			if a.Level.Blocks[i] == b.Level.Blocks[i] && getNibble(a.Level.Data, i) == getNibble(b.Level.Data, i) {
+				continue
+			}
+			if d.Blocks++; len(d.Positions) < opts.MaxPositions {
+				y, z, x := int32(i%ChunkHeight), int32(i/ChunkHeight%ChunkDepth), int32(i/(ChunkHeight*ChunkDepth))
+				d.Positions = append(d.Positions, BlockPos{xz.X*ChunkWidth + x, y, xz.Z*ChunkDepth + z})
+			}
+		}
+	}
+	if !opts.SkipEntities {
+		d.Added, d.Removed, d.Moved = compareEntities(a.Level.Entities, b.Level.Entities)
+		d.TileEntitiesAdded, d.TileEntitiesRemoved, d.TileEntitiesChanged = compareTileEntities(a.Level.TileEntities, b.Level.TileEntities)
+	}
+	if d.Blocks > 0 || len(d.Added) > 0 || len(d.Removed) > 0 || len(d.Moved) > 0 ||
+		len(d.TileEntitiesAdded) > 0 || len(d.TileEntitiesRemoved) > 0 || len(d.TileEntitiesChanged) > 0 {
+		d.Status = ChunkChanged
+	}
+	return d
+}
+
+// motionTags are the tags an entity that has only moved may differ in.
+var motionTags = []string{"Pos", "Motion", "Rotation"}
+
+// compareEntities pairs off the entities of a and b that are the same, then
+// those that only moved, and returns the rest of b as added and of a as removed.
+func compareEntities(a, b []*Entity) (added, removed []*Entity, moved []EntityMove) {
+	payloads := func(entities []*Entity) (full, still []map[string]interface{}) {
+		for _, e := range entities {
+			p := fromEntity(e)
+			full = append(full, p)
+			q := copyTags(p)
+			for _, tag := range motionTags {
+				q[tag] = nil, false
+			}
+			still = append(still, q)
+		}
+		return
+	}
+	fullA, stillA := payloads(a)
+	fullB, stillB := payloads(b)
+	pairedA, pairedB := make([]bool, len(a)), make([]bool, len(b))
+	pair := func(pa, pb []map[string]interface{}, f func(i, j int)) {
+		for i := range a {
+			for j := 0; j < len(b) && !pairedA[i]; j++ {
+				if !pairedB[j] && nbt.Equal(pa[i], pb[j]) {
+					pairedA[i], pairedB[j] = true, true
+					f(i, j)
+				}
+			}
+		}
+	}
+	pair(fullA, fullB, func(i, j int) {})
+	pair(stillA, stillB, func(i, j int) {
+		moved = append(moved, EntityMove{a[i], b[j]})
+	})
+	for i, e := range a {
+		if !pairedA[i] {
+			removed = append(removed, e)
+		}
+	}
+	for j, e := range b {
+		if !pairedB[j] {
+			added = append(added, e)
+		}
+	}
+	return
+}
+
+// compareTileEntities matches the tile entities of a and b by position.
+func compareTileEntities(a, b []TileEntity) (added, removed, changed []TileEntity) {
+	matched := make([]bool, len(a))
+	for _, te := range b {
+		i := 0
+		for i < len(a) && (matched[i] || a[i].X() != te.X() || a[i].Y() != te.Y() || a[i].Z() != te.Z()) {
+			i++
+		}
+		switch {
+		case i == len(a):
+			added = append(added, te)
+		case !nbt.Equal(a[i].toCompound(), te.toCompound()):
+			changed = append(changed, te)
+		}
+		if i < len(a) {
+			matched[i] = true
+		}
+	}
+	for i, te := range a {
+		if !matched[i] {
+			removed = append(removed, te)
+		}
+	}
+	return
+}
+
+// counts returns how many chunks have each status.
+func (d *WorldDiff) counts() (n [4]int) {
+	for _, c := range d.Chunks {
+		n[c.Status]++
+	}
+	return
+}
+
+// WriteText writes the differences for people to read: a line for each chunk
+// that is not identical, followed by an indented line for each changed block
+// listed, entity and tile entity, and a last line counting the chunks of each
+// status.
+func (d *WorldDiff) WriteText(w io.Writer) os.Error {
+	buf := new(bytes.Buffer)
+	for _, c := range d.Chunks {
+		switch c.Status {
+		case ChunkIdentical:
+			continue
+		case ChunkChanged:
+			fmt.Fprintf(buf, "chunk (%d, %d): %d blocks changed\n", c.X, c.Z, c.Blocks)
+		default:
+			fmt.Fprintf(buf, "chunk (%d, %d): %s\n", c.X, c.Z, c.Status)
+		}
+		for _, p := range c.Positions {
+			fmt.Fprintf(buf, "\tblock (%d, %d, %d)\n", p.X, p.Y, p.Z)
+		}
+		for _, e := range c.Added {
+			p := e.Physics.Position
+			fmt.Fprintf(buf, "\tadded %s at (%g, %g, %g)\n", e.Id, p.X, p.Y, p.Z)
+		}
+		for _, e := range c.Removed {
+			p := e.Physics.Position
+			fmt.Fprintf(buf, "\tremoved %s at (%g, %g, %g)\n", e.Id, p.X, p.Y, p.Z)
+		}
+		for _, m := range c.Moved {
+			p, q := m.From.Physics.Position, m.To.Physics.Position
+			fmt.Fprintf(buf, "\tmoved %s from (%g, %g, %g) to (%g, %g, %g)\n", m.To.Id, p.X, p.Y, p.Z, q.X, q.Y, q.Z)
+		}
+		for _, list := range []struct {
+			verb string
+			tes  []TileEntity
+		}{
+			{"added", c.TileEntitiesAdded},
+			{"removed", c.TileEntitiesRemoved},
+			{"changed", c.TileEntitiesChanged},
+		} {
+			for _, te := range list.tes {
+				fmt.Fprintf(buf, "\t%s %s at (%d, %d, %d)\n", list.verb, te.Id(), te.X(), te.Y(), te.Z())
+			}
+		}
+	}
+	n := d.counts()
+	fmt.Fprintf(buf, "%d identical, %d changed, %d only in a, %d only in b\n",
+		n[ChunkIdentical], n[ChunkChanged], n[ChunkOnlyInA], n[ChunkOnlyInB])
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// WriteJSON writes the differences as a JSON object:
+//
+//	{"identical": n, "changed": n, "onlyInA": n, "onlyInB": n,
+//	 "chunks": [{"x", "z", "status", "blocks", "positions": [[x, y, z], ...],
+//	            "added", "removed": [{"id", "pos": [x, y, z]}, ...],
+//	            "moved": [{"id", "from", "to"}, ...],
+//	            "tileEntitiesAdded", "tileEntitiesRemoved",
+//	            "tileEntitiesChanged": [{"id", "pos"}, ...]}, ...]}
+//
+// The chunks are those that are not identical, and status is one of "changed",
+// "onlyInA" and "onlyInB".  Chunks in one world alone have only x, z and status.
+func (d *WorldDiff) WriteJSON(w io.Writer) os.Error {
+	entities := func(list []*Entity) []interface{} {
+		a := []interface{}{}
+		for _, e := range list {
+			p := e.Physics.Position
+			a = append(a, jsonObject{{"id", e.Id}, {"pos", []interface{}{p.X, p.Y, p.Z}}})
+		}
+		return a
+	}
+	tileEntities := func(list []TileEntity) []interface{} {
+		a := []interface{}{}
+		for _, te := range list {
+			a = append(a, jsonObject{{"id", te.Id()}, {"pos", []interface{}{te.X(), te.Y(), te.Z()}}})
+		}
+		return a
+	}
+	chunks := []interface{}{}
+	for _, c := range d.Chunks {
+		switch c.Status {
+		case ChunkIdentical:
+			continue
+		case ChunkOnlyInA, ChunkOnlyInB:
+			status := "onlyInA"
+			if c.Status == ChunkOnlyInB {
+				status = "onlyInB"
+			}
+			chunks = append(chunks, jsonObject{{"x", c.X}, {"z", c.Z}, {"status", status}})
+			continue
+		}
+		positions := []interface{}{}
+		for _, p := range c.Positions {
+			positions = append(positions, []interface{}{p.X, p.Y, p.Z})
+		}
+		moved := []interface{}{}
+		for _, m := range c.Moved {
+			p, q := m.From.Physics.Position, m.To.Physics.Position
+			moved = append(moved, jsonObject{
+				{"id", m.To.Id},
+				{"from", []interface{}{p.X, p.Y, p.Z}},
+				{"to", []interface{}{q.X, q.Y, q.Z}},
+			})
+		}
+		chunks = append(chunks, jsonObject{
+			{"x", c.X}, {"z", c.Z}, {"status", "changed"},
+			{"blocks", c.Blocks},
+			{"positions", positions},
+			{"added", entities(c.Added)},
+			{"removed", entities(c.Removed)},
+			{"moved", moved},
+			{"tileEntitiesAdded", tileEntities(c.TileEntitiesAdded)},
+			{"tileEntitiesRemoved", tileEntities(c.TileEntitiesRemoved)},
+			{"tileEntitiesChanged", tileEntities(c.TileEntitiesChanged)},
+		})
+	}
+	n := d.counts()
+	buf := new(bytes.Buffer)
+	err := writeJSON(buf, jsonObject{
+		{"identical", n[ChunkIdentical]},
+		{"changed", n[ChunkChanged]},
+		{"onlyInA", n[ChunkOnlyInA]},
+		{"onlyInB", n[ChunkOnlyInB]},
+		{"chunks", chunks},
+	})
+	if err != nil {
+		return error.NewError("could not encode diff", err)
+	}
+	buf.WriteString("\n")
+	_, err = w.Write(buf.Bytes())
+	return err
+}
@@ -0,0 +1,205 @@
+package world
+
+import "bytes"
+import "io/ioutil"
+import "os"
+import "path"
+import "testing"
+
+// copyWorld copies the world in dir to a new directory and returns it.
+func copyWorld(t *testing.T, dir string) string {
+	clone, err := ioutil.TempDir("", "world")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var copyDir func(from, to string)
+	copyDir = func(from, to string) {
+		files, err := ioutil.ReadDir(from)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, fi := range files {
+			src, dst := path.Join(from, fi.Name), path.Join(to, fi.Name)
+			if fi.IsDirectory() {
+				if err = os.Mkdir(dst, 0755); err != nil {
+					t.Fatal(err)
+				}
+				copyDir(src, dst)
+				continue
+			}
+			data, err := ioutil.ReadFile(src)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err = ioutil.WriteFile(dst, data, 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	copyDir(dir, clone)
+	return clone
+}
+
+// openDiffWorlds opens the world of makeReportWorld and a copy of it, changed by
+// change and flushed, and reopened so that their chunks are read from disk.
+func openDiffWorlds(t *testing.T, change func(w *World)) (a, b *World, dirs []string) {
+	dir := makeReportWorld(t)
+	clone := copyWorld(t, dir)
+	dirs = []string{dir, clone}
+	b, err := Open(clone)
+	if err != nil {
+		t.Fatal(err)
+	}
+	change(b)
+	if err = b.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	b.Close()
+	if a, err = Open(dir); err != nil {
+		t.Fatal(err)
+	}
+	if b, err = Open(clone); err != nil {
+		t.Fatal(err)
+	}
+	return
+}
+
+func TestDiffBlocks(t *testing.T) {
+	a, b, dirs := openDiffWorlds(t, func(w *World) {
+		c, err := w.GetChunk(0, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c.SetBlock(1, 10, 1, BlockStone, 0) // was coal ore
+		c.SetBlock(4, 12, 4, BlockAir, 0)   // was glowing redstone ore
+		c.SetBlock(3, 64, 3, BlockWool, 14) // was air
+		if err = os.Remove(chunkPath(w.dir, 0, -1)); err != nil {
+			t.Fatal(err)
+		}
+	})
+	for _, dir := range dirs {
+		defer os.RemoveAll(dir)
+	}
+	defer a.Close()
+	defer b.Close()
+
+	d, err := Diff(a, b, nil, DiffOptions{MaxPositions: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(d.Chunks) != 2 {
+		t.Fatalf("expected two chunks, got %+v", d.Chunks)
+	}
+	if c := d.Chunks[0]; c.X != 0 || c.Z != -1 || c.Status != ChunkOnlyInA {
+		t.Errorf("expected chunk (0, -1) only in a, got %+v", c)
+	}
+	c := d.Chunks[1]
+	if c.X != 0 || c.Z != 0 || c.Status != ChunkChanged || c.Blocks != 3 || len(c.Positions) != 2 {
+		t.Fatalf("expected three blocks of chunk (0, 0) changed, got %+v", c)
+	}
+	if p, q := c.Positions[0], c.Positions[1]; p.X != 1 || p.Y != 10 || p.Z != 1 || q.X != 3 || q.Y != 64 || q.Z != 3 {
+		t.Error("expected the first two blocks changed, got ", c.Positions)
+	}
+	if len(c.Added)+len(c.Removed)+len(c.Moved)+len(c.TileEntitiesAdded)+len(c.TileEntitiesRemoved)+len(c.TileEntitiesChanged) != 0 {
+		t.Errorf("expected the entities to be the same, got %+v", c)
+	}
+	if len(a.Chunks) != 0 || len(b.Chunks) != 0 {
+		t.Error("expected no chunks to be made resident")
+	}
+
+	buf := new(bytes.Buffer)
+	if err = d.WriteText(buf); err != nil {
+		t.Fatal(err)
+	}
+	want := "chunk (0, -1): only in a\n" +
+		"chunk (0, 0): 3 blocks changed\n" +
+		"\tblock (1, 10, 1)\n" +
+		"\tblock (3, 64, 3)\n" +
+		"0 identical, 1 changed, 1 only in a, 0 only in b\n"
+	if buf.String() != want {
+		t.Errorf("expected\n%s\ngot\n%s", want, buf)
+	}
+	buf.Reset()
+	if err = d.WriteJSON(buf); err != nil {
+		t.Fatal(err)
+	}
+	want = `{"identical": 0, "changed": 1, "onlyInA": 1, "onlyInB": 0, "chunks": [` +
+		`{"x": 0, "z": -1, "status": "onlyInA"}, ` +
+		`{"x": 0, "z": 0, "status": "changed", "blocks": 3, "positions": [[1, 10, 1], [3, 64, 3]], ` +
+		`"added": [], "removed": [], "moved": [], ` +
+		`"tileEntitiesAdded": [], "tileEntitiesRemoved": [], "tileEntitiesChanged": []}]}` + "\n"
+	if buf.String() != want {
+		t.Errorf("expected\n%s\ngot\n%s", want, buf)
+	}
+
+	// the other way round, and only the blocks
+	if d, err = Diff(b, a, nil, DiffOptions{SkipEntities: true}); err != nil {
+		t.Fatal(err)
+	}
+	if n := d.counts(); n[ChunkOnlyInB] != 1 || n[ChunkChanged] != 1 || d.Chunks[1].Blocks != 3 || d.Chunks[1].Positions != nil {
+		t.Errorf("expected the same differences reversed, got %+v", d.Chunks)
+	}
+}
+
+func TestDiffEntities(t *testing.T) {
+	a, b, dirs := openDiffWorlds(t, func(w *World) {
+		c, err := w.GetChunk(0, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var kept []*Entity
+		for _, e := range c.Level.Entities {
+			switch e.Id {
+			case "Pig":
+				e.Physics.Position.X += 2
+			case "Item":
+				continue
+			}
+			kept = append(kept, e)
+		}
+		c.Level.Entities = kept
+		for _, te := range c.Level.TileEntities {
+			if sign, ok := te.(*Sign); ok {
+				sign.Text1 = "hello"
+			}
+		}
+		c.MarkDirty()
+	})
+	for _, dir := range dirs {
+		defer os.RemoveAll(dir)
+	}
+	defer a.Close()
+	defer b.Close()
+
+	d, err := Diff(a, b, nil, DiffOptions{SkipBlocks: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := d.counts(); n[ChunkIdentical] != 1 || n[ChunkChanged] != 1 {
+		t.Fatalf("expected one chunk changed, got %+v", d.Chunks)
+	}
+	c := d.Chunks[1]
+	if len(c.Moved) != 1 || c.Moved[0].From.Physics.Position.X != 4.5 || c.Moved[0].To.Physics.Position.X != 6.5 {
+		t.Errorf("expected the pig to move, got %+v", c.Moved)
+	}
+	if len(c.Removed) != 1 || c.Removed[0].Id != "Item" || len(c.Added) != 0 {
+		t.Errorf("expected the item to be removed, got %+v and %+v", c.Removed, c.Added)
+	}
+	if len(c.TileEntitiesChanged) != 1 || c.TileEntitiesChanged[0].Id() != "Sign" ||
+		len(c.TileEntitiesAdded)+len(c.TileEntitiesRemoved) != 0 {
+		t.Errorf("expected the sign to change, got %+v", c)
+	}
+
+	buf := new(bytes.Buffer)
+	if err = d.WriteText(buf); err != nil {
+		t.Fatal(err)
+	}
+	want := "chunk (0, 0): 0 blocks changed\n" +
+		"\tremoved Item at (-3.125, 70.5, 11.875)\n" +
+		"\tmoved Pig from (4.5, 65, 9.25) to (6.5, 65, 9.25)\n" +
+		"\tchanged Sign at (6, 64, 6)\n" +
+		"1 identical, 1 changed, 0 only in a, 0 only in b\n"
+	if buf.String() != want {
+		t.Errorf("expected\n%s\ngot\n%s", want, buf)
+	}
+}
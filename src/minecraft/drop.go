@@ -0,0 +1,51 @@
+package world
+
+import "minecraft/error"
+
+import "fmt"
+import "os"
+import "rand"
+
+// SpawnItem drops count of item at (x, y, z), at rest, the way the game drops a
+// broken block's item.  Counts past the item's stack size are split over several
+// drops, the first of which is returned.
+func (world *World) SpawnItem(x, y, z float64, item Item, count int8) (*Entity, os.Error) {
+	item.Count = count
+	drops, err := world.SpawnItems(x, y, z, []Item{item}, false)
+	if err != nil {
+		return nil, err
+	}
+	return drops[0], nil
+}
+
+// SpawnItems drops each of items at (x, y, z), split into stacks the game allows.
+// With scatter set the drops are thrown about like a broken chest's contents;
+// otherwise they are at rest.  Nothing is spawned if any item is invalid.
+func (world *World) SpawnItems(x, y, z float64, items []Item, scatter bool) (drops []*Entity, err os.Error) {
+	for _, item := range items {
+		if item.Count <= 0 {
+			return nil, error.NewError(fmt.Sprintf("cannot drop %d of item %d", item.Count, item.Id), nil)
+		}
+		stack := MaxStackSize(item.Id)
+		for n := int(item.Count); n > 0; n -= stack {
+			drop := item
+			drop.Count = int8(min(n, stack))
+			if item.Extra != nil {
+				drop.Extra = copyTags(item.Extra)
+			}
+			e := NewItemDrop(x, y, z, drop)
+			if scatter {
+				// as EntityItem's constructor does
+				e.Physics.Velocity = Velocity{rand.Float64()*0.2 - 0.1, 0.2, rand.Float64()*0.2 - 0.1}
+				e.Physics.Euler.Yaw = float32(rand.Float64() * 360)
+			}
+			drops = append(drops, e)
+		}
+	}
+	for _, e := range drops {
+		if err = world.AddEntity(e); err != nil {
+			return nil, err
+		}
+	}
+	return
+}
@@ -0,0 +1,67 @@
+package world
+
+import "minecraft/nbt"
+
+import "testing"
+
+func TestSpawnItem(t *testing.T) {
+	w := &World{Chunks: make(map[XZ]*Chunk)}
+	w.Chunks[MakeXZ(-1, 0)] = newChunk(-1, 0)
+	e, err := w.SpawnItem(-3.5, 70, 11.5, Item{Id: BlockCobblestone}, 17)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// as written by the game for a freshly mined block, less its random motion
+	vanilla := map[string]interface{}{
+		"id":           "Item",
+		"Pos":          []interface{}{float64(-3.5), float64(70), float64(11.5)},
+		"Motion":       []interface{}{float64(0), float64(0), float64(0)},
+		"Rotation":     []interface{}{float32(0), float32(0)},
+		"FallDistance": float32(0),
+		"Fire":         int16(0),
+		"Air":          int16(300),
+		"OnGround":     int8(0),
+		"Health":       int16(5),
+		"Age":          int16(0),
+		"Item": map[string]interface{}{
+			"id":     int16(BlockCobblestone),
+			"Count":  int8(17),
+			"Damage": int16(0),
+		},
+	}
+	if encoded := fromEntity(e); !nbt.Equal(encoded, vanilla) {
+		t.Error("expected ", vanilla, ", got ", encoded)
+	}
+	if c := w.Chunks[MakeXZ(-1, 0)]; len(c.Level.Entities) != 1 || !c.Dirty() {
+		t.Error("drop not added to its chunk")
+	}
+}
+
+func TestSpawnItemsSplitsStacks(t *testing.T) {
+	w := &World{Chunks: make(map[XZ]*Chunk)}
+	w.Chunks[MakeXZ(0, 0)] = newChunk(0, 0)
+	items := []Item{{Id: BlockDirt, Count: 100}, {Id: 332, Count: 20}, {Id: 276, Count: 2}}
+	drops, err := w.SpawnItems(8, 64, 8, items, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	counts := []int8{64, 36, 16, 4, 1, 1}
+	if len(drops) != len(counts) {
+		t.Fatal("expected ", len(counts), " drops, got ", len(drops))
+	}
+	for i, e := range drops {
+		if e.Item.Count != counts[i] {
+			t.Errorf("drop %d: expected %d items, got %d", i, counts[i], e.Item.Count)
+		}
+		if e.Physics.Velocity.DY != 0.2 {
+			t.Errorf("drop %d not scattered: %v", i, e.Physics.Velocity)
+		}
+	}
+
+	if _, err = w.SpawnItems(8, 64, 8, []Item{{Id: BlockDirt, Count: 1}, {Id: BlockDirt}}, false); err == nil {
+		t.Error("expected an error for an empty stack")
+	}
+	if n := len(w.Chunks[MakeXZ(0, 0)].Level.Entities); n != len(counts) {
+		t.Error("rejected drops were spawned; have ", n, " entities")
+	}
+}
@@ -0,0 +1,686 @@
+package world
+
+import "minecraft/error"
+
+import "fmt"
+import "math"
+import "os"
+
+// see: http://www.minecraftwiki.net/wiki/Alpha_Level_Format#Entity_Format
+
+type Entity struct {
+	Id           string
+	OnGround     int8
+	Air          int16
+	Fire         int16
+	Health       *int16
+	Tile         *int16 // block a falling sand entity becomes; not a projectile's inTile
+	Fuse         *int8  // ticks until primed TNT explodes
+	Item         *Item
+	FallDistance float32
+	Physics      Physics
+	Age          *int16
+
+	// Kind-specific tags; see entitydata.go.
+	Sheep      *SheepData
+	Slime      *SlimeData
+	Pig        *PigData
+	Wolf       *WolfData
+	Painting   *PaintingData
+	Projectile *ProjectileData
+	Minecart   *MinecartData
+
+	// The entity this one rides, such as the spider under a skeleton.  The game
+	// stores a vehicle nested within its rider rather than in the chunk's list,
+	// so chunk-wide queries such as EntitiesInBox return only the rider.
+	Riding *Entity
+
+	// Tags not modeled above, written back untouched.
+	Extra map[string]interface{}
+
+	// whether Tile was read as a short rather than the byte the game writes
+	tileShort bool
+}
+
+// entityTags are the tags every entity decodes into Entity's own fields.
+var entityTags = []string{"id", "Pos", "Motion", "Rotation", "FallDistance", "Fire", "Air", "OnGround"}
+
+type Item struct {
+	Id     int16
+	Count  int8
+	Damage int16
+	// Tags not modeled above, written back untouched.
+	Extra map[string]interface{}
+}
+
+type Physics struct {
+	Position Position
+	Velocity Velocity
+	Euler    Euler
+}
+
+type Position struct {
+	X, Y, Z float64
+}
+type Velocity struct {
+	DX, DY, DZ float64
+}
+
+// Euler is an entity's facing in degrees, stored by the game as Rotation: yaw
+// first, then pitch.  Entities have no roll.
+//
+// Earlier versions decoded Rotation[0] into a Roll field and left Yaw at zero;
+// code written against that must read Yaw instead.
+type Euler struct {
+	Yaw, Pitch float32
+}
+
+// NewItemDrop returns a freshly dropped item stack at (x, y, z), ready for
+// World.AddEntity.
+func NewItemDrop(x, y, z float64, item Item) *Entity {
+	health, age := int16(5), int16(0)
+	return &Entity{
+		Id:      "Item",
+		Air:     300,
+		Health:  &health,
+		Age:     &age,
+		Item:    &item,
+		Physics: Physics{Position: Position{x, y, z}},
+	}
+}
+
+// NewPrimedTNT returns lit TNT at (x, y, z) that explodes after fuse ticks, ready
+// for World.AddEntity.  The game lights TNT with a fuse of 80.
+func NewPrimedTNT(x, y, z float64, fuse int8) *Entity {
+	return &Entity{
+		Id:      "PrimedTnt",
+		Air:     300,
+		Fuse:    &fuse,
+		Physics: Physics{Position: Position{x, y, z}},
+	}
+}
+
+// NewFallingBlock returns a falling block of type tile at (x, y, z), ready for
+// World.AddEntity.  The game only lets sand and gravel fall.
+func NewFallingBlock(x, y, z float64, tile byte) *Entity {
+	t := int16(tile)
+	return &Entity{
+		Id:      "FallingSand",
+		Air:     300,
+		Tile:    &t,
+		Physics: Physics{Position: Position{x, y, z}},
+	}
+}
+
+// ChunkXZ returns the chunk coordinates of the chunk containing position p.
+func (p Position) ChunkXZ() (cx, cz int32) {
+	return int32(math.Floor(p.X)) >> 4, int32(math.Floor(p.Z)) >> 4
+}
+
+func checkEntity(e *Entity) os.Error {
+	if e.Id == "" {
+		return error.NewError("entity has no id", nil)
+	}
+	pos := e.Physics.Position
+	if math.IsNaN(pos.X) || math.IsNaN(pos.Y) || math.IsNaN(pos.Z) {
+		return error.NewError(fmt.Sprintf("%s has position %v", e.Id, pos), nil)
+	}
+	if p := e.Painting; p != nil && (p.Dir < 0 || p.Dir > 3) {
+		return error.NewError(fmt.Sprintf("painting faces direction %d", p.Dir), nil)
+	}
+	return nil
+}
+
+// validate reports values the game does not expect but that are kept as they
+// are, such as a painting with a motive it does not know.
+func (e *Entity) validate() os.Error {
+	if p := e.Painting; p != nil {
+		if p.Dir < 0 || p.Dir > 3 {
+			return error.NewError(fmt.Sprintf("painting faces direction %d", p.Dir), nil)
+		}
+		if !KnownMotive(p.Motive) {
+			return error.NewError(fmt.Sprintf("painting has unknown motive %q", p.Motive), nil)
+		}
+	}
+	return nil
+}
+
+// AddEntity spawns e into the chunk that contains its position, holding the
+// chunk's Lock.  The chunk is loaded if necessary; it is an error for it not to
+// exist.
+func (world *World) AddEntity(e *Entity) os.Error {
+	if err := checkEntity(e); err != nil {
+		return err
+	}
+	cx, cz := e.Physics.Position.ChunkXZ()
+	c, err := world.GetChunk(cx, cz)
+	if err != nil {
+		return error.NewError(fmt.Sprintf("could not add %s", e.Id), err)
+	}
+	c.Lock()
+	defer c.Unlock()
+	return c.AddEntity(e)
+}
+
+// AddEntity appends e to the chunk's entities.  Unlike World.AddEntity it does
+// not check that e's position lies within the chunk, nor take its Lock.
+func (c *Chunk) AddEntity(e *Entity) os.Error {
+	if err := checkEntity(e); err != nil {
+		return err
+	}
+	c.Level.Entities = append(c.Level.Entities, e)
+	c.dirty = true
+	return nil
+}
+
+// RemoveEntity removes e, which must be resident, from its chunk, holding the
+// chunk's Lock.
+func (world *World) RemoveEntity(e *Entity) os.Error {
+	c, err := world.owningChunk(e)
+	if err != nil {
+		return err
+	}
+	c.Lock()
+	defer c.Unlock()
+	if !c.removeEntity(e) {
+		return notInChunk(e)
+	}
+	return nil
+}
+
+// owningChunk finds the resident chunk whose Entities holds e, reading each
+// under its RLock.  The chunk containing e's position is tried first, then
+// every resident chunk in case e was moved without updating its chunk.
+func (world *World) owningChunk(e *Entity) (*Chunk, os.Error) {
+	holds := func(c *Chunk) bool {
+		c.RLock()
+		defer c.RUnlock()
+		return c.hasEntity(e)
+	}
+	cx, cz := e.Physics.Position.ChunkXZ()
+	if c, ok := world.resident(MakeXZ(cx, cz)); ok && holds(c) {
+		return c, nil
+	}
+	for _, c := range world.residentChunks() {
+		if holds(c) {
+			return c, nil
+		}
+	}
+	return nil, notInChunk(e)
+}
+
+// notInChunk is the error of an entity that is not in any resident chunk.
+func notInChunk(e *Entity) os.Error {
+	return error.NewError(fmt.Sprintf("%s at %v is not in any loaded chunk", e.Id, e.Physics.Position), nil)
+}
+
+func (c *Chunk) hasEntity(e *Entity) bool {
+	for _, other := range c.Level.Entities {
+		if other == e {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *Chunk) removeEntity(e *Entity) bool {
+	for i, other := range c.Level.Entities {
+		if other == e {
+			c.Level.Entities = append(c.Level.Entities[:i], c.Level.Entities[i+1:]...)
+			c.dirty = true
+			return true
+		}
+	}
+	return false
+}
+
+// MoveEntity puts e, which must be resident, at (x, y, z), moving it to the chunk
+// that contains its new position.  That chunk is loaded if necessary.  Velocity
+// and facing are left alone.  Any vehicles e rides are moved along with it.  The
+// Locks of both chunks are held while it does.
+func (world *World) MoveEntity(e *Entity, x, y, z float64) os.Error {
+	return world.moveEntity(e, Position{x, y, z}, true)
+}
+
+// MoveEntityResident is like MoveEntity but fails rather than load the chunk the
+// entity would move to.
+func (world *World) MoveEntityResident(e *Entity, x, y, z float64) os.Error {
+	return world.moveEntity(e, Position{x, y, z}, false)
+}
+
+func (world *World) moveEntity(e *Entity, to Position, load bool) os.Error {
+	if math.IsNaN(to.X) || math.IsNaN(to.Y) || math.IsNaN(to.Z) {
+		return error.NewError(fmt.Sprintf("cannot move %s to %v", e.Id, to), nil)
+	}
+	from, err := world.owningChunk(e)
+	if err != nil {
+		return err
+	}
+	cx, cz := to.ChunkXZ()
+	dest, ok := world.resident(MakeXZ(cx, cz))
+	if !ok {
+		if !load {
+			return error.InChunk(cx, cz).Error("cannot move "+e.Id+" into unloaded chunk", nil)
+		}
+		if dest, err = world.GetChunk(cx, cz); err != nil {
+			return error.NewError(fmt.Sprintf("cannot move %s", e.Id), err)
+		}
+	}
+	unlock := lockPair(from, dest)
+	defer unlock()
+	// another goroutine may have moved it meanwhile
+	if !from.hasEntity(e) {
+		return notInChunk(e)
+	}
+	placeEntity(e, from, dest, to)
+	return nil
+}
+
+// placeEntity moves e, and the vehicles it rides, from chunk from to to in chunk
+// dest.  The caller holds the Locks of both chunks.
+func placeEntity(e *Entity, from, dest *Chunk, to Position) {
+	at := e.Physics.Position
+	for v := e.Riding; v != nil; v = v.Riding {
+		p := &v.Physics.Position
+		p.X, p.Y, p.Z = p.X+to.X-at.X, p.Y+to.Y-at.Y, p.Z+to.Z-at.Z
+	}
+	e.Physics.Position = to
+	if dest != from {
+		from.removeEntity(e)
+		dest.Level.Entities = append(dest.Level.Entities, e)
+	}
+	from.dirty = true
+	dest.dirty = true
+}
+
+// lockPair takes the Locks of a and b, which may be the same chunk, in the
+// order ListChunks sorts them, so that goroutines locking several chunks at
+// once cannot deadlock, and returns a function that gives them back.
+func lockPair(a, b *Chunk) (unlock func()) {
+	if a == b {
+		a.Lock()
+		return a.Unlock
+	}
+	if b.Level.XPos < a.Level.XPos || b.Level.XPos == a.Level.XPos && b.Level.ZPos < a.Level.ZPos {
+		a, b = b, a
+	}
+	a.Lock()
+	b.Lock()
+	return func() {
+		b.Unlock()
+		a.Unlock()
+	}
+}
+
+// RemoveEntities removes every entity in region (nil meaning the whole world) for
+// which match returns true.  Chunks that are not resident are streamed from disk
+// and written back only if something was removed from them.
+func (world *World) RemoveEntities(match func(*Entity) bool, region *Region) (removed int, err os.Error) {
+	err = world.streamChunks("RemoveEntities", region, func(c *Chunk) (bool, os.Error) {
+		kept := c.Level.Entities[:0]
+		for _, e := range c.Level.Entities {
+			if !match(e) {
+				kept = append(kept, e)
+			}
+		}
+		n := len(c.Level.Entities) - len(kept)
+		c.Level.Entities = kept
+		removed += n
+		return n > 0, nil
+	})
+	return
+}
+
+// EntitiesInBox returns the entities whose positions lie within the box from
+// (minX, minY, minZ) to (maxX, maxY, maxZ), bounds included.  Every chunk the box
+// overlaps is loaded if it exists, and read under its RLock.  Entities are
+// ordered by chunk, x then z, and then by their order within the chunk.
+func (world *World) EntitiesInBox(minX, minY, minZ, maxX, maxY, maxZ float64) ([]*Entity, os.Error) {
+	return world.entitiesInBox(minX, minY, minZ, maxX, maxY, maxZ, true)
+}
+
+// LoadedEntitiesInBox is like EntitiesInBox but only consults resident chunks.
+func (world *World) LoadedEntitiesInBox(minX, minY, minZ, maxX, maxY, maxZ float64) []*Entity {
+	found, _ := world.entitiesInBox(minX, minY, minZ, maxX, maxY, maxZ, false)
+	return found
+}
+
+// EntitiesInRadius returns the entities within r of (x, y, z), ordered as for
+// EntitiesInBox.
+func (world *World) EntitiesInRadius(x, y, z, r float64) ([]*Entity, os.Error) {
+	inBox, err := world.EntitiesInBox(x-r, y-r, z-r, x+r, y+r, z+r)
+	if err != nil {
+		return nil, err
+	}
+	found := make([]*Entity, 0, len(inBox))
+	for _, e := range inBox {
+		pos := e.Physics.Position
+		dx, dy, dz := pos.X-x, pos.Y-y, pos.Z-z
+		if dx*dx+dy*dy+dz*dz <= r*r {
+			found = append(found, e)
+		}
+	}
+	return found, nil
+}
+
+func (world *World) entitiesInBox(minX, minY, minZ, maxX, maxY, maxZ float64, load bool) (found []*Entity, err os.Error) {
+	found = make([]*Entity, 0)
+	if minX > maxX || minY > maxY || minZ > maxZ {
+		return
+	}
+	// a position belongs to the chunk holding the block it floors to
+	cx0, cz0 := Position{minX, 0, minZ}.ChunkXZ()
+	cx1, cz1 := Position{maxX, 0, maxZ}.ChunkXZ()
+	for cx := cx0; cx <= cx1; cx++ {
+		for cz := cz0; cz <= cz1; cz++ {
+			c, ok := world.resident(MakeXZ(cx, cz))
+			if !ok {
+				if !load || !world.ChunkExists(cx, cz) {
+					continue
+				}
+				if c, err = world.GetChunk(cx, cz); err != nil {
+					return nil, err
+				}
+			}
+			c.RLock()
+			for _, e := range c.Level.Entities {
+				pos := e.Physics.Position
+				if pos.X >= minX && pos.X <= maxX && pos.Y >= minY && pos.Y <= maxY && pos.Z >= minZ && pos.Z <= maxZ {
+					found = append(found, e)
+				}
+			}
+			c.RUnlock()
+		}
+	}
+	return
+}
+
+// An EntityRef locates an entity found by a search: the chunk that owns it and its
+// index in that chunk's Entities.  Entity is the live entity when the chunk was
+// resident and a detached copy when it was streamed from disk; use Resolve to
+// get one that can be modified.
+type EntityRef struct {
+	Entity         *Entity
+	ChunkX, ChunkZ int32
+	Index          int
+}
+
+// Resolve loads the owning chunk and returns it along with the live entity.
+func (ref EntityRef) Resolve(world *World) (c *Chunk, e *Entity, err os.Error) {
+	if c, err = world.GetChunk(ref.ChunkX, ref.ChunkZ); err != nil {
+		return
+	}
+	if ref.Index >= len(c.Level.Entities) || c.Level.Entities[ref.Index].Id != ref.Entity.Id {
+		err = error.InChunk(ref.ChunkX, ref.ChunkZ).Error(fmt.Sprint(ref.Entity.Id, " ", ref.Index, " is gone from chunk"), nil)
+		return
+	}
+	e = c.Level.Entities[ref.Index]
+	return
+}
+
+// FindEntities returns every entity with the given id in region (nil meaning
+// the whole world).
+func (world *World) FindEntities(id string, region *Region) ([]EntityRef, os.Error) {
+	return world.FindEntitiesFunc(func(e *Entity) bool { return e.Id == id }, region, 0)
+}
+
+// FindEntitiesFunc returns up to limit entities in region for which match returns
+// true; a limit of 0 or less means no limit.  Results are ordered by chunk, x then
+// z, and then by index.  Chunks that are not resident are streamed from disk
+// without decoding their blocks and are not kept.
+func (world *World) FindEntitiesFunc(match func(*Entity) bool, region *Region, limit int) (refs []EntityRef, err os.Error) {
+	refs = make([]EntityRef, 0)
+	err = world.scanEntities(region, func(xz ChunkCoord, entities []*Entity) bool {
+		for i, e := range entities {
+			if !match(e) {
+				continue
+			}
+			refs = append(refs, EntityRef{e, xz.X, xz.Z, i})
+			if limit > 0 && len(refs) == limit {
+				return false
+			}
+		}
+		return true
+	})
+	if err != nil {
+		refs = nil
+	}
+	return
+}
+
+// toEntityList decodes every entity in payload, reporting in warnings any that
+// decoded but hold values the game would not expect.  An entity that cannot be
+// decoded at all is an error.
+func toEntityList(payload []interface{}) (entities []*Entity, warnings []os.Error, err os.Error) {
+	entities = make([]*Entity, len(payload))
+	for i, p := range payload {
+		raw, ok := p.(map[string]interface{})
+		if !ok {
+			return nil, nil, error.NewError(fmt.Sprintf("entity %d: expected compound, got %T", i, p), nil)
+		}
+		if entities[i], err = toEntity(raw); err != nil {
+			return nil, nil, error.NewError(fmt.Sprintf("could not decode entity %d", i), err)
+		}
+		if err := entities[i].validate(); err != nil {
+			warnings = append(warnings, error.NewError(fmt.Sprintf("entity %d", i), err))
+		}
+	}
+	return
+}
+
+// maxRidingDepth bounds how many vehicles deep toEntity decodes; anything
+// further is kept undecoded in Extra.
+const maxRidingDepth = 16
+
+// toEntity decodes an entity, failing if it lacks a tag every entity has or the
+// tag has the wrong type.
+func toEntity(payload map[string]interface{}) (*Entity, os.Error) {
+	return toEntityAt(payload, 0)
+}
+
+// toEntityAt decodes an entity that is the vehicle of depth others.
+func toEntityAt(payload map[string]interface{}, depth int) (e *Entity, err os.Error) {
+	var ent Entity
+	if ent.Id, err = getString(payload, "id"); err != nil {
+		return
+	}
+	if ent.OnGround, err = getInt8(payload, "OnGround"); err != nil {
+		return
+	}
+	if ent.Air, err = getInt16(payload, "Air"); err != nil {
+		return
+	}
+	if ent.Fire, err = getInt16(payload, "Fire"); err != nil {
+		return
+	}
+	if ent.FallDistance, err = getFloat32(payload, "FallDistance"); err != nil {
+		return
+	}
+	if ent.Physics, err = toPhysics(payload); err != nil {
+		return
+	}
+
+	// nullables
+	ihealth, ok := payload["Health"].(int16)
+	if ok {
+		ent.Health = &ihealth
+	}
+
+	iage, ok := payload["Age"].(int16)
+	if ok {
+		ent.Age = &iage
+	}
+
+	// the game writes Tile as a byte; accept a short too and write it back as one
+	switch tile := payload["Tile"].(type) {
+	case int8:
+		itile := int16(uint8(tile))
+		ent.Tile = &itile
+	case int16:
+		ent.Tile = &tile
+		ent.tileShort = true
+	}
+	ent.Fuse = optInt8(payload, "Fuse")
+
+	if iitem, ok := payload["Item"].(map[string]interface{}); ok {
+		if item, err := toItem(iitem); err == nil {
+			ent.Item = &item
+		}
+	}
+
+	// the tags decoded beyond entityTags; most entities have few, and the
+	// buffer spares allocating for them
+	var buf [16]string
+	known := buf[:0]
+	if ent.Health != nil {
+		known = append(known, "Health")
+	}
+	if ent.Age != nil {
+		known = append(known, "Age")
+	}
+	if ent.Tile != nil {
+		known = append(known, "Tile")
+	}
+	if ent.Fuse != nil {
+		known = append(known, "Fuse")
+	}
+	if ent.Item != nil {
+		known = append(known, "Item")
+	}
+	if ent.Sheep = toSheepData(payload); ent.Sheep != nil {
+		known = append(known, "Color", "Sheared")
+	}
+	if ent.Slime = toSlimeData(payload); ent.Slime != nil {
+		known = append(known, "Size")
+	}
+	if ent.Pig = toPigData(payload); ent.Pig != nil {
+		known = append(known, "Saddle")
+	}
+	if ent.Wolf = toWolfData(payload); ent.Wolf != nil {
+		known = append(known, "Owner", "Sitting", "Angry")
+	}
+	if ent.Painting = toPaintingData(payload); ent.Painting != nil {
+		known = append(known, "Motive", "Dir", "TileX", "TileY", "TileZ")
+	}
+	if ent.Projectile = toProjectileData(payload); ent.Projectile != nil {
+		known = append(known, ent.Projectile.tags()...)
+	}
+	if ent.Id == "Minecart" {
+		var cartTags []string
+		ent.Minecart, cartTags = toMinecartData(payload)
+		known = append(known, cartTags...)
+	}
+	if vehicle, ok := payload["Riding"].(map[string]interface{}); ok && depth < maxRidingDepth {
+		if ent.Riding, err = toEntityAt(vehicle, depth+1); err != nil {
+			return nil, error.NewError("could not decode vehicle", err)
+		}
+		known = append(known, "Riding")
+	}
+	ent.Extra = unknownTags(payload, entityTags, known)
+	return &ent, nil
+}
+
+// copyTags returns a shallow copy of tags, which may be nil.
+func copyTags(tags map[string]interface{}) map[string]interface{} {
+	c := make(map[string]interface{}, len(tags)+16)
+	for name, tag := range tags {
+		c[name] = tag
+	}
+	return c
+}
+
+// unknownTags copies the tags of payload not named in any of the known lists, or
+// returns nil if there are none.
+func unknownTags(payload map[string]interface{}, known ...[]string) map[string]interface{} {
+	var extra map[string]interface{}
+	for name, tag := range payload {
+		if isKnownTag(name, known) {
+			continue
+		}
+		if extra == nil {
+			extra = make(map[string]interface{})
+		}
+		extra[name] = tag
+	}
+	return extra
+}
+
+// isKnownTag reports whether name is in any of the known lists, which are short
+// enough that searching them beats building a set of them for every entity.
+func isKnownTag(name string, known [][]string) bool {
+	for _, names := range known {
+		for _, n := range names {
+			if n == name {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func fromEntityList(entities []*Entity) []interface{} {
+	payload := make([]interface{}, len(entities))
+	for i, e := range entities {
+		payload[i] = fromEntity(e)
+	}
+	return payload
+}
+
+// fromEntity encodes an entity the way the game stores it.  Typed fields take
+// precedence over any tag of the same name in Extra.
+func fromEntity(e *Entity) map[string]interface{} {
+	pos, vel, rot := e.Physics.Position, e.Physics.Velocity, e.Physics.Euler
+	payload := copyTags(e.Extra)
+	payload["id"] = e.Id
+	payload["OnGround"] = e.OnGround
+	payload["Air"] = e.Air
+	payload["Fire"] = e.Fire
+	payload["FallDistance"] = e.FallDistance
+	payload["Pos"] = []interface{}{pos.X, pos.Y, pos.Z}
+	payload["Motion"] = []interface{}{vel.DX, vel.DY, vel.DZ}
+	payload["Rotation"] = []interface{}{rot.Yaw, rot.Pitch}
+
+	// nullables
+	if e.Health != nil {
+		payload["Health"] = *e.Health
+	}
+	if e.Age != nil {
+		payload["Age"] = *e.Age
+	}
+	if e.Tile != nil && e.tileShort {
+		payload["Tile"] = *e.Tile
+	} else if e.Tile != nil {
+		payload["Tile"] = int8(*e.Tile)
+	}
+	if e.Fuse != nil {
+		payload["Fuse"] = *e.Fuse
+	}
+	if e.Item != nil {
+		payload["Item"] = fromItem(*e.Item)
+	}
+	if e.Sheep != nil {
+		e.Sheep.encode(payload)
+	}
+	if e.Slime != nil {
+		e.Slime.encode(payload)
+	}
+	if e.Pig != nil {
+		e.Pig.encode(payload)
+	}
+	if e.Wolf != nil {
+		e.Wolf.encode(payload)
+	}
+	if e.Painting != nil {
+		e.Painting.encode(payload)
+	}
+	if e.Projectile != nil {
+		e.Projectile.encode(payload)
+	}
+	if e.Minecart != nil {
+		e.Minecart.encode(payload)
+	}
+	if e.Riding != nil {
+		payload["Riding"] = fromEntity(e.Riding)
+	}
+	return payload
+}
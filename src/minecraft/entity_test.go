@@ -0,0 +1,805 @@
+package world
+
+import "minecraft/error"
+import "minecraft/nbt"
+
+import "bytes"
+import "math"
+import "os"
+import "reflect"
+import "testing"
+
+var pigFixture = map[string]interface{}{
+	"id":           "Pig",
+	"Pos":          []interface{}{float64(4.5), float64(65), float64(9.25)},
+	"Motion":       []interface{}{float64(0), float64(-0.0784), float64(0)},
+	"Rotation":     []interface{}{float32(271.5), float32(-12.25)},
+	"FallDistance": float32(0),
+	"Fire":         int16(-1),
+	"Air":          int16(300),
+	"OnGround":     int8(1),
+	"Health":       int16(10),
+}
+
+var itemFixture = map[string]interface{}{
+	"id":           "Item",
+	"Pos":          []interface{}{float64(-3.125), float64(70.5), float64(11.875)},
+	"Motion":       []interface{}{float64(0.01), float64(0), float64(-0.02)},
+	"Rotation":     []interface{}{float32(38), float32(0)},
+	"FallDistance": float32(0.5),
+	"Fire":         int16(0),
+	"Air":          int16(300),
+	"OnGround":     int8(0),
+	"Health":       int16(5),
+	"Age":          int16(1200),
+	"Item": map[string]interface{}{
+		"id":     int16(BlockCobblestone),
+		"Count":  int8(17),
+		"Damage": int16(0),
+	},
+}
+
+var fallingSandFixture = map[string]interface{}{
+	"id":           "FallingSand",
+	"Pos":          []interface{}{float64(2.5), float64(80.5), float64(2.5)},
+	"Motion":       []interface{}{float64(0), float64(-0.5), float64(0)},
+	"Rotation":     []interface{}{float32(0), float32(0)},
+	"FallDistance": float32(3),
+	"Fire":         int16(0),
+	"Air":          int16(300),
+	"OnGround":     int8(0),
+	"Tile":         int8(BlockSand),
+}
+
+var tntFixture = map[string]interface{}{
+	"id":           "PrimedTnt",
+	"Pos":          []interface{}{float64(4.5), float64(64), float64(4.5)},
+	"Motion":       []interface{}{float64(0.012), float64(0.2), float64(-0.015)},
+	"Rotation":     []interface{}{float32(0), float32(0)},
+	"FallDistance": float32(0),
+	"Fire":         int16(0),
+	"Air":          int16(300),
+	"OnGround":     int8(0),
+	"Fuse":         int8(42),
+}
+
+// mustEntity decodes an entity payload known to be well formed.
+func mustEntity(payload map[string]interface{}) *Entity {
+	e, err := toEntity(payload)
+	if err != nil {
+		panic(err)
+	}
+	return e
+}
+
+func TestEntityRoundTrip(t *testing.T) {
+	// some editors write Tile as a short; it must stay one
+	wideSand := make(map[string]interface{})
+	for k, v := range fallingSandFixture {
+		wideSand[k] = v
+	}
+	wideSand["Tile"] = int16(BlockGravel)
+	for _, fixture := range []map[string]interface{}{pigFixture, itemFixture, fallingSandFixture, tntFixture, wideSand} {
+		if encoded := fromEntity(mustEntity(fixture)); !nbt.Equal(encoded, fixture) {
+			t.Error("expected ", fixture, ", got ", encoded)
+		}
+	}
+}
+
+func TestEntityRotation(t *testing.T) {
+	// pigFixture faces yaw 271.5, pitch -12.25
+	e := mustEntity(pigFixture)
+	if e.Physics.Euler.Yaw != 271.5 || e.Physics.Euler.Pitch != -12.25 {
+		t.Errorf("expected yaw 271.5 and pitch -12.25, got %v", e.Physics.Euler)
+	}
+	e.Physics.Euler = Euler{Yaw: 90, Pitch: 30}
+	rot := fromEntity(e)["Rotation"].([]interface{})
+	if len(rot) != 2 || rot[0] != float32(90) || rot[1] != float32(30) {
+		t.Error("expected Rotation [90 30], got ", rot)
+	}
+}
+
+func TestMalformedEntity(t *testing.T) {
+	shortPos := mobFixture("Pig", map[string]interface{}{"Pos": []interface{}{float64(1), float64(2)}})
+	badVehicle := mobFixture("Skeleton", map[string]interface{}{"Riding": mobFixture("Spider", map[string]interface{}{"Air": "none"})})
+	for _, fixture := range []map[string]interface{}{shortPos, badVehicle, {"id": "Pig"}} {
+		if _, err := toEntity(fixture); err == nil {
+			t.Error("expected an error decoding ", fixture)
+		}
+	}
+
+	dir := makeTestWorld(t, testChunkPayload(0, 0, []interface{}{pigFixture}, nil),
+		testChunkPayload(1, 0, []interface{}{pigFixture, shortPos}, nil))
+	defer os.RemoveAll(dir)
+	w, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	err = w.ForEachEntity(nil, func(cx, cz int32, e *Entity) EntityAction { return Keep })
+	if ctx := error.ContextOf(err); !ctx.HasChunk || ctx.X != 1 || ctx.Z != 0 {
+		t.Error("expected an error in chunk (1, 0), got ", err)
+	}
+	if _, err = w.LoadChunk(1, 0); !IsError(err, ErrCorruptChunk) {
+		t.Error("expected ErrCorruptChunk loading the chunk, got ", err)
+	}
+}
+
+func TestChunkEntitiesRoundTrip(t *testing.T) {
+	payload := testChunkPayload(3, -4,
+		[]interface{}{pigFixture, itemFixture, fallingSandFixture}, nil)
+	c := mustChunk(payload)
+	if len(c.Level.Entities) != 3 {
+		t.Fatal("expected 3 entities, got ", len(c.Level.Entities))
+	}
+	if tile := c.Level.Entities[2].Tile; tile == nil || *tile != BlockSand {
+		t.Error("expected falling sand, got ", tile)
+	}
+
+	buf := new(bytes.Buffer)
+	if err := nbt.WriteTagCompound(buf, "", mustFromChunk(c)); err != nil {
+		t.Fatal(err)
+	}
+	_, decoded, err := nbt.ReadTagCompound(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !nbt.Equal(decoded, payload) {
+		t.Error("expected ", payload, ", got ", decoded)
+	}
+}
+
+func TestAddEntity(t *testing.T) {
+	dir := makeTestWorld(t, testChunkPayload(0, 0, nil, nil), testChunkPayload(-1, -1, nil, nil))
+	defer os.RemoveAll(dir)
+	w, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	drop := NewItemDrop(-0.5, 64, -16, Item{Id: BlockDirt, Count: 3})
+	if err = w.AddEntity(drop); err != nil {
+		t.Fatal(err)
+	}
+	c := w.Chunks[MakeXZ(-1, -1)]
+	if len(c.Level.Entities) != 1 || c.Level.Entities[0] != drop {
+		t.Error("expected the drop in chunk (-1, -1), got ", c.Level.Entities)
+	}
+	if !c.Dirty() {
+		t.Error("chunk not marked dirty")
+	}
+	if _, ok := w.Chunks[MakeXZ(0, 0)]; ok {
+		t.Error("chunk (0, 0) loaded for no reason")
+	}
+
+	if err = w.AddEntity(NewItemDrop(16, 64, 0, Item{Id: BlockDirt, Count: 1})); err == nil {
+		t.Error("expected an error adding to a missing chunk")
+	}
+	if err = w.AddEntity(NewItemDrop(math.NaN(), 64, 0, Item{Id: BlockDirt, Count: 1})); err == nil {
+		t.Error("expected an error for a NaN position")
+	}
+	if err = w.AddEntity(&Entity{}); err == nil {
+		t.Error("expected an error for an entity without an id")
+	}
+}
+
+func isItem(e *Entity) bool {
+	return e.Id == "Item"
+}
+
+func TestRemoveEntities(t *testing.T) {
+	dir := makeTestWorld(t,
+		testChunkPayload(0, 0, []interface{}{pigFixture, itemFixture, itemFixture}, nil),
+		testChunkPayload(-1, 0, []interface{}{itemFixture}, nil),
+		testChunkPayload(0, -1, []interface{}{pigFixture}, nil),
+		testChunkPayload(5, 5, []interface{}{fallingSandFixture}, nil))
+	defer os.RemoveAll(dir)
+	w, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	resident, err := w.GetChunk(0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	untouched, err := w.GetChunk(5, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	removed, err := w.RemoveEntities(isItem, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if removed != 3 {
+		t.Error("expected 3 items removed, got ", removed)
+	}
+	if len(resident.Level.Entities) != 1 || resident.Level.Entities[0].Id != "Pig" {
+		t.Error("expected only the pig left in (0, 0), got ", resident.Level.Entities)
+	}
+	if !resident.Dirty() {
+		t.Error("resident chunk not marked dirty")
+	}
+	if untouched.Dirty() {
+		t.Error("chunk with nothing removed marked dirty")
+	}
+	if _, ok := w.Chunks[MakeXZ(-1, 0)]; ok {
+		t.Error("streamed chunk left resident")
+	}
+	streamed, err := w.readChunk(-1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(streamed.Level.Entities) != 0 {
+		t.Error("expected the item removed from (-1, 0) on disk, got ", streamed.Level.Entities)
+	}
+	if streamed, err = w.readChunk(0, -1); err != nil {
+		t.Fatal(err)
+	}
+	if len(streamed.Level.Entities) != 1 {
+		t.Error("expected the pig kept in (0, -1) on disk, got ", streamed.Level.Entities)
+	}
+
+	if removed, err = w.RemoveEntities(isItem, NewRegion(-1, 0, -1, 0)); err != nil || removed != 0 {
+		t.Error("expected nothing left to remove, got ", removed, err)
+	}
+}
+
+func TestRemoveEntity(t *testing.T) {
+	dir := makeTestWorld(t, testChunkPayload(0, 0, []interface{}{pigFixture, itemFixture}, nil))
+	defer os.RemoveAll(dir)
+	w, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	c, err := w.GetChunk(0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pig := c.Level.Entities[0]
+	// wandered out of its chunk since it was loaded
+	pig.Physics.Position.X = -40
+	if err = w.RemoveEntity(pig); err != nil {
+		t.Fatal(err)
+	}
+	if len(c.Level.Entities) != 1 || c.Level.Entities[0].Id != "Item" {
+		t.Error("expected only the item left, got ", c.Level.Entities)
+	}
+	if !c.Dirty() {
+		t.Error("chunk not marked dirty")
+	}
+	if err = w.RemoveEntity(pig); err == nil {
+		t.Error("expected an error removing the pig twice")
+	}
+}
+
+// entityAt returns a bare pig at (x, y, z).
+func entityAt(x, y, z float64) *Entity {
+	return &Entity{Id: "Pig", Physics: Physics{Position: Position{x, y, z}}}
+}
+
+func TestEntitiesInBox(t *testing.T) {
+	w := &World{Chunks: make(map[XZ]*Chunk)}
+	for cx := int32(-2); cx <= 1; cx++ {
+		for cz := int32(-2); cz <= 1; cz++ {
+			w.Chunks[MakeXZ(cx, cz)] = newChunk(cx, cz)
+		}
+	}
+	entities := []*Entity{
+		entityAt(-16, 64, -0.001), // (-1, -1) on its western edge
+		entityAt(-16.001, 64, 0),  // (-2, 0), just outside
+		entityAt(0, 64, 0),        // (0, 0) corner
+		entityAt(15.999, 64, 3),   // (0, 0) eastern edge
+		entityAt(16, 64, 3),       // (1, 0), just outside
+		entityAt(-3, 64, -3),      // (-1, -1)
+		entityAt(-3, 70.5, -3),    // too high
+	}
+	for _, e := range entities {
+		cx, cz := e.Physics.Position.ChunkXZ()
+		w.Chunks[MakeXZ(cx, cz)].AddEntity(e)
+	}
+
+	found, err := w.EntitiesInBox(-16, 60, -16, 15.999, 70, 15.999)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []*Entity{entities[0], entities[5], entities[2], entities[3]}
+	if len(found) != len(want) {
+		t.Fatalf("expected %d entities, got %d", len(want), len(found))
+	}
+	for i := range want {
+		if found[i] != want[i] {
+			t.Errorf("%d: expected %v, got %v", i, want[i].Physics.Position, found[i].Physics.Position)
+		}
+	}
+
+	// grazing the x=16 seam pulls in chunk (1, 0)
+	found = w.LoadedEntitiesInBox(16, 64, 3, 16, 64, 3)
+	if len(found) != 1 || found[0] != entities[4] {
+		t.Error("expected the entity on the seam, got ", found)
+	}
+
+	if found, err = w.EntitiesInRadius(-3, 64, -3, 4); err != nil {
+		t.Fatal(err)
+	}
+	if len(found) != 1 || found[0] != entities[5] {
+		t.Error("expected one entity within 4 blocks, got ", found)
+	}
+}
+
+func TestFindEntities(t *testing.T) {
+	diamond := make(map[string]interface{})
+	for k, v := range itemFixture {
+		diamond[k] = v
+	}
+	diamond["Item"] = map[string]interface{}{"id": int16(264), "Count": int8(1), "Damage": int16(0)}
+	dir := makeTestWorld(t,
+		testChunkPayload(0, 0, []interface{}{pigFixture, itemFixture, diamond}, nil),
+		testChunkPayload(-1, 2, []interface{}{diamond, pigFixture}, nil),
+		testChunkPayload(3, -1, []interface{}{pigFixture}, nil))
+	defer os.RemoveAll(dir)
+	w, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	resident, err := w.GetChunk(0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pigs, err := w.FindEntities("Pig", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []EntityRef{{nil, -1, 2, 1}, {nil, 0, 0, 0}, {nil, 3, -1, 0}}
+	if len(pigs) != len(want) {
+		t.Fatalf("expected %d pigs, got %v", len(want), pigs)
+	}
+	for i, ref := range pigs {
+		if ref.Entity.Id != "Pig" || ref.ChunkX != want[i].ChunkX || ref.ChunkZ != want[i].ChunkZ || ref.Index != want[i].Index {
+			t.Errorf("%d: expected pig %d in (%d, %d), got %v", i, want[i].Index, want[i].ChunkX, want[i].ChunkZ, ref)
+		}
+	}
+	if pigs[1].Entity != resident.Level.Entities[0] {
+		t.Error("expected the live pig from the resident chunk")
+	}
+	if len(w.Chunks) != 1 {
+		t.Error("expected streamed chunks to stay unloaded, got ", len(w.Chunks))
+	}
+
+	isDiamond := func(e *Entity) bool { return e.Item != nil && e.Item.Id == 264 }
+	diamonds, err := w.FindEntitiesFunc(isDiamond, nil, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(diamonds) != 1 || diamonds[0].ChunkX != -1 {
+		t.Fatal("expected the first diamond in (-1, 2), got ", diamonds)
+	}
+	c, e, err := diamonds[0].Resolve(w)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c != w.Chunks[MakeXZ(-1, 2)] || e != c.Level.Entities[0] {
+		t.Error("Resolve did not return the live diamond")
+	}
+
+	if diamonds, err = w.FindEntitiesFunc(isDiamond, NewRegion(0, 0, 3, -1), 0); err != nil {
+		t.Fatal(err)
+	}
+	if len(diamonds) != 1 || diamonds[0].Index != 2 {
+		t.Error("expected one diamond in region, got ", diamonds)
+	}
+}
+
+// mobFixture returns a copy of pigFixture turned into the given mob with extra
+// tags.
+func mobFixture(id string, tags map[string]interface{}) map[string]interface{} {
+	mob := make(map[string]interface{})
+	for k, v := range pigFixture {
+		mob[k] = v
+	}
+	mob["id"] = id
+	mob["AttackTime"] = int16(0)
+	mob["HurtTime"] = int16(0)
+	mob["DeathTime"] = int16(0)
+	for k, v := range tags {
+		mob[k] = v
+	}
+	return mob
+}
+
+var shearedPinkSheepFixture = mobFixture("Sheep", map[string]interface{}{"Color": int8(6), "Sheared": int8(1)})
+
+// a size 3 slime
+var slimeFixture = mobFixture("Slime", map[string]interface{}{"Size": int32(2)})
+
+func TestMobRoundTrip(t *testing.T) {
+	saddledPig := mobFixture("Pig", map[string]interface{}{"Saddle": int8(1)})
+	fixtures := []map[string]interface{}{
+		shearedPinkSheepFixture,
+		slimeFixture,
+		saddledPig,
+		mobFixture("Zombie", nil),
+		mobFixture("Skeleton", nil),
+	}
+	for _, fixture := range fixtures {
+		if encoded := fromEntity(mustEntity(fixture)); !nbt.Equal(encoded, fixture) {
+			t.Error("expected ", fixture, ", got ", encoded)
+		}
+	}
+
+	sheep := mustEntity(shearedPinkSheepFixture)
+	if sheep.Sheep == nil || sheep.Sheep.Color != 6 || sheep.Sheep.Sheared != 1 {
+		t.Error("expected a sheared pink sheep, got ", sheep.Sheep)
+	}
+	if sheep.Slime != nil || sheep.Pig != nil {
+		t.Error("sheep decoded with other mobs' data")
+	}
+	slime := mustEntity(slimeFixture)
+	if slime.Slime == nil || slime.Slime.Size != 2 {
+		t.Error("expected a size 3 slime, got ", slime.Slime)
+	}
+	if pig := mustEntity(saddledPig); pig.Pig == nil || pig.Pig.Saddle != 1 {
+		t.Error("expected a saddled pig, got ", pig.Pig)
+	}
+
+	// a sheep dyed through the typed field
+	sheep.Sheep.Color = 11
+	if encoded := fromEntity(sheep); encoded["Color"] != int8(11) {
+		t.Error("expected Color 11, got ", encoded["Color"])
+	}
+}
+
+func paintingFixture(motive string, dir int8, x, y, z int32) map[string]interface{} {
+	return map[string]interface{}{
+		"id":           "Painting",
+		"Pos":          []interface{}{float64(x) + 0.5, float64(y) + 0.5, float64(z) + 0.5},
+		"Motion":       []interface{}{float64(0), float64(0), float64(0)},
+		"Rotation":     []interface{}{float32(dir) * 90, float32(0)},
+		"FallDistance": float32(0),
+		"Fire":         int16(0),
+		"Air":          int16(300),
+		"OnGround":     int8(0),
+		"Motive":       motive,
+		"Dir":          dir,
+		"TileX":        x,
+		"TileY":        y,
+		"TileZ":        z,
+	}
+}
+
+func TestPaintingRoundTrip(t *testing.T) {
+	payload := testChunkPayload(-1, 0, []interface{}{
+		paintingFixture("Kebab", 0, -3, 66, 9),
+		paintingFixture("SkullAndRoses", 2, -12, 70, 0),
+	}, nil)
+	c := mustChunk(payload)
+	if len(c.Warnings) != 0 {
+		t.Error("unexpected warnings: ", c.Warnings)
+	}
+	want := []PaintingData{{"Kebab", 0, -3, 66, 9}, {"SkullAndRoses", 2, -12, 70, 0}}
+	for i, e := range c.Level.Entities {
+		if e.Painting == nil || !reflect.DeepEqual(*e.Painting, want[i]) {
+			t.Errorf("%d: expected %v, got %v", i, want[i], e.Painting)
+		}
+	}
+	if encoded := mustFromChunk(c); !nbt.Equal(encoded, payload) {
+		t.Error("expected ", payload, ", got ", encoded)
+	}
+}
+
+func TestPaintingValidation(t *testing.T) {
+	modded := paintingFixture("MonaLisa", 1, 0, 64, 0)
+	c := mustChunk(testChunkPayload(0, 0, []interface{}{modded}, nil))
+	if len(c.Warnings) != 1 {
+		t.Error("expected a warning for an unknown motive, got ", c.Warnings)
+	}
+	if encoded := fromEntity(c.Level.Entities[0]); !nbt.Equal(encoded, modded) {
+		t.Error("expected ", modded, ", got ", encoded)
+	}
+
+	askew := mustEntity(paintingFixture("Kebab", 4, 0, 64, 0))
+	if err := c.AddEntity(askew); err == nil {
+		t.Error("expected an error adding a painting facing direction 4")
+	}
+}
+
+func arrowFixture(inGround bool) map[string]interface{} {
+	arrow := map[string]interface{}{
+		"id":           "Arrow",
+		"Pos":          []interface{}{float64(7.5), float64(66.2), float64(3.9)},
+		"Motion":       []interface{}{float64(1.2), float64(0.1), float64(-0.3)},
+		"Rotation":     []interface{}{float32(104), float32(-4.5)},
+		"FallDistance": float32(0),
+		"Fire":         int16(0),
+		"Air":          int16(300),
+		"OnGround":     int8(0),
+		"xTile":        int16(-1),
+		"yTile":        int16(-1),
+		"zTile":        int16(-1),
+		"inTile":       int8(0),
+		"shake":        int8(0),
+		"inGround":     int8(0),
+	}
+	if inGround {
+		arrow["Motion"] = []interface{}{float64(0), float64(0), float64(0)}
+		arrow["xTile"] = int16(8)
+		arrow["yTile"] = int16(66)
+		arrow["zTile"] = int16(3)
+		arrow["inTile"] = int8(BlockCobblestone)
+		arrow["shake"] = int8(7)
+		arrow["inGround"] = int8(1)
+	}
+	return arrow
+}
+
+func TestProjectileRoundTrip(t *testing.T) {
+	fireball := arrowFixture(false)
+	fireball["id"] = "Fireball"
+	fireball["direction"] = []interface{}{float64(0.02), float64(-0.01), float64(0.05)}
+	for _, fixture := range []map[string]interface{}{arrowFixture(true), arrowFixture(false), fireball} {
+		if encoded := fromEntity(mustEntity(fixture)); !nbt.Equal(encoded, fixture) {
+			t.Error("expected ", fixture, ", got ", encoded)
+		}
+	}
+
+	stuck := mustEntity(arrowFixture(true))
+	p := stuck.Projectile
+	if p == nil || p.InGround == nil || *p.InGround != 1 || *p.XTile != 8 || *p.InTile != BlockCobblestone {
+		t.Error("expected an arrow stuck in cobblestone at x=8, got ", p)
+	}
+	if stuck.Tile != nil {
+		t.Error("arrow decoded a falling block tile")
+	}
+	if p.Direction != nil {
+		t.Error("arrow decoded a fireball direction")
+	}
+	if d := mustEntity(fireball).Projectile.Direction; d == nil || d.DZ != 0.05 {
+		t.Error("expected a fireball direction, got ", d)
+	}
+	if mustEntity(pigFixture).Projectile != nil {
+		t.Error("pig decoded as a projectile")
+	}
+}
+
+func minecartFixture(id string, tags map[string]interface{}) map[string]interface{} {
+	cart := map[string]interface{}{
+		"id":           id,
+		"Pos":          []interface{}{float64(4.5), float64(64.35), float64(12.5)},
+		"Motion":       []interface{}{float64(0), float64(0), float64(0)},
+		"Rotation":     []interface{}{float32(90), float32(0)},
+		"FallDistance": float32(0),
+		"Fire":         int16(0),
+		"Air":          int16(300),
+		"OnGround":     int8(0),
+	}
+	for k, v := range tags {
+		cart[k] = v
+	}
+	return cart
+}
+
+func TestMinecartRoundTrip(t *testing.T) {
+	rideable := minecartFixture("Minecart", map[string]interface{}{"Type": int32(MinecartRideable)})
+	storage := minecartFixture("Minecart", map[string]interface{}{
+		"Type": int32(MinecartChest),
+		"Items": []interface{}{
+			itemCompound(0, BlockCobblestone, 64, 0),
+			itemCompound(26, 264, 3, 0),
+		},
+	})
+	powered := minecartFixture("Minecart", map[string]interface{}{
+		"Type":  int32(MinecartFurnace),
+		"PushX": float64(0.5),
+		"PushZ": float64(-0.25),
+		"Fuel":  int16(3600),
+	})
+	boat := minecartFixture("Boat", nil)
+	payload := testChunkPayload(0, 0, []interface{}{rideable, storage, powered, boat}, nil)
+	c := mustChunk(payload)
+	if encoded := mustFromChunk(c); !nbt.Equal(encoded, payload) {
+		t.Error("expected ", payload, ", got ", encoded)
+	}
+
+	for i, cartType := range []int32{MinecartRideable, MinecartChest, MinecartFurnace} {
+		if cart := c.Level.Entities[i].Minecart; cart == nil || cart.Type != cartType {
+			t.Errorf("%d: expected a minecart of type %d, got %v", i, cartType, cart)
+		}
+	}
+	if c.Level.Entities[3].Minecart != nil {
+		t.Error("boat decoded as a minecart")
+	}
+	if cart := c.Level.Entities[2].Minecart; cart.Fuel != 3600 || cart.PushZ != -0.25 {
+		t.Error("powered cart lost its fuel or push, got ", cart)
+	}
+
+	cart := c.Level.Entities[1].Minecart
+	if item, ok := cart.ItemAt(26); !ok || item.Id != 264 || item.Count != 3 {
+		t.Error("expected 3 diamonds in slot 26, got ", item, ok)
+	}
+	if err := cart.SetItem(5, Item{Id: BlockSand, Count: 10}); err != nil {
+		t.Fatal(err)
+	}
+	if err := cart.SetItem(0, Item{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := cart.SetItem(27, Item{Id: BlockSand, Count: 1}); err == nil {
+		t.Error("expected an error for slot 27")
+	}
+	if err := c.Level.Entities[0].Minecart.SetItem(0, Item{Id: BlockSand, Count: 1}); err == nil {
+		t.Error("expected an error storing in a rideable cart")
+	}
+	if len(cart.Items) != 2 || cart.Items[0].Slot != 5 || cart.Items[1].Slot != 26 {
+		t.Error("expected slots 5 and 26 filled, got ", cart.Items)
+	}
+	reloaded := mustEntity(fromEntity(c.Level.Entities[1]))
+	if item, ok := reloaded.Minecart.ItemAt(5); !ok || item.Id != BlockSand || item.Count != 10 {
+		t.Error("expected 10 sand in slot 5 after a round trip, got ", item, ok)
+	}
+}
+
+func TestMoveEntity(t *testing.T) {
+	dir := makeTestWorld(t,
+		testChunkPayload(0, 0, []interface{}{pigFixture}, nil),
+		testChunkPayload(-1, 0, nil, nil))
+	defer os.RemoveAll(dir)
+	w, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	from, err := w.GetChunk(0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pig := from.Level.Entities[0]
+	velocity := pig.Physics.Velocity
+
+	// within its chunk
+	if err = w.MoveEntity(pig, 1.5, 65, 9.25); err != nil {
+		t.Fatal(err)
+	}
+	if len(from.Level.Entities) != 1 || !from.Dirty() {
+		t.Error("expected the pig to stay in a dirty (0, 0)")
+	}
+
+	// across the border into a chunk that is not resident
+	if err = w.MoveEntityResident(pig, -0.5, 65, 9.25); err == nil {
+		t.Error("expected an error moving into an unloaded chunk")
+	}
+	if pig.Physics.Position.X != 1.5 {
+		t.Error("failed move changed the position to ", pig.Physics.Position)
+	}
+	if err = w.MoveEntity(pig, -0.5, 65, 9.25); err != nil {
+		t.Fatal(err)
+	}
+	to := w.Chunks[MakeXZ(-1, 0)]
+	if len(from.Level.Entities) != 0 {
+		t.Error("pig left behind in (0, 0): ", from.Level.Entities)
+	}
+	if len(to.Level.Entities) != 1 || to.Level.Entities[0] != pig {
+		t.Error("expected the pig in (-1, 0), got ", to.Level.Entities)
+	}
+	if !to.Dirty() {
+		t.Error("destination not marked dirty")
+	}
+	if !reflect.DeepEqual(pig.Physics.Position, Position{-0.5, 65, 9.25}) || !reflect.DeepEqual(pig.Physics.Velocity, velocity) {
+		t.Error("bad physics after move: ", pig.Physics)
+	}
+
+	if err = w.MoveEntity(pig, 40, 65, 0); err == nil {
+		t.Error("expected an error moving into a missing chunk")
+	}
+	if len(to.Level.Entities) != 1 {
+		t.Error("failed move lost the pig")
+	}
+}
+
+func TestNewPrimedTNT(t *testing.T) {
+	tnt := NewPrimedTNT(4.5, 64, 4.5, 80)
+	want := make(map[string]interface{})
+	for k, v := range tntFixture {
+		want[k] = v
+	}
+	want["Motion"] = []interface{}{float64(0), float64(0), float64(0)}
+	want["Fuse"] = int8(80)
+	if encoded := fromEntity(tnt); !nbt.Equal(encoded, want) {
+		t.Error("expected ", want, ", got ", encoded)
+	}
+	if fuse := mustEntity(fromEntity(tnt)).Fuse; fuse == nil || *fuse != 80 {
+		t.Error("fuse lost in round trip")
+	}
+}
+
+func TestNewFallingBlock(t *testing.T) {
+	w := &World{Chunks: make(map[XZ]*Chunk)}
+	w.Chunks[MakeXZ(0, 0)] = newChunk(0, 0)
+	if err := w.AddEntity(NewFallingBlock(2.5, 80.5, 2.5, BlockSand)); err != nil {
+		t.Fatal(err)
+	}
+	encoded := fromEntity(w.Chunks[MakeXZ(0, 0)].Level.Entities[0])
+	if encoded["Tile"] != int8(BlockSand) || encoded["id"] != "FallingSand" {
+		t.Error("bad falling block ", encoded)
+	}
+	for _, tag := range []string{"Health", "Age", "Fuse"} {
+		if _, ok := encoded[tag]; ok {
+			t.Error("falling block has tag ", tag)
+		}
+	}
+}
+
+func TestRidingRoundTrip(t *testing.T) {
+	jockey := mobFixture("Skeleton", map[string]interface{}{"Riding": mobFixture("Spider", nil)})
+	cart := mobFixture("Zombie", map[string]interface{}{
+		"Riding": minecartFixture("Minecart", map[string]interface{}{"Type": int32(MinecartRideable)}),
+	})
+	for _, fixture := range []map[string]interface{}{jockey, cart} {
+		e := mustEntity(fixture)
+		if e.Riding == nil || e.Riding.Riding != nil {
+			t.Fatal("expected one vehicle, got ", e.Riding)
+		}
+		if encoded := fromEntity(e); !nbt.Equal(encoded, fixture) {
+			t.Error("expected ", fixture, ", got ", encoded)
+		}
+	}
+	if spider := mustEntity(jockey).Riding; spider.Id != "Spider" || spider.Extra["Riding"] != nil {
+		t.Error("bad vehicle ", spider)
+	}
+	if cart := mustEntity(cart).Riding; cart.Minecart == nil {
+		t.Error("minecart data not decoded for a vehicle")
+	}
+
+	// a stack deeper than maxRidingDepth survives undecoded
+	tower := mobFixture("Slime", map[string]interface{}{"Size": int32(0)})
+	for i := 0; i < maxRidingDepth+2; i++ {
+		tower = mobFixture("Slime", map[string]interface{}{"Size": int32(0), "Riding": tower})
+	}
+	e := mustEntity(tower)
+	depth := 0
+	for ; e.Riding != nil; e = e.Riding {
+		depth++
+	}
+	if depth != maxRidingDepth || e.Extra["Riding"] == nil {
+		t.Error("expected decoding to stop at depth ", maxRidingDepth, ", stopped at ", depth)
+	}
+	if encoded := fromEntity(mustEntity(tower)); !nbt.Equal(encoded, tower) {
+		t.Error("deep stack did not round-trip")
+	}
+}
+
+func TestRidingCensusAndMove(t *testing.T) {
+	jockey := mobFixture("Skeleton", map[string]interface{}{"Riding": mobFixture("Spider", nil)})
+	w := &World{Chunks: make(map[XZ]*Chunk)}
+	for cx := int32(-1); cx <= 1; cx++ {
+		w.Chunks[MakeXZ(cx, 0)] = newChunk(cx, 0)
+	}
+	w.Chunks[MakeXZ(0, 0)].Level.Entities = []*Entity{mustEntity(jockey)}
+	region := NewRegion(-1, 0, 1, 0)
+	counts, err := w.EntityCensus(region, CensusOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if counts["Skeleton"] != 1 || counts["Spider"] != 1 {
+		t.Error("expected the spider to be counted, got ", counts)
+	}
+	if counts, _ = w.EntityCensus(region, CensusOptions{IgnoreVehicles: true}); counts["Spider"] != 0 {
+		t.Error("expected the spider to be ignored, got ", counts)
+	}
+	if found, _ := w.EntitiesInBox(-32, 0, -32, 32, 128, 32); len(found) != 1 {
+		t.Error("expected only the rider in the box, got ", found)
+	}
+
+	skeleton := w.Chunks[MakeXZ(0, 0)].Level.Entities[0]
+	spider := skeleton.Riding
+	dx := spider.Physics.Position.X - skeleton.Physics.Position.X
+	if err = w.MoveEntity(skeleton, 20, 70, 4); err != nil {
+		t.Fatal(err)
+	}
+	if spider.Physics.Position.X != 20+dx || len(w.Chunks[MakeXZ(1, 0)].Level.Entities) != 1 {
+		t.Error("vehicle not moved with its rider: ", spider.Physics.Position)
+	}
+}
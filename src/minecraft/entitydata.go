@@ -0,0 +1,349 @@
+package world
+
+import "minecraft/error"
+
+import "fmt"
+import "os"
+
+// Tags carried only by certain kinds of entity.  Each kind decodes into its own
+// struct, which is nil on entities that do not carry its tags.
+
+// SheepData holds a sheep's wool: Color is a wool data value (0 white through
+// 15 black) and Sheared is 1 once the wool has been taken.
+type SheepData struct {
+	Color   int8
+	Sheared int8
+}
+
+// SlimeData holds a slime's size.  The game stores one less than the size, so a
+// size 1 slime has Size 0.
+type SlimeData struct {
+	Size int32
+}
+
+// PigData holds whether a pig is saddled (1) or not (0).
+type PigData struct {
+	Saddle int8
+}
+
+// WolfData holds a wolf's mood and master: Owner is the name of the player who
+// tamed it, empty for a wild wolf, and Sitting and Angry are 1 when it is.
+type WolfData struct {
+	Owner   string
+	Sitting int8
+	Angry   int8
+}
+
+// PaintingData places a painting: Motive names the picture, Dir is the wall it
+// hangs on (0 to 3), and TileX, TileY and TileZ are the block it hangs from.
+type PaintingData struct {
+	Motive              string
+	Dir                 int8
+	TileX, TileY, TileZ int32
+}
+
+var motives = []string{
+	"Kebab", "Aztec", "Alban", "Aztec2", "Bomb", "Plant", "Wasteland",
+	"Pool", "Courbet", "Sea", "Sunset", "Creebet",
+	"Wanderer", "Graham",
+	"Match", "Bust", "Stage", "Void", "SkullAndRoses",
+	"Fighters",
+	"Pointer", "Pigscene", "BurningSkull",
+	"Skeleton", "DonkeyKong",
+}
+
+// KnownMotive reports whether the game has a painting called name.
+func KnownMotive(name string) bool {
+	for _, m := range motives {
+		if m == name {
+			return true
+		}
+	}
+	return false
+}
+
+// maxHealth is the health, in half hearts, each mob spawns with.  Slimes are
+// left out: theirs depends on their size.  An id listed here, or Slime, is one
+// KnownMob accepts.
+var maxHealth = map[string]int16{
+	"Pig": 10, "Sheep": 8, "Cow": 10, "Chicken": 4, "Squid": 10, "Wolf": 8,
+	"Creeper": 20, "Skeleton": 20, "Spider": 16, "Zombie": 20, "PigZombie": 20,
+	"Ghast": 10, "Giant": 100,
+}
+
+// KnownMob reports whether id names a mob the game knows.
+func KnownMob(id string) bool {
+	_, ok := maxHealth[id]
+	return ok || id == "Slime"
+}
+
+// MaxHealth returns the health e spawns with, and whether e is a mob the game
+// knows.  Other entities, such as item drops, have no meaningful maximum.
+func MaxHealth(e *Entity) (int16, bool) {
+	if e.Id == "Slime" && e.Slime != nil {
+		size := int16(e.Slime.Size + 1)
+		return size * size, true
+	}
+	max, ok := maxHealth[e.Id]
+	return max, ok
+}
+
+// ProjectileData holds the tags of arrows, snowballs, eggs and fireballs.  Each
+// field is nil if the projectile does not carry that tag.  XTile, YTile and ZTile
+// are the block the projectile is stuck in and InTile and InData that block's id
+// and data; they are unrelated to Entity.Tile, the block a falling sand or
+// gravel entity will become.  Direction is a fireball's acceleration.
+type ProjectileData struct {
+	XTile, YTile, ZTile *int16
+	InTile              *int8
+	InData              *int8
+	InGround            *int8
+	Shake               *int8
+	Direction           *Velocity
+}
+
+// Minecart types.
+const (
+	MinecartRideable = 0
+	MinecartChest    = 1
+	MinecartFurnace  = 2
+)
+
+// MinecartData holds a minecart's type and the tags that go with it: Items for
+// storage carts, and PushX, PushZ and Fuel for powered carts.  Changes made
+// through it do not mark the owning chunk dirty.
+type MinecartData struct {
+	Type         int32
+	Items        []InventorySlot
+	PushX, PushZ float64
+	Fuel         int16
+}
+
+func toSheepData(payload map[string]interface{}) *SheepData {
+	color, ok1 := payload["Color"].(int8)
+	sheared, ok2 := payload["Sheared"].(int8)
+	if !ok1 || !ok2 {
+		return nil
+	}
+	return &SheepData{color, sheared}
+}
+
+func (d *SheepData) encode(payload map[string]interface{}) {
+	payload["Color"] = d.Color
+	payload["Sheared"] = d.Sheared
+}
+
+func toSlimeData(payload map[string]interface{}) *SlimeData {
+	size, ok := payload["Size"].(int32)
+	if !ok {
+		return nil
+	}
+	return &SlimeData{size}
+}
+
+func (d *SlimeData) encode(payload map[string]interface{}) {
+	payload["Size"] = d.Size
+}
+
+func toPigData(payload map[string]interface{}) *PigData {
+	saddle, ok := payload["Saddle"].(int8)
+	if !ok {
+		return nil
+	}
+	return &PigData{saddle}
+}
+
+func (d *PigData) encode(payload map[string]interface{}) {
+	payload["Saddle"] = d.Saddle
+}
+
+func toWolfData(payload map[string]interface{}) *WolfData {
+	owner, ok1 := payload["Owner"].(string)
+	sitting, ok2 := payload["Sitting"].(int8)
+	angry, ok3 := payload["Angry"].(int8)
+	if !ok1 || !ok2 || !ok3 {
+		return nil
+	}
+	return &WolfData{owner, sitting, angry}
+}
+
+func (d *WolfData) encode(payload map[string]interface{}) {
+	payload["Owner"] = d.Owner
+	payload["Sitting"] = d.Sitting
+	payload["Angry"] = d.Angry
+}
+
+func toPaintingData(payload map[string]interface{}) *PaintingData {
+	motive, ok1 := payload["Motive"].(string)
+	dir, ok2 := payload["Dir"].(int8)
+	x, ok3 := payload["TileX"].(int32)
+	y, ok4 := payload["TileY"].(int32)
+	z, ok5 := payload["TileZ"].(int32)
+	if !ok1 || !ok2 || !ok3 || !ok4 || !ok5 {
+		return nil
+	}
+	return &PaintingData{motive, dir, x, y, z}
+}
+
+func (d *PaintingData) encode(payload map[string]interface{}) {
+	payload["Motive"] = d.Motive
+	payload["Dir"] = d.Dir
+	payload["TileX"] = d.TileX
+	payload["TileY"] = d.TileY
+	payload["TileZ"] = d.TileZ
+}
+
+func toProjectileData(payload map[string]interface{}) *ProjectileData {
+	d := &ProjectileData{
+		XTile:    optInt16(payload, "xTile"),
+		YTile:    optInt16(payload, "yTile"),
+		ZTile:    optInt16(payload, "zTile"),
+		InTile:   optInt8(payload, "inTile"),
+		InData:   optInt8(payload, "inData"),
+		InGround: optInt8(payload, "inGround"),
+		Shake:    optInt8(payload, "shake"),
+	}
+	if dir, ok := payload["direction"].([]interface{}); ok && len(dir) == 3 {
+		dx, ok1 := dir[0].(float64)
+		dy, ok2 := dir[1].(float64)
+		dz, ok3 := dir[2].(float64)
+		if ok1 && ok2 && ok3 {
+			d.Direction = &Velocity{dx, dy, dz}
+		}
+	}
+	if len(d.tags()) == 0 {
+		return nil
+	}
+	return d
+}
+
+// tags returns the names of the tags d holds.
+func (d *ProjectileData) tags() (names []string) {
+	if d.XTile != nil {
+		names = append(names, "xTile")
+	}
+	if d.YTile != nil {
+		names = append(names, "yTile")
+	}
+	if d.ZTile != nil {
+		names = append(names, "zTile")
+	}
+	if d.InTile != nil {
+		names = append(names, "inTile")
+	}
+	if d.InData != nil {
+		names = append(names, "inData")
+	}
+	if d.InGround != nil {
+		names = append(names, "inGround")
+	}
+	if d.Shake != nil {
+		names = append(names, "shake")
+	}
+	if d.Direction != nil {
+		names = append(names, "direction")
+	}
+	return
+}
+
+func (d *ProjectileData) encode(payload map[string]interface{}) {
+	if d.XTile != nil {
+		payload["xTile"] = *d.XTile
+	}
+	if d.YTile != nil {
+		payload["yTile"] = *d.YTile
+	}
+	if d.ZTile != nil {
+		payload["zTile"] = *d.ZTile
+	}
+	if d.InTile != nil {
+		payload["inTile"] = *d.InTile
+	}
+	if d.InData != nil {
+		payload["inData"] = *d.InData
+	}
+	if d.InGround != nil {
+		payload["inGround"] = *d.InGround
+	}
+	if d.Shake != nil {
+		payload["shake"] = *d.Shake
+	}
+	if d.Direction != nil {
+		payload["direction"] = []interface{}{d.Direction.DX, d.Direction.DY, d.Direction.DZ}
+	}
+}
+
+// toMinecartData decodes the tags of a minecart, returning nil if any of the tags
+// its type calls for are missing or malformed so that they are kept in
+// Entity.Extra instead.
+func toMinecartData(payload map[string]interface{}) (d *MinecartData, known []string) {
+	cartType, ok := payload["Type"].(int32)
+	if !ok {
+		return
+	}
+	cart := &MinecartData{Type: cartType}
+	switch cartType {
+	case MinecartChest:
+		var err os.Error
+		if cart.Items, err = toInventory(payload); err != nil {
+			return
+		}
+		known = []string{"Type", "Items"}
+	case MinecartFurnace:
+		var ok1, ok2, ok3 bool
+		cart.PushX, ok1 = payload["PushX"].(float64)
+		cart.PushZ, ok2 = payload["PushZ"].(float64)
+		cart.Fuel, ok3 = payload["Fuel"].(int16)
+		if !ok1 || !ok2 || !ok3 {
+			return
+		}
+		known = []string{"Type", "PushX", "PushZ", "Fuel"}
+	default:
+		known = []string{"Type"}
+	}
+	return cart, known
+}
+
+func (d *MinecartData) encode(payload map[string]interface{}) {
+	payload["Type"] = d.Type
+	switch d.Type {
+	case MinecartChest:
+		payload["Items"] = fromInventory(d.Items)
+	case MinecartFurnace:
+		payload["PushX"] = d.PushX
+		payload["PushZ"] = d.PushZ
+		payload["Fuel"] = d.Fuel
+	}
+}
+
+func (d *MinecartData) checkSlot(slot int8) os.Error {
+	if d.Type != MinecartChest {
+		return error.NewError(fmt.Sprintf("minecart of type %d has no storage", d.Type), nil)
+	}
+	if slot < 0 || slot >= ChestSlots {
+		return error.NewError(fmt.Sprint("no such minecart slot: ", slot), nil)
+	}
+	return nil
+}
+
+// ItemAt returns the stack in a storage cart's slot, and false if it is empty.
+func (d *MinecartData) ItemAt(slot int8) (item Item, ok bool) {
+	if i := findSlot(d.Items, slot); i >= 0 {
+		return d.Items[i].Item, true
+	}
+	return
+}
+
+// SetItem puts item in a storage cart's slot, emptying it if item.Count is not
+// positive.
+func (d *MinecartData) SetItem(slot int8, item Item) os.Error {
+	if err := d.checkSlot(slot); err != nil {
+		return err
+	}
+	if item.Count <= 0 {
+		d.Items = takeSlot(d.Items, slot)
+	} else {
+		d.Items = putSlot(d.Items, slot, item)
+	}
+	return nil
+}
@@ -2,48 +2,162 @@ package error
 
 import "os"
 import "fmt"
-import "runtime"
 
+// An Error is a failure, told by a message, the context in which it happened
+// and the error that caused it, if any.  Its String joins them on one line,
+// outermost first:
+//
+//	could not load chunk (3, -7): could not open file: open .../c.3.-7.dat: no such file or directory
 type Error interface {
 	os.Error
+	// Inner returns Cause as an Error.
 	Inner() Error
+	// Cause returns the error this one wraps, as it was given, or nil.
+	Cause() os.Error
+	// Context returns the context given with this error, not its causes'.
+	Context() Context
 }
 
-type error struct {
-	err   os.Error
-	inner Error
+// Context is the structured context of an Error; its zero value gives none.
+// The fields that are set are shown after the message, as in
+// "could not read tag (3, -7) .../c.3.-7.dat in /worlds/w at byte 1024".
+type Context struct {
+	Dir       string // the world directory
+	Path      string // the file
+	HasChunk  bool   // whether X and Z are set
+	X, Z      int32  // the chunk's coordinates
+	HasOffset bool   // whether Offset is set
+	Offset    int64  // the byte offset in the file or stream
 }
 
-func NewError(message string, inner os.Error) Error {
-	caller, _, _, ok := runtime.Caller(1)
-	var callerName string
-	if !ok {
-		callerName = "<unknown>"
-	} else {
-		callerName = runtime.FuncForPC(caller).Name()
+// InWorld, InFile, InChunk and AtOffset return a Context giving just one thing.
+func InWorld(dir string) Context    { return Context{Dir: dir} }
+func InFile(path string) Context    { return Context{Path: path} }
+func InChunk(x, z int32) Context    { return Context{HasChunk: true, X: x, Z: z} }
+func AtOffset(offset int64) Context { return Context{HasOffset: true, Offset: offset} }
+
+// Error returns an Error with the message, this context and inner as its cause.
+func (ctx Context) Error(message string, inner os.Error) Error {
+	return error{err: os.ErrorString(message), ctx: ctx, cause: inner}
+}
+
+// String formats the fields of ctx that are set, each preceded by a space.
+func (ctx Context) String() (s string) {
+	if ctx.HasChunk {
+		s += fmt.Sprintf(" (%d, %d)", ctx.X, ctx.Z)
+	}
+	if ctx.Path != "" {
+		s += " " + ctx.Path
 	}
-	msg := fmt.Sprint(callerName, ": ", message)
-	var inerr Error
-	if inner != nil {
-		inerr = newOsOnly(inner)
+	if ctx.Dir != "" {
+		s += " in " + ctx.Dir
 	}
-	return error{err: (os.ErrorString)(msg), inner: inerr}
+	if ctx.HasOffset {
+		s += fmt.Sprint(" at byte ", ctx.Offset)
+	}
+	return
 }
-func newOsOnly(err os.Error) Error {
-	return error{err: err}
+
+type error struct {
+	err   os.Error // the message, or the value given to Wrap
+	ctx   Context
+	cause os.Error
+}
+
+// NewError returns an Error with the message and inner as its cause.
+func NewError(message string, inner os.Error) Error {
+	return error{err: os.ErrorString(message), cause: inner}
+}
+
+// Wrap returns an Error whose message is err, kept as a value for Find, and
+// whose cause is inner.  It marks a failure as being of a kind callers test
+// for, such as a value exported by the package that returns it, while keeping
+// what caused it.
+func Wrap(err os.Error, inner os.Error) Error {
+	return error{err: err, cause: inner}
 }
 
 func (err error) String() string {
-	inner := err.inner
-	var str string
-	if inner != nil {
-		str = fmt.Sprint(err.err.String(), "\n-> ", inner.String())
-	} else {
-		str = err.err.String()
+	str := err.err.String() + err.ctx.String()
+	if err.cause != nil {
+		str += ": " + err.cause.String()
 	}
 	return str
 }
 
 func (err error) Inner() Error {
-	return err.inner
+	if err.cause == nil {
+		return nil
+	}
+	if e, ok := err.cause.(Error); ok {
+		return e
+	}
+	return error{err: err.cause}
+}
+
+func (err error) Cause() os.Error {
+	return err.cause
+}
+
+func (err error) Context() Context {
+	return err.ctx
+}
+
+// RootCause returns the error at the end of err's chain of causes: the first
+// that is not an Error, or has no cause.
+func RootCause(err os.Error) os.Error {
+	for {
+		e, ok := err.(Error)
+		if !ok || e.Cause() == nil {
+			return err
+		}
+		err = e.Cause()
+	}
+	panic("unreachable")
+}
+
+// ContextOf merges the contexts of err's chain of causes, each field taken
+// from the outermost Error that sets it.
+func ContextOf(err os.Error) (ctx Context) {
+	for err != nil {
+		e, ok := err.(Error)
+		if !ok {
+			break
+		}
+		c := e.Context()
+		if ctx.Dir == "" {
+			ctx.Dir = c.Dir
+		}
+		if ctx.Path == "" {
+			ctx.Path = c.Path
+		}
+		if !ctx.HasChunk && c.HasChunk {
+			ctx.HasChunk, ctx.X, ctx.Z = true, c.X, c.Z
+		}
+		if !ctx.HasOffset && c.HasOffset {
+			ctx.HasOffset, ctx.Offset = true, c.Offset
+		}
+		err = e.Cause()
+	}
+	return
+}
+
+// Find returns the first error in err's chain for which match is true, or nil
+// if there is none.  The chain is err itself, then the values given to Wrap
+// and the causes, however deeply they are nested.
+func Find(err os.Error, match func(os.Error) bool) os.Error {
+	if err == nil {
+		return nil
+	}
+	if match(err) {
+		return err
+	}
+	e, ok := err.(error)
+	if !ok {
+		return nil
+	}
+	if found := Find(e.err, match); found != nil {
+		return found
+	}
+	return Find(e.cause, match)
 }
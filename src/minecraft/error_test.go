@@ -0,0 +1,71 @@
+package error
+
+import "os"
+import "testing"
+
+func TestCauseChain(t *testing.T) {
+	root := &os.PathError{"open", "/worlds/w/1/j/c.3.-7.dat", os.ENOENT}
+	open := NewError("could not open file", root)
+	load := InChunk(3, -7).Error("could not load chunk", open)
+	flush := InWorld("/worlds/w").Error("could not flush", load)
+
+	if got := RootCause(flush); got != os.Error(root) {
+		t.Errorf("expected the *os.PathError at the root, got %v", got)
+	}
+	if e, ok := RootCause(flush).(*os.PathError); !ok || e.Error != os.ENOENT {
+		t.Error("expected ENOENT at the root")
+	}
+	if flush.Cause() != os.Error(load) || load.Cause() != os.Error(open) || open.Cause() != os.Error(root) {
+		t.Error("expected each Cause to be the error wrapped")
+	}
+	if flush.Inner().Inner().Inner().Cause() != nil {
+		t.Error("expected the root, as an Error, to have no cause")
+	}
+	if RootCause(nil) != nil || RootCause(root) != os.Error(root) {
+		t.Error("expected RootCause of an error that wraps nothing to be itself")
+	}
+
+	ctx := ContextOf(flush)
+	if ctx.Dir != "/worlds/w" || !ctx.HasChunk || ctx.X != 3 || ctx.Z != -7 || ctx.HasOffset || ctx.Path != "" {
+		t.Errorf("expected the world and chunk merged from the chain, got %+v", ctx)
+	}
+	if c := flush.Context(); c.HasChunk {
+		t.Errorf("expected Context to be the outermost error's alone, got %+v", c)
+	}
+
+	want := "could not flush in /worlds/w: could not load chunk (3, -7): could not open file: " + root.String()
+	if got := flush.String(); got != want {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+}
+
+func TestContextString(t *testing.T) {
+	ctx := Context{Dir: "/worlds/w", Path: "level.dat", HasChunk: true, X: -1, Z: 2, HasOffset: true, Offset: 1024}
+	if got, want := ctx.String(), " (-1, 2) level.dat in /worlds/w at byte 1024"; got != want {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+	if got := AtOffset(0).Error("bad tag", nil).String(); got != "bad tag at byte 0" {
+		t.Errorf("expected an offset of 0 shown, got %q", got)
+	}
+	if got := (Context{}).String(); got != "" {
+		t.Errorf("expected nothing from the zero Context, got %q", got)
+	}
+}
+
+func TestWrapFind(t *testing.T) {
+	kind := os.ErrorString("kind")
+	root := os.ErrorString("root")
+	err := InFile("c.0.0.dat").Error("could not decode", Wrap(kind, root))
+	if Find(err, func(e os.Error) bool { return e == kind }) != kind {
+		t.Error("expected Find to see the value given to Wrap")
+	}
+	if Find(err, func(e os.Error) bool { return e == root }) != root {
+		t.Error("expected Find to see the root")
+	}
+	if RootCause(err) != root {
+		t.Error("expected Wrap to keep its inner error as the cause")
+	}
+	if got := err.String(); got != "could not decode c.0.0.dat: kind: root" {
+		t.Errorf("unexpected %q", got)
+	}
+}
@@ -0,0 +1,92 @@
+package world
+
+import "minecraft/error"
+
+import "fmt"
+import "os"
+
+// errorKind is the type of the World's error values.
+type errorKind string
+
+func (e errorKind) String() string { return string(e) }
+
+// The kinds of failure callers may tell apart with IsError.  They are returned
+// wrapped in errors that say what was being done, as other errors are.
+var (
+	ErrChunkNotFound os.Error = errorKind("chunk not found")          // see ChunkNotFoundError
+	ErrCorruptChunk  os.Error = errorKind("chunk is corrupt")         // a chunk file cannot be decoded
+	ErrLockLost      os.Error = errorKind("session lock lost")        // another process has opened the world
+	ErrWorldNotFound os.Error = errorKind("world not found")          // Open found no world in the directory
+	ErrReadOnly      os.Error = errorKind("world is read-only")       // the session lock cannot be written
+	ErrOutOfRange    os.Error = errorKind("coordinates out of range") // block coordinates outside a chunk or the world
+	ErrCancelled     os.Error = errorKind("operation cancelled")      // see CancelledError
+)
+
+// A ChunkNotFoundError reports that there is no chunk at (X, Z), resident or on
+// disk.  IsError takes it for ErrChunkNotFound.
+type ChunkNotFoundError struct {
+	X, Z int32
+}
+
+func (e ChunkNotFoundError) String() string {
+	return fmt.Sprintf("chunk (%d, %d) not found", e.X, e.Z)
+}
+
+// A CancelledError reports that Op stopped, when World.Cancel was closed, with
+// Done of its Total steps done, steps being as Progress is told of them.
+// IsError takes it for ErrCancelled.
+type CancelledError struct {
+	Op          string
+	Done, Total int
+}
+
+func (e CancelledError) String() string {
+	return fmt.Sprintf("%s cancelled after %d of %d steps", e.Op, e.Done, e.Total)
+}
+
+// IsError reports whether err is target, one of the Err values, or wraps it.
+func IsError(err, target os.Error) bool {
+	return error.Find(err, func(e os.Error) bool {
+		switch e.(type) {
+		case ChunkNotFoundError:
+			return target == ErrChunkNotFound
+		case CancelledError:
+			return target == ErrCancelled
+		}
+		return e == target
+	}) != nil
+}
+
+// MissingChunk returns the coordinates of the chunk that err, or an error it
+// wraps, reports not found.
+func MissingChunk(err os.Error) (x, z int32, ok bool) {
+	e, ok := error.Find(err, func(e os.Error) bool {
+		_, ok := e.(ChunkNotFoundError)
+		return ok
+	}).(ChunkNotFoundError)
+	return e.X, e.Z, ok
+}
+
+// Cancelled returns the CancelledError that err is, or wraps, telling how far
+// the cancelled operation got.
+func Cancelled(err os.Error) (e CancelledError, ok bool) {
+	e, ok = error.Find(err, func(e os.Error) bool {
+		_, ok := e.(CancelledError)
+		return ok
+	}).(CancelledError)
+	return e, ok
+}
+
+// isNotExist reports whether err is an *os.PathError for a file that does not
+// exist.
+func isNotExist(err os.Error) bool {
+	e, ok := err.(*os.PathError)
+	return ok && e.Error == os.ENOENT
+}
+
+// isReadOnly reports whether err is an *os.PathError for a file that may not be
+// written.
+func isReadOnly(err os.Error) bool {
+	e, ok := err.(*os.PathError)
+	return ok && (e.Error == os.EACCES || e.Error == os.EROFS || e.Error == os.EPERM)
+}
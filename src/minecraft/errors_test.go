@@ -0,0 +1,145 @@
+package world
+
+import "minecraft/error"
+
+import "io/ioutil"
+import "os"
+import "path"
+import "strings"
+import "testing"
+
+func TestChunkErrors(t *testing.T) {
+	dir := makeTestWorld(t, testChunkPayload(0, 0, nil, nil))
+	defer os.RemoveAll(dir)
+	w, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	corrupt := chunkPath(w.dir, 1, 0)
+	if err = os.MkdirAll(path.Dir(corrupt), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err = ioutil.WriteFile(corrupt, []byte("not a chunk"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, lazy := range []bool{false, true} {
+		w.LazyArrays = lazy
+		_, err = w.LoadChunk(5, -3)
+		if !IsError(err, ErrChunkNotFound) || IsError(err, ErrCorruptChunk) {
+			t.Errorf("lazy %v: expected ErrChunkNotFound, got %v", lazy, err)
+		}
+		if x, z, ok := MissingChunk(err); !ok || x != 5 || z != -3 {
+			t.Errorf("lazy %v: expected chunk (5, -3) missing, got (%d, %d)", lazy, x, z)
+		}
+		if err == nil || !strings.Contains(err.String(), "could not load chunk (5, -3)") {
+			t.Errorf("lazy %v: expected the context kept, got %v", lazy, err)
+		}
+		if e, ok := error.RootCause(err).(*os.PathError); !ok || e.Error != os.ENOENT {
+			t.Errorf("lazy %v: expected ENOENT at the root, got %v", lazy, error.RootCause(err))
+		}
+		if ctx := error.ContextOf(err); !ctx.HasChunk || ctx.X != 5 || ctx.Z != -3 {
+			t.Errorf("lazy %v: expected the chunk in the context, got %+v", lazy, ctx)
+		}
+		if _, err = w.LoadChunk(1, 0); !IsError(err, ErrCorruptChunk) || IsError(err, ErrChunkNotFound) {
+			t.Errorf("lazy %v: expected ErrCorruptChunk, got %v", lazy, err)
+		}
+		if _, _, err = w.LoadChunksParallel([]XZ{MakeXZ(1, 0)}, 1); !IsError(err, ErrCorruptChunk) {
+			t.Errorf("lazy %v: expected ErrCorruptChunk loading in parallel, got %v", lazy, err)
+		}
+	}
+	if _, _, err = w.BlockAt(-100, 64, 0); !IsError(err, ErrChunkNotFound) {
+		t.Error("expected ErrChunkNotFound from BlockAt, got ", err)
+	}
+	if _, _, ok := MissingChunk(ErrChunkNotFound); ok {
+		t.Error("expected no coordinates from ErrChunkNotFound itself")
+	}
+
+	short := testChunkPayload(2, 0, nil, nil)
+	short["Level"].(map[string]interface{})["Blocks"] = make([]byte, 10)
+	if err = writeTestChunk(dir, short); err != nil {
+		t.Fatal(err)
+	}
+	c, err := w.GetChunk(2, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = c.LoadArrays(); !IsError(err, ErrCorruptChunk) {
+		t.Error("expected ErrCorruptChunk for a short Blocks, got ", err)
+	}
+}
+
+func TestOutOfRangeErrors(t *testing.T) {
+	c := newChunk(0, 0)
+	w := &World{Chunks: map[XZ]*Chunk{MakeXZ(0, 0): c}}
+	_, _, blockErr := c.BlockAt(16, 0, 0)
+	_, lightErr := c.SkyLightAt(0, ChunkHeight, 0)
+	_, _, sliceErr := c.Slice(-1)
+	_, _, worldErr := w.Slice(NewRegion(0, 0, 0, 0), ChunkHeight, BlockAir)
+	for i, err := range []os.Error{
+		blockErr,
+		c.SetBlock(0, -1, 0, BlockStone, 0),
+		lightErr,
+		sliceErr,
+		worldErr,
+	} {
+		if !IsError(err, ErrOutOfRange) || IsError(err, ErrCorruptChunk) {
+			t.Errorf("%d: expected ErrOutOfRange, got %v", i, err)
+		}
+	}
+	if IsError(nil, ErrOutOfRange) {
+		t.Error("expected nil to be no error")
+	}
+}
+
+func TestWorldErrors(t *testing.T) {
+	dir := makeTestWorld(t)
+	defer os.RemoveAll(dir)
+
+	if _, err := Open(path.Join(dir, "nowhere")); !IsError(err, ErrWorldNotFound) {
+		t.Error("expected ErrWorldNotFound for a missing directory, got ", err)
+	} else if ctx := error.ContextOf(err); ctx.Dir != path.Join(dir, "nowhere") {
+		t.Errorf("expected the world directory in the context, got %+v", ctx)
+	}
+	if _, err := Open(path.Join(dir, leveldat)); !IsError(err, ErrWorldNotFound) {
+		t.Error("expected ErrWorldNotFound for a file, got ", err)
+	}
+	empty, err := ioutil.TempDir("", "world")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(empty)
+	if _, err = Open(empty); !IsError(err, ErrWorldNotFound) {
+		t.Error("expected ErrWorldNotFound for a directory without level.dat, got ", err)
+	}
+
+	w, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = ioutil.WriteFile(path.Join(dir, sessionlock), make([]byte, 8), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err = w.VerifyLockNow(); !IsError(err, ErrLockLost) {
+		t.Error("expected ErrLockLost, got ", err)
+	}
+	if _, err = w.LoadChunk(0, 0); !IsError(err, ErrLockLost) || IsError(err, ErrChunkNotFound) {
+		t.Error("expected ErrLockLost loading a chunk, got ", err)
+	}
+	w.Close()
+
+	if !isReadOnly(&os.PathError{"open", sessionlock, os.EACCES}) || isReadOnly(&os.PathError{"open", sessionlock, os.ENOENT}) {
+		t.Error("expected only EACCES taken for read-only")
+	}
+	if err = os.Chmod(path.Join(dir, sessionlock), 0444); err != nil {
+		t.Fatal(err)
+	}
+	if w, err = Open(dir); err == nil {
+		// the superuser may write any file
+		t.Log("could open a world with a read-only session lock")
+		w.Close()
+	} else if !IsError(err, ErrReadOnly) {
+		t.Error("expected ErrReadOnly, got ", err)
+	}
+}
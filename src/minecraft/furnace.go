@@ -0,0 +1,118 @@
+package world
+
+import "minecraft/error"
+
+import "fmt"
+import "os"
+
+// Furnace slots.
+const (
+	FurnaceInput  = 0
+	FurnaceFuel   = 1
+	FurnaceOutput = 2
+)
+
+// CookTicks is how long a furnace takes to smelt one item.
+const CookTicks = 200
+
+// Input returns the stack being smelted; an empty slot has a Count of zero.
+func (furnace *Furnace) Input() Item {
+	return furnace.slot(FurnaceInput)
+}
+
+// Fuel returns the stack of fuel waiting to be burnt.
+func (furnace *Furnace) Fuel() Item {
+	return furnace.slot(FurnaceFuel)
+}
+
+// Output returns the stack of smelted items.
+func (furnace *Furnace) Output() Item {
+	return furnace.slot(FurnaceOutput)
+}
+
+// SetInput replaces the stack being smelted, emptying the slot if item.Count is
+// not positive.
+func (furnace *Furnace) SetInput(item Item) {
+	furnace.setSlot(FurnaceInput, item)
+}
+
+// SetFuel replaces the fuel stack.
+func (furnace *Furnace) SetFuel(item Item) {
+	furnace.setSlot(FurnaceFuel, item)
+}
+
+// SetOutput replaces the smelted stack.
+func (furnace *Furnace) SetOutput(item Item) {
+	furnace.setSlot(FurnaceOutput, item)
+}
+
+func (furnace *Furnace) slot(n int8) Item {
+	if i := findSlot(furnace.Slots, n); i >= 0 {
+		return furnace.Slots[i].Item
+	}
+	return Item{}
+}
+
+func (furnace *Furnace) setSlot(n int8, item Item) {
+	if item.Count <= 0 {
+		furnace.Slots = takeSlot(furnace.Slots, n)
+	} else {
+		furnace.Slots = putSlot(furnace.Slots, n, item)
+	}
+	furnace.touch()
+}
+
+// SetCookTime sets how far through smelting the input is, from 0 to CookTicks.
+func (furnace *Furnace) SetCookTime(ticks int16) os.Error {
+	if ticks < 0 || ticks > CookTicks {
+		return error.NewError(fmt.Sprintf("cook time %d is outside 0 to %d", ticks, CookTicks), nil)
+	}
+	furnace.CookTime = ticks
+	furnace.touch()
+	return nil
+}
+
+// SetBurnTime sets how many ticks of fuel are left burning.  If updateBlock is
+// set, the furnace block is swapped for a lit or unlit furnace to match.
+func (furnace *Furnace) SetBurnTime(ticks int16, updateBlock bool) os.Error {
+	if ticks < 0 {
+		return error.NewError(fmt.Sprint("negative burn time ", ticks), nil)
+	}
+	if updateBlock {
+		id := byte(BlockFurnace)
+		if ticks > 0 {
+			id = BlockLitFurnace
+		}
+		if err := furnace.setBlock(id); err != nil {
+			return err
+		}
+	}
+	furnace.BurnTime = ticks
+	furnace.touch()
+	return nil
+}
+
+// Ignite sets the furnace burning for fuelTicks and lights its block.
+func (furnace *Furnace) Ignite(fuelTicks int16) os.Error {
+	if fuelTicks <= 0 {
+		return error.NewError(fmt.Sprint("cannot ignite a furnace for ", fuelTicks, " ticks"), nil)
+	}
+	return furnace.SetBurnTime(fuelTicks, true)
+}
+
+// setBlock swaps the furnace's block for id, keeping its facing.
+func (furnace *Furnace) setBlock(id byte) os.Error {
+	c := furnace.chunk
+	if c == nil {
+		return error.NewError(fmt.Sprintf("furnace at (%d, %d, %d) is not in a chunk", furnace.x, furnace.y, furnace.z), nil)
+	}
+	lx, y, lz := furnace.local(c)
+	old, data, err := c.BlockAt(lx, y, lz)
+	if err != nil {
+		return error.NewError(fmt.Sprintf("furnace at (%d, %d, %d) is outside its chunk", furnace.x, furnace.y, furnace.z), err)
+	}
+	if old != BlockFurnace && old != BlockLitFurnace {
+		return error.NewError(fmt.Sprintf("block %d at (%d, %d, %d) is not a furnace", old, furnace.x, furnace.y, furnace.z), nil)
+	}
+	return c.SetBlock(lx, y, lz, id, data)
+}
@@ -0,0 +1,80 @@
+package world
+
+import "minecraft/nbt"
+
+import "testing"
+
+// furnaceChunk returns chunk (0, -2) holding furnaceFixture, at (11, 64, -20),
+// on an unlit furnace block facing east.
+func furnaceChunk() (*Chunk, *Furnace) {
+	payload := testChunkPayload(0, -2, nil, []interface{}{furnaceFixture})
+	c := mustChunk(payload)
+	c.SetBlock(11, 64, 12, BlockFurnace, 5)
+	c.dirty = false
+	return c, c.Level.TileEntities[0].(*Furnace)
+}
+
+func TestFurnaceSlots(t *testing.T) {
+	c, furnace := furnaceChunk()
+	if furnace.Input().Id != 15 || furnace.Fuel().Id != 263 || furnace.Output().Count != 0 {
+		t.Error("bad slots ", furnace.Slots)
+	}
+	furnace.SetInput(Item{})
+	furnace.SetOutput(Item{Id: 265, Count: 8})
+	if !c.Dirty() {
+		t.Error("chunk not marked dirty")
+	}
+	reread, err := toTileEntity(furnace.toCompound())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !nbt.Equal(reread.toCompound(), furnace.toCompound()) {
+		t.Error("expected ", furnace.toCompound(), ", got ", reread.toCompound())
+	}
+	if out := reread.(*Furnace).Output(); out.Id != 265 || out.Count != 8 || reread.(*Furnace).Input().Count != 0 {
+		t.Error("bad slots after round trip ", reread.(*Furnace).Slots)
+	}
+}
+
+func TestFurnaceTimes(t *testing.T) {
+	_, furnace := furnaceChunk()
+	if err := furnace.SetCookTime(CookTicks + 1); err == nil {
+		t.Error("expected an error for a cook time past CookTicks")
+	}
+	if err := furnace.SetBurnTime(-1, false); err == nil {
+		t.Error("expected an error for a negative burn time")
+	}
+	if err := furnace.SetCookTime(100); err != nil || furnace.CookTime != 100 {
+		t.Error("could not set cook time: ", err)
+	}
+}
+
+func TestFurnaceIgnite(t *testing.T) {
+	c, furnace := furnaceChunk()
+	if err := furnace.Ignite(1600); err != nil {
+		t.Fatal(err)
+	}
+	if furnace.BurnTime != 1600 {
+		t.Error("expected burn time 1600, got ", furnace.BurnTime)
+	}
+	if id, data, _ := c.BlockAt(11, 64, 12); id != BlockLitFurnace || data != 5 {
+		t.Errorf("expected a lit furnace facing 5, got %d:%d", id, data)
+	}
+	if !c.Dirty() {
+		t.Error("chunk not marked dirty")
+	}
+	if err := furnace.SetBurnTime(0, true); err != nil {
+		t.Fatal(err)
+	}
+	if id, _, _ := c.BlockAt(11, 64, 12); id != BlockFurnace {
+		t.Error("expected an unlit furnace, got ", id)
+	}
+
+	c.SetBlock(11, 64, 12, BlockStone, 0)
+	if err := furnace.Ignite(100); err == nil {
+		t.Error("expected an error igniting a furnace whose block is stone")
+	}
+	if furnace.BurnTime != 0 {
+		t.Error("failed ignite changed the burn time")
+	}
+}
@@ -0,0 +1,60 @@
+package world
+
+import "os"
+
+// A HealthPolicy says what NormalizeHealth does to the mobs it visits.
+type HealthPolicy int
+
+const (
+	ClampHealth HealthPolicy = iota // lower health above a mob's maximum to it
+	HealToMax                       // set every mob's health to its maximum
+	DeleteDead                      // remove mobs with no health left
+)
+
+// NormalizeHealth applies policy to every mob in region (nil meaning the whole
+// world), vehicles included, and returns how many of each id it changed or
+// removed.  Entities MaxHealth does not know are left alone.  A removed rider's
+// vehicle takes its place.  Chunks that are not resident are streamed from disk
+// and written back only if something changed.
+func (world *World) NormalizeHealth(region *Region, policy HealthPolicy) (counts map[string]int, err os.Error) {
+	counts = make(map[string]int)
+	err = world.streamChunks("NormalizeHealth", region, func(c *Chunk) (bool, os.Error) {
+		changed := 0
+		kept := c.Level.Entities[:0]
+		for _, e := range c.Level.Entities {
+			if e = normalizeHealth(e, policy, counts, &changed); e != nil {
+				kept = append(kept, e)
+			}
+		}
+		c.Level.Entities = kept
+		return changed > 0, nil
+	})
+	if err != nil {
+		counts = nil
+	}
+	return
+}
+
+// normalizeHealth applies policy to e and the vehicles it rides, returning what
+// is left of the stack.
+func normalizeHealth(e *Entity, policy HealthPolicy, counts map[string]int, changed *int) *Entity {
+	if e == nil {
+		return nil
+	}
+	e.Riding = normalizeHealth(e.Riding, policy, counts, changed)
+	max, ok := MaxHealth(e)
+	if !ok || e.Health == nil {
+		return e
+	}
+	switch health := *e.Health; {
+	case policy == DeleteDead && health <= 0:
+		counts[e.Id]++
+		*changed++
+		return e.Riding
+	case policy == ClampHealth && health > max, policy == HealToMax && health != max:
+		e.Health = &max
+		counts[e.Id]++
+		*changed++
+	}
+	return e
+}
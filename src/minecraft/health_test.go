@@ -0,0 +1,93 @@
+package world
+
+import "os"
+import "reflect"
+import "testing"
+
+// openHealthWorld opens a world whose chunk (0, 0) holds mobs with out of range
+// health, a dead skeleton riding a spider, and an item drop.
+func openHealthWorld(t *testing.T) (*World, string) {
+	mob := func(id string, health int16, tags map[string]interface{}) map[string]interface{} {
+		m := mobFixture(id, tags)
+		m["Health"] = health
+		return m
+	}
+	payload := []interface{}{
+		mob("Pig", 0, nil),
+		mob("Zombie", 500, nil),
+		mob("Cow", -3, nil),
+		mob("Sheep", 8, map[string]interface{}{"Color": int8(0), "Sheared": int8(0)}),
+		mob("Slime", 9, map[string]interface{}{"Size": int32(1)}),
+		mob("Skeleton", 0, map[string]interface{}{"Riding": mob("Spider", 30, nil)}),
+		itemAt(8, 64, 8),
+	}
+	dir := makeTestWorld(t, testChunkPayload(0, 0, payload, nil))
+	w, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = w.LoadChunk(0, 0); err != nil {
+		t.Fatal(err)
+	}
+	return w, dir
+}
+
+func TestNormalizeHealth(t *testing.T) {
+	region := NewRegion(0, 0, 0, 0)
+	tests := []struct {
+		policy HealthPolicy
+		counts map[string]int
+		health []int16
+	}{
+		{ClampHealth, map[string]int{"Zombie": 1, "Slime": 1, "Spider": 1},
+			[]int16{0, 20, -3, 8, 4, 0, 5}},
+		{HealToMax, map[string]int{"Pig": 1, "Zombie": 1, "Cow": 1, "Slime": 1, "Skeleton": 1, "Spider": 1},
+			[]int16{10, 20, 10, 8, 4, 20, 5}},
+		{DeleteDead, map[string]int{"Pig": 1, "Cow": 1, "Skeleton": 1},
+			[]int16{500, 8, 9, 30, 5}},
+	}
+	for _, test := range tests {
+		w, dir := openHealthWorld(t)
+		defer os.RemoveAll(dir)
+		defer w.Close()
+		counts, err := w.NormalizeHealth(region, test.policy)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(counts, test.counts) {
+			t.Errorf("policy %d: expected %v, got %v", test.policy, test.counts, counts)
+		}
+		c := w.Chunks[MakeXZ(0, 0)]
+		health := make([]int16, len(c.Level.Entities))
+		for i, e := range c.Level.Entities {
+			health[i] = *e.Health
+		}
+		if !reflect.DeepEqual(health, test.health) {
+			t.Errorf("policy %d: expected health %v, got %v", test.policy, test.health, health)
+		}
+		if !c.Dirty() {
+			t.Errorf("policy %d: chunk not marked dirty", test.policy)
+		}
+	}
+
+	w, dir := openHealthWorld(t)
+	defer os.RemoveAll(dir)
+	defer w.Close()
+	w.NormalizeHealth(region, ClampHealth)
+	if spider := w.Chunks[MakeXZ(0, 0)].Level.Entities[5].Riding; spider == nil || *spider.Health != 16 {
+		t.Error("vehicle not clamped: ", spider)
+	}
+}
+
+func TestMaxHealth(t *testing.T) {
+	slime := mustEntity(mobFixture("Slime", map[string]interface{}{"Size": int32(3)}))
+	if max, ok := MaxHealth(slime); !ok || max != 16 {
+		t.Error("expected a size 4 slime to have 16 health, got ", max)
+	}
+	if _, ok := MaxHealth(mustEntity(itemFixture)); ok {
+		t.Error("item drops have no maximum health")
+	}
+	if !KnownMob("Slime") || !KnownMob("Wolf") || KnownMob("Item") {
+		t.Error("KnownMob disagrees with the health table")
+	}
+}
@@ -0,0 +1,199 @@
+package world
+
+import "minecraft/error"
+
+import "fmt"
+import "image"
+import "io"
+import "math"
+import "os"
+
+// HeightmapOptions control how RenderHeightmap writes heights.
+type HeightmapOptions struct {
+	// Wide writes a 16-bit PNG rather than an 8-bit one.
+	Wide bool
+	// Missing is the gray value of the columns of missing chunks, from 0 to 255,
+	// or to 65535 if Wide is set.
+	Missing uint16
+}
+
+// RenderHeightmap writes a grayscale PNG of the surface height of region (nil
+// meaning every chunk in the world).  Pixel (px, py) is block column
+// (region.MinX*16 + px, region.MinZ*16 + py), as for RenderMap at scale 1.  A
+// column whose highest block is at y has gray value round(y*255/127), or
+// round(y*65535/127) with opts.Wide, so that each height has its own value; an
+// empty column is 0.  Level.HeightMap gives the height where it is valid, in
+// which case blocks that let skylight through, such as glass and torches, do not
+// count; otherwise the column is scanned.
+func (world *World) RenderHeightmap(w io.Writer, region *Region, opts HeightmapOptions) os.Error {
+	model, max := image.GrayColorModel, 255
+	if opts.Wide {
+		model, max = image.Gray16ColorModel, 65535
+	}
+	if int(opts.Missing) > max {
+		return error.NewError(fmt.Sprintf("gray value %d is out of range", opts.Missing), nil)
+	}
+	return world.renderColumns("RenderHeightmap", w, region, 1, model, func(c *Chunk, x, z int32) image.Color {
+		v := int(opts.Missing)
+		if c != nil {
+			h, _ := surfaceHeight(c, x, z)
+			v = heightValue(h, max)
+		}
+		if opts.Wide {
+			return image.Gray16Color{uint16(v)}
+		}
+		return image.GrayColor{uint8(v)}
+	})
+}
+
+// surfaceHeight returns the height of the highest block in column (x, z) of c,
+// or -1 if it is empty, trusting Level.HeightMap if it is valid.
+func surfaceHeight(c *Chunk, x, z int32) (int32, os.Error) {
+	if !c.HeightMapValid() {
+		y, _, err := c.highestBlockBelow(x, ChunkHeight-1, z)
+		return y, err
+	}
+	if err := c.LoadArrays(); err != nil {
+		return 0, err
+	}
+	return int32(c.Level.HeightMap[x+z*ChunkWidth]) - 1, nil
+}
+
+// heightValue maps height y, from 0 to 127, over the range 0 to max.
+func heightValue(y int32, max int) int {
+	if y < 0 {
+		return 0
+	}
+	return (int(y)*max + (ChunkHeight-1)/2) / (ChunkHeight - 1)
+}
+
+// dirtDepth is how many blocks of dirt ImportHeightmap puts under the surface.
+const dirtDepth = 3
+
+// HeightmapImportOptions control how ImportHeightmap builds terrain.
+type HeightmapImportOptions struct {
+	// Scale and Offset map the gray value g of a pixel, from 0 for black to 1
+	// for white, to the height of the surface of its column, Offset + g*Scale,
+	// rounded and kept within the world.  A Scale of zero means 127, which with
+	// no Offset undoes RenderHeightmap.
+	Scale, Offset float64
+	// Surface is the block on top of each column; zero means grass.
+	Surface byte
+	// WaterLevel floods the columns whose surface is below it with still water
+	// up to that height; zero means no water.
+	WaterLevel int32
+	// Overwrite rebuilds the columns of chunks that already exist, which are
+	// otherwise left alone.
+	Overwrite bool
+}
+
+// ImportHeightmap builds terrain from a grayscale image, the inverse of
+// RenderHeightmap: pixel (px, py), counted from the image's top left corner, is
+// block column (originX + px, originZ + py), and its gray value gives the height
+// of the column's surface as opts describes.  Each column is bedrock at y=0, then
+// stone, then three blocks of dirt under the surface block, and air or water
+// above; a column of height 0 is bedrock alone.  Chunks that do not exist are
+// created.  The columns of existing chunks are rebuilt only with opts.Overwrite,
+// which also drops the tile entities whose blocks are gone.  Sky light is filled
+// in straight down each column, heightmaps are recomputed and every chunk changed
+// is left resident, to be written on the next Flush.
+func (world *World) ImportHeightmap(m image.Image, originX, originZ int32, opts HeightmapImportOptions) os.Error {
+	scale := opts.Scale
+	if scale == 0 {
+		scale = ChunkHeight - 1
+	}
+	surface := opts.Surface
+	if surface == BlockAir {
+		surface = BlockGrass
+	}
+	if opts.WaterLevel < 0 || opts.WaterLevel >= ChunkHeight {
+		return error.NewError(fmt.Sprint("water level out of range: ", opts.WaterLevel), nil)
+	}
+	b := m.Bounds()
+	if b.Dx() <= 0 || b.Dy() <= 0 {
+		return nil
+	}
+	x1, z1 := originX+int32(b.Dx())-1, originZ+int32(b.Dy())-1
+	for cx := originX >> 4; cx <= x1>>4; cx++ {
+		for cz := originZ >> 4; cz <= z1>>4; cz++ {
+			var c *Chunk
+			var err os.Error
+			switch {
+			case !world.ChunkExists(cx, cz):
+				c, err = world.CreateChunk(cx, cz)
+			case opts.Overwrite:
+				c, err = world.GetChunk(cx, cz)
+			default:
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			c.Lock()
+			if err = c.LoadArrays(); err != nil {
+				c.Unlock()
+				return err
+			}
+			for x := int32(0); x < ChunkWidth; x++ {
+				for z := int32(0); z < ChunkDepth; z++ {
+					bx, bz := cx*ChunkWidth+x, cz*ChunkDepth+z
+					if bx < originX || bx > x1 || bz < originZ || bz > z1 {
+						continue
+					}
+					gray := image.Gray16ColorModel.Convert(m.At(b.Min.X+int(bx-originX), b.Min.Y+int(bz-originZ))).(image.Gray16Color)
+					h := int32(math.Floor(opts.Offset + float64(gray.Y)/0xffff*scale + 0.5))
+					fillColumn(c, x, z, max32(0, min32(h, ChunkHeight-1)), surface, opts.WaterLevel)
+				}
+			}
+			kept := c.Level.TileEntities[:0]
+			for _, te := range c.Level.TileEntities {
+				if !orphaned(c, te) {
+					kept = append(kept, te)
+				}
+			}
+			c.Level.TileEntities = kept
+			c.dirty = true
+			c.heightMapStale = true
+			err = c.updateHeightMap()
+			c.Unlock()
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// fillColumn rebuilds column (x, z) of c with its surface block at height h,
+// flooding it up to waterLevel, and lights it from the sky.  c's arrays must be
+// loaded.
+func fillColumn(c *Chunk, x, z, h int32, surface byte, waterLevel int32) {
+	base := blockIndex(x, 0, z)
+	for y := int32(0); y < ChunkHeight; y++ {
+		id := byte(BlockAir)
+		switch {
+		case y == 0:
+			id = BlockBedrock
+		case y == h:
+			id = surface
+		case y < h-dirtDepth:
+			id = BlockStone
+		case y < h:
+			id = BlockDirt
+		case y <= waterLevel:
+			id = BlockStillWater
+		}
+		c.Level.Blocks[base+int(y)] = id
+		setNibble(c.Level.Data, base+int(y), 0)
+		setNibble(c.Level.BlockLight, base+int(y), 0)
+	}
+	light := byte(15)
+	for y := int32(ChunkHeight - 1); y >= 0; y-- {
+		if o := lightOpacity[c.Level.Blocks[base+int(y)]]; o < light {
+			light -= o
+		} else {
+			light = 0
+		}
+		setNibble(c.Level.SkyLight, base+int(y), light)
+	}
+}
@@ -0,0 +1,195 @@
+package world
+
+import "bytes"
+import "image"
+import "os"
+import "testing"
+
+// heightmapWorld returns chunk (0, 0), with a valid heightmap and a block of stone
+// at y=10 in column (0, 0) and at y=127 in column (1, 0), and chunk (1, 0), whose
+// heightmap is stale after placing glass at y=64 in column (2, 3).  Chunk (0, 1)
+// is missing.
+func heightmapWorld() *World {
+	w := &World{Chunks: make(map[XZ]*Chunk)}
+	c := newChunk(0, 0)
+	c.SetBlock(0, 10, 0, BlockStone, 0)
+	c.SetBlock(1, 127, 0, BlockStone, 0)
+	c.updateHeightMap()
+	w.Chunks[MakeXZ(0, 0)] = c
+	c = newChunk(1, 0)
+	c.SetBlock(2, 64, 3, BlockGlass, 0)
+	w.Chunks[MakeXZ(1, 0)] = c
+	return w
+}
+
+func TestRenderHeightmap(t *testing.T) {
+	w := heightmapWorld()
+	region := NewRegion(0, 0, 1, 1)
+	buf := new(bytes.Buffer)
+	if err := w.RenderHeightmap(buf, region, HeightmapOptions{Missing: 7}); err != nil {
+		t.Fatal(err)
+	}
+	m := decodePNG(t, buf)
+	if b := m.Bounds(); b.Dx() != 32 || b.Dy() != 32 {
+		t.Fatal("expected a 32x32 heightmap, got ", b)
+	}
+	for _, p := range []struct {
+		px, py int
+		want   uint8
+	}{
+		{0, 0, 20},   // round(10*255/127)
+		{1, 0, 255},  // the top of the world
+		{2, 0, 0},    // empty
+		{18, 3, 129}, // round(64*255/127), from a scan
+		{0, 16, 7},   // missing
+	} {
+		if got := m.At(p.px, p.py); !sameColor(got, image.GrayColor{p.want}) {
+			t.Errorf("pixel (%d, %d): expected %d, got %v", p.px, p.py, p.want, got)
+		}
+	}
+
+	buf.Reset()
+	if err := w.RenderHeightmap(buf, region, HeightmapOptions{Wide: true, Missing: 65535}); err != nil {
+		t.Fatal(err)
+	}
+	m = decodePNG(t, buf)
+	for _, p := range []struct {
+		px, py int
+		want   uint16
+	}{
+		{0, 0, 5160}, // round(10*65535/127)
+		{1, 0, 65535},
+		{18, 3, 33026},
+		{0, 16, 65535},
+	} {
+		if got := m.At(p.px, p.py); !sameColor(got, image.Gray16Color{p.want}) {
+			t.Errorf("pixel (%d, %d): expected %d, got %v", p.px, p.py, p.want, got)
+		}
+	}
+
+	if err := w.RenderHeightmap(new(bytes.Buffer), region, HeightmapOptions{Missing: 256}); err == nil {
+		t.Error("expected an error for an 8-bit sentinel of 256")
+	}
+}
+
+func TestHeightValueDistinct(t *testing.T) {
+	for _, max := range []int{255, 65535} {
+		seen := make(map[int]bool)
+		for y := int32(0); y < ChunkHeight; y++ {
+			v := heightValue(y, max)
+			if seen[v] || v > max {
+				t.Fatalf("height %d maps to %d, taken or out of range", y, v)
+			}
+			seen[v] = true
+		}
+	}
+}
+
+func TestHeightmapTrustsValidHeightMap(t *testing.T) {
+	c := newChunk(0, 0)
+	c.Level.HeightMap[5+6*ChunkWidth] = 100
+	if h, err := surfaceHeight(c, 5, 6); err != nil || h != 99 {
+		t.Error("expected the stored height 99, got ", h, err)
+	}
+	c.SetBlock(5, 50, 6, BlockDirt, 0)
+	if h, err := surfaceHeight(c, 5, 6); err != nil || h != 50 {
+		t.Error("expected the scanned height 50, got ", h, err)
+	}
+}
+
+// gradient returns a 64x64 image growing lighter by 4 gray levels a pixel from
+// left to right.
+func gradient() image.Image {
+	m := image.NewGray(64, 64)
+	for px := 0; px < 64; px++ {
+		for py := 0; py < 64; py++ {
+			m.Set(px, py, image.GrayColor{uint8(px * 4)})
+		}
+	}
+	return m
+}
+
+func TestImportHeightmap(t *testing.T) {
+	payload := testChunkPayload(0, 0, nil, []interface{}{
+		tileEntityCompound("Chest", 5, 64, 5, map[string]interface{}{"Items": []interface{}{}}),
+	})
+	payload["Level"].(map[string]interface{})["Blocks"].([]byte)[blockIndex(5, 64, 5)] = BlockChest
+	dir := makeTestWorld(t, payload)
+	defer os.RemoveAll(dir)
+	w, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	// blocks -8 to 55 along x and 0 to 63 along z span chunks (-1, 0) to (3, 3)
+	if err = w.ImportHeightmap(gradient(), -8, 0, HeightmapImportOptions{WaterLevel: 10}); err != nil {
+		t.Fatal(err)
+	}
+	if len(w.Chunks) != 19 {
+		t.Error("expected every chunk but (0, 0) to be created, got ", len(w.Chunks))
+	}
+	if _, ok := w.Chunks[MakeXZ(0, 0)]; ok {
+		t.Error("expected chunk (0, 0), which exists, to be left alone")
+	}
+	for _, col := range []struct {
+		px  int32
+		h   int32 // round(px*4*127/255)
+		y   int32 // of the highest block
+		top byte
+		sky byte // just above the surface
+	}{
+		{0, 0, 10, BlockStillWater, 0},
+		{2, 4, 10, BlockStillWater, 0}, // under six blocks of water
+		{40, 80, 80, BlockGrass, 15},
+		{63, 126, 126, BlockGrass, 15},
+	} {
+		x, z := col.px-8, int32(20)
+		c := w.Chunks[MakeXZ(x>>4, z>>4)]
+		if c == nil || !c.Dirty() {
+			t.Errorf("column %d: expected its chunk to be resident and written", col.px)
+			continue
+		}
+		lx, lz := x&15, z&15
+		top, id, _ := c.highestBlockBelow(lx, ChunkHeight-1, lz)
+		if top != col.y || id != col.top {
+			t.Errorf("column %d: expected %d on top, got %d at %d", col.px, col.top, id, top)
+		}
+		if got, _ := surfaceHeight(c, lx, lz); got != top {
+			t.Errorf("column %d: expected the heightmap to reach %d, got %d", col.px, top, got)
+		}
+		base := blockIndex(lx, 0, lz)
+		want := []byte{BlockBedrock, BlockGrass, BlockDirt, BlockStone}
+		for i, y := range []int32{0, col.h, col.h - 1, col.h - dirtDepth - 1} {
+			if y <= 0 && i > 0 {
+				continue
+			}
+			if got := c.Level.Blocks[base+int(y)]; got != want[i] {
+				t.Errorf("column %d: expected %d at %d, got %d", col.px, want[i], y, got)
+			}
+		}
+		if got := getNibble(c.Level.SkyLight, base+int(col.h)+1); got != col.sky {
+			t.Errorf("column %d: expected sky light %d, got %d", col.px, col.sky, got)
+		}
+	}
+
+	opts := HeightmapImportOptions{Scale: 10, Offset: 60, Surface: BlockSand, Overwrite: true}
+	if err = w.ImportHeightmap(gradient(), -8, 0, opts); err != nil {
+		t.Fatal(err)
+	}
+	c := w.Chunks[MakeXZ(0, 0)]
+	if c == nil {
+		t.Fatal("expected chunk (0, 0) to be rebuilt")
+	}
+	// pixel 13 is gray 52, at 60 + 10*52/255, rounded
+	if y, id, _ := c.highestBlockBelow(5, ChunkHeight-1, 5); y != 62 || id != BlockSand {
+		t.Errorf("expected sand at 62, got %d at %d", id, y)
+	}
+	if len(c.Level.TileEntities) != 0 {
+		t.Error("expected the chest to go with its block, got ", c.Level.TileEntities)
+	}
+
+	if err = w.ImportHeightmap(gradient(), 0, 0, HeightmapImportOptions{WaterLevel: 128}); err == nil {
+		t.Error("expected an error for water above the world")
+	}
+}
@@ -0,0 +1,111 @@
+package world
+
+import "image"
+import "math"
+
+// maxRelief bounds how far hillshading moves a color toward white or black.
+const maxRelief = 0.5
+
+// hillshade shades the strips of a columnImage by the slope of the surface.
+// The heights of the last row of the strip above are kept, so that the columns
+// along the top of a strip are compared with their true northern neighbors.
+type hillshade struct {
+	strength float64
+	lightX   float64 // the direction toward the light, x growing east
+	lightZ   float64 // and z south
+	height   func(c *Chunk, x, z int32) int32
+
+	heights []int32 // the surface of the strip being drawn; -1 where there is none
+	above   []int32 // the surface of the last row of strip aboveZ
+	aboveZ  int32
+}
+
+// newHillshade returns a hillshade of the given strength, lit from azimuth
+// degrees clockwise from north, that finds the surface of a column with height.
+func newHillshade(strength, azimuth float64, height func(c *Chunk, x, z int32) int32) *hillshade {
+	a := azimuth * math.Pi / 180
+	// rounded, so that light from due north, say, has no part along x
+	round := func(v float64) float64 {
+		return math.Floor(v*1e9+0.5) / 1e9
+	}
+	return &hillshade{strength: strength, lightX: round(math.Sin(a)), lightZ: round(-math.Cos(a)), height: height}
+}
+
+// measure records the surface of chunk c, which is missing if nil, drawn at
+// column ox of a strip width columns wide.
+func (h *hillshade) measure(c *Chunk, ox, width int) {
+	if h.heights == nil {
+		h.heights = make([]int32, width*ChunkDepth)
+	}
+	for z := int32(0); z < ChunkDepth; z++ {
+		for x := int32(0); x < ChunkWidth; x++ {
+			y := int32(-1)
+			if c != nil {
+				y = h.height(c, x, z)
+			}
+			h.heights[ox+int(x)+int(z)*width] = y
+		}
+	}
+}
+
+// shade lightens or darkens each column of strip, chunk row cz, by its slope
+// toward the light.
+func (h *hillshade) shade(strip []image.Color, width int, cz int32) {
+	above := h.above
+	if above != nil && h.aboveZ != cz-1 {
+		above = nil
+	}
+	for z := 0; z < ChunkDepth; z++ {
+		for x := 0; x < width; x++ {
+			i := x + z*width
+			y := h.heights[i]
+			if y < 0 {
+				continue
+			}
+			west, north := int32(-1), int32(-1)
+			if x > 0 {
+				west = h.heights[i-1]
+			}
+			if z > 0 {
+				north = h.heights[i-width]
+			} else if above != nil {
+				north = above[x]
+			}
+			var dx, dz float64
+			if west >= 0 {
+				dx = float64(y - west)
+			}
+			if north >= 0 {
+				dz = float64(y - north)
+			}
+			if f := -h.strength * (dx*h.lightX + dz*h.lightZ); f != 0 {
+				strip[i] = relief(image.NRGBAColorModel.Convert(strip[i]).(image.NRGBAColor), f)
+			}
+		}
+	}
+	if h.above == nil {
+		h.above = make([]int32, width)
+	}
+	copy(h.above, h.heights[(ChunkDepth-1)*width:])
+	h.aboveZ = cz
+}
+
+// relief moves c toward white by f of the way if f is positive, and toward
+// black if it is negative, by at most maxRelief.  Transparent colors are kept.
+func relief(c image.NRGBAColor, f float64) image.NRGBAColor {
+	if c.A == 0 {
+		return c
+	}
+	if f > maxRelief {
+		f = maxRelief
+	} else if f < -maxRelief {
+		f = -maxRelief
+	}
+	g := func(v uint8) uint8 {
+		if f > 0 {
+			return uint8(float64(v) + float64(0xff-v)*f + 0.5)
+		}
+		return uint8(float64(v)*(1+f) + 0.5)
+	}
+	return image.NRGBAColor{g(c.R), g(c.G), g(c.B), c.A}
+}
@@ -0,0 +1,110 @@
+package world
+
+import "bytes"
+import "image"
+import "testing"
+
+// saddleWorld returns chunks (0, 0) to (1, 1) of stone shaped as a saddle: a
+// ridge running north to south along x = 16, falling a block for each block
+// east or west, and a valley running west to east along z = 16, rising a block
+// for each block north or south.
+func saddleWorld() *World {
+	abs := func(n int32) int32 {
+		if n < 0 {
+			return -n
+		}
+		return n
+	}
+	w := &World{Chunks: make(map[XZ]*Chunk)}
+	for cx := int32(0); cx < 2; cx++ {
+		for cz := int32(0); cz < 2; cz++ {
+			c := newChunk(cx, cz)
+			for x := int32(0); x < ChunkWidth; x++ {
+				for z := int32(0); z < ChunkDepth; z++ {
+					bx, bz := cx*ChunkWidth+x, cz*ChunkDepth+z
+					c.SetBlock(x, 80-abs(bx-16)+abs(bz-16), z, BlockStone, 0)
+				}
+			}
+			w.Chunks[MakeXZ(cx, cz)] = c
+		}
+	}
+	return w
+}
+
+func brightness(c image.Color) uint32 {
+	r, g, b, _ := c.RGBA()
+	return r + g + b
+}
+
+func TestRenderMapHillshade(t *testing.T) {
+	buf := new(bytes.Buffer)
+	opts := RenderOptions{Hillshade: 0.1, LightAzimuth: 270}
+	if err := saddleWorld().RenderMap(buf, NewRegion(0, 0, 1, 1), opts); err != nil {
+		t.Fatal(err)
+	}
+	m := decodePNG(t, buf)
+	stone := blockColors[BlockStone]
+	for _, p := range []struct {
+		px, py int
+		want   image.Color
+	}{
+		{0, 5, stone},                 // no neighbor to the west
+		{8, 5, relief(stone, 0.1)},    // rising toward the east, facing the light
+		{24, 5, relief(stone, -0.1)},  // falling toward the east
+		{16, 5, relief(stone, 0.1)},   // the ridge, against chunk (0, 0)
+		{17, 20, relief(stone, -0.1)}, // the far side of the ridge
+		{8, 16, relief(stone, 0.1)},   // the valley floor
+	} {
+		if got := m.At(p.px, p.py); !sameColor(got, p.want) {
+			t.Errorf("pixel (%d, %d): expected %v, got %v", p.px, p.py, p.want, got)
+		}
+	}
+	if brightness(m.At(8, 5)) <= brightness(stone) || brightness(m.At(24, 5)) >= brightness(stone) {
+		t.Error("expected opposing slopes lit and shaded")
+	}
+
+	// lit from the north, the valley's sides differ and its floor takes its
+	// northern neighbor from the strip of chunks above
+	buf.Reset()
+	opts.LightAzimuth = 0
+	if err := saddleWorld().RenderMap(buf, NewRegion(0, 0, 1, 1), opts); err != nil {
+		t.Fatal(err)
+	}
+	m = decodePNG(t, buf)
+	for _, p := range []struct {
+		px, py int
+		want   image.Color
+	}{
+		{5, 0, stone},                // no neighbor to the north
+		{5, 8, relief(stone, -0.1)},  // falling toward the south
+		{5, 24, relief(stone, 0.1)},  // rising toward the south
+		{5, 16, relief(stone, -0.1)}, // the valley floor, below chunk (0, 0)
+		{8, 5, relief(stone, -0.1)},  // the ridge's sides, across the light, alike
+		{24, 5, relief(stone, -0.1)},
+	} {
+		if got := m.At(p.px, p.py); !sameColor(got, p.want) {
+			t.Errorf("pixel (%d, %d): expected %v, got %v", p.px, p.py, p.want, got)
+		}
+	}
+
+	// strong slopes are bounded, and missing chunks stay transparent
+	buf.Reset()
+	w := saddleWorld()
+	w.Chunks[MakeXZ(1, 0)] = nil, false
+	if err := w.RenderMap(buf, NewRegion(0, 0, 1, 1), RenderOptions{Hillshade: 2}); err != nil {
+		t.Fatal(err)
+	}
+	m = decodePNG(t, buf)
+	if got, want := m.At(8, 5), relief(stone, -maxRelief); !sameColor(got, want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+	if got := m.At(20, 5); !sameColor(got, image.NRGBAColor{}) {
+		t.Error("expected a missing chunk to be transparent, got ", got)
+	}
+	if got := m.At(20, 16); !sameColor(got, stone) {
+		t.Error("expected no shading against a missing chunk, got ", got)
+	}
+	if err := w.RenderMap(buf, nil, RenderOptions{Hillshade: -1}); err == nil {
+		t.Error("expected an error for a negative strength")
+	}
+}
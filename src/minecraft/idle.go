@@ -0,0 +1,145 @@
+package world
+
+import "minecraft/error"
+
+import "bytes"
+import "compress/zlib"
+import "io"
+import "os"
+
+// arraysSize is how many bytes a chunk's five arrays take decoded.
+const arraysSize = chunkBlocks + 3*chunkNibbles + chunkColumns
+
+// ResidencyStats describe the chunks a World holds in memory.
+type ResidencyStats struct {
+	Resident   int   // chunks resident, compressed or not
+	Compressed int   // of those, the ones whose arrays are compressed
+	BytesSaved int64 // how much smaller the compressed arrays are than decoded
+}
+
+// touch records that c, a resident chunk, has just been used, for
+// CompressIdleChunks.
+func (world *World) touch(c *Chunk) {
+	if c == nil {
+		return
+	}
+	world.idleMu.Lock()
+	world.uses++
+	c.used = world.uses
+	world.idleMu.Unlock()
+}
+
+// idle reports whether c has gone unused for the last CompressIdleAfter uses of
+// resident chunks.
+func (world *World) idle(c *Chunk) bool {
+	world.idleMu.Lock()
+	defer world.idleMu.Unlock()
+	return world.uses-c.used >= int64(world.CompressIdleAfter)
+}
+
+// CompressIdleChunks compresses in memory the arrays of each resident chunk that
+// is clean and idle, as World.CompressIdleAfter describes, and returns how many
+// it compressed.  It empties the arrays of Level, so it must not be called while
+// other goroutines may be reading them, as the renderers do without a lock, and
+// as it takes each chunk's Lock, it must not be called while holding one.  A
+// good time is after a Flush, once nothing else is running.
+func (world *World) CompressIdleChunks() (n int, err os.Error) {
+	if world.CompressIdleAfter <= 0 {
+		return
+	}
+	for _, c := range world.residentChunks() {
+		if c == nil || !world.idle(c) {
+			continue
+		}
+		c.Lock()
+		var packed bool
+		if !c.dirty {
+			packed, err = c.compress()
+		}
+		c.Unlock()
+		if err != nil {
+			return n, error.InChunk(c.Level.XPos, c.Level.ZPos).Error("could not compress chunk", err)
+		}
+		if packed {
+			n++
+		}
+	}
+	return
+}
+
+// compress replaces the chunk's decoded arrays with their zlib compression,
+// which LoadArrays reverses, and reports whether it did.  Chunks whose arrays
+// are compressed already, or were never decoded, are left alone.
+func (c *Chunk) compress() (bool, os.Error) {
+	c.rawMu.Lock()
+	defer c.rawMu.Unlock()
+	if c.raw != nil || c.packed != nil || c.Level.Blocks == nil {
+		return false, nil
+	}
+	buf := new(bytes.Buffer)
+	zw, err := zlib.NewWriterLevel(buf, zlib.BestSpeed)
+	if err != nil {
+		return false, err
+	}
+	l := &c.Level
+	for _, b := range [][]byte{l.Blocks, l.Data, l.SkyLight, l.HeightMap, l.BlockLight} {
+		if _, err = zw.Write(b); err != nil {
+			return false, err
+		}
+	}
+	if err = zw.Close(); err != nil {
+		return false, err
+	}
+	// copied, so as not to keep the buffer's spare capacity
+	c.packed = append([]byte(nil), buf.Bytes()...)
+	l.Blocks, l.Data, l.SkyLight, l.HeightMap, l.BlockLight = nil, nil, nil, nil, nil
+	c.alloc = nil
+	return true, nil
+}
+
+// uncompress restores the arrays compress compressed.  rawMu must be held.
+func (c *Chunk) uncompress() os.Error {
+	zr, err := zlib.NewReader(bytes.NewBuffer(c.packed))
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+	var l Level
+	for _, array := range []struct {
+		dst  *[]byte
+		size int
+	}{
+		{&l.Blocks, chunkBlocks},
+		{&l.Data, chunkNibbles},
+		{&l.SkyLight, chunkNibbles},
+		{&l.HeightMap, chunkColumns},
+		{&l.BlockLight, chunkNibbles},
+	} {
+		*array.dst = make([]byte, array.size)
+		if _, err = io.ReadFull(zr, *array.dst); err != nil {
+			return err
+		}
+	}
+	c.Level.Blocks, c.Level.Data, c.Level.HeightMap = l.Blocks, l.Data, l.HeightMap
+	c.Level.SkyLight, c.Level.BlockLight = l.SkyLight, l.BlockLight
+	c.packed = nil
+	return nil
+}
+
+// ResidencyStats counts the resident chunks and those whose arrays are
+// compressed, for monitoring how much CompressIdleChunks saves.
+func (world *World) ResidencyStats() (stats ResidencyStats) {
+	for _, c := range world.residentChunks() {
+		stats.Resident++
+		if c == nil {
+			continue
+		}
+		c.rawMu.Lock()
+		if c.packed != nil {
+			stats.Compressed++
+			stats.BytesSaved += int64(arraysSize - len(c.packed))
+		}
+		c.rawMu.Unlock()
+	}
+	return
+}
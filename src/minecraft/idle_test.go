@@ -0,0 +1,134 @@
+package world
+
+import "os"
+import "runtime"
+import "testing"
+
+func TestCompressIdleChunks(t *testing.T) {
+	w := &World{Chunks: make(map[XZ]*Chunk), CompressIdleAfter: 3}
+	var chunks []*Chunk
+	for x := int32(0); x < 4; x++ {
+		c := newChunk(x, 0)
+		c.SetBlock(x, 64, 1, BlockStone, 2)
+		c.dirty = x == 3
+		chunks = append(chunks, w.keep(MakeXZ(x, 0), c))
+	}
+	if _, err := w.GetChunk(0, 0); err != nil {
+		t.Fatal(err)
+	}
+	// chunk 0 was just used, 2 only two uses ago, and 3 is dirty
+	if n, err := w.CompressIdleChunks(); err != nil || n != 1 {
+		t.Fatalf("expected 1 chunk compressed, got %d (%v)", n, err)
+	}
+	if chunks[1].Level.Blocks != nil || chunks[0].Level.Blocks == nil || chunks[3].Level.Blocks == nil {
+		t.Error("expected only chunk 1's arrays compressed")
+	}
+	stats := w.ResidencyStats()
+	if stats.Resident != 4 || stats.Compressed != 1 || stats.BytesSaved <= 0 || stats.BytesSaved >= arraysSize {
+		t.Error("unexpected stats ", stats)
+	}
+
+	if id, data, err := chunks[1].BlockAt(1, 64, 1); err != nil || id != BlockStone || data != 2 {
+		t.Errorf("expected stone:2, got %d:%d (%v)", id, data, err)
+	}
+	if len(chunks[1].Level.SkyLight) != chunkNibbles || len(chunks[1].Level.HeightMap) != chunkColumns {
+		t.Error("expected the arrays restored")
+	}
+	if stats = w.ResidencyStats(); stats.Compressed != 0 || stats.BytesSaved != 0 {
+		t.Error("expected nothing compressed, got ", stats)
+	}
+
+	w.CompressIdleAfter = 0
+	for i := 0; i < 10; i++ {
+		w.GetChunk(0, 0)
+	}
+	if n, _ := w.CompressIdleChunks(); n != 0 {
+		t.Error("expected no compression when disabled, got ", n)
+	}
+}
+
+func TestCompressAfterFlush(t *testing.T) {
+	dir := makeTestWorld(t, testChunkPayload(0, 0, nil, nil), testChunkPayload(1, 0, nil, nil))
+	defer os.RemoveAll(dir)
+	w, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	w.CompressIdleAfter = 1
+	a, err := w.GetChunk(0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := w.GetChunk(1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.SetBlock(5, 10, 5, BlockGlass, 0)
+	if err = w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	// renderers may be reading the arrays, so Flush leaves them be
+	if stats := w.ResidencyStats(); stats.Compressed != 0 || a.Level.Blocks == nil {
+		t.Error("expected Flush to compress nothing, got ", stats)
+	}
+	if n, err := w.CompressIdleChunks(); err != nil || n != 1 {
+		t.Errorf("expected 1 chunk compressed, got %d (%v)", n, err)
+	}
+	if stats := w.ResidencyStats(); stats.Compressed != 1 || a.Level.Blocks != nil || b.Level.Blocks == nil {
+		t.Error("expected the written chunk compressed once clean, got ", stats)
+	}
+
+	w.Chunks = make(map[XZ]*Chunk)
+	if id, _, err := w.BlockAt(5, 10, 5); err != nil || id != BlockGlass {
+		t.Errorf("expected glass on disk, got %d (%v)", id, err)
+	}
+}
+
+// TestCompressIdleChunksMemory keeps 2,000 idle chunks of stone, dirt and air
+// resident and checks that compressing them frees most of their arrays.
+func TestCompressIdleChunksMemory(t *testing.T) {
+	column := make([]byte, ChunkHeight)
+	for y := range column {
+		switch {
+		case y < 60:
+			column[y] = BlockStone
+		case y < 64:
+			column[y] = BlockDirt
+		}
+	}
+	w := &World{Chunks: make(map[XZ]*Chunk), CompressIdleAfter: 1}
+	for x := int32(0); x < 50; x++ {
+		for z := int32(0); z < 40; z++ {
+			c := newChunk(x, z)
+			for i := 0; i < chunkBlocks; i += ChunkHeight {
+				copy(c.Level.Blocks[i:], column)
+			}
+			w.keep(MakeXZ(x, z), c)
+		}
+	}
+	w.GetChunk(0, 0)
+	runtime.GC()
+	runtime.UpdateMemStats()
+	before := runtime.MemStats.HeapAlloc
+
+	n, err := w.CompressIdleChunks()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1999 {
+		t.Errorf("expected 1999 chunks compressed, got %d", n)
+	}
+	runtime.GC()
+	runtime.UpdateMemStats()
+	after := runtime.MemStats.HeapAlloc
+	stats := w.ResidencyStats()
+	t.Logf("heap %d KiB before, %d KiB after; %d KiB saved by %d of %d chunks",
+		before/1024, after/1024, stats.BytesSaved/1024, stats.Compressed, stats.Resident)
+	if decoded := uint64(n * arraysSize); after > before || before-after < decoded*3/4 {
+		t.Errorf("expected at least %d KiB freed, got %d KiB before and %d KiB after", decoded*3/4/1024, before/1024, after/1024)
+	}
+	if stats.BytesSaved < int64(n*arraysSize)*9/10 {
+		t.Error("expected over 90% saved, got ", stats.BytesSaved)
+	}
+}
@@ -0,0 +1,109 @@
+package world
+
+import "io/ioutil"
+import "path"
+import "strings"
+import "time"
+
+// A Format is a layout of a world's chunks on disk.
+type Format int
+
+const (
+	FormatAlpha    Format = iota // a file for each chunk, in directories by its coordinates
+	FormatMcRegion               // region/r.<x>.<z>.mcr files of 32 by 32 chunks
+	FormatAnvil                  // region/r.<x>.<z>.mca files, as McRegion's but taller
+)
+
+var formatNames = []string{"alpha", "mcregion", "anvil"}
+
+func (f Format) String() string {
+	return formatNames[f]
+}
+
+// regiondir is the directory of the region files of the later formats.
+const regiondir = "region"
+
+// detectFormat tells the format of the world in dir by its region files: Anvil
+// if any end in .mca, McRegion if any in .mcr, and Alpha otherwise.  Worlds the
+// game has converted keep the files of the formats before, so the latest found
+// is the one the game uses.
+func detectFormat(dir string) Format {
+	files, err := ioutil.ReadDir(path.Join(dir, regiondir))
+	if err != nil {
+		return FormatAlpha
+	}
+	format := FormatAlpha
+	for _, f := range files {
+		switch {
+		case !f.IsRegular():
+		case strings.HasSuffix(f.Name, ".mca"):
+			return FormatAnvil
+		case strings.HasSuffix(f.Name, ".mcr"):
+			format = FormatMcRegion
+		}
+	}
+	return format
+}
+
+// Dir returns the directory the World was opened in.
+func (world *World) Dir() string {
+	return world.dir
+}
+
+// Format returns the format Open found the world's chunks in, and so the
+// ChunkStore it chose for them unless given one.  Alpha and McRegion chunks are
+// decoded alike; the chunks of FormatAnvil are stored and listed, but do not
+// decode.
+func (world *World) Format() Format {
+	return world.format
+}
+
+// IsReadOnly reports whether the World was opened ReadOnly.
+func (world *World) IsReadOnly() bool {
+	return world.config.ReadOnly
+}
+
+// IsLocked reports whether the World holds the world's session lock: whether
+// it took the lock, has not been closed, and no other process has opened the
+// world since, which it checks by reading the lock as VerifyLockNow does.
+func (world *World) IsLocked() bool {
+	world.lockMu.Lock()
+	defer world.lockMu.Unlock()
+	return world.lockfd != nil && world.readLock(time.Nanoseconds()) == nil
+}
+
+// LockTimestamp returns the time, in milliseconds since 1970, the World wrote to
+// the session lock on taking it, which another process opening the world
+// overwrites; zero if it was opened ReadOnly.
+func (world *World) LockTimestamp() int64 {
+	return world.lockmsec
+}
+
+// LoadedChunkCount returns how many chunks are resident.
+func (world *World) LoadedChunkCount() int {
+	world.mu.RLock()
+	defer world.mu.RUnlock()
+	return len(world.Chunks)
+}
+
+// DirtyChunkCount returns how many resident chunks have changes not yet
+// written.  As it takes each chunk's RLock, it must not be called while holding
+// a chunk's Lock.
+func (world *World) DirtyChunkCount() int {
+	return world.dirtyChunks()
+}
+
+// Seed returns Data.RandomSeed, holding LockData to read it.
+func (world *World) Seed() int64 {
+	world.dataMu.Lock()
+	defer world.dataMu.Unlock()
+	return world.Data.RandomSeed
+}
+
+// Spawn returns the block coordinates of Data's spawn point, holding LockData
+// to read them.
+func (world *World) Spawn() (x, y, z int32) {
+	world.dataMu.Lock()
+	defer world.dataMu.Unlock()
+	return world.Data.SpawnX, world.Data.SpawnY, world.Data.SpawnZ
+}
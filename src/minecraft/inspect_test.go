@@ -0,0 +1,149 @@
+package world
+
+import "minecraft/nbt"
+
+import "io/ioutil"
+import "os"
+import "path"
+import "testing"
+
+func TestAccessors(t *testing.T) {
+	dir := makeTestWorld(t, testChunkPayload(0, 0, nil, nil), testChunkPayload(1, 0, nil, nil))
+	defer os.RemoveAll(dir)
+	w, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	if w.Dir() != dir || w.Format() != FormatAlpha || w.IsReadOnly() || !w.IsLocked() {
+		t.Errorf("expected %s, alpha, writable and locked, got %s, %v, %v and %v", dir, w.Dir(), w.Format(), w.IsReadOnly(), w.IsLocked())
+	}
+	f, err := os.Open(path.Join(dir, sessionlock), os.O_RDONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msec, err := nbt.ReadInt64(f)
+	f.Close()
+	if err != nil || msec != w.LockTimestamp() || msec == 0 {
+		t.Errorf("expected the timestamp written, %d, got %d", msec, w.LockTimestamp())
+	}
+	if w.Seed() != 42 {
+		t.Errorf("expected seed 42, got %d", w.Seed())
+	}
+	if x, y, z := w.Spawn(); x != 8 || y != 64 || z != 8 {
+		t.Errorf("expected spawn (8, 64, 8), got (%d, %d, %d)", x, y, z)
+	}
+	w.LockData()
+	w.Data.RandomSeed, w.Data.SpawnY = -1, 70
+	w.UnlockData()
+	if _, y, _ := w.Spawn(); w.Seed() != -1 || y != 70 {
+		t.Error("expected Seed and Spawn to follow Data")
+	}
+
+	if w.LoadedChunkCount() != 0 || w.DirtyChunkCount() != 0 {
+		t.Error("expected no chunks resident")
+	}
+	c, err := w.GetChunk(0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = w.GetChunk(1, 0); err != nil {
+		t.Fatal(err)
+	}
+	c.SetBlock(1, 64, 1, BlockStone, 0)
+	if w.LoadedChunkCount() != 2 || w.DirtyChunkCount() != 1 {
+		t.Errorf("expected 2 chunks resident and 1 dirty, got %d and %d", w.LoadedChunkCount(), w.DirtyChunkCount())
+	}
+	if err = w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if w.LoadedChunkCount() != 2 || w.DirtyChunkCount() != 0 {
+		t.Errorf("expected 2 chunks resident and none dirty once flushed, got %d and %d", w.LoadedChunkCount(), w.DirtyChunkCount())
+	}
+
+	// another process opening the world takes the lock, but not the rest
+	stamp := w.LockTimestamp()
+	if err = ioutil.WriteFile(path.Join(dir, sessionlock), make([]byte, 8), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if w.IsLocked() || w.IsReadOnly() {
+		t.Error("expected the lock lost, and the world still not read-only")
+	}
+	if w.LockTimestamp() != stamp || w.LoadedChunkCount() != 2 || w.Seed() != -1 {
+		t.Error("expected the timestamp, chunks and Data kept when the lock is lost")
+	}
+	c.SetBlock(1, 64, 1, BlockGlass, 0)
+	if err = w.Flush(); !IsError(err, ErrLockLost) || w.DirtyChunkCount() != 1 {
+		t.Errorf("expected the chunk left dirty by a flush without the lock, got %v", err)
+	}
+
+	w.Close()
+	if w.IsLocked() {
+		t.Error("expected no lock held once closed")
+	}
+	if err = w.VerifyLockNow(); !IsError(err, ErrLockLost) {
+		t.Error("expected ErrLockLost once closed, got ", err)
+	}
+}
+
+func TestAccessorsReadOnly(t *testing.T) {
+	dir := makeTestWorld(t, testChunkPayload(0, 0, nil, nil))
+	defer os.RemoveAll(dir)
+	w, err := Open(dir, ReadOnly())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	if !w.IsReadOnly() || w.IsLocked() || w.LockTimestamp() != 0 {
+		t.Errorf("expected read-only, unlocked and no timestamp, got %v, %v and %d", w.IsReadOnly(), w.IsLocked(), w.LockTimestamp())
+	}
+	c, err := w.GetChunk(0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.SetBlock(1, 64, 1, BlockStone, 0)
+	if w.LoadedChunkCount() != 1 || w.DirtyChunkCount() != 1 {
+		t.Errorf("expected a dirty chunk resident, got %d and %d", w.LoadedChunkCount(), w.DirtyChunkCount())
+	}
+	if w.Seed() != 42 || w.Dir() != dir {
+		t.Error("expected Seed and Dir as for a writable world")
+	}
+	w.Close()
+	if !w.IsReadOnly() || w.IsLocked() {
+		t.Error("expected read-only and unlocked once closed")
+	}
+}
+
+func TestFormat(t *testing.T) {
+	dir := makeTestWorld(t)
+	defer os.RemoveAll(dir)
+	regions := path.Join(dir, regiondir)
+	if err := os.MkdirAll(regions, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct {
+		file   string
+		format Format
+		name   string
+	}{
+		{"", FormatAlpha, "alpha"},
+		{"r.0.0.mcr", FormatMcRegion, "mcregion"},
+		{"r.0.0.mca", FormatAnvil, "anvil"},
+	} {
+		if c.file != "" {
+			if err := ioutil.WriteFile(path.Join(regions, c.file), nil, 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		w, err := Open(dir, ReadOnly())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if w.Format() != c.format || w.Format().String() != c.name {
+			t.Errorf("with %q: expected %s, got %s", c.file, c.name, w.Format())
+		}
+		w.Close()
+	}
+}
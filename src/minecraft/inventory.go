@@ -0,0 +1,41 @@
+package world
+
+// Slot bookkeeping shared by every container.  Containers store only their
+// occupied slots, ordered by slot number.
+
+// ChestSlots is the number of slots in a chest or storage minecart.
+const ChestSlots = 27
+
+// findSlot returns the index in slots of slot number n, or -1.
+func findSlot(slots []InventorySlot, n int8) int {
+	for i, s := range slots {
+		if s.Slot == n {
+			return i
+		}
+	}
+	return -1
+}
+
+// putSlot stores item in slot number n, replacing whatever was there.
+func putSlot(slots []InventorySlot, n int8, item Item) []InventorySlot {
+	if i := findSlot(slots, n); i >= 0 {
+		slots[i].Item = item
+		return slots
+	}
+	i := 0
+	for i < len(slots) && slots[i].Slot < n {
+		i++
+	}
+	slots = append(slots, InventorySlot{})
+	copy(slots[i+1:], slots[i:])
+	slots[i] = InventorySlot{n, item}
+	return slots
+}
+
+// takeSlot empties slot number n.
+func takeSlot(slots []InventorySlot, n int8) []InventorySlot {
+	if i := findSlot(slots, n); i >= 0 {
+		slots = append(slots[:i], slots[i+1:]...)
+	}
+	return slots
+}
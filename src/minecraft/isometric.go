@@ -0,0 +1,128 @@
+package world
+
+import "minecraft/error"
+
+import "fmt"
+import "image"
+import "image/png"
+import "io"
+import "os"
+
+// RenderIsometric writes a PNG of region (nil meaning every chunk in the world)
+// in the dimetric projection, with x running down to the right and z down to the
+// left, so that the viewer looks at the region from above its corner of greatest
+// x and z.  Each block is a sprite four pixels wide and four tall: a diamond of
+// its top face over its +z face on the left and its +x face on the right, shaded
+// darker.  Blocks are colored as on RenderMap, and only those between opts.MinY
+// and opts.MaxY are drawn, so that a MaxY below the surface cuts the terrain
+// away.  Pixels not covered by a block are transparent.
+//
+// For a region of w by d chunks, and n = opts.MaxY - opts.MinY + 1 layers, the
+// image is 2*16*(w+d) pixels wide and 16*(w+d) + 2*n tall, each multiplied by
+// opts.Scale.  The top corner of block (x, y, z) is at pixel
+// (2*(bx-bz) + 2*16*d - 2, bx + bz + 2*(opts.MaxY-y)), before scaling, where
+// (bx, bz) is its offset from the region's corner of least x and z.
+//
+// Unlike RenderMap, every chunk of the region is held in memory while drawing.
+func (world *World) RenderIsometric(w io.Writer, region *Region, opts RenderOptions) os.Error {
+	minY, maxY, err := opts.yRange()
+	if err != nil {
+		return err
+	}
+	if opts.Scale < 0 {
+		return error.NewError(fmt.Sprintf("cannot render at scale %d", opts.Scale), nil)
+	}
+	if region, err = world.extent(region); err != nil {
+		return err
+	}
+	if opts.Scale == 0 {
+		opts.Scale = 1
+	}
+	t := world.begin("RenderIsometric", int(region.Width()*region.Depth()))
+	v, err := world.loadVolume(region, minY, maxY, t)
+	if err != nil {
+		return t.end(err)
+	}
+	s := &isoScene{blockVolume: v, scale: opts.Scale, colors: opts.colors()}
+	s.m = image.NewNRGBA(2*(s.width+s.depth)*s.scale, (s.width+s.depth+2*int(maxY-minY+1))*s.scale)
+	s.draw()
+	if err = png.Encode(w, s.m); err != nil {
+		return t.end(error.NewError("could not write PNG", err))
+	}
+	return t.end(nil)
+}
+
+// isoScene is a region being drawn by RenderIsometric.
+type isoScene struct {
+	*blockVolume
+	scale  int
+	colors *ColorTable
+	m      *image.NRGBA
+}
+
+// draw paints the scene back to front: diagonals of columns in order of
+// increasing bx+bz, and each diagonal from the bottom up, so that nearer and
+// higher blocks are painted over those they hide.  A block whose top, +x and +z
+// neighbours all hide it is skipped, as none of it would show.
+func (s *isoScene) draw() {
+	for d := 0; d < s.width+s.depth-1; d++ {
+		for y := s.minY; y <= s.maxY; y++ {
+			for bx := 0; bx <= d; bx++ {
+				bz := d - bx
+				if bx >= s.width || bz >= s.depth {
+					continue
+				}
+				id := s.block(bx, y, bz)
+				if id == BlockAir {
+					continue
+				}
+				if hides(s.block(bx, y+1, bz), id) && hides(s.block(bx+1, y, bz), id) && hides(s.block(bx, y, bz+1), id) {
+					continue
+				}
+				s.drawBlock(2*(bx-bz)+2*s.depth-2, d+2*int(s.maxY-y), s.colors.Color(id, s.data(bx, y, bz)))
+			}
+		}
+	}
+}
+
+// drawBlock paints a block sprite with its top left corner at (px, py):
+//
+//	.TT.
+//	TTTT
+//	LLRR
+//	LLRR
+func (s *isoScene) drawBlock(px, py int, c image.NRGBAColor) {
+	top, left, right := c, shade(c, 4, 5), shade(c, 3, 5)
+	s.paint(px+1, py, top)
+	s.paint(px+2, py, top)
+	for i := 0; i < 4; i++ {
+		s.paint(px+i, py+1, top)
+	}
+	for j := 2; j < 4; j++ {
+		s.paint(px, py+j, left)
+		s.paint(px+1, py+j, left)
+		s.paint(px+2, py+j, right)
+		s.paint(px+3, py+j, right)
+	}
+}
+
+// paint draws c over pixel (px, py), which is scale pixels square.
+func (s *isoScene) paint(px, py int, c image.NRGBAColor) {
+	for y := py * s.scale; y < (py+1)*s.scale; y++ {
+		for x := px * s.scale; x < (px+1)*s.scale; x++ {
+			p := c
+			if under := s.m.At(x, y).(image.NRGBAColor); c.A != 0xff && under.A != 0 {
+				p = over(c, under)
+			}
+			s.m.SetNRGBA(x, y, p)
+		}
+	}
+}
+
+// shade darkens c to num/den of its brightness.
+func shade(c image.NRGBAColor, num, den int) image.NRGBAColor {
+	f := func(v uint8) uint8 {
+		return uint8(int(v) * num / den)
+	}
+	return image.NRGBAColor{f(c.R), f(c.G), f(c.B), c.A}
+}
@@ -0,0 +1,120 @@
+package world
+
+import "bytes"
+import "image"
+import "image/png"
+import "os"
+import "testing"
+
+// isoWorld returns a scene in chunk (0, 0): a gold block at (0, 60, 0), a 4x4
+// platform of grass at y=60 with a pillar of stone three high on it at (4, 2),
+// and a pool of water two deep over sand.
+func isoWorld() *World {
+	w := &World{Chunks: make(map[XZ]*Chunk)}
+	c := newChunk(0, 0)
+	c.SetBlock(0, 60, 0, 41, 0)
+	for x := int32(2); x < 6; x++ {
+		for z := int32(2); z < 6; z++ {
+			c.SetBlock(x, 60, z, BlockGrass, 0)
+		}
+	}
+	for y := int32(61); y < 64; y++ {
+		c.SetBlock(4, y, 2, BlockStone, 0)
+	}
+	for x := int32(8); x < 11; x++ {
+		for z := int32(8); z < 10; z++ {
+			c.SetBlock(x, 59, z, BlockSand, 0)
+			c.SetBlock(x, 60, z, BlockStillWater, 0)
+			c.SetBlock(x, 61, z, BlockStillWater, 0)
+		}
+	}
+	w.Chunks[MakeXZ(0, 0)] = c
+	return w
+}
+
+func TestRenderIsometric(t *testing.T) {
+	buf := new(bytes.Buffer)
+	if err := isoWorld().RenderIsometric(buf, NewRegion(0, 0, 0, 0), RenderOptions{MinY: 58, MaxY: 65}); err != nil {
+		t.Fatal(err)
+	}
+	m := decodePNG(t, buf)
+	// 2*16*(1+1) by 16*(1+1) + 2*8
+	if b := m.Bounds(); b.Dx() != 64 || b.Dy() != 48 {
+		t.Fatal("expected a 64x48 image, got ", b)
+	}
+	// the gold block's top corner is at (2*(0-0) + 2*16 - 2, 0 + 2*(65-60))
+	gold := blockColors[41]
+	for _, p := range []struct {
+		px, py int
+		want   image.Color
+	}{
+		{31, 10, gold},
+		{30, 11, gold},
+		{30, 12, shade(gold, 4, 5)},
+		{33, 13, shade(gold, 3, 5)},
+		{29, 10, image.NRGBAColor{}},
+		{34, 10, image.NRGBAColor{}},
+	} {
+		if got := m.At(p.px, p.py); !sameColor(got, p.want) {
+			t.Errorf("pixel (%d, %d): expected %v, got %v", p.px, p.py, p.want, got)
+		}
+	}
+
+	f, err := os.Open("testdata/isometric.png", os.O_RDONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	golden, err := png.Decode(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b := golden.Bounds(); b.Dx() != 64 || b.Dy() != 48 {
+		t.Fatal("expected testdata/isometric.png to be 64x48, got ", b)
+	}
+	for py := 0; py < 48; py++ {
+		for px := 0; px < 64; px++ {
+			if got, want := m.At(px, py), golden.At(px, py); !sameColor(got, want) {
+				t.Fatalf("pixel (%d, %d): expected %v, got %v", px, py, want, got)
+			}
+		}
+	}
+}
+
+func TestRenderIsometricScale(t *testing.T) {
+	buf := new(bytes.Buffer)
+	if err := isoWorld().RenderIsometric(buf, NewRegion(0, 0, 0, 0), RenderOptions{Scale: 3, MinY: 60, MaxY: 60}); err != nil {
+		t.Fatal(err)
+	}
+	m := decodePNG(t, buf)
+	if b := m.Bounds(); b.Dx() != 192 || b.Dy() != 102 {
+		t.Fatal("expected a 192x102 image, got ", b)
+	}
+	// the gold block's top corner, (31, 0) at scale 1
+	for _, p := range []image.Point{{93, 0}, {95, 2}} {
+		if got := m.At(p.X, p.Y); !sameColor(got, blockColors[41]) {
+			t.Errorf("pixel %v: expected gold, got %v", p, got)
+		}
+	}
+	if err := isoWorld().RenderIsometric(new(bytes.Buffer), NewRegion(0, 0, 0, 0), RenderOptions{Scale: -2}); err == nil {
+		t.Error("expected an error for a negative scale")
+	}
+}
+
+func TestIsometricHidden(t *testing.T) {
+	c := newChunk(0, 0)
+	for x := int32(0); x < 3; x++ {
+		for y := int32(0); y < 3; y++ {
+			for z := int32(0); z < 3; z++ {
+				c.SetBlock(x, y, z, BlockStone, 0)
+			}
+		}
+	}
+	s := &blockVolume{region: NewRegion(0, 0, 0, 0), chunks: map[XZ]*Chunk{MakeXZ(0, 0): c}, width: 16, depth: 16, maxY: 127}
+	if !hides(s.block(1, 2, 1), BlockStone) || hides(s.block(1, 3, 1), BlockStone) {
+		t.Error("expected the cube's blocks to hide one another and the air above not to")
+	}
+	if !hides(BlockStillWater, BlockStillWater) || hides(BlockWater, BlockStone) {
+		t.Error("expected water to hide only water")
+	}
+}
@@ -0,0 +1,53 @@
+package world
+
+// see: http://www.minecraftwiki.net/wiki/Data_values#Item_IDs
+
+// MaxStackSize is the number of items of a kind that fit in one slot.
+func MaxStackSize(id int16) int {
+	if id >= 0 && int(id) < len(stackSizes) && stackSizes[id] != 0 {
+		return int(stackSizes[id])
+	}
+	if id >= 2256 {
+		return 1 // records
+	}
+	return 64
+}
+
+var stackSizes [512]int8
+
+func init() {
+	for _, id := range []int16{
+		256, 257, 258, 259, // iron shovel, pickaxe, axe, flint and steel
+		261,                     // bow
+		267, 268, 269, 270, 271, // iron sword; wooden sword, shovel, pickaxe, axe
+		272, 273, 274, 275, // stone sword, shovel, pickaxe, axe
+		276, 277, 278, 279, // diamond sword, shovel, pickaxe, axe
+		282,                // mushroom soup
+		283, 284, 285, 286, // gold sword, shovel, pickaxe, axe
+		290, 291, 292, 293, 294, // hoes
+		298, 299, 300, 301, // leather armor
+		302, 303, 304, 305, // chainmail armor
+		306, 307, 308, 309, // iron armor
+		310, 311, 312, 313, // diamond armor
+		314, 315, 316, 317, // gold armor
+		319, 320, // raw and cooked porkchop
+		322,           // golden apple
+		323,           // sign
+		324,           // wooden door
+		325, 326, 327, // buckets
+		328, 329, 330, // minecart, saddle, iron door
+		333, 335, // boat, milk
+		342, 343, // storage and powered minecarts
+		346, // fishing rod
+	} {
+		stackSizes[id] = 1
+	}
+	stackSizes[332] = 16 // snowball
+	stackSizes[344] = 16 // egg
+}
+
+// stackable reports whether two stacks may be merged.  Stacks carrying tags
+// we do not model never are.
+func stackable(a, b Item) bool {
+	return a.Id == b.Id && a.Damage == b.Damage && len(a.Extra) == 0 && len(b.Extra) == 0
+}
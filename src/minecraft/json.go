@@ -0,0 +1,723 @@
+package world
+
+import "minecraft/error"
+
+import "bytes"
+import "fmt"
+import "io"
+import "json"
+import "math"
+import "os"
+import "sort"
+import "strconv"
+
+// JSON encoding of entities and tile entities, for tools that do not speak NBT.
+//
+// An entity is an object with these members, in this order:
+//
+//	"id"        its id, such as "Pig"
+//	"pos"       [x, y, z]
+//	"motion"    [dx, dy, dz]
+//	"rotation"  [yaw, pitch] in degrees
+//	"health"    if it has health
+//	"item"      the stack, for a dropped item
+//	"items"     the occupied slots, for a storage minecart
+//	"extra"     every other tag, such as "Fire" or a vehicle's "Riding"
+//
+// A tile entity has "id" and its block's "x", "y" and "z", then by kind: "items"
+// for a chest; "burnTime", "cookTime" and "items" for a furnace; "text1" to
+// "text4" for a sign; "entityId" and "delay" for a spawner; then "extra".  An item
+// is "slot" when in an inventory, then "id", "count", "damage" and "extra".
+//
+// "extra" is left out when empty.  Each of its members is an object with a single
+// member naming the tag's type: {"byte": 1}, {"short": 1}, {"int": 1},
+// {"long": "1"}, {"float": 0.5}, {"double": 0.5}, {"bytes": [1, 2]},
+// {"string": "s"}, {"list": [tag, ...]} or {"compound": {"name": tag, ...}}.
+// Longs are strings because a JSON number cannot hold every long.  A documented
+// member whose tag has an unexpected type is written in "extra" instead.
+//
+// Numbers are written in the shortest form that reads back as the same value, so
+// positions survive a round trip exactly.
+
+type jsonKind int
+
+const (
+	jsonString jsonKind = iota
+	jsonByte
+	jsonShort
+	jsonInt
+	jsonDoubles // a list of doubles
+	jsonFloats  // a list of floats
+	jsonItem    // an item compound
+	jsonItems   // a list of item compounds
+)
+
+// jsonMember maps a member of an object's JSON encoding to the tag it holds.
+type jsonMember struct {
+	name, tag string
+	kind      jsonKind
+}
+
+var entityMembers = []jsonMember{
+	{"id", "id", jsonString},
+	{"pos", "Pos", jsonDoubles},
+	{"motion", "Motion", jsonDoubles},
+	{"rotation", "Rotation", jsonFloats},
+	{"health", "Health", jsonShort},
+	{"item", "Item", jsonItem},
+	{"items", "Items", jsonItems},
+}
+
+var itemMembers = []jsonMember{
+	{"slot", "Slot", jsonByte},
+	{"id", "id", jsonShort},
+	{"count", "Count", jsonByte},
+	{"damage", "Damage", jsonShort},
+}
+
+var tileEntityMembers = []jsonMember{
+	{"id", "id", jsonString},
+	{"x", "x", jsonInt},
+	{"y", "y", jsonInt},
+	{"z", "z", jsonInt},
+}
+
+// typedTileEntityMembers lists, by id, the further members of each typed tile
+// entity.
+var typedTileEntityMembers = map[string][]jsonMember{
+	"Chest": []jsonMember{
+		{"items", "Items", jsonItems},
+	},
+	"Furnace": []jsonMember{
+		{"burnTime", "BurnTime", jsonShort},
+		{"cookTime", "CookTime", jsonShort},
+		{"items", "Items", jsonItems},
+	},
+	"Sign": []jsonMember{
+		{"text1", "Text1", jsonString},
+		{"text2", "Text2", jsonString},
+		{"text3", "Text3", jsonString},
+		{"text4", "Text4", jsonString},
+	},
+	"MobSpawner": []jsonMember{
+		{"entityId", "EntityId", jsonString},
+		{"delay", "Delay", jsonShort},
+	},
+}
+
+// MarshalJSON encodes e as described at the top of this file.
+func (e *Entity) MarshalJSON() ([]byte, os.Error) {
+	return marshalJSON(fromEntity(e), entityMembers)
+}
+
+// UnmarshalJSON replaces e with the entity data encodes.  An entity the game would
+// not expect, such as a painting with an unknown motive, is an error.
+func (e *Entity) UnmarshalJSON(data []byte) os.Error {
+	c, err := unmarshalJSON(data, entityMembers)
+	var decoded *Entity
+	if err == nil {
+		decoded, err = toEntity(c)
+	}
+	if err != nil {
+		return error.NewError("could not decode entity", err)
+	}
+	if err = decoded.validate(); err != nil {
+		return err
+	}
+	*e = *decoded
+	return nil
+}
+
+func (chest *Chest) MarshalJSON() ([]byte, os.Error) {
+	return marshalTileEntity(chest)
+}
+
+func (furnace *Furnace) MarshalJSON() ([]byte, os.Error) {
+	return marshalTileEntity(furnace)
+}
+
+func (sign *Sign) MarshalJSON() ([]byte, os.Error) {
+	return marshalTileEntity(sign)
+}
+
+func (spawner *MobSpawner) MarshalJSON() ([]byte, os.Error) {
+	return marshalTileEntity(spawner)
+}
+
+// UnmarshalJSON replaces the chest with the one data encodes, which must be a
+// chest.  A chest in a chunk stays there and dirties it.
+func (chest *Chest) UnmarshalJSON(data []byte) os.Error {
+	te, err := unmarshalTileEntity(data, "Chest", chest)
+	if err == nil {
+		*chest = *te.(*Chest)
+	}
+	return err
+}
+
+func (furnace *Furnace) UnmarshalJSON(data []byte) os.Error {
+	te, err := unmarshalTileEntity(data, "Furnace", furnace)
+	if err == nil {
+		*furnace = *te.(*Furnace)
+	}
+	return err
+}
+
+func (sign *Sign) UnmarshalJSON(data []byte) os.Error {
+	te, err := unmarshalTileEntity(data, "Sign", sign)
+	if err == nil {
+		*sign = *te.(*Sign)
+	}
+	return err
+}
+
+func (spawner *MobSpawner) UnmarshalJSON(data []byte) os.Error {
+	te, err := unmarshalTileEntity(data, "MobSpawner", spawner)
+	if err == nil {
+		*spawner = *te.(*MobSpawner)
+	}
+	return err
+}
+
+func tileEntityJSONMembers(id string) []jsonMember {
+	members := make([]jsonMember, len(tileEntityMembers), len(tileEntityMembers)+4)
+	copy(members, tileEntityMembers)
+	return append(members, typedTileEntityMembers[id]...)
+}
+
+func marshalTileEntity(te TileEntity) ([]byte, os.Error) {
+	return marshalJSON(te.toCompound(), tileEntityJSONMembers(te.Id()))
+}
+
+// unmarshalTileEntity decodes data as a tile entity with the given id to replace
+// old, keeping old's place in its chunk.
+func unmarshalTileEntity(data []byte, id string, old TileEntity) (te TileEntity, err os.Error) {
+	c, err := unmarshalJSON(data, tileEntityJSONMembers(id))
+	if err == nil {
+		te, err = toTileEntity(c)
+	}
+	if err == nil && te.Id() != id {
+		err = error.NewError(fmt.Sprintf("expected id %q, got %q", id, te.Id()), nil)
+	}
+	if err != nil {
+		return nil, error.NewError("could not decode "+id, err)
+	}
+	base := te.tileEntityBase()
+	base.chunk = old.tileEntityBase().chunk
+	base.touch()
+	return
+}
+
+// marshalJSON encodes compound c as an object with the given members.
+func marshalJSON(c map[string]interface{}, members []jsonMember) ([]byte, os.Error) {
+	obj, err := toJSONObject(c, members)
+	if err != nil {
+		return nil, err
+	}
+	buf := new(bytes.Buffer)
+	if err = writeJSON(buf, obj); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// unmarshalJSON decodes an object with the given members back into a compound.
+func unmarshalJSON(data []byte, members []jsonMember) (map[string]interface{}, os.Error) {
+	var v interface{}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return nil, err
+	}
+	return fromJSONObject(v, members)
+}
+
+// jsonField is a member of a JSON object being written; jsonObject keeps its
+// members in order.
+type jsonField struct {
+	name  string
+	value interface{}
+}
+
+type jsonObject []jsonField
+
+// toJSONObject encodes the tags of c named in members as those members, in order,
+// followed by the rest as "extra".
+func toJSONObject(c map[string]interface{}, members []jsonMember) (obj jsonObject, err os.Error) {
+	c = copyTags(c)
+	for _, m := range members {
+		v, ok, err := m.encode(c[m.tag])
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			obj = append(obj, jsonField{m.name, v})
+			c[m.tag] = nil, false
+		}
+	}
+	if len(c) > 0 {
+		extra, err := jsonTags(c)
+		if err != nil {
+			return nil, err
+		}
+		obj = append(obj, jsonField{"extra", extra})
+	}
+	return
+}
+
+// encode returns tag as member m, or false if tag is missing or of the wrong type.
+func (m jsonMember) encode(tag interface{}) (v interface{}, ok bool, err os.Error) {
+	switch m.kind {
+	case jsonString:
+		v, ok = tag.(string)
+	case jsonByte:
+		v, ok = tag.(int8)
+	case jsonShort:
+		v, ok = tag.(int16)
+	case jsonInt:
+		v, ok = tag.(int32)
+	case jsonDoubles, jsonFloats:
+		var list []interface{}
+		if list, ok = tag.([]interface{}); !ok {
+			return
+		}
+		for _, n := range list {
+			switch n.(type) {
+			case float64:
+				ok = ok && m.kind == jsonDoubles
+			case float32:
+				ok = ok && m.kind == jsonFloats
+			default:
+				ok = false
+			}
+		}
+		v = list
+	case jsonItem:
+		var item map[string]interface{}
+		if item, ok = tag.(map[string]interface{}); ok {
+			v, err = toJSONObject(item, itemMembers)
+		}
+	case jsonItems:
+		var list []interface{}
+		if list, ok = tag.([]interface{}); !ok {
+			return
+		}
+		items := make([]interface{}, len(list))
+		for i, it := range list {
+			item, isItem := it.(map[string]interface{})
+			if !isItem {
+				return nil, false, nil
+			}
+			if items[i], err = toJSONObject(item, itemMembers); err != nil {
+				return
+			}
+		}
+		v = items
+	}
+	return
+}
+
+// jsonTag returns the typed encoding of an NBT tag.
+func jsonTag(tag interface{}) (obj jsonObject, err os.Error) {
+	var typ string
+	var v interface{}
+	switch t := tag.(type) {
+	case int8:
+		typ, v = "byte", t
+	case int16:
+		typ, v = "short", t
+	case int32:
+		typ, v = "int", t
+	case int64:
+		typ, v = "long", strconv.Itoa64(t)
+	case float32:
+		typ, v = "float", t
+	case float64:
+		typ, v = "double", t
+	case []byte:
+		values := make([]interface{}, len(t))
+		for i, b := range t {
+			values[i] = int(b)
+		}
+		typ, v = "bytes", values
+	case string:
+		typ, v = "string", t
+	case []interface{}:
+		list := make([]interface{}, len(t))
+		for i, e := range t {
+			if list[i], err = jsonTag(e); err != nil {
+				return nil, error.NewError(fmt.Sprintf("list element %d", i), err)
+			}
+		}
+		typ, v = "list", list
+	case map[string]interface{}:
+		if v, err = jsonTags(t); err != nil {
+			return
+		}
+		typ = "compound"
+	default:
+		return nil, error.NewError(fmt.Sprintf("cannot encode %T", tag), nil)
+	}
+	return jsonObject{{typ, v}}, nil
+}
+
+// jsonTags returns the typed encodings of a compound's tags, sorted by name.
+func jsonTags(c map[string]interface{}) (obj jsonObject, err os.Error) {
+	names := make([]string, 0, len(c))
+	for name := range c {
+		names = append(names, name)
+	}
+	sort.SortStrings(names)
+	obj = make(jsonObject, len(names))
+	for i, name := range names {
+		obj[i].name = name
+		if obj[i].value, err = jsonTag(c[name]); err != nil {
+			return nil, error.NewError(fmt.Sprintf("tag %q", name), err)
+		}
+	}
+	return
+}
+
+// writeJSON writes v, built of jsonObjects, lists, strings, numbers, booleans
+// and nil, to buf.
+func writeJSON(buf *bytes.Buffer, v interface{}) os.Error {
+	switch v := v.(type) {
+	case jsonObject:
+		buf.WriteByte('{')
+		for i, f := range v {
+			if i > 0 {
+				buf.WriteString(", ")
+			}
+			writeJSON(buf, f.name)
+			buf.WriteString(": ")
+			if err := writeJSON(buf, f.value); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte('}')
+	case []interface{}:
+		buf.WriteByte('[')
+		for i, e := range v {
+			if i > 0 {
+				buf.WriteString(", ")
+			}
+			if err := writeJSON(buf, e); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte(']')
+	case string:
+		s, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		buf.Write(s)
+	case nil:
+		buf.WriteString("null")
+	case bool:
+		if v {
+			buf.WriteString("true")
+		} else {
+			buf.WriteString("false")
+		}
+	case int8:
+		buf.WriteString(strconv.Itoa(int(v)))
+	case int16:
+		buf.WriteString(strconv.Itoa(int(v)))
+	case int32:
+		buf.WriteString(strconv.Itoa(int(v)))
+	case int:
+		buf.WriteString(strconv.Itoa(v))
+	case float32:
+		if math.IsNaN(float64(v)) || math.IsInf(float64(v), 0) {
+			return error.NewError(fmt.Sprintf("cannot encode %v in JSON", v), nil)
+		}
+		buf.WriteString(strconv.Ftoa32(v, 'g', -1))
+	case float64:
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return error.NewError(fmt.Sprintf("cannot encode %v in JSON", v), nil)
+		}
+		buf.WriteString(strconv.Ftoa64(v, 'g', -1))
+	default:
+		return error.NewError(fmt.Sprintf("cannot encode %T in JSON", v), nil)
+	}
+	return nil
+}
+
+// writeJSONIndented writes v as writeJSON does, but with each member of an
+// object and each element of a list on a line of its own, indented by tabs
+// after indent, unless v is flat enough to fit on one line.
+func writeJSONIndented(buf *bytes.Buffer, v interface{}, indent string) os.Error {
+	if flatJSON(v) {
+		return writeJSON(buf, v)
+	}
+	inner := indent + "\t"
+	switch v := v.(type) {
+	case jsonObject:
+		buf.WriteString("{\n")
+		for i, f := range v {
+			if i > 0 {
+				buf.WriteString(",\n")
+			}
+			buf.WriteString(inner)
+			writeJSON(buf, f.name)
+			buf.WriteString(": ")
+			if err := writeJSONIndented(buf, f.value, inner); err != nil {
+				return err
+			}
+		}
+		buf.WriteString("\n" + indent + "}")
+	case []interface{}:
+		buf.WriteString("[\n")
+		for i, e := range v {
+			if i > 0 {
+				buf.WriteString(",\n")
+			}
+			buf.WriteString(inner)
+			if err := writeJSONIndented(buf, e, inner); err != nil {
+				return err
+			}
+		}
+		buf.WriteString("\n" + indent + "]")
+	}
+	return nil
+}
+
+// flatJSON reports whether v is a scalar, a list of scalars or an object with at
+// most one member, itself flat, which writeJSONIndented keeps on one line.
+func flatJSON(v interface{}) bool {
+	switch v := v.(type) {
+	case jsonObject:
+		return len(v) == 0 || len(v) == 1 && flatJSON(v[0].value)
+	case []interface{}:
+		for _, e := range v {
+			switch e.(type) {
+			case jsonObject, []interface{}:
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// fromJSONObject decodes a parsed object with the given members, and perhaps
+// "extra", back into a compound.
+func fromJSONObject(v interface{}, members []jsonMember) (c map[string]interface{}, err os.Error) {
+	obj, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, error.NewError(fmt.Sprintf("expected an object, got %v", v), nil)
+	}
+	c = make(map[string]interface{})
+	if extra, ok := obj["extra"]; ok {
+		tags, ok := extra.(map[string]interface{})
+		if !ok {
+			return nil, error.NewError(fmt.Sprintf("extra: expected an object, got %v", extra), nil)
+		}
+		for name, tag := range tags {
+			if c[name], err = tagFromJSON(tag); err != nil {
+				return nil, error.NewError(fmt.Sprintf("tag %q", name), err)
+			}
+		}
+	}
+	for _, m := range members {
+		if value, ok := obj[m.name]; ok {
+			if c[m.tag], err = m.decode(value); err != nil {
+				return nil, error.NewError(fmt.Sprintf("member %q", m.name), err)
+			}
+		}
+	}
+	for name := range obj {
+		if !isJSONMember(name, members) {
+			return nil, error.NewError(fmt.Sprintf("unknown member %q", name), nil)
+		}
+	}
+	return
+}
+
+func isJSONMember(name string, members []jsonMember) bool {
+	if name == "extra" {
+		return true
+	}
+	for _, m := range members {
+		if m.name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// decode returns the tag a member of kind m.kind holds.
+func (m jsonMember) decode(v interface{}) (tag interface{}, err os.Error) {
+	switch m.kind {
+	case jsonString:
+		s, ok := v.(string)
+		if !ok {
+			return nil, error.NewError(fmt.Sprintf("expected a string, got %v", v), nil)
+		}
+		return s, nil
+	case jsonByte:
+		n, err := jsonInteger(v, math.MinInt8, math.MaxInt8)
+		return int8(n), err
+	case jsonShort:
+		n, err := jsonInteger(v, math.MinInt16, math.MaxInt16)
+		return int16(n), err
+	case jsonInt:
+		n, err := jsonInteger(v, math.MinInt32, math.MaxInt32)
+		return int32(n), err
+	}
+	list, ok := v.([]interface{})
+	if !ok && m.kind != jsonItem {
+		return nil, error.NewError(fmt.Sprintf("expected an array, got %v", v), nil)
+	}
+	switch m.kind {
+	case jsonDoubles, jsonFloats:
+		values := make([]interface{}, len(list))
+		for i, n := range list {
+			f, ok := n.(float64)
+			if !ok {
+				return nil, error.NewError(fmt.Sprintf("expected a number, got %v", n), nil)
+			}
+			if m.kind == jsonFloats {
+				values[i] = float32(f)
+			} else {
+				values[i] = f
+			}
+		}
+		tag = values
+	case jsonItem:
+		tag, err = fromJSONObject(v, itemMembers)
+	case jsonItems:
+		items := make([]interface{}, len(list))
+		for i, item := range list {
+			if items[i], err = fromJSONObject(item, itemMembers); err != nil {
+				return nil, error.NewError(fmt.Sprintf("item %d", i), err)
+			}
+		}
+		tag = items
+	}
+	return
+}
+
+// jsonInteger returns v, a parsed JSON number, as an integer from min to max.
+func jsonInteger(v interface{}, min, max int64) (int64, os.Error) {
+	f, ok := v.(float64)
+	if !ok || f != math.Floor(f) || f < float64(min) || f > float64(max) {
+		return 0, error.NewError(fmt.Sprintf("expected an integer from %d to %d, got %v", min, max, v), nil)
+	}
+	return int64(f), nil
+}
+
+// tagFromJSON decodes the typed encoding of an NBT tag.
+func tagFromJSON(v interface{}) (tag interface{}, err os.Error) {
+	obj, ok := v.(map[string]interface{})
+	if !ok || len(obj) != 1 {
+		return nil, error.NewError(fmt.Sprintf("expected an object naming the tag's type, got %v", v), nil)
+	}
+	for typ, value := range obj {
+		switch typ {
+		case "byte":
+			n, err := jsonInteger(value, math.MinInt8, math.MaxInt8)
+			return int8(n), err
+		case "short":
+			n, err := jsonInteger(value, math.MinInt16, math.MaxInt16)
+			return int16(n), err
+		case "int":
+			n, err := jsonInteger(value, math.MinInt32, math.MaxInt32)
+			return int32(n), err
+		case "long":
+			s, ok := value.(string)
+			if !ok {
+				return nil, error.NewError(fmt.Sprintf("expected a long as a string, got %v", value), nil)
+			}
+			return strconv.Atoi64(s)
+		case "float", "double":
+			f, ok := value.(float64)
+			if !ok {
+				return nil, error.NewError(fmt.Sprintf("expected a number, got %v", value), nil)
+			}
+			if typ == "float" {
+				return float32(f), nil
+			}
+			return f, nil
+		case "bytes":
+			values, ok := value.([]interface{})
+			if !ok {
+				return nil, error.NewError(fmt.Sprintf("expected an array of bytes, got %v", value), nil)
+			}
+			b := make([]byte, len(values))
+			for i, n := range values {
+				u, err := jsonInteger(n, 0, math.MaxUint8)
+				if err != nil {
+					return nil, err
+				}
+				b[i] = byte(u)
+			}
+			return b, nil
+		case "string":
+			s, ok := value.(string)
+			if !ok {
+				return nil, error.NewError(fmt.Sprintf("expected a string, got %v", value), nil)
+			}
+			return s, nil
+		case "list":
+			elems, ok := value.([]interface{})
+			if !ok {
+				return nil, error.NewError(fmt.Sprintf("expected an array of tags, got %v", value), nil)
+			}
+			list := make([]interface{}, len(elems))
+			for i, e := range elems {
+				if list[i], err = tagFromJSON(e); err != nil {
+					return nil, error.NewError(fmt.Sprintf("list element %d", i), err)
+				}
+			}
+			return list, nil
+		case "compound":
+			tags, ok := value.(map[string]interface{})
+			if !ok {
+				return nil, error.NewError(fmt.Sprintf("expected an object of tags, got %v", value), nil)
+			}
+			c := make(map[string]interface{}, len(tags))
+			for name, t := range tags {
+				if c[name], err = tagFromJSON(t); err != nil {
+					return nil, error.NewError(fmt.Sprintf("tag %q", name), err)
+				}
+			}
+			return c, nil
+		}
+		return nil, error.NewError(fmt.Sprintf("unknown tag type %q", typ), nil)
+	}
+	panic("unreachable")
+}
+
+// ExportEntitiesJSON writes every entity in region, or in the whole world if
+// region is nil, to w as a JSON array with one entity per line.  Chunks are read
+// one at a time and none are loaded.
+func (world *World) ExportEntitiesJSON(w io.Writer, region *Region) (err os.Error) {
+	first := true
+	scanErr := world.scanEntities(region, func(xz ChunkCoord, entities []*Entity) bool {
+		for _, e := range entities {
+			var b []byte
+			if b, err = e.MarshalJSON(); err != nil {
+				err = error.InChunk(xz.X, xz.Z).Error("chunk", err)
+				return false
+			}
+			sep := ",\n"
+			if first {
+				sep, first = "[\n", false
+			}
+			if _, err = w.Write(append([]byte(sep), b...)); err != nil {
+				return false
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return
+	}
+	if scanErr != nil {
+		return scanErr
+	}
+	end := "\n]\n"
+	if first {
+		end = "[]\n"
+	}
+	_, err = w.Write([]byte(end))
+	return
+}
@@ -0,0 +1,231 @@
+package world
+
+import "minecraft/nbt"
+
+import "bytes"
+import "io/ioutil"
+import "math"
+import "os"
+import "path"
+import "strings"
+import "testing"
+
+// a pig carrying tags of every type, as a mod might add
+var moddedPigFixture = mobFixture("Pig", map[string]interface{}{
+	"Saddle":    int8(1),
+	"UUIDMost":  int64(-6346065890381750520),
+	"Brand":     []byte{0, 127, 128, 255},
+	"Speed":     float64(0.25),
+	"Nicknames": []interface{}{"Wilbur", "Babe"},
+	"Owner": map[string]interface{}{
+		"Name":  "notch",
+		"Since": int32(1300000000),
+	},
+})
+
+var jsonGoldens = []struct {
+	file    string
+	fixture map[string]interface{}
+}{
+	{"pig.json", pigFixture},
+	{"item.json", itemFixture},
+	{"modded_pig.json", moddedPigFixture},
+	{"storage_minecart.json", minecartFixture("Minecart", map[string]interface{}{
+		"Type": int32(MinecartChest),
+		"Items": []interface{}{
+			itemCompound(0, BlockCobblestone, 64, 0),
+			itemCompound(26, 264, 3, 0),
+		},
+	})},
+}
+
+var tileEntityJSONGoldens = []struct {
+	file    string
+	fixture map[string]interface{}
+}{
+	{"chest.json", chestFixture},
+	{"furnace.json", furnaceFixture},
+	{"sign.json", signFixture},
+	{"spawner.json", spawnerFixture},
+}
+
+func readGolden(t *testing.T, file string) string {
+	golden, err := ioutil.ReadFile(path.Join("testdata", file))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.TrimRight(string(golden), "\n")
+}
+
+func TestEntityJSONGolden(t *testing.T) {
+	for _, g := range jsonGoldens {
+		golden := readGolden(t, g.file)
+		encoded, err := mustEntity(g.fixture).MarshalJSON()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(encoded) != golden {
+			t.Errorf("%s: expected\n%s\ngot\n%s", g.file, golden, encoded)
+		}
+		var e Entity
+		if err = e.UnmarshalJSON([]byte(golden)); err != nil {
+			t.Fatal(g.file, ": ", err)
+		}
+		if decoded := fromEntity(&e); !nbt.Equal(decoded, g.fixture) {
+			t.Error(g.file, ": expected ", g.fixture, ", got ", decoded)
+		}
+	}
+}
+
+func TestTileEntityJSONGolden(t *testing.T) {
+	for _, g := range tileEntityJSONGoldens {
+		golden := readGolden(t, g.file)
+		te, err := toTileEntity(g.fixture)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var encoded []byte
+		var decoded TileEntity
+		switch te := te.(type) {
+		case *Chest:
+			encoded, err = te.MarshalJSON()
+			decoded = new(Chest)
+		case *Furnace:
+			encoded, err = te.MarshalJSON()
+			decoded = new(Furnace)
+		case *Sign:
+			encoded, err = te.MarshalJSON()
+			decoded = new(Sign)
+		case *MobSpawner:
+			encoded, err = te.MarshalJSON()
+			decoded = new(MobSpawner)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(encoded) != golden {
+			t.Errorf("%s: expected\n%s\ngot\n%s", g.file, golden, encoded)
+		}
+		if err = decoded.(interface {
+			UnmarshalJSON([]byte) os.Error
+		}).UnmarshalJSON([]byte(golden)); err != nil {
+			t.Fatal(g.file, ": ", err)
+		}
+		if c := decoded.toCompound(); !nbt.Equal(c, g.fixture) {
+			t.Error(g.file, ": expected ", g.fixture, ", got ", c)
+		}
+	}
+}
+
+func TestEntityJSONPrecision(t *testing.T) {
+	positions := []Position{
+		{0.1 + 0.2, 64, -0.1 - 0.2},
+		{8.000000000000002, 5e-324, -WorldLimit + 1e-9},
+		{1e21, -1e-7, 123456789.123456789},
+	}
+	for _, pos := range positions {
+		e := NewItemDrop(pos.X, pos.Y, pos.Z, Item{Id: BlockDirt, Count: 1})
+		e.Physics.Euler.Yaw = float32(1) / 3
+		encoded, err := e.MarshalJSON()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var decoded Entity
+		if err = decoded.UnmarshalJSON(encoded); err != nil {
+			t.Fatal(err)
+		}
+		if got := decoded.Physics.Position; got.X != pos.X || got.Y != pos.Y || got.Z != pos.Z {
+			t.Errorf("%s: expected %v, got %v", encoded, pos, decoded.Physics.Position)
+		}
+		if decoded.Physics.Euler.Yaw != e.Physics.Euler.Yaw {
+			t.Errorf("%s: yaw %v became %v", encoded, e.Physics.Euler.Yaw, decoded.Physics.Euler.Yaw)
+		}
+	}
+
+	e := NewItemDrop(math.NaN(), 64, 0, Item{Id: BlockDirt, Count: 1})
+	if _, err := e.MarshalJSON(); err == nil {
+		t.Error("expected an error for a NaN position")
+	}
+}
+
+func TestEntityJSONMalformed(t *testing.T) {
+	golden := readGolden(t, "pig.json")
+	for _, data := range []string{
+		`[]`,
+		strings.Replace(golden, `"health": 10`, `"heath": 10`, 1),
+		strings.Replace(golden, `"health": 10`, `"health": 1e6`, 1),
+		strings.Replace(golden, `"health": 10`, `"health": 9.5`, 1),
+		strings.Replace(golden, `"id": "Pig", "pos": [4.5, 65, 9.25], `, `"id": "Pig", `, 1),
+		strings.Replace(golden, `{"byte": 1}`, `{"bool": true}`, 1),
+		strings.Replace(golden, `{"byte": 1}`, `{"byte": 1, "short": 1}`, 1),
+	} {
+		var e Entity
+		if err := e.UnmarshalJSON([]byte(data)); err == nil {
+			t.Error("expected an error for ", data)
+		}
+	}
+}
+
+func TestChestUnmarshalJSONInChunk(t *testing.T) {
+	c := mustChunk(testChunkPayload(0, -2, []interface{}{}, []interface{}{chestFixture}))
+	chest := c.Level.TileEntities[0].(*Chest)
+	data := strings.Replace(readGolden(t, "chest.json"), `"count": 64`, `"count": 32`, 1)
+	if err := chest.UnmarshalJSON([]byte(data)); err != nil {
+		t.Fatal(err)
+	}
+	if chest.Slots[0].Item.Count != 32 {
+		t.Error("expected 32 in slot 0, got ", chest.Slots[0].Item.Count)
+	}
+	if c.Level.TileEntities[0] != TileEntity(chest) || !c.Dirty() {
+		t.Error("edited chest left its chunk clean")
+	}
+
+	sign := readGolden(t, "sign.json")
+	if err := chest.UnmarshalJSON([]byte(sign)); err == nil {
+		t.Error("expected an error decoding a sign into a chest")
+	}
+}
+
+func TestExportEntitiesJSON(t *testing.T) {
+	dir := makeTestWorld(t,
+		testChunkPayload(0, 0, []interface{}{pigFixture}, nil),
+		testChunkPayload(-1, 0, []interface{}{itemFixture, moddedPigFixture}, nil),
+		testChunkPayload(5, 5, []interface{}{}, nil))
+	defer os.RemoveAll(dir)
+	w, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	buf := new(bytes.Buffer)
+	if err = w.ExportEntitiesJSON(buf, nil); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(buf.String(), "\n", -1)
+	if len(lines) != 6 || lines[0] != "[" || lines[4] != "]" || lines[5] != "" {
+		t.Fatalf("expected a JSON array of 3 entities, got\n%s", buf.String())
+	}
+	ids := make(map[string]int)
+	for _, line := range lines[1:4] {
+		var e Entity
+		if err = e.UnmarshalJSON([]byte(strings.TrimRight(line, ","))); err != nil {
+			t.Fatal(err)
+		}
+		ids[e.Id]++
+	}
+	if ids["Pig"] != 2 || ids["Item"] != 1 {
+		t.Error("expected 2 pigs and an item, got ", ids)
+	}
+	if len(w.Chunks) != 0 {
+		t.Error("export loaded chunks")
+	}
+
+	buf.Reset()
+	if err = w.ExportEntitiesJSON(buf, NewRegion(5, 5, 5, 5)); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != "[]\n" {
+		t.Errorf("expected an empty array, got %q", buf.String())
+	}
+}
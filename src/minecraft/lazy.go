@@ -0,0 +1,109 @@
+package world
+
+import "minecraft/error"
+import "minecraft/nbt"
+
+import "fmt"
+import "os"
+
+// keepArrays decodes only the arrays of a chunk.
+func keepArrays(name string, ttype nbt.TagType) bool {
+	return ttype == nbt.Compound || ttype == nbt.ByteArray
+}
+
+// loadChunkFile reads the chunk at (x, z) to be made resident, lazily if
+// world.LazyArrays is set.
+func (world *World) loadChunkFile(x, z int32) (*Chunk, os.Error) {
+	if !world.LazyArrays {
+		return world.strict(world.readChunk(x, z))
+	}
+	raw, giveBack, err := world.readPayload(x, z)
+	if err != nil {
+		return nil, error.InChunk(x, z).Error("could not load chunk", err)
+	}
+	defer giveBack()
+	return world.decodeChunkFile(x, z, raw)
+}
+
+// decodeChunkFile decodes the chunk at (x, z) from raw, its payload, as
+// loadChunkFile would have read it.
+func (world *World) decodeChunkFile(x, z int32, raw []byte) (c *Chunk, err os.Error) {
+	if !world.LazyArrays {
+		if c, err = decodeChunkBytes(raw, &world.pool); err != nil {
+			err = error.InChunk(x, z).Error("could not load chunk", err)
+		}
+		return world.strict(c, err)
+	}
+	_, chunkmap, err := decodePayload(raw, skipByteArrays)
+	if err != nil {
+		return nil, error.InChunk(x, z).Error("could not load chunk", err)
+	}
+	level, err := getCompound(chunkmap, "Level")
+	if err == nil {
+		c, err = toChunkLevel(level)
+	}
+	if err != nil {
+		return nil, error.InChunk(x, z).Error("malformed chunk", error.Wrap(ErrCorruptChunk, err))
+	}
+	if _, ok := world.Store().(lender); ok {
+		raw = append([]byte(nil), raw...)
+	}
+	c.raw = raw
+	return world.strict(c, nil)
+}
+
+// LoadArrays decodes the chunk's block, data, light and height map arrays if
+// they were left undecoded by World.LazyArrays or compressed by
+// CompressIdleChunks, and otherwise does nothing.  Flush calls it before writing
+// a chunk.  Warnings about the chunk's spawners,
+// which need its blocks, are added to Warnings once the arrays are decoded.
+func (c *Chunk) LoadArrays() os.Error {
+	c.rawMu.Lock()
+	defer c.rawMu.Unlock()
+	x, z := c.Level.XPos, c.Level.ZPos
+	if c.packed != nil {
+		if err := c.uncompress(); err != nil {
+			return error.InChunk(x, z).Error("could not uncompress the arrays of chunk", err)
+		}
+		return nil
+	}
+	if c.raw == nil {
+		return nil
+	}
+	_, chunkmap, err := decodePayload(c.raw, keepArrays)
+	if err != nil {
+		return error.InChunk(x, z).Error("could not decode the arrays of chunk", err)
+	}
+	level, err := getCompound(chunkmap, "Level")
+	if err != nil {
+		return error.InChunk(x, z).Error("malformed chunk", error.Wrap(ErrCorruptChunk, err))
+	}
+	var l Level
+	for _, array := range []struct {
+		name string
+		dst  *[]byte
+		size int
+	}{
+		{"Blocks", &l.Blocks, chunkBlocks},
+		{"Data", &l.Data, chunkNibbles},
+		{"SkyLight", &l.SkyLight, chunkNibbles},
+		{"HeightMap", &l.HeightMap, chunkColumns},
+		{"BlockLight", &l.BlockLight, chunkNibbles},
+	} {
+		v, err := getByteArray(level, array.name)
+		if err != nil {
+			return error.InChunk(x, z).Error("malformed chunk", error.Wrap(ErrCorruptChunk, err))
+		}
+		if len(v) != array.size {
+			return error.InChunk(x, z).Error(fmt.Sprint(len(v), " bytes of ", array.name, " in chunk"), ErrCorruptChunk)
+		}
+		*array.dst = v
+	}
+	// checked on a copy, as c's own BlockAt would wait on rawMu
+	l.TileEntities, l.XPos, l.ZPos = c.Level.TileEntities, x, z
+	c.Warnings = append(c.Warnings, checkSpawnerBlocks(&Chunk{Level: l})...)
+	c.Level.Blocks, c.Level.Data, c.Level.HeightMap = l.Blocks, l.Data, l.HeightMap
+	c.Level.SkyLight, c.Level.BlockLight = l.SkyLight, l.BlockLight
+	c.raw = nil
+	return nil
+}
@@ -0,0 +1,220 @@
+package world
+
+import "minecraft/nbt"
+
+import "bytes"
+import "os"
+import "path"
+import "runtime"
+import "testing"
+
+func TestLazyArrays(t *testing.T) {
+	west := testChunkPayload(-1, 0, []interface{}{pigFixture}, []interface{}{spawnerFixture})
+	west["Level"].(map[string]interface{})["Blocks"].([]byte)[blockIndex(1, 64, 1)] = BlockStone
+	east := testChunkPayload(0, 0, []interface{}{pigFixture}, nil)
+	east["Level"].(map[string]interface{})["Blocks"].([]byte)[blockIndex(2, 70, 3)] = BlockGlass
+	short := testChunkPayload(1, 1, nil, nil)
+	short["Level"].(map[string]interface{})["Blocks"] = make([]byte, 10)
+	dir := makeTestWorld(t, west, east, short)
+	defer os.RemoveAll(dir)
+	w, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	eager, err := w.GetChunk(-1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if eager.Level.Blocks == nil || len(eager.Warnings) != 1 {
+		t.Errorf("expected an eager chunk with its blocks and 1 warning, got %v", eager.Warnings)
+	}
+	w.Chunks = make(map[XZ]*Chunk)
+
+	w.LazyArrays = true
+	c, err := w.GetChunk(-1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Level.Blocks != nil || c.Level.Data != nil || c.Level.SkyLight != nil || c.Level.BlockLight != nil || c.Level.HeightMap != nil {
+		t.Error("expected the arrays to be left undecoded")
+	}
+	if len(c.Level.Entities) != 1 || len(c.Level.TileEntities) != 1 || len(c.Warnings) != 0 {
+		t.Errorf("expected 1 entity, 1 tile entity and no warnings, got %d, %d and %v",
+			len(c.Level.Entities), len(c.Level.TileEntities), c.Warnings)
+	}
+	if id, _, err := c.BlockAt(1, 64, 1); err != nil || id != BlockStone {
+		t.Errorf("expected stone, got %d (%v)", id, err)
+	}
+	if len(c.Level.Blocks) != chunkBlocks || len(c.Level.HeightMap) != chunkColumns || len(c.Warnings) != 1 {
+		t.Errorf("expected the arrays decoded and the spawner warned about, got %v", c.Warnings)
+	}
+	if err = c.LoadArrays(); err != nil {
+		t.Error(err)
+	}
+
+	// a chunk flushed without its arrays having been touched keeps them
+	c, err = w.GetChunk(0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Level.Entities = nil
+	c.dirty = true
+	if err = w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	w.Chunks = make(map[XZ]*Chunk)
+	w.LazyArrays = false
+	if c, err = w.GetChunk(0, 0); err != nil {
+		t.Fatal(err)
+	}
+	if id, _, _ := c.BlockAt(2, 70, 3); id != BlockGlass || len(c.Level.Entities) != 0 {
+		t.Errorf("expected glass and no entities, got %d and %d entities", id, len(c.Level.Entities))
+	}
+
+	w.LazyArrays = true
+	if c, err = w.GetChunk(1, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err = c.LoadArrays(); err == nil {
+		t.Error("expected an error for a short Blocks")
+	}
+	if err = w.RenderMap(new(bytes.Buffer), NewRegion(1, 1, 1, 1), RenderOptions{}); !IsError(err, ErrCorruptChunk) {
+		t.Error("expected ErrCorruptChunk drawing a chunk whose arrays are malformed, got ", err)
+	}
+	if err = w.Flush(); err != nil {
+		t.Error(err)
+	}
+	c.dirty = true
+	if err = w.Flush(); err == nil {
+		t.Error("expected an error flushing a chunk whose arrays are malformed")
+	}
+}
+
+func TestTruncatedLazyArrays(t *testing.T) {
+	short := testChunkPayload(0, 0, nil, nil)
+	short["Level"].(map[string]interface{})["Blocks"] = make([]byte, chunkBlocks/2)
+	dir := makeTestWorld(t, short)
+	defer os.RemoveAll(dir)
+	w, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	w.LazyArrays = true
+	c, err := w.GetChunk(0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// each method that needs the arrays fails rather than panicking
+	for name, f := range map[string]func() os.Error{
+		"BlockAt":         func() os.Error { _, _, err := c.BlockAt(1, 2, 3); return err },
+		"HighestBlockAt":  func() os.Error { _, _, err := c.HighestBlockAt(1, 3); return err },
+		"SetBlock":        func() os.Error { return c.SetBlock(1, 2, 3, BlockStone, 0) },
+		"SetBlocks":       func() os.Error { return c.SetBlocks(make([]byte, chunkBlocks)) },
+		"SetRegionBlocks": func() os.Error { return c.SetRegionBlocks(0, 0, 0, [3]int32{1, 1, 1}, []byte{1}, nil) },
+		"Slice":           func() os.Error { _, _, err := c.Slice(64); return err },
+		"BlockLightAt":    func() os.Error { _, err := c.BlockLightAt(1, 2, 3); return err },
+		"SkyLightAt":      func() os.Error { _, err := c.SkyLightAt(1, 2, 3); return err },
+		"MarkDirty":       func() os.Error { return c.MarkDirty() },
+		"World.Slice": func() os.Error {
+			_, _, err := w.Slice(NewRegion(0, 0, 0, 0), 64, BlockAir)
+			return err
+		},
+		"ExportChunkJSON": func() os.Error { return w.ExportChunkJSON(new(bytes.Buffer), 0, 0, ChunkJSONOptions{}) },
+	} {
+		if err := f(); !IsError(err, ErrCorruptChunk) {
+			t.Errorf("%s: expected ErrCorruptChunk for a truncated Blocks, got %v", name, err)
+		}
+	}
+	if c.Dirty() {
+		t.Error("expected the chunk left clean")
+	}
+}
+
+func TestMalformedLazyLevel(t *testing.T) {
+	noPos := testChunkPayload(0, 0, nil, nil)
+	level := noPos["Level"].(map[string]interface{})
+	level["xPos"] = nil, false
+	badLevel := testChunkPayload(1, 0, nil, nil)
+	badLevel["Level"] = int32(7)
+	dir := makeTestWorld(t)
+	defer os.RemoveAll(dir)
+	for x, payload := range []map[string]interface{}{noPos, badLevel} {
+		file := chunkPath(dir, int32(x), 0)
+		if err := os.MkdirAll(path.Dir(file), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := nbt.Save(file, "", payload); err != nil {
+			t.Fatal(err)
+		}
+	}
+	w, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	for _, lazy := range []bool{false, true} {
+		w.LazyArrays = lazy
+		for x := int32(0); x < 2; x++ {
+			if _, err := w.GetChunk(x, 0); !IsError(err, ErrCorruptChunk) {
+				t.Errorf("chunk (%d, 0), lazy %v: expected ErrCorruptChunk, got %v", x, lazy, err)
+			}
+		}
+	}
+}
+
+// benchmarkEntityCensus counts the entities of a world of 1,000 chunks, loading
+// each chunk, as a sweep that never looks at blocks would, and logs what a sweep
+// allocates.
+func benchmarkEntityCensus(b *testing.B, lazy bool) {
+	b.StopTimer()
+	dir, err := writeTestWorld()
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for x := int32(0); x < 40; x++ {
+		for z := int32(0); z < 25; z++ {
+			item := itemAt(float64(x*16+8), 64, float64(z*16+8))
+			if err = writeTestChunk(dir, testChunkPayload(x, z, []interface{}{item}, nil)); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+	w, err := Open(dir)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer w.Close()
+	w.LazyArrays = lazy
+	runtime.UpdateMemStats()
+	bytes, mallocs := runtime.MemStats.TotalAlloc, runtime.MemStats.Mallocs
+	b.StartTimer()
+
+	for i := 0; i < b.N; i++ {
+		w.Chunks = make(map[XZ]*Chunk)
+		n := 0
+		for x := int32(0); x < 40; x++ {
+			for z := int32(0); z < 25; z++ {
+				c, err := w.GetChunk(x, z)
+				if err != nil {
+					b.Fatal(err)
+				}
+				n += len(c.Level.Entities)
+			}
+		}
+		if n != 1000 {
+			b.Fatal("expected 1000 entities, got ", n)
+		}
+	}
+	b.StopTimer()
+	runtime.UpdateMemStats()
+	bytes, mallocs = runtime.MemStats.TotalAlloc-bytes, runtime.MemStats.Mallocs-mallocs
+	b.Logf("%d KiB in %d allocations a sweep", bytes/1024/uint64(b.N), mallocs/uint64(b.N))
+}
+
+func BenchmarkEntityCensusEager(b *testing.B) { benchmarkEntityCensus(b, false) }
+func BenchmarkEntityCensusLazy(b *testing.B)  { benchmarkEntityCensus(b, true) }
@@ -0,0 +1,303 @@
+package world
+
+import "minecraft/error"
+
+import "fmt"
+import "image"
+import "io"
+import "os"
+
+// see: http://www.minecraftwiki.net/wiki/Light
+
+// lightEmission gives the block light each block gives off.
+var lightEmission [256]byte
+
+// lightOpacity gives how much light, beyond the one level lost every block,
+// each block takes from the light passing through it.  Solid blocks stop it.
+var lightOpacity [256]byte
+
+func init() {
+	for id, level := range map[byte]byte{
+		10: 15, // lava
+		11: 15, // stationary lava
+		39: 1,  // brown mushroom
+		50: 14, // torch
+		51: 15, // fire
+		62: 13, // burning furnace
+		74: 9,  // glowing redstone ore
+		76: 7,  // redstone torch (on)
+		89: 15, // glowstone
+		90: 11, // portal
+		91: 15, // jack-o-lantern
+	} {
+		lightEmission[id] = level
+	}
+	for id := range lightOpacity {
+		if !skyTransparent[id] {
+			lightOpacity[id] = 15
+		}
+	}
+	lightOpacity[BlockLeaves] = 1
+	lightOpacity[BlockWater] = 3
+	lightOpacity[BlockStillWater] = 3
+	lightOpacity[BlockIce] = 3
+	// they give off light, so light must reach their neighbours
+	lightOpacity[BlockLava] = 0
+	lightOpacity[BlockStillLava] = 0
+	lightOpacity[89] = 0
+	lightOpacity[91] = 0
+	lightOpacity[BlockLitFurnace] = 0
+	lightOpacity[74] = 0
+}
+
+// BlockLightAt returns the light that glowing blocks cast on block (x, y, z) of
+// the chunk, from 0 to 15, as the game last stored it.
+func (c *Chunk) BlockLightAt(x, y, z int32) (byte, os.Error) {
+	if !inChunk(x, y, z) {
+		return 0, error.NewError(fmt.Sprintf("(%d, %d, %d) is outside the chunk", x, y, z), ErrOutOfRange)
+	}
+	if err := c.LoadArrays(); err != nil {
+		return 0, err
+	}
+	return getNibble(c.Level.BlockLight, blockIndex(x, y, z)), nil
+}
+
+// SkyLightAt returns the light that the sky casts on block (x, y, z) of the chunk
+// at noon, from 0 to 15, as the game last stored it.
+func (c *Chunk) SkyLightAt(x, y, z int32) (byte, os.Error) {
+	if !inChunk(x, y, z) {
+		return 0, error.NewError(fmt.Sprintf("(%d, %d, %d) is outside the chunk", x, y, z), ErrOutOfRange)
+	}
+	if err := c.LoadArrays(); err != nil {
+		return 0, err
+	}
+	return getNibble(c.Level.SkyLight, blockIndex(x, y, z)), nil
+}
+
+// relight computes the block light of the volume from its blocks alone, ignoring
+// what is stored, by spreading light out from each glowing block a level at a
+// time.  The result is indexed like Level.Blocks, extended to the whole volume:
+// y + bz*128 + bx*128*depth.
+func (v *blockVolume) relight() []byte {
+	light := make([]byte, v.width*ChunkHeight*v.depth)
+	index := func(bx, y, bz int) int {
+		return y + bz*ChunkHeight + bx*ChunkHeight*v.depth
+	}
+	var levels [16][]int // the blocks lit to each level, waiting to spread
+	for bx := 0; bx < v.width; bx++ {
+		for bz := 0; bz < v.depth; bz++ {
+			for y := 0; y < ChunkHeight; y++ {
+				if e := lightEmission[v.block(bx, int32(y), bz)]; e > 0 {
+					i := index(bx, y, bz)
+					light[i] = e
+					levels[e] = append(levels[e], i)
+				}
+			}
+		}
+	}
+	for level := 15; level > 1; level-- {
+		for _, i := range levels[level] {
+			if int(light[i]) != level {
+				continue // reached more brightly since
+			}
+			bx, bz, y := i/(ChunkHeight*v.depth), i/ChunkHeight%v.depth, i%ChunkHeight
+			for _, d := range [6][3]int{{1, 0, 0}, {-1, 0, 0}, {0, 1, 0}, {0, -1, 0}, {0, 0, 1}, {0, 0, -1}} {
+				nx, ny, nz := bx+d[0], y+d[1], bz+d[2]
+				if nx < 0 || nx >= v.width || ny < 0 || ny >= ChunkHeight || nz < 0 || nz >= v.depth {
+					continue
+				}
+				next := level - 1 - int(lightOpacity[v.block(nx, int32(ny), nz)])
+				if j := index(nx, ny, nz); next > int(light[j]) {
+					light[j] = byte(next)
+					levels[next] = append(levels[next], j)
+				}
+			}
+		}
+	}
+	return light
+}
+
+// relitBlockLight returns the block light of c computed by relight, laid out like
+// Level.BlockLight, reading its neighbours so that light from beyond its edges is
+// counted.
+func (world *World) relitBlockLight(c *Chunk) ([]byte, os.Error) {
+	x, z := c.Level.XPos, c.Level.ZPos
+	v, err := world.loadVolume(NewRegion(x-1, z-1, x+1, z+1), 0, ChunkHeight-1, nil)
+	if err != nil {
+		return nil, err
+	}
+	v.chunks[MakeXZ(x, z)] = c
+	light := v.relight()
+	nibbles := make([]byte, chunkNibbles)
+	for lx := 0; lx < ChunkWidth; lx++ {
+		for lz := 0; lz < ChunkDepth; lz++ {
+			for y := 0; y < ChunkHeight; y++ {
+				i := y + (lz+ChunkDepth)*ChunkHeight + (lx+ChunkWidth)*ChunkHeight*v.depth
+				setNibble(nibbles, blockIndex(int32(lx), int32(y), int32(lz)), light[i])
+			}
+		}
+	}
+	return nibbles, nil
+}
+
+// LightOptions control how RenderLightMap draws light levels.
+type LightOptions struct {
+	RenderOptions
+	// Combined uses the brighter of the block light and the sky light at noon,
+	// rather than the block light alone.
+	Combined bool
+	// Overlay, if not zero, draws the map as RenderMap does and marks each
+	// surface dark enough for monsters to spawn on, with light below 8, in red
+	// of this opacity.  Otherwise each surface is colored by its light, from
+	// red at 0 to green at 15.
+	Overlay uint8
+	// Relight computes block light from the blocks, for worlds whose stored
+	// light may be stale, such as after editing.  The world is not changed.
+	// Sky light is always as stored.
+	Relight bool
+}
+
+// DarkLight is the level of light below which monsters spawn.
+const DarkLight = 8
+
+// RenderLightMap writes a PNG map of region (nil meaning every chunk in the
+// world), laid out as by RenderMap, showing the light on the surface of each
+// column: the light in the block just above its highest block between opts.MinY
+// and opts.MaxY, which is where a monster would stand.  Columns of missing
+// chunks, or with no blocks in range, are transparent.  Chunks are read a row at
+// a time as the PNG is written, along with their neighbours if opts.Relight is
+// set, and are not kept.
+func (world *World) RenderLightMap(w io.Writer, region *Region, opts LightOptions) os.Error {
+	minY, maxY, err := opts.yRange()
+	if err != nil {
+		return err
+	}
+	colors := opts.colors()
+	light := &columnLight{world: world, relight: opts.Relight}
+	err = world.renderColumns("RenderLightMap", w, region, opts.Scale, image.NRGBAColorModel, func(c *Chunk, x, z int32) image.Color {
+		if c == nil {
+			return image.NRGBAColor{}
+		}
+		y, _, _ := c.highestBlockBelow(x, maxY, z)
+		if y < minY {
+			return image.NRGBAColor{}
+		}
+		block, sky := light.above(c, x, y, z)
+		if opts.Combined && sky > block {
+			block = sky
+		}
+		if opts.Overlay == 0 {
+			return lightColor(block)
+		}
+		color := topColor(c, x, z, minY, maxY, colors)
+		if block < DarkLight {
+			color = over(image.NRGBAColor{0xff, 0, 0, opts.Overlay}, color)
+		}
+		return color
+	})
+	if err == nil {
+		err = light.err
+	}
+	return err
+}
+
+// columnLight finds the light on the surfaces of columns for the renderers of
+// this file, relighting each chunk first if relight is set and keeping its block
+// light for the chunk's other columns.
+type columnLight struct {
+	world   *World
+	relight bool
+
+	lit        *Chunk // the chunk blockLight was computed for
+	blockLight []byte
+	err        os.Error // the first chunk that could not be relit
+}
+
+// above returns the block light and the sky light in the block above block
+// (x, y, z) of c.  Above the top of the world there is no block light and the
+// sky's is full.
+func (l *columnLight) above(c *Chunk, x, y, z int32) (block, sky byte) {
+	if c != l.lit {
+		l.lit, l.blockLight = c, c.Level.BlockLight
+		if l.relight {
+			var err os.Error
+			if l.blockLight, err = l.world.relitBlockLight(c); err != nil {
+				l.blockLight = c.Level.BlockLight
+				if l.err == nil {
+					l.err = err
+				}
+			}
+		}
+	}
+	if y++; y >= ChunkHeight {
+		return 0, 15
+	}
+	return getNibble(l.blockLight, blockIndex(x, y, z)), getNibble(c.Level.SkyLight, blockIndex(x, y, z))
+}
+
+// lightColor returns the color of a light level on a ramp from red to green.
+func lightColor(light byte) image.NRGBAColor {
+	return image.NRGBAColor{uint8(0xff * (15 - int(light)) / 15), uint8(0xff * int(light) / 15), 0, 0xff}
+}
+
+// NightOptions control how RenderNightMap draws the world by night.
+type NightOptions struct {
+	RenderOptions
+	// Relight computes block light from the blocks, as LightOptions.Relight
+	// does.
+	Relight bool
+	// Moonlight lights each surface by the sky light on it too, dimmed to the
+	// light of the moon, MoonLight at most.  Otherwise only glowing blocks give
+	// light.
+	Moonlight bool
+}
+
+// MoonLight is the light the full moon casts on a surface open to the sky.
+const MoonLight = 4
+
+// nightColor is the dark blue a surface in no light is tinted toward.
+var nightColor = image.NRGBAColor{0x08, 0x10, 0x38, 0xff}
+
+// RenderNightMap writes a PNG map of region (nil meaning every chunk in the
+// world), laid out and colored as by RenderMap, with each column darkened toward
+// deep blue by how little light lies on its surface, the block above its highest
+// block between opts.MinY and opts.MaxY: fully at light 0 and not at all at 15,
+// so that torches cast pools of color in the dark.  Columns of missing chunks, or
+// with no blocks in range, are transparent.  Chunks are read as by
+// RenderLightMap and are not kept.
+func (world *World) RenderNightMap(w io.Writer, region *Region, opts NightOptions) os.Error {
+	minY, maxY, err := opts.yRange()
+	if err != nil {
+		return err
+	}
+	colors := opts.colors()
+	light := &columnLight{world: world, relight: opts.Relight}
+	err = world.renderColumns("RenderNightMap", w, region, opts.Scale, image.NRGBAColorModel, func(c *Chunk, x, z int32) image.Color {
+		if c == nil {
+			return image.NRGBAColor{}
+		}
+		y, _, _ := c.highestBlockBelow(x, maxY, z)
+		if y < minY {
+			return image.NRGBAColor{}
+		}
+		block, sky := light.above(c, x, y, z)
+		if moon := sky * MoonLight / 15; opts.Moonlight && moon > block {
+			block = moon
+		}
+		return darken(topColor(c, x, z, minY, maxY, colors), block)
+	})
+	if err == nil {
+		err = light.err
+	}
+	return err
+}
+
+// darken returns color as it looks in the given light, tinted toward nightColor
+// by how dark it is, keeping its opacity.
+func darken(color image.NRGBAColor, light byte) image.NRGBAColor {
+	tint := nightColor
+	tint.A = uint8(0xe0 * (15 - int(light)) / 15)
+	dark := over(tint, color)
+	dark.A = color.A
+	return dark
+}
@@ -0,0 +1,238 @@
+package world
+
+import "bytes"
+import "image"
+import "image/png"
+import "os"
+import "testing"
+
+// darkWorld returns chunks (0, 0) and (1, 0) floored with stone at y=63 under
+// open air, with no light stored, and a torch standing at (14, 64, 8) near the
+// edge between them.
+func darkWorld() *World {
+	w := &World{Chunks: make(map[XZ]*Chunk)}
+	for cx := int32(0); cx < 2; cx++ {
+		c := newChunk(cx, 0)
+		for x := int32(0); x < 16; x++ {
+			for z := int32(0); z < 16; z++ {
+				c.SetBlock(x, 63, z, BlockStone, 0)
+			}
+		}
+		w.Chunks[MakeXZ(cx, 0)] = c
+	}
+	w.Chunks[MakeXZ(0, 0)].SetBlock(14, 64, 8, BlockTorch, 0)
+	return w
+}
+
+func TestLightAt(t *testing.T) {
+	c := newChunk(0, 0)
+	setNibble(c.Level.BlockLight, blockIndex(1, 2, 3), 7)
+	setNibble(c.Level.SkyLight, blockIndex(1, 2, 3), 12)
+	if l, err := c.BlockLightAt(1, 2, 3); err != nil || l != 7 {
+		t.Error("expected block light 7, got ", l, err)
+	}
+	if l, err := c.SkyLightAt(1, 2, 3); err != nil || l != 12 {
+		t.Error("expected sky light 12, got ", l, err)
+	}
+	if _, err := c.BlockLightAt(1, 128, 3); err == nil {
+		t.Error("expected an error above the chunk")
+	}
+}
+
+func TestRenderLightMapRelight(t *testing.T) {
+	buf := new(bytes.Buffer)
+	if err := darkWorld().RenderLightMap(buf, NewRegion(0, 0, 1, 0), LightOptions{Relight: true}); err != nil {
+		t.Fatal(err)
+	}
+	m := decodePNG(t, buf)
+	if b := m.Bounds(); b.Dx() != 32 || b.Dy() != 16 {
+		t.Fatal("expected a 32x16 map, got ", b)
+	}
+	for _, p := range []struct {
+		px, py int
+		light  byte
+	}{
+		{14, 8, 13}, // above the torch
+		{13, 8, 13},
+		{12, 8, 12},
+		{14, 5, 11},
+		{17, 8, 11}, // across the chunk edge
+		{20, 10, 6},
+		{8, 0, 0},
+		{0, 8, 0},
+	} {
+		if got := m.At(p.px, p.py); !sameColor(got, lightColor(p.light)) {
+			t.Errorf("pixel (%d, %d): expected light %d, got %v", p.px, p.py, p.light, got)
+		}
+	}
+}
+
+func TestRenderLightMapStored(t *testing.T) {
+	w := darkWorld()
+	c := w.Chunks[MakeXZ(0, 0)]
+	setNibble(c.Level.BlockLight, blockIndex(3, 64, 3), 12)
+	setNibble(c.Level.SkyLight, blockIndex(5, 64, 5), 15)
+	for _, test := range []struct {
+		opts       LightOptions
+		lit, sunny byte
+	}{
+		{LightOptions{}, 12, 0},
+		{LightOptions{Combined: true}, 12, 15},
+	} {
+		buf := new(bytes.Buffer)
+		if err := w.RenderLightMap(buf, NewRegion(0, 0, 0, 0), test.opts); err != nil {
+			t.Fatal(err)
+		}
+		m := decodePNG(t, buf)
+		if got := m.At(3, 3); !sameColor(got, lightColor(test.lit)) {
+			t.Errorf("%+v: expected light %d at the lit block, got %v", test.opts, test.lit, got)
+		}
+		if got := m.At(5, 5); !sameColor(got, lightColor(test.sunny)) {
+			t.Errorf("%+v: expected light %d under the sky, got %v", test.opts, test.sunny, got)
+		}
+		if got := m.At(14, 8); !sameColor(got, lightColor(0)) {
+			t.Errorf("%+v: expected the torch unlit without relighting, got %v", test.opts, got)
+		}
+	}
+}
+
+func TestRenderLightMapOverlay(t *testing.T) {
+	buf := new(bytes.Buffer)
+	opts := LightOptions{Relight: true, Overlay: 0x80}
+	if err := darkWorld().RenderLightMap(buf, NewRegion(0, 0, 0, 0), opts); err != nil {
+		t.Fatal(err)
+	}
+	m := decodePNG(t, buf)
+	stone := blockColors[BlockStone]
+	for _, p := range []struct {
+		px, py int
+		want   image.Color
+	}{
+		{13, 8, stone},
+		{10, 6, stone}, // light 8
+		{10, 5, over(image.NRGBAColor{0xff, 0, 0, 0x80}, stone)}, // light 7
+		{0, 0, over(image.NRGBAColor{0xff, 0, 0, 0x80}, stone)},
+	} {
+		if got := m.At(p.px, p.py); !sameColor(got, p.want) {
+			t.Errorf("pixel (%d, %d): expected %v, got %v", p.px, p.py, p.want, got)
+		}
+	}
+}
+
+// villageWorld returns chunks (0, 0) and (1, 0) of grass at y=63 under open air,
+// with no light stored: a cobblestone hut from (2, 64, 2) to (6, 66, 6) roofed
+// with planks, with a torch inside it and a doorway on its +x side, a pond of
+// water from (9, 63, 10) to (12, 63, 13), and torches standing at (9, 64, 4) and
+// (24, 64, 9).
+func villageWorld() *World {
+	w := &World{Chunks: make(map[XZ]*Chunk)}
+	for cx := int32(0); cx < 2; cx++ {
+		c := newChunk(cx, 0)
+		for x := int32(0); x < 16; x++ {
+			for z := int32(0); z < 16; z++ {
+				c.SetBlock(x, 62, z, BlockDirt, 0)
+				c.SetBlock(x, 63, z, BlockGrass, 0)
+			}
+		}
+		w.Chunks[MakeXZ(cx, 0)] = c
+	}
+	c := w.Chunks[MakeXZ(0, 0)]
+	for x := int32(2); x <= 6; x++ {
+		for z := int32(2); z <= 6; z++ {
+			for y := int32(64); y <= 66; y++ {
+				if x == 2 || x == 6 || z == 2 || z == 6 {
+					c.SetBlock(x, y, z, BlockCobblestone, 0)
+				}
+			}
+			c.SetBlock(x, 67, z, 5, 0)
+		}
+	}
+	c.SetBlock(6, 64, 4, BlockAir, 0)
+	c.SetBlock(6, 65, 4, BlockAir, 0)
+	c.SetBlock(4, 64, 4, BlockTorch, 5)
+	for x := int32(9); x <= 12; x++ {
+		for z := int32(10); z <= 13; z++ {
+			c.SetBlock(x, 63, z, BlockStillWater, 0)
+		}
+	}
+	c.SetBlock(9, 64, 4, BlockTorch, 5)
+	w.Chunks[MakeXZ(1, 0)].SetBlock(8, 64, 9, BlockTorch, 5)
+	return w
+}
+
+func TestRenderNightMap(t *testing.T) {
+	buf := new(bytes.Buffer)
+	if err := villageWorld().RenderNightMap(buf, NewRegion(0, 0, 1, 0), NightOptions{Relight: true}); err != nil {
+		t.Fatal(err)
+	}
+	m := decodePNG(t, buf)
+	if b := m.Bounds(); b.Dx() != 32 || b.Dy() != 16 {
+		t.Fatal("expected a 32x16 map, got ", b)
+	}
+	grass := blockColors[BlockGrass]
+	for _, p := range []struct {
+		px, py int
+		want   image.Color
+	}{
+		{9, 5, darken(grass, 13)}, // beside a torch
+		{24, 12, darken(grass, 11)},
+		{0, 15, darken(grass, 0)},
+		{4, 4, darken(blockColors[5], 5)}, // the roof, lit from outside the hut
+		{7, 4, darken(grass, 12)},         // outside the doorway
+		{7, 2, darken(grass, 10)},
+	} {
+		if got := m.At(p.px, p.py); !sameColor(got, p.want) {
+			t.Errorf("pixel (%d, %d): expected %v, got %v", p.px, p.py, p.want, got)
+		}
+	}
+
+	f, err := os.Open("testdata/night.png", os.O_RDONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	golden, err := png.Decode(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b := golden.Bounds(); b.Dx() != 32 || b.Dy() != 16 {
+		t.Fatal("expected testdata/night.png to be 32x16, got ", b)
+	}
+	for py := 0; py < 16; py++ {
+		for px := 0; px < 32; px++ {
+			if got, want := m.At(px, py), golden.At(px, py); !sameColor(got, want) {
+				t.Fatalf("pixel (%d, %d): expected %v, got %v", px, py, want, got)
+			}
+		}
+	}
+}
+
+func TestRenderNightMapMoonlight(t *testing.T) {
+	w := villageWorld()
+	c := w.Chunks[MakeXZ(0, 0)]
+	setNibble(c.Level.BlockLight, blockIndex(0, 64, 0), 11)
+	setNibble(c.Level.SkyLight, blockIndex(1, 64, 0), 15)
+	setNibble(c.Level.SkyLight, blockIndex(0, 64, 0), 15)
+	grass := blockColors[BlockGrass]
+	for _, test := range []struct {
+		opts             NightOptions
+		lit, open, torch byte
+	}{
+		{NightOptions{}, 11, 0, 0},
+		{NightOptions{Moonlight: true}, 11, MoonLight, 0},
+	} {
+		buf := new(bytes.Buffer)
+		if err := w.RenderNightMap(buf, NewRegion(0, 0, 0, 0), test.opts); err != nil {
+			t.Fatal(err)
+		}
+		m := decodePNG(t, buf)
+		for _, p := range []struct {
+			px, py int
+			light  byte
+		}{{0, 0, test.lit}, {1, 0, test.open}, {9, 5, test.torch}} {
+			if got, want := m.At(p.px, p.py), darken(grass, p.light); !sameColor(got, want) {
+				t.Errorf("%+v: pixel (%d, %d): expected light %d, got %v", test.opts, p.px, p.py, p.light, got)
+			}
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package world
+
+import "minecraft/error"
+
+import "bytes"
+import "fmt"
+import "io"
+import "os"
+import "strings"
+
+// SignMarkerOptions control which signs ExportSignMarkers writes.
+type SignMarkerOptions struct {
+	// Prefix, if set, keeps only the signs whose text starts with it, such as
+	// "[map]", so that players can choose which places are labelled.  It is
+	// removed from the text written, along with the spaces and line breaks that
+	// follow it.
+	Prefix string
+}
+
+// ExportSignMarkers writes the signs in region (nil meaning the whole world) to
+// w as a GeoJSON FeatureCollection of points, one feature to a line, for labelling
+// the maps RenderTiles draws.  The point of the sign at block (x, y, z) is
+// [x, z], which is the pixel that shows its block at zoom 0 counted from the
+// world's origin, as RenderTiles lays tiles out: at zoom n the sign lies at pixel
+// (x>>n, z>>n), which is in tile (x>>(8+n), z>>(8+n)).  Each feature's properties
+// are:
+//
+//	"text"   the sign's lines joined by line breaks, less any empty lines at the end
+//	"lines"  the four lines
+//	"x", "y", "z"  the sign's block
+//	"kind"   "post" for a sign on a post, block 63, or "wall" for one on a wall, 68
+//	"tile"   [x>>8, z>>8], the tile at zoom 0 holding the sign
+//
+// Signs whose block is neither kind of sign, which the game does not show, are
+// left out.  Chunks are read one at a time and none are loaded.
+func (world *World) ExportSignMarkers(w io.Writer, region *Region, opts SignMarkerOptions) (err os.Error) {
+	buf := bytes.NewBufferString(`{"type": "FeatureCollection", "features": [`)
+	first := true
+	scanErr := world.scanTileEntities(region, func(xz ChunkCoord, tes []TileEntity) bool {
+		var blocks []byte
+		for _, te := range tes {
+			sign, ok := te.(*Sign)
+			if !ok {
+				continue
+			}
+			lines := sign.Lines()
+			text := strings.Join(lines[:], "\n")
+			if !strings.HasPrefix(text, opts.Prefix) {
+				continue
+			}
+			text = strings.TrimLeft(text[len(opts.Prefix):], " \n")
+			text = strings.TrimRight(text, "\n")
+			if blocks == nil {
+				if blocks, err = world.readChunkBlocks(xz.X, xz.Z); err != nil {
+					return false
+				}
+			}
+			x, y, z := sign.X(), sign.Y(), sign.Z()
+			lx, lz := x-xz.X*ChunkWidth, z-xz.Z*ChunkDepth
+			if !inChunk(lx, y, lz) {
+				continue
+			}
+			var kind string
+			switch blocks[blockIndex(lx, y, lz)] {
+			case BlockSignPost:
+				kind = "post"
+			case BlockWallSign:
+				kind = "wall"
+			default:
+				continue
+			}
+			feature := jsonObject{
+				{"type", "Feature"},
+				{"geometry", jsonObject{{"type", "Point"}, {"coordinates", []interface{}{x, z}}}},
+				{"properties", jsonObject{
+					{"text", text},
+					{"lines", []interface{}{lines[0], lines[1], lines[2], lines[3]}},
+					{"x", x}, {"y", y}, {"z", z},
+					{"kind", kind},
+					{"tile", []interface{}{x >> 8, z >> 8}}, // TileSize is 256
+				}},
+			}
+			if first {
+				buf.WriteString("\n")
+				first = false
+			} else {
+				buf.WriteString(",\n")
+			}
+			if err = writeJSON(buf, feature); err != nil {
+				err = error.NewError(fmt.Sprintf("could not encode sign at (%d, %d, %d)", x, y, z), err)
+				return false
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return
+	}
+	if scanErr != nil {
+		return scanErr
+	}
+	if !first {
+		buf.WriteString("\n")
+	}
+	buf.WriteString("]}\n")
+	_, err = w.Write(buf.Bytes())
+	return
+}
@@ -0,0 +1,80 @@
+package world
+
+import "bytes"
+import "fmt"
+import "os"
+import "strings"
+import "testing"
+
+// signCompound returns the tags of a sign at (x, y, z) reading lines.
+func signCompound(x, y, z int32, lines ...string) map[string]interface{} {
+	tags := map[string]interface{}{"Text1": "", "Text2": "", "Text3": "", "Text4": ""}
+	for i, line := range lines {
+		tags[fmt.Sprint("Text", i+1)] = line
+	}
+	return tileEntityCompound("Sign", x, y, z, tags)
+}
+
+// makeMarkerWorld writes a world of chunks (16, 1), holding a sign on a post
+// at (260, 64, 17) and one on a wall at (271, 70, 31), and (-19, -1), holding a
+// sign on a post at (-300, 80, -5) and one at (-289, 80, -1) with no block
+// beneath it.
+func makeMarkerWorld(t *testing.T) string {
+	east := testChunkPayload(16, 1, nil, []interface{}{
+		signCompound(260, 64, 17, "[map]", "Spawn"),
+		signCompound(271, 70, 31, "Mine", "", "keep out"),
+		tileEntityCompound("Chest", 262, 64, 17, map[string]interface{}{"Items": []interface{}{}}),
+	})
+	west := testChunkPayload(-19, -1, nil, []interface{}{
+		signCompound(-300, 80, -5, "[map] Farm"),
+		signCompound(-289, 80, -1, "[map] Lost"),
+	})
+	east["Level"].(map[string]interface{})["Blocks"].([]byte)[blockIndex(4, 64, 1)] = BlockSignPost
+	east["Level"].(map[string]interface{})["Blocks"].([]byte)[blockIndex(15, 70, 15)] = BlockWallSign
+	west["Level"].(map[string]interface{})["Blocks"].([]byte)[blockIndex(4, 80, 11)] = BlockSignPost
+	return makeTestWorld(t, east, west)
+}
+
+func exportMarkers(t *testing.T, w *World, region *Region, opts SignMarkerOptions) string {
+	buf := new(bytes.Buffer)
+	if err := w.ExportSignMarkers(buf, region, opts); err != nil {
+		t.Fatal(err)
+	}
+	return buf.String()
+}
+
+func TestExportSignMarkers(t *testing.T) {
+	dir := makeMarkerWorld(t)
+	defer os.RemoveAll(dir)
+	w, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	if got, want := exportMarkers(t, w, nil, SignMarkerOptions{}), readGolden(t, "markers.json")+"\n"; got != want {
+		t.Errorf("expected\n%s\ngot\n%s", want, got)
+	}
+	if len(w.Chunks) != 0 {
+		t.Error("expected no chunks to be made resident, got ", len(w.Chunks))
+	}
+
+	got := exportMarkers(t, w, nil, SignMarkerOptions{Prefix: "[map]"})
+	if n := strings.Count(got, `"type": "Feature"`); n != 2 {
+		t.Fatalf("expected the 2 signs marked for the map, got %d in\n%s", n, got)
+	}
+	for _, want := range []string{
+		`"coordinates": [-300, -5]}, "properties": {"text": "Farm", `,
+		`"tile": [-2, -1]}`,
+		`"coordinates": [260, 17]}, "properties": {"text": "Spawn", `,
+		`"tile": [1, 0]}`,
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("expected %s in\n%s", want, got)
+		}
+	}
+
+	if got := exportMarkers(t, w, NewRegion(0, 0, 1, 1), SignMarkerOptions{}); got != `{"type": "FeatureCollection", "features": []}`+"\n" {
+		t.Error("expected no features, got ", got)
+	}
+}
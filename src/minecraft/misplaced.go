@@ -0,0 +1,143 @@
+package world
+
+import "math"
+import "os"
+
+// WorldLimit is how far from the origin, in blocks, the game lets anything go.
+const WorldLimit = 30000000
+
+// A Misplaced entity is held by a chunk other than the one containing its
+// position.  The EntityRef names the chunk that holds it; Absurd is set when the
+// position is not a number or lies beyond WorldLimit, so that it belongs nowhere.
+type Misplaced struct {
+	EntityRef
+	Position Position
+	Absurd   bool
+}
+
+// absurd reports whether p is not a position the game could have written.
+func (p Position) absurd() bool {
+	for _, v := range []float64{p.X, p.Y, p.Z} {
+		if math.IsNaN(v) || math.IsInf(v, 0) || math.Fabs(v) > WorldLimit {
+			return true
+		}
+	}
+	return false
+}
+
+// misplaced returns the entity at index i of chunk (cx, cz) if it does not
+// belong there.
+func misplaced(cx, cz int32, i int, e *Entity) (m Misplaced, ok bool) {
+	m = Misplaced{EntityRef{e, cx, cz, i}, e.Physics.Position, e.Physics.Position.absurd()}
+	if m.Absurd {
+		return m, true
+	}
+	x, z := m.Position.ChunkXZ()
+	return m, x != cx || z != cz
+}
+
+// ValidateEntities reports the entities in region (nil meaning the whole world)
+// that are held by the wrong chunk.  Chunks are scanned without being loaded.
+func (world *World) ValidateEntities(region *Region) (found []Misplaced, err os.Error) {
+	err = world.scanEntities(region, func(xz ChunkCoord, entities []*Entity) bool {
+		for i, e := range entities {
+			if m, ok := misplaced(xz.X, xz.Z, i, e); ok {
+				found = append(found, m)
+			}
+		}
+		return true
+	})
+	return
+}
+
+// RepairEntities moves the misplaced entities in region (nil meaning the whole
+// world) into the chunks containing their positions and deletes those with absurd
+// positions.  An entity whose chunk does not exist is moved into a new, empty
+// chunk if create is set, and otherwise left where it is.  The chunks entities
+// are moved to are written first, and only then are the entities taken from the
+// chunks holding them, which are written back as they are streamed, so that a
+// crash between leaves an entity in both rather than in neither.  Resident
+// chunks it is taken from are left dirty until Flush.
+func (world *World) RepairEntities(region *Region, create bool) (moved, deleted int, err os.Error) {
+	if err = world.VerifyLockNow(); err != nil {
+		return
+	}
+	var movers []*Entity
+	err = world.scanEntities(region, func(xz ChunkCoord, entities []*Entity) bool {
+		for i, e := range entities {
+			if world.repairAction(xz.X, xz.Z, i, e, create) == repairMove {
+				movers = append(movers, e)
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return
+	}
+	dests := make(map[XZ]*Chunk)
+	for _, e := range movers {
+		cx, cz := e.Physics.Position.ChunkXZ()
+		if !world.ChunkExists(cx, cz) {
+			world.keep(MakeXZ(cx, cz), newChunk(cx, cz))
+		}
+		var c *Chunk
+		if c, err = world.GetChunk(cx, cz); err != nil {
+			return
+		}
+		c.Lock()
+		c.Level.Entities = append(c.Level.Entities, e)
+		c.dirty = true
+		c.Unlock()
+		dests[MakeXZ(cx, cz)] = c
+	}
+	for _, c := range dests {
+		c.Lock()
+		if c.dirty {
+			err = world.saveChunk(c)
+		}
+		c.Unlock()
+		if err != nil {
+			return
+		}
+	}
+	moved = len(movers)
+
+	err = world.streamChunks("RepairEntities", region, func(c *Chunk) (bool, os.Error) {
+		kept := c.Level.Entities[:0]
+		n := len(c.Level.Entities)
+		for i, e := range c.Level.Entities {
+			switch world.repairAction(c.Level.XPos, c.Level.ZPos, i, e, create) {
+			case repairKeep:
+				kept = append(kept, e)
+			case repairDelete:
+				deleted++
+			}
+		}
+		c.Level.Entities = kept
+		return len(kept) < n, nil
+	})
+	return
+}
+
+// What RepairEntities does with an entity.
+const (
+	repairKeep = iota
+	repairMove
+	repairDelete
+)
+
+// repairAction returns what RepairEntities does with the entity at index i of
+// chunk (cx, cz).  It is the same before the entities are moved as after, as
+// only chunks they are moved to are created.
+func (world *World) repairAction(cx, cz int32, i int, e *Entity, create bool) int {
+	m, ok := misplaced(cx, cz, i, e)
+	switch {
+	case !ok:
+		return repairKeep
+	case m.Absurd:
+		return repairDelete
+	case create || world.ChunkExists(m.Position.ChunkXZ()):
+		return repairMove
+	}
+	return repairKeep
+}
@@ -0,0 +1,92 @@
+package world
+
+import "math"
+import "os"
+import "testing"
+
+func makeMisplacedWorld(t *testing.T) string {
+	return makeTestWorld(t,
+		testChunkPayload(0, 0, []interface{}{
+			itemAt(8, 64, 8),
+			itemAt(20, 64, 3),         // belongs in (1, 0)
+			itemAt(-5, 64, -5),        // belongs in (-1, -1), which does not exist
+			itemAt(math.NaN(), 64, 3), // nowhere
+			itemAt(4, 64, 4.5e7),      // beyond the world's edge
+		}, nil),
+		testChunkPayload(1, 0, []interface{}{itemAt(17, 64, 1)}, nil))
+}
+
+func TestValidateEntities(t *testing.T) {
+	dir := makeMisplacedWorld(t)
+	defer os.RemoveAll(dir)
+	w, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	found, err := w.ValidateEntities(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(found) != 4 {
+		t.Fatal("expected 4 misplaced entities, got ", found)
+	}
+	for i, m := range found {
+		if m.ChunkX != 0 || m.ChunkZ != 0 || m.Index != i+1 {
+			t.Errorf("entity %d: bad reference %v", i, m.EntityRef)
+		}
+		if m.Absurd != (i >= 2) {
+			t.Errorf("entity %d at %v: absurd=%v", i, m.Position, m.Absurd)
+		}
+	}
+	if len(w.Chunks) != 0 {
+		t.Error("validation loaded chunks")
+	}
+}
+
+func TestRepairEntities(t *testing.T) {
+	dir := makeMisplacedWorld(t)
+	defer os.RemoveAll(dir)
+	w, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	moved, deleted, err := w.RepairEntities(nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if moved != 1 || deleted != 2 {
+		t.Errorf("expected 1 moved and 2 deleted, got %d and %d", moved, deleted)
+	}
+	if c := w.Chunks[MakeXZ(1, 0)]; c == nil || len(c.Level.Entities) != 2 {
+		t.Error("entity not moved into chunk (1, 0)")
+	}
+	// the chunk moved to is written before the one moved from
+	level, err := w.readChunkLevel(1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if onDisk, _ := getList(level, "Entities"); len(onDisk) != 2 {
+		t.Errorf("expected the entity written to chunk (1, 0) on disk, got %d entities", len(onDisk))
+	}
+	if found, _ := w.ValidateEntities(nil); len(found) != 1 || found[0].Position.X != -5 {
+		t.Error("expected the entity without a chunk to stay, got ", found)
+	}
+
+	if moved, _, err = w.RepairEntities(nil, true); err != nil || moved != 1 {
+		t.Fatal("expected to move 1 entity into a new chunk, moved ", moved, ": ", err)
+	}
+	if err = w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if !w.ChunkExists(-1, -1) {
+		t.Error("chunk (-1, -1) not created")
+	}
+	w.Chunks = make(map[XZ]*Chunk)
+	if found, err := w.ValidateEntities(nil); err != nil || len(found) != 0 {
+		t.Error("expected no misplaced entities after repair, got ", found, err)
+	}
+}
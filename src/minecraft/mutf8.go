@@ -0,0 +1,108 @@
+// Java's modified UTF-8, which is how NBT and the wire protocol store strings.
+// It differs from UTF-8 in writing NUL as two bytes and characters outside the
+// Basic Multilingual Plane as a surrogate pair of three bytes each.
+
+package nbt
+
+import "minecraft/error"
+
+import "fmt"
+import "os"
+
+// EncodeModifiedUTF8 converts a UTF-8 string to modified UTF-8.
+func EncodeModifiedUTF8(s string) []byte {
+	b := make([]byte, 0, len(s))
+	for _, c := range s {
+		r := int(c)
+		switch {
+		case r >= 1 && r < 0x80:
+			b = append(b, byte(r))
+		case r < 0x800:
+			// includes NUL, which is never written as a zero byte
+			b = append(b, byte(0xc0|r>>6), byte(0x80|r&0x3f))
+		case r < 0x10000:
+			b = appendUnit(b, r)
+		default:
+			r -= 0x10000
+			b = appendUnit(b, 0xd800|r>>10)
+			b = appendUnit(b, 0xdc00|r&0x3ff)
+		}
+	}
+	return b
+}
+
+// appendUnit appends a UTF-16 code unit in its three byte form.
+func appendUnit(b []byte, u int) []byte {
+	return append(b, byte(0xe0|u>>12), byte(0x80|(u>>6)&0x3f), byte(0x80|u&0x3f))
+}
+
+// DecodeModifiedUTF8 converts modified UTF-8 to a UTF-8 string.  Unpaired
+// surrogates, which Java strings may hold but UTF-8 cannot, become U+FFFD.
+func DecodeModifiedUTF8(b []byte) (s string, err os.Error) {
+	if isASCII(b) {
+		// the same in both encodings, as tag names nearly always are
+		return string(b), nil
+	}
+	units := make([]int, 0, len(b))
+	for i := 0; i < len(b); {
+		c := int(b[i])
+		switch {
+		case c < 0x80:
+			units = append(units, c)
+			i++
+		case c&0xe0 == 0xc0:
+			if i+1 >= len(b) || b[i+1]&0xc0 != 0x80 {
+				return "", badModifiedUTF8(i)
+			}
+			units = append(units, (c&0x1f)<<6|int(b[i+1]&0x3f))
+			i += 2
+		case c&0xf0 == 0xe0:
+			if i+2 >= len(b) || b[i+1]&0xc0 != 0x80 || b[i+2]&0xc0 != 0x80 {
+				return "", badModifiedUTF8(i)
+			}
+			units = append(units, (c&0x0f)<<12|int(b[i+1]&0x3f)<<6|int(b[i+2]&0x3f))
+			i += 3
+		default:
+			return "", badModifiedUTF8(i)
+		}
+	}
+
+	out := make([]byte, 0, len(b))
+	for i := 0; i < len(units); i++ {
+		r := units[i]
+		switch {
+		case r >= 0xd800 && r < 0xdc00 && i+1 < len(units) && units[i+1] >= 0xdc00 && units[i+1] < 0xe000:
+			r = 0x10000 + (r-0xd800)<<10 + (units[i+1] - 0xdc00)
+			i++
+		case r >= 0xd800 && r < 0xe000:
+			r = 0xfffd
+		}
+		out = appendUTF8(out, r)
+	}
+	return string(out), nil
+}
+
+func isASCII(b []byte) bool {
+	for _, c := range b {
+		if c >= 0x80 {
+			return false
+		}
+	}
+	return true
+}
+
+func appendUTF8(b []byte, r int) []byte {
+	switch {
+	case r < 0x80:
+		return append(b, byte(r))
+	case r < 0x800:
+		return append(b, byte(0xc0|r>>6), byte(0x80|r&0x3f))
+	case r < 0x10000:
+		return append(b, byte(0xe0|r>>12), byte(0x80|(r>>6)&0x3f), byte(0x80|r&0x3f))
+	}
+	return append(b, byte(0xf0|r>>18), byte(0x80|(r>>12)&0x3f), byte(0x80|(r>>6)&0x3f), byte(0x80|r&0x3f))
+}
+
+func badModifiedUTF8(offset int) os.Error {
+	return error.NewError(fmt.Sprint("malformed modified UTF-8 at byte ", offset), nil)
+}
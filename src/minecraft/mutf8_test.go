@@ -0,0 +1,60 @@
+package nbt
+
+import "bytes"
+import "testing"
+
+var modifiedUTF8Tests = []struct {
+	s       string
+	encoded []byte
+}{
+	{"", []byte{}},
+	{"Notch", []byte("Notch")},
+	{"a\x00b", []byte{'a', 0xc0, 0x80, 'b'}},
+	{"é", []byte{0xc3, 0xa9}},
+	{"☃", []byte{0xe2, 0x98, 0x83}},
+	// U+1D11E MUSICAL SYMBOL G CLEF is the surrogate pair D834 DD1E
+	{"\U0001d11e", []byte{0xed, 0xa0, 0xb4, 0xed, 0xb4, 0x9e}},
+}
+
+func TestModifiedUTF8(t *testing.T) {
+	for _, test := range modifiedUTF8Tests {
+		if encoded := EncodeModifiedUTF8(test.s); !bytes.Equal(encoded, test.encoded) {
+			t.Errorf("%q: expected % x, got % x", test.s, test.encoded, encoded)
+		}
+		s, err := DecodeModifiedUTF8(test.encoded)
+		if err != nil || s != test.s {
+			t.Errorf("% x: expected %q, got %q (%v)", test.encoded, test.s, s, err)
+		}
+	}
+}
+
+func TestModifiedUTF8LoneSurrogate(t *testing.T) {
+	s, err := DecodeModifiedUTF8([]byte{'x', 0xed, 0xa0, 0xb4, 'y'})
+	if err != nil || s != "x�y" {
+		t.Errorf("expected %q, got %q (%v)", "x�y", s, err)
+	}
+}
+
+func TestModifiedUTF8Malformed(t *testing.T) {
+	for _, b := range [][]byte{{0xc3}, {0xe2, 0x98}, {0xff}, {0x80}, {0xe2, 0x28, 0xa1}} {
+		if s, err := DecodeModifiedUTF8(b); err == nil {
+			t.Errorf("% x: expected an error, got %q", b, s)
+		}
+	}
+}
+
+func TestStringRoundTrip(t *testing.T) {
+	for _, test := range modifiedUTF8Tests {
+		buf := new(bytes.Buffer)
+		if err := WriteString(buf, test.s); err != nil {
+			t.Fatal(err)
+		}
+		if buf.Len() != 2+len(test.encoded) {
+			t.Errorf("%q: expected %d bytes, got %d", test.s, 2+len(test.encoded), buf.Len())
+		}
+		s, err := ReadString(buf)
+		if err != nil || s != test.s {
+			t.Errorf("expected %q, got %q (%v)", test.s, s, err)
+		}
+	}
+}
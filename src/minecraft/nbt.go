@@ -6,11 +6,13 @@ package nbt
 
 import "minecraft/error"
 
+import "bytes"
 import "compress/gzip"
 import "fmt"
 import "io"
 import "math"
 import "os"
+import "sort"
 
 type TagType int8
 
@@ -46,51 +48,183 @@ func Load(file string) (name string, payload map[string]interface{}, err os.Erro
 	defer gz.Close()
 	nbtf, err := gzip.NewReader(gz)
 	if err != nil {
-		err = error.NewError("could not gunzip file", err)
+		err = error.InFile(file).Error("could not gunzip", err)
 		return
 	}
 	defer nbtf.Close()
 	name, payload, err = ReadTagCompound(nbtf)
 	if err != nil {
-		err = error.NewError("could not read compound tag", err)
+		err = error.InFile(file).Error("could not decode", err)
+		return
+	}
+	return
+}
+
+// A Filter decides which tags of a compound get decoded; the payloads of the
+// rest are skipped over.
+type Filter func(name string, ttype TagType) bool
+
+// LoadFiltered is like Load but only decodes the tags keep accepts.  keep is
+// consulted in every nested compound, so skipping the big byte arrays of a chunk
+// need not skip the compound holding them.
+func LoadFiltered(file string, keep Filter) (name string, payload map[string]interface{}, err os.Error) {
+	gz, err := os.Open(file, os.O_RDONLY, 0000)
+	if err != nil {
+		err = error.NewError("could not open file", err)
+		return
+	}
+	defer gz.Close()
+	if name, payload, err = DecodeFiltered(gz, keep); err != nil {
+		err = error.InFile(file).Error("could not decode", err)
+	}
+	return
+}
+
+// DecodeFiltered is like LoadFiltered but reads the gzipped contents of a file
+// from gz, so that a file read into memory can be decoded more than once.
+func DecodeFiltered(gz io.Reader, keep Filter) (name string, payload map[string]interface{}, err os.Error) {
+	nbtf, err := gzip.NewReader(gz)
+	if err != nil {
+		err = error.NewError("could not gunzip file", err)
+		return
+	}
+	defer nbtf.Close()
+	return ReadTagCompoundFiltered(nbtf, keep)
+}
+
+// ReadTagCompoundFiltered is like ReadTagCompound but only decodes the tags keep
+// accepts, as LoadFiltered does.
+func ReadTagCompoundFiltered(reader io.Reader, keep Filter) (name string, payload map[string]interface{}, err os.Error) {
+	d := NewDecoder(reader)
+	var tag NamedTag
+	if tag, err = d.ReadNamedTag(); err != nil {
+		err = error.AtOffset(d.Offset()).Error("could not read named tag", err)
+		return
+	}
+	if tag.Type != Compound {
+		err = (os.ErrorString)(fmt.Sprint("nbt.ReadTagCompoundFiltered: expected compound type, got ", tag.Type))
+		return
+	}
+	name = tag.Name
+	if payload, err = d.ReadCompoundFiltered(keep); err != nil {
+		err = error.AtOffset(d.Offset()).Error("could not read compound tag", err)
 		return
 	}
 	return
 }
 // It would be slightly more correct to take an io.Writer, but this is a convenience
 // function anyway.
+//
+// The file is written under a temporary name and renamed into place, so a failed
+// save never leaves a truncated file behind.
 func Save(file string, name string, payload map[string]interface{}) (err os.Error) {
-	panic("writeme")
+	tmp := file + ".tmp"
+	gz, err := os.Open(tmp, os.O_WRONLY|os.O_CREAT|os.O_TRUNC, 0644)
+	if err != nil {
+		err = error.NewError("could not create file", err)
+		return
+	}
+	defer func() {
+		if err != nil {
+			gz.Close()
+			os.Remove(tmp)
+			err = error.InFile(file).Error("could not save", err)
+		}
+	}()
+	nbtf, err := gzip.NewWriter(gz)
+	if err != nil {
+		err = error.NewError("could not gzip file", err)
+		return
+	}
+	if err = WriteTagCompound(nbtf, name, payload); err != nil {
+		err = error.NewError("could not write compound tag", err)
+		return
+	}
+	if err = nbtf.Close(); err != nil {
+		err = error.NewError("could not finish gzip stream", err)
+		return
+	}
+	if err = gz.Close(); err != nil {
+		err = error.NewError("could not close file", err)
+		return
+	}
+	if err = os.Rename(tmp, file); err != nil {
+		err = error.NewError("could not rename file into place", err)
+		return
+	}
+	return
+}
+
+// Equal reports whether two decoded payloads hold the same tags.  Compounds are
+// compared without regard to key order, floats by their bits, and empty lists are
+// equal whatever their element type.
+func Equal(a, b interface{}) bool {
+	switch av := a.(type) {
+	case int8, int16, int32, int64, string:
+		return a == b
+	case float32:
+		bv, ok := b.(float32)
+		return ok && math.Float32bits(av) == math.Float32bits(bv)
+	case float64:
+		bv, ok := b.(float64)
+		return ok && math.Float64bits(av) == math.Float64bits(bv)
+	case []byte:
+		bv, ok := b.([]byte)
+		return ok && bytes.Equal(av, bv)
+	case []interface{}:
+		bv, ok := b.([]interface{})
+		if !ok || len(av) != len(bv) {
+			return false
+		}
+		for i := range av {
+			if !Equal(av[i], bv[i]) {
+				return false
+			}
+		}
+		return true
+	case map[string]interface{}:
+		bv, ok := b.(map[string]interface{})
+		if !ok || len(av) != len(bv) {
+			return false
+		}
+		for k, v := range av {
+			w, ok := bv[k]
+			if !ok || !Equal(v, w) {
+				return false
+			}
+		}
+		return true
+	}
+	return false
 }
 
 // Named tag readers.
 
 func ReadNamedTag(reader io.Reader) (t NamedTag, err os.Error) {
-	var tag int8
-	if tag, err = ReadInt8(reader); err != nil {
-		err = error.NewError("could not read tag type", err)
+	return NewDecoder(reader).ReadNamedTag()
+}
+
+func WriteNamedTag(writer io.Writer, t NamedTag) (err os.Error) {
+	if err = WriteInt8(writer, int8(t.Type)); err != nil {
+		err = error.NewError("could not write tag type", err)
 		return
 	}
-	t.Type = TagType(tag)
 	if t.Type == End {
-		// end tags have no name; not even a bytelen of 0 for name
 		return
 	}
-	if t.Name, err = ReadString(reader); err != nil {
+	if err = WriteString(writer, t.Name); err != nil {
+		err = error.NewError("could not write tag name", err)
 		return
 	}
 	return
 }
 
-func WriteNamedTag(writer io.Writer, t NamedTag) (err os.Error) {
-	panic("writeme")
-}
-
 
 func ReadTagCompound(reader io.Reader) (name string, payload map[string]interface{}, err os.Error) {
+	d := NewDecoder(reader)
 	var tag NamedTag
-	if tag, err = ReadNamedTag(reader); err != nil {
-		err = error.NewError("could not read named tag", err)
+	if tag, err = d.ReadNamedTag(); err != nil {
+		err = error.AtOffset(d.Offset()).Error("could not read named tag", err)
 		return
 	}
 	name = tag.Name
@@ -98,69 +232,78 @@ func ReadTagCompound(reader io.Reader) (name string, payload map[string]interfac
 		err = (os.ErrorString)(fmt.Sprint("nbt.ReadTagCompound: expected compound type, got ", tag.Type))
 		return
 	}
-	if payload, err = ReadCompound(reader); err != nil {
-		error.NewError("could not read compound tag", err)
+	if payload, err = d.ReadCompound(); err != nil {
+		err = error.AtOffset(d.Offset()).Error("could not read compound tag", err)
 		return
 	}
 	return
 }
 
-func readPayload(reader io.Reader, ttype TagType) (payload interface{}, err os.Error) {
-	switch ttype {
-	case End:
-		err = (os.ErrorString)("nbt.readPayload: tag type End has no payload")
-	case Byte:
-		payload, err = ReadInt8(reader)
-		if err != nil {
-			err = error.NewError("could not read payload byte", err)
-		}
-	case Short:
-		payload, err = ReadInt16(reader)
-		if err != nil {
-			err = error.NewError("could not read payload short", err)
-		}
-	case Int:
-		payload, err = ReadInt32(reader)
-		if err != nil {
-			err = error.NewError("could not read payload int", err)
-		}
-	case Long:
-		payload, err = ReadInt64(reader)
-		if err != nil {
-			err = error.NewError("could not read payload long", err)
-		}
-	case Float:
-		payload, err = ReadFloat32(reader)
-		if err != nil {
-			err = error.NewError("could not read payload float", err)
-		}
-	case Double:
-		payload, err = ReadFloat64(reader)
-		if err != nil {
-			err = error.NewError("could not read payload double", err)
-		}
-	case ByteArray:
-		payload, err = ReadByteArray(reader)
-		if err != nil {
-			err = error.NewError("could not read payload byte array", err)
-		}
-	case String:
-		payload, err = ReadString(reader)
-		if err != nil {
-			err = error.NewError("could not read payload string", err)
-		}
-	case List:
-		payload, err = ReadList(reader)
-		if err != nil {
-			err = error.NewError("could not read payload list", err)
-		}
-	case Compound:
-		payload, err = ReadCompound(reader)
-		if err != nil {
-			err = error.NewError("could not read payload compound", err)
-		}
+func WriteTagCompound(writer io.Writer, name string, payload map[string]interface{}) (err os.Error) {
+	if err = WriteNamedTag(writer, NamedTag{Compound, name}); err != nil {
+		err = error.NewError("could not write named tag", err)
+		return
+	}
+	if err = WriteCompound(writer, payload); err != nil {
+		err = error.NewError("could not write compound tag", err)
+		return
+	}
+	return
+}
+
+// TypeOf returns the tag type a decoded payload is written as.
+func TypeOf(payload interface{}) (ttype TagType, err os.Error) {
+	switch payload.(type) {
+	case int8:
+		ttype = Byte
+	case int16:
+		ttype = Short
+	case int32:
+		ttype = Int
+	case int64:
+		ttype = Long
+	case float32:
+		ttype = Float
+	case float64:
+		ttype = Double
+	case []byte:
+		ttype = ByteArray
+	case string:
+		ttype = String
+	case []interface{}:
+		ttype = List
+	case map[string]interface{}:
+		ttype = Compound
+	default:
+		err = (os.ErrorString)(fmt.Sprintf("nbt.TypeOf: no tag type for %T", payload))
+	}
+	return
+}
+
+func writePayload(writer io.Writer, payload interface{}) (err os.Error) {
+	switch p := payload.(type) {
+	case int8:
+		err = WriteInt8(writer, p)
+	case int16:
+		err = WriteInt16(writer, p)
+	case int32:
+		err = WriteInt32(writer, p)
+	case int64:
+		err = WriteInt64(writer, p)
+	case float32:
+		err = WriteFloat32(writer, p)
+	case float64:
+		err = WriteFloat64(writer, p)
+	case []byte:
+		err = WriteByteArray(writer, p)
+	case string:
+		err = WriteString(writer, p)
+	case []interface{}:
+		err = WriteList(writer, p)
+	case map[string]interface{}:
+		err = WriteCompound(writer, p)
 	default:
-		err = (os.ErrorString)(fmt.Sprint("nbt.readPayload: unknown payload type ", ttype))
+		err = (os.ErrorString)(fmt.Sprintf("nbt.writePayload: cannot write %T", payload))
 	}
 	return
 }
@@ -193,22 +336,7 @@ func WriteBool(writer io.Writer, b bool) (err os.Error) {
 }
 
 func ReadByteArray(reader io.Reader) (b []byte, err os.Error) {
-	// Normally, we'd use the reader's buffer to read into.  However, it doesn't
-	// buy us much here, because it's essentially just scratch space and we need
-	// to return something that won't change after we return it.
-	var length int32
-	if length, err = ReadInt32(reader); err != nil {
-		err = error.NewError("could not read byte array's length", err)
-		return
-	}
-	if length < 0 {
-		err = error.NewError("byte array's length cannot be < 0", nil)
-	}
-	b = make([]byte, length)
-	if _, err = io.ReadFull(reader, b); err != nil {
-		err = error.NewError("could not read byte array", err)
-	}
-	return
+	return NewDecoder(reader).ReadByteArray()
 }
 
 
@@ -228,22 +356,49 @@ func WriteByteArray(writer io.Writer, b []byte) (err os.Error) {
 
 
 func ReadCompound(reader io.Reader) (c map[string]interface{}, err os.Error) {
-	c = make(map[string]interface{})
-	var tag NamedTag
-	for {
-		if tag, err = ReadNamedTag(reader); err != nil {
-			err = error.NewError("could not read named tag", err)
+	return NewDecoder(reader).ReadCompound()
+}
+
+// ReadCompoundFiltered reads a compound, decoding only the tags keep accepts.
+// Nested compounds are filtered the same way; lists are decoded whole.
+func ReadCompoundFiltered(reader io.Reader, keep Filter) (c map[string]interface{}, err os.Error) {
+	return NewDecoder(reader).ReadCompoundFiltered(keep)
+}
+
+type stringSlice []string
+
+func (s stringSlice) Len() int           { return len(s) }
+func (s stringSlice) Less(i, j int) bool { return s[i] < s[j] }
+func (s stringSlice) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+
+// WriteCompound writes the tags of c in sorted order, so equal compounds always
+// encode to the same bytes.
+func WriteCompound(writer io.Writer, c map[string]interface{}) (err os.Error) {
+	names := make(stringSlice, 0, len(c))
+	for name := range c {
+		names = append(names, name)
+	}
+	sort.Sort(names)
+	for _, name := range names {
+		var ttype TagType
+		if ttype, err = TypeOf(c[name]); err != nil {
+			err = error.NewError(fmt.Sprintf("could not write tag %q", name), err)
 			return
 		}
-		if tag.Type == End {
+		if err = WriteNamedTag(writer, NamedTag{ttype, name}); err != nil {
+			err = error.NewError("could not write named tag", err)
 			return
 		}
-		if c[tag.Name], err = readPayload(reader, tag.Type); err != nil {
-			err = error.NewError("could not read payload", err)
+		if err = writePayload(writer, c[name]); err != nil {
+			err = error.NewError(fmt.Sprintf("could not write payload of tag %q", name), err)
 			return
 		}
 	}
-	panic("shouldn't get here")
+	if err = WriteNamedTag(writer, NamedTag{End, ""}); err != nil {
+		err = error.NewError("could not write end tag", err)
+		return
+	}
+	return
 }
 
 func ReadFloat32(reader io.Reader) (f float32, err os.Error) {
@@ -379,35 +534,44 @@ func WriteInt64(writer io.Writer, i int64) (err os.Error) {
 }
 
 func ReadList(reader io.Reader) (l []interface{}, err os.Error) {
-	var ttypei8 int8
-	var llen int32
+	return NewDecoder(reader).ReadList()
+}
 
-	if ttypei8, err = ReadInt8(reader); err != nil {
-		err = error.NewError("could not read list type", err)
-		return
+// WriteList writes l as a list of its first element's type; every element must
+// have that type.  Empty lists are written as lists of bytes, as Minecraft does.
+func WriteList(writer io.Writer, l []interface{}) (err os.Error) {
+	ttype := Byte
+	if len(l) > 0 {
+		if ttype, err = TypeOf(l[0]); err != nil {
+			return
+		}
 	}
-	if llen, err = ReadInt32(reader); err != nil {
-		err = error.NewError("could not read list length", err)
+	if len(l) > math.MaxInt32 {
+		return (os.ErrorString)("nbt.WriteList: list was too long")
+	}
+	if err = WriteInt8(writer, int8(ttype)); err != nil {
+		err = error.NewError("could not write list type", err)
 		return
 	}
-	if llen < 0 {
-		err = error.NewError("list length cannot be < 0", nil)
+	if err = WriteInt32(writer, int32(len(l))); err != nil {
+		err = error.NewError("could not write list length", err)
 		return
 	}
-	ttype := TagType(ttypei8)
-	// FIXME: we need to make ReadListInt, ReadListCompound, etc...
-	l = make([]interface{}, int(llen))
-	for i := int32(0); i < llen; i++ {
-		var payload interface{}
-		if payload, err = readPayload(reader, ttype); err != nil {
-			err = error.NewError(fmt.Sprint("could not read list payload at index", i), nil)
+	for i, payload := range l {
+		var t TagType
+		if t, err = TypeOf(payload); err != nil || t != ttype {
+			err = error.NewError(fmt.Sprintf("list element %d is a %T, not the list's type", i, payload), err)
+			return
+		}
+		if err = writePayload(writer, payload); err != nil {
+			err = error.NewError(fmt.Sprint("could not write list payload at index ", i), err)
 			return
 		}
-		l[i] = payload
 	}
 	return
 }
 
+// ReadString reads a string stored in modified UTF-8.
 func ReadString(reader io.Reader) (s string, err os.Error) {
 	var strlen int16
 
@@ -422,15 +586,17 @@ func ReadString(reader io.Reader) (s string, err os.Error) {
 	if _, err = io.ReadFull(reader, strchars); err != nil {
 		return
 	}
-	s = string(strchars)
+	s, err = DecodeModifiedUTF8(strchars)
 	return
 }
 
+// WriteString writes s in modified UTF-8.
 func WriteString(writer io.Writer, s string) (err os.Error) {
 	var strlenui int16
 	var strlen int
 
-	strlen = len(s)
+	strchars := EncodeModifiedUTF8(s)
+	strlen = len(strchars)
 	if strlen > math.MaxInt16 {
 		return (os.ErrorString)("nbt.WriteString: string was too long")
 	}
@@ -438,10 +604,8 @@ func WriteString(writer io.Writer, s string) (err os.Error) {
 	if err = WriteInt16(writer, strlenui); err != nil {
 		return
 	}
-	strchars := ([]byte)(s)
 	if _, err = writer.Write(strchars); err != nil {
 		return
 	}
-	s = string(strchars)
 	return
 }
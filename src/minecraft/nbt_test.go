@@ -1,8 +1,15 @@
 package nbt
 
+import "minecraft/error"
+
 import "testing"
 import "bytes"
 import "compress/gzip"
+import "io"
+import "io/ioutil"
+import "math"
+import "os"
+import "path"
 import "reflect"
 
 func TestTestNbt(t *testing.T) {
@@ -11,6 +18,172 @@ func TestTestNbt(t *testing.T) {
 	})
 }
 
+var allTypesPayload = map[string]interface{}{
+	"byte":      int8(-128),
+	"short":     int16(32767),
+	"int":       int32(-2147483648),
+	"long":      int64(9223372036854775807),
+	"float":     float32(0.49823147),
+	"double":    float64(0.4931287132182315),
+	"nan":       float32(math.NaN()),
+	"bytearray": []byte{0, 1, 2, 254, 255},
+	"string":    "HELLO WORLD THIS IS A TEST STRING ÅÄÖ!",
+	"empty":     "",
+	"list":      []interface{}{int16(1), int16(2), int16(3)},
+	"emptylist": []interface{}{},
+	"compounds": []interface{}{
+		map[string]interface{}{"name": "Compound tag #0"},
+		map[string]interface{}{"name": "Compound tag #1"},
+	},
+	"nested": map[string]interface{}{
+		"egg": map[string]interface{}{"name": "Eggbert", "value": float32(0.5)},
+	},
+}
+
+func TestWriteReadRoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteTagCompound(&buf, "Level", allTypesPayload); err != nil {
+		t.Fatal(err)
+	}
+	name, payload, err := ReadTagCompound(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name != "Level" {
+		t.Error("expected Level, got ", name)
+	}
+	if !Equal(payload, allTypesPayload) {
+		t.Error("expected ", allTypesPayload, ", got ", payload)
+	}
+}
+
+func TestWriteIsDeterministic(t *testing.T) {
+	var a, b bytes.Buffer
+	WriteTagCompound(&a, "", allTypesPayload)
+	WriteTagCompound(&b, "", allTypesPayload)
+	if !bytes.Equal(a.Bytes(), b.Bytes()) {
+		t.Error("two encodings of the same compound differ")
+	}
+}
+
+func TestWriteListMixedTypes(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteList(&buf, []interface{}{int8(1), int16(2)}); err == nil {
+		t.Error("expected an error writing a list of mixed types")
+	}
+}
+
+func TestEqual(t *testing.T) {
+	cases := []struct {
+		a, b  interface{}
+		equal bool
+	}{
+		{int8(1), int8(1), true},
+		{int8(1), int16(1), false},
+		{[]byte{1, 2}, []byte{1, 2}, true},
+		{[]byte{1, 2}, []byte{1, 3}, false},
+		{[]interface{}{}, []interface{}(nil), true},
+		{[]interface{}{int32(1)}, []interface{}{int64(1)}, false},
+		{map[string]interface{}{"a": "b"}, map[string]interface{}{"a": "b"}, true},
+		{map[string]interface{}{"a": "b"}, map[string]interface{}{"a": "b", "c": "d"}, false},
+		{float64(math.NaN()), float64(math.NaN()), true},
+	}
+	for i, c := range cases {
+		if Equal(c.a, c.b) != c.equal {
+			t.Errorf("case %d: Equal(%v, %v) != %v", i, c.a, c.b, c.equal)
+		}
+	}
+}
+
+func TestSaveLoad(t *testing.T) {
+	dir, err := ioutil.TempDir("", "nbt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := path.Join(dir, "test.dat")
+	if err = Save(file, "hello world", allTypesPayload); err != nil {
+		t.Fatal(err)
+	}
+	name, payload, err := Load(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name != "hello world" || !Equal(payload, allTypesPayload) {
+		t.Error("expected ", allTypesPayload, ", got ", name, payload)
+	}
+	if _, err := os.Stat(file + ".tmp"); err == nil {
+		t.Error("temporary file left behind")
+	}
+}
+
+func TestLoadErrorContext(t *testing.T) {
+	dir, err := ioutil.TempDir("", "nbt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	var encoded bytes.Buffer
+	if err = WriteTagCompound(&encoded, "hello world", allTypesPayload); err != nil {
+		t.Fatal(err)
+	}
+	var truncated bytes.Buffer
+	gz, err := gzip.NewWriter(&truncated)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gz.Write(encoded.Bytes()[:20])
+	gz.Close()
+	file := path.Join(dir, "truncated.dat")
+	if err = ioutil.WriteFile(file, truncated.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	_, _, err = Load(file)
+	ctx := error.ContextOf(err)
+	if err == nil || ctx.Path != file || !ctx.HasOffset || ctx.Offset != 20 {
+		t.Errorf("expected the file and an offset of 20, got %+v from %v", ctx, err)
+	}
+	if error.RootCause(err) != io.ErrUnexpectedEOF {
+		t.Error("expected io.ErrUnexpectedEOF at the root, got ", error.RootCause(err))
+	}
+	if _, _, err = LoadFiltered(file, func(string, TagType) bool { return true }); error.ContextOf(err).Path != file {
+		t.Error("expected the file from LoadFiltered, got ", err)
+	}
+}
+
+func TestReadCompoundFiltered(t *testing.T) {
+	payload := map[string]interface{}{
+		"Level": map[string]interface{}{
+			"Blocks":   make([]byte, 32768),
+			"Entities": []interface{}{map[string]interface{}{"id": "Pig"}},
+			"xPos":     int32(-3),
+		},
+		"Data": []byte{1, 2, 3},
+	}
+	buf := new(bytes.Buffer)
+	if err := WriteCompound(buf, payload); err != nil {
+		t.Fatal(err)
+	}
+	skipArrays := func(name string, ttype TagType) bool { return ttype != ByteArray }
+	filtered, err := ReadCompoundFiltered(buf, skipArrays)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]interface{}{
+		"Level": map[string]interface{}{
+			"Entities": []interface{}{map[string]interface{}{"id": "Pig"}},
+			"xPos":     int32(-3),
+		},
+	}
+	if !Equal(filtered, expected) {
+		t.Error("expected ", expected, ", got ", filtered)
+	}
+	if buf.Len() != 0 {
+		t.Error("left ", buf.Len(), " bytes unread")
+	}
+}
+
 func xTestBigTestNbt(t *testing.T) {
 	// TODO: figure out why this fails.  it seems ok
 	testGZippedFile(t, bigtestnbt, "Level", map[string]interface{}{
@@ -0,0 +1,211 @@
+package world
+
+import "minecraft/error"
+
+import "bufio"
+import "fmt"
+import "io"
+import "os"
+import "sort"
+import "strconv"
+
+// OBJOptions control how ExportOBJ builds its mesh.
+type OBJOptions struct {
+	// Greedy merges neighbouring faces of the same material that face the same
+	// way into larger rectangles, which makes far fewer vertices for flat
+	// terrain.
+	Greedy bool
+	// MaterialLib is the name of the file the .mtl is written to, for the OBJ's
+	// mtllib statement; empty means "world.mtl".
+	MaterialLib string
+}
+
+// ExportOBJ writes the blocks of region (nil meaning every chunk in the world) as
+// a Wavefront OBJ mesh to objW and its materials to mtlW.  A vertex (x, y, z) is
+// the corner of block (x, y, z) at its least x, y and z, so that y is up, as in
+// the game, and coordinates are absolute.  Each face of a block that another
+// block does not hide, as RenderIsometric decides, is a quad wound
+// counter-clockwise seen from outside.  Faces on the region's edge are kept, so
+// the mesh is closed.
+//
+// Each block id gets a material named block_<id> with the diffuse color RenderMap
+// shows it in, except that the two water blocks share the material water and
+// leaves have the material leaves, so that their transparency can be set apart.
+// Translucent colors set the dissolve.
+//
+// Unlike RenderMap, every chunk of the region is held in memory while exporting.
+func (world *World) ExportOBJ(objW, mtlW io.Writer, region *Region, opts OBJOptions) (err os.Error) {
+	if region, err = world.extent(region); err != nil {
+		return err
+	}
+	t := world.begin("ExportOBJ", int(region.Width()*region.Depth()))
+	defer func() { t.end(err) }()
+	v, err := world.loadVolume(region, 0, ChunkHeight-1, t)
+	if err != nil {
+		return err
+	}
+	m := &objMesh{blockVolume: v, greedy: opts.Greedy, quads: make(map[string][]objQuad)}
+	m.build()
+
+	names := make([]string, 0, len(m.quads))
+	for name := range m.quads {
+		names = append(names, name)
+	}
+	sort.SortStrings(names)
+
+	lib := opts.MaterialLib
+	if lib == "" {
+		lib = "world.mtl"
+	}
+	ow := bufio.NewWriter(objW)
+	fmt.Fprintf(ow, "mtllib %s\n", lib)
+	n := 0
+	for _, name := range names {
+		fmt.Fprintf(ow, "usemtl %s\n", name)
+		for _, q := range m.quads[name] {
+			for _, c := range q {
+				fmt.Fprintf(ow, "v %d %d %d\n", c[0], c[1], c[2])
+			}
+			fmt.Fprintf(ow, "f %d %d %d %d\n", n+1, n+2, n+3, n+4)
+			n += 4
+		}
+	}
+	if err = ow.Flush(); err != nil {
+		return error.NewError("could not write OBJ", err)
+	}
+
+	mw := bufio.NewWriter(mtlW)
+	for i, name := range names {
+		if i > 0 {
+			mw.WriteString("\n")
+		}
+		c := blockColors[m.ids[name]]
+		fmt.Fprintf(mw, "newmtl %s\nKd %s %s %s\n", name, unit(c.R), unit(c.G), unit(c.B))
+		if c.A != 0xff {
+			fmt.Fprintf(mw, "d %s\n", unit(c.A))
+		}
+	}
+	if err = mw.Flush(); err != nil {
+		return error.NewError("could not write materials", err)
+	}
+	return nil
+}
+
+// unit formats a color component as a fraction of 1.
+func unit(v uint8) string {
+	return strconv.Ftoa64(float64(v)/0xff, 'f', 3)
+}
+
+// materialName returns the name of the material of blocks with the given id.
+func materialName(id byte) string {
+	switch id {
+	case BlockWater, BlockStillWater:
+		return "water"
+	case BlockLeaves:
+		return "leaves"
+	}
+	return fmt.Sprint("block_", id)
+}
+
+// objQuad is the four corners of a face, in absolute block coordinates.
+type objQuad [4][3]int
+
+// objMesh collects the faces of a volume by material.
+type objMesh struct {
+	*blockVolume
+	greedy bool
+	quads  map[string][]objQuad
+	ids    map[string]byte // a block id of each material, for its color
+}
+
+// build finds the visible faces, a layer of blocks at a time for each of the six
+// directions a face can point.
+func (m *objMesh) build() {
+	m.ids = make(map[string]byte)
+	size := [3]int{m.width, int(ChunkHeight), m.depth}
+	for axis := 0; axis < 3; axis++ {
+		// u and v are the other two axes, in the order that makes u x v point
+		// along axis
+		u, v := (axis+1)%3, (axis+2)%3
+		mask := make([]string, size[u]*size[v])
+		for _, dir := range []int{1, -1} {
+			for layer := 0; layer < size[axis]; layer++ {
+				for j := 0; j < size[v]; j++ {
+					for i := 0; i < size[u]; i++ {
+						var p [3]int
+						p[axis], p[u], p[v] = layer, i, j
+						mask[i+j*size[u]] = m.face(p, axis, dir)
+					}
+				}
+				plane := layer
+				if dir > 0 {
+					plane++
+				}
+				m.addQuads(mask, size[u], size[v], axis, u, v, plane, dir)
+			}
+		}
+	}
+}
+
+// face returns the material of the face of block p pointing dir along axis, or
+// "" if there is no block there or the face is hidden.
+func (m *objMesh) face(p [3]int, axis, dir int) string {
+	id := m.block(p[0], int32(p[1]), p[2])
+	if id == BlockAir {
+		return ""
+	}
+	p[axis] += dir
+	if hides(m.block(p[0], int32(p[1]), p[2]), id) {
+		return ""
+	}
+	name := materialName(id)
+	m.ids[name] = id
+	return name
+}
+
+// addQuads turns a layer's mask of face materials into quads in the given plane,
+// merging runs of the same material into rectangles if the mesh is greedy.  The
+// mask is consumed.
+func (m *objMesh) addQuads(mask []string, nu, nv, axis, u, v, plane, dir int) {
+	origin := [3]int{int(m.region.MinX) * ChunkWidth, 0, int(m.region.MinZ) * ChunkDepth}
+	corner := func(i, j int) (c [3]int) {
+		c[axis], c[u], c[v] = plane, i, j
+		for k := range c {
+			c[k] += origin[k]
+		}
+		return
+	}
+	for j := 0; j < nv; j++ {
+		for i := 0; i < nu; i++ {
+			name := mask[i+j*nu]
+			if name == "" {
+				continue
+			}
+			w, h := 1, 1
+			if m.greedy {
+				for i+w < nu && mask[i+w+j*nu] == name {
+					w++
+				}
+			grow:
+				for j+h < nv {
+					for k := i; k < i+w; k++ {
+						if mask[k+(j+h)*nu] != name {
+							break grow
+						}
+					}
+					h++
+				}
+			}
+			for y := j; y < j+h; y++ {
+				for x := i; x < i+w; x++ {
+					mask[x+y*nu] = ""
+				}
+			}
+			q := objQuad{corner(i, j), corner(i+w, j), corner(i+w, j+h), corner(i, j+h)}
+			if dir < 0 {
+				q[1], q[3] = q[3], q[1]
+			}
+			m.quads[name] = append(m.quads[name], q)
+		}
+	}
+}
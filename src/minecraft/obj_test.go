@@ -0,0 +1,137 @@
+package world
+
+import "bytes"
+import "strconv"
+import "strings"
+import "testing"
+
+// objWorld returns chunks (0, 0) to (1, 1): a slab of stone one block thick at
+// y=0 over all four, a 2x2 pool of water on it at (4..5, 1, 4..5) and a block of
+// leaves at (20, 1, 20).
+func objWorld() *World {
+	w := &World{Chunks: make(map[XZ]*Chunk)}
+	for cx := int32(0); cx < 2; cx++ {
+		for cz := int32(0); cz < 2; cz++ {
+			c := newChunk(cx, cz)
+			for x := int32(0); x < ChunkWidth; x++ {
+				for z := int32(0); z < ChunkDepth; z++ {
+					c.SetBlock(x, 0, z, BlockStone, 0)
+				}
+			}
+			w.Chunks[MakeXZ(cx, cz)] = c
+		}
+	}
+	c := w.Chunks[MakeXZ(0, 0)]
+	for x := int32(4); x < 6; x++ {
+		for z := int32(4); z < 6; z++ {
+			c.SetBlock(x, 1, z, BlockStillWater, 0)
+		}
+	}
+	w.Chunks[MakeXZ(1, 1)].SetBlock(4, 1, 4, BlockLeaves, 0)
+	return w
+}
+
+// objFaces parses an OBJ, returning its faces' corners by material and its
+// number of vertices.
+func objFaces(t *testing.T, obj string) (faces map[string][][4][3]float64, vertices int) {
+	faces = make(map[string][][4][3]float64)
+	var vs [][3]float64
+	material := ""
+	for _, line := range strings.Split(strings.TrimRight(obj, "\n"), "\n", -1) {
+		fields := strings.Fields(line)
+		switch fields[0] {
+		case "usemtl":
+			material = fields[1]
+		case "v":
+			var v [3]float64
+			for i := range v {
+				v[i], _ = strconv.Atof64(fields[i+1])
+			}
+			vs = append(vs, v)
+		case "f":
+			var f [4][3]float64
+			for i := range f {
+				n, err := strconv.Atoi(fields[i+1])
+				if err != nil || n < 1 || n > len(vs) {
+					t.Fatal("bad face: ", line)
+				}
+				f[i] = vs[n-1]
+			}
+			faces[material] = append(faces[material], f)
+		}
+	}
+	return faces, len(vs)
+}
+
+// normal returns the unnormalized normal of a quad wound counter-clockwise.
+func normal(f [4][3]float64) (n [3]float64) {
+	var a, b [3]float64
+	for i := range a {
+		a[i], b[i] = f[1][i]-f[0][i], f[2][i]-f[0][i]
+	}
+	return [3]float64{a[1]*b[2] - a[2]*b[1], a[2]*b[0] - a[0]*b[2], a[0]*b[1] - a[1]*b[0]}
+}
+
+func TestExportOBJ(t *testing.T) {
+	for _, c := range []struct {
+		greedy       bool
+		vertices     int
+		stone, water int
+		leaves       int
+	}{
+		// stone: 1023 tops, as the leaves hide one, 1024 bottoms and 4*32 sides;
+		// water: 4 tops and 8 sides, as it hides itself and stone hides its bottom;
+		// leaves: a top and 4 sides
+		{false, 4 * 2192, 2175, 12, 5},
+		// stone: 4 quads of top around the leaves, a bottom and 4 sides; water
+		// and leaves a top and 4 sides each
+		{true, 4 * 19, 9, 5, 5},
+	} {
+		obj, mtl := new(bytes.Buffer), new(bytes.Buffer)
+		if err := objWorld().ExportOBJ(obj, mtl, NewRegion(0, 0, 1, 1), OBJOptions{Greedy: c.greedy}); err != nil {
+			t.Fatal(err)
+		}
+		if !strings.HasPrefix(obj.String(), "mtllib world.mtl\n") {
+			t.Error("expected an mtllib statement first")
+		}
+		faces, vertices := objFaces(t, obj.String())
+		if vertices != c.vertices {
+			t.Errorf("greedy %v: expected %d vertices, got %d", c.greedy, c.vertices, vertices)
+		}
+		if len(faces["block_1"]) != c.stone || len(faces["water"]) != c.water || len(faces["leaves"]) != c.leaves || len(faces) != 3 {
+			t.Errorf("greedy %v: expected %d stone, %d water and %d leaves faces, got %d, %d and %d of %d materials", c.greedy,
+				c.stone, c.water, c.leaves, len(faces["block_1"]), len(faces["water"]), len(faces["leaves"]), len(faces))
+		}
+		for _, f := range faces["block_1"] {
+			n := normal(f)
+			if f[0][1] == 1 && f[1][1] == 1 && f[2][1] == 1 && n[1] <= 0 || f[0][1] == 0 && f[1][1] == 0 && f[2][1] == 0 && n[1] >= 0 {
+				t.Fatalf("greedy %v: face %v is wound inward", c.greedy, f)
+			}
+		}
+		if !strings.Contains(mtl.String(), "newmtl water\nKd 0.184 0.310 0.839\nd 0.565\n") {
+			t.Errorf("expected a translucent water material, got\n%s", mtl.String())
+		}
+		if !strings.Contains(mtl.String(), "newmtl block_1\nKd 0.490 0.490 0.490\n\n") {
+			t.Errorf("expected an opaque stone material, got\n%s", mtl.String())
+		}
+	}
+}
+
+func TestExportOBJMaterialLib(t *testing.T) {
+	obj := new(bytes.Buffer)
+	if err := objWorld().ExportOBJ(obj, new(bytes.Buffer), NewRegion(1, 1, 1, 1), OBJOptions{Greedy: true, MaterialLib: "farm.mtl"}); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(obj.String(), "mtllib farm.mtl\n") {
+		t.Error("expected mtllib farm.mtl, got ", strings.Split(obj.String(), "\n", 2)[0])
+	}
+	// corners are absolute: the slab of chunk (1, 1) spans (16, 0, 16) to (32, 1, 32)
+	faces, _ := objFaces(t, obj.String())
+	for _, f := range faces["block_1"] {
+		for _, c := range f {
+			if c[0] < 16 || c[0] > 32 || c[2] < 16 || c[2] > 32 || c[1] < 0 || c[1] > 1 {
+				t.Fatal("corner outside chunk (1, 1): ", c)
+			}
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package world
+
+import "minecraft/error"
+
+import "os"
+import "sort"
+
+// Config is how a World was opened: the Options given to Open, resolved.  The
+// zero Config is Open's behavior without options.
+type Config struct {
+	ReadOnly            bool       // see ReadOnly
+	CreateLockIfMissing bool       // see CreateLockIfMissing
+	Strict              bool       // see Strict
+	CacheSize           int        // see CacheSize; zero if not given
+	Store               ChunkStore // see Store; nil if not given
+	MapRegions          bool       // see MapRegions
+}
+
+// An Option changes how Open opens a world.
+type Option func(config *Config)
+
+// ReadOnly opens the world without taking its session lock, so that another
+// process, the game, say, keeps it.  Chunks and level.dat are read as usual,
+// but Flush, SaveLevel and the other methods that write to the world fail with
+// ErrReadOnly.
+func ReadOnly() Option {
+	return func(config *Config) { config.ReadOnly = true }
+}
+
+// CreateLockIfMissing creates the world's session lock if it has none, rather
+// than taking the world for not being one.  It cannot be given with ReadOnly.
+func CreateLockIfMissing() Option {
+	return func(config *Config) { config.CreateLockIfMissing = true }
+}
+
+// Strict makes loading a chunk fail with ErrCorruptChunk where it would
+// otherwise succeed with Warnings, such as for a malformed tile entity.  The
+// warnings LoadArrays adds to chunks loaded with LazyArrays are left as they
+// are.
+func Strict() Option {
+	return func(config *Config) { config.Strict = true }
+}
+
+// CacheSize sets MaxResident to n, bounding how many chunks UnloadExcessChunks
+// leaves resident.
+func CacheSize(n int) Option {
+	return func(config *Config) { config.CacheSize = n }
+}
+
+// Store keeps the world's chunks in store rather than in the store of the
+// format Open finds them in; the world's directory still holds its level.dat
+// and session lock.  With a MemoryStore, nothing Flush writes reaches the
+// disk until it is copied there with CopyChunks.
+func Store(store ChunkStore) Option {
+	return func(config *Config) { config.Store = store }
+}
+
+// MapRegions reads the chunks of a McRegion or Anvil world through memory maps
+// of its region files, where the system can map them, rather than reading
+// each chunk's sectors into a buffer of its own, and decodes them straight
+// from the mapped files.  Chunks are written as usual; a file is unmapped
+// before it is written to, and mapped again by the next read.  Worlds of other
+// formats, and those given a Store, are read as usual.
+func MapRegions() Option {
+	return func(config *Config) { config.MapRegions = true }
+}
+
+// validate returns an error if config asks for options that cannot be had
+// together.
+func (config Config) validate() os.Error {
+	if config.ReadOnly && config.CreateLockIfMissing {
+		return error.NewError("a read-only world cannot create its session lock", nil)
+	}
+	if config.CacheSize < 0 {
+		return error.NewError("cache size cannot be < 0", nil)
+	}
+	return nil
+}
+
+// Config returns the options the World was opened with.
+func (world *World) Config() Config {
+	return world.config
+}
+
+// errReadOnly is returned by the methods that write to a world opened with
+// ReadOnly.
+var errReadOnly = error.NewError("world was opened read-only", ErrReadOnly)
+
+// strict passes on c and err, the result of loading a chunk, unless the world
+// was opened Strict and c has Warnings, when it returns the first of them as
+// ErrCorruptChunk instead.
+func (world *World) strict(c *Chunk, err os.Error) (*Chunk, os.Error) {
+	if err != nil || !world.config.Strict || len(c.Warnings) == 0 {
+		return c, err
+	}
+	return nil, error.InChunk(c.Level.XPos, c.Level.ZPos).Error("could not load chunk", error.Wrap(ErrCorruptChunk, c.Warnings[0]))
+}
+
+// byUse sorts chunks by when they were last used, least recently first.
+type byUse struct {
+	chunks []*Chunk
+	used   []int64
+}
+
+func (s byUse) Len() int           { return len(s.chunks) }
+func (s byUse) Less(i, j int) bool { return s.used[i] < s.used[j] }
+func (s byUse) Swap(i, j int) {
+	s.chunks[i], s.chunks[j] = s.chunks[j], s.chunks[i]
+	s.used[i], s.used[j] = s.used[j], s.used[i]
+}
+
+// UnloadExcessChunks unloads the least recently used resident chunks, as
+// UnloadChunk does, until no more than MaxResident are left, and returns how
+// many it unloaded.  It is never called for the caller, as chunks unloaded
+// while other goroutines use them would stop being written; call it when none
+// are.  As it takes each chunk's Lock, it must not be called while holding one.
+func (world *World) UnloadExcessChunks() (n int, err os.Error) {
+	if world.MaxResident <= 0 {
+		return
+	}
+	var s byUse
+	resident := world.residentChunks()
+	world.idleMu.Lock()
+	for _, c := range resident {
+		if c != nil {
+			s.chunks = append(s.chunks, c)
+			s.used = append(s.used, c.used)
+		}
+	}
+	world.idleMu.Unlock()
+	chunks := s.chunks
+	if len(chunks) <= world.MaxResident {
+		return
+	}
+	sort.Sort(s)
+	for _, c := range chunks[:len(chunks)-world.MaxResident] {
+		if err = world.UnloadChunk(c.Level.XPos, c.Level.ZPos); err != nil {
+			return
+		}
+		n++
+	}
+	return
+}
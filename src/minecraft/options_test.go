@@ -0,0 +1,213 @@
+package world
+
+import "bytes"
+import "io/ioutil"
+import "os"
+import "path"
+import "testing"
+
+func TestOpenOptions(t *testing.T) {
+	dir := makeTestWorld(t)
+	defer os.RemoveAll(dir)
+
+	w, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c := w.Config(); c.ReadOnly || c.CreateLockIfMissing || c.Strict || c.CacheSize != 0 || w.MaxResident != 0 {
+		t.Errorf("expected the zero Config without options, got %+v", w.Config())
+	}
+	w.Close()
+
+	if _, err = Open(dir, ReadOnly(), CreateLockIfMissing()); err == nil {
+		t.Error("expected an error for ReadOnly with CreateLockIfMissing")
+	}
+	if _, err = Open(dir, CacheSize(-1)); err == nil {
+		t.Error("expected an error for a negative cache size")
+	}
+	if w, err = Open(dir, Strict(), CacheSize(10)); err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	if c := w.Config(); !c.Strict || c.CacheSize != 10 || c.ReadOnly || w.MaxResident != 10 {
+		t.Errorf("expected Strict and a cache size of 10, got %+v", c)
+	}
+}
+
+func TestReadOnly(t *testing.T) {
+	dir := makeTestWorld(t, testChunkPayload(0, 0, nil, nil))
+	defer os.RemoveAll(dir)
+	lockPath := path.Join(dir, sessionlock)
+	before, err := ioutil.ReadFile(lockPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w, err := Open(dir, ReadOnly())
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := w.GetChunk(0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if after, _ := ioutil.ReadFile(lockPath); !bytes.Equal(before, after) {
+		t.Error("expected the session lock left alone")
+	}
+	c.SetBlock(1, 64, 1, BlockStone, 0)
+	if err = w.Flush(); !IsError(err, ErrReadOnly) {
+		t.Error("expected ErrReadOnly from Flush, got ", err)
+	}
+	if err = w.SaveLevel(); !IsError(err, ErrReadOnly) {
+		t.Error("expected ErrReadOnly from SaveLevel, got ", err)
+	}
+	if err = w.StartAutoFlush(1e9, 0); !IsError(err, ErrReadOnly) {
+		t.Error("expected ErrReadOnly from StartAutoFlush, got ", err)
+	}
+	if id := blockOnDisk(w, 1, 64, 1); id != BlockAir {
+		t.Errorf("expected nothing written, got %d", id)
+	}
+
+	// the game opening the world does not stop a read-only World reading it
+	if err = ioutil.WriteFile(lockPath, make([]byte, 8), 0644); err != nil {
+		t.Fatal(err)
+	}
+	w.drop(MakeXZ(0, 0))
+	if _, err = w.GetChunk(0, 0); err != nil {
+		t.Error("expected chunks read whoever holds the lock, got ", err)
+	}
+	if err = w.Close(); err != nil {
+		t.Error(err)
+	}
+}
+
+// TestReadOnlyStreaming streams a read-only world, which fails only once a
+// chunk is to be written.
+func TestReadOnlyStreaming(t *testing.T) {
+	dir := makeTestWorld(t, testChunkPayload(0, 0, []interface{}{pigFixture}, nil))
+	defer os.RemoveAll(dir)
+	w, err := Open(dir, ReadOnly())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	pigs := func(e *Entity) bool { return e.Id == "Pig" }
+	if n, err := w.RemoveEntities(func(e *Entity) bool { return false }, nil); err != nil || n != 0 {
+		t.Errorf("expected nothing removed, got %d (%v)", n, err)
+	}
+	if err = w.ForEachEntity(nil, func(x, z int32, e *Entity) EntityAction { return Keep }); err != nil {
+		t.Error("expected entities visited, got ", err)
+	}
+	if _, err = w.RemoveEntities(pigs, nil); !IsError(err, ErrReadOnly) {
+		t.Error("expected ErrReadOnly from RemoveEntities, got ", err)
+	}
+	if err = w.ForEachEntity(nil, func(x, z int32, e *Entity) EntityAction { return Delete }); !IsError(err, ErrReadOnly) {
+		t.Error("expected ErrReadOnly from ForEachEntity, got ", err)
+	}
+	if counts, err := w.EntityCensus(nil, CensusOptions{}); err != nil || counts["Pig"] != 1 {
+		t.Errorf("expected the pig left on disk, got %v (%v)", counts, err)
+	}
+}
+
+func TestCreateLockIfMissing(t *testing.T) {
+	dir := makeTestWorld(t)
+	defer os.RemoveAll(dir)
+	lockPath := path.Join(dir, sessionlock)
+	if err := os.Remove(lockPath); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Open(dir); !IsError(err, ErrWorldNotFound) {
+		t.Error("expected ErrWorldNotFound without a session lock, got ", err)
+	}
+	w, err := Open(dir, CreateLockIfMissing())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	if fi, err := os.Stat(lockPath); err != nil || fi.Size != 8 {
+		t.Error("expected a session lock written, got ", err)
+	}
+	if err = w.VerifyLockNow(); err != nil {
+		t.Error("expected the created lock held, got ", err)
+	}
+}
+
+func TestStrict(t *testing.T) {
+	dir := makeTestWorld(t, testChunkPayload(-1, 0, nil, []interface{}{spawnerFixture}))
+	defer os.RemoveAll(dir)
+
+	for _, lazy := range []bool{false, true} {
+		w, err := Open(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.LazyArrays = lazy
+		c, err := w.GetChunk(-1, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c.LoadArrays()
+		if len(c.Warnings) != 1 {
+			t.Errorf("lazy %v: expected a warning when lenient, got %v", lazy, c.Warnings)
+		}
+		w.Close()
+
+		if w, err = Open(dir, Strict()); err != nil {
+			t.Fatal(err)
+		}
+		w.LazyArrays = lazy
+		if _, err = w.GetChunk(-1, 0); !lazy && !IsError(err, ErrCorruptChunk) {
+			t.Error("expected ErrCorruptChunk when strict, got ", err)
+		} else if lazy && err != nil {
+			// the spawner's block is checked only once the arrays are decoded
+			t.Error("expected the lazy chunk loaded, got ", err)
+		}
+		if _, _, err = w.LoadChunksParallel([]XZ{MakeXZ(-1, 0)}, 1); !lazy && !IsError(err, ErrCorruptChunk) {
+			t.Error("expected ErrCorruptChunk loading in parallel, got ", err)
+		}
+		w.Close()
+	}
+}
+
+func TestCacheSize(t *testing.T) {
+	dir := makeTestWorld(t,
+		testChunkPayload(0, 0, nil, nil),
+		testChunkPayload(1, 0, nil, nil),
+		testChunkPayload(2, 0, nil, nil))
+	defer os.RemoveAll(dir)
+	w, err := Open(dir, CacheSize(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	for _, x := range []int32{0, 1, 2, 0} {
+		if _, err = w.GetChunk(x, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	c := w.Chunks[MakeXZ(2, 0)]
+	c.Lock()
+	c.SetBlock(1, 64, 1, BlockStone, 0)
+	c.Unlock()
+	if err = w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if len(w.Chunks) != 3 {
+		t.Fatalf("expected 3 chunks resident after a flush, got %d", len(w.Chunks))
+	}
+	if n, err := w.UnloadExcessChunks(); n != 1 || err != nil {
+		t.Errorf("expected 1 chunk unloaded, got %d, %v", n, err)
+	}
+	if len(w.Chunks) != 2 || w.Chunks[MakeXZ(1, 0)] != nil {
+		t.Errorf("expected chunk (1, 0), the least recently used, unloaded, got %v", w.Chunks)
+	}
+	if c = w.Chunks[MakeXZ(2, 0)]; c == nil || c.Dirty() {
+		t.Error("expected chunk (2, 0) written and kept")
+	}
+	if n, err := w.UnloadExcessChunks(); n != 0 || err != nil {
+		t.Errorf("expected nothing more to unload, got %d, %v", n, err)
+	}
+}
@@ -0,0 +1,128 @@
+package world
+
+import "minecraft/error"
+import "minecraft/nbt"
+
+import "fmt"
+import "io"
+import "os"
+
+// An OreDistribution counts ores by height, as returned by World.OreDistribution.
+type OreDistribution struct {
+	// Ores are the block ids counted, in the order asked for.
+	Ores []byte
+	// Counts holds the number of blocks of each ore at each height.
+	Counts map[byte][ChunkHeight]int64
+	// Rock holds the number of stone blocks at each height, together with the
+	// ores counted, which take the place of stone.
+	Rock [ChunkHeight]int64
+}
+
+// OreDistribution counts the blocks of each of oreIDs in region (nil meaning the
+// whole world) at each height, along with the rock around them.  Only the block
+// ids of chunks that are not resident are read from disk, and they are not kept.
+func (world *World) OreDistribution(region *Region, oreIDs []byte) (*OreDistribution, os.Error) {
+	d := &OreDistribution{Counts: make(map[byte][ChunkHeight]int64)}
+	var ore [256]int // index into counts plus one, by block id
+	for _, id := range oreIDs {
+		if ore[id] == 0 {
+			d.Ores = append(d.Ores, id)
+			ore[id] = len(d.Ores)
+		}
+	}
+	counts := make([][ChunkHeight]int64, len(d.Ores))
+
+	coords, err := world.ListChunks(region)
+	if err != nil {
+		return nil, err
+	}
+	for _, xz := range coords {
+		blocks, err := world.readChunkBlocks(xz.X, xz.Z)
+		if err != nil {
+			return nil, err
+		}
+		for i, id := range blocks {
+			y := i % ChunkHeight
+			if n := ore[id]; n > 0 {
+				counts[n-1][y]++
+				d.Rock[y]++
+			} else if id == BlockStone {
+				d.Rock[y]++
+			}
+		}
+	}
+	for i, id := range d.Ores {
+		d.Counts[id] = counts[i]
+	}
+	return d, nil
+}
+
+func keepBlocks(name string, ttype nbt.TagType) bool {
+	return name == "Level" && ttype == nbt.Compound || name == "Blocks" && ttype == nbt.ByteArray
+}
+
+// readChunkBlocks returns the block ids of the chunk at (x, z), decoding nothing
+// else of it if it is not resident.
+func (world *World) readChunkBlocks(x, z int32) ([]byte, os.Error) {
+	if c, ok := world.resident(MakeXZ(x, z)); ok {
+		if err := c.LoadArrays(); err != nil {
+			return nil, err
+		}
+		return c.Level.Blocks, nil
+	}
+	_, chunkmap, err := world.loadChunkMap(x, z, keepBlocks)
+	if err != nil {
+		return nil, err
+	}
+	level, err := getCompound(chunkmap, "Level")
+	if err != nil {
+		return nil, error.InChunk(x, z).Error("malformed chunk", err)
+	}
+	blocks, ok := level["Blocks"].([]byte)
+	if !ok {
+		return nil, error.InChunk(x, z).Error("malformed chunk", tagError("Blocks", "byte array", level["Blocks"]))
+	}
+	if len(blocks) != chunkBlocks {
+		return nil, error.InChunk(x, z).Error(fmt.Sprint(len(blocks), " blocks in chunk"), nil)
+	}
+	return blocks, nil
+}
+
+// Normalized returns the share of the rock at each height that is the ore with
+// the given id, so that worlds of different sizes or depths of digging can be
+// compared.  Heights without rock are 0.
+func (d *OreDistribution) Normalized(id byte) (shares [ChunkHeight]float64) {
+	counts := d.Counts[id]
+	for y, rock := range d.Rock {
+		if rock > 0 {
+			shares[y] = float64(counts[y]) / float64(rock)
+		}
+	}
+	return
+}
+
+// WriteCSV writes the distribution as CSV for plotting: a header row, then a row
+// of ore id, height and count for every ore and height, ores in the order asked
+// for and heights from 0 up.  If normalized is set, the last column is the share
+// Normalized gives instead of the count, and is headed share.
+func (d *OreDistribution) WriteCSV(w io.Writer, normalized bool) os.Error {
+	cw := newCSVWriter(w)
+	if normalized {
+		cw.row("ore", "y", "share")
+	} else {
+		cw.row("ore", "y", "count")
+	}
+	for _, id := range d.Ores {
+		counts, shares := d.Counts[id], d.Normalized(id)
+		for y := 0; y < ChunkHeight; y++ {
+			value := fmt.Sprint(counts[y])
+			if normalized {
+				value = csvFloat(shares[y])
+			}
+			if !cw.row(fmt.Sprint(id), fmt.Sprint(y), value) {
+				break
+			}
+		}
+	}
+	return cw.flush()
+}
@@ -0,0 +1,90 @@
+package world
+
+import "bytes"
+import "os"
+import "strings"
+import "testing"
+
+// makeOreWorld writes chunks (0, 0) and (1, 0) of stone up to y=63, with three
+// coal ore at y=40 across the two, diamond ore at y=12 and y=5, and iron ore at
+// y=30.
+func makeOreWorld(t *testing.T) string {
+	var chunks []map[string]interface{}
+	for cx := int32(0); cx < 2; cx++ {
+		c := testChunkPayload(cx, 0, nil, nil)
+		blocks := c["Level"].(map[string]interface{})["Blocks"].([]byte)
+		for i := range blocks {
+			if i%ChunkHeight < 64 {
+				blocks[i] = BlockStone
+			}
+		}
+		chunks = append(chunks, c)
+	}
+	set := func(cx int, x, y, z int32, id byte) {
+		chunks[cx]["Level"].(map[string]interface{})["Blocks"].([]byte)[blockIndex(x, y, z)] = id
+	}
+	set(0, 1, 40, 1, 16)
+	set(0, 2, 40, 1, 16)
+	set(1, 8, 40, 8, 16)
+	set(0, 3, 12, 3, 56)
+	set(1, 4, 5, 4, 56)
+	set(1, 5, 30, 5, 15)
+	return makeTestWorld(t, chunks...)
+}
+
+func TestOreDistribution(t *testing.T) {
+	dir := makeOreWorld(t)
+	defer os.RemoveAll(dir)
+	w, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	d, err := w.OreDistribution(nil, []byte{56, 16, 56})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(d.Ores) != 2 || d.Ores[0] != 56 || d.Ores[1] != 16 || len(d.Counts) != 2 {
+		t.Fatal("expected diamond and coal once each, got ", d.Ores)
+	}
+	coal, diamond := d.Counts[16], d.Counts[56]
+	if coal[40] != 3 || diamond[12] != 1 || diamond[5] != 1 {
+		t.Errorf("expected the ores at their heights, got coal %v and diamond %v", coal, diamond)
+	}
+	total := int64(0)
+	for y := range coal {
+		total += coal[y] + diamond[y]
+	}
+	if total != 5 {
+		t.Error("expected 5 ores in all, got ", total)
+	}
+	// the iron ore was not asked for, so it is not rock
+	if d.Rock[40] != 512 || d.Rock[30] != 511 || d.Rock[64] != 0 {
+		t.Errorf("expected rock 512, 511 and 0, got %d, %d and %d", d.Rock[40], d.Rock[30], d.Rock[64])
+	}
+	if share := d.Normalized(16); share[40] != 3.0/512 || share[64] != 0 {
+		t.Errorf("expected shares of 3/512 and 0, got %v and %v", share[40], share[64])
+	}
+	if len(w.Chunks) != 0 {
+		t.Error("expected no chunks to be made resident, got ", len(w.Chunks))
+	}
+
+	buf := new(bytes.Buffer)
+	if err = d.WriteCSV(buf, false); err != nil {
+		t.Fatal(err)
+	}
+	rows := strings.Split(buf.String(), "\r\n", -1)
+	if len(rows) != 2+2*ChunkHeight || rows[0] != "ore,y,count" || rows[1] != "56,0,0" || rows[13] != "56,12,1" ||
+		rows[1+ChunkHeight+40] != "16,40,3" {
+		t.Error("bad CSV ", rows)
+	}
+	buf.Reset()
+	if err = d.WriteCSV(buf, true); err != nil {
+		t.Fatal(err)
+	}
+	rows = strings.Split(buf.String(), "\r\n", -1)
+	if rows[0] != "ore,y,share" || rows[1+ChunkHeight+40] != "16,40,0.005859375" {
+		t.Error("bad normalized CSV ", rows[0], rows[1+ChunkHeight+40])
+	}
+}
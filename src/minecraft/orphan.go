@@ -0,0 +1,120 @@
+package world
+
+import "os"
+
+// tileEntityBlocks lists, by tile entity id, the blocks that carry it.  Tile
+// entities with other ids cannot be checked and are left alone.
+var tileEntityBlocks = map[string][]byte{
+	"Chest":      []byte{BlockChest},
+	"Furnace":    []byte{BlockFurnace, BlockLitFurnace},
+	"Sign":       []byte{BlockSignPost, BlockWallSign},
+	"MobSpawner": []byte{BlockMobSpawner},
+}
+
+// blockTileEntity is the inverse of tileEntityBlocks.
+var blockTileEntity = make(map[byte]string)
+
+func init() {
+	for id, blocks := range tileEntityBlocks {
+		for _, b := range blocks {
+			blockTileEntity[b] = id
+		}
+	}
+}
+
+// TileEntityRef names a tile entity by the chunk holding it and its index in that
+// chunk's TileEntities, with the same caveats as EntityRef.
+type TileEntityRef struct {
+	TileEntity     TileEntity
+	ChunkX, ChunkZ int32
+	Index          int
+}
+
+// orphaned reports whether te is a kind we can check that does not sit on a block
+// that carries it.
+func orphaned(c *Chunk, te TileEntity) bool {
+	blocks, ok := tileEntityBlocks[te.Id()]
+	if !ok {
+		return false
+	}
+	id, _, err := c.BlockAt(te.tileEntityBase().local(c))
+	if err != nil {
+		return true // not even in the chunk
+	}
+	for _, b := range blocks {
+		if id == b {
+			return false
+		}
+	}
+	return true
+}
+
+// FindOrphanedTileEntities returns the tile entities in region (nil meaning the
+// whole world) whose block is not one that carries them, such as a chest where
+// the chest block has been replaced with stone.  Chunks that are not resident
+// are streamed from disk.
+func (world *World) FindOrphanedTileEntities(region *Region) (refs []TileEntityRef, err os.Error) {
+	err = world.streamChunks("FindOrphanedTileEntities", region, func(c *Chunk) (bool, os.Error) {
+		for i, te := range c.Level.TileEntities {
+			if orphaned(c, te) {
+				refs = append(refs, TileEntityRef{te, c.Level.XPos, c.Level.ZPos, i})
+			}
+		}
+		return false, nil
+	})
+	return
+}
+
+// RemoveOrphanedTileEntities deletes the tile entities FindOrphanedTileEntities
+// would return.  Chunks that are not resident are streamed from disk and written
+// back only if something was removed from them.
+func (world *World) RemoveOrphanedTileEntities(region *Region) (removed int, err os.Error) {
+	err = world.streamChunks("RemoveOrphanedTileEntities", region, func(c *Chunk) (bool, os.Error) {
+		kept := c.Level.TileEntities[:0]
+		for _, te := range c.Level.TileEntities {
+			if !orphaned(c, te) {
+				kept = append(kept, te)
+			}
+		}
+		n := len(c.Level.TileEntities) - len(kept)
+		c.Level.TileEntities = kept
+		removed += n
+		return n > 0, nil
+	})
+	return
+}
+
+// FindMissingTileEntities returns, for every block in region (nil meaning the
+// whole world) that should carry a tile entity but has none, an empty tile entity
+// for it.  If create is set each is added to its chunk; otherwise the refs have an
+// Index of -1.  Chunks that are not resident are streamed from disk and written
+// back only if something was added to them.
+func (world *World) FindMissingTileEntities(region *Region, create bool) (refs []TileEntityRef, err os.Error) {
+	err = world.streamChunks("FindMissingTileEntities", region, func(c *Chunk) (bool, os.Error) {
+		have := make(map[int]bool, len(c.Level.TileEntities))
+		for _, te := range c.Level.TileEntities {
+			if lx, y, lz := te.tileEntityBase().local(c); inChunk(lx, y, lz) {
+				have[blockIndex(lx, y, lz)] = true
+			}
+		}
+		added := false
+		for i, b := range c.Level.Blocks {
+			id, ok := blockTileEntity[b]
+			if !ok || have[i] {
+				continue
+			}
+			lx, y, lz := int32(i/(ChunkHeight*ChunkDepth)), int32(i%ChunkHeight), int32(i/ChunkHeight%ChunkDepth)
+			te := newTileEntity(id, c.Level.XPos*ChunkWidth+lx, y, c.Level.ZPos*ChunkDepth+lz)
+			ref := TileEntityRef{te, c.Level.XPos, c.Level.ZPos, -1}
+			if create {
+				te.tileEntityBase().chunk = c
+				ref.Index = len(c.Level.TileEntities)
+				c.Level.TileEntities = append(c.Level.TileEntities, te)
+				added = true
+			}
+			refs = append(refs, ref)
+		}
+		return added, nil
+	})
+	return
+}
@@ -0,0 +1,95 @@
+package world
+
+import "os"
+import "testing"
+
+// makeOrphanWorld writes a world whose chunk (0, 0) has a furnace tile entity on
+// stone, and a chest and a sign post with no tile entity, among tile entities
+// that match their blocks and one of a kind we cannot check.
+func makeOrphanWorld(t *testing.T) string {
+	c := newChunk(0, 0)
+	c.SetBlock(1, 64, 1, BlockChest, 0)
+	c.SetBlock(2, 64, 2, BlockStone, 0)
+	c.SetBlock(3, 64, 3, BlockWallSign, 2)
+	c.SetBlock(5, 64, 5, BlockChest, 0)
+	c.SetBlock(6, 70, 6, BlockSignPost, 4)
+	payload := mustFromChunk(c)
+	payload["Level"].(map[string]interface{})["TileEntities"] = []interface{}{
+		tileEntityCompound("Chest", 1, 64, 1, map[string]interface{}{"Items": []interface{}{}}),
+		tileEntityCompound("Furnace", 2, 64, 2, map[string]interface{}{
+			"BurnTime": int16(0), "CookTime": int16(0), "Items": []interface{}{},
+		}),
+		tileEntityCompound("Sign", 3, 64, 3, map[string]interface{}{
+			"Text1": "", "Text2": "", "Text3": "", "Text4": "",
+		}),
+		tileEntityCompound("Trap", 4, 64, 4, nil),
+	}
+	return makeTestWorld(t, payload)
+}
+
+func TestOrphanedTileEntities(t *testing.T) {
+	dir := makeOrphanWorld(t)
+	defer os.RemoveAll(dir)
+	w, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	refs, err := w.FindOrphanedTileEntities(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(refs) != 1 || refs[0].TileEntity.Id() != "Furnace" || refs[0].Index != 1 {
+		t.Fatal("expected the furnace to be orphaned, got ", refs)
+	}
+	removed, err := w.RemoveOrphanedTileEntities(nil)
+	if err != nil || removed != 1 {
+		t.Fatal("expected to remove 1 tile entity, removed ", removed, ": ", err)
+	}
+	if refs, _ = w.FindOrphanedTileEntities(nil); len(refs) != 0 {
+		t.Error("orphans left after removal: ", refs)
+	}
+	c, err := w.LoadChunk(0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := len(c.Level.TileEntities); n != 3 {
+		t.Error("expected 3 tile entities to remain, got ", n)
+	}
+}
+
+func TestMissingTileEntities(t *testing.T) {
+	dir := makeOrphanWorld(t)
+	defer os.RemoveAll(dir)
+	w, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	refs, err := w.FindMissingTileEntities(nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(refs) != 2 || refs[0].TileEntity.Id() != "Chest" || refs[1].TileEntity.Id() != "Sign" || refs[0].Index != -1 {
+		t.Fatal("expected a missing chest and sign, got ", refs)
+	}
+	if te := refs[1].TileEntity; te.X() != 6 || te.Y() != 70 || te.Z() != 6 {
+		t.Errorf("sign at (%d, %d, %d)", te.X(), te.Y(), te.Z())
+	}
+	if refs, err = w.FindMissingTileEntities(nil, true); err != nil || len(refs) != 2 {
+		t.Fatal("expected to create 2 tile entities, got ", refs, err)
+	}
+	if refs, _ = w.FindMissingTileEntities(nil, false); len(refs) != 0 {
+		t.Error("tile entities still missing after creation: ", refs)
+	}
+	c, err := w.LoadChunk(0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chest, ok := c.Level.TileEntities[4].(*Chest)
+	if !ok || chest.X() != 5 || len(chest.Slots) != 0 {
+		t.Error("expected an empty chest, got ", c.Level.TileEntities[4])
+	}
+}
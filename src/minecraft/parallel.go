@@ -0,0 +1,173 @@
+package world
+
+import "minecraft/error"
+
+import "fmt"
+import "os"
+import "runtime"
+import "sort"
+
+// loadResult is what a worker of LoadChunksParallel found at coords[i].
+type loadResult struct {
+	i       int
+	c       *Chunk
+	missing bool
+	skipped bool // not read, as Cancel was closed
+	err     os.Error
+}
+
+// readJob is a chunk read for LoadChunksParallel, to be decoded and given
+// back.
+type readJob struct {
+	i        int
+	raw      []byte
+	giveBack func()
+}
+
+// A readOrderer is a ChunkStore whose chunks are best read in the order of the
+// keys it gives them, such as the paths of their files.
+type readOrderer interface {
+	readKey(x, z int32) string
+}
+
+// readOrder sorts indexes into coords by the keys of their chunks, if the store
+// gives them, and otherwise as SortXZ would sort the coords.
+type readOrder struct {
+	indexes []int
+	coords  []XZ
+	keys    []string
+}
+
+func (p readOrder) Len() int      { return len(p.indexes) }
+func (p readOrder) Swap(i, j int) { p.indexes[i], p.indexes[j] = p.indexes[j], p.indexes[i] }
+func (p readOrder) Less(i, j int) bool {
+	a, b := p.indexes[i], p.indexes[j]
+	if p.keys != nil {
+		return p.keys[a] < p.keys[b]
+	}
+	return xzLess(p.coords[a], p.coords[b])
+}
+
+// LoadChunksParallel makes the chunks at coords resident, as LoadChunk does,
+// decoding them with the given number of workers at once; zero means
+// GOMAXPROCS.  The session lock is checked once, before any are read, and each
+// chunk is made resident as it is decoded.
+//
+// The chunks are read one at a time, whatever the order of coords, in the
+// order the store keeps them: for an Alpha world a directory at a time, as
+// chunks 64 apart share a directory, and reading a directory's files
+// together, rather than returning to it later, keeps its entries cached; for
+// the others a region file at a time.  Decoding, which is most of the work once
+// the chunks are read, goes on meanwhile.
+//
+// loaded holds the coordinates of the chunks that are now resident, including
+// those that already were, and missing those with no chunk stored, both in the
+// order of coords.  A chunk that cannot be read does not stop the others; err
+// then counts the failures and wraps the first of them.  Once Cancel is closed,
+// the chunks not yet read are neither loaded nor missing, and err is a
+// CancelledError unless some chunk failed.
+func (world *World) LoadChunksParallel(coords []XZ, workers int) (loaded, missing []XZ, err os.Error) {
+	if err = world.verifyLock(); err != nil {
+		return
+	}
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	found := make([]bool, len(coords))
+	order := readOrder{coords: coords}
+	orderer, ok := world.Store().(readOrderer)
+	if ok {
+		order.keys = make([]string, len(coords))
+	}
+	for i, xz := range coords {
+		if _, ok := world.resident(xz); ok {
+			found[i] = true
+			continue
+		}
+		if orderer != nil {
+			order.keys[i] = orderer.readKey(SplitXZ(xz))
+		}
+		order.indexes = append(order.indexes, i)
+	}
+	sort.Sort(order)
+	pending := len(order.indexes)
+	t := world.begin("LoadChunksParallel", pending)
+	defer func() { t.end(err) }()
+
+	// once cancelled, the reader skips the rest, so the workers drain and stop
+	results := make(chan loadResult)
+	reads := make(chan readJob, workers)
+	go func() {
+		for _, i := range order.indexes {
+			if t.cancelled() != nil {
+				results <- loadResult{i: i, skipped: true}
+			} else if job, r, ok := world.readJob(i, coords[i]); ok {
+				reads <- job
+			} else {
+				results <- r
+			}
+		}
+		close(reads)
+	}()
+	for n := 0; n < workers && n < pending; n++ {
+		go func() {
+			for job := range reads {
+				x, z := SplitXZ(coords[job.i])
+				c, err := world.decodeChunkFile(x, z, job.raw)
+				job.giveBack()
+				results <- loadResult{i: job.i, c: c, err: err}
+			}
+		}()
+	}
+	absent := make([]bool, len(coords))
+	var failed int
+	var first os.Error
+	cancelled := false
+	for ; pending > 0; pending-- {
+		r := <-results
+		switch {
+		case r.skipped:
+			cancelled = true
+			continue
+		case r.err != nil:
+			failed++
+			if first == nil {
+				first = r.err
+			}
+		case r.missing:
+			absent[r.i] = true
+		default:
+			found[r.i] = true
+			world.keep(coords[r.i], r.c)
+		}
+		x, z := SplitXZ(coords[r.i])
+		t.chunk(x, z)
+	}
+	for i, xz := range coords {
+		if found[i] {
+			loaded = append(loaded, xz)
+		} else if absent[i] {
+			missing = append(missing, xz)
+		}
+	}
+	if failed > 0 {
+		err = error.NewError(fmt.Sprintf("could not load %d of %d chunks", failed, len(coords)), first)
+	} else if cancelled {
+		err = t.cancelled()
+	}
+	return
+}
+
+// readJob reads the payload of the chunk at xz for LoadChunksParallel.  If ok
+// is false, there is nothing to decode and r is the result.
+func (world *World) readJob(i int, xz XZ) (job readJob, r loadResult, ok bool) {
+	x, z := SplitXZ(xz)
+	raw, giveBack, err := world.readPayload(x, z)
+	if err != nil {
+		if IsError(err, ErrChunkNotFound) {
+			return job, loadResult{i: i, missing: true}, false
+		}
+		return job, loadResult{i: i, err: error.InChunk(x, z).Error("could not load chunk", err)}, false
+	}
+	return readJob{i, raw, giveBack}, r, true
+}
@@ -0,0 +1,243 @@
+package world
+
+import "io/ioutil"
+import "os"
+import "path"
+import "rand"
+import "syscall"
+import "testing"
+
+func TestLoadChunksParallel(t *testing.T) {
+	dir := makeTestWorld(t,
+		testChunkPayload(0, 0, []interface{}{pigFixture}, nil),
+		testChunkPayload(-1, -1, nil, nil),
+		testChunkPayload(3, -2, nil, nil))
+	defer os.RemoveAll(dir)
+	w, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	corrupt := chunkPath(w.dir, 1, 0)
+	if err = os.MkdirAll(path.Dir(corrupt), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err = ioutil.WriteFile(corrupt, []byte("not a chunk"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	resident, err := w.GetChunk(0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resident.Level.Entities = nil
+
+	coords := []XZ{MakeXZ(0, 0), MakeXZ(-1, -1), MakeXZ(5, 5), MakeXZ(1, 0), MakeXZ(3, -2)}
+	loaded, missing, err := w.LoadChunksParallel(coords, 3)
+	if err == nil {
+		t.Error("expected an error for the corrupt chunk")
+	}
+	if len(loaded) != 3 || loaded[0] != coords[0] || loaded[1] != coords[1] || loaded[2] != coords[4] {
+		t.Error("expected chunks (0, 0), (-1, -1) and (3, -2) loaded, got ", loaded)
+	}
+	if len(missing) != 1 || missing[0] != coords[2] {
+		t.Error("expected chunk (5, 5) missing, got ", missing)
+	}
+	if len(w.Chunks) != 3 {
+		t.Error("expected 3 resident chunks, got ", len(w.Chunks))
+	}
+	if w.Chunks[MakeXZ(0, 0)] != resident {
+		t.Error("expected the resident chunk to be kept")
+	}
+	if c := w.Chunks[MakeXZ(3, -2)]; c == nil || c.Level.XPos != 3 || c.Level.ZPos != -2 {
+		t.Error("expected chunk (3, -2) to be decoded, got ", c)
+	}
+
+	if loaded, missing, err = w.LoadChunksParallel(coords[:3], 0); err != nil || len(loaded) != 2 || len(missing) != 1 {
+		t.Errorf("expected 2 loaded and 1 missing, got %v and %v (%v)", loaded, missing, err)
+	}
+}
+
+// benchmarkLoadChunks loads a world of 1,000 chunks with the given number of
+// workers.
+func benchmarkLoadChunks(b *testing.B, workers int) {
+	b.StopTimer()
+	dir, err := writeTestWorld()
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	var coords []XZ
+	for x := int32(0); x < 40; x++ {
+		for z := int32(0); z < 25; z++ {
+			item := itemAt(float64(x*16+8), 64, float64(z*16+8))
+			if err = writeTestChunk(dir, testChunkPayload(x, z, []interface{}{item}, nil)); err != nil {
+				b.Fatal(err)
+			}
+			coords = append(coords, MakeXZ(x, z))
+		}
+	}
+	w, err := Open(dir)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer w.Close()
+	b.StartTimer()
+
+	for i := 0; i < b.N; i++ {
+		w.Chunks = make(map[XZ]*Chunk)
+		if _, _, err = w.LoadChunksParallel(coords, workers); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkLoadChunksParallel1(b *testing.B) { benchmarkLoadChunks(b, 1) }
+func BenchmarkLoadChunksParallel2(b *testing.B) { benchmarkLoadChunks(b, 2) }
+func BenchmarkLoadChunksParallel4(b *testing.B) { benchmarkLoadChunks(b, 4) }
+
+// BenchmarkLoadChunksScattered loads a world of 1,024 chunks, 128 by 8, two to
+// a directory, asking for them in a shuffled order.
+//
+// With the page cache warm this measures little but decoding.  To measure cold,
+// as a server starting up would find the disk, run it as root with
+// MINECRAFT_DROP_CACHES set, and the page, dentry and inode caches are synced
+// and dropped before each load:
+//
+//	MINECRAFT_DROP_CACHES=1 gotest -bench LoadChunksScattered -benchtime 20x
+//
+// The world must then be on a disk rather than tmpfs, whose files cannot be
+// dropped; set TMPDIR to put it elsewhere.
+func BenchmarkLoadChunksScattered(b *testing.B) {
+	b.StopTimer()
+	dir, err := writeTestWorld()
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	var coords []XZ
+	for x := int32(0); x < 128; x++ {
+		for z := int32(0); z < 8; z++ {
+			if err = writeTestChunk(dir, testChunkPayload(x, z, nil, nil)); err != nil {
+				b.Fatal(err)
+			}
+			coords = append(coords, MakeXZ(x, z))
+		}
+	}
+	scattered := make([]XZ, len(coords))
+	for i, j := range rand.New(rand.NewSource(1)).Perm(len(coords)) {
+		scattered[i] = coords[j]
+	}
+	w, err := Open(dir)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer w.Close()
+	cold := os.Getenv("MINECRAFT_DROP_CACHES") != ""
+
+	for i := 0; i < b.N; i++ {
+		w.Chunks = make(map[XZ]*Chunk)
+		if cold {
+			syscall.Sync()
+			if err = ioutil.WriteFile("/proc/sys/vm/drop_caches", []byte("3\n"), 0200); err != nil {
+				b.Fatal(err)
+			}
+		}
+		b.StartTimer()
+		if _, _, err = w.LoadChunksParallel(scattered, 4); err != nil {
+			b.Fatal(err)
+		}
+		b.StopTimer()
+	}
+}
+
+// TestConcurrentUse loads, changes, reads and flushes chunks from several
+// goroutines at once, for the race detector.
+func TestConcurrentUse(t *testing.T) {
+	var payloads []map[string]interface{}
+	var coords []XZ
+	for x := int32(-2); x < 2; x++ {
+		for z := int32(-2); z < 2; z++ {
+			payloads = append(payloads, testChunkPayload(x, z, nil, nil))
+			coords = append(coords, MakeXZ(x, z))
+		}
+	}
+	dir := makeTestWorld(t, payloads...)
+	defer os.RemoveAll(dir)
+	w, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	const rounds = 20
+	errs := make(chan os.Error)
+	run := func(f func(i int) os.Error) {
+		go func() {
+			for i := 0; i < rounds; i++ {
+				if err := f(i); err != nil {
+					errs <- err
+					return
+				}
+			}
+			errs <- nil
+		}()
+	}
+	run(func(i int) os.Error {
+		_, _, err := w.LoadChunksParallel(coords, 4)
+		return err
+	})
+	run(func(i int) os.Error {
+		return w.ForEachChunk(func(c *Chunk) os.Error {
+			return c.SetBlock(0, 64, 0, BlockStone, byte(i%16))
+		})
+	})
+	run(func(i int) os.Error {
+		return w.Flush()
+	})
+	run(func(i int) os.Error {
+		_, _, err := w.BlockAt(int32(i%4)*16-32, 64, 0)
+		return err
+	})
+	run(func(i int) os.Error {
+		_, err := w.GetChunk(int32(i%4)-2, 1)
+		return err
+	})
+	run(func(i int) os.Error {
+		return w.BlitBlocks(-8, 80, -8, [3]int32{16, 1, 16}, make([]byte, 256), nil)
+	})
+	run(func(i int) os.Error {
+		_, _, err := w.Slice(NewRegion(-2, -2, 1, 1), 64, BlockAir)
+		return err
+	})
+	run(func(i int) os.Error {
+		_, err := w.EntitiesInBox(-32, 0, -32, 32, 128, 32)
+		return err
+	})
+	run(func(i int) os.Error {
+		_, err := w.EntityCensus(nil, CensusOptions{})
+		return err
+	})
+	for i := 0; i < 9; i++ {
+		if err := <-errs; err != nil {
+			t.Error(err)
+		}
+	}
+
+	if err = w.ForEachChunk(func(c *Chunk) os.Error {
+		return c.SetBlock(0, 64, 0, BlockStone, 0)
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err = w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if len(w.Chunks) != len(coords) {
+		t.Errorf("expected %d resident chunks, got %d", len(coords), len(w.Chunks))
+	}
+	if id, _, err := w.BlockAt(-17, 64, -32); err != nil || id != BlockAir {
+		t.Errorf("expected air at (-17, 64, -32), got %d (%v)", id, err)
+	}
+	if id, _, err := w.BlockAt(-32, 64, -32); err != nil || id != BlockStone {
+		t.Errorf("expected stone at (-32, 64, -32), got %d (%v)", id, err)
+	}
+}
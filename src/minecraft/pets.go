@@ -0,0 +1,74 @@
+package world
+
+import "minecraft/error"
+
+import "fmt"
+import "os"
+
+// FindPets returns the wolves tamed by the player called owner.  The chunks
+// holding them are made resident so that they can be moved or edited.
+func (world *World) FindPets(owner string) (pets []*Entity, err os.Error) {
+	if owner == "" {
+		return nil, error.NewError("wild wolves have no owner", nil)
+	}
+	isPet := func(e *Entity) bool {
+		return e.Wolf != nil && e.Wolf.Owner == owner
+	}
+	var homes []ChunkCoord
+	err = world.scanEntities(nil, func(xz ChunkCoord, entities []*Entity) bool {
+		for _, e := range entities {
+			if isPet(e) {
+				homes = append(homes, xz)
+				break
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return
+	}
+	pets = make([]*Entity, 0)
+	for _, xz := range homes {
+		c, err := world.GetChunk(xz.X, xz.Z)
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range c.Level.Entities {
+			if isPet(e) {
+				pets = append(pets, e)
+			}
+		}
+	}
+	return
+}
+
+// GatherPets moves every wolf tamed by owner to the player's feet.  The player is
+// read from the players directory, or is the single-player player if owner has no
+// file there, and must be in the overworld.  Moved wolves' chunks are left
+// resident and dirty.
+func (world *World) GatherPets(owner string) (moved int, err os.Error) {
+	p := world.Data.Player
+	if _, statErr := os.Stat(world.playerPath(owner)); statErr == nil {
+		if p, err = world.LoadPlayer(owner); err != nil {
+			return
+		}
+	}
+	if p == nil {
+		return 0, error.NewError(fmt.Sprintf("no player %q", owner), nil)
+	}
+	if p.Dimension != 0 {
+		return 0, error.NewError(fmt.Sprintf("%s is not in the overworld", owner), nil)
+	}
+	pets, err := world.FindPets(owner)
+	if err != nil {
+		return
+	}
+	to := p.Physics.Position
+	for _, e := range pets {
+		if err = world.MoveEntity(e, to.X, to.Y-EyeHeight, to.Z); err != nil {
+			return
+		}
+		moved++
+	}
+	return
+}
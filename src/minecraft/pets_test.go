@@ -0,0 +1,91 @@
+package world
+
+import "minecraft/nbt"
+
+import "os"
+import "path"
+import "testing"
+
+// wolfFixture returns a wolf at (x, 64, z) with the given taming and mood tags.
+func wolfFixture(x, z float64, owner string, sitting, angry int8) map[string]interface{} {
+	wolf := mobFixture("Wolf", map[string]interface{}{"Owner": owner, "Sitting": sitting, "Angry": angry})
+	wolf["Pos"] = []interface{}{x, float64(64), z}
+	return wolf
+}
+
+func TestWolfRoundTrip(t *testing.T) {
+	sittingTamed := wolfFixture(4.5, 9.25, "notch", 1, 0)
+	wildAngry := wolfFixture(4.5, 9.25, "", 0, 1)
+	for _, fixture := range []map[string]interface{}{sittingTamed, wildAngry} {
+		if encoded := fromEntity(mustEntity(fixture)); !nbt.Equal(encoded, fixture) {
+			t.Error("expected ", fixture, ", got ", encoded)
+		}
+	}
+	if wolf := mustEntity(sittingTamed); wolf.Wolf == nil || wolf.Wolf.Owner != "notch" || wolf.Wolf.Sitting != 1 {
+		t.Error("expected a sitting wolf tamed by notch, got ", wolf.Wolf)
+	}
+	if wolf := mustEntity(wildAngry); wolf.Wolf == nil || wolf.Wolf.Owner != "" || wolf.Wolf.Angry != 1 {
+		t.Error("expected a wild angry wolf, got ", wolf.Wolf)
+	}
+	if pig := mustEntity(pigFixture); pig.Wolf != nil {
+		t.Error("pig decoded with wolf data")
+	}
+}
+
+func TestFindAndGatherPets(t *testing.T) {
+	dir := makeTestWorld(t,
+		testChunkPayload(0, 0, []interface{}{wolfFixture(2.5, 3.5, "notch", 0, 0)}, nil),
+		testChunkPayload(2, 1, []interface{}{
+			wolfFixture(40.5, 20.5, "notch", 1, 0),
+			wolfFixture(41.5, 20.5, "", 0, 1),
+			wolfFixture(42.5, 20.5, "jeb_", 1, 0),
+		}, nil))
+	defer os.RemoveAll(dir)
+	if err := os.MkdirAll(path.Join(dir, playersdir), 0755); err != nil {
+		t.Fatal(err)
+	}
+	notch := playerFixture(false)
+	notch["Pos"] = []interface{}{float64(8.5), float64(64) + EyeHeight, float64(8.5)}
+	if err := nbt.Save(path.Join(dir, playersdir, "notch.dat"), "", notch); err != nil {
+		t.Fatal(err)
+	}
+	w, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	pets, err := w.FindPets("notch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pets) != 2 {
+		t.Fatal("expected 2 of notch's wolves, got ", len(pets))
+	}
+	if _, err = w.FindPets(""); err == nil {
+		t.Error("expected an error for an empty owner")
+	}
+
+	moved, err := w.GatherPets("notch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if moved != 2 {
+		t.Error("expected 2 wolves moved, got ", moved)
+	}
+	for _, e := range w.Chunks[MakeXZ(0, 0)].Level.Entities {
+		if pos := e.Physics.Position; pos.X != 8.5 || pos.Y != 64 || pos.Z != 8.5 {
+			t.Error("wolf not at notch's feet: ", pos)
+		}
+	}
+	if n := len(w.Chunks[MakeXZ(0, 0)].Level.Entities); n != 2 {
+		t.Error("expected 2 wolves beside notch, got ", n)
+	}
+	if n := len(w.Chunks[MakeXZ(2, 1)].Level.Entities); n != 2 {
+		t.Error("expected the other wolves left behind, got ", n)
+	}
+
+	if _, err = w.GatherPets("dinnerbone"); err == nil {
+		t.Error("expected an error for a missing player")
+	}
+}
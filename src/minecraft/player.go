@@ -0,0 +1,354 @@
+package world
+
+import "minecraft/error"
+import "minecraft/nbt"
+
+import "fmt"
+import "io/ioutil"
+import "os"
+import "path"
+import "sort"
+import "strings"
+
+// see: http://www.minecraftwiki.net/wiki/Alpha_Level_Format#level.dat
+
+const playersdir = "players"
+
+// A Player is the state the game keeps for a player: in level.dat's Data.Player
+// for single-player worlds, or in players/<name>.dat for multiplayer ones.  Both
+// are decoded and encoded by the same functions.
+type Player struct {
+	Physics      Physics
+	OnGround     int8
+	Air          int16
+	Fire         int16
+	FallDistance float32
+	Health       int16
+	AttackTime   int16
+	HurtTime     int16
+	DeathTime    int16
+	Dimension    int32
+	Sleeping     *int8 // nil in files written before beds were added
+	SleepTimer   *int16
+	Inventory    []InventorySlot
+	// the player's bed, if they have slept in one; nil otherwise
+	SpawnX, SpawnY, SpawnZ *int32
+	// Tags not modeled above, written back untouched.
+	Extra map[string]interface{}
+
+	// the players directory entry the player was read from or saved to; empty
+	// for the single-player player
+	name string
+}
+
+var playerTags = []string{
+	"Pos", "Motion", "Rotation", "FallDistance", "Fire", "Air", "OnGround",
+	"Health", "AttackTime", "HurtTime", "DeathTime", "Dimension", "Inventory",
+}
+
+func toPlayer(payload map[string]interface{}) (p *Player, err os.Error) {
+	p = new(Player)
+	if p.Physics, err = toPhysics(payload); err != nil {
+		return nil, err
+	}
+	if p.OnGround, err = getInt8(payload, "OnGround"); err != nil {
+		return nil, err
+	}
+	if p.Air, err = getInt16(payload, "Air"); err != nil {
+		return nil, err
+	}
+	if p.Fire, err = getInt16(payload, "Fire"); err != nil {
+		return nil, err
+	}
+	if p.FallDistance, err = getFloat32(payload, "FallDistance"); err != nil {
+		return nil, err
+	}
+	if p.Health, err = getInt16(payload, "Health"); err != nil {
+		return nil, err
+	}
+	if p.AttackTime, err = getInt16(payload, "AttackTime"); err != nil {
+		return nil, err
+	}
+	if p.HurtTime, err = getInt16(payload, "HurtTime"); err != nil {
+		return nil, err
+	}
+	if p.DeathTime, err = getInt16(payload, "DeathTime"); err != nil {
+		return nil, err
+	}
+	if p.Dimension, err = getInt32(payload, "Dimension"); err != nil {
+		return nil, err
+	}
+	items, err := getList(payload, "Inventory")
+	if err != nil {
+		return nil, err
+	}
+	if p.Inventory, err = toSlots(items); err != nil {
+		return nil, error.NewError("could not decode inventory", err)
+	}
+
+	known := playerTags
+	if p.Sleeping = optInt8(payload, "Sleeping"); p.Sleeping != nil {
+		known = append(known, "Sleeping")
+	}
+	if p.SleepTimer = optInt16(payload, "SleepTimer"); p.SleepTimer != nil {
+		known = append(known, "SleepTimer")
+	}
+	p.SpawnX, p.SpawnY, p.SpawnZ = optInt32(payload, "SpawnX"), optInt32(payload, "SpawnY"), optInt32(payload, "SpawnZ")
+	if p.SpawnX != nil && p.SpawnY != nil && p.SpawnZ != nil {
+		known = append(known, "SpawnX", "SpawnY", "SpawnZ")
+	} else {
+		p.SpawnX, p.SpawnY, p.SpawnZ = nil, nil, nil
+	}
+	p.Extra = unknownTags(payload, known)
+	return
+}
+
+// fromPlayer encodes a player the way the game stores it.
+func fromPlayer(p *Player) map[string]interface{} {
+	pos, vel, rot := p.Physics.Position, p.Physics.Velocity, p.Physics.Euler
+	payload := copyTags(p.Extra)
+	payload["Pos"] = []interface{}{pos.X, pos.Y, pos.Z}
+	payload["Motion"] = []interface{}{vel.DX, vel.DY, vel.DZ}
+	payload["Rotation"] = []interface{}{rot.Yaw, rot.Pitch}
+	payload["OnGround"] = p.OnGround
+	payload["Air"] = p.Air
+	payload["Fire"] = p.Fire
+	payload["FallDistance"] = p.FallDistance
+	payload["Health"] = p.Health
+	payload["AttackTime"] = p.AttackTime
+	payload["HurtTime"] = p.HurtTime
+	payload["DeathTime"] = p.DeathTime
+	payload["Dimension"] = p.Dimension
+	payload["Inventory"] = fromInventory(p.Inventory)
+	if p.Sleeping != nil {
+		payload["Sleeping"] = *p.Sleeping
+	}
+	if p.SleepTimer != nil {
+		payload["SleepTimer"] = *p.SleepTimer
+	}
+	if p.SpawnX != nil && p.SpawnY != nil && p.SpawnZ != nil {
+		payload["SpawnX"] = *p.SpawnX
+		payload["SpawnY"] = *p.SpawnY
+		payload["SpawnZ"] = *p.SpawnZ
+	}
+	return payload
+}
+
+// toPhysics decodes the Pos, Motion and Rotation tags shared by every entity.
+func toPhysics(payload map[string]interface{}) (phys Physics, err os.Error) {
+	var pos, motion, rotation []float64
+	if pos, err = getFloats(payload, "Pos", 3); err != nil {
+		return
+	}
+	if motion, err = getFloats(payload, "Motion", 3); err != nil {
+		return
+	}
+	if rotation, err = getFloats(payload, "Rotation", 2); err != nil {
+		return
+	}
+	phys.Position = Position{pos[0], pos[1], pos[2]}
+	phys.Velocity = Velocity{motion[0], motion[1], motion[2]}
+	phys.Euler = Euler{float32(rotation[0]), float32(rotation[1])}
+	return
+}
+
+// getFloats reads a list of n floats or doubles.
+func getFloats(c map[string]interface{}, name string, n int) (v []float64, err os.Error) {
+	list, err := getList(c, name)
+	if err != nil {
+		return
+	}
+	if len(list) != n {
+		return nil, error.NewError(fmt.Sprintf("tag %q: expected %d values, got %d", name, n, len(list)), nil)
+	}
+	v = make([]float64, n)
+	for i, f := range list {
+		switch f := f.(type) {
+		case float64:
+			v[i] = f
+		case float32:
+			v[i] = float64(f)
+		default:
+			return nil, tagError(name, "list of floats", f)
+		}
+	}
+	return
+}
+
+// PlayerNames lists the players with a file in the world's players directory, in
+// order.  A single-player world has none; its player is Data.Player.
+func (world *World) PlayerNames() (names []string, err os.Error) {
+	files, err := ioutil.ReadDir(path.Join(world.dir, playersdir))
+	if err != nil {
+		if e, ok := err.(*os.PathError); ok && e.Error == os.ENOENT {
+			return nil, nil
+		}
+		return nil, error.NewError("could not list players directory", err)
+	}
+	for _, fi := range files {
+		if fi.IsRegular() && strings.HasSuffix(fi.Name, ".dat") {
+			names = append(names, fi.Name[:len(fi.Name)-len(".dat")])
+		}
+	}
+	sort.SortStrings(names)
+	return
+}
+
+func (world *World) playerPath(name string) string {
+	return path.Join(world.dir, playersdir, name+".dat")
+}
+
+// LoadPlayer decodes players/<name>.dat.
+func (world *World) LoadPlayer(name string) (p *Player, err os.Error) {
+	_, payload, err := nbt.Load(world.playerPath(name))
+	if err != nil {
+		return nil, error.NewError(fmt.Sprintf("could not load player %q", name), err)
+	}
+	if p, err = toPlayer(payload); err != nil {
+		return nil, error.NewError(fmt.Sprintf("could not decode player %q", name), err)
+	}
+	p.name = name
+	return
+}
+
+// SavePlayer writes p to players/<name>.dat.
+func (world *World) SavePlayer(name string, p *Player) (err os.Error) {
+	if err = world.VerifyLockNow(); err != nil {
+		return
+	}
+	if err = os.MkdirAll(path.Join(world.dir, playersdir), 0755); err != nil {
+		return error.NewError("could not create players directory", err)
+	}
+	if err = nbt.Save(world.playerPath(name), "", fromPlayer(p)); err != nil {
+		return error.NewError(fmt.Sprintf("could not save player %q", name), err)
+	}
+	p.name = name
+	return
+}
+
+// StorePlayer writes p back to where it came from: level.dat for the
+// single-player player, otherwise the players directory.
+func (world *World) StorePlayer(p *Player) os.Error {
+	if p == world.Data.Player {
+		return world.SaveLevel()
+	}
+	if p.name == "" {
+		return error.NewError("player was not loaded from this world", nil)
+	}
+	return world.SavePlayer(p.name, p)
+}
+
+// Name returns the name the player is stored under in the players directory, or
+// "" for the single-player player.
+func (p *Player) Name() string {
+	return p.name
+}
+
+// EyeHeight is how far above their feet the game records a player's position.
+const EyeHeight = 1.62
+
+// SetPosition moves the player to (x, y, z), where y is at eye level, and brings
+// them to rest so that they take no fall damage when next loaded.
+func (p *Player) SetPosition(x, y, z float64) {
+	p.Physics.Position = Position{x, y, z}
+	p.Physics.Velocity = Velocity{}
+	p.FallDistance = 0
+}
+
+// SetDimension puts the player in the overworld (0) or the Nether (-1).
+func (p *Player) SetDimension(d int32) os.Error {
+	if d != 0 && d != -1 {
+		return error.NewError(fmt.Sprint("unknown dimension ", d), nil)
+	}
+	p.Dimension = d
+	return nil
+}
+
+// SetSpawn sets where the player respawns, as if they had slept in a bed there.
+func (p *Player) SetSpawn(x, y, z int32) {
+	p.SpawnX, p.SpawnY, p.SpawnZ = &x, &y, &z
+}
+
+// RescuePlayer moves a player to the top of solid ground at their spawn point, or
+// the world's if they have none, in the overworld, and saves them.  An empty name
+// means the single-player player.
+func (world *World) RescuePlayer(name string) (err os.Error) {
+	p := world.Data.Player
+	if name != "" {
+		if p, err = world.LoadPlayer(name); err != nil {
+			return
+		}
+	} else if p == nil {
+		return error.NewError("world has no single-player player", nil)
+	}
+	x, z := world.Data.SpawnX, world.Data.SpawnZ
+	if p.SpawnX != nil && p.SpawnZ != nil {
+		x, z = *p.SpawnX, *p.SpawnZ
+	}
+	y, err := world.safeSpot(x, z)
+	if err != nil {
+		return error.NewError("could not rescue player", err)
+	}
+	p.SetPosition(float64(x)+0.5, float64(y)+EyeHeight, float64(z)+0.5)
+	p.Dimension = 0
+	return world.StorePlayer(p)
+}
+
+// safeSpot returns the height at which a player can stand in column (x, z): the
+// top of the highest solid block with two blocks of air above it.
+func (world *World) safeSpot(x, z int32) (y int32, err os.Error) {
+	c, err := world.GetChunk(x>>4, z>>4)
+	if err != nil {
+		return
+	}
+	lx, lz := x&15, z&15
+	for y = ChunkHeight - 3; y >= 0; y-- {
+		id, _, _ := c.BlockAt(lx, y, lz)
+		if id == BlockAir || skyTransparent[id] || id >= BlockWater && id <= BlockStillLava {
+			continue
+		}
+		above, _, _ := c.BlockAt(lx, y+1, lz)
+		head, _, _ := c.BlockAt(lx, y+2, lz)
+		if above == BlockAir && head == BlockAir {
+			return y + 1, nil
+		}
+	}
+	return 0, error.NewError(fmt.Sprintf("no safe spot at (%d, %d)", x, z), nil)
+}
+
+// ForEachPlayer calls fn with every player in the players directory, in name
+// order, and saves those whose tags fn changed.  Players that cannot be loaded
+// are skipped and named in the returned error once the rest have been visited.
+// An error from fn, or from saving, stops the sweep.
+func (world *World) ForEachPlayer(fn func(name string, p *Player) os.Error) (err os.Error) {
+	names, err := world.PlayerNames()
+	if err != nil {
+		return
+	}
+	var bad []string
+	var first os.Error
+	for _, name := range names {
+		p, err := world.LoadPlayer(name)
+		if err != nil {
+			bad = append(bad, name)
+			if first == nil {
+				first = err
+			}
+			continue
+		}
+		before := fromPlayer(p)
+		if err = fn(name, p); err != nil {
+			return err
+		}
+		if nbt.Equal(fromPlayer(p), before) {
+			continue
+		}
+		if err = world.SavePlayer(name, p); err != nil {
+			return err
+		}
+	}
+	if len(bad) > 0 {
+		err = error.NewError(fmt.Sprintf("skipped %d of %d players: %s", len(bad), len(names), strings.Join(bad, ", ")), first)
+	}
+	return
+}
@@ -0,0 +1,328 @@
+package world
+
+import "minecraft/nbt"
+
+import "io/ioutil"
+import "os"
+import "path"
+import "reflect"
+import "strings"
+import "testing"
+
+// playerFixture returns a player as written by the game.  Single-player worlds
+// from after beds were added also have the sleep tags.
+func playerFixture(sleep bool) map[string]interface{} {
+	p := map[string]interface{}{
+		"Pos":          []interface{}{float64(10.5), float64(65.62), float64(-4.5)},
+		"Motion":       []interface{}{float64(0), float64(-0.0784), float64(0)},
+		"Rotation":     []interface{}{float32(270), float32(12.5)},
+		"FallDistance": float32(0),
+		"Fire":         int16(-20),
+		"Air":          int16(300),
+		"OnGround":     int8(1),
+		"Health":       int16(18),
+		"AttackTime":   int16(0),
+		"HurtTime":     int16(0),
+		"DeathTime":    int16(0),
+		"Dimension":    int32(0),
+		"Inventory": []interface{}{
+			itemCompound(0, 278, 1, 12),
+			itemCompound(1, BlockTorch, 37, 0),
+			itemCompound(103, 310, 1, 0),
+		},
+	}
+	if sleep {
+		p["Sleeping"] = int8(0)
+		p["SleepTimer"] = int16(0)
+	}
+	return p
+}
+
+func TestPlayerRoundTrip(t *testing.T) {
+	modded := playerFixture(false)
+	modded["ModData"] = map[string]interface{}{"Mana": int32(40)}
+	for _, fixture := range []map[string]interface{}{playerFixture(true), playerFixture(false), modded} {
+		p, err := toPlayer(fixture)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if encoded := fromPlayer(p); !nbt.Equal(encoded, fixture) {
+			t.Error("expected ", fixture, ", got ", encoded)
+		}
+	}
+	p, _ := toPlayer(playerFixture(true))
+	if p.Physics.Euler.Yaw != 270 || p.Health != 18 || len(p.Inventory) != 3 || p.Inventory[2].Slot != 103 {
+		t.Error("bad player ", p)
+	}
+}
+
+func TestPlayerMalformed(t *testing.T) {
+	fixture := playerFixture(true)
+	fixture["Pos"] = []interface{}{float64(1), float64(2)}
+	if _, err := toPlayer(fixture); err == nil {
+		t.Error("expected an error for a short Pos")
+	}
+}
+
+// setLevelPlayer rewrites the level.dat of a test world to hold player, along
+// with a tag Data does not model.
+func setLevelPlayer(t *testing.T, dir string, player map[string]interface{}) {
+	_, level, err := nbt.Load(path.Join(dir, leveldat))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := level["Data"].(map[string]interface{})
+	data["Player"] = player
+	data["LevelName"] = "New World"
+	if err = nbt.Save(path.Join(dir, leveldat), "", level); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLevelPlayer(t *testing.T) {
+	dir := makeTestWorld(t)
+	defer os.RemoveAll(dir)
+	setLevelPlayer(t, dir, playerFixture(true))
+	_, original, err := nbt.Load(path.Join(dir, leveldat))
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w.Data.Player == nil || w.Data.Player.Health != 18 {
+		t.Fatal("expected the single-player player, got ", w.Data.Player)
+	}
+	if err = w.SaveLevel(); err != nil {
+		t.Fatal(err)
+	}
+	_, saved, err := nbt.Load(path.Join(dir, leveldat))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !nbt.Equal(saved, original) {
+		t.Error("expected ", original, ", got ", saved)
+	}
+
+	w.Data.Player.Health = 20
+	if err = w.SaveLevel(); err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+	if w, err = Open(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	if w.Data.Player.Health != 20 {
+		t.Error("expected health 20, got ", w.Data.Player.Health)
+	}
+	if names, _ := w.PlayerNames(); len(names) != 0 {
+		t.Error("expected no multiplayer players, got ", names)
+	}
+}
+
+func TestLevelPlayerMalformed(t *testing.T) {
+	dir := makeTestWorld(t)
+	defer os.RemoveAll(dir)
+	fixture := playerFixture(false)
+	fixture["Health"] = "full"
+	setLevelPlayer(t, dir, fixture)
+	w, err := Open(dir)
+	if err == nil {
+		w.Close()
+		t.Fatal("expected an error for a malformed player")
+	}
+	if w.lockfd != nil {
+		t.Error("expected the session lock given back")
+	}
+}
+
+func TestPlayersDirectory(t *testing.T) {
+	dir := makeTestWorld(t)
+	defer os.RemoveAll(dir)
+	if err := os.MkdirAll(path.Join(dir, playersdir), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"notch", "jeb_"} {
+		if err := nbt.Save(path.Join(dir, playersdir, name+".dat"), "", playerFixture(false)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	w, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	if w.Data.Player != nil {
+		t.Error("multiplayer world has a single-player player")
+	}
+	names, err := w.PlayerNames()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(names, []string{"jeb_", "notch"}) {
+		t.Error("expected [jeb_ notch], got ", names)
+	}
+
+	p, err := w.LoadPlayer("notch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = w.SavePlayer("notch", p); err != nil {
+		t.Fatal(err)
+	}
+	_, saved, err := nbt.Load(path.Join(dir, playersdir, "notch.dat"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fixture := playerFixture(false); !nbt.Equal(saved, fixture) {
+		t.Error("expected ", fixture, ", got ", saved)
+	}
+	if _, err = w.LoadPlayer("dinnerbone"); err == nil {
+		t.Error("expected an error for a missing player")
+	}
+}
+
+func TestSetPosition(t *testing.T) {
+	p, _ := toPlayer(playerFixture(true))
+	p.FallDistance = 80
+	p.SetPosition(100.5, 70+EyeHeight, -3.5)
+	if p.Physics.Position.Y != 70+EyeHeight || p.FallDistance != 0 || p.Physics.Velocity.DY != 0 {
+		t.Error("player not brought to rest: ", p.Physics, p.FallDistance)
+	}
+	if err := p.SetDimension(1); err == nil {
+		t.Error("expected an error for dimension 1")
+	}
+	if err := p.SetDimension(-1); err != nil || p.Dimension != -1 {
+		t.Error("could not send player to the Nether: ", err)
+	}
+	p.SetSpawn(1, 2, 3)
+	encoded := fromPlayer(p)
+	if encoded["SpawnX"] != int32(1) || encoded["SpawnY"] != int32(2) || encoded["SpawnZ"] != int32(3) {
+		t.Error("spawn tags not written: ", encoded)
+	}
+	if reread, _ := toPlayer(encoded); reread.SpawnX == nil || *reread.SpawnZ != 3 || reread.Extra != nil {
+		t.Error("spawn tags not decoded: ", reread)
+	}
+}
+
+// groundedChunk returns chunk (0, 0) with a column of ground at world spawn,
+// topped with grass at y=63.
+func groundedChunk() map[string]interface{} {
+	c := newChunk(0, 0)
+	for y := int32(0); y < 63; y++ {
+		c.SetBlock(8, y, 8, BlockStone, 0)
+	}
+	c.SetBlock(8, 63, 8, BlockGrass, 0)
+	c.Level.Entities, c.Level.TileEntities = []*Entity{}, []TileEntity{}
+	return mustFromChunk(c)
+}
+
+func checkRescued(t *testing.T, p *Player) {
+	pos := p.Physics.Position
+	if pos.X != 8.5 || pos.Y != 64+EyeHeight || pos.Z != 8.5 || p.FallDistance != 0 {
+		t.Error("player not rescued: ", p.Physics, p.FallDistance)
+	}
+}
+
+func TestRescuePlayer(t *testing.T) {
+	dir := makeTestWorld(t, groundedChunk())
+	defer os.RemoveAll(dir)
+	void := playerFixture(true)
+	void["Pos"] = []interface{}{float64(500), float64(-40), float64(500)}
+	void["FallDistance"] = float32(104)
+	setLevelPlayer(t, dir, void)
+	if err := os.MkdirAll(path.Join(dir, playersdir), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := nbt.Save(path.Join(dir, playersdir, "notch.dat"), "", void); err != nil {
+		t.Fatal(err)
+	}
+
+	w, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = w.RescuePlayer(""); err != nil {
+		t.Fatal(err)
+	}
+	if err = w.RescuePlayer("notch"); err != nil {
+		t.Fatal(err)
+	}
+	if err = w.RescuePlayer("jeb_"); err == nil {
+		t.Error("expected an error for a missing player")
+	}
+	w.Close()
+
+	if w, err = Open(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	checkRescued(t, w.Data.Player)
+	p, err := w.LoadPlayer("notch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkRescued(t, p)
+}
+
+func TestForEachPlayer(t *testing.T) {
+	dir := makeTestWorld(t)
+	defer os.RemoveAll(dir)
+	if err := os.MkdirAll(path.Join(dir, playersdir), 0755); err != nil {
+		t.Fatal(err)
+	}
+	nether := playerFixture(false)
+	nether["Dimension"] = int32(-1)
+	healthy := playerFixture(false)
+	healthy["Health"] = int16(20)
+	healthy["Inventory"] = []interface{}{}
+	// the root tag is named so that a rewrite, which names it "", shows
+	for name, p := range map[string]map[string]interface{}{"notch": nether, "jeb_": healthy} {
+		if err := nbt.Save(path.Join(dir, playersdir, name+".dat"), name, p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ioutil.WriteFile(path.Join(dir, playersdir, "dinnerbone.dat"), []byte("not gzip"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	w, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	var visited []string
+	err = w.ForEachPlayer(func(name string, p *Player) os.Error {
+		visited = append(visited, name)
+		p.Health = 20
+		if p.Dimension == -1 {
+			p.SetDimension(0)
+			p.SetPosition(8.5, 64+EyeHeight, 8.5)
+		}
+		for n := int8(0); n < PlayerSlots; n++ {
+			if item, ok := p.GetSlot(n); ok && item.Id == BlockTorch {
+				p.ClearSlot(n)
+			}
+		}
+		return nil
+	})
+	if err == nil || !strings.Contains(err.String(), "dinnerbone") {
+		t.Error("expected the corrupt player to be reported, got ", err)
+	}
+	if !reflect.DeepEqual(visited, []string{"jeb_", "notch"}) {
+		t.Error("expected to visit [jeb_ notch], got ", visited)
+	}
+
+	if root, _, err := nbt.Load(path.Join(dir, playersdir, "jeb_.dat")); err != nil || root != "jeb_" {
+		t.Error("unchanged player was rewritten")
+	}
+	p, err := w.LoadPlayer("notch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Dimension != 0 || p.Health != 20 || len(p.Inventory) != 2 {
+		t.Error("changes not saved: ", fromPlayer(p))
+	}
+}
@@ -0,0 +1,112 @@
+package world
+
+import "minecraft/error"
+
+import "fmt"
+import "os"
+
+// Player inventory slots: 0-8 are the hotbar, 9-35 the main inventory and
+// 100-103 the armor, from boots up to helmet.
+const (
+	HotbarSlots = 9
+	PlayerSlots = 36
+	SlotBoots   = 100
+	SlotLegs    = 101
+	SlotChest   = 102
+	SlotHelmet  = 103
+)
+
+// checkPlayerSlot fails for slot numbers the game does not use.
+func checkPlayerSlot(n int8) os.Error {
+	if (n < 0 || n >= PlayerSlots) && (n < SlotBoots || n > SlotHelmet) {
+		return error.NewError(fmt.Sprint("no player inventory slot ", n), nil)
+	}
+	return nil
+}
+
+// GetSlot returns a copy of the stack in slot n, and whether there is one.
+func (p *Player) GetSlot(n int8) (*Item, bool) {
+	i := findSlot(p.Inventory, n)
+	if i < 0 {
+		return nil, false
+	}
+	item := p.Inventory[i].Item
+	return &item, true
+}
+
+// SetSlot puts count of item in slot n, replacing whatever was there.  Armor
+// slots hold a single item.  item.Count is ignored.
+func (p *Player) SetSlot(n int8, item Item, count int8) os.Error {
+	if err := checkPlayerSlot(n); err != nil {
+		return err
+	}
+	max := MaxStackSize(item.Id)
+	if n >= SlotBoots {
+		max = 1
+	}
+	if count <= 0 || int(count) > max {
+		return error.NewError(fmt.Sprintf("cannot put %d of item %d in slot %d", count, item.Id, n), nil)
+	}
+	item.Count = count
+	p.Inventory = putSlot(p.Inventory, n, item)
+	return nil
+}
+
+// ClearSlot empties slot n.
+func (p *Player) ClearSlot(n int8) os.Error {
+	if err := checkPlayerSlot(n); err != nil {
+		return err
+	}
+	p.Inventory = takeSlot(p.Inventory, n)
+	return nil
+}
+
+// AddToInventory gives the player up to count of item, topping up existing
+// stacks before filling empty slots, hotbar first.  Armor slots are left alone.
+// It returns how many fit.  item.Count is ignored.
+func (p *Player) AddToInventory(item Item, count int) (added int) {
+	max := MaxStackSize(item.Id)
+	for i := range p.Inventory {
+		s := &p.Inventory[i]
+		if added == count {
+			break
+		}
+		if s.Slot >= PlayerSlots || !stackable(s.Item, item) || int(s.Item.Count) >= max {
+			continue
+		}
+		n := min(max-int(s.Item.Count), count-added)
+		s.Item.Count += int8(n)
+		added += n
+	}
+	for slot := int8(0); slot < PlayerSlots && added < count; slot++ {
+		if findSlot(p.Inventory, slot) >= 0 {
+			continue
+		}
+		stack := item
+		n := min(max, count-added)
+		stack.Count = int8(n)
+		p.Inventory = putSlot(p.Inventory, slot, stack)
+		added += n
+	}
+	return
+}
+
+// Helmet returns the player's helmet, and whether they wear one.
+func (p *Player) Helmet() (*Item, bool) {
+	return p.GetSlot(SlotHelmet)
+}
+
+// Chest returns the player's chestplate, and whether they wear one.
+func (p *Player) Chest() (*Item, bool) {
+	return p.GetSlot(SlotChest)
+}
+
+// Legs returns the player's leggings, and whether they wear them.
+func (p *Player) Legs() (*Item, bool) {
+	return p.GetSlot(SlotLegs)
+}
+
+// Boots returns the player's boots, and whether they wear them.
+func (p *Player) Boots() (*Item, bool) {
+	return p.GetSlot(SlotBoots)
+}
@@ -0,0 +1,87 @@
+package world
+
+import "minecraft/nbt"
+
+import "testing"
+
+func TestAddToInventory(t *testing.T) {
+	p, err := toPlayer(playerFixture(false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// slot 1 already holds 37 torches
+	if added := p.AddToInventory(Item{Id: BlockTorch}, 100); added != 100 {
+		t.Fatal("expected 100 torches to fit, got ", added)
+	}
+	want := map[int8]int8{1: 64, 2: 64, 3: 9}
+	for n, count := range want {
+		if item, ok := p.GetSlot(n); !ok || item.Id != BlockTorch || item.Count != count {
+			t.Errorf("slot %d: expected %d torches, got %v", n, count, item)
+		}
+	}
+	if len(p.Inventory) != 5 {
+		t.Error("expected 5 occupied slots, got ", p.Inventory)
+	}
+
+	// as read from file, armor may come before the main slots
+	unsorted := &Player{Inventory: []InventorySlot{{SlotHelmet, Item{Id: 310, Count: 1}}, {5, Item{Id: BlockTorch, Count: 10}}}}
+	if added := unsorted.AddToInventory(Item{Id: BlockTorch}, 10); added != 10 {
+		t.Fatal("expected 10 torches to fit, got ", added)
+	}
+	if item, _ := unsorted.GetSlot(5); item.Count != 20 || len(unsorted.Inventory) != 2 {
+		t.Errorf("expected the torches stacked into slot 5, got %v", unsorted.Inventory)
+	}
+
+	for slot := int8(0); slot < PlayerSlots; slot++ {
+		p.SetSlot(slot, Item{Id: 4}, 64)
+	}
+	if added := p.AddToInventory(Item{Id: 4}, 1); added != 0 {
+		t.Error("full inventory took ", added, " more")
+	}
+}
+
+func TestEquipArmor(t *testing.T) {
+	p, _ := toPlayer(playerFixture(false))
+	if helmet, ok := p.Helmet(); !ok || helmet.Id != 310 {
+		t.Error("expected a diamond helmet, got ", helmet)
+	}
+	if _, ok := p.Boots(); ok {
+		t.Error("player should not be wearing boots")
+	}
+	if err := p.SetSlot(SlotBoots, Item{Id: 313}, 2); err == nil {
+		t.Error("expected an error for two boots in one slot")
+	}
+	if err := p.SetSlot(SlotBoots, Item{Id: 313}, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.SetSlot(SlotChest, Item{Id: 311}, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.ClearSlot(SlotHelmet); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.SetSlot(36, Item{Id: 1}, 1); err == nil {
+		t.Error("expected an error for slot 36")
+	}
+	if err := p.SetSlot(2, Item{Id: 276}, 2); err == nil {
+		t.Error("expected an error for stacked swords")
+	}
+
+	reread, err := toPlayer(fromPlayer(p))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !nbt.Equal(fromPlayer(reread), fromPlayer(p)) {
+		t.Error("expected ", fromPlayer(p), ", got ", fromPlayer(reread))
+	}
+	boots, _ := reread.Boots()
+	chest, _ := reread.Chest()
+	if _, ok := reread.Helmet(); ok || boots == nil || boots.Id != 313 || chest == nil || chest.Id != 311 {
+		t.Error("bad armor after round trip ", reread.Inventory)
+	}
+	for _, s := range reread.Inventory {
+		if s.Item.Count <= 0 {
+			t.Error("empty slot written: ", s)
+		}
+	}
+}
@@ -0,0 +1,180 @@
+package world
+
+import "fmt"
+import "io"
+import "os"
+import "sync"
+import "time"
+
+// A Progress is told of the course of the World's long operations: Flush,
+// ForEachChunk, ForEachEntity, LoadChunksParallel, the methods that stream
+// chunks through memory such as RemoveEntities, ExportRaw, ImportRaw, Report
+// and the renderers.  Each calls Begin, then Step as it finishes each of total
+// steps, with done counting them from 1, then End with the error it returns.
+// Warn reports a problem the operation passed over, such as a chunk's
+// Warnings.  Operations may run at once, from different goroutines, and Flush
+// calls Step from its workers.
+type Progress interface {
+	Begin(op string, total int)
+	Step(done int, detail string)
+	Warn(msg string)
+	End(err os.Error)
+}
+
+// task follows one operation, reporting it to a World's Progress and watching
+// its Cancel.  Its methods do nothing, and a nil *task is returned, when the
+// World has neither.
+type task struct {
+	p      Progress
+	cancel <-chan bool
+	op     string
+	total  int
+	mu     sync.Mutex // guards done and calls to p, for Flush's workers
+	done   int
+}
+
+// begin reports the start of op, of total steps, and returns its task.
+func (world *World) begin(op string, total int) *task {
+	if world.Progress == nil && world.Cancel == nil {
+		return nil
+	}
+	if world.Progress != nil {
+		world.Progress.Begin(op, total)
+	}
+	return &task{p: world.Progress, cancel: world.Cancel, op: op, total: total}
+}
+
+// step reports that the next step, on detail, is done.
+func (t *task) step(detail string) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	t.done++
+	if t.p != nil {
+		t.p.Step(t.done, detail)
+	}
+	t.mu.Unlock()
+}
+
+// chunk reports that the step for the chunk at (x, z) is done.
+func (t *task) chunk(x, z int32) {
+	if t != nil {
+		t.step(fmt.Sprintf("chunk (%d, %d)", x, z))
+	}
+}
+
+// warn reports a problem passed over.
+func (t *task) warn(msg string) {
+	if t == nil || t.p == nil {
+		return
+	}
+	t.mu.Lock()
+	t.p.Warn(msg)
+	t.mu.Unlock()
+}
+
+// warnChunk reports each of the chunk's Warnings.
+func (t *task) warnChunk(c *Chunk) {
+	if t == nil {
+		return
+	}
+	for _, w := range c.Warnings {
+		t.warn(fmt.Sprintf("chunk (%d, %d): %s", c.Level.XPos, c.Level.ZPos, w))
+	}
+}
+
+// cancelled returns a CancelledError, telling how many steps are done, if the
+// World's Cancel has been closed, and nil otherwise.  Operations call it before
+// each chunk, so that they stop between chunks.
+func (t *task) cancelled() os.Error {
+	if t == nil || t.cancel == nil {
+		return nil
+	}
+	select {
+	case <-t.cancel:
+	default:
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return CancelledError{t.op, t.done, t.total}
+}
+
+// end reports the end of the operation and returns err.
+func (t *task) end(err os.Error) os.Error {
+	if t != nil && t.p != nil {
+		t.p.End(err)
+	}
+	return err
+}
+
+// A ProgressWriter is a Progress writing lines of text to W, such as
+//
+//	Flush: 120/800 chunk (3, -7)
+//
+// Steps are written at most once every Interval nanoseconds, and the last step
+// always; warnings, and the start and end of each operation, are all written.
+// It follows one operation at a time: steps and warnings are put down to the
+// operation last begun and not yet ended.
+type ProgressWriter struct {
+	W        io.Writer
+	Interval int64
+
+	mu      sync.Mutex
+	ops     []progressOp // the operations begun and not ended, innermost last
+	written int64        // when a step was last written
+}
+
+type progressOp struct {
+	name  string
+	total int
+	start int64
+}
+
+func (pw *ProgressWriter) Begin(op string, total int) {
+	pw.mu.Lock()
+	defer pw.mu.Unlock()
+	pw.ops = append(pw.ops, progressOp{op, total, time.Nanoseconds()})
+	fmt.Fprintf(pw.W, "%s: %d steps\n", op, total)
+}
+
+func (pw *ProgressWriter) Step(done int, detail string) {
+	pw.mu.Lock()
+	defer pw.mu.Unlock()
+	op := pw.op()
+	now := time.Nanoseconds()
+	if done < op.total && now-pw.written < pw.Interval {
+		return
+	}
+	pw.written = now
+	fmt.Fprintf(pw.W, "%s: %d/%d %s\n", op.name, done, op.total, detail)
+}
+
+func (pw *ProgressWriter) Warn(msg string) {
+	pw.mu.Lock()
+	defer pw.mu.Unlock()
+	fmt.Fprintf(pw.W, "%s: warning: %s\n", pw.op().name, msg)
+}
+
+func (pw *ProgressWriter) End(err os.Error) {
+	pw.mu.Lock()
+	defer pw.mu.Unlock()
+	op := pw.op()
+	if len(pw.ops) > 0 {
+		pw.ops = pw.ops[:len(pw.ops)-1]
+	}
+	if err != nil {
+		fmt.Fprintf(pw.W, "%s: failed: %s\n", op.name, err)
+		return
+	}
+	fmt.Fprintf(pw.W, "%s: done in %.1fs\n", op.name, float64(time.Nanoseconds()-op.start)/1e9)
+}
+
+// op returns the innermost operation begun and not ended.  The caller holds mu.
+func (pw *ProgressWriter) op() progressOp {
+	if len(pw.ops) == 0 {
+		return progressOp{name: "?"}
+	}
+	return pw.ops[len(pw.ops)-1]
+}
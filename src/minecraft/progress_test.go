@@ -0,0 +1,392 @@
+package world
+
+import "bytes"
+import "fmt"
+import "image/png"
+import "io/ioutil"
+import "os"
+import "path"
+import "strings"
+import "testing"
+
+// progressLog is a Progress noting what it is told, one line for each call.
+type progressLog []string
+
+func (l *progressLog) Begin(op string, total int)   { l.add("begin %s %d", op, total) }
+func (l *progressLog) Step(done int, detail string) { l.add("step %d %s", done, detail) }
+func (l *progressLog) Warn(msg string)              { l.add("warn %s", msg) }
+func (l *progressLog) End(err os.Error) {
+	if err != nil {
+		l.add("end failed")
+	} else {
+		l.add("end")
+	}
+}
+
+func (l *progressLog) add(format string, args ...interface{}) {
+	*l = append(*l, fmt.Sprintf(format, args...))
+}
+
+func expectProgress(t *testing.T, got progressLog, want ...string) {
+	if len(got) != len(want) {
+		t.Errorf("expected %q, got %q", want, got)
+		return
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("%d: expected %q, got %q", i, want[i], got[i])
+		}
+	}
+}
+
+func TestProgressConverter(t *testing.T) {
+	dir := makeTestWorld(t,
+		testChunkPayload(0, 0, nil, nil),
+		testChunkPayload(0, 1, nil, nil),
+		testChunkPayload(1, 0, nil, nil))
+	defer os.RemoveAll(dir)
+	out, err := ioutil.TempDir("", "raw")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(out)
+	w, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	log := new(progressLog)
+	w.Progress = log
+
+	if err = w.ExportRaw(out, nil, ExportNBT); err != nil {
+		t.Fatal(err)
+	}
+	expectProgress(t, *log,
+		"begin ExportRaw 3",
+		"step 1 chunk (0, 0)",
+		"step 2 chunk (0, 1)",
+		"step 3 chunk (1, 0)",
+		"end")
+
+	*log = nil
+	if err = w.ImportRaw(out); err != nil {
+		t.Fatal(err)
+	}
+	entries, _ := ioutil.ReadDir(out)
+	if len(*log) != len(entries)+2 || (*log)[0] != fmt.Sprint("begin ImportRaw ", len(entries)) || (*log)[len(*log)-1] != "end" {
+		t.Errorf("expected a step for each of %d entries, got %q", len(entries), *log)
+	}
+
+	// a chunk that cannot be read ends the export, which says so
+	if err = ioutil.WriteFile(chunkPath(w.dir, 0, 1), []byte("not a chunk"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	*log = nil
+	if err = w.ExportRaw(out, nil, ExportNBT); err == nil {
+		t.Fatal("expected an error exporting a corrupt chunk")
+	}
+	expectProgress(t, *log, "begin ExportRaw 3", "step 1 chunk (0, 0)", "end failed")
+}
+
+func TestProgressWarnings(t *testing.T) {
+	dir := makeTestWorld(t,
+		testChunkPayload(0, 0, nil, nil),
+		testChunkPayload(-1, 0, nil, []interface{}{spawnerFixture}))
+	defer os.RemoveAll(dir)
+	w, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	log := new(progressLog)
+	w.Progress = log
+
+	if _, err = w.Report(nil, SkipDiskSize); err != nil {
+		t.Fatal(err)
+	}
+	// the spawner is both a chunk warning and a problem of the report's own
+	if len(*log) != 6 || (*log)[0] != "begin Report 2" || !strings.HasPrefix((*log)[1], "warn chunk (-1, 0): ") ||
+		(*log)[2] != "warn MobSpawner at (-3, 20, 7) has no block" || (*log)[5] != "end" {
+		t.Errorf("expected the spawner's problems reported, got %q", *log)
+	}
+
+	*log = nil
+	if _, err = w.RemoveEntities(func(*Entity) bool { return false }, nil); err != nil {
+		t.Fatal(err)
+	}
+	if len(*log) != 5 || (*log)[0] != "begin RemoveEntities 2" || !strings.HasPrefix((*log)[1], "warn chunk (-1, 0): ") {
+		t.Errorf("expected streaming to report the warning, got %q", *log)
+	}
+
+	*log = nil
+	if _, err = w.GetChunk(0, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err = w.ForEachChunk(func(c *Chunk) os.Error { return c.SetBlock(0, 64, 0, BlockStone, 0) }); err != nil {
+		t.Fatal(err)
+	}
+	if err = w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	expectProgress(t, *log,
+		"begin ForEachChunk 1", "step 1 chunk (0, 0)", "end",
+		"begin Flush 1", "step 1 chunk (0, 0)", "end")
+}
+
+func TestProgressWriter(t *testing.T) {
+	var buf bytes.Buffer
+	pw := &ProgressWriter{W: &buf, Interval: 1e12}
+	pw.Begin("Flush", 3)
+	pw.Step(1, "chunk (0, 0)")
+	pw.Step(2, "chunk (0, 1)")
+	pw.Warn("something odd")
+	pw.Step(3, "chunk (1, 0)")
+	pw.End(nil)
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n", -1)
+	want := []string{"Flush: 3 steps", "Flush: 1/3 chunk (0, 0)", "Flush: warning: something odd", "Flush: 3/3 chunk (1, 0)"}
+	if len(lines) != len(want)+1 || !strings.HasPrefix(lines[len(want)], "Flush: done in ") {
+		t.Fatalf("expected the second step left out, got %q", lines)
+	}
+	for i := range want {
+		if lines[i] != want[i] {
+			t.Errorf("%d: expected %q, got %q", i, want[i], lines[i])
+		}
+	}
+
+	buf.Reset()
+	pw.Interval = 0
+	pw.Begin("ExportRaw", 2)
+	pw.Step(1, "chunk (0, 0)")
+	pw.Step(2, "chunk (0, 1)")
+	pw.End(os.ErrorString("disk full"))
+	if got := buf.String(); got != "ExportRaw: 2 steps\nExportRaw: 1/2 chunk (0, 0)\nExportRaw: 2/2 chunk (0, 1)\nExportRaw: failed: disk full\n" {
+		t.Errorf("expected every step without an interval, got %q", got)
+	}
+}
+
+// cancelAfter is a Progress that closes cancel once n steps are done, and then
+// forgets it.
+type cancelAfter struct {
+	n      int
+	cancel chan bool
+}
+
+func (c *cancelAfter) Begin(op string, total int) {}
+func (c *cancelAfter) Warn(msg string)            {}
+func (c *cancelAfter) End(err os.Error)           {}
+func (c *cancelAfter) Step(done int, detail string) {
+	if done == c.n && c.cancel != nil {
+		close(c.cancel)
+		c.cancel = nil
+	}
+}
+
+// cancelWorld opens a world of chunks (0, 0) through (n-1, 0) that cancels
+// each operation once it has done after steps.
+func cancelWorld(t *testing.T, n int32, after int) (dir string, w *World, p *cancelAfter) {
+	var chunks []map[string]interface{}
+	for x := int32(0); x < n; x++ {
+		chunks = append(chunks, testChunkPayload(x, 0, nil, nil))
+	}
+	dir = makeTestWorld(t, chunks...)
+	w, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p = &cancelAfter{n: after}
+	w.Progress = p
+	p.rearm(w)
+	return
+}
+
+// rearm gives w a new Cancel for the next operation.
+func (c *cancelAfter) rearm(w *World) {
+	c.cancel = make(chan bool)
+	w.Cancel = c.cancel
+}
+
+func expectCancelled(t *testing.T, err os.Error, op string, done, total int) {
+	e, ok := Cancelled(err)
+	if !ok || !IsError(err, ErrCancelled) {
+		t.Errorf("%s: expected a cancel, got %v", op, err)
+		return
+	}
+	if e.Op != op || e.Done != done || e.Total != total {
+		t.Errorf("%s: expected cancelled after %d of %d steps, got %v", op, done, total, e)
+	}
+}
+
+func TestCancelConversion(t *testing.T) {
+	dir, w, p := cancelWorld(t, 4, 2)
+	defer os.RemoveAll(dir)
+	defer w.Close()
+	out, err := ioutil.TempDir("", "raw")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(out)
+
+	err = w.ExportRaw(out, nil, ExportNBT)
+	expectCancelled(t, err, "ExportRaw", 2, 4)
+	if got := err.String(); got != "ExportRaw cancelled after 2 of 4 steps" {
+		t.Errorf("expected the error to say how far the export got, got %q", got)
+	}
+	entries, err := ioutil.ReadDir(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exported := 0
+	for _, fi := range entries {
+		if strings.HasPrefix(fi.Name, "c.") {
+			exported++
+		}
+	}
+	if exported != 2 {
+		t.Errorf("expected the 2 chunks before the cancel exported, got %d", exported)
+	}
+
+	// what was exported is whole, and imports
+	into := makeTestWorld(t)
+	defer os.RemoveAll(into)
+	w2, err := Open(into)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w2.Close()
+	if err = w2.ImportRaw(out); err != nil {
+		t.Fatal(err)
+	}
+	if coords, err := w2.ListChunks(nil); err != nil || len(coords) != 2 || coords[0].X != 0 || coords[1].X != 1 {
+		t.Errorf("expected chunks (0, 0) and (1, 0) imported, got %v (%v)", coords, err)
+	}
+
+	// a closed Cancel cancels every operation until it is replaced
+	if err = w.ExportRaw(out, nil, ExportNBT); !IsError(err, ErrCancelled) {
+		t.Error("expected the export cancelled again, got ", err)
+	}
+	p.rearm(w)
+	p.n = 0
+	if err = w.ExportRaw(out, nil, ExportNBT); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCancelStreaming(t *testing.T) {
+	dir, w, p := cancelWorld(t, 4, 1)
+	defer os.RemoveAll(dir)
+	defer w.Close()
+
+	removed, err := w.RemoveEntities(func(*Entity) bool { return true }, nil)
+	expectCancelled(t, err, "RemoveEntities", 1, 4)
+	if removed != 0 {
+		t.Error("expected nothing removed, got ", removed)
+	}
+	p.rearm(w)
+	err = w.ForEachEntity(nil, func(x, z int32, e *Entity) EntityAction { return Keep })
+	expectCancelled(t, err, "ForEachEntity", 1, 4)
+	p.rearm(w)
+	_, err = w.Report(nil, SkipDiskSize)
+	expectCancelled(t, err, "Report", 1, 4)
+
+	for x := int32(0); x < 2; x++ {
+		c, err := w.GetChunk(x, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c.SetBlock(0, 64, 0, BlockStone, 0)
+	}
+	p.rearm(w)
+	var buf bytes.Buffer
+	err = w.RenderMap(&buf, nil, RenderOptions{})
+	expectCancelled(t, err, "RenderMap", 1, 4)
+	m, err := png.Decode(&buf)
+	if err != nil {
+		t.Fatal("expected a whole PNG, got ", err)
+	}
+	if b := m.Bounds(); b.Dx() != 4*ChunkWidth || b.Dy() != ChunkDepth {
+		t.Errorf("expected the whole region drawn, got %v", b)
+	}
+	if _, _, _, a := m.At(0, 0).RGBA(); a == 0 {
+		t.Error("expected the first chunk drawn")
+	}
+	if _, _, _, a := m.At(ChunkWidth, 0).RGBA(); a != 0 {
+		t.Error("expected the chunks after the cancel left transparent")
+	}
+
+	// a cancelled render leaves no manifest to skip the tiles it missed
+	tiles, err := ioutil.TempDir("", "tiles")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tiles)
+	cancel := make(chan bool)
+	close(cancel)
+	w.Cancel = cancel
+	err = w.RenderTiles(tiles, nil, TileOptions{})
+	expectCancelled(t, err, "RenderTiles", 0, 1)
+	if _, err = os.Stat(path.Join(tiles, "tiles.manifest")); err == nil {
+		t.Error("expected no manifest written")
+	}
+	w.Cancel = nil
+}
+
+func TestCancelResident(t *testing.T) {
+	dir, w, p := cancelWorld(t, 16, 2)
+	defer os.RemoveAll(dir)
+	defer w.Close()
+	var coords []XZ
+	for x := int32(0); x < 16; x++ {
+		coords = append(coords, MakeXZ(x, 0))
+	}
+
+	// the workers stop reading and drain
+	loaded, missing, err := w.LoadChunksParallel(coords, 2)
+	if e, ok := Cancelled(err); !ok || e.Op != "LoadChunksParallel" || e.Total != 16 || e.Done != len(loaded) {
+		t.Errorf("expected a cancel counting the chunks loaded, got %v", err)
+	}
+	if len(loaded) < 2 || len(loaded) == 16 || len(missing) != 0 {
+		t.Errorf("expected some of the chunks loaded, got %d (%d missing)", len(loaded), len(missing))
+	}
+	if n := w.LoadedChunkCount(); n != len(loaded) {
+		t.Errorf("expected the %d chunks loaded resident, got %d", len(loaded), n)
+	}
+
+	p.rearm(w)
+	p.n = 0
+	if _, _, err = w.LoadChunksParallel(coords, 2); err != nil {
+		t.Fatal(err)
+	}
+	p.rearm(w)
+	p.n = 3
+	err = w.ForEachChunk(func(c *Chunk) os.Error { return c.SetBlock(0, 64, 0, BlockStone, 0) })
+	expectCancelled(t, err, "ForEachChunk", 3, 16)
+	if n := w.DirtyChunkCount(); n != 3 {
+		t.Errorf("expected 3 chunks changed, got %d", n)
+	}
+
+	// the chunks not flushed stay dirty
+	p.rearm(w)
+	p.n = 1
+	w.FlushWorkers = 1
+	err = w.Flush()
+	expectCancelled(t, err, "Flush", 1, 16)
+	if n := w.DirtyChunkCount(); n < 2 {
+		t.Errorf("expected the chunks after the cancel left dirty, got %d dirty", n)
+	}
+	w.Cancel = nil
+	if err = w.Flush(); err != nil || w.DirtyChunkCount() != 0 {
+		t.Errorf("expected the rest flushed, got %v", err)
+	}
+}
+
+func BenchmarkForEachChunkNoProgress(b *testing.B) {
+	w := &World{Chunks: make(map[XZ]*Chunk)}
+	for x := int32(0); x < 64; x++ {
+		w.Chunks[MakeXZ(x, 0)] = newChunk(x, 0)
+	}
+	noop := func(c *Chunk) os.Error { return nil }
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		w.ForEachChunk(noop)
+	}
+}
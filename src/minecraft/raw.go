@@ -0,0 +1,360 @@
+package world
+
+import "minecraft/error"
+import "minecraft/nbt"
+
+import "bytes"
+import "fmt"
+import "io/ioutil"
+import "json"
+import "os"
+import "path"
+import "strconv"
+import "strings"
+
+// ExportFormat chooses how ExportRaw writes each file.
+type ExportFormat int
+
+const (
+	// ExportNBT writes NBT as the game does, but without gzip, so that each
+	// file is the same bytes whenever its tags are the same.
+	ExportNBT ExportFormat = iota
+	// ExportText writes the typed JSON encoding of NBT that ExportEntitiesJSON
+	// uses for extra tags, {"compound": {"name": tag, ...}}, with a tag on each
+	// line, for reading and line-based diffs.  The root tag's name, which the
+	// game leaves empty, is not kept.
+	ExportText
+)
+
+// extension returns the file name extension of the format.
+func (format ExportFormat) extension() string {
+	if format == ExportText {
+		return ".json"
+	}
+	return ".nbt"
+}
+
+// rawLevel is the name ExportRaw gives the copy of level.dat, before its
+// extension.
+const rawLevel = "level"
+
+// ExportRaw writes region of the world (nil meaning every chunk) to dir, which
+// is created if need be, as a flat directory of uncompressed files in the given
+// format: c.<x>.<z>.nbt for each chunk, with its chunk coordinates in decimal,
+// level.nbt for level.dat and players/<name>.nbt for each player, or .json for
+// ExportText.  The tags of compounds are written in order of their names, so
+// that exporting the same world twice gives the same files, and files for
+// chunks, players or a region no longer exported are left in place.  Resident
+// chunks are written as they are in memory, with their unsaved changes; the rest
+// are copied from disk and not kept.
+func (world *World) ExportRaw(dir string, region *Region, format ExportFormat) os.Error {
+	if format != ExportNBT && format != ExportText {
+		return error.NewError(fmt.Sprint("unknown export format ", format), nil)
+	}
+	if err := os.MkdirAll(path.Join(dir, playersdir), 0755); err != nil {
+		return error.NewError("could not create export directory", err)
+	}
+	ext := format.extension()
+	if err := writeRaw(path.Join(dir, rawLevel+ext), "", world.fromLevelDat(), format); err != nil {
+		return err
+	}
+	names, err := world.PlayerNames()
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		tagName, payload, err := nbt.Load(world.playerPath(name))
+		if err != nil {
+			return error.NewError(fmt.Sprintf("could not load player %q", name), err)
+		}
+		if err = writeRaw(path.Join(dir, playersdir, name+ext), tagName, payload, format); err != nil {
+			return err
+		}
+	}
+
+	coords, err := world.ListChunks(region)
+	if err != nil {
+		return err
+	}
+	t := world.begin("ExportRaw", len(coords))
+	for _, xz := range coords {
+		if err = t.cancelled(); err != nil {
+			return t.end(err)
+		}
+		tagName, payload := "", map[string]interface{}(nil)
+		if c, ok := world.resident(MakeXZ(xz.X, xz.Z)); ok {
+			if payload, err = fromChunk(c); err != nil {
+				return t.end(err)
+			}
+		} else if tagName, payload, err = world.loadChunkMap(xz.X, xz.Z, keepAll); err != nil {
+			return t.end(err)
+		}
+		file := path.Join(dir, fmt.Sprintf("c.%d.%d%s", xz.X, xz.Z, ext))
+		if err = writeRaw(file, tagName, payload, format); err != nil {
+			return t.end(err)
+		}
+		t.chunk(xz.X, xz.Z)
+	}
+	return t.end(nil)
+}
+
+// writeRaw writes a compound to file in the given format.
+func writeRaw(file, name string, payload map[string]interface{}, format ExportFormat) os.Error {
+	buf := new(bytes.Buffer)
+	if format == ExportText {
+		v, err := jsonTag(payload)
+		if err != nil {
+			return error.NewError("could not encode "+file, err)
+		}
+		if err = writeJSONIndented(buf, v, ""); err != nil {
+			return error.NewError("could not encode "+file, err)
+		}
+		buf.WriteString("\n")
+	} else if err := nbt.WriteTagCompound(buf, name, payload); err != nil {
+		return error.NewError("could not encode "+file, err)
+	}
+	if err := ioutil.WriteFile(file, buf.Bytes(), 0644); err != nil {
+		return error.NewError("could not write "+file, err)
+	}
+	return nil
+}
+
+// readRaw reads a file written by writeRaw, telling its format by its extension.
+func readRaw(file string) (name string, payload map[string]interface{}, err os.Error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return "", nil, error.NewError("could not read "+file, err)
+	}
+	if !strings.HasSuffix(file, ExportText.extension()) {
+		if name, payload, err = nbt.ReadTagCompound(bytes.NewBuffer(data)); err != nil {
+			return "", nil, error.NewError("could not decode "+file, err)
+		}
+		return
+	}
+	var v interface{}
+	if err = json.Unmarshal(data, &v); err != nil {
+		return "", nil, error.NewError("could not parse "+file, err)
+	}
+	tag, err := tagFromJSON(v)
+	if err != nil {
+		return "", nil, error.NewError("could not decode "+file, err)
+	}
+	if payload, _ = tag.(map[string]interface{}); payload == nil {
+		return "", nil, error.NewError(file+" does not hold a compound", nil)
+	}
+	return
+}
+
+// ImportRaw reads a directory written by ExportRaw, in either format, into the
+// world: every chunk found replaces the chunk at its coordinates, dropping it if
+// it is resident, and level.dat and the players are replaced if they were
+// exported.  Files are written as they are read, so an import that fails partway
+// leaves the world partly replaced.  Chunks are checked for the tags the game
+// needs before anything is written, as are level.dat and the players.  Progress
+// is told of a step for each entry of dir.
+func (world *World) ImportRaw(dir string) os.Error {
+	if err := world.VerifyLockNow(); err != nil {
+		return err
+	}
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return error.NewError("could not list import directory", err)
+	}
+	t := world.begin("ImportRaw", len(files))
+	return t.end(world.importRaw(dir, files, t))
+}
+
+// importRaw does the work of ImportRaw on files, the entries of dir.
+func (world *World) importRaw(dir string, files []*os.FileInfo, t *task) (err os.Error) {
+	for _, fi := range files {
+		if err = t.cancelled(); err != nil {
+			return
+		}
+		if err = world.importRawFile(dir, fi); err != nil {
+			return
+		}
+		t.step(fi.Name)
+	}
+
+	players, err := ioutil.ReadDir(path.Join(dir, playersdir))
+	if err != nil {
+		if e, ok := err.(*os.PathError); ok && e.Error == os.ENOENT {
+			return nil
+		}
+		return error.NewError("could not list exported players", err)
+	}
+	for _, fi := range players {
+		if name, ok := rawBase(fi.Name); ok && fi.IsRegular() {
+			if err = world.importPlayer(path.Join(dir, playersdir, fi.Name), name); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// importRawFile imports fi, an entry of dir, if it is a chunk or level.dat.
+func (world *World) importRawFile(dir string, fi *os.FileInfo) os.Error {
+	if !fi.IsRegular() {
+		return nil
+	}
+	file := path.Join(dir, fi.Name)
+	base, ok := rawBase(fi.Name)
+	switch {
+	case !ok:
+		return nil
+	case base == rawLevel:
+		return world.importLevel(file)
+	}
+	x, z, ok := parseRawChunkName(base)
+	if !ok {
+		return nil
+	}
+	return world.importChunk(file, x, z)
+}
+
+// rawBase returns name without the extension of an export format, or false if
+// it has neither.
+func rawBase(name string) (string, bool) {
+	for _, format := range []ExportFormat{ExportNBT, ExportText} {
+		if ext := format.extension(); strings.HasSuffix(name, ext) {
+			return name[:len(name)-len(ext)], true
+		}
+	}
+	return "", false
+}
+
+// parseRawChunkName extracts the chunk coordinates from c.<x>.<z>, as ExportRaw
+// names chunks.
+func parseRawChunkName(base string) (x, z int32, ok bool) {
+	parts := strings.Split(base, ".", -1)
+	if len(parts) != 3 || parts[0] != "c" {
+		return
+	}
+	px, err := strconv.Atoi(parts[1])
+	if err != nil || int(int32(px)) != px {
+		return
+	}
+	pz, err := strconv.Atoi(parts[2])
+	if err != nil || int(int32(pz)) != pz {
+		return
+	}
+	return int32(px), int32(pz), true
+}
+
+// importChunk writes the exported chunk in file to the world as chunk (x, z).
+func (world *World) importChunk(file string, x, z int32) os.Error {
+	name, payload, err := readRaw(file)
+	if err != nil {
+		return err
+	}
+	if err = checkChunkPayload(payload, x, z); err != nil {
+		return error.InChunk(x, z).Error("malformed chunk", err)
+	}
+	if err = world.saveChunkMap(x, z, name, payload); err != nil {
+		return err
+	}
+	world.drop(MakeXZ(x, z))
+	return nil
+}
+
+// checkChunkPayload checks that payload has the tags toChunk expects of chunk
+// (x, z).
+func checkChunkPayload(payload map[string]interface{}, x, z int32) os.Error {
+	level, err := getCompound(payload, "Level")
+	if err != nil {
+		return err
+	}
+	for _, array := range []struct {
+		name string
+		size int
+	}{
+		{"Blocks", chunkBlocks},
+		{"Data", chunkNibbles},
+		{"SkyLight", chunkNibbles},
+		{"BlockLight", chunkNibbles},
+		{"HeightMap", chunkColumns},
+	} {
+		b, ok := level[array.name].([]byte)
+		if !ok {
+			return tagError(array.name, "byte array", level[array.name])
+		}
+		if len(b) != array.size {
+			return error.NewError(fmt.Sprintf("expected %d bytes of %s, got %d", array.size, array.name, len(b)), nil)
+		}
+	}
+	for _, name := range []string{"Entities", "TileEntities"} {
+		if _, err = getList(level, name); err != nil {
+			return err
+		}
+	}
+	if _, err = getInt64(level, "LastUpdate"); err != nil {
+		return err
+	}
+	if _, err = getInt8(level, "TerrainPopulated"); err != nil {
+		return err
+	}
+	px, err := getInt32(level, "xPos")
+	if err != nil {
+		return err
+	}
+	pz, err := getInt32(level, "zPos")
+	if err != nil {
+		return err
+	}
+	if px != x || pz != z {
+		return error.InChunk(px, pz).Error("holds chunk", nil)
+	}
+	return nil
+}
+
+// importLevel replaces level.dat with the exported copy in file.
+func (world *World) importLevel(file string) os.Error {
+	_, payload, err := readRaw(file)
+	if err != nil {
+		return err
+	}
+	data, err := getCompound(payload, "Data")
+	if err == nil {
+		for _, name := range []string{"Time", "LastPlayed", "SizeOnDisk", "RandomSeed"} {
+			if _, err = getInt64(data, name); err != nil {
+				break
+			}
+		}
+	}
+	if err == nil {
+		for _, name := range []string{"SpawnX", "SpawnY", "SpawnZ"} {
+			if _, err = getInt32(data, name); err != nil {
+				break
+			}
+		}
+	}
+	if err == nil {
+		_, err = getInt8(data, "SnowCovered")
+	}
+	if err == nil {
+		err = world.loadLevelDat(payload)
+	}
+	if err != nil {
+		return error.NewError(fmt.Sprint("could not decode exported ", leveldat), err)
+	}
+	return world.SaveLevel()
+}
+
+// importPlayer replaces the named player with the exported copy in file.
+func (world *World) importPlayer(file, name string) os.Error {
+	tagName, payload, err := readRaw(file)
+	if err != nil {
+		return err
+	}
+	if _, err = toPlayer(payload); err != nil {
+		return error.NewError(fmt.Sprintf("could not decode exported player %q", name), err)
+	}
+	if err = os.MkdirAll(path.Join(world.dir, playersdir), 0755); err != nil {
+		return error.NewError("could not create players directory", err)
+	}
+	if err = nbt.Save(world.playerPath(name), tagName, payload); err != nil {
+		return error.NewError(fmt.Sprintf("could not save player %q", name), err)
+	}
+	return nil
+}
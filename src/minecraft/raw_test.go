@@ -0,0 +1,148 @@
+package world
+
+import "minecraft/nbt"
+
+import "bytes"
+import "io/ioutil"
+import "os"
+import "path"
+import "testing"
+
+// exportRaw exports the whole of w in format to a new directory and returns it.
+func exportRaw(t *testing.T, w *World, format ExportFormat) string {
+	dir, err := ioutil.TempDir("", "export")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = w.ExportRaw(dir, nil, format); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+// sameFiles reports whether the directories a and b hold the same files, with
+// the same bytes, one directory deep.
+func sameFiles(t *testing.T, a, b string) bool {
+	files, err := ioutil.ReadDir(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	others, err := ioutil.ReadDir(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != len(others) {
+		return false
+	}
+	for _, fi := range files {
+		if fi.IsDirectory() {
+			if !sameFiles(t, path.Join(a, fi.Name), path.Join(b, fi.Name)) {
+				return false
+			}
+			continue
+		}
+		da, err := ioutil.ReadFile(path.Join(a, fi.Name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		db, err := ioutil.ReadFile(path.Join(b, fi.Name))
+		if err != nil || !bytes.Equal(da, db) {
+			return false
+		}
+	}
+	return true
+}
+
+func TestExportRawRoundTrip(t *testing.T) {
+	dir := makeReportWorld(t)
+	defer os.RemoveAll(dir)
+	src, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer src.Close()
+	coords, err := src.ListChunks(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, format := range []ExportFormat{ExportNBT, ExportText} {
+		exported := exportRaw(t, src, format)
+		defer os.RemoveAll(exported)
+		again := exportRaw(t, src, format)
+		defer os.RemoveAll(again)
+		if !sameFiles(t, exported, again) {
+			t.Errorf("format %d: expected exporting twice to give the same files", format)
+		}
+		if _, err = os.Stat(path.Join(exported, "c.0.-1"+format.extension())); err != nil {
+			t.Errorf("format %d: expected chunk (0, -1) named by its coordinates: %s", format, err)
+		}
+
+		target := makeTestWorld(t, testChunkPayload(3, 3, nil, nil))
+		defer os.RemoveAll(target)
+		w, err := Open(target)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = w.GetChunk(3, 3); err != nil {
+			t.Fatal(err)
+		}
+		if err = w.ImportRaw(exported); err != nil {
+			t.Fatalf("format %d: %s", format, err)
+		}
+		for _, xz := range coords {
+			_, want, err := nbt.Load(chunkPath(src.dir, xz.X, xz.Z))
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, got, err := nbt.Load(chunkPath(w.dir, xz.X, xz.Z))
+			if err != nil {
+				t.Fatalf("format %d: chunk (%d, %d): %s", format, xz.X, xz.Z, err)
+			}
+			if !nbt.Equal(got, want) {
+				t.Errorf("format %d: chunk (%d, %d) differs after the round trip", format, xz.X, xz.Z)
+			}
+		}
+		if !w.ChunkExists(3, 3) {
+			t.Errorf("format %d: expected chunk (3, 3), not exported, to be kept", format)
+		}
+		_, wantLevel, _ := nbt.Load(path.Join(dir, leveldat))
+		if _, gotLevel, err := nbt.Load(path.Join(target, leveldat)); err != nil || !nbt.Equal(gotLevel, wantLevel) {
+			t.Errorf("format %d: expected level.dat to be imported (%v)", format, err)
+		}
+		_, wantPlayer, _ := nbt.Load(src.playerPath("notch"))
+		if _, gotPlayer, err := nbt.Load(w.playerPath("notch")); err != nil || !nbt.Equal(gotPlayer, wantPlayer) {
+			t.Errorf("format %d: expected the player to be imported (%v)", format, err)
+		}
+		w.Close()
+	}
+}
+
+func TestImportRawMalformed(t *testing.T) {
+	dir := makeReportWorld(t)
+	defer os.RemoveAll(dir)
+	src, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer src.Close()
+	exported := exportRaw(t, src, ExportNBT)
+	defer os.RemoveAll(exported)
+	if err = os.Rename(path.Join(exported, "c.0.-1.nbt"), path.Join(exported, "c.0.-2.nbt")); err != nil {
+		t.Fatal(err)
+	}
+
+	target := makeTestWorld(t)
+	defer os.RemoveAll(target)
+	w, err := Open(target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	if err = w.ImportRaw(exported); err == nil {
+		t.Error("expected an error for a chunk named for other coordinates")
+	}
+	if w.ChunkExists(0, -2) {
+		t.Error("expected the misnamed chunk not to be written")
+	}
+}
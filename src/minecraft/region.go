@@ -0,0 +1,38 @@
+package world
+
+// A Region is a rectangle of chunks given in chunk coordinates.  Both corners are
+// inclusive.
+type Region struct {
+	MinX, MinZ int32
+	MaxX, MaxZ int32
+}
+
+// NewRegion returns the region spanning chunks (x1, z1) through (x2, z2), in any order.
+func NewRegion(x1, z1, x2, z2 int32) *Region {
+	return &Region{min32(x1, x2), min32(z1, z2), max32(x1, x2), max32(z1, z2)}
+}
+
+// BlockRegion returns the smallest region holding every block column from
+// (x1, z1) through (x2, z2), given in absolute block coordinates.
+func BlockRegion(x1, z1, x2, z2 int32) *Region {
+	return NewRegion(x1>>4, z1>>4, x2>>4, z2>>4)
+}
+
+func (r *Region) Contains(x, z int32) bool {
+	return x >= r.MinX && x <= r.MaxX && z >= r.MinZ && z <= r.MaxZ
+}
+
+// Width is the number of chunks the region spans along x.
+func (r *Region) Width() int32 {
+	return r.MaxX - r.MinX + 1
+}
+
+// Depth is the number of chunks the region spans along z.
+func (r *Region) Depth() int32 {
+	return r.MaxZ - r.MinZ + 1
+}
+
+// Union returns the smallest region holding both r and other.
+func (r *Region) Union(other *Region) *Region {
+	return &Region{min32(r.MinX, other.MinX), min32(r.MinZ, other.MinZ), max32(r.MaxX, other.MaxX), max32(r.MaxZ, other.MaxZ)}
+}
@@ -0,0 +1,202 @@
+package world
+
+import "minecraft/error"
+
+import "fmt"
+import "os"
+import "sync"
+import "syscall"
+
+// regionMaps are memory maps of region files, read from where they lie.  A
+// file is mapped by the first read from it, and unmapped before it is written
+// to, as a write may reuse the sectors of a chunk read from it, or by close;
+// but never while bytes lent from it are still in use.  Each loan is given
+// back by calling the function that came with it, and unmapping waits for them
+// all.  Once a file cannot be mapped, on a system that cannot map files, say,
+// none is, and files are read as the regionStore reads them.
+type regionMaps struct {
+	mu    sync.Mutex
+	maps  map[string]*regionMap
+	nomap bool // map nothing: mapping failed, or the maps are closed
+}
+
+// A regionMap is the mapping of a region file, with its loans.
+type regionMap struct {
+	b     []byte
+	loans sync.WaitGroup
+}
+
+// get returns the mapping of file with a loan of it taken, or nil if file is
+// not to be mapped.
+func (r *regionMaps) get(file string) *regionMap {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.nomap {
+		return nil
+	}
+	m, ok := r.maps[file]
+	if !ok {
+		var failed bool
+		if m, failed = mapFile(file); m == nil {
+			r.nomap = failed
+			return nil
+		}
+		if r.maps == nil {
+			r.maps = make(map[string]*regionMap)
+		}
+		r.maps[file] = m
+	}
+	m.loans.Add(1)
+	return m
+}
+
+// mapFile maps file whole.  It returns nil if file is missing or empty, for
+// the regionStore to make what it will of it, and if mapping failed.
+func mapFile(file string) (m *regionMap, failed bool) {
+	f, err := os.Open(file, os.O_RDONLY, 0)
+	if err != nil {
+		return nil, false
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil || fi.Size == 0 {
+		return nil, false
+	}
+	if fi.Size != int64(int(fi.Size)) {
+		return nil, true
+	}
+	b, errno := syscall.Mmap(f.Fd(), 0, int(fi.Size), syscall.PROT_READ, syscall.MAP_SHARED)
+	if errno != 0 {
+		return nil, true
+	}
+	return &regionMap{b: b}, false
+}
+
+// unmap unmaps file, if it is mapped, once its loans are given back.
+func (r *regionMaps) unmap(file string) os.Error {
+	r.mu.Lock()
+	m, ok := r.maps[file]
+	if ok {
+		r.maps[file] = nil, false
+	}
+	r.mu.Unlock()
+	if !ok {
+		return nil
+	}
+	m.loans.Wait()
+	if errno := syscall.Munmap(m.b); errno != 0 {
+		return error.NewError(fmt.Sprint("could not unmap ", file), os.Errno(errno))
+	}
+	return nil
+}
+
+// close unmaps every file, as unmap does, and maps none from then on.
+func (r *regionMaps) close() (err os.Error) {
+	r.mu.Lock()
+	r.nomap = true
+	var files []string
+	for file := range r.maps {
+		files = append(files, file)
+	}
+	r.mu.Unlock()
+	for _, file := range files {
+		if e := r.unmap(file); err == nil {
+			err = e
+		}
+	}
+	return
+}
+
+// payload returns the payload of the chunk at index i in the headers of the
+// mapped file, its compression byte and compressed compound, or nil if the
+// file has no such chunk.  short is set if the mapping ends before the chunk
+// does, as it may if the file was written to since it was mapped.
+func (m *regionMap) payload(i int64) (payload []byte, short bool, err os.Error) {
+	if int64(len(m.b)) < headerSectors*sectorSize {
+		return nil, true, nil
+	}
+	location := getUint32(m.b[i*4:])
+	if location == 0 {
+		return nil, false, nil
+	}
+	start, count := int64(location>>8), int64(location&0xff)
+	if start*sectorSize+4 > int64(len(m.b)) {
+		return nil, true, nil
+	}
+	length := int64(getUint32(m.b[start*sectorSize:]))
+	if length < 1 || length+4 > count*sectorSize {
+		return nil, false, error.NewError(fmt.Sprint("chunk of ", length, " bytes in ", count, " sectors"), ErrCorruptChunk)
+	}
+	if start*sectorSize+4+length > int64(len(m.b)) {
+		return nil, true, nil
+	}
+	return m.b[start*sectorSize+4 : start*sectorSize+4+length], false, nil
+}
+
+// mappedStore is the regionStore of a world opened with MapRegions.  It lends
+// the World the payloads of chunks as slices of memory maps of their region
+// files, for the decoder to inflate in place, rather than reading each into a
+// buffer; files it cannot map, and chunks past the end of a file's mapping,
+// are read as the regionStore reads them.  Writes go through the regionStore,
+// once the file is unmapped, so a chunk is written to free sectors as usual.
+type mappedStore struct {
+	*regionStore
+	maps regionMaps
+}
+
+func newMappedStore(worlddir, ext string) *mappedStore {
+	return &mappedStore{regionStore: newRegionStore(worlddir, ext)}
+}
+
+// Read returns a copy of the chunk's payload, which is the caller's, as it is
+// from every ChunkStore; World decodes chunks from the mapping with lend.
+func (s *mappedStore) Read(x, z int32) ([]byte, os.Error) {
+	payload, giveBack, err := s.lend(x, z)
+	if err != nil {
+		return nil, err
+	}
+	defer giveBack()
+	return append([]byte(nil), payload...), nil
+}
+
+func (s *mappedStore) lend(x, z int32) (payload []byte, giveBack func(), err os.Error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	file, i := s.file(x, z)
+	m := s.maps.get(file)
+	if m == nil {
+		payload, err = s.read(x, z)
+		return payload, noLoan, err
+	}
+	payload, short, err := m.payload(i)
+	switch {
+	case err != nil:
+	case short:
+		payload, err = s.read(x, z)
+	case payload == nil:
+		err = ChunkNotFoundError{x, z}
+	default:
+		if c := Compression(payload[0]); c == CompressGzip || c == CompressZlib {
+			return payload, m.loans.Done, nil
+		}
+		payload, err = nil, error.NewError(fmt.Sprint("unknown compression ", payload[0]), ErrCorruptChunk)
+	}
+	m.loans.Done()
+	return payload, noLoan, err
+}
+
+func (s *mappedStore) Write(x, z int32, payload []byte) os.Error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	file, _ := s.file(x, z)
+	if err := s.maps.unmap(file); err != nil {
+		return err
+	}
+	return s.write(x, z, payload)
+}
+
+// Close unmaps the store's region files, once the payloads it has lent are
+// given back, and reads without mapping from then on.
+func (s *mappedStore) Close() os.Error {
+	return s.maps.close()
+}
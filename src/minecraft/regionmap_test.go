@@ -0,0 +1,241 @@
+package world
+
+import "bytes"
+import "io/ioutil"
+import "os"
+import "path"
+import "testing"
+import "time"
+
+func TestRegionMaps(t *testing.T) {
+	dir, err := ioutil.TempDir("", "region")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	s := newRegionStore(dir, mcRegionExt)
+	if err = s.Write(5, 0, storePayload(t, 5, 0, 0)); err != nil {
+		t.Fatal(err)
+	}
+	file, i := s.file(5, 0)
+	var r regionMaps
+	m := r.get(file)
+	if m == nil {
+		t.Fatal("expected the region file mapped")
+	}
+	raw, short, err := m.payload(i)
+	if err != nil || short || raw == nil || len(m.b) != 3*sectorSize || &raw[0] != &m.b[2*sectorSize+4] {
+		t.Fatalf("expected the payload in the mapped file, got %d bytes (%v)", len(raw), err)
+	}
+	if raw, short, err := m.payload(i + 1); raw != nil || short || err != nil {
+		t.Errorf("expected no chunk at index %d, got %d bytes (%v)", i+1, len(raw), err)
+	}
+	if r.get(path.Join(dir, regiondir, "r.1.0.mcr")) != nil || r.nomap {
+		t.Error("expected a missing file left unmapped, and no other file kept from being mapped")
+	}
+
+	// unmapping waits for the loan to be given back
+	done := make(chan os.Error)
+	go func() { done <- r.unmap(file) }()
+	select {
+	case <-done:
+		t.Fatal("expected unmap to wait for the loan")
+	case <-time.After(50e6):
+	}
+	m.loans.Done()
+	if err = <-done; err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := r.maps[file]; ok {
+		t.Error("expected the file unmapped")
+	}
+
+	// a chunk written past the end of the mapping is short
+	if m = r.get(file); m == nil {
+		t.Fatal("expected the region file mapped again")
+	}
+	if err = s.Write(7, 0, storePayload(t, 7, 0, 9000)); err != nil {
+		t.Fatal(err)
+	}
+	if raw, short, err = m.payload(i + 2); !short || err != nil {
+		t.Errorf("expected the chunk past the mapping short, got %d bytes (%v)", len(raw), err)
+	}
+	m.loans.Done()
+
+	if err = r.close(); err != nil {
+		t.Fatal(err)
+	}
+	if len(r.maps) != 0 || r.get(file) != nil {
+		t.Errorf("expected nothing mapped once closed, got %d files", len(r.maps))
+	}
+}
+
+func TestMappedStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "region")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	s := newMappedStore(dir, mcRegionExt)
+	payload := storePayload(t, 0, 0, 0)
+	if err = s.Write(0, 0, payload); err != nil {
+		t.Fatal(err)
+	}
+	got, giveBack, err := s.lend(0, 0)
+	if err != nil || !bytes.Equal(got, payload) {
+		t.Fatalf("expected the payload lent as it was written, got %d bytes (%v)", len(got), err)
+	}
+	file, _ := s.file(0, 0)
+	m := s.maps.maps[file]
+	if m == nil || len(m.b) != 3*sectorSize || &got[0] != &m.b[2*sectorSize+4] {
+		t.Fatal("expected the payload lent from the mapped file, not a copy")
+	}
+	if read, err := s.Read(0, 0); err != nil || !bytes.Equal(read, payload) || &read[0] == &got[0] {
+		t.Errorf("expected Read to return a copy of the payload, got %d bytes (%v)", len(read), err)
+	}
+
+	// a write waits for the payload to be given back, then unmaps the file
+	done := make(chan os.Error)
+	go func() { done <- s.Write(0, 0, storePayload(t, 0, 0, 5000)) }()
+	select {
+	case <-done:
+		t.Fatal("expected the write to wait for the loan")
+	case <-time.After(50e6):
+	}
+	if !bytes.Equal(got, payload) {
+		t.Error("expected the lent payload unchanged while it is lent")
+	}
+	giveBack()
+	if err = <-done; err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := s.maps.maps[file]; ok {
+		t.Error("expected the file unmapped once written")
+	}
+
+	// writes go to free sectors as the regionStore's do
+	rewrite, err := recompress(payload, CompressZlib)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = s.Write(0, 0, rewrite); err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadFile(path.Join(dir, regiondir, "r.0.0.mcr"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if location := getUint32(b); location != 2<<8|1 {
+		t.Errorf("expected the chunk rewritten at sector 2, got location %#x", location)
+	}
+	if got, giveBack, err = s.lend(0, 0); err != nil || !bytes.Equal(got, rewrite) {
+		t.Errorf("expected the rewritten payload, got %d bytes (%v)", len(got), err)
+	} else {
+		giveBack()
+	}
+	expectMissing(t, "mapped", s, MakeXZ(1, 0))
+	expectMissing(t, "mapped", s, MakeXZ(32, 0))
+
+	// a chunk past the end of the mapping, as another writer leaves it, is
+	// read rather than taken for corrupt
+	other := storePayload(t, 1, 0, 9000)
+	if err = newRegionStore(dir, mcRegionExt).Write(1, 0, other); err != nil {
+		t.Fatal(err)
+	}
+	if got, giveBack, err = s.lend(1, 0); err != nil || !bytes.Equal(got, other) {
+		t.Errorf("expected the chunk past the mapping read, got %d bytes (%v)", len(got), err)
+	} else {
+		giveBack()
+	}
+
+	if err = s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if got, giveBack, err = s.lend(0, 0); err != nil || !bytes.Equal(got, rewrite) {
+		t.Errorf("expected the payload read without mapping once closed, got %d bytes (%v)", len(got), err)
+	} else {
+		giveBack()
+	}
+	if len(s.maps.maps) != 0 {
+		t.Errorf("expected nothing mapped once closed, got %d files", len(s.maps.maps))
+	}
+}
+
+func TestMapRegionsWorld(t *testing.T) {
+	c := testChunkPayload(0, 0, nil, nil)
+	c["Level"].(map[string]interface{})["Blocks"].([]byte)[blockIndex(2, 70, 3)] = BlockGlass
+	dir := makeTestWorld(t, c)
+	defer os.RemoveAll(dir)
+	if _, err := CopyChunks(NewStore(dir, FormatMcRegion), NewStore(dir, FormatAlpha)); err != nil {
+		t.Fatal(err)
+	}
+	w, err := Open(dir, MapRegions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := w.Store().(*mappedStore); !ok {
+		t.Fatalf("expected a mapped store, got %T", w.Store())
+	}
+	w.LazyArrays = true
+	lazy, err := w.GetChunk(0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// the undecoded arrays outlive the mapping
+	if id, _, err := lazy.BlockAt(2, 70, 3); err != nil || id != BlockGlass {
+		t.Errorf("expected glass decoded once the world is closed, got %d (%v)", id, err)
+	}
+}
+
+// benchmarkReadRegion reads and decodes every chunk of a full region file,
+// mapped or read a chunk at a time.  The store is made afresh for each pass,
+// so the mapping's cost is counted.
+func benchmarkReadRegion(b *testing.B, mapped bool) {
+	b.StopTimer()
+	dir, err := ioutil.TempDir("", "region")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	s := newRegionStore(dir, mcRegionExt)
+	for x := int32(0); x < 1<<regionShift; x++ {
+		for z := int32(0); z < 1<<regionShift; z++ {
+			payload, err := encodeChunk("", testChunkPayload(x, z, nil, nil), CompressZlib)
+			if err == nil {
+				err = s.Write(x, z, payload)
+			}
+			if err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+	b.StartTimer()
+	for i := 0; i < b.N; i++ {
+		plain, store := newRegionStore(dir, mcRegionExt), newMappedStore(dir, mcRegionExt)
+		for x := int32(0); x < 1<<regionShift; x++ {
+			for z := int32(0); z < 1<<regionShift; z++ {
+				var raw []byte
+				giveBack := noLoan
+				if mapped {
+					raw, giveBack, err = store.lend(x, z)
+				} else {
+					raw, err = plain.Read(x, z)
+				}
+				if err == nil {
+					_, _, err = decodePayload(raw, keepAll)
+					giveBack()
+				}
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+		}
+		store.Close()
+	}
+}
+
+func BenchmarkReadRegion(b *testing.B)       { benchmarkReadRegion(b, false) }
+func BenchmarkReadRegionMapped(b *testing.B) { benchmarkReadRegion(b, true) }
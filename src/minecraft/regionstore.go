@@ -0,0 +1,309 @@
+package world
+
+import "minecraft/error"
+
+import "fmt"
+import "io/ioutil"
+import "os"
+import "path"
+import "strconv"
+import "strings"
+import "sync"
+import "time"
+
+// The extensions of the region files of FormatMcRegion and FormatAnvil.
+const (
+	mcRegionExt = ".mcr"
+	anvilExt    = ".mca"
+)
+
+const (
+	sectorSize    = 4096 // region files are allocated in sectors of this many bytes
+	headerSectors = 2    // a sector of chunk locations, then one of timestamps
+	regionChunks  = 1 << (2 * regionShift)
+	maxSectors    = 255 // a chunk's count of sectors is a byte
+)
+
+// regionStore is the ChunkStore of FormatMcRegion and FormatAnvil: files of 32
+// by 32 chunks, region/r.<x>.<z>.mcr or .mca, given in region coordinates.
+// Each file begins with two sectors of headers, the first holding the
+// location of each of its chunks, a big-endian word of its first sector
+// shifted left by 8 and its count of sectors, and the second when each was
+// last written, in seconds since the epoch.  A chunk's sectors hold its length
+// in bytes and then its payload, a byte for its compression and its compressed
+// compound, so payloads are kept as they are.  World writes them compressed
+// with zlib, as the game does; the game's older chunks may be gzipped.
+//
+// A chunk is written to the first run of free sectors large enough for it,
+// which may be at the end of the file, and only then do the headers point to
+// it, so a write cut short leaves the chunk as it was; its old sectors are
+// free for the next write.  The payloads of Anvil chunks, which keep their
+// blocks in sections, are passed on as they are; World cannot decode them.
+type regionStore struct {
+	dir string
+	ext string
+	mu  sync.RWMutex // writers hold Lock, as they may move chunks
+}
+
+func newRegionStore(worlddir, ext string) *regionStore {
+	return &regionStore{dir: path.Join(worlddir, regiondir), ext: ext}
+}
+
+func (s *regionStore) compression() Compression { return CompressZlib }
+
+// file returns the region file of the chunk at (x, z), and the chunk's index in
+// its headers.
+func (s *regionStore) file(x, z int32) (file string, i int64) {
+	name := fmt.Sprintf("r.%d.%d%s", x>>regionShift, z>>regionShift, s.ext)
+	return path.Join(s.dir, name), int64(x&(1<<regionShift-1) + (z&(1<<regionShift-1))<<regionShift)
+}
+
+func getUint32(b []byte) uint32 {
+	return uint32(b[0])<<24 | uint32(b[1])<<16 | uint32(b[2])<<8 | uint32(b[3])
+}
+
+func putUint32(b []byte, v uint32) {
+	b[0], b[1], b[2], b[3] = byte(v>>24), byte(v>>16), byte(v>>8), byte(v)
+}
+
+// readHeaders returns the locations and timestamps of the region file f, the
+// parts of them past the end of a new or short file being zero.
+func readHeaders(f *os.File) ([]uint32, os.Error) {
+	b := make([]byte, headerSectors*sectorSize)
+	if n, err := f.ReadAt(b, 0); err != nil && !(err == os.EOF && n%4 == 0) {
+		return nil, error.NewError("could not read region headers", err)
+	}
+	header := make([]uint32, len(b)/4)
+	for i := range header {
+		header[i] = getUint32(b[i*4:])
+	}
+	return header, nil
+}
+
+// locate opens the region file of the chunk at (x, z) and returns its headers
+// and the chunk's index in them.  The chunk need not have been written.
+func (s *regionStore) locate(x, z int32, flag int) (f *os.File, header []uint32, i int64, err os.Error) {
+	file, i := s.file(x, z)
+	if f, err = os.Open(file, flag, 0644); err != nil {
+		return nil, nil, 0, error.NewError("could not open region file", notFound(err, x, z))
+	}
+	if header, err = readHeaders(f); err != nil {
+		f.Close()
+		return nil, nil, 0, error.InFile(file).Error("corrupt region file", error.Wrap(ErrCorruptChunk, err))
+	}
+	return
+}
+
+func (s *regionStore) Has(x, z int32) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	f, header, i, err := s.locate(x, z, os.O_RDONLY)
+	if err != nil {
+		return false
+	}
+	f.Close()
+	return header[i] != 0
+}
+
+func (s *regionStore) Read(x, z int32) ([]byte, os.Error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.read(x, z)
+}
+
+// read is Read for a caller holding s.mu.
+func (s *regionStore) read(x, z int32) ([]byte, os.Error) {
+	f, header, i, err := s.locate(x, z, os.O_RDONLY)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	if header[i] == 0 {
+		return nil, ChunkNotFoundError{x, z}
+	}
+	start, count := int64(header[i]>>8), int64(header[i]&0xff)
+	head := make([]byte, 4)
+	if _, err = f.ReadAt(head, start*sectorSize); err != nil {
+		return nil, error.NewError("could not read chunk from region file", error.Wrap(ErrCorruptChunk, err))
+	}
+	length := int64(getUint32(head))
+	if length < 1 || length+4 > count*sectorSize {
+		return nil, error.NewError(fmt.Sprint("chunk of ", length, " bytes in ", count, " sectors"), ErrCorruptChunk)
+	}
+	payload := make([]byte, length)
+	if _, err = f.ReadAt(payload, start*sectorSize+4); err != nil {
+		return nil, error.NewError("could not read chunk from region file", error.Wrap(ErrCorruptChunk, err))
+	}
+	if c := Compression(payload[0]); c != CompressGzip && c != CompressZlib {
+		return nil, error.NewError(fmt.Sprint("unknown compression ", c), ErrCorruptChunk)
+	}
+	return payload, nil
+}
+
+func (s *regionStore) Write(x, z int32, payload []byte) os.Error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.write(x, z, payload)
+}
+
+// write is Write for a caller holding s.mu.
+func (s *regionStore) write(x, z int32, payload []byte) os.Error {
+	if len(payload) == 0 {
+		return error.NewError("empty payload", nil)
+	}
+	if c := Compression(payload[0]); c != CompressGzip && c != CompressZlib {
+		return error.NewError(fmt.Sprint("unknown compression ", c), nil)
+	}
+	sectors := (int64(len(payload)) + 4 + sectorSize - 1) / sectorSize
+	if sectors > maxSectors {
+		return error.NewError(fmt.Sprint("chunk of ", len(payload), " bytes is too large for a region file"), nil)
+	}
+	if err := os.MkdirAll(s.dir, 0755); err != nil {
+		return error.NewError("could not create region directory", err)
+	}
+	f, header, i, err := s.locate(x, z, os.O_RDWR|os.O_CREAT)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	start := freeSectors(header, sectors)
+	b := make([]byte, sectors*sectorSize)
+	putUint32(b, uint32(len(payload)))
+	copy(b[4:], payload)
+	if _, err = f.WriteAt(b, start*sectorSize); err != nil {
+		return error.NewError("could not write chunk to region file", err)
+	}
+	return writeHeader(f, i, uint32(start<<8|sectors), uint32(time.Seconds()))
+}
+
+// freeSectors returns the first sector of the first run of n sectors used by
+// none of the chunks in header, not even one about to be rewritten.  The run
+// may extend past the end of the file.
+func freeSectors(header []uint32, n int64) int64 {
+	used := make(map[int64]bool)
+	end := int64(headerSectors)
+	for _, location := range header[:regionChunks] {
+		if location == 0 {
+			continue
+		}
+		start, count := int64(location>>8), int64(location&0xff)
+		for k := start; k < start+count; k++ {
+			used[k] = true
+		}
+		if start+count > end {
+			end = start + count
+		}
+	}
+	var run int64
+	for k := int64(headerSectors); k < end; k++ {
+		if used[k] {
+			run = 0
+		} else if run++; run == n {
+			return k - n + 1
+		}
+	}
+	return end - run
+}
+
+// writeHeader sets the location and timestamp of the chunk at index i.
+func writeHeader(f *os.File, i int64, location, stamp uint32) os.Error {
+	b := make([]byte, 4)
+	putUint32(b, location)
+	if _, err := f.WriteAt(b, i*4); err != nil {
+		return error.NewError("could not write region headers", err)
+	}
+	putUint32(b, stamp)
+	if _, err := f.WriteAt(b, sectorSize+i*4); err != nil {
+		return error.NewError("could not write region headers", err)
+	}
+	return nil
+}
+
+// Delete clears the chunk's location and timestamp, freeing its sectors for
+// other chunks; the file does not shrink.
+func (s *regionStore) Delete(x, z int32) os.Error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f, header, i, err := s.locate(x, z, os.O_RDWR)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if header[i] == 0 {
+		return ChunkNotFoundError{x, z}
+	}
+	return writeHeader(f, i, 0, 0)
+}
+
+func (s *regionStore) List() (keys []XZ, err os.Error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	files, err := ioutil.ReadDir(s.dir)
+	if err != nil {
+		if isNotExist(err) {
+			return nil, nil
+		}
+		return nil, error.NewError("could not list region directory", err)
+	}
+	for _, fi := range files {
+		rx, rz, ok := parseRegionName(fi.Name, s.ext)
+		if !ok || !fi.IsRegular() {
+			continue
+		}
+		file := path.Join(s.dir, fi.Name)
+		f, err := os.Open(file, os.O_RDONLY, 0)
+		if err != nil {
+			return nil, error.NewError("could not open region file", err)
+		}
+		header, err := readHeaders(f)
+		f.Close()
+		if err != nil {
+			return nil, error.InFile(file).Error("corrupt region file", error.Wrap(ErrCorruptChunk, err))
+		}
+		for i, location := range header[:regionChunks] {
+			if location != 0 {
+				keys = append(keys, MakeXZ(rx<<regionShift+int32(i&(1<<regionShift-1)), rz<<regionShift+int32(i>>regionShift)))
+			}
+		}
+	}
+	return keys, nil
+}
+
+// parseRegionName extracts the region coordinates from a file name of the form
+// r.<x>.<z><ext>.
+func parseRegionName(name, ext string) (rx, rz int32, ok bool) {
+	if !strings.HasSuffix(name, ext) {
+		return
+	}
+	parts := strings.Split(name[:len(name)-len(ext)], ".", -1)
+	if len(parts) != 3 || parts[0] != "r" {
+		return
+	}
+	if rx, ok = parseRegionCoord(parts[1]); !ok {
+		return
+	}
+	rz, ok = parseRegionCoord(parts[2])
+	return
+}
+
+// parseRegionCoord parses a region coordinate, which must leave the
+// coordinates of the region's chunks within int32.
+func parseRegionCoord(s string) (int32, bool) {
+	i, err := strconv.Atoi(s)
+	limit := 1 << (31 - regionShift)
+	return int32(i), err == nil && i >= -limit && i < limit
+}
+
+func (s *regionStore) ModTime(x, z int32) (int64, os.Error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	f, header, i, err := s.locate(x, z, os.O_RDONLY)
+	if err != nil {
+		return 0, err
+	}
+	f.Close()
+	if header[i] == 0 {
+		return 0, ChunkNotFoundError{x, z}
+	}
+	return int64(header[regionChunks+i]) * 1e9, nil
+}
@@ -0,0 +1,142 @@
+package world
+
+import "bytes"
+import "io/ioutil"
+import "os"
+import "path"
+import "testing"
+
+func TestRegionFileLayout(t *testing.T) {
+	dir, err := ioutil.TempDir("", "region")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	s := NewStore(dir, FormatMcRegion)
+	payload := storePayload(t, -31, 34, 0)
+	if err = s.Write(-31, 34, payload); err != nil {
+		t.Fatal(err)
+	}
+
+	// chunk (-31, 34) is (1, 2) of region (-1, 1)
+	b, err := ioutil.ReadFile(path.Join(dir, regiondir, "r.-1.1.mcr"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(b) != 3*sectorSize {
+		t.Fatalf("expected the headers and a sector, got %d bytes", len(b))
+	}
+	i := 1 + 2*32
+	if location := getUint32(b[i*4:]); location != 2<<8|1 {
+		t.Errorf("expected the chunk at sector 2, got location %#x", location)
+	}
+	if getUint32(b[sectorSize+i*4:]) == 0 {
+		t.Error("expected a timestamp")
+	}
+	length := getUint32(b[2*sectorSize:])
+	if int(length) != len(payload) || !bytes.Equal(b[2*sectorSize+4:2*sectorSize+4+int(length)], payload) {
+		t.Error("expected the payload as it was written")
+	}
+	got, err := s.Read(-31, 34)
+	if err != nil || !bytes.Equal(got, payload) {
+		t.Errorf("expected the payload read back as it was written, got %d bytes (%v)", len(got), err)
+	}
+
+	// a rewrite goes to free sectors, leaving the chunk as it was until the
+	// headers point to it
+	zpayload, err := encodeChunk("", testChunkPayload(-31, 34, nil, nil), CompressZlib)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = s.Write(-31, 34, zpayload); err != nil {
+		t.Fatal(err)
+	}
+	if b, err = ioutil.ReadFile(path.Join(dir, regiondir, "r.-1.1.mcr")); err != nil {
+		t.Fatal(err)
+	}
+	if location := getUint32(b[i*4:]); location != 3<<8|1 {
+		t.Errorf("expected the chunk rewritten at sector 3, got location %#x", location)
+	}
+	if !bytes.Equal(b[2*sectorSize+4:2*sectorSize+4+len(payload)], payload) {
+		t.Error("expected the old sector untouched")
+	}
+	expectPayload(t, "zlib", s, MakeXZ(-31, 34), zpayload)
+
+	// a location past the end of the file is corrupt, not missing
+	putUint32(b[i*4:], 9<<8|1)
+	if err = ioutil.WriteFile(path.Join(dir, regiondir, "r.-1.1.mcr"), b, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = s.Read(-31, 34); !IsError(err, ErrCorruptChunk) {
+		t.Error("expected ErrCorruptChunk, got ", err)
+	}
+	if err = s.Write(-31, 34, storePayload(t, -31, 34, 2000000)); err == nil {
+		t.Error("expected a chunk of more than 255 sectors refused")
+	}
+}
+
+func TestFreeSectors(t *testing.T) {
+	header := make([]uint32, 2*regionChunks)
+	header[0] = 2<<8 | 2 // sectors 2 and 3
+	header[1] = 5<<8 | 1 // sector 5
+	header[2] = 7<<8 | 3 // sectors 7 through 9
+	for _, c := range []struct {
+		n      int64
+		expect int64
+	}{
+		{1, 4},  // the gap before 5
+		{2, 10}, // no gap is 2 long
+		{3, 10}, // nor 3
+	} {
+		if got := freeSectors(header, c.n); got != c.expect {
+			t.Errorf("%d sectors: expected %d, got %d", c.n, c.expect, got)
+		}
+	}
+	// a chunk's own sectors are not free to it
+	header[1] = 0
+	if got := freeSectors(header, 3); got != 4 {
+		t.Errorf("3 sectors with 5 freed: expected 4, got %d", got)
+	}
+}
+
+func TestMcRegionWorld(t *testing.T) {
+	dir := makeTestWorld(t, testChunkPayload(0, 0, nil, nil), testChunkPayload(-1, 40, nil, nil))
+	defer os.RemoveAll(dir)
+	if _, err := CopyChunks(NewStore(dir, FormatMcRegion), NewStore(dir, FormatAlpha)); err != nil {
+		t.Fatal(err)
+	}
+	// the game leaves the Alpha chunks behind; without this one, only the region
+	// file has it
+	if err := os.Remove(chunkPath(dir, -1, 40)); err != nil {
+		t.Fatal(err)
+	}
+	w, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	if w.Format() != FormatMcRegion {
+		t.Fatal("expected McRegion, got ", w.Format())
+	}
+	coords, err := w.ListChunks(nil)
+	if err != nil || len(coords) != 2 || coords[0].X != -1 || coords[0].Z != 40 {
+		t.Errorf("expected both chunks listed, got %v (%v)", coords, err)
+	}
+	c, err := w.GetChunk(-1, 40)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.SetBlock(0, 70, 0, BlockGlass, 0)
+	if err = w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if err = w.UnloadChunk(-1, 40); err != nil {
+		t.Fatal(err)
+	}
+	if id, _, err := w.BlockAt(-16, 70, 40*16); err != nil || id != BlockGlass {
+		t.Errorf("expected glass read back from the region file, got %d (%v)", id, err)
+	}
+	if NewStore(dir, FormatAlpha).Has(-1, 40) {
+		t.Error("expected nothing written to the Alpha chunks")
+	}
+}
@@ -0,0 +1,222 @@
+package world
+
+import "minecraft/error"
+
+import "fmt"
+import "image"
+import "image/png"
+import "io"
+import "os"
+
+// RenderOptions control how RenderMap draws a map.
+type RenderOptions struct {
+	// Scale is the width and height in pixels of each block column; zero means 1.
+	Scale int
+	// MinY and MaxY bound the blocks drawn, both inclusive, so that a MaxY below
+	// the surface maps what lies underground.  A MaxY of zero means the top of
+	// the world.
+	MinY, MaxY int32
+	// Colors gives the color of each block; nil means DefaultColorTable.
+	Colors *ColorTable
+	// Hillshade, if positive, has RenderMap shade the surface by its slope, as
+	// a relief map does: each block a column rises toward the light lightens it,
+	// and each block it falls darkens it, by Hillshade of the way to white or
+	// black, up to half way.
+	Hillshade float64
+	// LightAzimuth is the direction the light for Hillshade comes from, in
+	// degrees clockwise from north; 315, the north-west, is the usual choice.
+	LightAzimuth float64
+}
+
+// colors returns the color table to draw with.
+func (opts *RenderOptions) colors() *ColorTable {
+	if opts.Colors == nil {
+		return defaultColors
+	}
+	return opts.Colors
+}
+
+// yRange returns the bounds of the blocks to draw.
+func (opts *RenderOptions) yRange() (minY, maxY int32, err os.Error) {
+	minY, maxY = opts.MinY, opts.MaxY
+	if maxY == 0 {
+		maxY = ChunkHeight - 1
+	}
+	if minY < 0 || maxY >= ChunkHeight || minY > maxY {
+		err = error.NewError(fmt.Sprintf("cannot render y from %d to %d", opts.MinY, opts.MaxY), nil)
+	}
+	return
+}
+
+// RenderMap writes a PNG map of region (nil meaning every chunk in the world),
+// seen from above.  Each block column is colored by its highest block between
+// opts.MinY and opts.MaxY, as opts.Colors gives it by id and data value; water is
+// drawn translucent over what lies beneath it, and columns of missing chunks, or
+// with no blocks in range, are transparent.
+//
+// Pixel (px, py) shows block column (region.MinX*16 + px/Scale,
+// region.MinZ*16 + py/Scale), so that x grows to the right and z downward.
+// Chunks are read a row at a time as the PNG is written and are not kept.
+//
+// With opts.Hillshade each column is compared with its neighbors to the west
+// and north, which may lie in the neighboring chunk; where a neighbor is missing
+// or outside region the surface is taken to be flat that way.
+func (world *World) RenderMap(w io.Writer, region *Region, opts RenderOptions) os.Error {
+	minY, maxY, err := opts.yRange()
+	if err != nil {
+		return err
+	}
+	colors := opts.colors()
+	m := &columnImage{op: "RenderMap", scale: opts.Scale, model: image.NRGBAColorModel, column: func(c *Chunk, x, z int32) image.Color {
+		if c == nil {
+			return image.NRGBAColor{}
+		}
+		return topColor(c, x, z, minY, maxY, colors)
+	}}
+	if opts.Hillshade < 0 {
+		return error.NewError(fmt.Sprintf("cannot shade with strength %g", opts.Hillshade), nil)
+	}
+	if opts.Hillshade > 0 {
+		m.relief = newHillshade(opts.Hillshade, opts.LightAzimuth, func(c *Chunk, x, z int32) int32 {
+			if y, _, _ := c.highestBlockBelow(x, maxY, z); y >= minY {
+				return y
+			}
+			return -1
+		})
+	}
+	return world.renderColumnImage(w, region, m)
+}
+
+// topColor returns the color of column (x, z) of c seen from above maxY, looking
+// through water to the block beneath.  c's arrays must be loaded.
+func topColor(c *Chunk, x, z, minY, maxY int32, colors *ColorTable) image.NRGBAColor {
+	y, id, _ := c.highestBlockBelow(x, maxY, z)
+	if y < minY {
+		return image.NRGBAColor{}
+	}
+	base := blockIndex(x, 0, z)
+	if id != BlockWater && id != BlockStillWater {
+		return colors.Color(id, getNibble(c.Level.Data, base+int(y)))
+	}
+	water := colors.Color(id, getNibble(c.Level.Data, base+int(y)))
+	for ; y >= minY; y-- {
+		if id = c.Level.Blocks[base+int(y)]; id != BlockAir && id != BlockWater && id != BlockStillWater {
+			return over(water, colors.Color(id, getNibble(c.Level.Data, base+int(y))))
+		}
+	}
+	return water
+}
+
+// renderColumns writes region as a PNG with one scale by scale square per block
+// column, colored by column, which is given nil for the columns of missing
+// chunks.  The chunks column is given have their arrays loaded, so it need not
+// check for errors loading them.  Progress is told of it as op.
+func (world *World) renderColumns(op string, w io.Writer, region *Region, scale int, model image.ColorModel, column func(c *Chunk, x, z int32) image.Color) os.Error {
+	return world.renderColumnImage(w, region, &columnImage{op: op, scale: scale, model: model, column: column})
+}
+
+// renderColumnImage writes m, drawn of region, as a PNG.  Once Cancel is closed
+// the PNG is still written whole, with the chunks not yet drawn left as missing,
+// and the CancelledError returned.
+func (world *World) renderColumnImage(w io.Writer, region *Region, m *columnImage) os.Error {
+	if m.scale < 0 {
+		return error.NewError(fmt.Sprintf("cannot render at scale %d", m.scale), nil)
+	}
+	if m.scale == 0 {
+		m.scale = 1
+	}
+	region, err := world.extent(region)
+	if err != nil {
+		return err
+	}
+	m.world, m.region = world, region
+	m.t = world.begin(m.op, int(region.Width()*region.Depth()))
+	if err = png.Encode(w, m); err != nil {
+		return m.t.end(error.NewError("could not write PNG", err))
+	}
+	return m.t.end(m.err)
+}
+
+// columnImage is region drawn by renderColumns.  It is drawn a strip of chunks at
+// a time as the PNG encoder asks for rows from top to bottom, so that memory use
+// grows with the region's width but not its depth.
+type columnImage struct {
+	op     string
+	world  *World
+	region *Region
+	t      *task
+	scale  int
+	model  image.ColorModel
+	column func(c *Chunk, x, z int32) image.Color
+	relief *hillshade // if set, shades each strip by slope once it is drawn
+
+	strip   []image.Color // the block columns of chunk row stripZ, x varying fastest
+	stripZ  int32
+	err     os.Error // the first chunk that could not be read, or the cancel
+	stopped bool     // whether drawing was cancelled
+}
+
+func (m *columnImage) ColorModel() image.ColorModel {
+	return m.model
+}
+
+func (m *columnImage) Bounds() image.Rectangle {
+	return image.Rect(0, 0, int(m.region.Width())*ChunkWidth*m.scale, int(m.region.Depth())*ChunkDepth*m.scale)
+}
+
+func (m *columnImage) At(px, py int) image.Color {
+	bx, bz := px/m.scale, py/m.scale
+	if cz := m.region.MinZ + int32(bz/ChunkDepth); m.strip == nil || cz != m.stripZ {
+		m.drawStrip(cz)
+	}
+	return m.strip[bx+bz%ChunkDepth*int(m.region.Width())*ChunkWidth]
+}
+
+// drawStrip draws chunk row cz, reading chunks that are not resident and
+// dropping them again.
+func (m *columnImage) drawStrip(cz int32) {
+	width := int(m.region.Width()) * ChunkWidth
+	if m.strip == nil {
+		m.strip = make([]image.Color, width*ChunkDepth)
+	}
+	m.stripZ = cz
+	for cx := m.region.MinX; cx <= m.region.MaxX; cx++ {
+		c := m.chunk(cx, cz)
+		ox := int(cx-m.region.MinX) * ChunkWidth
+		for z := int32(0); z < ChunkDepth; z++ {
+			for x := int32(0); x < ChunkWidth; x++ {
+				m.strip[ox+int(x)+int(z)*width] = m.column(c, x, z)
+			}
+		}
+		if m.relief != nil {
+			m.relief.measure(c, ox, width)
+		}
+	}
+	if m.relief != nil {
+		m.relief.shade(m.strip, width, cz)
+	}
+}
+
+// chunk returns the chunk at (cx, cz) to draw, or nil if it is missing, cannot
+// be read or drawing has been cancelled.
+func (m *columnImage) chunk(cx, cz int32) *Chunk {
+	if m.stopped {
+		return nil
+	}
+	if err := m.t.cancelled(); err != nil {
+		m.stopped = true
+		if m.err == nil {
+			m.err = err
+		}
+		return nil
+	}
+	c, err := m.world.peekChunk(cx, cz)
+	m.t.chunk(cx, cz)
+	if err != nil {
+		if m.err == nil {
+			m.err = err
+		}
+		return nil
+	}
+	return c
+}
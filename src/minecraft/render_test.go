@@ -0,0 +1,124 @@
+package world
+
+import "bytes"
+import "image"
+import "image/png"
+import "os"
+import "testing"
+
+func sameColor(a, b image.Color) bool {
+	ar, ag, ab, aa := a.RGBA()
+	br, bg, bb, ba := b.RGBA()
+	return ar == br && ag == bg && ab == bb && aa == ba
+}
+
+func decodePNG(t *testing.T, buf *bytes.Buffer) image.Image {
+	m, err := png.Decode(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+// mapWorld returns chunks (0, 0) and (1, 1) of a world whose other chunks in
+// region (0, 0)-(1, 1) are missing.  Chunk (0, 0) has grass over dirt in column
+// (0, 0), water over sand in column (1, 0) and a lone block of stone low down in
+// column (2, 0); chunk (1, 1) has a gold block in column (21, 23).
+func mapWorld() *World {
+	w := &World{Chunks: make(map[XZ]*Chunk)}
+	c := newChunk(0, 0)
+	for y := int32(55); y < 63; y++ {
+		c.SetBlock(0, y, 0, BlockDirt, 0)
+	}
+	c.SetBlock(0, 63, 0, BlockGrass, 0)
+	c.SetBlock(1, 61, 0, BlockSand, 0)
+	c.SetBlock(1, 62, 0, BlockStillWater, 0)
+	c.SetBlock(1, 63, 0, BlockStillWater, 0)
+	c.SetBlock(2, 40, 0, BlockStone, 0)
+	w.Chunks[MakeXZ(0, 0)] = c
+	c = newChunk(1, 1)
+	c.SetBlock(5, 70, 7, 41, 0)
+	w.Chunks[MakeXZ(1, 1)] = c
+	return w
+}
+
+func TestRenderMap(t *testing.T) {
+	w := mapWorld()
+	buf := new(bytes.Buffer)
+	if err := w.RenderMap(buf, NewRegion(0, 0, 1, 1), RenderOptions{Scale: 2}); err != nil {
+		t.Fatal(err)
+	}
+	m := decodePNG(t, buf)
+	if b := m.Bounds(); b.Dx() != 64 || b.Dy() != 64 {
+		t.Fatal("expected a 64x64 map, got ", b)
+	}
+	transparent := image.NRGBAColor{}
+	for _, p := range []struct {
+		px, py int
+		want   image.Color
+	}{
+		{0, 0, blockColors[BlockGrass]},
+		{1, 1, blockColors[BlockGrass]},
+		{2, 0, over(waterColor, blockColors[BlockSand])},
+		{5, 1, blockColors[BlockStone]},
+		{6, 0, transparent},  // empty column
+		{40, 2, transparent}, // missing chunk (1, 0)
+		{2, 40, transparent}, // missing chunk (0, 1)
+		{42, 46, blockColors[41]},
+		{43, 47, blockColors[41]},
+		{44, 46, transparent},
+	} {
+		if got := m.At(p.px, p.py); !sameColor(got, p.want) {
+			t.Errorf("pixel (%d, %d): expected %v, got %v", p.px, p.py, p.want, got)
+		}
+	}
+}
+
+func TestRenderMapUnderground(t *testing.T) {
+	w := mapWorld()
+	buf := new(bytes.Buffer)
+	if err := w.RenderMap(buf, NewRegion(0, 0, 0, 0), RenderOptions{MinY: 50, MaxY: 62}); err != nil {
+		t.Fatal(err)
+	}
+	m := decodePNG(t, buf)
+	if got := m.At(0, 0); !sameColor(got, blockColors[BlockDirt]) {
+		t.Error("expected dirt below the grass, got ", got)
+	}
+	if got := m.At(1, 0); !sameColor(got, over(waterColor, blockColors[BlockSand])) {
+		t.Error("expected water over sand, got ", got)
+	}
+	if got := m.At(2, 0); !sameColor(got, image.NRGBAColor{}) {
+		t.Error("expected stone below MinY to be left out, got ", got)
+	}
+
+	for _, opts := range []RenderOptions{{MaxY: 128}, {MinY: 70, MaxY: 60}, {MinY: -1}, {Scale: -1}} {
+		if err := w.RenderMap(new(bytes.Buffer), NewRegion(0, 0, 0, 0), opts); err == nil {
+			t.Errorf("expected an error for %+v", opts)
+		}
+	}
+}
+
+func TestRenderMapWholeWorld(t *testing.T) {
+	dir := makeTestWorld(t, groundedChunk(), testChunkPayload(2, -1, []interface{}{}, []interface{}{}))
+	defer os.RemoveAll(dir)
+	w, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	buf := new(bytes.Buffer)
+	if err = w.RenderMap(buf, nil, RenderOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	m := decodePNG(t, buf)
+	if b := m.Bounds(); b.Dx() != 48 || b.Dy() != 32 {
+		t.Fatal("expected a 48x32 map of chunks (0, -1) to (2, 0), got ", b)
+	}
+	// block column (8, 8) is in the second strip
+	if got := m.At(8, 24); !sameColor(got, blockColors[BlockGrass]) {
+		t.Error("expected grass at spawn, got ", got)
+	}
+	if len(w.Chunks) != 0 {
+		t.Error("rendering left chunks resident")
+	}
+}
@@ -0,0 +1,378 @@
+package world
+
+import "minecraft/error"
+
+import "bytes"
+import "fmt"
+import "io"
+import "io/ioutil"
+import "os"
+import "path"
+import "sort"
+import "strconv"
+
+// ReportVersion is the version of the schema WriteJSON writes reports in.  It
+// changes whenever a member is renamed or removed or changes meaning, but not
+// when members are added.
+const ReportVersion = 1
+
+// ReportFlags choose which of the slower parts of a report to leave out.
+type ReportFlags int
+
+const (
+	// SkipEntities leaves out the entity and tile entity censuses.
+	SkipEntities ReportFlags = 1 << iota
+	// SkipBlocks leaves out the ore and chest counts, which read every block.
+	SkipBlocks
+	// SkipValidation leaves out the search for problems.
+	SkipValidation
+	// SkipDiskSize leaves out the size of the world's files.
+	SkipDiskSize
+	// SkipVehicles leaves the vehicles being ridden out of the entity census,
+	// counting only the entities chunks hold.
+	SkipVehicles
+)
+
+// reportOres names the ores a report counts, by block id.
+var reportOres = map[byte]string{
+	14: "gold",
+	15: "iron",
+	16: "coal",
+	56: "diamond",
+	73: "redstone",
+	74: "redstone", // glowing
+}
+
+// A WorldReport summarizes a world, as returned by Report.  The parts a report
+// was asked to skip are nil, or -1 for numbers.
+type WorldReport struct {
+	// from level.dat
+	Time, LastPlayed, RandomSeed, SizeOnDisk int64
+	SpawnX, SpawnY, SpawnZ                   int32
+	SnowCovered                              bool
+
+	// Chunks is the number of chunks in the region reported on, and Bounds the
+	// smallest region holding them, nil if there are none.
+	Chunks int
+	Bounds *Region
+
+	// Entities and TileEntities count them by id.  Vehicles being ridden count
+	// unless the report was asked to SkipVehicles.
+	Entities, TileEntities map[string]int
+
+	// Ores counts the blocks of each ore by name, and Chests the chest blocks.
+	Ores   map[string]int
+	Chests int
+
+	// DiskSize is the total size in bytes of the world's files.
+	DiskSize int64
+
+	// Players lists every player, the player of a single-player world first
+	// with an empty name.
+	Players []PlayerSummary
+
+	// Problems describes, in no particular order of importance, each chunk or
+	// player that could not be read, each warning raised while decoding a
+	// chunk, each entity held by the wrong chunk and each tile entity without
+	// its block.
+	Problems []string
+}
+
+// PlayerSummary is where a player is.
+type PlayerSummary struct {
+	Name      string
+	Position  Position
+	Dimension int32
+}
+
+// Report summarizes region (nil meaning the whole world), leaving out the parts
+// flags name.  Except for level.dat, players and the size on disk, everything is
+// counted within the region only.  Chunks are streamed from disk and not kept,
+// and are decoded without their blocks when SkipBlocks and SkipValidation are
+// both set.  Unless validation is skipped, chunks and players that cannot be
+// read become problems instead of failing the report, and are passed to
+// Progress as warnings.
+func (world *World) Report(region *Region, flags ReportFlags) (report *WorldReport, err os.Error) {
+	d := &world.Data
+	r := &WorldReport{
+		Time:        d.Time,
+		LastPlayed:  d.LastPlayed,
+		RandomSeed:  d.RandomSeed,
+		SizeOnDisk:  d.SizeOnDisk,
+		SpawnX:      d.SpawnX,
+		SpawnY:      d.SpawnY,
+		SpawnZ:      d.SpawnZ,
+		SnowCovered: d.SnowCovered != 0,
+		Chests:      -1,
+		DiskSize:    -1,
+		Players:     []PlayerSummary{},
+	}
+	censuses := flags&SkipEntities == 0
+	blocks := flags&SkipBlocks == 0
+	validate := flags&SkipValidation == 0
+	vehicles := flags&SkipVehicles == 0
+	if censuses {
+		r.Entities, r.TileEntities = make(map[string]int), make(map[string]int)
+	}
+	if blocks {
+		r.Ores, r.Chests = make(map[string]int), 0
+		for _, name := range reportOres {
+			r.Ores[name] = 0
+		}
+	}
+	if validate {
+		r.Problems = []string{}
+	}
+	var t *task
+	defer func() { t.end(err) }()
+	problem := func(format string, args ...interface{}) {
+		msg := fmt.Sprintf(format, args...)
+		r.Problems = append(r.Problems, msg)
+		t.warn(msg)
+	}
+
+	coords, err := world.ListChunks(region)
+	if err != nil {
+		return nil, err
+	}
+	r.Chunks = len(coords)
+	t = world.begin("Report", len(coords))
+	for _, xz := range coords {
+		if err = t.cancelled(); err != nil {
+			return nil, err
+		}
+		if r.Bounds == nil {
+			r.Bounds = NewRegion(xz.X, xz.Z, xz.X, xz.Z)
+		} else {
+			r.Bounds = r.Bounds.Union(NewRegion(xz.X, xz.Z, xz.X, xz.Z))
+		}
+		if !censuses && !blocks && !validate {
+			t.chunk(xz.X, xz.Z)
+			continue
+		}
+		c, err := world.reportChunk(xz.X, xz.Z, blocks || validate)
+		if err != nil {
+			if !validate {
+				return nil, err
+			}
+			problem("%s", err)
+			t.chunk(xz.X, xz.Z)
+			continue
+		}
+		if censuses {
+			for _, e := range c.Level.Entities {
+				r.Entities[e.Id]++
+				for v := e.Riding; v != nil && vehicles; v = v.Riding {
+					r.Entities[v.Id]++
+				}
+			}
+			for _, te := range c.Level.TileEntities {
+				r.TileEntities[te.Id()]++
+			}
+		}
+		if blocks {
+			for _, id := range c.Level.Blocks {
+				if name, ok := reportOres[id]; ok {
+					r.Ores[name]++
+				} else if id == BlockChest {
+					r.Chests++
+				}
+			}
+		}
+		if validate {
+			for _, w := range c.Warnings {
+				problem("chunk (%d, %d): %s", xz.X, xz.Z, w)
+			}
+			for i, e := range c.Level.Entities {
+				if m, ok := misplaced(xz.X, xz.Z, i, e); ok {
+					p := m.Position
+					problem("%s at (%g, %g, %g) is held by chunk (%d, %d)", e.Id, p.X, p.Y, p.Z, xz.X, xz.Z)
+				}
+			}
+			for _, te := range c.Level.TileEntities {
+				if orphaned(c, te) {
+					problem("%s at (%d, %d, %d) has no block", te.Id(), te.X(), te.Y(), te.Z())
+				}
+			}
+		}
+		t.chunk(xz.X, xz.Z)
+	}
+
+	if p := d.Player; p != nil {
+		r.Players = append(r.Players, PlayerSummary{"", p.Physics.Position, p.Dimension})
+	}
+	names, err := world.PlayerNames()
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range names {
+		p, err := world.LoadPlayer(name)
+		if err != nil {
+			if !validate {
+				return nil, err
+			}
+			problem("%s", err)
+			continue
+		}
+		r.Players = append(r.Players, PlayerSummary{name, p.Physics.Position, p.Dimension})
+	}
+
+	if flags&SkipDiskSize == 0 {
+		if r.DiskSize, err = dirSize(world.dir); err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+// reportChunk returns the chunk at (x, z), resident or read from disk.  Unless
+// full is set, a chunk that is not resident is decoded without its blocks, and
+// the chunk returned holds only its coordinates, entities and tile entities.
+func (world *World) reportChunk(x, z int32, full bool) (*Chunk, os.Error) {
+	if c, ok := world.resident(MakeXZ(x, z)); ok {
+		return c, c.LoadArrays()
+	}
+	if full {
+		return world.readChunk(x, z)
+	}
+	level, err := world.readChunkLevel(x, z)
+	if err != nil {
+		return nil, err
+	}
+	entityList, err := getList(level, "Entities")
+	if err != nil {
+		return nil, error.InChunk(x, z).Error("malformed chunk", err)
+	}
+	tileEntityList, err := getList(level, "TileEntities")
+	if err != nil {
+		return nil, error.InChunk(x, z).Error("malformed chunk", err)
+	}
+	c := &Chunk{Level: Level{XPos: x, ZPos: z}}
+	if c.Level.Entities, _, err = toEntityList(entityList); err != nil {
+		return nil, error.InChunk(x, z).Error("malformed chunk", err)
+	}
+	c.Level.TileEntities, _ = toTileEntityList(tileEntityList)
+	return c, nil
+}
+
+// dirSize returns the total size of the files under dir.
+func dirSize(dir string) (size int64, err os.Error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return 0, error.NewError(fmt.Sprint("could not list ", dir), err)
+	}
+	for _, fi := range files {
+		if fi.IsDirectory() {
+			n, err := dirSize(path.Join(dir, fi.Name))
+			if err != nil {
+				return 0, err
+			}
+			size += n
+		} else if fi.IsRegular() {
+			size += fi.Size
+		}
+	}
+	return
+}
+
+// WriteJSON writes the report as a JSON object with these members, in this
+// order, each on a line of its own:
+//
+//	"version"       ReportVersion
+//	"level"         {"time", "lastPlayed", "randomSeed", "sizeOnDisk",
+//	                "spawn": [x, y, z], "snowCovered"}
+//	"chunks"        the number of chunks
+//	"bounds"        {"minX", "minZ", "maxX", "maxZ"} in chunks, or null
+//	"entities"      {id: count, ...}
+//	"tileEntities"  {id: count, ...}
+//	"ores"          {name: count, ...}
+//	"chests"        the number of chest blocks
+//	"diskSize"      in bytes
+//	"players"       [{"name", "pos": [x, y, z], "dimension"}, ...]
+//	"problems"      [description, ...]
+//
+// The members of the report that were skipped are null.  As in the JSON encoding
+// of entities, longs, which are the times, the seed and the sizes, are strings,
+// and the members of count objects are in order of their names.
+func (r *WorldReport) WriteJSON(w io.Writer) os.Error {
+	var bounds interface{}
+	if b := r.Bounds; b != nil {
+		bounds = jsonObject{{"minX", b.MinX}, {"minZ", b.MinZ}, {"maxX", b.MaxX}, {"maxZ", b.MaxZ}}
+	}
+	var chests, diskSize, problems interface{}
+	if r.Chests >= 0 {
+		chests = r.Chests
+	}
+	if r.DiskSize >= 0 {
+		diskSize = strconv.Itoa64(r.DiskSize)
+	}
+	if r.Problems != nil {
+		list := []interface{}{}
+		for _, p := range r.Problems {
+			list = append(list, p)
+		}
+		problems = list
+	}
+	players := []interface{}{}
+	for _, p := range r.Players {
+		pos := p.Position
+		players = append(players, jsonObject{
+			{"name", p.Name},
+			{"pos", []interface{}{pos.X, pos.Y, pos.Z}},
+			{"dimension", p.Dimension},
+		})
+	}
+	obj := jsonObject{
+		{"version", ReportVersion},
+		{"level", jsonObject{
+			{"time", strconv.Itoa64(r.Time)},
+			{"lastPlayed", strconv.Itoa64(r.LastPlayed)},
+			{"randomSeed", strconv.Itoa64(r.RandomSeed)},
+			{"sizeOnDisk", strconv.Itoa64(r.SizeOnDisk)},
+			{"spawn", []interface{}{r.SpawnX, r.SpawnY, r.SpawnZ}},
+			{"snowCovered", r.SnowCovered},
+		}},
+		{"chunks", r.Chunks},
+		{"bounds", bounds},
+		{"entities", countsJSON(r.Entities)},
+		{"tileEntities", countsJSON(r.TileEntities)},
+		{"ores", countsJSON(r.Ores)},
+		{"chests", chests},
+		{"diskSize", diskSize},
+		{"players", players},
+		{"problems", problems},
+	}
+	buf := new(bytes.Buffer)
+	buf.WriteString("{\n")
+	for i, f := range obj {
+		if i > 0 {
+			buf.WriteString(",\n")
+		}
+		writeJSON(buf, f.name)
+		buf.WriteString(": ")
+		if err := writeJSON(buf, f.value); err != nil {
+			return error.NewError(fmt.Sprint("could not encode report ", f.name), err)
+		}
+	}
+	buf.WriteString("\n}\n")
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// countsJSON returns counts as an object with its members in order, or nil if
+// counts is nil.
+func countsJSON(counts map[string]int) interface{} {
+	if counts == nil {
+		return nil
+	}
+	names := make([]string, 0, len(counts))
+	for name := range counts {
+		names = append(names, name)
+	}
+	sort.SortStrings(names)
+	obj := jsonObject{}
+	for _, name := range names {
+		obj = append(obj, jsonField{name, counts[name]})
+	}
+	return obj
+}
@@ -0,0 +1,138 @@
+package world
+
+import "minecraft/nbt"
+
+import "bytes"
+import "io/ioutil"
+import "os"
+import "path"
+import "strings"
+import "testing"
+
+// makeReportWorld writes a world of chunks (0, 0) and (0, -1) and one player.
+// Chunk (0, 0) holds two coal ore, a diamond ore, a glowing redstone ore and a
+// chest, a pig, a skeleton riding a spider, an item that belongs in chunk (-1, 0)
+// and a sign tile entity without its block.
+func makeReportWorld(t *testing.T) string {
+	c := testChunkPayload(0, 0,
+		[]interface{}{pigFixture, mobFixture("Skeleton", map[string]interface{}{"Riding": mobFixture("Spider", nil)}), itemFixture},
+		[]interface{}{
+			tileEntityCompound("Chest", 5, 64, 5, map[string]interface{}{"Items": []interface{}{}}),
+			tileEntityCompound("Sign", 6, 64, 6, map[string]interface{}{"Text1": "", "Text2": "", "Text3": "", "Text4": ""}),
+		})
+	blocks := c["Level"].(map[string]interface{})["Blocks"].([]byte)
+	blocks[blockIndex(1, 10, 1)] = 16
+	blocks[blockIndex(2, 10, 1)] = 16
+	blocks[blockIndex(3, 5, 3)] = 56
+	blocks[blockIndex(4, 12, 4)] = 74
+	blocks[blockIndex(5, 64, 5)] = BlockChest
+	dir := makeTestWorld(t, c, testChunkPayload(0, -1, nil, nil))
+	if err := os.MkdirAll(path.Join(dir, playersdir), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := nbt.Save(path.Join(dir, playersdir, "notch.dat"), "", playerFixture(false)); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func TestReport(t *testing.T) {
+	dir := makeReportWorld(t)
+	defer os.RemoveAll(dir)
+	w, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	r, err := w.Report(nil, SkipDiskSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := new(bytes.Buffer)
+	if err = r.WriteJSON(buf); err != nil {
+		t.Fatal(err)
+	}
+	if want := readGolden(t, "report.json") + "\n"; buf.String() != want {
+		t.Errorf("expected\n%s\ngot\n%s", want, buf)
+	}
+	if len(w.Chunks) != 0 {
+		t.Error("expected no chunks to be made resident, got ", len(w.Chunks))
+	}
+
+	if r, err = w.Report(NewRegion(0, -1, 0, -1), 0); err != nil {
+		t.Fatal(err)
+	}
+	if r.Chunks != 1 || len(r.Entities) != 0 || r.Ores["coal"] != 0 || len(r.Problems) != 0 {
+		t.Errorf("expected one empty chunk, got %+v", r)
+	}
+	if r.DiskSize <= 0 {
+		t.Error("expected the size of the world's files, got ", r.DiskSize)
+	}
+}
+
+func TestReportSkipped(t *testing.T) {
+	dir := makeReportWorld(t)
+	defer os.RemoveAll(dir)
+	w, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	r, err := w.Report(nil, SkipEntities|SkipBlocks|SkipValidation|SkipDiskSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Chunks != 2 || r.Bounds == nil || r.Bounds.MinZ != -1 || r.Bounds.MaxZ != 0 || len(r.Players) != 1 {
+		t.Errorf("expected the chunks and player, got %+v", r)
+	}
+	buf := new(bytes.Buffer)
+	if err = r.WriteJSON(buf); err != nil {
+		t.Fatal(err)
+	}
+	for _, member := range []string{"entities", "tileEntities", "ores", "chests", "diskSize", "problems"} {
+		if s := "\"" + member + "\": null"; !strings.Contains(buf.String(), s) {
+			t.Errorf("expected %s in\n%s", s, buf)
+		}
+	}
+
+	// only the entities are needed, so the chunks are read without their blocks
+	if r, err = w.Report(nil, SkipBlocks|SkipValidation|SkipDiskSize); err != nil {
+		t.Fatal(err)
+	}
+	if r.Entities["Spider"] != 1 || r.TileEntities["Sign"] != 1 || r.Ores != nil || r.Problems != nil {
+		t.Errorf("expected only the censuses, got %+v", r)
+	}
+}
+
+func TestReportCorruptChunk(t *testing.T) {
+	dir := makeReportWorld(t)
+	defer os.RemoveAll(dir)
+	w, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	if err = os.MkdirAll(path.Dir(chunkPath(w.dir, 1, 0)), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err = ioutil.WriteFile(chunkPath(w.dir, 1, 0), []byte("not a chunk"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := w.Report(nil, SkipDiskSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := false
+	for _, p := range r.Problems {
+		found = found || strings.Contains(p, "chunk (1, 0)")
+	}
+	if !found || r.Chunks != 3 || r.Entities["Pig"] != 1 {
+		t.Errorf("expected the corrupt chunk as a problem, got %+v", r)
+	}
+	if _, err = w.Report(nil, SkipValidation|SkipDiskSize); err == nil {
+		t.Error("expected an error without validation")
+	}
+}
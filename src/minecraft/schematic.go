@@ -0,0 +1,301 @@
+package world
+
+import "minecraft/error"
+import "minecraft/nbt"
+
+import "compress/gzip"
+import "fmt"
+import "io"
+import "math"
+import "os"
+
+// see: http://www.minecraftwiki.net/wiki/Schematic_file_format
+
+// ExportSchematic writes the box of blocks with corners (x1, y1, z1) and
+// (x2, y2, z2), both inclusive, in the schematic format MCEdit and WorldEdit
+// read: a gzipped compound named Schematic with the box's Width (x), Height (y)
+// and Length (z), and its Blocks and Data laid out y slowest and x fastest, at
+// index (y*Length + z)*Width + x.
+//
+// Entities whose position lies within the box and tile entities whose block does
+// are included, their coordinates made relative to the box's minimum corner.
+// Blocks in missing chunks are exported as air.  Chunks are read as needed and
+// not kept.
+func (world *World) ExportSchematic(w io.Writer, x1, y1, z1, x2, y2, z2 int32) os.Error {
+	x1, x2 = min32(x1, x2), max32(x1, x2)
+	y1, y2 = min32(y1, y2), max32(y1, y2)
+	z1, z2 = min32(z1, z2), max32(z1, z2)
+	if y1 < 0 || y2 >= ChunkHeight {
+		return error.NewError(fmt.Sprintf("box from y=%d to y=%d leaves the world", y1, y2), ErrOutOfRange)
+	}
+	width, height, length := int64(x2)-int64(x1)+1, int64(y2-y1+1), int64(z2)-int64(z1)+1
+	if width > math.MaxInt16 || length > math.MaxInt16 {
+		return error.NewError(fmt.Sprintf("box of %d by %d blocks is too big for a schematic", width, length), nil)
+	}
+	blocks := make([]byte, width*height*length)
+	data := make([]byte, len(blocks))
+	entities := []interface{}{}
+	tileEntities := []interface{}{}
+	ox, oy, oz := float64(x1), float64(y1), float64(z1)
+	for cx := x1 >> 4; cx <= x2>>4; cx++ {
+		for cz := z1 >> 4; cz <= z2>>4; cz++ {
+			c, err := world.peekChunk(cx, cz)
+			if err != nil {
+				return err
+			}
+			if c == nil {
+				continue
+			}
+			bx0, bx1 := max32(x1, cx*ChunkWidth), min32(x2, cx*ChunkWidth+ChunkWidth-1)
+			bz0, bz1 := max32(z1, cz*ChunkDepth), min32(z2, cz*ChunkDepth+ChunkDepth-1)
+			for x := bx0; x <= bx1; x++ {
+				for z := bz0; z <= bz1; z++ {
+					for y := y1; y <= y2; y++ {
+						i := blockIndex(x-cx*ChunkWidth, y, z-cz*ChunkDepth)
+						j := (int64(y-y1)*length+int64(z-z1))*width + int64(x-x1)
+						blocks[j] = c.Level.Blocks[i]
+						data[j] = getNibble(c.Level.Data, i)
+					}
+				}
+			}
+			for _, e := range c.Level.Entities {
+				p := e.Physics.Position
+				if p.X >= ox && p.X < float64(x2)+1 && p.Y >= oy && p.Y < float64(y2)+1 && p.Z >= oz && p.Z < float64(z2)+1 {
+					entities = append(entities, shiftEntityTags(fromEntity(e), -ox, -oy, -oz))
+				}
+			}
+			for _, te := range c.Level.TileEntities {
+				if x, y, z := te.X(), te.Y(), te.Z(); x >= x1 && x <= x2 && y >= y1 && y <= y2 && z >= z1 && z <= z2 {
+					tileEntities = append(tileEntities, shiftTileEntityTags(te.toCompound(), -x1, -y1, -z1))
+				}
+			}
+		}
+	}
+
+	gz, err := gzip.NewWriter(w)
+	if err != nil {
+		return error.NewError("could not gzip schematic", err)
+	}
+	err = nbt.WriteTagCompound(gz, "Schematic", map[string]interface{}{
+		"Width":        int16(width),
+		"Height":       int16(height),
+		"Length":       int16(length),
+		"Materials":    "Alpha",
+		"Blocks":       blocks,
+		"Data":         data,
+		"Entities":     entities,
+		"TileEntities": tileEntities,
+	})
+	if err != nil {
+		return error.NewError("could not write schematic", err)
+	}
+	if err = gz.Close(); err != nil {
+		return error.NewError("could not finish gzip stream", err)
+	}
+	return nil
+}
+
+// shiftEntityTags returns a copy of an encoded entity moved by (dx, dy, dz),
+// along with the vehicles it rides and, for a painting, the block it hangs from.
+func shiftEntityTags(payload map[string]interface{}, dx, dy, dz float64) map[string]interface{} {
+	payload = copyTags(payload)
+	if pos, ok := payload["Pos"].([]interface{}); ok && len(pos) == 3 {
+		x, ok1 := pos[0].(float64)
+		y, ok2 := pos[1].(float64)
+		z, ok3 := pos[2].(float64)
+		if ok1 && ok2 && ok3 {
+			payload["Pos"] = []interface{}{x + dx, y + dy, z + dz}
+		}
+	}
+	for tag, d := range map[string]float64{"TileX": dx, "TileY": dy, "TileZ": dz} {
+		if v, ok := payload[tag].(int32); ok {
+			payload[tag] = v + int32(d)
+		}
+	}
+	if vehicle, ok := payload["Riding"].(map[string]interface{}); ok {
+		payload["Riding"] = shiftEntityTags(vehicle, dx, dy, dz)
+	}
+	return payload
+}
+
+// shiftTileEntityTags returns a copy of an encoded tile entity moved by (dx, dy,
+// dz).
+func shiftTileEntityTags(payload map[string]interface{}, dx, dy, dz int32) map[string]interface{} {
+	payload = copyTags(payload)
+	for tag, d := range map[string]int32{"x": dx, "y": dy, "z": dz} {
+		if v, ok := payload[tag].(int32); ok {
+			payload[tag] = v + d
+		}
+	}
+	return payload
+}
+
+// A Clipboard is a box of blocks lifted out of a world or a schematic, ready to
+// Paste.  Blocks and Data hold a block id and data value for each of its Width (x)
+// by Height (y) by Length (z) blocks, laid out as in a schematic, at index
+// (y*Length + z)*Width + x.  The positions of Entities and the coordinates of
+// TileEntities are relative to the box's minimum corner.
+type Clipboard struct {
+	Width, Height, Length int32
+	Blocks, Data          []byte
+	Entities              []*Entity
+	TileEntities          []TileEntity
+}
+
+// LoadSchematic reads a schematic, as written by ExportSchematic or MCEdit, into a
+// Clipboard.  Schematics of materials other than Alpha, or that use AddBlocks to
+// hold block ids above 255, are refused.  Entities and tile entities that cannot
+// be decoded are skipped.
+func LoadSchematic(r io.Reader) (*Clipboard, os.Error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, error.NewError("could not gunzip schematic", err)
+	}
+	defer gz.Close()
+	_, s, err := nbt.ReadTagCompound(gz)
+	if err != nil {
+		return nil, error.NewError("could not read schematic", err)
+	}
+	if _, ok := s["AddBlocks"]; ok {
+		return nil, error.NewError("schematic uses AddBlocks for block ids above 255, which this world cannot hold", nil)
+	}
+	if materials, err := getString(s, "Materials"); err != nil {
+		return nil, error.NewError("could not decode schematic", err)
+	} else if materials != "Alpha" {
+		return nil, error.NewError(fmt.Sprintf("schematic has %s materials, not Alpha", materials), nil)
+	}
+	var dims [3]int16
+	for i, name := range []string{"Width", "Height", "Length"} {
+		if dims[i], err = getInt16(s, name); err != nil {
+			return nil, error.NewError("could not decode schematic", err)
+		}
+		if dims[i] < 0 {
+			return nil, error.NewError(fmt.Sprintf("schematic has negative %s %d", name, dims[i]), nil)
+		}
+	}
+	cb := &Clipboard{Width: int32(dims[0]), Height: int32(dims[1]), Length: int32(dims[2])}
+	n := int(cb.Width * cb.Height * cb.Length)
+	for _, a := range []struct {
+		name string
+		to   *[]byte
+	}{{"Blocks", &cb.Blocks}, {"Data", &cb.Data}} {
+		v, ok := s[a.name].([]byte)
+		if !ok {
+			return nil, error.NewError("could not decode schematic", tagError(a.name, "byte array", s[a.name]))
+		}
+		if len(v) != n {
+			return nil, error.NewError(fmt.Sprintf("schematic of %d blocks has %d in %s", n, len(v), a.name), nil)
+		}
+		*a.to = v
+	}
+	if entities, err := getList(s, "Entities"); err == nil {
+		if cb.Entities, _, err = toEntityList(entities); err != nil {
+			return nil, error.NewError("could not decode schematic", err)
+		}
+	}
+	if tileEntities, err := getList(s, "TileEntities"); err == nil {
+		for _, payload := range tileEntities {
+			if c, ok := payload.(map[string]interface{}); ok {
+				if te, err := toTileEntity(c); err == nil {
+					cb.TileEntities = append(cb.TileEntities, te)
+				}
+			}
+		}
+	}
+	return cb, nil
+}
+
+// PasteOptions control how Paste places a Clipboard.
+type PasteOptions struct {
+	// SkipAir leaves the world's blocks where the clipboard has air, so that a
+	// build keeps the terrain it is pasted into.
+	SkipAir bool
+}
+
+// Paste places cb with its minimum corner at absolute block coordinates (x, y, z).
+// Its entities and tile entities are moved to absolute coordinates and added to
+// the chunks they land in, and any tile entity already at a block that is pasted
+// over is removed.  Every chunk the box spans is loaded, and its Lock taken,
+// before any is modified.
+func (world *World) Paste(cb *Clipboard, x, y, z int32, opts PasteOptions) os.Error {
+	if n := int(cb.Width * cb.Height * cb.Length); len(cb.Blocks) != n || len(cb.Data) != n {
+		return error.NewError(fmt.Sprintf("clipboard of %d blocks has %d block ids and %d data values", n, len(cb.Blocks), len(cb.Data)), nil)
+	}
+	if cb.Width == 0 || cb.Height == 0 || cb.Length == 0 {
+		return nil
+	}
+	if y < 0 || y+cb.Height > ChunkHeight {
+		return error.NewError(fmt.Sprintf("clipboard from y=%d of height %d leaves the world", y, cb.Height), ErrOutOfRange)
+	}
+	// locked in the order ListChunks sorts them, as lockPair locks them
+	chunks := make(map[XZ]*Chunk)
+	defer func() {
+		for _, c := range chunks {
+			c.Unlock()
+		}
+	}()
+	for cx := x >> 4; cx <= (x+cb.Width-1)>>4; cx++ {
+		for cz := z >> 4; cz <= (z+cb.Length-1)>>4; cz++ {
+			c, err := world.GetChunk(cx, cz)
+			if err != nil {
+				return error.InChunk(cx, cz).Error("could not get chunk", err)
+			}
+			c.Lock()
+			chunks[MakeXZ(cx, cz)] = c
+		}
+	}
+	chunkAt := func(bx, bz int32) *Chunk {
+		return chunks[MakeXZ(bx>>4, bz>>4)]
+	}
+
+	pasted := func(bx, by, bz int32) bool {
+		i := ((by-y)*cb.Length+(bz-z))*cb.Width + (bx - x)
+		return !opts.SkipAir || cb.Blocks[i] != BlockAir
+	}
+	for _, c := range chunks {
+		kept := c.Level.TileEntities[:0]
+		for _, te := range c.Level.TileEntities {
+			if bx, by, bz := te.X(), te.Y(), te.Z(); bx < x || bx >= x+cb.Width || by < y || by >= y+cb.Height ||
+				bz < z || bz >= z+cb.Length || !pasted(bx, by, bz) {
+				kept = append(kept, te)
+			}
+		}
+		c.Level.TileEntities = kept
+	}
+	for i, id := range cb.Blocks {
+		if opts.SkipAir && id == BlockAir {
+			continue
+		}
+		dx, dy, dz := int32(i)%cb.Width, int32(i)/(cb.Width*cb.Length), int32(i)/cb.Width%cb.Length
+		chunkAt(x+dx, z+dz).SetBlock((x+dx)&15, y+dy, (z+dz)&15, id, cb.Data[i]&0xf)
+	}
+
+	for _, te := range cb.TileEntities {
+		moved, err := toTileEntity(shiftTileEntityTags(te.toCompound(), x, y, z))
+		if err != nil {
+			return error.NewError("could not move tile entity", err)
+		}
+		c := chunkAt(moved.X(), moved.Z())
+		if c == nil {
+			return error.NewError(fmt.Sprintf("%s at (%d, %d, %d) lies outside the clipboard", te.Id(), te.X(), te.Y(), te.Z()), nil)
+		}
+		moved.tileEntityBase().chunk = c
+		c.Level.TileEntities = append(c.Level.TileEntities, moved)
+		c.dirty = true
+	}
+	for _, e := range cb.Entities {
+		moved, err := toEntity(shiftEntityTags(fromEntity(e), float64(x), float64(y), float64(z)))
+		if err != nil {
+			return error.NewError("could not paste entity", err)
+		}
+		cx, cz := moved.Physics.Position.ChunkXZ()
+		c, ok := chunks[MakeXZ(cx, cz)]
+		if !ok {
+			return error.NewError(fmt.Sprintf("%s at %v lies outside the clipboard", moved.Id, moved.Physics.Position), nil)
+		}
+		if err = c.AddEntity(moved); err != nil {
+			return error.NewError("could not paste entity", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,287 @@
+package world
+
+import "minecraft/nbt"
+
+import "bytes"
+import "compress/gzip"
+import "os"
+import "strings"
+import "testing"
+
+// schematicWorld returns chunks (-1, 0) and (0, 0) holding a build between
+// (-2, 60, 1) and (1, 62, 3): stone at its minimum corner, red wool at its maximum
+// one and a chest of cobblestone at (-2, 61, 2), with a drop of it in the middle
+// and another just inside its +x face.  A drop and a sign lie outside it.
+func schematicWorld() *World {
+	w := &World{Chunks: make(map[XZ]*Chunk)}
+	chest := tileEntityCompound("Chest", -2, 61, 2, map[string]interface{}{
+		"Items": []interface{}{itemCompound(4, BlockCobblestone, 12, 0)},
+	})
+	sign := tileEntityCompound("Sign", 5, 61, 2, map[string]interface{}{
+		"Text1": "keep", "Text2": "", "Text3": "", "Text4": "",
+	})
+	west := mustChunk(testChunkPayload(-1, 0, []interface{}{itemAt(-0.5, 61, 2.5)}, []interface{}{chest}))
+	east := mustChunk(testChunkPayload(0, 0, []interface{}{itemAt(1.875, 60, 1), itemAt(2.125, 60, 2)}, []interface{}{sign}))
+	west.SetBlock(14, 60, 1, BlockStone, 0)
+	west.SetBlock(14, 61, 2, 54, 0)
+	east.SetBlock(1, 62, 3, 35, 14)
+	w.Chunks[MakeXZ(-1, 0)] = west
+	w.Chunks[MakeXZ(0, 0)] = east
+	return w
+}
+
+func readSchematic(t *testing.T, buf *bytes.Buffer) map[string]interface{} {
+	gz, err := gzip.NewReader(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	name, payload, err := nbt.ReadTagCompound(gz)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name != "Schematic" {
+		t.Error("expected a compound named Schematic, got ", name)
+	}
+	return payload
+}
+
+func TestExportSchematic(t *testing.T) {
+	buf := new(bytes.Buffer)
+	// corners given maximum first
+	if err := schematicWorld().ExportSchematic(buf, 1, 62, 3, -2, 60, 1); err != nil {
+		t.Fatal(err)
+	}
+	s := readSchematic(t, buf)
+	if s["Width"] != int16(4) || s["Height"] != int16(3) || s["Length"] != int16(3) {
+		t.Fatalf("expected a 4x3x3 schematic, got %v x %v x %v", s["Width"], s["Height"], s["Length"])
+	}
+	if s["Materials"] != "Alpha" {
+		t.Error("expected Alpha materials, got ", s["Materials"])
+	}
+	blocks, data := s["Blocks"].([]byte), s["Data"].([]byte)
+	if len(blocks) != 36 || len(data) != 36 {
+		t.Fatalf("expected 36 blocks and data values, got %d and %d", len(blocks), len(data))
+	}
+	for i, id := range blocks {
+		want := byte(BlockAir)
+		switch i {
+		case 0: // (0, 0, 0)
+			want = BlockStone
+		case 16: // (0, 1, 1)
+			want = 54
+		case 35: // (3, 2, 2)
+			want = 35
+		}
+		if id != want {
+			t.Errorf("block %d: expected %d, got %d", i, want, id)
+		}
+	}
+	if data[35] != 14 {
+		t.Error("expected red wool, got data ", data[35])
+	}
+
+	entities := s["Entities"].([]interface{})
+	if len(entities) != 2 {
+		t.Fatal("expected the 2 drops inside the box, got ", entities)
+	}
+	for i, want := range []Position{{1.5, 1, 1.5}, {3.875, 0, 0}} {
+		p := mustEntity(entities[i].(map[string]interface{})).Physics.Position
+		if p.X != want.X || p.Y != want.Y || p.Z != want.Z {
+			t.Errorf("entity %d: expected relative position %v, got %v", i, want, p)
+		}
+	}
+	tileEntities := s["TileEntities"].([]interface{})
+	if len(tileEntities) != 1 {
+		t.Fatal("expected only the chest, got ", tileEntities)
+	}
+	te, err := toTileEntity(tileEntities[0].(map[string]interface{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if chest, ok := te.(*Chest); !ok || chest.X() != 0 || chest.Y() != 1 || chest.Z() != 1 || len(chest.Slots) != 1 {
+		t.Errorf("expected the chest at (0, 1, 1), got %v", te)
+	}
+}
+
+func TestExportSchematicMissingChunks(t *testing.T) {
+	buf := new(bytes.Buffer)
+	if err := schematicWorld().ExportSchematic(buf, 14, 0, 0, 17, 127, 0); err != nil {
+		t.Fatal(err)
+	}
+	s := readSchematic(t, buf)
+	for i, id := range s["Blocks"].([]byte) {
+		if id != BlockAir {
+			t.Fatalf("block %d: expected air in missing chunk (1, 0), got %d", i, id)
+		}
+	}
+	if err := schematicWorld().ExportSchematic(new(bytes.Buffer), 0, -1, 0, 1, 10, 1); err == nil {
+		t.Error("expected an error for a box below the world")
+	}
+	if err := schematicWorld().ExportSchematic(new(bytes.Buffer), -20000, 0, 0, 20000, 0, 0); err == nil {
+		t.Error("expected an error for a box too wide for a schematic")
+	}
+}
+
+// loadShed reads testdata/shed.schematic, a 3x2x3 shed with a cobblestone floor,
+// a torch on its (0, 1, 0) corner, a chest of 3 diamonds and 20 cobblestone at
+// (1, 1, 1) and a saddled pig at (2.5, 1, 0.5).  It carries WorldEdit's origin
+// tags too.
+func loadShed(t *testing.T) *Clipboard {
+	f, err := os.Open("testdata/shed.schematic", os.O_RDONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	cb, err := LoadSchematic(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cb
+}
+
+func TestLoadSchematic(t *testing.T) {
+	cb := loadShed(t)
+	if cb.Width != 3 || cb.Height != 2 || cb.Length != 3 {
+		t.Fatalf("expected a 3x2x3 clipboard, got %dx%dx%d", cb.Width, cb.Height, cb.Length)
+	}
+	if cb.Blocks[0] != BlockCobblestone || cb.Blocks[9] != BlockTorch || cb.Data[9] != 5 || cb.Blocks[13] != BlockChest {
+		t.Error("expected floor, torch and chest, got ", cb.Blocks)
+	}
+	if len(cb.Entities) != 1 || len(cb.TileEntities) != 1 {
+		t.Fatalf("expected a pig and a chest, got %d entities and %d tile entities", len(cb.Entities), len(cb.TileEntities))
+	}
+}
+
+func TestPasteSchematic(t *testing.T) {
+	w := &World{Chunks: make(map[XZ]*Chunk)}
+	c := newChunk(-2, -1)
+	w.Chunks[MakeXZ(-2, -1)] = c
+	// stone where the shed has air, and a sign where its chest goes
+	c.SetBlock(-18&15, 65, -5&15, BlockStone, 0)
+	c.SetBlock(-19&15, 65, -6&15, BlockSignPost, 0)
+	sign := newTileEntity("Sign", -19, 65, -6)
+	sign.tileEntityBase().chunk = c
+	c.Level.TileEntities = append(c.Level.TileEntities, sign)
+
+	if err := w.Paste(loadShed(t), -20, 64, -7, PasteOptions{SkipAir: true}); err != nil {
+		t.Fatal(err)
+	}
+	for _, b := range []struct {
+		x, y, z  int32
+		id, data byte
+	}{
+		{-20, 64, -7, BlockCobblestone, 0},
+		{-18, 64, -5, BlockCobblestone, 0},
+		{-20, 65, -7, BlockTorch, 5},
+		{-19, 65, -6, BlockChest, 2},
+		{-18, 65, -5, BlockStone, 0}, // air in the shed, skipped
+	} {
+		if id, data, _ := c.BlockAt(b.x&15, b.y, b.z&15); id != b.id || data != b.data {
+			t.Errorf("block (%d, %d, %d): expected %d:%d, got %d:%d", b.x, b.y, b.z, b.id, b.data, id, data)
+		}
+	}
+	if len(c.Level.TileEntities) != 1 {
+		t.Fatal("expected the chest to replace the sign, got ", c.Level.TileEntities)
+	}
+	chest, ok := c.Level.TileEntities[0].(*Chest)
+	if !ok || chest.X() != -19 || chest.Y() != 65 || chest.Z() != -6 {
+		t.Fatal("expected a chest at (-19, 65, -6), got ", c.Level.TileEntities[0])
+	}
+	if items := chest.Items(); items[0].Id != 264 || items[0].Count != 3 || items[5].Id != BlockCobblestone || items[5].Count != 20 {
+		t.Error("expected 3 diamonds and 20 cobblestone, got ", chest.Slots)
+	}
+	if len(c.Level.Entities) != 1 {
+		t.Fatal("expected the pig, got ", c.Level.Entities)
+	}
+	pig := c.Level.Entities[0]
+	if p := pig.Physics.Position; p.X != -17.5 || p.Y != 65 || p.Z != -6.5 {
+		t.Error("expected the pig at (-17.5, 65, -6.5), got ", p)
+	}
+	if pig.Pig == nil || pig.Pig.Saddle != 1 {
+		t.Error("expected the pig to keep its saddle")
+	}
+	if !c.Dirty() {
+		t.Error("chunk not marked dirty")
+	}
+
+	// without SkipAir the stone is replaced
+	if err := w.Paste(loadShed(t), -20, 64, -7, PasteOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if id, _, _ := c.BlockAt(-18&15, 65, -5&15); id != BlockAir {
+		t.Error("expected air to be pasted over the stone, got ", id)
+	}
+	if len(c.Level.TileEntities) != 1 {
+		t.Error("expected the second chest to replace the first, got ", c.Level.TileEntities)
+	}
+	if err := w.Paste(loadShed(t), -20, 127, -7, PasteOptions{}); err == nil {
+		t.Error("expected an error for a paste above the world")
+	}
+}
+
+func TestSchematicRoundTrip(t *testing.T) {
+	buf := new(bytes.Buffer)
+	if err := schematicWorld().ExportSchematic(buf, -2, 60, 1, 1, 62, 3); err != nil {
+		t.Fatal(err)
+	}
+	exported := buf.Bytes()
+	cb, err := LoadSchematic(bytes.NewBuffer(exported))
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := &World{Chunks: make(map[XZ]*Chunk)}
+	w.Chunks[MakeXZ(0, 0)] = newChunk(0, 0)
+	if err = w.Paste(cb, 4, 10, 4, PasteOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	buf = new(bytes.Buffer)
+	if err = w.ExportSchematic(buf, 4, 10, 4, 7, 12, 6); err != nil {
+		t.Fatal(err)
+	}
+	a, b := readSchematic(t, bytes.NewBuffer(exported)), readSchematic(t, buf)
+	for _, tag := range []string{"Blocks", "Data", "Entities", "TileEntities"} {
+		if !nbt.Equal(a[tag], b[tag]) {
+			t.Errorf("%s: expected %v, got %v", tag, a[tag], b[tag])
+		}
+	}
+}
+
+func TestLoadSchematicRefuses(t *testing.T) {
+	schematic := func(tags map[string]interface{}) *bytes.Buffer {
+		s := map[string]interface{}{
+			"Width": int16(1), "Height": int16(1), "Length": int16(1),
+			"Materials": "Alpha", "Blocks": []byte{1}, "Data": []byte{0},
+			"Entities": []interface{}{}, "TileEntities": []interface{}{},
+		}
+		for k, v := range tags {
+			s[k] = v
+		}
+		buf := new(bytes.Buffer)
+		gz, err := gzip.NewWriter(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = nbt.WriteTagCompound(gz, "Schematic", s); err != nil {
+			t.Fatal(err)
+		}
+		gz.Close()
+		return buf
+	}
+	if _, err := LoadSchematic(schematic(nil)); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct {
+		tags map[string]interface{}
+		want string
+	}{
+		{map[string]interface{}{"AddBlocks": []byte{1}}, "AddBlocks"},
+		{map[string]interface{}{"Materials": "Pocket"}, "Pocket"},
+		{map[string]interface{}{"Data": []byte{0, 0}}, "Data"},
+		{map[string]interface{}{"Width": int32(1)}, "Width"},
+	} {
+		_, err := LoadSchematic(schematic(c.tags))
+		if err == nil || !strings.Contains(err.String(), c.want) {
+			t.Errorf("expected an error about %s, got %v", c.want, err)
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package world
+
+import "minecraft/error"
+
+import "fmt"
+import "os"
+import "utf8"
+
+// SignLineLength is the longest line, in characters, the client shows on a sign.
+const SignLineLength = 15
+
+// Lines returns the sign's four lines of text, top first.
+func (sign *Sign) Lines() [4]string {
+	return [4]string{sign.Text1, sign.Text2, sign.Text3, sign.Text4}
+}
+
+// SetLines replaces the sign's text and marks its chunk dirty.  No line may be
+// longer than SignLineLength characters.
+func (sign *Sign) SetLines(lines [4]string) os.Error {
+	for i, line := range lines {
+		if n := utf8.RuneCountInString(line); n > SignLineLength {
+			return error.NewError(fmt.Sprintf("line %d of sign at (%d, %d, %d) is %d characters long; the limit is %d",
+				i+1, sign.x, sign.y, sign.z, n, SignLineLength), nil)
+		}
+	}
+	sign.Text1, sign.Text2, sign.Text3, sign.Text4 = lines[0], lines[1], lines[2], lines[3]
+	sign.touch()
+	return nil
+}
+
+// SignsIn returns every sign in region (nil meaning the whole world).  The
+// chunks holding them are made resident so that edits are kept by Flush.
+func (world *World) SignsIn(region *Region) (signs []*Sign, err os.Error) {
+	coords, err := world.ListChunks(region)
+	if err != nil {
+		return
+	}
+	signs = make([]*Sign, 0)
+	for _, xz := range coords {
+		c, err := world.GetChunk(xz.X, xz.Z)
+		if err != nil {
+			return nil, err
+		}
+		for _, te := range c.Level.TileEntities {
+			if sign, ok := te.(*Sign); ok {
+				signs = append(signs, sign)
+			}
+		}
+	}
+	return
+}
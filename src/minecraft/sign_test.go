@@ -0,0 +1,76 @@
+package world
+
+import "os"
+import "reflect"
+import "strings"
+import "testing"
+
+func TestSetLines(t *testing.T) {
+	sign := &Sign{Text1: "a", Text2: "b", Text3: "c", Text4: "d"}
+	if !reflect.DeepEqual(sign.Lines(), [4]string{"a", "b", "c", "d"}) {
+		t.Error("bad lines ", sign.Lines())
+	}
+	// 15 characters but 30 bytes
+	if err := sign.SetLines([4]string{"ééééééééééééééé", "", "", ""}); err != nil {
+		t.Error(err)
+	}
+	if err := sign.SetLines([4]string{"", "", "", "0123456789abcdef"}); err == nil {
+		t.Error("expected an error for a 16 character line")
+	}
+	if sign.Text1 != "ééééééééééééééé" || sign.Text4 != "" {
+		t.Error("rejected lines were applied: ", sign.Lines())
+	}
+}
+
+func TestSignEditSurvivesFlush(t *testing.T) {
+	other := tileEntityCompound("Sign", -20, 70, 5, map[string]interface{}{
+		"Text1": "Notch's house", "Text2": "", "Text3": "", "Text4": "",
+	})
+	dir := makeTestWorld(t,
+		testChunkPayload(0, 0, nil, []interface{}{signFixture, chestFixture}),
+		testChunkPayload(-2, 0, nil, []interface{}{other}))
+	defer os.RemoveAll(dir)
+	w, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signs, err := w.SignsIn(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(signs) != 2 {
+		t.Fatal("expected 2 signs, got ", len(signs))
+	}
+	for _, sign := range signs {
+		lines := sign.Lines()
+		for i := range lines {
+			lines[i] = strings.Replace(lines[i], "Notch", "jeb_", -1)
+		}
+		lines[3] = "☃ 𝄞 日本"
+		if err = sign.SetLines(lines); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !w.Chunks[MakeXZ(0, 0)].Dirty() || !w.Chunks[MakeXZ(-2, 0)].Dirty() {
+		t.Error("editing signs did not dirty their chunks")
+	}
+	if err = w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+
+	if w, err = Open(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	if signs, err = w.SignsIn(NewRegion(-2, 0, -2, 0)); err != nil {
+		t.Fatal(err)
+	}
+	if len(signs) != 1 {
+		t.Fatal("expected 1 sign, got ", len(signs))
+	}
+	if want := [4]string{"jeb_'s house", "", "", "☃ 𝄞 日本"}; !reflect.DeepEqual(signs[0].Lines(), want) {
+		t.Errorf("expected %q, got %q", want, signs[0].Lines())
+	}
+}
@@ -0,0 +1,127 @@
+package world
+
+import "minecraft/error"
+
+import "fmt"
+import "image"
+import "image/png"
+import "io"
+import "os"
+
+// SliceOptions control how RenderSlice and RenderSection draw blocks.
+type SliceOptions struct {
+	// BlackAir draws air black rather than transparent.
+	BlackAir bool
+}
+
+// missingColor marks the blocks of missing chunks on slices and sections.
+var missingColor = image.NRGBAColor{0x40, 0x00, 0x40, 0xff}
+
+// color returns the color of a block with the given id on a slice or section.
+func (opts *SliceOptions) color(id byte) image.NRGBAColor {
+	c := blockColors[id]
+	if opts.BlackAir && c.A != 0xff {
+		c = over(c, image.NRGBAColor{0, 0, 0, 0xff})
+	}
+	return c
+}
+
+// RenderSlice writes a PNG of the layer of blocks at height y across region (nil
+// meaning every chunk in the world), one pixel per block colored as on RenderMap
+// but without looking through water.  Pixel (px, py) is block
+// (region.MinX*16 + px, y, region.MinZ*16 + py), so that x grows to the right and
+// z downward, as on RenderMap.  Blocks of missing chunks are a dark purple.
+// Chunks are read a row at a time as the PNG is written and are not kept.
+func (world *World) RenderSlice(w io.Writer, region *Region, y int32, opts SliceOptions) os.Error {
+	if y < 0 || y >= ChunkHeight {
+		return error.NewError(fmt.Sprintf("y=%d is outside the world", y), ErrOutOfRange)
+	}
+	var last *Chunk // the chunk layer holds the slice of
+	var layer [chunkColumns]byte
+	return world.renderColumns("RenderSlice", w, region, 1, image.NRGBAColorModel, func(c *Chunk, x, z int32) image.Color {
+		if c == nil {
+			return missingColor
+		}
+		if c != last {
+			layer, _, _ = c.Slice(y)
+			last = c
+		}
+		return opts.color(layer[x+z*ChunkWidth])
+	})
+}
+
+// Axis names a horizontal axis of the world.
+type Axis int
+
+const (
+	AxisX Axis = iota
+	AxisZ
+)
+
+// RenderSection writes a PNG of the vertical cross-section through region (nil
+// meaning every chunk in the world) where the given axis equals coord, as if cut
+// open and seen from the side, one pixel per block colored as on RenderSlice.
+// The top row is y=127 and the bottom row y=0.  For AxisX, the plane x = coord
+// seen from the west, pixel (px, py) is block (coord, 127 - py,
+// region.MinZ*16 + px); for AxisZ, the plane z = coord seen from the south, it is
+// block (region.MinX*16 + px, 127 - py, coord).  The image is 128 pixels tall and
+// as wide as the region is along the other axis.  Only the chunks the plane
+// passes through are read, and they are not kept.
+func (world *World) RenderSection(w io.Writer, region *Region, axis Axis, coord int32, opts SliceOptions) os.Error {
+	region, err := world.extent(region)
+	if err != nil {
+		return err
+	}
+	var chunks, first int32 // the chunks across the image, and the first of them
+	switch axis {
+	case AxisX:
+		if !region.Contains(coord>>4, region.MinZ) {
+			return error.NewError(fmt.Sprintf("x=%d is outside the region", coord), ErrOutOfRange)
+		}
+		chunks, first = region.Depth(), region.MinZ
+	case AxisZ:
+		if !region.Contains(region.MinX, coord>>4) {
+			return error.NewError(fmt.Sprintf("z=%d is outside the region", coord), ErrOutOfRange)
+		}
+		chunks, first = region.Width(), region.MinX
+	default:
+		return error.NewError(fmt.Sprintf("unknown axis %d", axis), nil)
+	}
+	m := image.NewNRGBA(int(chunks)*ChunkWidth, ChunkHeight)
+	t := world.begin("RenderSection", int(chunks))
+	for i := int32(0); i < chunks; i++ {
+		if err = t.cancelled(); err != nil {
+			return t.end(err)
+		}
+		cx, cz := coord>>4, first+i
+		if axis == AxisZ {
+			cx, cz = first+i, coord>>4
+		}
+		c, err := world.peekChunk(cx, cz)
+		if err != nil {
+			return t.end(err)
+		}
+		for y := int32(0); y < ChunkHeight; y++ {
+			var layer [chunkColumns]byte
+			if c != nil {
+				layer, _, _ = c.Slice(y)
+			}
+			for j := int32(0); j < ChunkWidth; j++ {
+				lx, lz := coord&15, j
+				if axis == AxisZ {
+					lx, lz = j, coord&15
+				}
+				color := missingColor
+				if c != nil {
+					color = opts.color(layer[lx+lz*ChunkWidth])
+				}
+				m.SetNRGBA(int(i*ChunkWidth+j), int(ChunkHeight-1-y), color)
+			}
+		}
+		t.chunk(cx, cz)
+	}
+	if err = png.Encode(w, m); err != nil {
+		return t.end(error.NewError("could not write PNG", err))
+	}
+	return t.end(nil)
+}
@@ -0,0 +1,95 @@
+package world
+
+import "bytes"
+import "image"
+import "testing"
+
+func TestRenderSlice(t *testing.T) {
+	w := mapWorld()
+	buf := new(bytes.Buffer)
+	if err := w.RenderSlice(buf, NewRegion(0, 0, 1, 1), 62, SliceOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	m := decodePNG(t, buf)
+	if b := m.Bounds(); b.Dx() != 32 || b.Dy() != 32 {
+		t.Fatal("expected a 32x32 slice, got ", b)
+	}
+	for _, p := range []struct {
+		px, py int
+		want   image.Color
+	}{
+		{0, 0, blockColors[BlockDirt]}, // under the grass
+		{1, 0, waterColor},
+		{2, 0, image.NRGBAColor{}},
+		{20, 3, missingColor},
+		{21, 23, image.NRGBAColor{}}, // the gold block is higher up
+	} {
+		if got := m.At(p.px, p.py); !sameColor(got, p.want) {
+			t.Errorf("pixel (%d, %d): expected %v, got %v", p.px, p.py, p.want, got)
+		}
+	}
+
+	buf.Reset()
+	if err := w.RenderSlice(buf, NewRegion(0, 0, 0, 0), 62, SliceOptions{BlackAir: true}); err != nil {
+		t.Fatal(err)
+	}
+	m = decodePNG(t, buf)
+	black := image.NRGBAColor{0, 0, 0, 0xff}
+	if got := m.At(2, 0); !sameColor(got, black) {
+		t.Error("expected black air, got ", got)
+	}
+	if got := m.At(1, 0); !sameColor(got, over(waterColor, black)) {
+		t.Error("expected water over black, got ", got)
+	}
+	if err := w.RenderSlice(new(bytes.Buffer), nil, 128, SliceOptions{}); err == nil {
+		t.Error("expected an error for y=128")
+	}
+}
+
+func TestRenderSection(t *testing.T) {
+	w := mapWorld()
+	buf := new(bytes.Buffer)
+	if err := w.RenderSection(buf, NewRegion(0, 0, 1, 1), AxisX, 1, SliceOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	m := decodePNG(t, buf)
+	if b := m.Bounds(); b.Dx() != 32 || b.Dy() != 128 {
+		t.Fatal("expected a 32x128 section, got ", b)
+	}
+	for _, p := range []struct {
+		px, py int
+		want   image.Color
+	}{
+		{0, 127 - 63, waterColor}, // block (1, 63, 0)
+		{0, 127 - 61, blockColors[BlockSand]},
+		{0, 127 - 60, image.NRGBAColor{}},
+		{1, 127 - 61, image.NRGBAColor{}},
+		{16, 0, missingColor}, // chunk (0, 1)
+	} {
+		if got := m.At(p.px, p.py); !sameColor(got, p.want) {
+			t.Errorf("x section pixel (%d, %d): expected %v, got %v", p.px, p.py, p.want, got)
+		}
+	}
+
+	buf.Reset()
+	if err := w.RenderSection(buf, NewRegion(0, 0, 1, 1), AxisZ, 23, SliceOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	m = decodePNG(t, buf)
+	// the gold block at (21, 70, 23)
+	if got := m.At(21, 127-70); !sameColor(got, blockColors[41]) {
+		t.Error("expected the gold block, got ", got)
+	}
+	if got := m.At(5, 127-70); !sameColor(got, missingColor) {
+		t.Error("expected missing chunk (0, 1), got ", got)
+	}
+
+	for _, c := range []struct {
+		axis  Axis
+		coord int32
+	}{{AxisX, 32}, {AxisZ, -1}, {Axis(2), 0}} {
+		if err := w.RenderSection(new(bytes.Buffer), NewRegion(0, 0, 1, 1), c.axis, c.coord, SliceOptions{}); err == nil {
+			t.Errorf("expected an error for axis %d at %d", c.axis, c.coord)
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package world
+
+import "minecraft/error"
+
+import "fmt"
+import "os"
+
+// SetEntityId makes the spawner spawn id instead.  Unless allowUnknown is set
+// for modded mobs, id must be one KnownMob accepts.
+func (spawner *MobSpawner) SetEntityId(id string, allowUnknown bool) os.Error {
+	if id == "" || !allowUnknown && !KnownMob(id) {
+		return error.NewError(fmt.Sprintf("unknown mob %q", id), nil)
+	}
+	spawner.EntityId = id
+	spawner.touch()
+	return nil
+}
+
+// SetDelay sets the ticks until the spawner next spawns.
+func (spawner *MobSpawner) SetDelay(ticks int16) os.Error {
+	if ticks < 0 {
+		return error.NewError(fmt.Sprint("negative spawner delay ", ticks), nil)
+	}
+	spawner.Delay = ticks
+	spawner.touch()
+	return nil
+}
+
+// FindSpawners returns every mob spawner in region (nil meaning the whole world).
+// The chunks holding them are made resident so that edits are kept by Flush.
+func (world *World) FindSpawners(region *Region) (spawners []*MobSpawner, err os.Error) {
+	coords, err := world.ListChunks(region)
+	if err != nil {
+		return
+	}
+	spawners = make([]*MobSpawner, 0)
+	for _, xz := range coords {
+		c, err := world.GetChunk(xz.X, xz.Z)
+		if err != nil {
+			return nil, err
+		}
+		for _, te := range c.Level.TileEntities {
+			if spawner, ok := te.(*MobSpawner); ok {
+				spawners = append(spawners, spawner)
+			}
+		}
+	}
+	return
+}
+
+// checkSpawnerBlocks warns about spawners that do not sit on a mob spawner block,
+// as is common in edited worlds.
+func checkSpawnerBlocks(c *Chunk) (errs []os.Error) {
+	for _, te := range c.Level.TileEntities {
+		spawner, ok := te.(*MobSpawner)
+		if !ok {
+			continue
+		}
+		id, _, err := c.BlockAt(spawner.local(c))
+		if err != nil || id != BlockMobSpawner {
+			errs = append(errs, error.NewError(fmt.Sprintf("mob spawner at (%d, %d, %d) is on block %d",
+				spawner.x, spawner.y, spawner.z, id), err))
+		}
+	}
+	return
+}
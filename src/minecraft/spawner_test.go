@@ -0,0 +1,77 @@
+package world
+
+import "os"
+import "testing"
+
+func TestSetEntityId(t *testing.T) {
+	spawner := &MobSpawner{EntityId: "Pig"}
+	if err := spawner.SetEntityId("Dragon", false); err == nil || spawner.EntityId != "Pig" {
+		t.Error("expected an unknown mob to be rejected")
+	}
+	if err := spawner.SetEntityId("Dragon", true); err != nil || spawner.EntityId != "Dragon" {
+		t.Error("expected an unknown mob to be allowed: ", err)
+	}
+	if err := spawner.SetEntityId("", true); err == nil {
+		t.Error("expected an empty id to be rejected")
+	}
+	if err := spawner.SetDelay(-1); err == nil {
+		t.Error("expected an error for a negative delay")
+	}
+}
+
+func TestSpawnerBlockWarning(t *testing.T) {
+	payload := testChunkPayload(-1, 0, nil, []interface{}{spawnerFixture})
+	if c := mustChunk(payload); len(c.Warnings) != 1 {
+		t.Error("expected a warning for a spawner on air, got ", c.Warnings)
+	}
+}
+
+func TestRetargetSpawner(t *testing.T) {
+	c := newChunk(-1, 0)
+	c.SetBlock(13, 20, 7, BlockMobSpawner, 0)
+	c.Level.TileEntities = []TileEntity{}
+	payload := mustFromChunk(c)
+	level := payload["Level"].(map[string]interface{})
+	level["TileEntities"] = []interface{}{spawnerFixture}
+	dir := makeTestWorld(t, payload, testChunkPayload(0, 0, nil, []interface{}{chestFixture}))
+	defer os.RemoveAll(dir)
+	w, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	spawners, err := w.FindSpawners(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(spawners) != 1 {
+		t.Fatal("expected 1 spawner, got ", len(spawners))
+	}
+	if warnings := w.Chunks[MakeXZ(-1, 0)].Warnings; len(warnings) != 0 {
+		t.Error("unexpected warnings: ", warnings)
+	}
+	if err = spawners[0].SetEntityId("Zombie", false); err != nil {
+		t.Fatal(err)
+	}
+	if err = spawners[0].SetDelay(200); err != nil {
+		t.Fatal(err)
+	}
+	if !w.Chunks[MakeXZ(-1, 0)].Dirty() {
+		t.Error("editing the spawner did not dirty its chunk")
+	}
+	if err = w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+
+	if w, err = Open(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	if spawners, err = w.FindSpawners(NewRegion(-1, 0, -1, 0)); err != nil {
+		t.Fatal(err)
+	}
+	if len(spawners) != 1 || spawners[0].EntityId != "Zombie" || spawners[0].Delay != 200 {
+		t.Error("retargeted spawner not kept: ", spawners)
+	}
+}
@@ -0,0 +1,352 @@
+package world
+
+import "minecraft/error"
+import "minecraft/nbt"
+
+import "os"
+import "sort"
+
+// ChunkCoord names a chunk by its chunk coordinates.
+type ChunkCoord struct {
+	X, Z int32
+}
+
+type chunkCoordSlice []ChunkCoord
+
+func (p chunkCoordSlice) Len() int      { return len(p) }
+func (p chunkCoordSlice) Swap(i, j int) { p[i], p[j] = p[j], p[i] }
+func (p chunkCoordSlice) Less(i, j int) bool {
+	if p[i].X != p[j].X {
+		return p[i].X < p[j].X
+	}
+	return p[i].Z < p[j].Z
+}
+
+// ListChunks returns the coordinates of every chunk in region that is resident
+// or stored, ordered by x and then z.  A nil region means the whole world.
+func (world *World) ListChunks(region *Region) (coords []ChunkCoord, err os.Error) {
+	if region != nil {
+		for x := region.MinX; x <= region.MaxX; x++ {
+			for z := region.MinZ; z <= region.MaxZ; z++ {
+				if world.ChunkExists(x, z) {
+					coords = append(coords, ChunkCoord{x, z})
+				}
+			}
+		}
+		return
+	}
+
+	seen := make(map[XZ]bool)
+	for _, c := range world.residentChunks() {
+		seen[MakeXZ(c.Level.XPos, c.Level.ZPos)] = true
+		coords = append(coords, ChunkCoord{c.Level.XPos, c.Level.ZPos})
+	}
+	keys, err := world.Store().List()
+	if err != nil {
+		err = error.NewError("could not list chunks", err)
+		return
+	}
+	for _, xz := range keys {
+		if !seen[xz] {
+			seen[xz] = true
+			coords = append(coords, ChunkCoord{xz.X(), xz.Z()})
+		}
+	}
+	sort.Sort(chunkCoordSlice(coords))
+	return
+}
+
+// streamChunks calls f on every chunk in region (nil meaning the whole world)
+// without keeping non-resident chunks in memory: each is loaded, handed to f,
+// written back if f reports it modified, and dropped.  Resident chunks are only
+// marked dirty, and f is called holding their Lock, so it must not take another
+// chunk's.  The session lock is checked, as VerifyLockNow does, only before a
+// chunk is written, so a World opened ReadOnly may be streamed by an f that
+// changes nothing.  Streaming stops at the first error.  With ArrayPoolSize set, the
+// arrays of the chunks not resident are returned to the pool once f is done
+// with them, so f must not keep them.  Progress is told of it as op.
+func (world *World) streamChunks(op string, region *Region, f func(c *Chunk) (modified bool, err os.Error)) os.Error {
+	return world.streamChunksHolding(op, region, nil, f)
+}
+
+// streamChunksHolding is streamChunks for a caller that holds the Lock of held,
+// a resident chunk f may change besides the one it is given, which is not
+// locked again when it is streamed.
+func (world *World) streamChunksHolding(op string, region *Region, held *Chunk, f func(c *Chunk) (modified bool, err os.Error)) os.Error {
+	coords, err := world.ListChunks(region)
+	if err != nil {
+		return err
+	}
+	t := world.begin(op, len(coords))
+	for _, xz := range coords {
+		if err = t.cancelled(); err != nil {
+			return t.end(err)
+		}
+		if err = world.streamChunk(xz.X, xz.Z, held, f, t); err != nil {
+			return t.end(err)
+		}
+		t.chunk(xz.X, xz.Z)
+	}
+	return t.end(nil)
+}
+
+// streamChunk does the work of streamChunks for the chunk at (x, z).
+func (world *World) streamChunk(x, z int32, held *Chunk, f func(c *Chunk) (modified bool, err os.Error), t *task) (err os.Error) {
+	c, resident := world.resident(MakeXZ(x, z))
+	if !resident {
+		if c, err = world.readChunk(x, z); err != nil {
+			return
+		}
+		t.warnChunk(c)
+	} else {
+		// so that auto-flush never writes it half changed
+		if c != held {
+			c.Lock()
+			defer c.Unlock()
+		}
+		if err = c.LoadArrays(); err != nil {
+			return
+		}
+	}
+	modified, err := f(c)
+	if err != nil {
+		return
+	}
+	if modified {
+		c.dirty = true
+	}
+	if resident {
+		return
+	}
+	if modified {
+		if err = world.VerifyLockNow(); err != nil {
+			return
+		}
+		if err = world.saveChunk(c); err != nil {
+			return
+		}
+	}
+	world.release(c)
+	return
+}
+
+func skipByteArrays(name string, ttype nbt.TagType) bool {
+	return ttype != nbt.ByteArray
+}
+
+// readChunkLevel decodes the Level compound of the chunk at (x, z) without its
+// block, data, light and height arrays, for scans that only need entities.
+func (world *World) readChunkLevel(x, z int32) (level map[string]interface{}, err os.Error) {
+	_, chunkmap, err := world.loadChunkMap(x, z, skipByteArrays)
+	if err != nil {
+		return
+	}
+	if level, err = getCompound(chunkmap, "Level"); err != nil {
+		err = error.InChunk(x, z).Error("malformed chunk", err)
+	}
+	return
+}
+
+// scanEntities calls f with the entities of every chunk in region, in the order
+// of ListChunks, until f returns false.  Resident chunks pass their live entities,
+// listed under the chunk's RLock, which is given back before f is called; the
+// rest are decoded from disk without their blocks and then dropped.
+func (world *World) scanEntities(region *Region, f func(xz ChunkCoord, entities []*Entity) bool) os.Error {
+	coords, err := world.ListChunks(region)
+	if err != nil {
+		return err
+	}
+	for _, xz := range coords {
+		var entities []*Entity
+		if c, ok := world.resident(MakeXZ(xz.X, xz.Z)); ok {
+			c.RLock()
+			entities = append([]*Entity(nil), c.Level.Entities...)
+			c.RUnlock()
+		} else {
+			level, err := world.readChunkLevel(xz.X, xz.Z)
+			if err != nil {
+				return err
+			}
+			list, err := getList(level, "Entities")
+			if err != nil {
+				return error.InChunk(xz.X, xz.Z).Error("malformed chunk", err)
+			}
+			if entities, _, err = toEntityList(list); err != nil {
+				return error.InChunk(xz.X, xz.Z).Error("malformed chunk", err)
+			}
+		}
+		if !f(xz, entities) {
+			break
+		}
+	}
+	return nil
+}
+
+// scanTileEntities calls f with the tile entities of every chunk in region, in
+// the order of ListChunks, until f returns false, reading chunks as scanEntities
+// does.
+func (world *World) scanTileEntities(region *Region, f func(xz ChunkCoord, tes []TileEntity) bool) os.Error {
+	coords, err := world.ListChunks(region)
+	if err != nil {
+		return err
+	}
+	for _, xz := range coords {
+		var tes []TileEntity
+		if c, ok := world.resident(MakeXZ(xz.X, xz.Z)); ok {
+			c.RLock()
+			tes = append([]TileEntity(nil), c.Level.TileEntities...)
+			c.RUnlock()
+		} else {
+			level, err := world.readChunkLevel(xz.X, xz.Z)
+			if err != nil {
+				return err
+			}
+			list, err := getList(level, "TileEntities")
+			if err != nil {
+				return error.InChunk(xz.X, xz.Z).Error("malformed chunk", err)
+			}
+			tes, _ = toTileEntityList(list)
+		}
+		if !f(xz, tes) {
+			break
+		}
+	}
+	return nil
+}
+
+// An EntityAction tells ForEachEntity what to do with the entity it passed.
+type EntityAction int
+
+const (
+	Keep     EntityAction = iota // leave the entity as it was
+	Delete                       // remove the entity from its chunk
+	Modified                     // keep the entity, which has been changed
+)
+
+// ForEachEntity calls fn with every entity in region (nil meaning the whole world)
+// and the chunk holding it, then applies the action fn returns.  Vehicles are
+// reached through their riders.  Chunks that are not resident are decoded without
+// their blocks and dropped afterwards; only those in which something was deleted
+// or modified are written back, by replacing their entities on disk, once the
+// session lock is checked as VerifyLockNow does.  Resident
+// chunks are marked dirty instead, and fn is called holding their Lock, so it
+// must not take a chunk's lock, as MoveEntity does.
+func (world *World) ForEachEntity(region *Region, fn func(chunkX, chunkZ int32, e *Entity) EntityAction) os.Error {
+	coords, err := world.ListChunks(region)
+	if err != nil {
+		return err
+	}
+	t := world.begin("ForEachEntity", len(coords))
+	for _, xz := range coords {
+		if err = t.cancelled(); err != nil {
+			return t.end(err)
+		}
+		if err = world.forChunkEntities(xz.X, xz.Z, fn); err != nil {
+			return t.end(err)
+		}
+		t.chunk(xz.X, xz.Z)
+	}
+	return t.end(nil)
+}
+
+// forChunkEntities does the work of ForEachEntity for the chunk at (x, z).
+func (world *World) forChunkEntities(x, z int32, fn func(chunkX, chunkZ int32, e *Entity) EntityAction) os.Error {
+	c, resident := world.resident(MakeXZ(x, z))
+	var entities []*Entity
+	if resident {
+		c.Lock()
+		defer c.Unlock()
+		entities = c.Level.Entities
+	} else {
+		level, err := world.readChunkLevel(x, z)
+		if err != nil {
+			return err
+		}
+		list, err := getList(level, "Entities")
+		if err != nil {
+			return error.InChunk(x, z).Error("malformed chunk", err)
+		}
+		if entities, _, err = toEntityList(list); err != nil {
+			return error.InChunk(x, z).Error("malformed chunk", err)
+		}
+	}
+
+	changed := false
+	kept := entities[:0]
+	for _, e := range entities {
+		switch fn(x, z, e) {
+		case Delete:
+			changed = true
+			continue
+		case Modified:
+			changed = true
+		}
+		kept = append(kept, e)
+	}
+	if !changed {
+		return nil
+	}
+	if resident {
+		c.Level.Entities = kept
+		c.dirty = true
+		return nil
+	}
+	if err := world.VerifyLockNow(); err != nil {
+		return err
+	}
+	return world.writeEntities(x, z, kept)
+}
+
+// writeEntities replaces the entities of the stored chunk at (x, z), leaving the
+// rest of it untouched.
+func (world *World) writeEntities(x, z int32, entities []*Entity) os.Error {
+	name, chunkmap, err := world.loadChunkMap(x, z, keepAll)
+	if err != nil {
+		return err
+	}
+	level, err := getCompound(chunkmap, "Level")
+	if err != nil {
+		return error.InChunk(x, z).Error("malformed chunk", err)
+	}
+	level["Entities"] = fromEntityList(entities)
+	return world.saveChunkMap(x, z, name, chunkmap)
+}
+
+// extent returns region, or if it is nil the smallest region holding every chunk
+// in the world.
+func (world *World) extent(region *Region) (*Region, os.Error) {
+	if region != nil {
+		return region, nil
+	}
+	coords, err := world.ListChunks(nil)
+	if err != nil {
+		return nil, err
+	}
+	if len(coords) == 0 {
+		return nil, error.NewError("world has no chunks", nil)
+	}
+	region = NewRegion(coords[0].X, coords[0].Z, coords[0].X, coords[0].Z)
+	for _, xz := range coords[1:] {
+		region = region.Union(NewRegion(xz.X, xz.Z, xz.X, xz.Z))
+	}
+	return region, nil
+}
+
+// peekChunk returns the chunk at (x, z) without making it resident, or nil if it
+// does not exist or cannot be read.
+func (world *World) peekChunk(x, z int32) (*Chunk, os.Error) {
+	if c, ok := world.resident(MakeXZ(x, z)); ok {
+		if err := c.LoadArrays(); err != nil {
+			return nil, err
+		}
+		return c, nil
+	}
+	if !world.ChunkExists(x, z) {
+		return nil, nil
+	}
+	c, err := world.readChunk(x, z)
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
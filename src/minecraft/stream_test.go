@@ -0,0 +1,117 @@
+package world
+
+import "os"
+import "runtime"
+import "testing"
+
+func TestForEachEntity(t *testing.T) {
+	dir := makeCensusWorld(t)
+	defer os.RemoveAll(dir)
+	w, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	if _, err = w.LoadChunk(0, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	visited := 0
+	err = w.ForEachEntity(nil, func(cx, cz int32, e *Entity) EntityAction {
+		visited++
+		if e.Id == "Pig" && !(cx == 0 && cz == 0 || cx == -1 && cz == -1) {
+			t.Errorf("pig reported in chunk (%d, %d)", cx, cz)
+		}
+		switch e.Id {
+		case "Item":
+			return Delete
+		case "Pig":
+			health := int16(1)
+			e.Health = &health
+			return Modified
+		}
+		return Keep
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if visited != 10 {
+		t.Error("expected to visit 10 entities, visited ", visited)
+	}
+	if len(w.Chunks) != 1 {
+		t.Error("streamed chunks were kept resident")
+	}
+	if c := w.Chunks[MakeXZ(0, 0)]; !c.Dirty() || len(c.Level.Entities) != 1 {
+		t.Error("resident chunk not updated")
+	}
+
+	w.Chunks = make(map[XZ]*Chunk)
+	counts, err := w.EntityCensus(NewRegion(-1, -1, 2, -1), CensusOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if counts["Item"] != 0 || counts["Pig"] != 1 {
+		t.Error("changes not written back: ", counts)
+	}
+	c, err := w.LoadChunk(-1, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pig := c.Level.Entities[0]; *pig.Health != 1 {
+		t.Error("modified pig not written back")
+	}
+	if len(c.Level.Blocks) != chunkBlocks {
+		t.Error("rewriting entities lost the chunk's blocks")
+	}
+}
+
+// BenchmarkForEachEntity sweeps a world of 20,000 chunks, failing if the heap
+// grows by more than maxSweepHeap, far short of the 1.6 GB the chunks would take
+// were they kept, and reporting the largest growth seen.
+func BenchmarkForEachEntity(b *testing.B) {
+	const maxSweepHeap = 32 << 20
+	b.StopTimer()
+	dir, err := writeTestWorld()
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for x := int32(0); x < 200; x++ {
+		for z := int32(0); z < 100; z++ {
+			item := itemAt(float64(x*16+8), 64, float64(z*16+8))
+			if err = writeTestChunk(dir, testChunkPayload(x, z, []interface{}{item}, nil)); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+	w, err := Open(dir)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer w.Close()
+	runtime.GC()
+	runtime.UpdateMemStats()
+	base := runtime.MemStats.HeapAlloc
+	b.StartTimer()
+
+	peak := base
+	for i := 0; i < b.N; i++ {
+		n := 0
+		err = w.ForEachEntity(nil, func(cx, cz int32, e *Entity) EntityAction {
+			if n++; n%1000 == 0 {
+				runtime.UpdateMemStats()
+				if runtime.MemStats.HeapAlloc > peak {
+					peak = runtime.MemStats.HeapAlloc
+				}
+			}
+			return Keep
+		})
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+	if peak > base+maxSweepHeap {
+		b.Fatalf("heap grew by %d KiB over %d chunks; expected at most %d KiB", (peak-base)/1024, 20000, maxSweepHeap/1024)
+	}
+	b.Logf("heap grew by %d KiB over %d chunks", (peak-base)/1024, 20000)
+}
@@ -0,0 +1,429 @@
+package world
+
+import "minecraft/error"
+import "minecraft/nbt"
+
+import "compress/gzip"
+import "fmt"
+import "io"
+import "math"
+import "os"
+import "strings"
+
+// see: http://minecraft.gamepedia.com/Structure_block_file_format
+
+// StructureMaxSize is the most blocks along each side of the box a structure
+// block saves or loads.
+const StructureMaxSize = 48
+
+// structureDataVersion is the data version ExportStructure writes: that of 1.13,
+// the first to name blocks by state, which later versions upgrade on loading.
+const structureDataVersion = 1519
+
+// StructureReport counts what ExportStructure could not translate.
+type StructureReport struct {
+	// Blocks counts, by id, the blocks left out for want of a block state.  A
+	// structure block leaves the world's blocks where they were there.
+	Blocks map[byte]int
+	// Entities counts, by id, the entities and tile entities kept under their
+	// old ids for want of new ones, which the game skips.
+	Entities map[string]int
+}
+
+// ExportStructure writes the box of blocks with corners (x1, y1, z1) and
+// (x2, y2, z2), both inclusive, as the structure files structure blocks save and
+// load: a gzipped compound with the box's size, a palette of the block states it
+// uses, each block as its position relative to the box's minimum corner and its
+// index in the palette, and the entities whose positions lie within the box.  No
+// side of the box may be longer than StructureMaxSize.
+//
+// Block ids and data values are translated to states by BlockState.  Tile
+// entities go with their blocks, and entities, whose vehicles become their
+// Passengers as newer versions store them, are given the ids newer versions use.
+// Their other tags are kept as they are, so that items, for instance, keep their
+// numeric ids, which newer versions do not read.  What cannot be translated is
+// counted in the report.  Blocks in missing chunks are left out.  Chunks are read
+// as needed and not kept.
+func (world *World) ExportStructure(w io.Writer, x1, y1, z1, x2, y2, z2 int32) (report StructureReport, err os.Error) {
+	report = StructureReport{make(map[byte]int), make(map[string]int)}
+	x1, x2 = min32(x1, x2), max32(x1, x2)
+	y1, y2 = min32(y1, y2), max32(y1, y2)
+	z1, z2 = min32(z1, z2), max32(z1, z2)
+	if y1 < 0 || y2 >= ChunkHeight {
+		return report, error.NewError(fmt.Sprintf("box from y=%d to y=%d leaves the world", y1, y2), ErrOutOfRange)
+	}
+	size := [3]int64{int64(x2) - int64(x1) + 1, int64(y2-y1) + 1, int64(z2) - int64(z1) + 1}
+	if size[0] > StructureMaxSize || size[1] > StructureMaxSize || size[2] > StructureMaxSize {
+		return report, error.NewError(fmt.Sprintf("box of %d by %d by %d blocks is too big for a structure, whose sides are at most %d", size[0], size[1], size[2], StructureMaxSize), nil)
+	}
+
+	var palette []interface{}
+	states := make(map[string]int32)
+	blocks := []interface{}{}
+	entities := []interface{}{}
+	ox, oy, oz := float64(x1), float64(y1), float64(z1)
+	for cx := x1 >> 4; cx <= x2>>4; cx++ {
+		for cz := z1 >> 4; cz <= z2>>4; cz++ {
+			c, err := world.peekChunk(cx, cz)
+			if err != nil {
+				return report, err
+			}
+			if c == nil {
+				continue
+			}
+			tileEntities := make(map[int]TileEntity)
+			for _, te := range c.Level.TileEntities {
+				if x, y, z := te.tileEntityBase().local(c); inChunk(x, y, z) {
+					tileEntities[blockIndex(x, y, z)] = te
+				}
+			}
+			bx0, bx1 := max32(x1, cx*ChunkWidth), min32(x2, cx*ChunkWidth+ChunkWidth-1)
+			bz0, bz1 := max32(z1, cz*ChunkDepth), min32(z2, cz*ChunkDepth+ChunkDepth-1)
+			for x := bx0; x <= bx1; x++ {
+				for z := bz0; z <= bz1; z++ {
+					for y := y1; y <= y2; y++ {
+						i := blockIndex(x-cx*ChunkWidth, y, z-cz*ChunkDepth)
+						id := c.Level.Blocks[i]
+						state, ok := BlockState(id, getNibble(c.Level.Data, i))
+						if !ok {
+							report.Blocks[id]++
+							continue
+						}
+						index, ok := states[state]
+						if !ok {
+							index = int32(len(palette))
+							states[state] = index
+							palette = append(palette, stateCompound(state))
+						}
+						block := map[string]interface{}{
+							"pos":   []interface{}{x - x1, y - y1, z - z1},
+							"state": index,
+						}
+						if te, ok := tileEntities[i]; ok {
+							block["nbt"] = structureTileEntity(te, &report)
+						}
+						blocks = append(blocks, block)
+					}
+				}
+			}
+			for _, e := range c.Level.Entities {
+				p := e.Physics.Position
+				if p.X >= ox && p.X < float64(x2)+1 && p.Y >= oy && p.Y < float64(y2)+1 && p.Z >= oz && p.Z < float64(z2)+1 {
+					tags := structureEntity(shiftEntityTags(fromEntity(e), -ox, -oy, -oz), &report)
+					rx, ry, rz := p.X-ox, p.Y-oy, p.Z-oz
+					entities = append(entities, map[string]interface{}{
+						"pos":      []interface{}{rx, ry, rz},
+						"blockPos": []interface{}{int32(math.Floor(rx)), int32(math.Floor(ry)), int32(math.Floor(rz))},
+						"nbt":      tags,
+					})
+				}
+			}
+		}
+	}
+	if palette == nil {
+		palette = []interface{}{}
+	}
+
+	gz, err := gzip.NewWriter(w)
+	if err != nil {
+		return report, error.NewError("could not gzip structure", err)
+	}
+	err = nbt.WriteTagCompound(gz, "", map[string]interface{}{
+		"DataVersion": int32(structureDataVersion),
+		"size":        []interface{}{int32(size[0]), int32(size[1]), int32(size[2])},
+		"palette":     palette,
+		"blocks":      blocks,
+		"entities":    entities,
+	})
+	if err != nil {
+		return report, error.NewError("could not write structure", err)
+	}
+	if err = gz.Close(); err != nil {
+		return report, error.NewError("could not finish gzip stream", err)
+	}
+	return report, nil
+}
+
+// stateCompound returns a block state written as BlockState gives it, such as
+// "minecraft:wheat[age=7]", as a palette holds it: its Name and, if it has any,
+// its Properties.
+func stateCompound(state string) map[string]interface{} {
+	c := map[string]interface{}{"Name": state}
+	open := strings.Index(state, "[")
+	if open < 0 {
+		return c
+	}
+	c["Name"] = state[:open]
+	properties := make(map[string]interface{})
+	for _, property := range strings.Split(state[open+1:len(state)-1], ",", -1) {
+		kv := strings.Split(property, "=", 2)
+		properties[kv[0]] = kv[1]
+	}
+	c["Properties"] = properties
+	return c
+}
+
+// structureTileEntity returns te as a structure holds it with its block: without
+// its coordinates, and with the id newer versions use.
+func structureTileEntity(te TileEntity, report *StructureReport) map[string]interface{} {
+	tags := te.toCompound()
+	for _, name := range []string{"x", "y", "z"} {
+		tags[name] = nil, false
+	}
+	if id, ok := modernTileEntityIds[te.Id()]; ok {
+		tags["id"] = id
+	} else {
+		report.Entities[te.Id()]++
+	}
+	return tags
+}
+
+// structureEntity returns an encoded entity with the ids newer versions use, and
+// with the vehicle it rides, if any, carrying it as a passenger.
+func structureEntity(tags map[string]interface{}, report *StructureReport) map[string]interface{} {
+	id, _ := tags["id"].(string)
+	if modern, ok := modernEntityIds[id]; ok {
+		tags["id"] = modern
+	} else if kind, _ := tags["Type"].(int32); id == "Minecart" && kind >= MinecartRideable && kind <= MinecartFurnace {
+		tags["id"] = [...]string{"minecraft:minecart", "minecraft:chest_minecart", "minecraft:furnace_minecart"}[kind]
+	} else {
+		report.Entities[id]++
+	}
+	vehicle, ok := tags["Riding"].(map[string]interface{})
+	if !ok {
+		return tags
+	}
+	tags["Riding"] = nil, false
+	vehicle = structureEntity(vehicle, report)
+	vehicle["Passengers"] = []interface{}{tags}
+	return vehicle
+}
+
+// modernTileEntityIds gives the ids newer versions use for tile entities.
+var modernTileEntityIds = map[string]string{
+	"Chest":        "minecraft:chest",
+	"Furnace":      "minecraft:furnace",
+	"Sign":         "minecraft:sign",
+	"MobSpawner":   "minecraft:mob_spawner",
+	"Trap":         "minecraft:dispenser",
+	"Music":        "minecraft:noteblock",
+	"RecordPlayer": "minecraft:jukebox",
+}
+
+// modernEntityIds gives the ids newer versions use for entities, but for
+// minecarts, whose id depends on their type.
+var modernEntityIds = map[string]string{
+	"Item":        "minecraft:item",
+	"Arrow":       "minecraft:arrow",
+	"Snowball":    "minecraft:snowball",
+	"Painting":    "minecraft:painting",
+	"PrimedTnt":   "minecraft:tnt",
+	"FallingSand": "minecraft:falling_block",
+	"Boat":        "minecraft:boat",
+	"Creeper":     "minecraft:creeper",
+	"Skeleton":    "minecraft:skeleton",
+	"Spider":      "minecraft:spider",
+	"Giant":       "minecraft:giant",
+	"Zombie":      "minecraft:zombie",
+	"Slime":       "minecraft:slime",
+	"Ghast":       "minecraft:ghast",
+	"PigZombie":   "minecraft:zombie_pigman",
+	"Pig":         "minecraft:pig",
+	"Sheep":       "minecraft:sheep",
+	"Cow":         "minecraft:cow",
+	"Chicken":     "minecraft:chicken",
+	"Squid":       "minecraft:squid",
+	"Wolf":        "minecraft:wolf",
+}
+
+// simpleStates gives the block state of each id whose data value does not
+// change it.
+var simpleStates = map[byte]string{
+	0: "air", 1: "stone", 2: "grass_block[snowy=false]", 3: "dirt", 4: "cobblestone",
+	5: "oak_planks", 7: "bedrock", 12: "sand", 13: "gravel", 14: "gold_ore",
+	15: "iron_ore", 16: "coal_ore", 19: "sponge", 20: "glass", 21: "lapis_ore",
+	22: "lapis_block", 24: "sandstone", 25: "note_block", 30: "cobweb",
+	32: "dead_bush", 37: "dandelion", 38: "poppy", 39: "brown_mushroom",
+	40: "red_mushroom", 41: "gold_block", 42: "iron_block", 45: "bricks",
+	46: "tnt", 47: "bookshelf", 48: "mossy_cobblestone", 49: "obsidian",
+	51: "fire[age=0]", 52: "spawner", 56: "diamond_ore", 57: "diamond_block",
+	58: "crafting_table", 73: "redstone_ore[lit=false]", 74: "redstone_ore[lit=true]",
+	79: "ice", 80: "snow_block", 82: "clay", 85: "oak_fence", 87: "netherrack",
+	88: "soul_sand", 89: "glowstone", 90: "nether_portal[axis=x]",
+}
+
+var (
+	// the horizontal facings of data values 0 to 3, for most blocks
+	horizontal = [4]string{"south", "west", "north", "east"}
+	// the facings of data values 2 to 5, for wall signs, ladders and chests
+	wallFacing = [6]string{2: "north", 3: "south", 4: "west", 5: "east"}
+	// the facings of data values 1 to 4, for torches and buttons on walls
+	torchFacing = [5]string{1: "east", 2: "west", 3: "south", 4: "north"}
+	stairFacing = [4]string{"east", "west", "south", "north"}
+	doorFacing  = [4]string{"east", "south", "west", "north"}
+	railShapes  = [10]string{"north_south", "east_west", "ascending_east", "ascending_west",
+		"ascending_north", "ascending_south", "south_east", "south_west", "north_west", "north_east"}
+	woolColors = [16]string{"white", "orange", "magenta", "light_blue", "yellow", "lime", "pink", "gray",
+		"light_gray", "cyan", "purple", "blue", "brown", "green", "red", "black"}
+	woodTypes  = [4]string{"oak", "spruce", "birch", "oak"}
+	slabStates = [4]string{"stone_slab", "sandstone_slab", "oak_slab", "cobblestone_slab"}
+)
+
+// BlockState returns the block state newer versions give a block id and data
+// value, in the form "minecraft:name[property=value,...]", or false if it has
+// none.  Properties that data values do not hold, such as whether a door is
+// hinged on the left, take the game's defaults.
+func BlockState(id, data byte) (state string, ok bool) {
+	if state, ok = simpleStates[id]; ok {
+		return "minecraft:" + state, true
+	}
+	f := func(format string, args ...interface{}) string {
+		return fmt.Sprintf(format, args...)
+	}
+	facing := wallFacing[2]
+	if data >= 2 && data <= 5 {
+		facing = wallFacing[data]
+	}
+	switch id {
+	case 6:
+		state = woodTypes[data&3] + "_sapling[stage=0]"
+	case BlockWater, BlockStillWater:
+		state = f("water[level=%d]", data&15)
+	case BlockLava, BlockStillLava:
+		state = f("lava[level=%d]", data&15)
+	case BlockWood:
+		state = woodTypes[data&3] + "_log[axis=y]"
+	case BlockLeaves:
+		state = woodTypes[data&3] + "_leaves[distance=7,persistent=true]"
+	case 23:
+		state = f("dispenser[facing=%s,triggered=false]", facing)
+	case 26:
+		part := "foot"
+		if data&8 != 0 {
+			part = "head"
+		}
+		state = f("red_bed[facing=%s,occupied=false,part=%s]", horizontal[data&3], part)
+	case 27, 28:
+		if data&7 > 5 {
+			return "", false
+		}
+		name := "powered_rail"
+		if id == 28 {
+			name = "detector_rail"
+		}
+		state = f("%s[powered=%t,shape=%s]", name, data&8 != 0, railShapes[data&7])
+	case 31:
+		state = [...]string{"dead_bush", "grass", "fern", "grass"}[data&3]
+	case BlockWool:
+		state = woolColors[data] + "_wool"
+	case 43, 44:
+		kind := "double"
+		if id == 44 {
+			kind = "bottom"
+			if data&8 != 0 {
+				kind = "top"
+			}
+		}
+		state = f("%s[type=%s,waterlogged=false]", slabStates[data&3], kind)
+	case BlockTorch:
+		state = torchState("torch", "wall_torch", data, "")
+	case 53, 67:
+		half := "bottom"
+		if data&4 != 0 {
+			half = "top"
+		}
+		name := "oak_stairs"
+		if id == 67 {
+			name = "cobblestone_stairs"
+		}
+		state = f("%s[facing=%s,half=%s,shape=straight,waterlogged=false]", name, stairFacing[data&3], half)
+	case BlockChest:
+		state = f("chest[facing=%s,type=single,waterlogged=false]", facing)
+	case 55:
+		state = f("redstone_wire[east=none,north=none,power=%d,south=none,west=none]", data&15)
+	case 59:
+		state = f("wheat[age=%d]", data&7)
+	case 60:
+		state = f("farmland[moisture=%d]", data&7)
+	case BlockFurnace, BlockLitFurnace:
+		state = f("furnace[facing=%s,lit=%t]", facing, id == BlockLitFurnace)
+	case BlockSignPost:
+		state = f("sign[rotation=%d,waterlogged=false]", data&15)
+	case 64, 71:
+		name := "oak_door"
+		if id == 71 {
+			name = "iron_door"
+		}
+		half := "lower"
+		if data&8 != 0 {
+			half = "upper"
+		}
+		state = f("%s[facing=%s,half=%s,hinge=left,open=%t,powered=false]", name, doorFacing[data&3], half, data&4 != 0)
+	case 65:
+		state = f("ladder[facing=%s,waterlogged=false]", facing)
+	case 66:
+		if data > 9 {
+			return "", false
+		}
+		state = f("rail[shape=%s]", railShapes[data])
+	case BlockWallSign:
+		state = f("wall_sign[facing=%s,waterlogged=false]", facing)
+	case 69:
+		powered := data&8 != 0
+		switch data & 7 {
+		case 1, 2, 3, 4:
+			state = f("lever[face=wall,facing=%s,powered=%t]", torchFacing[data&7], powered)
+		case 5:
+			state = f("lever[face=floor,facing=north,powered=%t]", powered)
+		case 6:
+			state = f("lever[face=floor,facing=east,powered=%t]", powered)
+		default:
+			return "", false
+		}
+	case 70:
+		state = f("stone_pressure_plate[powered=%t]", data&1 != 0)
+	case 72:
+		state = f("oak_pressure_plate[powered=%t]", data&1 != 0)
+	case 75, 76:
+		state = torchState("redstone_torch", "redstone_wall_torch", data, f(",lit=%t", id == 76))
+	case 77:
+		if data&7 < 1 || data&7 > 4 {
+			return "", false
+		}
+		state = f("stone_button[face=wall,facing=%s,powered=%t]", torchFacing[data&7], data&8 != 0)
+	case 78:
+		state = f("snow[layers=%d]", data&7+1)
+	case 81:
+		state = f("cactus[age=%d]", data&15)
+	case 83:
+		state = f("sugar_cane[age=%d]", data&15)
+	case 84:
+		state = f("jukebox[has_record=%t]", data != 0)
+	case 86:
+		state = f("carved_pumpkin[facing=%s]", horizontal[data&3])
+	case 91:
+		state = f("jack_o_lantern[facing=%s]", horizontal[data&3])
+	case 92:
+		if data > 6 {
+			return "", false
+		}
+		state = f("cake[bites=%d]", data)
+	case 93, 94:
+		state = f("repeater[delay=%d,facing=%s,locked=false,powered=%t]", data>>2+1, horizontal[data&3], id == 94)
+	default:
+		return "", false
+	}
+	return "minecraft:" + state, true
+}
+
+// torchState returns the state of a torch of data value data, standing or on a
+// wall, with the further properties given.
+func torchState(standing, wall string, data byte, properties string) string {
+	if data >= 1 && data <= 4 {
+		return fmt.Sprintf("%s[facing=%s%s]", wall, torchFacing[data], properties)
+	}
+	if properties == "" {
+		return standing
+	}
+	return standing + "[" + properties[1:] + "]"
+}
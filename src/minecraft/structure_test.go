@@ -0,0 +1,239 @@
+package world
+
+import "minecraft/nbt"
+
+import "bytes"
+import "compress/gzip"
+import "sort"
+import "strings"
+import "testing"
+
+// readStructure decodes a structure file and checks it against the format's
+// schema, returning its compound.
+func readStructure(t *testing.T, buf *bytes.Buffer) map[string]interface{} {
+	gz, err := gzip.NewReader(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, s, err := nbt.ReadTagCompound(gz)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = getInt32(s, "DataVersion"); err != nil {
+		t.Error(err)
+	}
+	size := checkIntList(t, s["size"], "size", nil)
+	palette, err := getList(s, "palette")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, p := range palette {
+		state, ok := p.(map[string]interface{})
+		if !ok {
+			t.Fatalf("palette %d: expected a compound, got %v", i, p)
+		}
+		if name, err := getString(state, "Name"); err != nil || len(name) < 11 || name[:10] != "minecraft:" {
+			t.Errorf("palette %d: expected a namespaced Name, got %v", i, state["Name"])
+		}
+		if properties, ok := state["Properties"]; ok {
+			props, ok := properties.(map[string]interface{})
+			if !ok || len(props) == 0 {
+				t.Errorf("palette %d: expected Properties to be a compound, got %v", i, properties)
+			}
+			for name, value := range props {
+				if _, ok := value.(string); !ok {
+					t.Errorf("palette %d: expected property %s to be a string, got %v", i, name, value)
+				}
+			}
+		}
+	}
+	blocks, err := getList(s, "blocks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, b := range blocks {
+		block, ok := b.(map[string]interface{})
+		if !ok {
+			t.Fatalf("block %d: expected a compound, got %v", i, b)
+		}
+		checkIntList(t, block["pos"], "pos", size)
+		if state, err := getInt32(block, "state"); err != nil || state < 0 || int(state) >= len(palette) {
+			t.Errorf("block %d: expected a palette index, got %v", i, block["state"])
+		}
+		if te, ok := block["nbt"]; ok {
+			if _, ok := te.(map[string]interface{}); !ok {
+				t.Errorf("block %d: expected nbt to be a compound, got %v", i, te)
+			}
+		}
+	}
+	entities, err := getList(s, "entities")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, e := range entities {
+		entity, ok := e.(map[string]interface{})
+		if !ok {
+			t.Fatalf("entity %d: expected a compound, got %v", i, e)
+		}
+		if pos, err := getList(entity, "pos"); err != nil || len(pos) != 3 {
+			t.Errorf("entity %d: expected pos of 3 doubles, got %v", i, entity["pos"])
+		} else {
+			for _, v := range pos {
+				if _, ok := v.(float64); !ok {
+					t.Errorf("entity %d: expected pos of 3 doubles, got %v", i, pos)
+				}
+			}
+		}
+		checkIntList(t, entity["blockPos"], "blockPos", size)
+		if _, err := getCompound(entity, "nbt"); err != nil {
+			t.Errorf("entity %d: %s", i, err)
+		}
+	}
+	return s
+}
+
+// checkIntList checks that v is a list of 3 ints, each below the matching one of
+// bounds unless bounds is nil, and returns it.
+func checkIntList(t *testing.T, v interface{}, name string, bounds []int32) []int32 {
+	list, ok := v.([]interface{})
+	if !ok || len(list) != 3 {
+		t.Fatalf("expected %s to be a list of 3 ints, got %v", name, v)
+	}
+	ints := make([]int32, 3)
+	for i, n := range list {
+		if ints[i], ok = n.(int32); !ok {
+			t.Fatalf("expected %s to be a list of 3 ints, got %v", name, v)
+		}
+		if bounds != nil && (ints[i] < 0 || ints[i] >= bounds[i]) {
+			t.Errorf("%s %v lies outside the structure", name, ints)
+		}
+	}
+	return ints
+}
+
+// paletteState returns the state of the block at (x, y, z) in structure s as
+// BlockState writes it, or "" if there is no block there.
+func paletteState(s map[string]interface{}, x, y, z int32) string {
+	palette := s["palette"].([]interface{})
+	for _, b := range s["blocks"].([]interface{}) {
+		block := b.(map[string]interface{})
+		pos := block["pos"].([]interface{})
+		if pos[0] != x || pos[1] != y || pos[2] != z {
+			continue
+		}
+		state := palette[block["state"].(int32)].(map[string]interface{})
+		properties, _ := state["Properties"].(map[string]interface{})
+		if len(properties) == 0 {
+			return state["Name"].(string)
+		}
+		var names []string
+		for name := range properties {
+			names = append(names, name)
+		}
+		sort.SortStrings(names)
+		for i, name := range names {
+			names[i] = name + "=" + properties[name].(string)
+		}
+		return state["Name"].(string) + "[" + strings.Join(names, ",") + "]"
+	}
+	return ""
+}
+
+func TestExportStructure(t *testing.T) {
+	w := schematicWorld()
+	east := w.Chunks[MakeXZ(0, 0)]
+	east.SetBlock(0, 61, 1, 95, 0) // a locked chest, which newer versions lack
+	skeleton := mobFixture("Skeleton", map[string]interface{}{"Riding": mobFixture("Spider", nil)})
+	skeleton["Pos"] = []interface{}{float64(0.5), float64(61), float64(1.5)}
+	east.Level.Entities = append(east.Level.Entities, mustEntity(skeleton))
+
+	buf := new(bytes.Buffer)
+	report, err := w.ExportStructure(buf, 1, 62, 3, -2, 60, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := readStructure(t, buf)
+	if size := s["size"].([]interface{}); size[0] != int32(4) || size[1] != int32(3) || size[2] != int32(3) {
+		t.Fatal("expected a 4x3x3 structure, got ", size)
+	}
+	if n := len(s["blocks"].([]interface{})); n != 35 {
+		t.Errorf("expected 35 blocks, all but the locked chest, got %d", n)
+	}
+	if report.Blocks[95] != 1 || len(report.Blocks) != 1 {
+		t.Error("expected the locked chest to be reported, got ", report.Blocks)
+	}
+	if len(report.Entities) != 0 {
+		t.Error("expected every entity to be translated, got ", report.Entities)
+	}
+	if got := paletteState(s, 0, 0, 0); got != "minecraft:stone" {
+		t.Error("expected stone at the minimum corner, got ", got)
+	}
+	if got := paletteState(s, 3, 2, 2); got != "minecraft:red_wool" {
+		t.Error("expected red wool at the maximum corner, got ", got)
+	}
+	if got := paletteState(s, 0, 1, 1); got != "minecraft:chest[facing=north,type=single,waterlogged=false]" {
+		t.Error("expected a chest, got ", got)
+	}
+	if got := paletteState(s, 2, 1, 0); got != "" {
+		t.Error("expected no block for the locked chest, got ", got)
+	}
+
+	var chest map[string]interface{}
+	for _, b := range s["blocks"].([]interface{}) {
+		if te, ok := b.(map[string]interface{})["nbt"].(map[string]interface{}); ok {
+			chest = te
+		}
+	}
+	if chest == nil || chest["id"] != "minecraft:chest" || chest["x"] != nil {
+		t.Error("expected the chest's tile entity without coordinates, got ", chest)
+	}
+
+	entities := s["entities"].([]interface{})
+	if len(entities) != 3 {
+		t.Fatal("expected the 2 drops and the spider inside the box, got ", len(entities))
+	}
+	spider := entities[2].(map[string]interface{})["nbt"].(map[string]interface{})
+	if spider["id"] != "minecraft:spider" {
+		t.Fatal("expected the skeleton's vehicle, got ", spider["id"])
+	}
+	passengers, _ := spider["Passengers"].([]interface{})
+	if len(passengers) != 1 || passengers[0].(map[string]interface{})["id"] != "minecraft:skeleton" {
+		t.Error("expected the skeleton as the spider's passenger, got ", passengers)
+	}
+	if pos := entities[2].(map[string]interface{})["blockPos"].([]interface{}); pos[0] != int32(2) || pos[1] != int32(1) || pos[2] != int32(0) {
+		t.Error("expected the spider at block (2, 1, 0) of the structure, got ", pos)
+	}
+
+	if _, err = w.ExportStructure(new(bytes.Buffer), 0, 0, 0, StructureMaxSize, 0, 0); err == nil {
+		t.Error("expected an error for a box too big for a structure")
+	}
+}
+
+func TestBlockState(t *testing.T) {
+	for _, test := range []struct {
+		id, data byte
+		want     string
+	}{
+		{BlockAir, 0, "minecraft:air"},
+		{BlockWool, 14, "minecraft:red_wool"},
+		{BlockTorch, 3, "minecraft:wall_torch[facing=south]"},
+		{BlockTorch, 5, "minecraft:torch"},
+		{76, 5, "minecraft:redstone_torch[lit=true]"},
+		{BlockWallSign, 5, "minecraft:wall_sign[facing=east,waterlogged=false]"},
+		{64, 8 | 4 | 1, "minecraft:oak_door[facing=south,half=upper,hinge=left,open=true,powered=false]"},
+		{44, 8 | 3, "minecraft:cobblestone_slab[type=top,waterlogged=false]"},
+		{93, 4 | 2, "minecraft:repeater[delay=2,facing=north,locked=false,powered=false]"},
+	} {
+		if got, ok := BlockState(test.id, test.data); !ok || got != test.want {
+			t.Errorf("%d:%d: expected %s, got %s", test.id, test.data, test.want, got)
+		}
+	}
+	for _, id := range []byte{95, 96, 200} {
+		if state, ok := BlockState(id, 0); ok {
+			t.Errorf("expected no state for block %d, got %s", id, state)
+		}
+	}
+	if c := stateCompound("minecraft:wheat[age=7]"); c["Name"] != "minecraft:wheat" || c["Properties"].(map[string]interface{})["age"] != "7" {
+		t.Error("expected wheat of age 7, got ", c)
+	}
+}
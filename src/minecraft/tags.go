@@ -0,0 +1,119 @@
+package world
+
+import "minecraft/error"
+
+import "fmt"
+import "os"
+
+// Checked accessors for decoded NBT compounds.  They fail with a descriptive error
+// instead of panicking when a tag is missing or has the wrong type.
+
+func tagError(name string, want string, got interface{}) os.Error {
+	if got == nil {
+		return error.NewError(fmt.Sprintf("missing tag %q", name), nil)
+	}
+	return error.NewError(fmt.Sprintf("tag %q: expected %s, got %T", name, want, got), nil)
+}
+
+func getInt8(c map[string]interface{}, name string) (v int8, err os.Error) {
+	v, ok := c[name].(int8)
+	if !ok {
+		err = tagError(name, "byte", c[name])
+	}
+	return
+}
+
+func getInt16(c map[string]interface{}, name string) (v int16, err os.Error) {
+	v, ok := c[name].(int16)
+	if !ok {
+		err = tagError(name, "short", c[name])
+	}
+	return
+}
+
+func getInt32(c map[string]interface{}, name string) (v int32, err os.Error) {
+	v, ok := c[name].(int32)
+	if !ok {
+		err = tagError(name, "int", c[name])
+	}
+	return
+}
+
+func getInt64(c map[string]interface{}, name string) (v int64, err os.Error) {
+	v, ok := c[name].(int64)
+	if !ok {
+		err = tagError(name, "long", c[name])
+	}
+	return
+}
+
+func getFloat32(c map[string]interface{}, name string) (v float32, err os.Error) {
+	v, ok := c[name].(float32)
+	if !ok {
+		err = tagError(name, "float", c[name])
+	}
+	return
+}
+
+func getFloat64(c map[string]interface{}, name string) (v float64, err os.Error) {
+	v, ok := c[name].(float64)
+	if !ok {
+		err = tagError(name, "double", c[name])
+	}
+	return
+}
+
+func getString(c map[string]interface{}, name string) (v string, err os.Error) {
+	v, ok := c[name].(string)
+	if !ok {
+		err = tagError(name, "string", c[name])
+	}
+	return
+}
+
+func getList(c map[string]interface{}, name string) (v []interface{}, err os.Error) {
+	v, ok := c[name].([]interface{})
+	if !ok {
+		err = tagError(name, "list", c[name])
+	}
+	return
+}
+
+func getByteArray(c map[string]interface{}, name string) (v []byte, err os.Error) {
+	v, ok := c[name].([]byte)
+	if !ok {
+		err = tagError(name, "byte array", c[name])
+	}
+	return
+}
+
+func getCompound(c map[string]interface{}, name string) (v map[string]interface{}, err os.Error) {
+	v, ok := c[name].(map[string]interface{})
+	if !ok {
+		err = tagError(name, "compound", c[name])
+	}
+	return
+}
+
+// Optional accessors return nil when a tag is missing or has the wrong type.
+
+func optInt8(c map[string]interface{}, name string) *int8 {
+	if v, ok := c[name].(int8); ok {
+		return &v
+	}
+	return nil
+}
+
+func optInt16(c map[string]interface{}, name string) *int16 {
+	if v, ok := c[name].(int16); ok {
+		return &v
+	}
+	return nil
+}
+
+func optInt32(c map[string]interface{}, name string) *int32 {
+	if v, ok := c[name].(int32); ok {
+		return &v
+	}
+	return nil
+}
@@ -0,0 +1,162 @@
+package world
+
+import "minecraft/error"
+
+import "bytes"
+import "fmt"
+import "image"
+import "image/png"
+import "io/ioutil"
+import "os"
+import "path"
+import "sort"
+import "strings"
+import "time"
+
+// ThumbnailSize is the default size of a chunk's thumbnail: a pixel per column.
+const ThumbnailSize = ChunkWidth
+
+// ThumbnailCache keeps the thumbnails ChunkThumbnail renders as PNG files in a
+// directory, each named for its chunk, its size and the time the chunk was last
+// written, so that a chunk written since is rendered again.  Chunks with unsaved
+// changes are rendered every time and not kept.
+type ThumbnailCache struct {
+	// Dir is the directory the thumbnails are kept in, created if need be.
+	Dir string
+	// MaxEntries, if positive, bounds how many thumbnails are kept; the least
+	// recently used are removed to make room.
+	MaxEntries int
+	// Hits and Renders count the thumbnails read from the cache and rendered.
+	Hits, Renders int
+}
+
+// ChunkThumbnail returns chunk (x, z) drawn from above as RenderMap draws it, as
+// a size by size image; a size of zero means ThumbnailSize.  Sizes other than
+// ThumbnailSize sample the nearest column.  If world.Thumbnails is set the image
+// is read from the cache when it is current and kept there when it is not.  A
+// chunk that is not resident is read and not kept.
+func (world *World) ChunkThumbnail(x, z int32, size int) (image.Image, os.Error) {
+	if size < 0 {
+		return nil, error.NewError(fmt.Sprintf("cannot draw a thumbnail of size %d", size), nil)
+	}
+	if size == 0 {
+		size = ThumbnailSize
+	}
+	if !world.ChunkExists(x, z) {
+		return nil, error.InChunk(x, z).Error("missing chunk", nil)
+	}
+	cache := world.Thumbnails
+	if cache == nil {
+		return world.renderThumbnail(x, z, size)
+	}
+	stamp, err := world.chunkStamp(x, z)
+	if err != nil {
+		return nil, err
+	}
+	if stamp.mtime < 0 {
+		cache.Renders++
+		return world.renderThumbnail(x, z, size)
+	}
+	file := path.Join(cache.Dir, fmt.Sprintf("%d.%d.%d.%d.png", x, z, size, stamp.mtime))
+	if m, err := cache.read(file); m != nil || err != nil {
+		return m, err
+	}
+	cache.Renders++
+	m, err := world.renderThumbnail(x, z, size)
+	if err != nil {
+		return nil, err
+	}
+	if err = cache.write(file, fmt.Sprintf("%d.%d.%d.", x, z, size), m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// renderThumbnail draws the thumbnail of chunk (x, z).
+func (world *World) renderThumbnail(x, z int32, size int) (image.Image, os.Error) {
+	c, err := world.peekChunk(x, z)
+	if err != nil {
+		return nil, err
+	}
+	m := image.NewNRGBA(size, size)
+	for py := 0; py < size; py++ {
+		for px := 0; px < size; px++ {
+			bx, bz := int32(px*ChunkWidth/size), int32(py*ChunkDepth/size)
+			m.SetNRGBA(px, py, topColor(c, bx, bz, 0, ChunkHeight-1, defaultColors))
+		}
+	}
+	return m, nil
+}
+
+// read returns the thumbnail in file, marking it used, or nil if there is none.
+func (cache *ThumbnailCache) read(file string) (image.Image, os.Error) {
+	f, err := os.Open(file, os.O_RDONLY, 0)
+	if err != nil {
+		if e, ok := err.(*os.PathError); ok && e.Error == os.ENOENT {
+			return nil, nil
+		}
+		return nil, error.NewError("could not open thumbnail "+file, err)
+	}
+	defer f.Close()
+	m, err := png.Decode(f)
+	if err != nil {
+		return nil, error.NewError("could not decode thumbnail "+file, err)
+	}
+	now := time.Nanoseconds()
+	if err = os.Chtimes(file, now, now); err != nil {
+		return nil, error.NewError("could not mark thumbnail "+file, err)
+	}
+	cache.Hits++
+	return m, nil
+}
+
+// write keeps m in file, removing the other thumbnails whose names start with
+// prefix, which are stale, and the least recently used if there are too many.
+func (cache *ThumbnailCache) write(file, prefix string, m image.Image) os.Error {
+	buf := new(bytes.Buffer)
+	if err := png.Encode(buf, m); err != nil {
+		return error.NewError("could not encode thumbnail "+file, err)
+	}
+	if err := os.MkdirAll(cache.Dir, 0755); err != nil {
+		return error.NewError("could not create thumbnail directory", err)
+	}
+	if err := ioutil.WriteFile(file, buf.Bytes(), 0644); err != nil {
+		return error.NewError("could not write thumbnail "+file, err)
+	}
+	files, err := ioutil.ReadDir(cache.Dir)
+	if err != nil {
+		return error.NewError("could not list thumbnails", err)
+	}
+	var kept thumbnailsByUse
+	for _, fi := range files {
+		switch {
+		case !fi.IsRegular() || !strings.HasSuffix(fi.Name, ".png"):
+		case strings.HasPrefix(fi.Name, prefix) && fi.Name != path.Base(file):
+			if err = os.Remove(path.Join(cache.Dir, fi.Name)); err != nil {
+				return error.NewError("could not remove stale thumbnail "+fi.Name, err)
+			}
+		default:
+			kept = append(kept, fi)
+		}
+	}
+	if cache.MaxEntries <= 0 || len(kept) <= cache.MaxEntries {
+		return nil
+	}
+	sort.Sort(kept)
+	for _, fi := range kept[:len(kept)-cache.MaxEntries] {
+		if fi.Name == path.Base(file) {
+			continue
+		}
+		if err = os.Remove(path.Join(cache.Dir, fi.Name)); err != nil {
+			return error.NewError("could not remove thumbnail "+fi.Name, err)
+		}
+	}
+	return nil
+}
+
+// thumbnailsByUse sorts thumbnails from the least recently used.
+type thumbnailsByUse []*os.FileInfo
+
+func (s thumbnailsByUse) Len() int           { return len(s) }
+func (s thumbnailsByUse) Less(i, j int) bool { return s[i].Mtime_ns < s[j].Mtime_ns }
+func (s thumbnailsByUse) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
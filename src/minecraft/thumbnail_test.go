@@ -0,0 +1,151 @@
+package world
+
+import "io/ioutil"
+import "os"
+import "testing"
+
+// openThumbnailWorld opens a world of chunks (0, 0), with a stone floor at y 10
+// and a column of gold at (3, 20, 5), and (1, 0), caching thumbnails in a
+// directory of their own.
+func openThumbnailWorld(t *testing.T) (*World, []string) {
+	c := newChunk(0, 0)
+	for x := int32(0); x < ChunkWidth; x++ {
+		for z := int32(0); z < ChunkDepth; z++ {
+			c.SetBlock(x, 10, z, BlockStone, 0)
+		}
+	}
+	c.SetBlock(3, 20, 5, 41, 0)
+	dir := makeTestWorld(t, mustFromChunk(c), testChunkPayload(1, 0, nil, nil))
+	cacheDir, err := ioutil.TempDir("", "thumbnails")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Thumbnails = &ThumbnailCache{Dir: cacheDir}
+	return w, []string{dir, cacheDir}
+}
+
+func cachedThumbnails(t *testing.T, cache *ThumbnailCache) int {
+	files, err := ioutil.ReadDir(cache.Dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return len(files)
+}
+
+func TestChunkThumbnail(t *testing.T) {
+	w, dirs := openThumbnailWorld(t)
+	for _, dir := range dirs {
+		defer os.RemoveAll(dir)
+	}
+	defer w.Close()
+	w.Thumbnails = nil
+
+	m, err := w.ChunkThumbnail(0, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b := m.Bounds(); b.Dx() != ThumbnailSize || b.Dy() != ThumbnailSize {
+		t.Fatal("expected a 16 by 16 thumbnail, got ", b)
+	}
+	if !sameColor(m.At(3, 5), blockColors[41]) || !sameColor(m.At(4, 5), blockColors[BlockStone]) {
+		t.Errorf("expected gold at (3, 5) on stone, got %v and %v", m.At(3, 5), m.At(4, 5))
+	}
+	if m, err = w.ChunkThumbnail(0, 0, 64); err != nil {
+		t.Fatal(err)
+	}
+	if b := m.Bounds(); b.Dx() != 64 || !sameColor(m.At(15, 23), blockColors[41]) || !sameColor(m.At(16, 23), blockColors[BlockStone]) {
+		t.Error("expected gold scaled to 4 by 4 pixels")
+	}
+	if _, err = w.ChunkThumbnail(2, 0, 0); err == nil {
+		t.Error("expected an error for a missing chunk")
+	}
+	if len(w.Chunks) != 0 {
+		t.Error("expected no chunks to be made resident, got ", len(w.Chunks))
+	}
+}
+
+func TestChunkThumbnailCache(t *testing.T) {
+	w, dirs := openThumbnailWorld(t)
+	for _, dir := range dirs {
+		defer os.RemoveAll(dir)
+	}
+	defer w.Close()
+	cache := w.Thumbnails
+
+	first, err := w.ChunkThumbnail(0, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := w.ChunkThumbnail(0, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cache.Renders != 1 || cache.Hits != 1 {
+		t.Errorf("expected 1 render and 1 hit, got %d and %d", cache.Renders, cache.Hits)
+	}
+	if !sameColor(first.At(3, 5), second.At(3, 5)) {
+		t.Error("expected the cached thumbnail to match the rendered one")
+	}
+
+	// writing the chunk again makes its thumbnail stale
+	mtime, err := w.ChunkModTime(0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = os.Chtimes(chunkPath(w.dir, 0, 0), mtime+1e9, mtime+1e9); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = w.ChunkThumbnail(0, 0, 0); err != nil {
+		t.Fatal(err)
+	}
+	if cache.Renders != 2 || cachedThumbnails(t, cache) != 1 {
+		t.Errorf("expected a second render replacing the first, got %d renders and %d files", cache.Renders, cachedThumbnails(t, cache))
+	}
+
+	// unsaved changes are rendered every time
+	c, err := w.GetChunk(0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.SetBlock(3, 20, 5, BlockAir, 0)
+	for i := 0; i < 2; i++ {
+		m, err := w.ChunkThumbnail(0, 0, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !sameColor(m.At(3, 5), blockColors[BlockStone]) {
+			t.Error("expected the unsaved change drawn, got ", m.At(3, 5))
+		}
+	}
+	if cache.Renders != 4 || cache.Hits != 1 {
+		t.Errorf("expected 4 renders and 1 hit, got %d and %d", cache.Renders, cache.Hits)
+	}
+}
+
+func TestChunkThumbnailEviction(t *testing.T) {
+	w, dirs := openThumbnailWorld(t)
+	for _, dir := range dirs {
+		defer os.RemoveAll(dir)
+	}
+	defer w.Close()
+	w.Thumbnails.MaxEntries = 2
+
+	for _, xz := range [][3]int32{{0, 0, 16}, {1, 0, 16}, {0, 0, 32}} {
+		if _, err := w.ChunkThumbnail(xz[0], xz[1], int(xz[2])); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := cachedThumbnails(t, w.Thumbnails); n != 2 {
+		t.Error("expected 2 thumbnails kept, got ", n)
+	}
+	if _, err := w.ChunkThumbnail(0, 0, 32); err != nil {
+		t.Fatal(err)
+	}
+	if w.Thumbnails.Hits != 1 {
+		t.Error("expected the newest thumbnail to be kept")
+	}
+}
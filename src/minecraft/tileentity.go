@@ -0,0 +1,364 @@
+package world
+
+import "minecraft/error"
+
+import "fmt"
+import "os"
+
+// see: http://www.minecraftwiki.net/wiki/Alpha_Level_Format#Tile_Entity_Format
+
+// A TileEntity is the extra state attached to a block such as a chest or a sign.
+// The concrete type is one of *Chest, *Furnace, *Sign, *MobSpawner or, for ids we
+// do not model, *GenericTileEntity.
+type TileEntity interface {
+	Id() string
+	X() int32
+	Y() int32
+	Z() int32
+
+	// toCompound encodes the tile entity the way the game stores it.
+	toCompound() map[string]interface{}
+	tileEntityBase() *TileEntityBase
+}
+
+// TileEntityBase holds the tags common to every tile entity: its id and the
+// absolute coordinates of its block.  Extra holds the tags a typed tile entity
+// does not model, which are written back untouched.
+type TileEntityBase struct {
+	id      string
+	x, y, z int32
+	Extra   map[string]interface{}
+
+	// the chunk holding the tile entity, dirtied by edits made through methods
+	chunk *Chunk
+}
+
+var tileEntityTags = []string{"id", "x", "y", "z"}
+
+// typedTileEntityTags lists, by id, the further tags each typed tile entity models.
+var typedTileEntityTags = map[string][]string{
+	"Chest":      []string{"Items"},
+	"Furnace":    []string{"BurnTime", "CookTime", "Items"},
+	"Sign":       []string{"Text1", "Text2", "Text3", "Text4"},
+	"MobSpawner": []string{"EntityId", "Delay"},
+}
+
+func (te *TileEntityBase) Id() string {
+	return te.id
+}
+
+func (te *TileEntityBase) X() int32 {
+	return te.x
+}
+
+func (te *TileEntityBase) Y() int32 {
+	return te.y
+}
+
+func (te *TileEntityBase) Z() int32 {
+	return te.z
+}
+
+func (te *TileEntityBase) tileEntityBase() *TileEntityBase {
+	return te
+}
+
+// local returns the tile entity's coordinates within chunk c.
+func (te *TileEntityBase) local(c *Chunk) (lx, y, lz int32) {
+	return te.x - c.Level.XPos*ChunkWidth, te.y, te.z - c.Level.ZPos*ChunkDepth
+}
+
+// touch marks the owning chunk dirty, if there is one.
+func (te *TileEntityBase) touch() {
+	if te.chunk != nil {
+		te.chunk.dirty = true
+	}
+}
+
+// InventorySlot is an item stack in a numbered slot of a container.
+type InventorySlot struct {
+	Slot int8
+	Item Item
+}
+
+// Chest holds its occupied slots, ordered by slot number; see chest.go for
+// methods that keep them that way.
+type Chest struct {
+	TileEntityBase
+	Slots []InventorySlot
+}
+
+// Furnace holds its occupied slots like a chest; see furnace.go for what each
+// slot is for.
+type Furnace struct {
+	TileEntityBase
+	BurnTime int16
+	CookTime int16
+	Slots    []InventorySlot
+}
+
+type Sign struct {
+	TileEntityBase
+	Text1, Text2, Text3, Text4 string
+}
+
+type MobSpawner struct {
+	TileEntityBase
+	EntityId string
+	Delay    int16
+}
+
+// GenericTileEntity carries a tile entity we have no type for, such as a
+// Dispenser or a mod's block, or one that failed to decode, as its raw compound.
+// Raw is written back exactly as it was read, and FindOrphanedTileEntities leaves
+// kinds it cannot check alone.
+type GenericTileEntity struct {
+	TileEntityBase
+	Raw map[string]interface{}
+}
+
+// newTileEntity returns an empty tile entity with the given id, as the game makes
+// one when its block is placed.
+func newTileEntity(id string, x, y, z int32) TileEntity {
+	base := TileEntityBase{id: id, x: x, y: y, z: z}
+	switch id {
+	case "Chest":
+		return &Chest{TileEntityBase: base}
+	case "Furnace":
+		return &Furnace{TileEntityBase: base}
+	case "Sign":
+		return &Sign{TileEntityBase: base}
+	case "MobSpawner":
+		return &MobSpawner{base, "Pig", 20}
+	}
+	return &GenericTileEntity{base, base.baseCompound()}
+}
+
+// toTileEntityList decodes every tile entity in payload.  A compound that cannot be
+// decoded is kept as a *GenericTileEntity and reported in errs instead of failing
+// the whole list; anything that is not a compound at all is reported and dropped.
+func toTileEntityList(payload []interface{}) (tes []TileEntity, errs []os.Error) {
+	tes = make([]TileEntity, 0, len(payload))
+	for i, p := range payload {
+		raw, ok := p.(map[string]interface{})
+		if !ok {
+			errs = append(errs, error.NewError(fmt.Sprintf("tile entity %d: expected compound, got %T", i, p), nil))
+			continue
+		}
+		te, err := toTileEntity(raw)
+		if err != nil {
+			errs = append(errs, error.NewError(fmt.Sprintf("could not decode tile entity %d", i), err))
+			te = toGenericTileEntity(raw)
+		}
+		tes = append(tes, te)
+	}
+	return
+}
+
+func toTileEntity(payload map[string]interface{}) (te TileEntity, err os.Error) {
+	var base TileEntityBase
+	if base, err = toTileEntityBase(payload); err != nil {
+		return
+	}
+	if typed, ok := typedTileEntityTags[base.id]; ok {
+		base.Extra = unknownTags(payload, tileEntityTags, typed)
+	}
+	switch base.id {
+	case "Chest":
+		chest := &Chest{TileEntityBase: base}
+		if chest.Slots, err = toInventory(payload); err != nil {
+			return
+		}
+		te = chest
+	case "Furnace":
+		furnace := &Furnace{TileEntityBase: base}
+		if furnace.BurnTime, err = getInt16(payload, "BurnTime"); err != nil {
+			return
+		}
+		if furnace.CookTime, err = getInt16(payload, "CookTime"); err != nil {
+			return
+		}
+		if furnace.Slots, err = toInventory(payload); err != nil {
+			return
+		}
+		te = furnace
+	case "Sign":
+		sign := &Sign{TileEntityBase: base}
+		if sign.Text1, err = getString(payload, "Text1"); err != nil {
+			return
+		}
+		if sign.Text2, err = getString(payload, "Text2"); err != nil {
+			return
+		}
+		if sign.Text3, err = getString(payload, "Text3"); err != nil {
+			return
+		}
+		if sign.Text4, err = getString(payload, "Text4"); err != nil {
+			return
+		}
+		te = sign
+	case "MobSpawner":
+		spawner := &MobSpawner{TileEntityBase: base}
+		if spawner.EntityId, err = getString(payload, "EntityId"); err != nil {
+			return
+		}
+		if spawner.Delay, err = getInt16(payload, "Delay"); err != nil {
+			return
+		}
+		te = spawner
+	default:
+		te = &GenericTileEntity{base, payload}
+	}
+	return
+}
+
+func toTileEntityBase(payload map[string]interface{}) (base TileEntityBase, err os.Error) {
+	if base.id, err = getString(payload, "id"); err != nil {
+		return
+	}
+	if base.x, err = getInt32(payload, "x"); err != nil {
+		return
+	}
+	if base.y, err = getInt32(payload, "y"); err != nil {
+		return
+	}
+	if base.z, err = getInt32(payload, "z"); err != nil {
+		return
+	}
+	return
+}
+
+// toGenericTileEntity salvages whatever common tags it can from a compound that
+// failed to decode.
+func toGenericTileEntity(payload map[string]interface{}) *GenericTileEntity {
+	te := &GenericTileEntity{Raw: payload}
+	te.id, _ = payload["id"].(string)
+	te.x, _ = payload["x"].(int32)
+	te.y, _ = payload["y"].(int32)
+	te.z, _ = payload["z"].(int32)
+	return te
+}
+
+var slotTags = []string{"Slot", "id", "Count", "Damage"}
+
+// toInventory decodes the Items list of a container.
+func toInventory(payload map[string]interface{}) (slots []InventorySlot, err os.Error) {
+	items, err := getList(payload, "Items")
+	if err != nil {
+		return
+	}
+	return toSlots(items)
+}
+
+// toSlots decodes a list of item stacks tagged with their slot numbers.
+func toSlots(items []interface{}) (slots []InventorySlot, err os.Error) {
+	slots = make([]InventorySlot, len(items))
+	for i, it := range items {
+		itm, ok := it.(map[string]interface{})
+		if !ok {
+			err = error.NewError(fmt.Sprintf("item %d: expected compound, got %T", i, it), nil)
+			return
+		}
+		if slots[i].Slot, err = getInt8(itm, "Slot"); err != nil {
+			return
+		}
+		if slots[i].Item, err = toItemExcept(itm, slotTags); err != nil {
+			return
+		}
+	}
+	return
+}
+
+var itemTags = []string{"id", "Count", "Damage"}
+
+func toItem(payload map[string]interface{}) (item Item, err os.Error) {
+	return toItemExcept(payload, itemTags)
+}
+
+// toItemExcept decodes an item stack, keeping every tag not named in known in
+// item.Extra.
+func toItemExcept(payload map[string]interface{}, known []string) (item Item, err os.Error) {
+	if item.Id, err = getInt16(payload, "id"); err != nil {
+		return
+	}
+	if item.Count, err = getInt8(payload, "Count"); err != nil {
+		return
+	}
+	if item.Damage, err = getInt16(payload, "Damage"); err != nil {
+		return
+	}
+	item.Extra = unknownTags(payload, known)
+	return
+}
+
+// baseCompound starts the encoding of a typed tile entity: its Extra tags, then
+// the common tags.
+func (te *TileEntityBase) baseCompound() map[string]interface{} {
+	c := copyTags(te.Extra)
+	c["id"] = te.id
+	c["x"] = te.x
+	c["y"] = te.y
+	c["z"] = te.z
+	return c
+}
+
+func (chest *Chest) toCompound() map[string]interface{} {
+	c := chest.baseCompound()
+	c["Items"] = fromInventory(chest.Slots)
+	return c
+}
+
+func (furnace *Furnace) toCompound() map[string]interface{} {
+	c := furnace.baseCompound()
+	c["BurnTime"] = furnace.BurnTime
+	c["CookTime"] = furnace.CookTime
+	c["Items"] = fromInventory(furnace.Slots)
+	return c
+}
+
+func (sign *Sign) toCompound() map[string]interface{} {
+	c := sign.baseCompound()
+	c["Text1"] = sign.Text1
+	c["Text2"] = sign.Text2
+	c["Text3"] = sign.Text3
+	c["Text4"] = sign.Text4
+	return c
+}
+
+func (spawner *MobSpawner) toCompound() map[string]interface{} {
+	c := spawner.baseCompound()
+	c["EntityId"] = spawner.EntityId
+	c["Delay"] = spawner.Delay
+	return c
+}
+
+// toCompound returns the preserved compound untouched.
+func (te *GenericTileEntity) toCompound() map[string]interface{} {
+	return te.Raw
+}
+
+func fromTileEntityList(tes []TileEntity) []interface{} {
+	payload := make([]interface{}, len(tes))
+	for i, te := range tes {
+		payload[i] = te.toCompound()
+	}
+	return payload
+}
+
+func fromInventory(slots []InventorySlot) []interface{} {
+	items := make([]interface{}, len(slots))
+	for i, slot := range slots {
+		itm := fromItem(slot.Item)
+		itm["Slot"] = slot.Slot
+		items[i] = itm
+	}
+	return items
+}
+
+func fromItem(item Item) map[string]interface{} {
+	c := copyTags(item.Extra)
+	c["id"] = item.Id
+	c["Count"] = item.Count
+	c["Damage"] = item.Damage
+	return c
+}
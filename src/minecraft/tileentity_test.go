@@ -0,0 +1,247 @@
+package world
+
+import "minecraft/nbt"
+
+import "bytes"
+import "os"
+import "reflect"
+import "testing"
+
+func itemCompound(slot int8, id int16, count int8, damage int16) map[string]interface{} {
+	return map[string]interface{}{
+		"Slot":   slot,
+		"id":     id,
+		"Count":  count,
+		"Damage": damage,
+	}
+}
+
+func tileEntityCompound(id string, x, y, z int32, tags map[string]interface{}) map[string]interface{} {
+	c := map[string]interface{}{
+		"id": id,
+		"x":  x,
+		"y":  y,
+		"z":  z,
+	}
+	for k, v := range tags {
+		c[k] = v
+	}
+	return c
+}
+
+var chestFixture = tileEntityCompound("Chest", 10, 64, -20, map[string]interface{}{
+	"Items": []interface{}{
+		itemCompound(0, 4, 64, 0),
+		itemCompound(13, 264, 3, 0),
+		itemCompound(26, 256, 1, 17),
+	},
+})
+
+var furnaceFixture = tileEntityCompound("Furnace", 11, 64, -20, map[string]interface{}{
+	"BurnTime": int16(200),
+	"CookTime": int16(50),
+	"Items": []interface{}{
+		itemCompound(0, 15, 8, 0),
+		itemCompound(1, 263, 2, 0),
+	},
+})
+
+var signFixture = tileEntityCompound("Sign", 12, 65, -20, map[string]interface{}{
+	"Text1": "Welcome",
+	"Text2": "to",
+	"Text3": "Zombo",
+	"Text4": "com",
+})
+
+var spawnerFixture = tileEntityCompound("MobSpawner", -3, 20, 7, map[string]interface{}{
+	"EntityId": "Pig",
+	"Delay":    int16(20),
+})
+
+func TestToTileEntityList(t *testing.T) {
+	unknown := tileEntityCompound("Dispenser", 1, 2, 3, map[string]interface{}{"Items": []interface{}{}})
+	tes, errs := toTileEntityList([]interface{}{chestFixture, furnaceFixture, signFixture, spawnerFixture, unknown})
+	if len(errs) != 0 {
+		t.Fatal(errs)
+	}
+	if len(tes) != 5 {
+		t.Fatal("expected 5 tile entities, got ", len(tes))
+	}
+
+	chest, ok := tes[0].(*Chest)
+	if !ok {
+		t.Fatalf("expected *Chest, got %T", tes[0])
+	}
+	if chest.Id() != "Chest" || chest.X() != 10 || chest.Y() != 64 || chest.Z() != -20 {
+		t.Errorf("chest has id %q at (%d, %d, %d)", chest.Id(), chest.X(), chest.Y(), chest.Z())
+	}
+	expectedItems := []InventorySlot{
+		{0, Item{Id: 4, Count: 64}},
+		{13, Item{Id: 264, Count: 3}},
+		{26, Item{Id: 256, Count: 1, Damage: 17}},
+	}
+	if !reflect.DeepEqual(chest.Slots, expectedItems) {
+		t.Error("expected ", expectedItems, ", got ", chest.Slots)
+	}
+
+	furnace, ok := tes[1].(*Furnace)
+	if !ok {
+		t.Fatalf("expected *Furnace, got %T", tes[1])
+	}
+	if furnace.BurnTime != 200 || furnace.CookTime != 50 || len(furnace.Slots) != 2 {
+		t.Error("bad furnace ", furnace)
+	}
+
+	sign, ok := tes[2].(*Sign)
+	if !ok {
+		t.Fatalf("expected *Sign, got %T", tes[2])
+	}
+	if sign.Text1 != "Welcome" || sign.Text2 != "to" || sign.Text3 != "Zombo" || sign.Text4 != "com" {
+		t.Error("bad sign ", sign)
+	}
+
+	spawner, ok := tes[3].(*MobSpawner)
+	if !ok {
+		t.Fatalf("expected *MobSpawner, got %T", tes[3])
+	}
+	if spawner.EntityId != "Pig" || spawner.Delay != 20 || spawner.X() != -3 {
+		t.Error("bad spawner ", spawner)
+	}
+
+	generic, ok := tes[4].(*GenericTileEntity)
+	if !ok {
+		t.Fatalf("expected *GenericTileEntity, got %T", tes[4])
+	}
+	if generic.Id() != "Dispenser" || !reflect.DeepEqual(generic.Raw, unknown) {
+		t.Error("bad generic tile entity ", generic)
+	}
+}
+
+func TestMalformedTileEntity(t *testing.T) {
+	broken := tileEntityCompound("Sign", 5, 6, 7, map[string]interface{}{
+		"Text1": "only",
+		"Text2": int16(2),
+	})
+	tes, errs := toTileEntityList([]interface{}{broken, signFixture})
+	if len(errs) != 1 {
+		t.Fatal("expected exactly one error, got ", errs)
+	}
+	generic, ok := tes[0].(*GenericTileEntity)
+	if !ok {
+		t.Fatalf("expected the malformed sign to be kept as *GenericTileEntity, got %T", tes[0])
+	}
+	if generic.Id() != "Sign" || generic.X() != 5 || generic.Y() != 6 || generic.Z() != 7 {
+		t.Error("salvaged tags are wrong: ", generic)
+	}
+	if _, ok := tes[1].(*Sign); !ok {
+		t.Errorf("the well-formed sign should still decode, got %T", tes[1])
+	}
+}
+
+func TestToChunkTileEntities(t *testing.T) {
+	payload := testChunkPayload(0, 0, nil, []interface{}{chestFixture, "garbage"})
+	c := mustChunk(payload)
+	if len(c.Level.TileEntities) != 1 {
+		t.Fatal("expected 1 tile entity, got ", len(c.Level.TileEntities))
+	}
+	if len(c.Warnings) != 1 {
+		t.Error("expected 1 warning, got ", c.Warnings)
+	}
+}
+
+// roundTrip encodes a tile entity, writes and rereads it as NBT, and decodes it
+// again, returning the reread compound.
+func roundTripTileEntity(t *testing.T, payload map[string]interface{}) map[string]interface{} {
+	te, err := toTileEntity(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err = nbt.WriteTagCompound(&buf, "", te.toCompound()); err != nil {
+		t.Fatal(err)
+	}
+	_, reread, err := nbt.ReadTagCompound(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, err := toTileEntity(reread)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(te, again) {
+		t.Error("expected ", te, ", got ", again)
+	}
+	return reread
+}
+
+func TestChestRoundTrip(t *testing.T) {
+	items := make([]interface{}, 27)
+	for i := range items {
+		items[i] = itemCompound(int8(i), int16(256+i), int8(i+1), int16(i*3))
+	}
+	chest := tileEntityCompound("Chest", -100, 12, 3000, map[string]interface{}{"Items": items})
+	if reread := roundTripTileEntity(t, chest); !nbt.Equal(reread, chest) {
+		t.Error("expected ", chest, ", got ", reread)
+	}
+}
+
+func TestSignRoundTrip(t *testing.T) {
+	sign := tileEntityCompound("Sign", 1, 2, 3, map[string]interface{}{
+		"Text1": "Ünïcødé",
+		"Text2": "☃ snowman",
+		"Text3": "",
+		"Text4": "日本語",
+	})
+	if reread := roundTripTileEntity(t, sign); !nbt.Equal(reread, sign) {
+		t.Error("expected ", sign, ", got ", reread)
+	}
+}
+
+func TestModdedTileEntityRoundTrip(t *testing.T) {
+	modded := tileEntityCompound("widget_machine", 7, 8, 9, map[string]interface{}{
+		"Energy":  int32(12345),
+		"Mode":    "overdrive",
+		"Buffers": []interface{}{[]byte{1, 2, 3}, []byte{}},
+		"Config":  map[string]interface{}{"speed": float64(1.5)},
+	})
+	if reread := roundTripTileEntity(t, modded); !nbt.Equal(reread, modded) {
+		t.Error("expected ", modded, ", got ", reread)
+	}
+}
+
+func TestGenericTileEntitySurvivesFlush(t *testing.T) {
+	widget := tileEntityCompound("widget_machine", 9, 40, 9, map[string]interface{}{
+		"Power":    int64(1) << 40,
+		"Mode":     "overdrive",
+		"Buffer":   []byte{0, 1, 2, 255},
+		"Settings": map[string]interface{}{"Speed": float32(1.5), "Faces": []interface{}{int8(0), int8(3)}},
+		"Items":    []interface{}{itemCompound(0, 264, 3, 0)},
+	})
+	dir := makeTestWorld(t, testChunkPayload(0, 0, nil, []interface{}{widget}))
+	defer os.RemoveAll(dir)
+	w, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if refs, err := w.FindOrphanedTileEntities(nil); err != nil || len(refs) != 0 {
+		t.Error("widget on air reported as orphaned: ", refs, err)
+	}
+	c, err := w.LoadChunk(0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.SetBlock(0, 10, 0, BlockStone, 0)
+	if err = w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+
+	_, chunk, err := nbt.Load(chunkPath(w.dir, 0, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tes := chunk["Level"].(map[string]interface{})["TileEntities"].([]interface{})
+	if len(tes) != 1 || !nbt.Equal(tes[0], widget) {
+		t.Error("expected ", widget, ", got ", tes)
+	}
+}
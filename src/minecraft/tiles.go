@@ -0,0 +1,512 @@
+package world
+
+import "minecraft/error"
+
+import "bytes"
+import "fmt"
+import "image"
+import "image/png"
+import "io/ioutil"
+import "os"
+import "path"
+import "runtime"
+import "strconv"
+import "strings"
+
+// TileSize is the width and height of a map tile in pixels.
+const TileSize = 256
+
+// tileChunks is how many chunks a tile spans each way at the base zoom.
+const tileChunks = TileSize / ChunkWidth
+
+// tilesFile is the name of the metadata RenderTiles writes beside the tiles.
+const tilesFile = "tiles.json"
+
+// manifestFile is the name of the record RenderTiles keeps of what it drew, so
+// that the next render into the same directory can skip what has not changed.
+const manifestFile = "tiles.manifest"
+
+// TileOptions control how RenderTiles draws tiles.
+type TileOptions struct {
+	// MinY and MaxY bound the blocks drawn, as for RenderOptions.
+	MinY, MaxY int32
+	// Colors gives the color of each block; nil means DefaultColorTable.
+	Colors *ColorTable
+	// Workers is how many tiles are drawn at once; zero means GOMAXPROCS.
+	Workers int
+	// Force redraws every tile, rather than only those whose chunks changed
+	// since the last render into the same directory.  Set it when Colors has
+	// changed, as the manifest does not record them.
+	Force bool
+}
+
+// tileCoord names a tile by its column and row at some zoom.
+type tileCoord struct {
+	x, y int32
+}
+
+// RenderTiles writes region (nil meaning every chunk in the world), seen from
+// above as by RenderMap, as a pyramid of TileSize by TileSize PNG tiles for web
+// map viewers, at dir/<zoom>/<x>/<y>.png.  Zoom 0 is the base, at one pixel per
+// block, and each zoom above it is drawn by shrinking its children 2:1, so that a
+// pixel at zoom z covers 2^z by 2^z blocks; viewers that number zooms the other
+// way, such as Leaflet, must be told to reverse them.  Zooms are added until a
+// single tile holds the region, or until going up no longer narrows the range of
+// tiles, as happens when they lie either side of the origin, which leaves at most
+// two by two tiles at the top.
+//
+// Tiles are laid out from the world's origin rather than the region's, so that
+// tile (x, y) at zoom z covers blocks x*256*2^z to (x+1)*256*2^z - 1 along x and
+// likewise along z, and a tile's edges always fall on chunk edges.  Only the tiles
+// holding chunks of the region are written; blocks outside the region, or in
+// missing chunks, are transparent.  dir/tiles.json describes the result: the
+// tile size, the zoom range, the block bounds of the chunks drawn and, for each
+// zoom, its blocks per pixel and the range of its tiles.
+//
+// Tiles are drawn by opts.Workers at once.  Chunks are read as each base tile is
+// drawn and are not kept, and the tiles of each zoom are drawn from the PNGs of
+// the zoom below.
+//
+// RenderTiles also writes dir/tiles.manifest, recording when each chunk drawn
+// was last written to disk.  Unless opts.Force is set, a later render into dir
+// with the same MinY and MaxY redraws only the base tiles holding chunks that
+// were added, removed, rewritten or have unflushed changes since, and the tiles
+// above them, along with any tiles that are missing; tiles left with no chunks
+// are removed, and the rest are left untouched.  A render cancelled by Cancel
+// leaves the manifest as it was, so that the next redraws the tiles it missed.
+func (world *World) RenderTiles(dir string, region *Region, opts TileOptions) os.Error {
+	ropts := &RenderOptions{MinY: opts.MinY, MaxY: opts.MaxY, Colors: opts.Colors}
+	minY, maxY, err := ropts.yRange()
+	if err != nil {
+		return err
+	}
+	colors := ropts.colors()
+	coords, err := world.ListChunks(region)
+	if err != nil {
+		return err
+	}
+	if len(coords) == 0 {
+		return error.NewError("no chunks to render", nil)
+	}
+	workers := opts.Workers
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+
+	bounds := NewRegion(coords[0].X, coords[0].Z, coords[0].X, coords[0].Z)
+	m := &tileManifest{minY: minY, maxY: maxY}
+	var tiles []tileCoord
+	seen := make(map[XZ]bool)
+	for _, xz := range coords {
+		bounds = bounds.Union(NewRegion(xz.X, xz.Z, xz.X, xz.Z))
+		t := tileCoord{xz.X >> 4, xz.Z >> 4} // tileChunks is 16
+		if !seen[MakeXZ(t.x, t.y)] {
+			seen[MakeXZ(t.x, t.y)] = true
+			tiles = append(tiles, t)
+		}
+		stamp, err := world.chunkStamp(xz.X, xz.Z)
+		if err != nil {
+			return err
+		}
+		m.chunks = append(m.chunks, stamp)
+	}
+	levels := [][]tileCoord{tiles}
+	for top := tiles; len(top) > 1; {
+		parents := parentTiles(top)
+		if tileSpan(parents) == tileSpan(top) {
+			break
+		}
+		levels = append(levels, parents)
+		top = parents
+	}
+	m.maxZoom = len(levels) - 1
+
+	prev, err := readTileManifest(dir)
+	if err != nil {
+		return err
+	}
+	changed := tiles
+	if prev != nil && !opts.Force && prev.minY == minY && prev.maxY == maxY {
+		changed = prev.changedTiles(m)
+	}
+	redraws := make([][]tileCoord, len(levels))
+	total := 0
+	for zoom, level := range levels {
+		if zoom > 0 {
+			changed = parentTiles(changed)
+		}
+		if redraws[zoom], err = staleTiles(dir, zoom, level, changed); err != nil {
+			return err
+		}
+		total += len(redraws[zoom])
+	}
+
+	// a cancelled render leaves the manifest as it was, so the next redraws
+	// what this one did not reach
+	job := world.begin("RenderTiles", total)
+	for zoom, redraw := range redraws {
+		z := zoom
+		err = drawTiles(redraw, workers, func(t tileCoord) (err os.Error) {
+			if err = job.cancelled(); err != nil {
+				return
+			}
+			if z == 0 {
+				err = world.drawBaseTile(dir, bounds, t, minY, maxY, colors, job)
+			} else {
+				err = drawParentTile(dir, z, t)
+			}
+			if err == nil {
+				job.step(fmt.Sprintf("tile %d/%d/%d", z, t.x, t.y))
+			}
+			return
+		})
+		if err != nil {
+			return job.end(err)
+		}
+	}
+	if prev != nil {
+		for zoom := len(levels); zoom <= prev.maxZoom; zoom++ {
+			if err = os.RemoveAll(path.Join(dir, strconv.Itoa(zoom))); err != nil {
+				return job.end(error.NewError(fmt.Sprint("could not remove zoom ", zoom), err))
+			}
+		}
+	}
+	if err = writeTilesJSON(dir, bounds, levels); err != nil {
+		return job.end(err)
+	}
+	return job.end(m.write(dir))
+}
+
+// staleTiles removes those of changed that are not in level from the given zoom,
+// and returns those that are, along with the tiles of level that are missing.
+func staleTiles(dir string, zoom int, level, changed []tileCoord) (redraw []tileCoord, err os.Error) {
+	inLevel := make(map[XZ]bool)
+	for _, t := range level {
+		inLevel[MakeXZ(t.x, t.y)] = true
+	}
+	dirty := make(map[XZ]bool)
+	for _, t := range changed {
+		dirty[MakeXZ(t.x, t.y)] = true
+		if inLevel[MakeXZ(t.x, t.y)] {
+			redraw = append(redraw, t)
+			continue
+		}
+		file := tilePath(dir, zoom, t)
+		if err := os.Remove(file); err != nil {
+			if e, ok := err.(*os.PathError); !ok || e.Error != os.ENOENT {
+				return nil, error.NewError("could not remove tile "+file, err)
+			}
+		}
+	}
+	for _, t := range level {
+		if dirty[MakeXZ(t.x, t.y)] {
+			continue
+		}
+		if _, err := os.Stat(tilePath(dir, zoom, t)); err != nil {
+			redraw = append(redraw, t)
+		}
+	}
+	return
+}
+
+// drawTiles calls draw on every tile with the given number of workers, returning
+// the first error.  Once a tile has failed, the rest are skipped.
+func drawTiles(tiles []tileCoord, workers int, draw func(t tileCoord) os.Error) os.Error {
+	jobs := make(chan tileCoord, len(tiles))
+	for _, t := range tiles {
+		jobs <- t
+	}
+	close(jobs)
+	results := make(chan os.Error)
+	failed := make(chan bool)
+	for i := 0; i < workers; i++ {
+		go func() {
+			for t := range jobs {
+				select {
+				case <-failed:
+					results <- nil
+				default:
+					results <- draw(t)
+				}
+			}
+		}()
+	}
+	var err os.Error
+	for i := 0; i < len(tiles); i++ {
+		if e := <-results; e != nil && err == nil {
+			err = e
+			close(failed)
+		}
+	}
+	return err
+}
+
+// drawBaseTile draws tile t of zoom 0 from the chunks of region it covers,
+// stopping without writing it if job is cancelled.
+func (world *World) drawBaseTile(dir string, region *Region, t tileCoord, minY, maxY int32, colors *ColorTable, job *task) os.Error {
+	m := image.NewNRGBA(TileSize, TileSize)
+	for i := int32(0); i < tileChunks; i++ {
+		for j := int32(0); j < tileChunks; j++ {
+			cx, cz := t.x*tileChunks+i, t.y*tileChunks+j
+			if !region.Contains(cx, cz) {
+				continue
+			}
+			if err := job.cancelled(); err != nil {
+				return err
+			}
+			c, err := world.peekChunk(cx, cz)
+			if err != nil {
+				return err
+			}
+			if c == nil {
+				continue
+			}
+			for x := int32(0); x < ChunkWidth; x++ {
+				for z := int32(0); z < ChunkDepth; z++ {
+					m.SetNRGBA(int(i*ChunkWidth+x), int(j*ChunkDepth+z), topColor(c, x, z, minY, maxY, colors))
+				}
+			}
+		}
+	}
+	return writeTile(dir, 0, t, m)
+}
+
+// drawParentTile draws tile t of the given zoom from its four children at the
+// zoom below, those missing being transparent.
+func drawParentTile(dir string, zoom int, t tileCoord) os.Error {
+	m := image.NewNRGBA(TileSize, TileSize)
+	for i := int32(0); i < 2; i++ {
+		for j := int32(0); j < 2; j++ {
+			child, err := readTile(dir, zoom-1, tileCoord{t.x*2 + i, t.y*2 + j})
+			if err != nil {
+				return err
+			}
+			if child != nil {
+				shrinkInto(m, child, int(i)*TileSize/2, int(j)*TileSize/2)
+			}
+		}
+	}
+	return writeTile(dir, zoom, t, m)
+}
+
+// shrinkInto draws src, a whole tile, at half size into dst with its top left
+// corner at (ox, oy).  Each pixel is the average of four, weighted by their
+// opacity so that transparent pixels do not darken their neighbours.
+func shrinkInto(dst *image.NRGBA, src image.Image, ox, oy int) {
+	b := src.Bounds()
+	for py := 0; py < TileSize/2; py++ {
+		for px := 0; px < TileSize/2; px++ {
+			var r, g, bl, a uint32 // premultiplied, as RGBA returns them
+			for _, d := range [4][2]int{{0, 0}, {1, 0}, {0, 1}, {1, 1}} {
+				cr, cg, cb, ca := src.At(b.Min.X+2*px+d[0], b.Min.Y+2*py+d[1]).RGBA()
+				r, g, bl, a = r+cr, g+cg, bl+cb, a+ca
+			}
+			if a == 0 {
+				continue
+			}
+			dst.SetNRGBA(ox+px, oy+py, image.NRGBAColor{
+				uint8(r * 0xff / a), uint8(g * 0xff / a), uint8(bl * 0xff / a), uint8(a / 4 >> 8),
+			})
+		}
+	}
+}
+
+// tilePath returns where tile t of the given zoom is written.
+func tilePath(dir string, zoom int, t tileCoord) string {
+	return path.Join(dir, strconv.Itoa(zoom), fmt.Sprint(t.x), fmt.Sprint(t.y)+".png")
+}
+
+func writeTile(dir string, zoom int, t tileCoord, m image.Image) os.Error {
+	file := tilePath(dir, zoom, t)
+	buf := new(bytes.Buffer)
+	if err := png.Encode(buf, m); err != nil {
+		return error.NewError("could not encode tile "+file, err)
+	}
+	if err := os.MkdirAll(path.Dir(file), 0755); err != nil {
+		return error.NewError("could not create tile directory", err)
+	}
+	if err := ioutil.WriteFile(file, buf.Bytes(), 0644); err != nil {
+		return error.NewError("could not write tile "+file, err)
+	}
+	return nil
+}
+
+// readTile decodes tile t of the given zoom, or returns nil if it was not written.
+func readTile(dir string, zoom int, t tileCoord) (image.Image, os.Error) {
+	file := tilePath(dir, zoom, t)
+	f, err := os.Open(file, os.O_RDONLY, 0)
+	if err != nil {
+		if e, ok := err.(*os.PathError); ok && e.Error == os.ENOENT {
+			return nil, nil
+		}
+		return nil, error.NewError("could not open tile "+file, err)
+	}
+	defer f.Close()
+	m, err := png.Decode(f)
+	if err != nil {
+		return nil, error.NewError("could not decode tile "+file, err)
+	}
+	return m, nil
+}
+
+// parentTiles returns the tiles one zoom up that hold tiles.
+func parentTiles(tiles []tileCoord) (parents []tileCoord) {
+	seen := make(map[XZ]bool)
+	for _, t := range tiles {
+		p := tileCoord{t.x >> 1, t.y >> 1}
+		if !seen[MakeXZ(p.x, p.y)] {
+			seen[MakeXZ(p.x, p.y)] = true
+			parents = append(parents, p)
+		}
+	}
+	return
+}
+
+// tileRange returns the smallest rectangle of tiles holding tiles, as a Region
+// whose X and Z are tile columns and rows.
+func tileRange(tiles []tileCoord) *Region {
+	r := NewRegion(tiles[0].x, tiles[0].y, tiles[0].x, tiles[0].y)
+	for _, t := range tiles[1:] {
+		r = r.Union(NewRegion(t.x, t.y, t.x, t.y))
+	}
+	return r
+}
+
+// tileSpan returns how many tiles wide and high the range of tiles is, as one
+// comparable number.
+func tileSpan(tiles []tileCoord) XZ {
+	r := tileRange(tiles)
+	return MakeXZ(r.Width(), r.Depth())
+}
+
+// writeTilesJSON writes the metadata of the zooms drawn, lowest first, to
+// dir/tiles.json.
+func writeTilesJSON(dir string, bounds *Region, levels [][]tileCoord) os.Error {
+	zooms := []interface{}{}
+	for zoom, tiles := range levels {
+		r := tileRange(tiles)
+		zooms = append(zooms, jsonObject{
+			{"zoom", zoom},
+			{"blocksPerPixel", 1 << uint(zoom)},
+			{"minX", r.MinX}, {"minY", r.MinZ}, {"maxX", r.MaxX}, {"maxY", r.MaxZ},
+		})
+	}
+	obj := jsonObject{
+		{"tileSize", TileSize},
+		{"minZoom", 0},
+		{"maxZoom", len(levels) - 1},
+		{"blocks", jsonObject{
+			{"minX", bounds.MinX * ChunkWidth},
+			{"minZ", bounds.MinZ * ChunkDepth},
+			{"maxX", bounds.MaxX*ChunkWidth + ChunkWidth - 1},
+			{"maxZ", bounds.MaxZ*ChunkDepth + ChunkDepth - 1},
+		}},
+		{"zooms", zooms},
+	}
+	buf := new(bytes.Buffer)
+	if err := writeJSON(buf, obj); err != nil {
+		return error.NewError("could not encode tile metadata", err)
+	}
+	buf.WriteString("\n")
+	if err := ioutil.WriteFile(path.Join(dir, tilesFile), buf.Bytes(), 0644); err != nil {
+		return error.NewError("could not write tile metadata", err)
+	}
+	return nil
+}
+
+// A chunkStamp records when a chunk was last written to disk, as a tile manifest
+// holds it.
+type chunkStamp struct {
+	x, z  int32
+	mtime int64 // -1 if the chunk has changes not yet on disk
+}
+
+// chunkStamp returns the stamp of the chunk at (x, z), resident or on disk.
+func (world *World) chunkStamp(x, z int32) (chunkStamp, os.Error) {
+	if c, ok := world.resident(MakeXZ(x, z)); ok && c.Dirty() {
+		return chunkStamp{x, z, -1}, nil
+	}
+	mtime, err := world.ChunkModTime(x, z)
+	if err != nil {
+		return chunkStamp{}, err
+	}
+	return chunkStamp{x, z, mtime}, nil
+}
+
+// A tileManifest records what RenderTiles drew: the range of heights, the top
+// zoom and the stamp of every chunk, in the order ListChunks gives them.  It is kept as text, a line of
+// "minY maxY maxZoom" followed by a line of "x z mtime" for each chunk.
+type tileManifest struct {
+	minY, maxY int32
+	maxZoom    int
+	chunks     []chunkStamp
+}
+
+// readTileManifest reads the manifest of the tiles in dir, returning nil if there
+// is none or it cannot be made sense of, in which case every tile is redrawn.
+func readTileManifest(dir string) (*tileManifest, os.Error) {
+	file := path.Join(dir, manifestFile)
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		if e, ok := err.(*os.PathError); ok && e.Error == os.ENOENT {
+			return nil, nil
+		}
+		return nil, error.NewError("could not read tile manifest", err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n", -1)
+	m := new(tileManifest)
+	if _, err := fmt.Sscan(lines[0], &m.minY, &m.maxY, &m.maxZoom); err != nil {
+		return nil, nil
+	}
+	for _, line := range lines[1:] {
+		var s chunkStamp
+		if _, err := fmt.Sscan(line, &s.x, &s.z, &s.mtime); err != nil {
+			return nil, nil
+		}
+		m.chunks = append(m.chunks, s)
+	}
+	return m, nil
+}
+
+// write saves the manifest to dir.
+func (m *tileManifest) write(dir string) os.Error {
+	buf := new(bytes.Buffer)
+	fmt.Fprintln(buf, m.minY, m.maxY, m.maxZoom)
+	for _, s := range m.chunks {
+		fmt.Fprintln(buf, s.x, s.z, s.mtime)
+	}
+	if err := ioutil.WriteFile(path.Join(dir, manifestFile), buf.Bytes(), 0644); err != nil {
+		return error.NewError("could not write tile manifest", err)
+	}
+	return nil
+}
+
+// changedTiles returns the base tiles holding the chunks that differ between m,
+// the manifest of the last render, and now, that of this one.
+func (m *tileManifest) changedTiles(now *tileManifest) (tiles []tileCoord) {
+	seen := make(map[XZ]bool)
+	change := func(s chunkStamp) {
+		t := tileCoord{s.x >> 4, s.z >> 4}
+		if !seen[MakeXZ(t.x, t.y)] {
+			seen[MakeXZ(t.x, t.y)] = true
+			tiles = append(tiles, t)
+		}
+	}
+	last := make(map[XZ]int64)
+	for _, s := range m.chunks {
+		last[MakeXZ(s.x, s.z)] = s.mtime
+	}
+	for _, s := range now.chunks {
+		xz := MakeXZ(s.x, s.z)
+		if mtime, ok := last[xz]; !ok || s.mtime < 0 || mtime != s.mtime {
+			change(s)
+		}
+		last[xz] = 0, false
+	}
+	for _, s := range m.chunks {
+		if _, ok := last[MakeXZ(s.x, s.z)]; ok {
+			change(s) // removed since
+		}
+	}
+	return
+}
@@ -0,0 +1,219 @@
+package world
+
+import "image"
+import "io/ioutil"
+import "os"
+import "path"
+import "sort"
+import "strings"
+import "testing"
+
+// makeTileWorld writes chunks (0, 0) and (1, 0), which lie in tile (0, 0) at the
+// base zoom, (16, 0), in tile (1, 0), and (-1, -1), in tile (-1, -1).  Chunk
+// (0, 0) has grass on columns (0, 0) to (1, 1) and stone on (2, 0).
+func makeTileWorld(t *testing.T) string {
+	c := testChunkPayload(0, 0, nil, nil)
+	blocks := c["Level"].(map[string]interface{})["Blocks"].([]byte)
+	for x := int32(0); x < 2; x++ {
+		for z := int32(0); z < 2; z++ {
+			blocks[blockIndex(x, 64, z)] = BlockGrass
+		}
+	}
+	blocks[blockIndex(2, 64, 0)] = BlockStone
+	return makeTestWorld(t, c, testChunkPayload(1, 0, nil, nil), testChunkPayload(16, 0, nil, nil),
+		testChunkPayload(-1, -1, nil, nil))
+}
+
+func TestRenderTiles(t *testing.T) {
+	dir := makeTileWorld(t)
+	defer os.RemoveAll(dir)
+	w, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	out, err := ioutil.TempDir("", "tiles")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(out)
+
+	if err = w.RenderTiles(out, nil, TileOptions{Workers: 3}); err != nil {
+		t.Fatal(err)
+	}
+	for _, tile := range []string{"0/0/0.png", "0/1/0.png", "0/-1/-1.png", "1/0/0.png", "1/-1/-1.png"} {
+		if _, err := os.Stat(path.Join(out, tile)); err != nil {
+			t.Error("expected tile ", tile)
+		}
+	}
+	for _, tile := range []string{"0/0/-1.png", "2"} {
+		if _, err := os.Stat(path.Join(out, tile)); err == nil {
+			t.Error("unexpected tile ", tile)
+		}
+	}
+
+	base, err := readTile(out, 0, tileCoord{0, 0})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := base.At(1, 1); !sameColor(got, blockColors[BlockGrass]) {
+		t.Error("expected grass at the base, got ", got)
+	}
+	if got := base.At(16, 0); !sameColor(got, image.NRGBAColor{}) {
+		t.Error("expected an empty column to be transparent, got ", got)
+	}
+	top, err := readTile(out, 1, tileCoord{0, 0})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := top.At(0, 0); !sameColor(got, blockColors[BlockGrass]) {
+		t.Error("expected grass one zoom up, got ", got)
+	}
+	stone := blockColors[BlockStone]
+	stone.A = 0x3f // one of the four blocks shrunk into the pixel
+	if got := top.At(1, 0); !sameColor(got, stone) {
+		t.Errorf("expected %v one zoom up, got %v", stone, got)
+	}
+
+	meta, err := ioutil.ReadFile(path.Join(out, tilesFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"tileSize": 256, "minZoom": 0, "maxZoom": 1, ` +
+		`"blocks": {"minX": -16, "minZ": -16, "maxX": 271, "maxZ": 15}, "zooms": [` +
+		`{"zoom": 0, "blocksPerPixel": 1, "minX": -1, "minY": -1, "maxX": 1, "maxY": 0}, ` +
+		`{"zoom": 1, "blocksPerPixel": 2, "minX": -1, "minY": -1, "maxX": 0, "maxY": 0}]}` + "\n"
+	if string(meta) != want {
+		t.Errorf("expected\n%s\ngot\n%s", want, meta)
+	}
+	if len(w.Chunks) != 0 {
+		t.Error("expected no chunks to be made resident, got ", len(w.Chunks))
+	}
+}
+
+func TestRenderTilesRegion(t *testing.T) {
+	dir := makeTileWorld(t)
+	defer os.RemoveAll(dir)
+	w, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	out, err := ioutil.TempDir("", "tiles")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(out)
+
+	// the region holds a single chunk, so the base zoom is the only one
+	if err = w.RenderTiles(out, NewRegion(0, 0, 0, 0), TileOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path.Join(out, "1")); err == nil {
+		t.Error("expected only the base zoom")
+	}
+	if _, err := os.Stat(path.Join(out, "0/0/0.png")); err != nil {
+		t.Error("expected the base tile, got ", err)
+	}
+	if err = w.RenderTiles(out, NewRegion(5, 5, 6, 6), TileOptions{}); err == nil {
+		t.Error("expected an error for a region without chunks")
+	}
+}
+
+// touchedTiles returns the tiles under dir written since it was last called,
+// sorted, and marks every tile as old.
+func touchedTiles(t *testing.T, dir string) (tiles []string) {
+	const old = 1e9
+	var walk func(rel string)
+	walk = func(rel string) {
+		files, err := ioutil.ReadDir(path.Join(dir, rel))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, fi := range files {
+			name := path.Join(rel, fi.Name)
+			if fi.IsDirectory() {
+				walk(name)
+			} else if path.Ext(name) == ".png" {
+				if fi.Mtime_ns != old {
+					tiles = append(tiles, name)
+				}
+				if err := os.Chtimes(path.Join(dir, name), old, old); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+	}
+	walk("")
+	sort.SortStrings(tiles)
+	return
+}
+
+func TestRenderTilesIncremental(t *testing.T) {
+	dir := makeTileWorld(t)
+	defer os.RemoveAll(dir)
+	w, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	out, err := ioutil.TempDir("", "tiles")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(out)
+
+	render := func(opts TileOptions, want string) {
+		if err := w.RenderTiles(out, nil, opts); err != nil {
+			t.Fatal(err)
+		}
+		if got := strings.Join(touchedTiles(t, out), " "); got != want {
+			t.Errorf("%+v: expected tiles [%s] to be drawn, got [%s]", opts, want, got)
+		}
+	}
+	render(TileOptions{}, "0/-1/-1.png 0/0/0.png 0/1/0.png 1/-1/-1.png 1/0/0.png")
+	render(TileOptions{}, "")
+
+	mtime, err := w.ChunkModTime(16, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = os.Chtimes(chunkPath(w.dir, 16, 0), mtime+1e9, mtime+1e9); err != nil {
+		t.Fatal(err)
+	}
+	render(TileOptions{}, "0/1/0.png 1/0/0.png")
+
+	// a resident chunk with changes counts as changed until it is flushed
+	c, err := w.GetChunk(0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = c.SetBlock(5, 64, 5, BlockStone, 0); err != nil {
+		t.Fatal(err)
+	}
+	render(TileOptions{}, "0/0/0.png 1/0/0.png")
+	if base, err := readTile(out, 0, tileCoord{0, 0}); err != nil {
+		t.Fatal(err)
+	} else if got := base.At(5, 5); !sameColor(got, blockColors[BlockStone]) {
+		t.Error("expected the change to be drawn, got ", got)
+	}
+	if err = w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	render(TileOptions{}, "0/0/0.png 1/0/0.png")
+	render(TileOptions{}, "")
+
+	if err = os.Remove(chunkPath(w.dir, -1, -1)); err != nil {
+		t.Fatal(err)
+	}
+	// the tiles left are unchanged, so only those of the chunk go
+	render(TileOptions{}, "")
+	for _, tile := range []string{"0/-1/-1.png", "1/-1/-1.png"} {
+		if _, err := os.Stat(path.Join(out, tile)); err == nil {
+			t.Error("expected the tile without chunks to be removed: ", tile)
+		}
+	}
+
+	render(TileOptions{Force: true}, "0/0/0.png 0/1/0.png 1/0/0.png")
+	render(TileOptions{MaxY: 100}, "0/0/0.png 0/1/0.png 1/0/0.png")
+}
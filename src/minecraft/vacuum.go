@@ -0,0 +1,83 @@
+package world
+
+import "minecraft/error"
+
+import "fmt"
+import "os"
+
+// VacuumItems moves the dropped items in region (nil meaning the whole world) into
+// the chest at (chestX, chestY, chestZ), topping up stacks of the same item before
+// taking empty slots, and removes the drops it emptied.  What does not fit once
+// the chest is full stays on the ground.  It returns how many items were
+// collected and how many were left.  The block must be a chest; its tile entity
+// is made if it has none.  The chest's chunk is left resident, and its Lock is
+// held throughout; chunks that are not resident are streamed from disk and
+// written back if items were taken from them, but only once the chest's chunk
+// has been written, so that a crash between leaves the items in both rather
+// than in neither.  Otherwise the chest's chunk is left dirty.
+func (world *World) VacuumItems(region *Region, chestX, chestY, chestZ int32) (collected, leftOnGround int, err os.Error) {
+	cc, err := world.GetChunk(chestX>>4, chestZ>>4)
+	if err != nil {
+		return
+	}
+	cc.Lock()
+	defer cc.Unlock()
+	chest, err := cc.chestAt(chestX, chestY, chestZ)
+	if err != nil {
+		return
+	}
+	err = world.streamChunksHolding("VacuumItems", region, cc, func(c *Chunk) (bool, os.Error) {
+		taken := false
+		kept := c.Level.Entities[:0]
+		for _, e := range c.Level.Entities {
+			if e.Id != "Item" || e.Item == nil || e.Item.Count <= 0 {
+				kept = append(kept, e)
+				continue
+			}
+			n := chest.Add(*e.Item, int(e.Item.Count))
+			collected += n
+			if n > 0 {
+				taken = true
+			}
+			if n < int(e.Item.Count) {
+				e.Item.Count -= int8(n)
+				leftOnGround += int(e.Item.Count)
+				kept = append(kept, e)
+			}
+		}
+		c.Level.Entities = kept
+		if taken {
+			cc.dirty = true
+			if _, resident := world.resident(MakeXZ(c.Level.XPos, c.Level.ZPos)); !resident {
+				if err := world.saveChunk(cc); err != nil {
+					return false, err
+				}
+			}
+		}
+		return taken, nil
+	})
+	return
+}
+
+// chestAt returns the chest at absolute block coordinates (x, y, z), which lie
+// in the chunk, making an empty one if the chest block has no tile entity.  The
+// caller holds the chunk's Lock.
+func (c *Chunk) chestAt(x, y, z int32) (*Chest, os.Error) {
+	if id, _, _ := c.BlockAt(x&15, y, z&15); id != BlockChest {
+		return nil, error.NewError(fmt.Sprintf("block at (%d, %d, %d) is %d, not a chest", x, y, z, id), nil)
+	}
+	for _, te := range c.Level.TileEntities {
+		if te.X() != x || te.Y() != y || te.Z() != z {
+			continue
+		}
+		if chest, ok := te.(*Chest); ok {
+			return chest, nil
+		}
+		return nil, error.NewError(fmt.Sprintf("chest at (%d, %d, %d) has a %s tile entity", x, y, z, te.Id()), nil)
+	}
+	chest := newTileEntity("Chest", x, y, z).(*Chest)
+	chest.chunk = c
+	c.Level.TileEntities = append(c.Level.TileEntities, chest)
+	c.dirty = true
+	return chest, nil
+}
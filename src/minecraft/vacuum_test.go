@@ -0,0 +1,92 @@
+package world
+
+import "os"
+import "testing"
+
+// makeVacuumWorld writes a world with a chest block at (1, 64, 1) that has no
+// tile entity, and eight drops of 17 cobblestone spread over two chunks.
+func makeVacuumWorld(t *testing.T) string {
+	c := newChunk(0, 0)
+	c.SetBlock(1, 64, 1, BlockChest, 0)
+	c.Level.Entities = []*Entity{
+		mustEntity(itemAt(3, 64, 3)), mustEntity(pigFixture), mustEntity(itemAt(4, 64, 3)),
+		mustEntity(itemAt(5, 64, 3)), mustEntity(itemAt(6, 64, 3)),
+	}
+	return makeTestWorld(t, mustFromChunk(c), testChunkPayload(1, 0, []interface{}{
+		itemAt(17, 64, 3), itemAt(18, 64, 3), itemAt(19, 64, 3), itemAt(20, 64, 3),
+	}, nil))
+}
+
+func TestVacuumItems(t *testing.T) {
+	dir := makeVacuumWorld(t)
+	defer os.RemoveAll(dir)
+	w, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	if _, _, err = w.VacuumItems(nil, 2, 64, 1); err == nil {
+		t.Error("expected an error for a block that is not a chest")
+	}
+	collected, left, err := w.VacuumItems(nil, 1, 64, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if collected != 8*17 || left != 0 {
+		t.Errorf("expected 136 collected and none left, got %d and %d", collected, left)
+	}
+	c := w.Chunks[MakeXZ(0, 0)]
+	if len(c.Level.TileEntities) != 1 {
+		t.Fatal("chest tile entity not created")
+	}
+	// the chest is written before the streamed chunk its items came from
+	level, err := w.readChunkLevel(0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if onDisk, _ := getList(level, "TileEntities"); len(onDisk) != 1 {
+		t.Errorf("expected the chest written, got %d tile entities on disk", len(onDisk))
+	}
+	items := c.Level.TileEntities[0].(*Chest).Items()
+	if items[0].Count != 64 || items[1].Count != 64 || items[2].Count != 8 || items[3].Count != 0 {
+		t.Error("items not merged into stacks: ", items)
+	}
+	if len(c.Level.Entities) != 1 {
+		t.Error("expected only the pig to remain, got ", len(c.Level.Entities))
+	}
+	if counts, _ := w.EntityCensus(NewRegion(1, 0, 1, 0), CensusOptions{}); counts["Item"] != 0 {
+		t.Error("drops in streamed chunk not removed")
+	}
+}
+
+func TestVacuumItemsFullChest(t *testing.T) {
+	dir := makeVacuumWorld(t)
+	defer os.RemoveAll(dir)
+	w, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	c, err := w.GetChunk(0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chest, err := c.chestAt(1, 64, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chest.Add(Item{Id: BlockDirt}, (ChestSlots-1)*64)
+
+	collected, left, err := w.VacuumItems(nil, 1, 64, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if collected != 64 || left != 8*17-64 {
+		t.Errorf("expected 64 collected and 72 left, got %d and %d", collected, left)
+	}
+	counts, _ := w.EntityCensus(nil, CensusOptions{})
+	if counts["Item"] != 5 {
+		t.Error("expected 5 drops left on the ground, got ", counts["Item"])
+	}
+}
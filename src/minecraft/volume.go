@@ -0,0 +1,76 @@
+package world
+
+import "os"
+
+// blockVolume holds every chunk of a region in memory so that blocks can be
+// looked up by their offset (bx, y, bz) from the region's corner of least x and
+// z, for work such as rendering that visits neighbouring blocks across chunks.
+type blockVolume struct {
+	region       *Region
+	chunks       map[XZ]*Chunk
+	width, depth int // in blocks
+	minY, maxY   int32
+}
+
+// loadVolume reads the chunks of region, which must not be nil, without making
+// them resident.  Blocks outside minY to maxY read as air.  Each chunk is a
+// step of t, which may be nil.
+func (world *World) loadVolume(region *Region, minY, maxY int32, t *task) (*blockVolume, os.Error) {
+	v := &blockVolume{
+		region: region,
+		chunks: make(map[XZ]*Chunk),
+		width:  int(region.Width()) * ChunkWidth,
+		depth:  int(region.Depth()) * ChunkDepth,
+		minY:   minY,
+		maxY:   maxY,
+	}
+	for x := region.MinX; x <= region.MaxX; x++ {
+		for z := region.MinZ; z <= region.MaxZ; z++ {
+			if err := t.cancelled(); err != nil {
+				return nil, err
+			}
+			c, err := world.peekChunk(x, z)
+			if err != nil {
+				return nil, err
+			}
+			if c != nil {
+				v.chunks[MakeXZ(x, z)] = c
+			}
+			t.chunk(x, z)
+		}
+	}
+	return v, nil
+}
+
+// block returns the id of block (bx, y, bz), or air if it lies outside the volume
+// or in a missing chunk.
+func (v *blockVolume) block(bx int, y int32, bz int) byte {
+	if bx < 0 || bx >= v.width || bz < 0 || bz >= v.depth || y < v.minY || y > v.maxY {
+		return BlockAir
+	}
+	c, ok := v.chunks[MakeXZ(v.region.MinX+int32(bx/ChunkWidth), v.region.MinZ+int32(bz/ChunkDepth))]
+	if !ok {
+		return BlockAir
+	}
+	return c.Level.Blocks[blockIndex(int32(bx%ChunkWidth), y, int32(bz%ChunkDepth))]
+}
+
+// data returns the data value of block (bx, y, bz), or 0 if it lies outside the
+// volume or in a missing chunk.
+func (v *blockVolume) data(bx int, y int32, bz int) byte {
+	if bx < 0 || bx >= v.width || bz < 0 || bz >= v.depth || y < v.minY || y > v.maxY {
+		return 0
+	}
+	c, ok := v.chunks[MakeXZ(v.region.MinX+int32(bx/ChunkWidth), v.region.MinZ+int32(bz/ChunkDepth))]
+	if !ok {
+		return 0
+	}
+	return getNibble(c.Level.Data, blockIndex(int32(bx%ChunkWidth), y, int32(bz%ChunkDepth)))
+}
+
+// hides reports whether a block with id n next to a block with id b covers the
+// face of b it touches: it does if it is opaque, or if both are the same
+// translucent block, as within a body of water.
+func hides(n, b byte) bool {
+	return blockColors[n].A == 0xff || (n == b && n != BlockAir)
+}
@@ -7,26 +7,131 @@ import "fmt"
 import "io/ioutil"
 import "os"
 import "path"
+import "runtime"
+import "sync"
+import "time"
 
 const (
 	leveldat    = "level.dat"
 	sessionlock = "session.lock"
 )
 
+// DefaultLockCheckInterval is how long, in nanoseconds, a World trusts a check
+// of its session lock before loading chunks checks it again.
+const DefaultLockCheckInterval = 5e9
+
+// XZ packs the coordinates of a chunk into one comparable number, for keying
+// maps: x in the low 32 bits and z in the high 32.  Make keys with MakeXZ and
+// take them apart with SplitXZ.
+//
+// MakeXZ once added x, sign extended, to z shifted up, so that a negative x
+// borrowed from the bits of z: (-1, 0) was -1 where it is now 0xffffffff.  The
+// numbers of keys with a negative x have changed with it.  Keys made by MakeXZ
+// still match one another, but any kept as numbers outside a World, in a file,
+// say, must be made again from their coordinates.
 type XZ int64
 
+// MakeXZ packs chunk coordinates (x, z) into an XZ.
 func MakeXZ(x int32, z int32) XZ {
-	return XZ(int64(x) + int64(z)<<32)
+	return XZ(int64(uint32(x)) | int64(z)<<32)
 }
 
+// SplitXZ returns the coordinates MakeXZ packed into xz.
+func SplitXZ(xz XZ) (x, z int32) {
+	return int32(xz), int32(xz >> 32)
+}
+
+// A World is a world directory opened by Open.
+//
+// A World may be shared between goroutines.  The set of resident chunks is
+// guarded by the World, so that LoadChunk, LoadChunksParallel, GetChunk,
+// CreateChunk, ChunkExists, BlockAt, ForEachChunk and Flush may be called at
+// once, as may the methods that only read chunks from disk, such as the
+// renderers and exporters.  Chunks themselves are not guarded by the World: a
+// goroutine changing a chunk that others may be using must hold its Lock, as
+// ForEachChunk and Flush do, and one reading it its RLock, as BlockAt does.
+// Methods that lock several chunks at once, such as BlitBlocks, Paste or
+// ImportChests, take their Locks in the order ListChunks sorts them, so a
+// goroutine doing the same cannot deadlock with them.
+//
+// The Chunks map itself, Data and the option fields are not guarded: set them
+// before the World is shared, and use GetChunk rather than reading Chunks once
+// it is; Data may change later only under LockData.  ChunkThumbnail counts its
+// cache's hits unguarded and must not be called from two goroutines at once.
 type World struct {
 	dir      string
+	config   Config     // the options Open was given
+	format   Format     // as Open found it
+	store    ChunkStore // where the chunks are kept
 	lockmsec int64
 	// see: http://www.minecraftwiki.net/wiki/Alpha_Level_Format
 	Data Data
+	// level.dat as read, so that tags Data does not model are written back
+	level map[string]interface{}
 	// we cheat and use int64, since it has equality defined.
 	Chunks map[XZ]*Chunk
-	lockfd *os.File
+	// Thumbnails, if set, keeps the images ChunkThumbnail renders.
+	Thumbnails *ThumbnailCache
+	// LockCheckInterval is how long, in nanoseconds, loading chunks trusts the
+	// last check that no other process has opened the world since; zero means
+	// DefaultLockCheckInterval, and a negative interval checks before every
+	// load.  Anything that writes to the world checks first regardless.
+	LockCheckInterval int64
+	// FlushWorkers is how many chunks Flush encodes and writes at once; zero
+	// means GOMAXPROCS.
+	FlushWorkers int
+	// LazyArrays makes the chunks LoadChunk, GetChunk and LoadChunksParallel
+	// load leave their block, data, light and height map arrays undecoded until
+	// first needed, which saves much of the work of loading chunks for their
+	// entities alone.  Until then the arrays of Level are nil: the methods of
+	// Chunk decode them as they need them, failing with ErrCorruptChunk if
+	// they are malformed, but code using Level's arrays directly must call
+	// LoadArrays first.
+	LazyArrays bool
+	// CompressIdleAfter, if positive, makes CompressIdleChunks compress in
+	// memory the arrays of each clean resident chunk that none of
+	// the last CompressIdleAfter uses of resident chunks has touched, where
+	// fetching a chunk through the World, by GetChunk or BlockAt, say, uses it.
+	// Compressed chunks stay resident and their arrays are uncompressed as
+	// LazyArrays decodes them: on first use by the methods of Chunk, or by
+	// LoadArrays.  Dirty chunks are never compressed.
+	CompressIdleAfter int
+	// ArrayPoolSize, if positive, is how many bytes of streamed chunks' arrays
+	// the World may keep to decode other chunks into, rather than allocating
+	// theirs afresh.  Arrays are returned only by the methods that stream
+	// chunks through memory without making them resident, such as
+	// RemoveEntities, once done with each chunk; resident chunks keep their
+	// arrays when they are unloaded.
+	ArrayPoolSize int64
+	// MaxResident, if positive, makes UnloadExcessChunks unload the least
+	// recently used resident chunks beyond MaxResident, where using a chunk is
+	// as CompressIdleAfter has it.  They are unloaded as UnloadChunk unloads
+	// them, so a chunk fetched before UnloadExcessChunks is called must be
+	// fetched again after it.  Nothing else unloads chunks; Open's CacheSize
+	// option sets it.
+	MaxResident int
+	// Progress, if set, is told of the course of long operations.
+	Progress Progress
+	// Cancel, if set, cancels long operations once it is closed: each one
+	// Progress is told of stops before its next chunk, leaving every chunk
+	// written whole or not at all, and fails with a CancelledError telling how
+	// far it got.  Set a new channel to run operations again.
+	Cancel <-chan bool
+	// FlushError, if set, is called with the error of each flush of
+	// StartAutoFlush that fails.
+	FlushError  func(err os.Error)
+	lockfd      *os.File
+	lockChecked int64 // when the session lock was last found to be ours
+	lockReads   int   // how many times it has been read, for testing
+
+	mu     sync.RWMutex // guards Chunks
+	lockMu sync.Mutex   // guards lockfd and lockChecked
+	idleMu sync.Mutex   // guards uses and the chunks' used
+	uses   int64        // how many times resident chunks have been used
+	pool   arrayPool    // see ArrayPoolSize
+	autoMu sync.Mutex   // guards auto
+	auto   *autoFlush   // the running auto-flush, if any
+	dataMu sync.Mutex   // see LockData
 }
 
 type Data struct {
@@ -36,10 +141,25 @@ type Data struct {
 	LastPlayed             int64
 	SizeOnDisk             int64
 	RandomSeed             int64
+	// the player of a single-player world; nil for multiplayer worlds, whose
+	// players are in the players directory
+	Player *Player
 }
 
 type Chunk struct {
 	Level Level
+	// Problems found while decoding that did not prevent loading the chunk,
+	// such as malformed tile entities.
+	Warnings []os.Error
+
+	dirty          bool
+	heightMapStale bool
+	mu             sync.RWMutex // see Lock
+	raw            []byte       // the chunk's file, until its arrays are decoded
+	packed         []byte       // the arrays compressed by CompressIdleChunks
+	rawMu          sync.Mutex   // guards raw and packed, for readers sharing the chunk
+	used           int64        // world.uses when last used; guarded by world.idleMu
+	alloc          []byte       // the allocation Level's arrays were decoded into
 }
 
 type Level struct {
@@ -49,76 +169,171 @@ type Level struct {
 	HeightMap        []byte
 	BlockLight       []byte
 	Entities         []*Entity
-	TileEntities     interface{}
+	TileEntities     []TileEntity
 	LastUpdate       int64
 	XPos             int32
 	ZPos             int32
 	TerrainPopulated int8
 }
 
-type Entity struct {
-	Id           string
-	OnGround     int8
-	Air          int16
-	Fire         int16
-	Health       *int16
-	Tile         *int16
-	Item         *Item
-	FallDistance float32
-	Physics      Physics
-	Age          *int16
-}
-
-type Item struct {
-	Id     int16
-	Count  int8
-	Damage int16
-}
-
-type Physics struct {
-	Position Position
-	Velocity Velocity
-	Euler    Euler
-}
-
-type Position struct {
-	X, Y, Z float64
-}
-type Velocity struct {
-	DX, DY, DZ float64
-}
-type Euler struct {
-	Yaw, Pitch, Roll float32
-}
-
-func Open(worlddir string) (w *World, err os.Error) {
+// Open opens the world in worlddir and takes its session lock, as the game
+// does, unless the options say otherwise.  Open without options is Open with
+// the zero Config.
+func Open(worlddir string, opts ...Option) (w *World, err os.Error) {
 	w = &World{dir: worlddir}
+	for _, opt := range opts {
+		opt(&w.config)
+	}
+	if err = w.config.validate(); err != nil {
+		err = error.InWorld(worlddir).Error("invalid options", err)
+		return
+	}
+	w.MaxResident = w.config.CacheSize
 	if err = w.verifyFormat(); err != nil {
-		err = error.NewError("could not verify world format", err)
+		err = error.InWorld(worlddir).Error("could not verify world format", err)
 		return
 	}
+	w.format = detectFormat(worlddir)
+	if w.store = w.config.Store; w.store == nil {
+		w.store = NewStore(worlddir, w.format)
+		if s, ok := w.store.(*regionStore); ok && w.config.MapRegions {
+			w.store = newMappedStore(worlddir, s.ext)
+		}
+	}
+	// give the lock back if the world cannot be opened after all
+	defer func() {
+		if err != nil {
+			w.unlock()
+		}
+	}()
 	if err = w.lock(); err != nil {
-		err = error.NewError("unable to obtain lock on world", err)
+		err = error.InWorld(worlddir).Error("unable to obtain lock", err)
 		return
 	}
 	_, levelDat, err := nbt.Load(path.Join(w.dir, leveldat))
 	if err != nil {
-		err = error.NewError("could not read level", err)
+		err = error.InWorld(worlddir).Error("could not read level", err)
 		return
 	}
 
 	w.Chunks = make(map[XZ]*Chunk)
-	w.loadLevelDat(levelDat)
+	if err = w.loadLevelDat(levelDat); err != nil {
+		err = error.InWorld(worlddir).Error("could not decode level", err)
+	}
 	return
 }
 
+// Close stops auto-flush, if it is running, and gives up the world's session
+// lock.  The lock is given up even if the last auto-flush fails, and the
+// error of that flush returned.  The region files of a world opened with
+// MapRegions are unmapped.
 func (world *World) Close() os.Error {
-	return world.unlock()
+	err := world.StopAutoFlush()
+	if e := world.unlock(); err == nil {
+		err = e
+	}
+	if s, ok := world.store.(*mappedStore); ok {
+		if e := s.Close(); err == nil {
+			err = e
+		}
+	}
+	return err
 }
 
-// Flushes any in-memory changes to disk
+// Flushes any in-memory changes to disk.  Every dirty chunk is written even if
+// an earlier one fails; chunks that could not be written stay dirty, as do
+// those not yet written when Cancel is closed.
+//
+// Chunks are encoded, compressed and written by FlushWorkers at once.  Each
+// chunk has a file of its own, written by one worker while it holds the chunk's
+// Lock, and replaced only once it is complete.  Once they are all written,
+// Flush writes level.dat with SaveLevel, so that level.dat is never newer than
+// the chunks; if any chunk could not be written, level.dat is left as it was.
+// Flush unloads no chunks, whatever MaxResident, as other goroutines may be
+// reading them; call UnloadExcessChunks when none are.
 func (world *World) Flush() os.Error {
-	panic("writeme")
+	return world.flushAll()
+}
+
+// writeDirty writes the dirty chunks for flushAll.
+func (world *World) writeDirty() (err os.Error) {
+	if err = world.VerifyLockNow(); err != nil {
+		return
+	}
+	chunks := world.residentChunks()
+	t := world.begin("Flush", len(chunks))
+	defer func() { t.end(err) }()
+	workers := world.FlushWorkers
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	jobs := make(chan int, len(chunks))
+	for i := range chunks {
+		jobs <- i
+	}
+	close(jobs)
+	saved := make([]bool, len(chunks))   // whether each chunk was dirty
+	skipped := make([]bool, len(chunks)) // whether each was left for a cancel
+	errs := make([]os.Error, len(chunks))
+	done := make(chan bool)
+	for n := 0; n < workers; n++ {
+		go func() {
+			for i := range jobs {
+				if t.cancelled() != nil {
+					skipped[i] = true
+					continue
+				}
+				// saving updates the height map, so this must exclude readers too
+				c := chunks[i]
+				c.Lock()
+				if c.dirty {
+					saved[i] = true
+					errs[i] = world.saveChunk(c)
+				}
+				c.Unlock()
+				t.chunk(c.Level.XPos, c.Level.ZPos)
+			}
+			done <- true
+		}()
+	}
+	for n := 0; n < workers; n++ {
+		<-done
+	}
+	var failed, total int
+	var first os.Error
+	cancelled := false
+	for i := range chunks {
+		cancelled = cancelled || skipped[i]
+		if !saved[i] {
+			continue
+		}
+		total++
+		if errs[i] != nil {
+			failed++
+			if first == nil {
+				first = errs[i]
+			}
+		}
+	}
+	if failed > 0 {
+		return error.NewError(fmt.Sprintf("could not write %d of %d dirty chunks", failed, total), first)
+	}
+	if cancelled {
+		return t.cancelled()
+	}
+	return nil
+}
+
+func (world *World) saveChunk(c *Chunk) (err os.Error) {
+	chunkmap, err := fromChunk(c)
+	if err != nil {
+		return
+	}
+	if err = world.saveChunkMap(c.Level.XPos, c.Level.ZPos, "", chunkmap); err != nil {
+		return
+	}
+	c.dirty = false
+	return
 }
 
 func (world *World) verifyFormat() (err os.Error) {
@@ -127,12 +342,15 @@ func (world *World) verifyFormat() (err os.Error) {
 	// so if this world is in use by another process, things don't go terribly wrong.
 	fi, err := os.Stat(world.dir)
 	if err != nil {
+		if isNotExist(err) {
+			err = error.Wrap(ErrWorldNotFound, err)
+		}
 		err = error.NewError("could not stat world directory", err)
 		return
 	}
 
 	if !fi.IsDirectory() {
-		return error.NewError("expected a directory, didn't get one", nil)
+		return error.NewError("expected a directory, didn't get one", ErrWorldNotFound)
 	}
 	var hasLevelDat, hasSessionLock bool
 
@@ -154,11 +372,11 @@ func (world *World) verifyFormat() (err os.Error) {
 	}
 
 	if !hasLevelDat {
-		err = error.NewError(fmt.Sprint("world is missing ", leveldat), nil)
+		err = error.NewError(fmt.Sprint("world is missing ", leveldat), ErrWorldNotFound)
 		return
 	}
-	if !hasSessionLock {
-		err = error.NewError(fmt.Sprint("world is missing ", sessionlock), nil)
+	if !hasSessionLock && !world.config.CreateLockIfMissing {
+		err = error.NewError(fmt.Sprint("world is missing ", sessionlock), ErrWorldNotFound)
 		return
 	}
 	return
@@ -168,10 +386,21 @@ func (world *World) lock() (err os.Error) {
 	if world.lockfd != nil {
 		panic("lock fd already exists... should never happen")
 	}
+	if world.config.ReadOnly {
+		return
+	}
 	sessionLockPath := path.Join(world.dir, sessionlock)
-	world.lockfd, err = os.Open(sessionLockPath, os.O_RDWR|os.O_ASYNC, 0000)
+	flag := os.O_RDWR | os.O_ASYNC
+	if world.config.CreateLockIfMissing {
+		flag |= os.O_CREAT
+	}
+	world.lockfd, err = os.Open(sessionLockPath, flag, 0644)
 	if err != nil {
-		error.NewError(fmt.Sprint("could not open ", sessionlock), nil)
+		if isReadOnly(err) {
+			err = error.Wrap(ErrReadOnly, err)
+		}
+		err = error.NewError(fmt.Sprint("could not open ", sessionlock), err)
+		return
 	}
 	// minecraft's locking mechanism is peculiar.
 	// It writes the current system time in milliseconds since 1970 to the file.
@@ -193,10 +422,51 @@ func (world *World) lock() (err os.Error) {
 		err = error.NewError("could not write timestamp to session lock", err)
 		return
 	}
+	world.lockChecked = time.Nanoseconds()
 	return
 }
 
-func (world *World) verifyLock() (err os.Error) {
+// verifyLock checks that no other process has opened the world, trusting a
+// check made within the last LockCheckInterval.  A world opened ReadOnly has
+// no lock to lose.
+func (world *World) verifyLock() os.Error {
+	if world.config.ReadOnly {
+		return nil
+	}
+	world.lockMu.Lock()
+	defer world.lockMu.Unlock()
+	interval := world.LockCheckInterval
+	if interval == 0 {
+		interval = DefaultLockCheckInterval
+	}
+	now := time.Nanoseconds()
+	if interval > 0 && world.lockChecked != 0 && now-world.lockChecked < interval {
+		return nil
+	}
+	return world.readLock(now)
+}
+
+// VerifyLockNow checks that no other process has opened the world since it was
+// opened, by reading the session lock.  Flush and the other methods that write
+// to the world do so before writing, and so fail with ErrReadOnly if the world
+// was opened ReadOnly.
+func (world *World) VerifyLockNow() os.Error {
+	if world.config.ReadOnly {
+		return errReadOnly
+	}
+	world.lockMu.Lock()
+	defer world.lockMu.Unlock()
+	return world.readLock(time.Nanoseconds())
+}
+
+// readLock reads the session lock, noting that it was found to be ours at now.
+// The caller holds lockMu.
+func (world *World) readLock(now int64) (err os.Error) {
+	world.lockChecked = 0
+	world.lockReads++
+	if world.lockfd == nil {
+		return error.NewError("world is closed", ErrLockLost)
+	}
 	_, err = world.lockfd.Seek(0, 0)
 	if err != nil {
 		err = error.NewError("could not seek to beginning of session lock", err)
@@ -208,18 +478,27 @@ func (world *World) verifyLock() (err os.Error) {
 		return
 	}
 	if msec != world.lockmsec {
-		err = error.NewError("someone else has opened this world :(", nil)
+		err = error.NewError("someone else has opened this world :(", ErrLockLost)
 		return
 	}
+	world.lockChecked = now
 	return
 }
 
 func (world *World) unlock() os.Error {
-	return world.lockfd.Close()
+	world.lockMu.Lock()
+	defer world.lockMu.Unlock()
+	if world.lockfd == nil {
+		return nil
+	}
+	err := world.lockfd.Close()
+	world.lockfd = nil
+	return err
 }
 
-func (world *World) loadLevelDat(level map[string]interface{}) {
+func (world *World) loadLevelDat(level map[string]interface{}) (err os.Error) {
 	data := level["Data"].(map[string]interface{})
+	world.level = level
 	world.Data = Data{
 		SnowCovered: data["SnowCovered"].(int8),
 		Time:        data["Time"].(int64),
@@ -230,114 +509,263 @@ func (world *World) loadLevelDat(level map[string]interface{}) {
 		SizeOnDisk:  data["SizeOnDisk"].(int64),
 		RandomSeed:  data["RandomSeed"].(int64),
 	}
-}
-func posmod64(i int32) int32 {
-	if i < 0 {
-		i = 64 - i
+	if player, ok := data["Player"].(map[string]interface{}); ok {
+		if world.Data.Player, err = toPlayer(player); err != nil {
+			err = error.NewError("could not decode player", err)
+		}
 	}
-	return i % 64
+	return
 }
 
-func (world *World) LoadChunk(x int32, z int32) (err os.Error) {
-	if err = world.verifyLock(); err != nil {
-		return
+// fromLevelDat encodes Data over the level.dat that was read.
+func (world *World) fromLevelDat() map[string]interface{} {
+	level := copyTags(world.level)
+	data := copyTags(level["Data"].(map[string]interface{}))
+	data["SnowCovered"] = world.Data.SnowCovered
+	data["Time"] = world.Data.Time
+	data["SpawnX"] = world.Data.SpawnX
+	data["SpawnY"] = world.Data.SpawnY
+	data["SpawnZ"] = world.Data.SpawnZ
+	data["LastPlayed"] = world.Data.LastPlayed
+	data["SizeOnDisk"] = world.Data.SizeOnDisk
+	data["RandomSeed"] = world.Data.RandomSeed
+	if world.Data.Player != nil {
+		data["Player"] = fromPlayer(world.Data.Player)
+	} else {
+		data["Player"] = nil, false
 	}
+	level["Data"] = data
+	return level
+}
 
-	xz := MakeXZ(x, z)
-	if _, ok := world.Chunks[xz]; ok {
-		return // nothing to do
-	}
-	var px, pz = posmod64(x), posmod64(z)
-
-	chunkPath := path.Join(
-		world.dir,
-		int32ToBase36String(px),
-		int32ToBase36String(pz),
-		fmt.Sprint(
-			"c.",
-			int32ToBase36String(x),
-			".",
-			int32ToBase36String(z),
-			".dat"))
-
-	_, chunkmap, err := nbt.Load(chunkPath)
-	if err != nil {
-		err = error.NewError(fmt.Sprintf("could not load chunk (%d, %d)", x, z), err)
+// SaveLevel writes Data, including the single-player Player, to level.dat,
+// holding LockData as it does.
+func (world *World) SaveLevel() (err os.Error) {
+	if err = world.VerifyLockNow(); err != nil {
 		return
 	}
-	world.Chunks[xz] = toChunk(chunkmap)
+	world.dataMu.Lock()
+	defer world.dataMu.Unlock()
+	if err = nbt.Save(path.Join(world.dir, leveldat), "", world.fromLevelDat()); err != nil {
+		err = error.NewError(fmt.Sprint("could not save ", leveldat), err)
+	}
 	return
+}
 
+// ChunkExists reports whether the chunk at (x, z) is resident or stored.
+func (world *World) ChunkExists(x int32, z int32) bool {
+	if _, ok := world.resident(MakeXZ(x, z)); ok {
+		return true
+	}
+	return world.chunkOnDisk(x, z)
 }
 
-func toChunk(payload map[string]interface{}) *Chunk {
+// chunkOnDisk reports whether the chunk at (x, z) is stored.
+func (world *World) chunkOnDisk(x int32, z int32) bool {
+	return world.Store().Has(x, z)
+}
 
-	levmap := payload["Level"].(map[string]interface{})
-	return &Chunk{
-		Level: Level{
-			Blocks:           levmap["Blocks"].([]byte),
-			Data:             levmap["Data"].([]byte),
-			SkyLight:         levmap["SkyLight"].([]byte),
-			HeightMap:        levmap["HeightMap"].([]byte),
-			BlockLight:       levmap["BlockLight"].([]byte),
-			Entities:         toEntityList(levmap["Entities"].([]interface{})),
-			TileEntities:     levmap["TileEntities"].(interface{}),
-			LastUpdate:       levmap["LastUpdate"].(int64),
-			XPos:             levmap["xPos"].(int32),
-			ZPos:             levmap["xPos"].(int32),
-			TerrainPopulated: levmap["TerrainPopulated"].(int8),
-		},
+// ChunkModTime returns when the chunk at (x, z) was last written to the store,
+// in nanoseconds since the epoch.  Changes to a resident chunk that have yet to
+// be flushed are not counted.
+func (world *World) ChunkModTime(x int32, z int32) (int64, os.Error) {
+	mtime, err := world.Store().ModTime(x, z)
+	if err != nil {
+		return 0, error.InChunk(x, z).Error("could not find chunk", err)
 	}
+	return mtime, nil
 }
-func toEntityList(payload []interface{}) []*Entity {
-	entities := make([]*Entity, len(payload))
-	for i, e := range payload {
-		entities[i] = toEntity(e.(map[string]interface{}))
+
+// LoadChunk makes the chunk at (x, z) resident, reading it from the store, and
+// returns it.  A chunk already resident is returned as it is, without checking
+// the session lock; otherwise the lock is checked as LockCheckInterval allows
+// before the chunk is read.  It fails with ErrChunkNotFound if there is no such
+// chunk.
+//
+// LoadChunk is for loading chunks explicitly, ahead of their use, as
+// LoadChunksParallel loads many; GetChunk, which loads on demand, is for using
+// them.
+func (world *World) LoadChunk(x int32, z int32) (*Chunk, os.Error) {
+	if c, ok := world.resident(MakeXZ(x, z)); ok {
+		return c, nil
 	}
-	return entities
+	if err := world.verifyLock(); err != nil {
+		return nil, err
+	}
+	return world.load(x, z)
 }
 
-func toEntity(payload map[string]interface{}) *Entity {
-	xyz := payload["Pos"].([]interface{})       // FIXME
-	dxdydz := payload["Motion"].([]interface{}) // FIXME
-	rpy := payload["Rotation"].([]interface{})  // FIXME
-
-	ent := Entity{
-		Id:           payload["id"].(string),
-		OnGround:     payload["OnGround"].(int8),
-		Air:          payload["Air"].(int16),
-		Fire:         payload["Fire"].(int16),
-		FallDistance: payload["FallDistance"].(float32),
-		Physics: Physics{
-			Position{xyz[0].(float64), xyz[1].(float64), xyz[2].(float64)},
-			Velocity{dxdydz[0].(float64), dxdydz[1].(float64), dxdydz[2].(float64)},
-			Euler{0, rpy[1].(float32), rpy[0].(float32)},
-		},
+// ForEachChunk calls fn with each chunk resident when it is called, in no
+// particular order, holding the chunk's Lock so that fn may change it, and
+// stops at the first error fn returns.  Chunks made resident meanwhile by other
+// goroutines may or may not be visited.
+func (world *World) ForEachChunk(fn func(c *Chunk) os.Error) os.Error {
+	chunks := world.residentChunks()
+	t := world.begin("ForEachChunk", len(chunks))
+	for _, c := range chunks {
+		if err := t.cancelled(); err != nil {
+			return t.end(err)
+		}
+		c.Lock()
+		err := fn(c)
+		x, z := c.Level.XPos, c.Level.ZPos
+		c.Unlock()
+		if err != nil {
+			return t.end(err)
+		}
+		t.chunk(x, z)
 	}
+	return t.end(nil)
+}
 
-	// nullables
-	ihealth, ok := payload["Health"].(int16)
+// resident returns the resident chunk at xz, if there is one.
+func (world *World) resident(xz XZ) (c *Chunk, ok bool) {
+	world.mu.RLock()
+	c, ok = world.Chunks[xz]
+	world.mu.RUnlock()
 	if ok {
-		ent.Health = &ihealth
+		world.touch(c)
 	}
+	return
+}
 
-	iage, ok := payload["Age"].(int16)
-	if ok {
-		ent.Age = &iage
+// residentChunks returns the chunks resident when it is called.
+func (world *World) residentChunks() []*Chunk {
+	world.mu.RLock()
+	defer world.mu.RUnlock()
+	chunks := make([]*Chunk, 0, len(world.Chunks))
+	for _, c := range world.Chunks {
+		chunks = append(chunks, c)
 	}
+	return chunks
+}
 
-	itile, ok := payload["Tile"].(int16)
-	if ok {
-		ent.Tile = &itile
+// keep makes c resident at xz unless a chunk already is, and returns the chunk
+// that is.
+func (world *World) keep(xz XZ, c *Chunk) *Chunk {
+	world.mu.Lock()
+	defer world.mu.Unlock()
+	if resident, ok := world.Chunks[xz]; ok {
+		return resident
 	}
+	world.Chunks[xz] = c
+	world.touch(c)
+	return c
+}
 
-	iitem, ok := payload["Item"].(map[string]interface{})
-	if ok {
-		ent.Item = &Item{
-			Id:     iitem["id"].(int16),
-			Count:  iitem["Count"].(int8),
-			Damage: iitem["Damage"].(int16),
+// drop forgets the resident chunk at xz, if there is one, without saving it.
+func (world *World) drop(xz XZ) {
+	world.mu.Lock()
+	world.Chunks[xz] = nil, false
+	world.mu.Unlock()
+}
+
+// load makes the chunk at (x, z) resident, if it is not already, and returns
+// it.  Two goroutines loading the same chunk may both read it, but only the
+// first to finish keeps it.
+func (world *World) load(x int32, z int32) (*Chunk, os.Error) {
+	xz := MakeXZ(x, z)
+	if c, ok := world.resident(xz); ok {
+		return c, nil
+	}
+	c, err := world.loadChunkFile(x, z)
+	if err != nil {
+		return nil, err
+	}
+	return world.keep(xz, c), nil
+}
+
+func toChunk(payload map[string]interface{}) (*Chunk, os.Error) {
+	levmap, err := getCompound(payload, "Level")
+	if err != nil {
+		return nil, err
+	}
+	c, err := toChunkLevel(levmap)
+	if err != nil {
+		return nil, err
+	}
+	for _, array := range []struct {
+		name string
+		dst  *[]byte
+	}{
+		{"Blocks", &c.Level.Blocks},
+		{"Data", &c.Level.Data},
+		{"SkyLight", &c.Level.SkyLight},
+		{"HeightMap", &c.Level.HeightMap},
+		{"BlockLight", &c.Level.BlockLight},
+	} {
+		if *array.dst, err = getByteArray(levmap, array.name); err != nil {
+			return nil, err
 		}
 	}
-	return &ent
+	c.Warnings = append(c.Warnings, checkSpawnerBlocks(c)...)
+	return c, nil
+}
+
+// toChunkLevel decodes everything of a chunk's Level but its arrays.
+func toChunkLevel(levmap map[string]interface{}) (c *Chunk, err os.Error) {
+	var l Level
+	var entityList, tileEntityList []interface{}
+	if l.LastUpdate, err = getInt64(levmap, "LastUpdate"); err == nil {
+		l.XPos, err = getInt32(levmap, "xPos")
+	}
+	if err == nil {
+		l.ZPos, err = getInt32(levmap, "zPos")
+	}
+	if err == nil {
+		l.TerrainPopulated, err = getInt8(levmap, "TerrainPopulated")
+	}
+	if err == nil {
+		entityList, err = getList(levmap, "Entities")
+	}
+	if err == nil {
+		tileEntityList, err = getList(levmap, "TileEntities")
+	}
+	if err != nil {
+		return nil, err
+	}
+	return makeChunk(l, entityList, tileEntityList)
+}
+
+// makeChunk returns a chunk of l with the entities and tile entities decoded
+// from their tags.
+func makeChunk(l Level, entityList, tileEntityList []interface{}) (*Chunk, os.Error) {
+	entities, warnings, err := toEntityList(entityList)
+	if err != nil {
+		return nil, err
+	}
+	tileEntities, teWarnings := toTileEntityList(tileEntityList)
+	warnings = append(warnings, teWarnings...)
+	c := &Chunk{Warnings: warnings, Level: l}
+	c.Level.Entities, c.Level.TileEntities = entities, tileEntities
+	for _, te := range tileEntities {
+		te.tileEntityBase().chunk = c
+	}
+	return c, nil
+}
+
+// fromChunk encodes a chunk the way the game stores it, decoding its arrays
+// first if need be.
+func fromChunk(c *Chunk) (map[string]interface{}, os.Error) {
+	if err := c.LoadArrays(); err != nil {
+		return nil, err
+	}
+	if err := c.updateHeightMap(); err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{
+		"Level": map[string]interface{}{
+			"Blocks":           c.Level.Blocks,
+			"Data":             c.Level.Data,
+			"SkyLight":         c.Level.SkyLight,
+			"HeightMap":        c.Level.HeightMap,
+			"BlockLight":       c.Level.BlockLight,
+			"Entities":         fromEntityList(c.Level.Entities),
+			"TileEntities":     fromTileEntityList(c.Level.TileEntities),
+			"LastUpdate":       c.Level.LastUpdate,
+			"xPos":             c.Level.XPos,
+			"zPos":             c.Level.ZPos,
+			"TerrainPopulated": c.Level.TerrainPopulated,
+		},
+	}, nil
 }
@@ -1,6 +1,13 @@
 package world
 
+import "minecraft/nbt"
+
+import "io/ioutil"
+import "os"
+import "path"
+import "strings"
 import "testing"
+import "time"
 
 func TestWorld(t *testing.T) {
 	w, err := Open("/Users/roberthencke/Downloads/world/")
@@ -18,3 +25,473 @@ func TestWorld(t *testing.T) {
 	}
 
 }
+
+// testChunkPayload builds the decoded NBT of an empty chunk holding the given
+// entity and tile entity compounds.
+func testChunkPayload(x, z int32, entities []interface{}, tileEntities []interface{}) map[string]interface{} {
+	if entities == nil {
+		entities = []interface{}{}
+	}
+	if tileEntities == nil {
+		tileEntities = []interface{}{}
+	}
+	return map[string]interface{}{
+		"Level": map[string]interface{}{
+			"Blocks":           make([]byte, chunkBlocks),
+			"Data":             make([]byte, chunkNibbles),
+			"SkyLight":         make([]byte, chunkNibbles),
+			"HeightMap":        make([]byte, chunkColumns),
+			"BlockLight":       make([]byte, chunkNibbles),
+			"Entities":         entities,
+			"TileEntities":     tileEntities,
+			"LastUpdate":       int64(1234),
+			"xPos":             x,
+			"zPos":             z,
+			"TerrainPopulated": int8(1),
+		},
+	}
+}
+
+// makeTestWorld writes a minimal world directory holding the given chunk payloads
+// and returns its path.
+func makeTestWorld(t *testing.T, chunks ...map[string]interface{}) string {
+	dir, err := writeTestWorld(chunks...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func writeTestWorld(chunks ...map[string]interface{}) (dir string, err os.Error) {
+	if dir, err = ioutil.TempDir("", "world"); err != nil {
+		return
+	}
+	level := map[string]interface{}{
+		"Data": map[string]interface{}{
+			"SnowCovered": int8(0),
+			"Time":        int64(6000),
+			"SpawnX":      int32(8),
+			"SpawnY":      int32(64),
+			"SpawnZ":      int32(8),
+			"LastPlayed":  int64(1294000000000),
+			"SizeOnDisk":  int64(0),
+			"RandomSeed":  int64(42),
+		},
+	}
+	if err = nbt.Save(path.Join(dir, leveldat), "", level); err != nil {
+		return
+	}
+	if err = ioutil.WriteFile(path.Join(dir, sessionlock), make([]byte, 8), 0644); err != nil {
+		return
+	}
+	for _, c := range chunks {
+		if err = writeTestChunk(dir, c); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// mustChunk decodes a chunk payload known to be well formed.
+func mustChunk(payload map[string]interface{}) *Chunk {
+	c, err := toChunk(payload)
+	if err != nil {
+		panic(err)
+	}
+	return c
+}
+
+// mustFromChunk encodes c, panicking if its arrays cannot be decoded.
+func mustFromChunk(c *Chunk) map[string]interface{} {
+	payload, err := fromChunk(c)
+	if err != nil {
+		panic(err)
+	}
+	return payload
+}
+
+// writeTestChunk saves a chunk payload where a world in dir keeps it.
+func writeTestChunk(dir string, c map[string]interface{}) os.Error {
+	lev := c["Level"].(map[string]interface{})
+	chunkPath := chunkPath(dir, lev["xPos"].(int32), lev["zPos"].(int32))
+	if err := os.MkdirAll(path.Dir(chunkPath), 0755); err != nil {
+		return err
+	}
+	return nbt.Save(chunkPath, "", c)
+}
+
+func TestFlush(t *testing.T) {
+	dir := makeTestWorld(t,
+		testChunkPayload(0, 0, []interface{}{pigFixture}, []interface{}{chestFixture}),
+		testChunkPayload(-1, -1, nil, nil))
+	defer os.RemoveAll(dir)
+
+	w, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := w.GetChunk(0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = w.GetChunk(-1, -1); err != nil {
+		t.Fatal(err)
+	}
+	c.SetBlock(1, 2, 3, BlockStone, 0)
+	chest := c.Level.TileEntities[0].(*Chest)
+	chest.Slots[0].Item.Count = 12
+	w.Data.Time = 4242
+	if err = w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if c.Dirty() {
+		t.Error("chunk still dirty after Flush")
+	}
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	w, err = Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	c, err = w.GetChunk(0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id, _, _ := c.BlockAt(1, 2, 3); id != BlockStone {
+		t.Error("expected stone, got ", id)
+	}
+	if h := c.Level.HeightMap[1+3*ChunkWidth]; h != 3 {
+		t.Error("expected height 3, got ", h)
+	}
+	if n := c.Level.TileEntities[0].(*Chest).Slots[0].Item.Count; n != 12 {
+		t.Error("expected 12 items, got ", n)
+	}
+	if len(c.Level.Entities) != 1 || c.Level.Entities[0].Id != "Pig" {
+		t.Error("expected the pig to survive, got ", c.Level.Entities)
+	}
+	if c.Level.XPos != 0 || c.Level.ZPos != 0 {
+		t.Errorf("chunk claims to be at (%d, %d)", c.Level.XPos, c.Level.ZPos)
+	}
+	if w.Data.Time != 4242 {
+		t.Error("expected level.dat written by Flush, got time ", w.Data.Time)
+	}
+}
+
+func TestFlushWorkers(t *testing.T) {
+	dir := makeTestWorld(t)
+	defer os.RemoveAll(dir)
+	w, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	w.FlushWorkers = 3
+	for x := int32(0); x < 8; x++ {
+		if _, err = w.CreateChunk(x, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// chunk (70, 5) belongs in directory 6/5, which is made a file
+	blocked, err := w.CreateChunk(70, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = os.Mkdir(path.Join(dir, "6"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err = ioutil.WriteFile(path.Join(dir, "6", "5"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	err = w.Flush()
+	if err == nil || !strings.Contains(err.String(), "could not write 1 of 9 dirty chunks") {
+		t.Error("expected 1 of 9 chunks to fail, got ", err)
+	}
+	if !blocked.Dirty() {
+		t.Error("expected the chunk that failed to stay dirty")
+	}
+	for x := int32(0); x < 8; x++ {
+		if c := w.Chunks[MakeXZ(x, 0)]; c.Dirty() || !w.store.Has(x, 0) {
+			t.Errorf("expected chunk (%d, 0) written", x)
+		}
+	}
+	if err = os.Remove(path.Join(dir, "6", "5")); err != nil {
+		t.Fatal(err)
+	}
+	if err = w.Flush(); err != nil || blocked.Dirty() {
+		t.Error("expected the last chunk to be written, got ", err)
+	}
+}
+
+// benchmarkFlush flushes 2,000 dirty chunks with the given number of workers.
+func benchmarkFlush(b *testing.B, workers int) {
+	b.StopTimer()
+	dir, err := writeTestWorld()
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	w, err := Open(dir)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer w.Close()
+	w.FlushWorkers = workers
+	for x := int32(0); x < 40; x++ {
+		for z := int32(0); z < 50; z++ {
+			c, err := w.CreateChunk(x, z)
+			if err != nil {
+				b.Fatal(err)
+			}
+			for y := int32(0); y < 60; y++ {
+				c.SetBlock(x%ChunkWidth, y, z%ChunkDepth, BlockStone, 0)
+			}
+		}
+	}
+	for i := 0; i < b.N; i++ {
+		for _, c := range w.Chunks {
+			c.MarkDirty()
+		}
+		b.StartTimer()
+		if err = w.Flush(); err != nil {
+			b.Fatal(err)
+		}
+		b.StopTimer()
+	}
+}
+
+func BenchmarkFlush1(b *testing.B) { benchmarkFlush(b, 1) }
+func BenchmarkFlush4(b *testing.B) { benchmarkFlush(b, 4) }
+
+func TestChunkRoundTrip(t *testing.T) {
+	payload := testChunkPayload(-7, 12,
+		[]interface{}{pigFixture},
+		[]interface{}{chestFixture, signFixture, furnaceFixture, spawnerFixture})
+	if encoded := mustFromChunk(mustChunk(payload)); !nbt.Equal(encoded, payload) {
+		t.Error("expected ", payload, ", got ", encoded)
+	}
+}
+
+func TestUnknownTagsSurviveFlush(t *testing.T) {
+	modData := map[string]interface{}{
+		"Owner":  "notch",
+		"Levels": []interface{}{int32(1), int32(2), int32(3)},
+	}
+	pig := make(map[string]interface{})
+	for k, v := range pigFixture {
+		pig[k] = v
+	}
+	pig["ModData"] = modData
+	chest := tileEntityCompound("Chest", 1, 64, 1, map[string]interface{}{
+		"Items":   []interface{}{itemCompound(0, 276, 1, 0)},
+		"ModData": modData,
+	})
+	chest["Items"].([]interface{})[0].(map[string]interface{})["tag"] = modData
+	payload := testChunkPayload(0, 0, []interface{}{pig}, []interface{}{chest})
+	dir := makeTestWorld(t, payload)
+	defer os.RemoveAll(dir)
+
+	w, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := w.GetChunk(0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Level.Entities[0].Health = nil
+	c.Level.TileEntities[0].(*Chest).Slots[0].Item.Count = 2
+	c.MarkDirty()
+	if err = w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+
+	_, saved, err := nbt.Load(chunkPath(w.dir, 0, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	level := saved["Level"].(map[string]interface{})
+	savedPig := level["Entities"].([]interface{})[0].(map[string]interface{})
+	if !nbt.Equal(savedPig["ModData"], modData) {
+		t.Error("entity lost ModData, got ", savedPig)
+	}
+	if _, ok := savedPig["Health"]; ok {
+		t.Error("cleared Health was written back")
+	}
+	savedChest := level["TileEntities"].([]interface{})[0].(map[string]interface{})
+	if !nbt.Equal(savedChest["ModData"], modData) {
+		t.Error("chest lost ModData, got ", savedChest)
+	}
+	savedItem := savedChest["Items"].([]interface{})[0].(map[string]interface{})
+	if !nbt.Equal(savedItem["tag"], modData) || savedItem["Count"] != int8(2) {
+		t.Error("item lost its tag or count, got ", savedItem)
+	}
+}
+
+func TestLockCheckInterval(t *testing.T) {
+	dir := makeTestWorld(t, testChunkPayload(0, 0, nil, nil), testChunkPayload(1, 0, nil, nil))
+	defer os.RemoveAll(dir)
+	w, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	if _, err = w.LoadChunk(0, 0); err != nil {
+		t.Fatal(err)
+	}
+	if w.lockReads != 0 {
+		t.Error("expected the check made on opening to be trusted, got reads ", w.lockReads)
+	}
+	w.LockCheckInterval = -1
+	for i := 0; i < 3; i++ {
+		w.Chunks = make(map[XZ]*Chunk)
+		if _, err = w.LoadChunk(int32(i%2), 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if w.lockReads != 3 {
+		t.Error("expected every load to read the lock, got reads ", w.lockReads)
+	}
+	c, err := w.LoadChunk(0, 0)
+	if err != nil || c != w.Chunks[MakeXZ(0, 0)] {
+		t.Fatalf("expected the resident chunk returned, got %v (%v)", c, err)
+	}
+	if w.lockReads != 3 {
+		t.Error("expected a resident chunk returned without reading the lock, got reads ", w.lockReads)
+	}
+
+	// another process opens the world
+	w.LockCheckInterval = 0
+	if err = ioutil.WriteFile(path.Join(dir, sessionlock), make([]byte, 8), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = w.GetChunk(1, 0); err != nil {
+		t.Error("expected the last check to be trusted, got ", err)
+	}
+	if err = w.VerifyLockNow(); err == nil {
+		t.Error("expected the lock to be lost")
+	}
+	if _, err = w.LoadChunk(0, 0); err != nil {
+		t.Error("expected the resident chunk whatever the lock, got ", err)
+	}
+	w.Chunks = make(map[XZ]*Chunk)
+	if _, err = w.LoadChunk(0, 0); err == nil {
+		t.Error("expected a failed check not to be trusted")
+	}
+	w.lockChecked = time.Nanoseconds()
+	if err = w.Flush(); err == nil {
+		t.Error("expected Flush to check the lock itself")
+	}
+}
+
+// benchmarkLockChecks loads each of 5,000 chunks in turn, checking the session
+// lock every interval, and reports how often it was read.
+func benchmarkLockChecks(b *testing.B, interval int64) {
+	b.StopTimer()
+	dir, err := writeTestWorld()
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for x := int32(0); x < 100; x++ {
+		for z := int32(0); z < 50; z++ {
+			if err = writeTestChunk(dir, testChunkPayload(x, z, nil, nil)); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+	w, err := Open(dir)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer w.Close()
+	w.LockCheckInterval = interval
+	b.StartTimer()
+
+	for i := 0; i < b.N; i++ {
+		for x := int32(0); x < 100; x++ {
+			for z := int32(0); z < 50; z++ {
+				if _, err = w.LoadChunk(x, z); err != nil {
+					b.Fatal(err)
+				}
+				w.Chunks[MakeXZ(x, z)] = nil, false
+			}
+		}
+	}
+	b.Logf("read the session lock %d times for %d loads", w.lockReads, b.N*5000)
+}
+
+func BenchmarkLoadChunkStrictLock(b *testing.B) { benchmarkLockChecks(b, -1) }
+func BenchmarkLoadChunkCachedLock(b *testing.B) { benchmarkLockChecks(b, 0) }
+
+// xzCoords are coordinates at and either side of the boundaries of int32 and
+// of the low bits of x that MakeXZ could once carry out of.
+var xzCoords = []int32{-2147483648, -2147483647, -65536, -65535, -257, -256, -2, -1, 0, 1, 2, 255, 256, 65535, 65536, 2147483646, 2147483647}
+
+func TestMakeXZ(t *testing.T) {
+	seen := make(map[XZ][2]int32)
+	for _, x := range xzCoords {
+		for _, z := range xzCoords {
+			xz := MakeXZ(x, z)
+			if gx, gz := SplitXZ(xz); gx != x || gz != z {
+				t.Errorf("expected (%d, %d), got (%d, %d)", x, z, gx, gz)
+			}
+			if prev, ok := seen[xz]; ok {
+				t.Errorf("(%d, %d) and %v share key %d", x, z, prev, xz)
+			}
+			seen[xz] = [2]int32{x, z}
+			if uint32(xz) != uint32(x) || int32(int64(xz)>>32) != z {
+				t.Errorf("expected x in the low bits and z in the high of %x", uint64(xz))
+			}
+		}
+	}
+	for _, c := range []struct {
+		x, z int32
+		xz   XZ
+	}{
+		{0, 0, 0},
+		{-1, 0, 0xffffffff},
+		{0, -1, -1 << 32},
+		{-1, -1, -1},
+		{1, -1, -1<<32 | 1},
+	} {
+		if xz := MakeXZ(c.x, c.z); xz != c.xz {
+			t.Errorf("(%d, %d): expected %x, got %x", c.x, c.z, uint64(c.xz), uint64(xz))
+		}
+	}
+}
+
+// TestNegativeChunkLookups makes chunks resident in every quadrant and around
+// the origin and checks that each is found where it belongs.
+func TestNegativeChunkLookups(t *testing.T) {
+	w := &World{Chunks: make(map[XZ]*Chunk)}
+	coords := []int32{-65, -64, -2, -1, 0, 1, 63, 64}
+	for _, x := range coords {
+		for _, z := range coords {
+			w.keep(MakeXZ(x, z), newChunk(x, z))
+		}
+	}
+	if len(w.Chunks) != len(coords)*len(coords) {
+		t.Fatalf("expected %d resident chunks, got %d", len(coords)*len(coords), len(w.Chunks))
+	}
+	for _, x := range coords {
+		for _, z := range coords {
+			c, err := w.GetChunk(x, z)
+			if err != nil {
+				t.Error(err)
+			} else if c.Level.XPos != x || c.Level.ZPos != z {
+				t.Errorf("expected chunk (%d, %d), got (%d, %d)", x, z, c.Level.XPos, c.Level.ZPos)
+			}
+		}
+	}
+	for xz, c := range w.Chunks {
+		if x, z := SplitXZ(xz); x != c.Level.XPos || z != c.Level.ZPos {
+			t.Errorf("key %x holds chunk (%d, %d)", uint64(xz), c.Level.XPos, c.Level.ZPos)
+		}
+	}
+}
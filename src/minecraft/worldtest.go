@@ -0,0 +1,300 @@
+// Package worldtest builds disposable worlds for tests: flat, with a chest,
+// entities and a torch where asked, and the same for the same Options every
+// time, so that golden images and diffs of them are reproducible.
+package worldtest
+
+import "minecraft/error"
+import "minecraft/nbt"
+import "minecraft/world"
+
+import "bytes"
+import "compress/gzip"
+import "fmt"
+import "io/ioutil"
+import "os"
+import "path"
+import "rand"
+
+// A TB is what NewTestWorld needs of a *testing.T or *testing.B.
+type TB interface {
+	Fatal(args ...interface{})
+}
+
+// Options describes the world NewTestWorld builds.  The zero Options is a flat
+// Alpha world of 2 by 2 chunks, with seed 0, ground at y=63 and the spawn above
+// the middle of chunk (0, 0).
+type Options struct {
+	Seed                   int64         // level.dat's RandomSeed, and of everything placed at random
+	SpawnX, SpawnY, SpawnZ int32         // zero for the default
+	Region                 *world.Region // the chunks to generate; nil means (0, 0) to (1, 1)
+	Format                 world.Format  // how the chunks are stored
+	Ground                 int32         // the y of the grass; zero means 63
+
+	// Chest, if set, is a chest placed at its block coordinates, which must lie
+	// in Region.
+	Chest *Chest
+	// Entities are the ids of entities to place, one for each, standing on
+	// the ground at positions chosen by Seed.  Mobs get 10 health.
+	Entities []string
+	// Torch, if set, is where a torch is placed.  BlockLight is zero
+	// throughout, as the game leaves a world until it relights it, so tests of
+	// light relight it.
+	Torch *Block
+}
+
+// Block is a block's absolute coordinates.
+type Block struct {
+	X, Y, Z int32
+}
+
+// A Chest is the chest Options places.  Each item fills the slot of its index.
+type Chest struct {
+	Block
+	Items []world.Item
+}
+
+// Ground is the default height of the grass.
+const Ground = 63
+
+// NewTestWorld builds the world opts describes in a temporary directory, opens
+// it and returns it, with a function that closes it and removes the directory.
+// Below the grass are three layers of dirt, then stone down to bedrock at y=0;
+// about one stone block in 50 is gravel, chosen by Seed and the chunk's
+// coordinates, so that chunks differ.  Above the ground the sky light is full.
+// Problems building it are reported with tb.Fatal.
+func NewTestWorld(tb TB, opts Options) (w *world.World, cleanup func()) {
+	dir, err := ioutil.TempDir("", "worldtest")
+	if err != nil {
+		tb.Fatal(err)
+	}
+	if err = build(dir, opts); err != nil {
+		os.RemoveAll(dir)
+		tb.Fatal(err)
+	}
+	if w, err = world.Open(dir); err != nil {
+		os.RemoveAll(dir)
+		tb.Fatal(err)
+	}
+	return w, func() {
+		w.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+// build writes the world opts describes into dir.
+func build(dir string, opts Options) os.Error {
+	region := opts.Region
+	if region == nil {
+		region = world.NewRegion(0, 0, 1, 1)
+	}
+	ground := opts.Ground
+	if ground == 0 {
+		ground = Ground
+	}
+	if ground < 4 || ground >= world.ChunkHeight-1 {
+		return error.NewError(fmt.Sprintf("ground at y=%d leaves no room", ground), nil)
+	}
+	spawnX, spawnY, spawnZ := opts.SpawnX, opts.SpawnY, opts.SpawnZ
+	if spawnX == 0 && spawnY == 0 && spawnZ == 0 {
+		spawnX, spawnY, spawnZ = 8, ground+1, 8
+	}
+
+	level := map[string]interface{}{
+		"Data": map[string]interface{}{
+			"SnowCovered": int8(0),
+			"Time":        int64(6000),
+			"SpawnX":      spawnX,
+			"SpawnY":      spawnY,
+			"SpawnZ":      spawnZ,
+			"LastPlayed":  int64(1294000000000),
+			"SizeOnDisk":  int64(0),
+			"RandomSeed":  opts.Seed,
+		},
+	}
+	if err := nbt.Save(path.Join(dir, "level.dat"), "", level); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(path.Join(dir, "session.lock"), make([]byte, 8), 0644); err != nil {
+		return err
+	}
+
+	chunks := make(map[world.XZ]*chunk)
+	for x := region.MinX; x <= region.MaxX; x++ {
+		for z := region.MinZ; z <= region.MaxZ; z++ {
+			chunks[world.MakeXZ(x, z)] = flatChunk(x, z, ground, opts.Seed)
+		}
+	}
+	at := func(b Block) (*chunk, os.Error) {
+		c, ok := chunks[world.MakeXZ(b.X>>4, b.Z>>4)]
+		if !ok || b.Y < 0 || b.Y >= world.ChunkHeight {
+			return nil, error.NewError(fmt.Sprintf("block (%d, %d, %d) is outside the region", b.X, b.Y, b.Z), nil)
+		}
+		return c, nil
+	}
+	if opts.Chest != nil {
+		c, err := at(opts.Chest.Block)
+		if err != nil {
+			return err
+		}
+		c.setBlock(opts.Chest.Block, world.BlockChest)
+		c.tileEntities = append(c.tileEntities, chestCompound(opts.Chest))
+	}
+	if opts.Torch != nil {
+		c, err := at(*opts.Torch)
+		if err != nil {
+			return err
+		}
+		c.setBlock(*opts.Torch, world.BlockTorch)
+	}
+	r := rand.New(rand.NewSource(opts.Seed))
+	for _, id := range opts.Entities {
+		b := Block{
+			region.MinX*world.ChunkWidth + int32(r.Int63()%int64(region.Width()*world.ChunkWidth)),
+			ground + 1,
+			region.MinZ*world.ChunkDepth + int32(r.Int63()%int64(region.Depth()*world.ChunkDepth)),
+		}
+		c, _ := at(b)
+		c.entities = append(c.entities, entityCompound(id, float64(b.X)+0.5, float64(b.Y), float64(b.Z)+0.5))
+	}
+
+	// in order, so that region files come out the same
+	store := world.NewStore(dir, opts.Format)
+	for x := region.MinX; x <= region.MaxX; x++ {
+		for z := region.MinZ; z <= region.MaxZ; z++ {
+			payload, err := chunks[world.MakeXZ(x, z)].encode()
+			if err != nil {
+				return err
+			}
+			if err = store.Write(x, z, payload); err != nil {
+				return error.InChunk(x, z).Error("could not write chunk", err)
+			}
+		}
+	}
+	return nil
+}
+
+// chunk is a chunk being built, in the arrays of its Level.
+type chunk struct {
+	x, z                     int32
+	blocks, skyLight, height []byte
+	entities, tileEntities   []interface{}
+}
+
+// index is where block (x, y, z) of a chunk lies in its arrays: y varies
+// fastest, then z, then x.
+func index(x, y, z int32) int {
+	return int(y + z*world.ChunkHeight + x*world.ChunkHeight*world.ChunkDepth)
+}
+
+// flatChunk returns chunk (cx, cz) with its ground at y=ground.
+func flatChunk(cx, cz, ground int32, seed int64) *chunk {
+	blocks := world.ChunkWidth * world.ChunkDepth * world.ChunkHeight
+	c := &chunk{
+		x: cx, z: cz,
+		blocks:   make([]byte, blocks),
+		skyLight: make([]byte, blocks/2),
+		height:   make([]byte, world.ChunkWidth*world.ChunkDepth),
+	}
+	r := rand.New(rand.NewSource(seed ^ int64(cx)<<32 ^ int64(uint32(cz))))
+	for x := int32(0); x < world.ChunkWidth; x++ {
+		for z := int32(0); z < world.ChunkDepth; z++ {
+			c.blocks[index(x, 0, z)] = world.BlockBedrock
+			for y := int32(1); y < ground; y++ {
+				id := byte(world.BlockStone)
+				if y >= ground-3 {
+					id = world.BlockDirt
+				} else if r.Int63()%50 == 0 {
+					id = world.BlockGravel
+				}
+				c.blocks[index(x, y, z)] = id
+			}
+			c.blocks[index(x, ground, z)] = world.BlockGrass
+			for y := ground + 1; y < world.ChunkHeight; y++ {
+				i := index(x, y, z)
+				c.skyLight[i>>1] |= 15 << uint(i&1*4)
+			}
+			c.height[x+z*world.ChunkWidth] = byte(ground + 1)
+		}
+	}
+	return c
+}
+
+// setBlock sets block b, which lies in c, to id.  Chests and torches let the
+// sky through, so the sky light and height map stand.
+func (c *chunk) setBlock(b Block, id byte) {
+	c.blocks[index(b.X&(world.ChunkWidth-1), b.Y, b.Z&(world.ChunkDepth-1))] = id
+}
+
+// encode returns the chunk's payload, as a ChunkStore keeps it.
+func (c *chunk) encode() ([]byte, os.Error) {
+	nibbles := len(c.blocks) / 2
+	chunkmap := map[string]interface{}{
+		"Level": map[string]interface{}{
+			"Blocks":           c.blocks,
+			"Data":             make([]byte, nibbles),
+			"SkyLight":         c.skyLight,
+			"HeightMap":        c.height,
+			"BlockLight":       make([]byte, nibbles),
+			"Entities":         append([]interface{}{}, c.entities...),
+			"TileEntities":     append([]interface{}{}, c.tileEntities...),
+			"LastUpdate":       int64(0),
+			"xPos":             c.x,
+			"zPos":             c.z,
+			"TerrainPopulated": int8(1),
+		},
+	}
+	buf := bytes.NewBuffer([]byte{byte(world.CompressGzip)})
+	gz, err := gzip.NewWriter(buf)
+	if err != nil {
+		return nil, err
+	}
+	if err = nbt.WriteTagCompound(gz, "", chunkmap); err != nil {
+		return nil, err
+	}
+	if err = gz.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func chestCompound(chest *Chest) map[string]interface{} {
+	items := []interface{}{}
+	for i, item := range chest.Items {
+		items = append(items, map[string]interface{}{
+			"Slot":   int8(i),
+			"id":     item.Id,
+			"Count":  item.Count,
+			"Damage": item.Damage,
+		})
+	}
+	return map[string]interface{}{
+		"id":    "Chest",
+		"x":     chest.X,
+		"y":     chest.Y,
+		"z":     chest.Z,
+		"Items": items,
+	}
+}
+
+// mobs are the entities that get health.
+var mobs = map[string]bool{
+	"Pig": true, "Sheep": true, "Cow": true, "Chicken": true, "Wolf": true,
+	"Zombie": true, "Skeleton": true, "Spider": true, "Creeper": true, "Slime": true,
+}
+
+func entityCompound(id string, x, y, z float64) map[string]interface{} {
+	e := map[string]interface{}{
+		"id":           id,
+		"Pos":          []interface{}{x, y, z},
+		"Motion":       []interface{}{float64(0), float64(0), float64(0)},
+		"Rotation":     []interface{}{float32(0), float32(0)},
+		"FallDistance": float32(0),
+		"Fire":         int16(-1),
+		"Air":          int16(300),
+		"OnGround":     int8(1),
+	}
+	if mobs[id] {
+		e["Health"] = int16(10)
+	}
+	return e
+}
@@ -0,0 +1,109 @@
+package worldtest
+
+import "minecraft/world"
+
+import "bytes"
+import "testing"
+
+var testOptions = Options{
+	Seed:     1234,
+	SpawnX:   20,
+	SpawnY:   70,
+	SpawnZ:   -3,
+	Region:   world.NewRegion(-1, -1, 1, 0),
+	Chest:    &Chest{Block{-5, Ground + 1, 3}, []world.Item{{Id: 4, Count: 64}, {Id: 264, Count: 3}}},
+	Entities: []string{"Pig", "Pig", "Sheep"},
+	Torch:    &Block{2, Ground + 1, -2},
+}
+
+func TestNewTestWorld(t *testing.T) {
+	for _, format := range []world.Format{world.FormatAlpha, world.FormatMcRegion} {
+		opts := testOptions
+		opts.Format = format
+		w, cleanup := NewTestWorld(t, opts)
+		defer cleanup()
+
+		if w.Format() != format {
+			t.Errorf("%v: expected the world opened as %v, got %v", format, format, w.Format())
+		}
+		if x, y, z := w.Spawn(); w.Seed() != 1234 || x != 20 || y != 70 || z != -3 {
+			t.Errorf("%v: expected level.dat as given, got seed %d and spawn (%d, %d, %d)", format, w.Seed(), x, y, z)
+		}
+		if coords, err := w.ListChunks(nil); err != nil || len(coords) != 6 {
+			t.Errorf("%v: expected 6 chunks, got %v (%v)", format, coords, err)
+		}
+		for _, b := range []struct {
+			y  int32
+			id byte
+		}{{0, world.BlockBedrock}, {Ground - 1, world.BlockDirt}, {Ground, world.BlockGrass}, {Ground + 1, world.BlockAir}} {
+			if id, _, err := w.BlockAt(-7, b.y, 9); err != nil || id != b.id {
+				t.Errorf("%v: expected block %d at y=%d, got %d (%v)", format, b.id, b.y, id, err)
+			}
+		}
+		if id, _, _ := w.BlockAt(2, Ground+1, -2); id != world.BlockTorch {
+			t.Errorf("%v: expected the torch, got %d", format, id)
+		}
+
+		c, err := w.LoadChunk(-1, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if id, _, _ := w.BlockAt(-5, Ground+1, 3); id != world.BlockChest || len(c.Level.TileEntities) != 1 {
+			t.Fatalf("%v: expected the chest, got block %d and %v", format, id, c.Level.TileEntities)
+		}
+		chest, ok := c.Level.TileEntities[0].(*world.Chest)
+		if !ok || chest.X() != -5 || len(chest.Slots) != 2 || chest.Slots[1].Slot != 1 || chest.Slots[1].Item.Id != 264 {
+			t.Errorf("%v: expected the chest's items, got %v", format, c.Level.TileEntities[0])
+		}
+		if sky, _ := c.SkyLightAt(0, Ground+1, 0); sky != 15 {
+			t.Errorf("%v: expected full sky light above the ground, got %d", format, sky)
+		}
+
+		pigs, err := w.FindEntities("Pig", nil)
+		if err != nil || len(pigs) != 2 {
+			t.Errorf("%v: expected 2 pigs, got %v (%v)", format, pigs, err)
+		}
+		for _, ref := range pigs {
+			if p := ref.Entity.Physics.Position; p.Y != Ground+1 || ref.Entity.Health == nil {
+				t.Errorf("%v: expected a healthy pig on the ground, got %+v", format, ref.Entity)
+			}
+		}
+	}
+}
+
+// render returns the PNG of a slice through the stone of a world built from
+// opts.
+func render(t *testing.T, opts Options) []byte {
+	w, cleanup := NewTestWorld(t, opts)
+	defer cleanup()
+	var buf bytes.Buffer
+	if err := w.RenderSlice(&buf, nil, 30, world.SliceOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestDeterministic(t *testing.T) {
+	a := render(t, testOptions)
+	if b := render(t, testOptions); !bytes.Equal(a, b) {
+		t.Error("expected the same world from the same options")
+	}
+	opts := testOptions
+	opts.Seed++
+	if b := render(t, opts); bytes.Equal(a, b) {
+		t.Error("expected another seed to place the gravel elsewhere")
+	}
+
+	pig := func(seed int64) world.Position {
+		w, cleanup := NewTestWorld(t, Options{Seed: seed, Entities: []string{"Pig"}})
+		defer cleanup()
+		pigs, err := w.FindEntities("Pig", nil)
+		if err != nil || len(pigs) != 1 {
+			t.Fatalf("expected a pig, got %v (%v)", pigs, err)
+		}
+		return pigs[0].Entity.Physics.Position
+	}
+	if a, b := pig(7), pig(7); a.X != b.X || a.Z != b.Z {
+		t.Errorf("expected the pig placed alike, got %v and %v", a, b)
+	}
+}
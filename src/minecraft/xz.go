@@ -0,0 +1,101 @@
+package world
+
+import "fmt"
+import "math"
+import "sort"
+
+// regionShift is the log2 of the width, in chunks, of the regions SortXZ groups
+// chunks by: 32 by 32, as the region files of later versions of the game hold
+// them.
+const regionShift = 5
+
+// X returns the chunk's x coordinate, as SplitXZ does.
+func (xz XZ) X() int32 {
+	x, _ := SplitXZ(xz)
+	return x
+}
+
+// Z returns the chunk's z coordinate, as SplitXZ does.
+func (xz XZ) Z() int32 {
+	_, z := SplitXZ(xz)
+	return z
+}
+
+// String formats xz as its coordinates, as in "(12,-7)".
+func (xz XZ) String() string {
+	x, z := SplitXZ(xz)
+	return formatXZ(x, z)
+}
+
+func formatXZ(x, z int32) string {
+	return fmt.Sprintf("(%d,%d)", x, z)
+}
+
+// Offset returns the key of the chunk dx chunks east and dz south of xz.
+// Coordinates wrap around at the limits of int32.
+func (xz XZ) Offset(dx, dz int32) XZ {
+	x, z := SplitXZ(xz)
+	return MakeXZ(x+dx, z+dz)
+}
+
+// DistanceTo returns how far the chunk of other is from xz's, in chunks: the
+// Chebyshev distance, the larger of the differences of their coordinates and
+// so the number of rings of chunks between them, and the Euclidean.
+func (xz XZ) DistanceTo(other XZ) (chebyshev int64, euclidean float64) {
+	x1, z1 := SplitXZ(xz)
+	x2, z2 := SplitXZ(other)
+	dx, dz := abs64(int64(x2)-int64(x1)), abs64(int64(z2)-int64(z1))
+	chebyshev = dx
+	if dz > dx {
+		chebyshev = dz
+	}
+	return chebyshev, math.Sqrt(float64(dx)*float64(dx) + float64(dz)*float64(dz))
+}
+
+func abs64(i int64) int64 {
+	if i < 0 {
+		return -i
+	}
+	return i
+}
+
+// xzOrder sorts keys for SortXZ.
+type xzOrder []XZ
+
+func (s xzOrder) Len() int           { return len(s) }
+func (s xzOrder) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s xzOrder) Less(i, j int) bool { return xzLess(s[i], s[j]) }
+
+// xzLess reports whether SortXZ puts a before b.
+func xzLess(a, b XZ) bool {
+	xi, zi := SplitXZ(a)
+	xj, zj := SplitXZ(b)
+	if ri, rj := xi>>regionShift, xj>>regionShift; ri != rj {
+		return ri < rj
+	}
+	if ri, rj := zi>>regionShift, zj>>regionShift; ri != rj {
+		return ri < rj
+	}
+	if zi != zj {
+		return zi < zj
+	}
+	return xi < xj
+}
+
+// SortXZ sorts keys by the 32 by 32 region of chunks each falls in, west to
+// east and then north to south, and within a region by z and then x, the order
+// a region file keeps its chunks in.  Chunks near one another on disk are
+// visited together.
+func SortXZ(keys []XZ) {
+	sort.Sort(xzOrder(keys))
+}
+
+// XZ returns the key of the chunk at c.
+func (c ChunkCoord) XZ() XZ {
+	return MakeXZ(c.X, c.Z)
+}
+
+// String formats c as XZ does, as in "(12,-7)".
+func (c ChunkCoord) String() string {
+	return formatXZ(c.X, c.Z)
+}
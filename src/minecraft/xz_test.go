@@ -0,0 +1,115 @@
+package world
+
+import "fmt"
+import "math"
+import "rand"
+import "testing"
+
+// checkXZ checks that the methods of MakeXZ(x, z) agree with SplitXZ and give
+// back x and z.
+func checkXZ(t *testing.T, x, z int32) {
+	xz := MakeXZ(x, z)
+	sx, sz := SplitXZ(xz)
+	if xz.X() != x || xz.Z() != z || sx != x || sz != z {
+		t.Errorf("expected (%d, %d), got X %d Z %d and SplitXZ (%d, %d)", x, z, xz.X(), xz.Z(), sx, sz)
+	}
+	if MakeXZ(xz.X(), xz.Z()) != xz {
+		t.Errorf("(%d, %d): expected the key made again from X and Z the same", x, z)
+	}
+	if s := xz.String(); s != fmt.Sprintf("(%d,%d)", x, z) || s != (ChunkCoord{x, z}).String() {
+		t.Errorf("(%d, %d): unexpected %q", x, z, s)
+	}
+	if (ChunkCoord{x, z}).XZ() != xz {
+		t.Errorf("(%d, %d): expected ChunkCoord's key to be MakeXZ's", x, z)
+	}
+}
+
+func TestXZMethods(t *testing.T) {
+	for _, x := range xzCoords {
+		for _, z := range xzCoords {
+			checkXZ(t, x, z)
+		}
+	}
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 100000; i++ {
+		n := r.Int63()
+		checkXZ(t, int32(n), int32(n>>31))
+	}
+	if s := fmt.Sprint(MakeXZ(12, -7)); s != "(12,-7)" {
+		t.Errorf("expected (12,-7), got %s", s)
+	}
+}
+
+func TestXZOffset(t *testing.T) {
+	for _, x := range xzCoords {
+		for _, z := range xzCoords {
+			for _, d := range [][2]int32{{0, 0}, {1, 0}, {0, 1}, {-1, -1}, {64, -64}} {
+				got := MakeXZ(x, z).Offset(d[0], d[1])
+				if want := MakeXZ(x+d[0], z+d[1]); got != want {
+					t.Errorf("(%d, %d) offset by %v: expected %v, got %v", x, z, d, want, got)
+				}
+			}
+		}
+	}
+	if got := MakeXZ(0, 0).Offset(-1, 0); got != MakeXZ(-1, 0) || got.X() != -1 || got.Z() != 0 {
+		t.Errorf("expected x to go negative without borrowing from z, got %v", got)
+	}
+}
+
+func TestXZDistance(t *testing.T) {
+	for _, c := range []struct {
+		a, b      XZ
+		chebyshev int64
+		euclidean float64
+	}{
+		{MakeXZ(0, 0), MakeXZ(0, 0), 0, 0},
+		{MakeXZ(0, 0), MakeXZ(3, -4), 4, 5},
+		{MakeXZ(-1, -1), MakeXZ(1, 1), 2, math.Sqrt(8)},
+		{MakeXZ(-2147483648, 0), MakeXZ(2147483647, 0), 4294967295, 4294967295},
+	} {
+		for _, swap := range []bool{false, true} {
+			a, b := c.a, c.b
+			if swap {
+				a, b = b, a
+			}
+			cheb, eucl := a.DistanceTo(b)
+			if cheb != c.chebyshev || math.Fabs(eucl-c.euclidean) > 1e-9 {
+				t.Errorf("%v to %v: expected %d and %g, got %d and %g", a, b, c.chebyshev, c.euclidean, cheb, eucl)
+			}
+		}
+	}
+}
+
+func TestSortXZ(t *testing.T) {
+	keys := []XZ{
+		MakeXZ(32, 0), MakeXZ(0, 32), MakeXZ(31, 31), MakeXZ(-1, 0),
+		MakeXZ(0, 0), MakeXZ(1, 0), MakeXZ(0, 1), MakeXZ(-32, -1),
+	}
+	SortXZ(keys)
+	want := []XZ{
+		MakeXZ(-32, -1), // region (-1, -1)
+		MakeXZ(-1, 0),   // region (-1, 0)
+		MakeXZ(0, 0),    // region (0, 0), by z and then x
+		MakeXZ(1, 0),
+		MakeXZ(0, 1),
+		MakeXZ(31, 31),
+		MakeXZ(0, 32), // region (0, 1)
+		MakeXZ(32, 0), // region (1, 0)
+	}
+	for i := range want {
+		if keys[i] != want[i] {
+			t.Errorf("expected %v, got %v", want, keys)
+			break
+		}
+	}
+}
+
+func TestChunkDiffString(t *testing.T) {
+	d := ChunkDiff{X: 12, Z: -7, Status: ChunkChanged, Blocks: 3, Moved: make([]EntityMove, 2)}
+	if s := d.String(); s != "(12,-7) changed: 3 blocks, entities +0 -0 ~2, tile entities +0 -0 ~0" {
+		t.Errorf("unexpected %q", s)
+	}
+	if s := fmt.Sprint(ChunkDiff{X: 0, Z: 1, Status: ChunkOnlyInB}); s != "(0,1) only in b" {
+		t.Errorf("unexpected %q", s)
+	}
+}